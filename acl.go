@@ -150,6 +150,23 @@ func parseACL(data []byte) (ACL, error) {
 	return acl, nil
 }
 
+// Returns the ACL in the binary form used in security descriptors, the opposite of parseACL
+func (a ACL) Bytes() []byte {
+	var entries []byte
+	for _, ace := range a.Entries {
+		entries = append(entries, ace.Bytes()...)
+	}
+	revision := a.Revision
+	if revision == 0 {
+		revision = 4 // ACL_REVISION_DS, needed for object ACEs
+	}
+	header := make([]byte, 8)
+	header[0] = revision
+	binary.LittleEndian.PutUint16(header[2:], uint16(8+len(entries)))
+	binary.LittleEndian.PutUint16(header[4:], uint16(len(a.Entries)))
+	return append(header, entries...)
+}
+
 func (a ACL) String() string {
 	var result string
 	for _, acl := range a.Entries {
@@ -196,6 +213,27 @@ func parseACLentry(data []byte) (ACE, []byte, error) {
 	return ace, data, nil
 }
 
+// Returns the ACE in binary form, the opposite of parseACLentry
+func (a ACE) Bytes() []byte {
+	body := make([]byte, 4)
+	binary.LittleEndian.PutUint32(body, a.Mask)
+	if a.Type == ACETYPE_ACCESS_ALLOWED_OBJECT || a.Type == ACETYPE_ACCESS_DENIED_OBJECT {
+		flags := make([]byte, 4)
+		binary.LittleEndian.PutUint32(flags, a.Flags)
+		body = append(body, flags...)
+		if a.Flags&OBJECT_TYPE_PRESENT != 0 {
+			body = append(body, SwapUUIDEndianess(a.ObjectType).Bytes()...)
+		}
+		if a.Flags&INHERITED_OBJECT_TYPE_PRESENT != 0 {
+			body = append(body, SwapUUIDEndianess(a.InheritedObjectType).Bytes()...)
+		}
+	}
+	body = append(body, a.SID...)
+	header := []byte{a.Type, a.ACEFlags, 0, 0}
+	binary.LittleEndian.PutUint16(header[2:], uint16(4+len(body)))
+	return append(header, body...)
+}
+
 // Is the ACE something that allows this type of GUID?
 func (a ACE) AllowObjectClass(o *Object) bool {
 	// http://www.selfadsi.org/deep-inside/ad-security-descriptors.htm
@@ -254,20 +292,22 @@ func (a ACE) AllowMaskedClass(mask uint32, g uuid.UUID) bool {
 	return false
 }
 
-func (a ACE) String() string {
-	var result string
+func (a ACE) TypeString() string {
 	switch a.Type {
 	case ACETYPE_ACCESS_ALLOWED:
-		result += "Allow"
+		return "Allow"
 	case ACETYPE_ACCESS_ALLOWED_OBJECT:
-		result += "Allow object"
+		return "Allow object"
 	case ACETYPE_ACCESS_DENIED:
-		result += "Deny"
+		return "Deny"
 	case ACETYPE_ACCESS_DENIED_OBJECT:
-		result += "Deny object"
-	default:
-		result += fmt.Sprintf("Unknown %v", a.ACEFlags)
+		return "Deny object"
 	}
+	return fmt.Sprintf("Unknown %v", a.ACEFlags)
+}
+
+func (a ACE) String() string {
+	result := a.TypeString()
 
 	result += " " + a.SID.String()
 
@@ -301,6 +341,12 @@ func (a ACE) String() string {
 			result += " inherited " + a.InheritedObjectType.String() + " (not found)"
 		}
 	}
+	result += " " + strings.Join(a.Rights(), " | ")
+	return result
+}
+
+// Returns the names of the rights in the access mask
+func (a ACE) Rights() []string {
 	var rights []string
 	if a.Mask&RIGHT_GENERIC_READ != 0 {
 		rights = append(rights, "GENERIC_READ")
@@ -363,6 +409,5 @@ func (a ACE) String() string {
 	if a.Mask&RIGHT_DS_CREATE_CHILD != 0 {
 		rights = append(rights, "DS_CREATE_CHILD")
 	}
-	result += " " + strings.Join(rights, " | ")
-	return result
+	return rights
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Active Directory Certificate Services. The enterprise CAs (pKIEnrollmentService) and certificate templates
+// (pKICertificateTemplate) are below CN=Public Key Services in the configuration, which is part of a normal dump.
+// Settings only found on the CA itself, like EDITF_ATTRIBUTESUBJECTALTNAME2 (ESC6) and the CA officer and manager
+// rights (ESC7), are not in the directory, and neither are the enrollment rights the CA has apart from the template
+
+const (
+	CT_FLAG_ENROLLEE_SUPPLIES_SUBJECT = 0x01 // msPKI-Certificate-Name-Flag
+	CT_FLAG_PEND_ALL_REQUESTS         = 0x02 // msPKI-Enrollment-Flag, manager approval
+)
+
+const (
+	adcsAnyPurposeEKU   = "2.5.29.37.0"
+	adcsRequestAgentEKU = "1.3.6.1.4.1.311.20.2.1"
+)
+
+// EKUs that let the certificate be used for logging on to the domain
+var adcsAuthenticationEKUs = []string{
+	"1.3.6.1.5.5.7.3.2",      // Client Authentication
+	"1.3.6.1.5.2.3.4",        // PKINIT Client Authentication
+	"1.3.6.1.4.1.311.20.2.2", // Smart Card Logon
+	adcsAnyPurposeEKU,
+}
+
+func adcsIsCA(o *Object) bool {
+	return StringInSlice("pKIEnrollmentService", o.Attr(ObjectClass))
+}
+
+func adcsIsTemplate(o *Object) bool {
+	return StringInSlice("pKICertificateTemplate", o.Attr(ObjectClass))
+}
+
+// Returns the templates that are published on an enterprise CA, with the CAs publishing them
+func adcsPublishedTemplates() map[*Object][]*Object {
+	var cas, templates []*Object
+	for _, o := range AllObjects.AsArray() {
+		if adcsIsCA(o) {
+			cas = append(cas, o)
+		} else if adcsIsTemplate(o) {
+			templates = append(templates, o)
+		}
+	}
+	results := make(map[*Object][]*Object)
+	for _, template := range templates {
+		for _, ca := range cas {
+			if StringInSlice(template.OneAttr(Name), ca.Attr(CertificateTemplates)) {
+				results[template] = append(results[template], ca)
+			}
+		}
+	}
+	return results
+}
+
+// The EKUs of certificates issued from the template, application policies take precedence from schema version 2
+func adcsEKUs(template *Object) []string {
+	if version, _ := template.AttrInt(MSPKITemplateSchemaVersion); version >= 2 {
+		if policies := template.Attr(MSPKICertApplicationPolicy); len(policies) > 0 {
+			return policies
+		}
+	}
+	return template.Attr(PKIExtendedKeyUsage)
+}
+
+// No EKU means any purpose
+func adcsCanAuthenticate(ekus []string) bool {
+	if len(ekus) == 0 {
+		return true
+	}
+	for _, eku := range adcsAuthenticationEKUs {
+		if StringInSlice(eku, ekus) {
+			return true
+		}
+	}
+	return false
+}
+
+func adcsCanRequestOnBehalf(ekus []string) bool {
+	return len(ekus) == 0 || StringInSlice(adcsAnyPurposeEKU, ekus) || StringInSlice(adcsRequestAgentEKU, ekus)
+}
+
+// Certificates are issued right away, without manager approval or signatures from enrollment agents
+func adcsIssuedDirectly(template *Object) bool {
+	enrollmentflag, _ := template.AttrInt(MSPKIEnrollmentFlag)
+	signatures, _ := template.AttrInt(MSPKIRASignature)
+	return enrollmentflag&CT_FLAG_PEND_ALL_REQUESTS == 0 && signatures <= 0
+}
+
+// Templates an enrollment agent can request certificates from on behalf of others
+func adcsAllowsAgents(template *Object) bool {
+	version, _ := template.AttrInt(MSPKITemplateSchemaVersion)
+	if version <= 1 {
+		return true
+	}
+	signatures, _ := template.AttrInt(MSPKIRASignature)
+	enrollmentflag, _ := template.AttrInt(MSPKIEnrollmentFlag)
+	return signatures == 1 && enrollmentflag&CT_FLAG_PEND_ALL_REQUESTS == 0 &&
+		strings.Contains(strings.Join(template.Attr(MSPKIRAApplicationPolicies), " "), adcsRequestAgentEKU)
+}
+
+// Returns the principals with Enroll or AutoEnroll on the object
+func adcsEnrollers(o *Object) []*Object {
+	var results []*Object
+	sd, err := o.SecurityDescriptor()
+	if err != nil {
+		return results
+	}
+	for _, acl := range sd.DACL.Entries {
+		if acl.AllowObjectClass(o) && (acl.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, ExtendedRightEnroll) || acl.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, ExtendedRightAutoEnroll)) {
+			results = append(results, AllObjects.FindOrAddSID(acl.SID))
+		}
+	}
+	return results
+}
+
+// Returns the owner and the principals that can change the object
+func adcsControllers(o *Object) []*Object {
+	var results []*Object
+	sd, err := o.SecurityDescriptor()
+	if err != nil {
+		return results
+	}
+	if !sd.Owner.IsNull() {
+		results = append(results, AllObjects.FindOrAddSID(sd.Owner))
+	}
+	for _, acl := range sd.DACL.Entries {
+		if !acl.AllowObjectClass(o) {
+			continue
+		}
+		for _, mask := range []uint32{RIGHT_GENERIC_ALL, RIGHT_GENERIC_WRITE, RIGHT_WRITE_DACL, RIGHT_WRITE_OWNER, RIGHT_DS_WRITE_PROPERTY} {
+			if acl.AllowMaskedClass(mask, NullGUID) && (acl.Type != ACETYPE_ACCESS_ALLOWED_OBJECT || acl.Flags&OBJECT_TYPE_PRESENT == 0 || acl.ObjectType == NullGUID) {
+				results = append(results, AllObjects.FindOrAddSID(acl.SID))
+				break
+			}
+		}
+	}
+	return results
+}
+
+func adcsNames(objects []*Object) string {
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.OneAttr(Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// Records the reasons for each source on the connection to the domain, and returns the sources
+func adcsResults(domain *Object, method PwnMethod, reasons map[*Object][]string) []*Object {
+	var results []*Object
+	for source, sourcereasons := range reasons {
+		sort.Strings(sourcereasons)
+		SetEdgeReason(source, domain, method, strings.Join(sourcereasons, "; "))
+		results = append(results, source)
+	}
+	return results
+}
+
+func adcsAddReason(reasons map[*Object][]string, source *Object, reason string) {
+	if !StringInSlice(reason, reasons[source]) {
+		reasons[source] = append(reasons[source], reason)
+	}
+}
+
+// ESC1 (enrollee supplies the subject of an authentication certificate), ESC2 (any purpose certificates work as
+// enrollment agent certificates) and ESC3 (enrollment agent certificates), which all let the enrollee get a
+// certificate for logging on as anyone
+func adcsImpersonators(domain *Object) []*Object {
+	published := adcsPublishedTemplates()
+	reasons := make(map[*Object][]string)
+	for template, cas := range published {
+		if !adcsIssuedDirectly(template) {
+			continue
+		}
+		ekus := adcsEKUs(template)
+		nameflag, _ := template.AttrInt(MSPKICertificateNameFlag)
+		if nameflag&CT_FLAG_ENROLLEE_SUPPLIES_SUBJECT != 0 && adcsCanAuthenticate(ekus) {
+			for _, enroller := range adcsEnrollers(template) {
+				adcsAddReason(reasons, enroller, "ESC1 - template "+template.OneAttr(Name)+" on "+adcsNames(cas)+" lets the enrollee choose the subject of an authentication certificate")
+			}
+		}
+		if !adcsCanRequestOnBehalf(ekus) {
+			continue
+		}
+		esc := "ESC3"
+		if len(ekus) == 0 || StringInSlice(adcsAnyPurposeEKU, ekus) {
+			esc = "ESC2"
+		}
+		agents := make(map[*Object]struct{})
+		for _, agent := range adcsEnrollers(template) {
+			agents[agent] = struct{}{}
+		}
+		for target := range published {
+			if target == template || !adcsAllowsAgents(target) || !adcsCanAuthenticate(adcsEKUs(target)) {
+				continue
+			}
+			for _, enroller := range adcsEnrollers(target) {
+				if _, found := agents[enroller]; !found {
+					continue
+				}
+				adcsAddReason(reasons, enroller, esc+" - enrollment agent certificate from template "+template.OneAttr(Name)+" on "+adcsNames(cas)+
+					" requests authentication certificates from template "+target.OneAttr(Name)+" on behalf of anyone")
+			}
+		}
+	}
+	return adcsResults(domain, PwnADCSESC1, reasons)
+}
+
+// ESC4 (control of a published template, so it can be made vulnerable to ESC1) and ESC5 (control of the CA object,
+// which decides the published templates)
+func adcsPKIControllers(domain *Object) []*Object {
+	reasons := make(map[*Object][]string)
+	for template, cas := range adcsPublishedTemplates() {
+		for _, controller := range adcsControllers(template) {
+			adcsAddReason(reasons, controller, "ESC4 - can change template "+template.OneAttr(Name)+" published on "+adcsNames(cas)+" to let the enrollee choose the subject")
+		}
+	}
+	for _, o := range AllObjects.AsArray() {
+		if adcsIsCA(o) {
+			for _, controller := range adcsControllers(o) {
+				adcsAddReason(reasons, controller, "ESC5 - controls the enterprise CA object "+o.OneAttr(Name)+", which decides the templates it publishes")
+			}
+		}
+	}
+	return adcsResults(domain, PwnADCSESC4, reasons)
+}
+
+// Control of NTAuthCertificates, which decides the CAs domain controllers accept logon certificates from. Adding a CA
+// of their own there gives certificates for anyone, with no template or enterprise CA in the directory involved
+func adcsNTAuthWriters(domain *Object) []*Object {
+	reasons := make(map[*Object][]string)
+	if ntauth, found := AllObjects.Find("CN=NTAuthCertificates,CN=Public Key Services,CN=Services,CN=Configuration," + AllObjects.Base); found {
+		for _, controller := range adcsControllers(ntauth) {
+			adcsAddReason(reasons, controller, "can add a CA of their own to NTAuthCertificates, so certificates it issues are accepted for logon")
+		}
+	}
+	return adcsResults(domain, PwnADCSNTAuth, reasons)
+}
+
+// Returns why the CA likely has HTTP enrollment, from the enrollment web service URLs or IIS in the local machine data
+func adcsWebEnrollment(ca *Object) string {
+	for _, server := range ca.Attr(MSPKIEnrollmentServers) {
+		if index := strings.Index(strings.ToLower(server), "http"); index != -1 {
+			return "enrollment web service at " + server[index:]
+		}
+	}
+	if machine, found := FindLocalMachine(ca.OneAttr(DNSHostName)); found {
+		for _, service := range machine.Services {
+			if strings.EqualFold(service.Name, "W3SVC") && !strings.EqualFold(service.StartMode, "Disabled") {
+				return "IIS runs on " + ca.OneAttr(DNSHostName) + ", so web enrollment is likely"
+			}
+		}
+	}
+	return ""
+}
+
+// Domain controllers, Enterprise Domain Controllers and read only domain controllers
+func adcsIsDCPrincipal(o *Object) bool {
+	sid := o.SID()
+	if sid.IsNull() {
+		return false
+	}
+	if sid.ToString() == "S-1-5-9" {
+		return true
+	}
+	rid := sid.RID()
+	return strings.HasPrefix(sid.ToString(), "S-1-5-21-") && (rid == 516 || rid == 521) || irIsDC(o)
+}
+
+// ESC8 - anyone can coerce a domain controller to authenticate and relay that to HTTP enrollment on a CA, getting a
+// certificate for the domain controller when the CA publishes an authentication template domain controllers enroll in
+func adcsRelayers(domain *Object) []*Object {
+	authenticatedusers, found := AllObjects.Find("CN=Authenticated Users,CN=WellKnown Security Principals,CN=Configuration," + AllObjects.Base)
+	if !found {
+		return nil
+	}
+	catemplates := make(map[*Object][]string)
+	for template, cas := range adcsPublishedTemplates() {
+		if !adcsIssuedDirectly(template) || !adcsCanAuthenticate(adcsEKUs(template)) {
+			continue
+		}
+		for _, enroller := range adcsEnrollers(template) {
+			if adcsIsDCPrincipal(enroller) {
+				for _, ca := range cas {
+					catemplates[ca] = append(catemplates[ca], template.OneAttr(Name))
+				}
+				break
+			}
+		}
+	}
+	var reasons []string
+	for ca, templates := range catemplates {
+		web := adcsWebEnrollment(ca)
+		if web == "" {
+			continue
+		}
+		sort.Strings(templates)
+		reasons = append(reasons, "ESC8 - coerce a domain controller to authenticate and relay it to "+ca.OneAttr(Name)+" ("+web+") for a certificate from "+strings.Join(templates, ", "))
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	return adcsResults(domain, PwnADCSESC8, map[*Object][]string{authenticatedusers: reasons})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Sysinternals AD Explorer snapshots (.dat). A header, the objects, and then the properties (attributes) with their
+// ADSTYPE. Each object is a table of property index and offset, and the values of each property at the offset. This
+// follows what ADExplorerSnapshot.py reads
+
+const adExplorerHeaderSize = 0x43e
+
+// ADSTYPEENUM values the snapshot stores
+const (
+	adsTypeDNString             = 1
+	adsTypeCaseExactString      = 2
+	adsTypeCaseIgnoreString     = 3
+	adsTypePrintableString      = 4
+	adsTypeNumericString        = 5
+	adsTypeBoolean              = 6
+	adsTypeInteger              = 7
+	adsTypeOctetString          = 8
+	adsTypeUTCTime              = 9
+	adsTypeLargeInteger         = 10
+	adsTypeObjectClass          = 12
+	adsTypeNTSecurityDescriptor = 25
+)
+
+type adExplorerProperty struct {
+	name    string
+	adstype uint32
+}
+
+// Dumps the objects in an AD Explorer snapshot that are not in an excluded subtree, redacted like a dump from LDAP
+func DumpADExplorerSnapshot(filename string, exclusions []string, redactor *Redactor, attributes []string, e *msgp.Writer) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := make([]byte, adExplorerHeaderSize)
+	if _, err = io.ReadFull(file, header); err != nil {
+		return fmt.Errorf("Problem reading snapshot header: %v", err)
+	}
+	created := FiletimeToTime(binary.LittleEndian.Uint64(header[14:]))
+	server := utf16String(header[542:1062])
+	if end := strings.IndexByte(server, 0); end >= 0 {
+		server = server[:end]
+	}
+	objectcount := binary.LittleEndian.Uint32(header[1062:])
+	propertiesoffset := int64(binary.LittleEndian.Uint32(header[1074:]))<<32 | int64(binary.LittleEndian.Uint32(header[1070:]))
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if propertiesoffset < adExplorerHeaderSize || propertiesoffset >= info.Size() {
+		return errors.New("Not an AD Explorer snapshot")
+	}
+	log.Info().Msgf("Snapshot of %v taken %v with %v objects", server, created.Format(time.RFC3339), objectcount)
+
+	properties, err := readADExplorerProperties(io.NewSectionReader(file, propertiesoffset, info.Size()-propertiesoffset))
+	if err != nil {
+		return fmt.Errorf("Problem reading snapshot properties: %v", err)
+	}
+
+	unsupported := make(map[string]struct{})
+	w := newFileDumpWriter(e, exclusions, redactor, attributes)
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, adExplorerHeaderSize, propertiesoffset-adExplorerHeaderSize), 1<<20)
+	for i := uint32(0); i < objectcount; i++ {
+		var size uint32
+		if err = binary.Read(reader, binary.LittleEndian, &size); err != nil {
+			return fmt.Errorf("Problem reading object %v: %v", i, err)
+		}
+		if size < 8 || int64(size) > propertiesoffset {
+			return fmt.Errorf("Object %v has a bad size", i)
+		}
+		data := make([]byte, size)
+		binary.LittleEndian.PutUint32(data, size)
+		if _, err = io.ReadFull(reader, data[4:]); err != nil {
+			return fmt.Errorf("Problem reading object %v: %v", i, err)
+		}
+		object, err := parseADExplorerObject(data, properties, unsupported)
+		if err != nil {
+			return fmt.Errorf("Object %v: %v", i, err)
+		}
+		if object.DistinguishedName == "" {
+			continue
+		}
+		if err = w.Write(object); err != nil {
+			return err
+		}
+	}
+	for name := range unsupported {
+		log.Debug().Msgf("Attribute %v has a type that isn't read from snapshots, leaving it out", name)
+	}
+	w.Done(filename)
+	return nil
+}
+
+func readADExplorerProperties(r io.Reader) ([]adExplorerProperty, error) {
+	reader := bufio.NewReader(r)
+	var count uint32
+	if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	properties := make([]adExplorerProperty, 0, count)
+	for i := uint32(0); i < count; i++ {
+		name, err := readADExplorerString(reader)
+		if err != nil {
+			return nil, err
+		}
+		var fields struct {
+			Unknown uint32
+			ADSType uint32
+		}
+		if err = binary.Read(reader, binary.LittleEndian, &fields); err != nil {
+			return nil, err
+		}
+		if _, err = readADExplorerString(reader); err != nil { // DN of the attributeSchema object
+			return nil, err
+		}
+		// schemaIDGUID, attributeSecurityGUID and four unknown bytes
+		if _, err = reader.Discard(36); err != nil {
+			return nil, err
+		}
+		properties = append(properties, adExplorerProperty{name: name, adstype: fields.ADSType})
+	}
+	return properties, nil
+}
+
+// Length in bytes and then a NUL terminated UTF-16 string
+func readADExplorerString(reader *bufio.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+	if length > 65536 {
+		return "", errors.New("String is too long")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", err
+	}
+	s := utf16String(data)
+	if end := strings.IndexByte(s, 0); end >= 0 {
+		s = s[:end]
+	}
+	return s, nil
+}
+
+// The object is its size, the number of properties, the property index and offset of each, and then the values
+func parseADExplorerObject(data []byte, properties []adExplorerProperty, unsupported map[string]struct{}) (*RawObject, error) {
+	count := int(binary.LittleEndian.Uint32(data[4:]))
+	if 8+count*8 > len(data) {
+		return nil, errors.New("Property table is larger than the object")
+	}
+	var object RawObject
+	object.init()
+	for i := 0; i < count; i++ {
+		index := binary.LittleEndian.Uint32(data[8+i*8:])
+		offset := int(int32(binary.LittleEndian.Uint32(data[12+i*8:])))
+		if int(index) >= len(properties) {
+			return nil, fmt.Errorf("Unknown property %v", index)
+		}
+		if offset < 0 || offset+4 > len(data) {
+			return nil, fmt.Errorf("Property %v is outside the object", properties[index].name)
+		}
+		property := properties[index]
+		values, err := adExplorerValues(data[offset:], property.adstype)
+		if err != nil {
+			return nil, fmt.Errorf("Property %v: %v", property.name, err)
+		}
+		if values == nil {
+			unsupported[property.name] = struct{}{}
+			continue
+		}
+		object.Attributes[property.name] = values
+	}
+	if dn := object.Attributes["distinguishedName"]; len(dn) > 0 {
+		object.DistinguishedName = dn[0]
+	}
+	return &object, nil
+}
+
+// Converts the values of a property at the start of data to what LDAP returns, nil for types that aren't read
+func adExplorerValues(data []byte, adstype uint32) ([]string, error) {
+	count := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+
+	fixed := func(size int) ([][]byte, error) {
+		if count > len(data) || count*size > len(data) {
+			return nil, errors.New("Values are outside the object")
+		}
+		result := make([][]byte, count)
+		for i := range result {
+			result[i] = data[i*size : (i+1)*size]
+		}
+		return result, nil
+	}
+
+	var values []string
+	switch adstype {
+	case adsTypeDNString, adsTypeCaseExactString, adsTypeCaseIgnoreString, adsTypePrintableString, adsTypeNumericString, adsTypeObjectClass:
+		// Offsets from the start of the property to NUL terminated UTF-16 strings
+		offsets, err := fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range offsets {
+			start := int(binary.LittleEndian.Uint32(o)) - 4
+			if start < 0 || start > len(data) {
+				return nil, errors.New("String is outside the object")
+			}
+			end := start
+			for end+1 < len(data) && (data[end] != 0 || data[end+1] != 0) {
+				end += 2
+			}
+			values = append(values, utf16String(data[start:end]))
+		}
+	case adsTypeOctetString:
+		// All the lengths, then all the values
+		lengths, err := fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		position := count * 4
+		for _, l := range lengths {
+			length := int(binary.LittleEndian.Uint32(l))
+			if position+length > len(data) {
+				return nil, errors.New("Value is outside the object")
+			}
+			values = append(values, string(data[position:position+length]))
+			position += length
+		}
+	case adsTypeNTSecurityDescriptor:
+		// Length and value for each
+		position := 0
+		for i := 0; i < count; i++ {
+			if position+4 > len(data) {
+				return nil, errors.New("Value is outside the object")
+			}
+			length := int(binary.LittleEndian.Uint32(data[position:]))
+			position += 4
+			if position+length > len(data) {
+				return nil, errors.New("Value is outside the object")
+			}
+			values = append(values, string(data[position:position+length]))
+			position += length
+		}
+	case adsTypeBoolean:
+		booleans, err := fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range booleans {
+			if binary.LittleEndian.Uint32(b) != 0 {
+				values = append(values, "TRUE")
+			} else {
+				values = append(values, "FALSE")
+			}
+		}
+	case adsTypeInteger:
+		integers, err := fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range integers {
+			values = append(values, strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(i))), 10))
+		}
+	case adsTypeLargeInteger:
+		integers, err := fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range integers {
+			values = append(values, strconv.FormatInt(int64(binary.LittleEndian.Uint64(i)), 10))
+		}
+	case adsTypeUTCTime:
+		// SYSTEMTIME: year, month, day of week, day, hour, minute, second, milliseconds
+		times, err := fixed(16)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range times {
+			field := func(i int) int {
+				return int(binary.LittleEndian.Uint16(t[i*2:]))
+			}
+			values = append(values, fmt.Sprintf("%04d%02d%02d%02d%02d%02d.0Z", field(0), field(1), field(3), field(4), field(5), field(6)))
+		}
+	default:
+		return nil, nil
+	}
+	if values == nil {
+		values = []string{}
+	}
+	return values, nil
+}
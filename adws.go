@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	ldap "github.com/lkarlslund/ldap/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Active Directory Web Services (MS-ADDM) is on every domain controller since 2008 R2. It answers the same queries
+// as LDAP, but as SOAP over .NET Message Framing (MC-NMF) protected by NegotiateStream (MS-NNS), which is what the
+// PowerShell AD module and SOAPHound use. Connections to it look different from LDAP collection, and it's reachable
+// where only port 9389 is let through
+const ADWSPort = 9389
+
+// ADWS won't give out more objects than this in one pull
+const adwsMaxElements = 256
+
+// .NET Message Framing record types (MC-NMF section 2.2)
+const (
+	nmfVersion        = 0x00
+	nmfMode           = 0x01
+	nmfVia            = 0x02
+	nmfKnownEncoding  = 0x03
+	nmfSizedEnvelope  = 0x06
+	nmfEnd            = 0x07
+	nmfFault          = 0x08
+	nmfUpgradeRequest = 0x09
+	nmfUpgradeReply   = 0x0a
+	nmfPreambleAck    = 0x0b
+	nmfPreambleEnd    = 0x0c
+
+	nmfModeDuplex         = 0x02
+	nmfEncodingBinarySess = 0x08 // application/soap+msbinsession1
+)
+
+// NegotiateStream handshake message ids (MS-NNS section 2.2.1)
+const (
+	nnsHandshakeDone       = 0x14
+	nnsHandshakeError      = 0x15
+	nnsHandshakeInProgress = 0x16
+)
+
+// NegotiateStream won't take more than this in one data message
+const nnsMaxPayload = 0xfc00
+
+// Syntaxes of attributes with binary values: octet string, NT security descriptor and SID
+var adwsBinarySyntaxes = map[string]struct{}{
+	"2.5.5.10": {},
+	"2.5.5.15": {},
+	"2.5.5.17": {},
+}
+
+// Attributes flagged with this in systemFlags are constructed, and are not asked for when asking for everything
+const attributeIsConstructed = 0x4
+
+type adwsClient struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	to       string // The endpoint URL
+	instance string // Which directory on the server, ldap:389 or the Global Catalog on ldap:3268
+	session  []string
+
+	// From the schema, read the first time something is dumped
+	schemadn   string
+	binary     map[string]struct{}
+	everything []string
+}
+
+func (ad *AD) connectADWS(authmode byte) error {
+	if authmode != 3 && authmode != 4 {
+		return errors.New("ADWS collection needs -authmode ntlm or ntlmpth")
+	}
+	conn, err := dialDirectoryTimeout(net.JoinHostPort(ad.Server, strconv.Itoa(ADWSPort)), 30*time.Second)
+	if err != nil {
+		return err
+	}
+	c, err := newNTLMClient(ad.User, ad.ntlmDomain(), ad.Password, authmode == 4, ad.Server, nil)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c.spn = "host/" + ad.Server
+
+	client := &adwsClient{
+		to:       "net.tcp://" + ad.Server + ":" + strconv.Itoa(ADWSPort) + "/ActiveDirectoryWebServices/Windows/Enumeration",
+		instance: "ldap:389",
+		schemadn: "CN=Schema,CN=Configuration," + ad.RootDn(),
+	}
+	if ad.Port == GlobalCatalogPort || ad.Port == GlobalCatalogTLSPort {
+		client.instance = "ldap:3268"
+	}
+	if client.conn, err = adwsHandshake(conn, client.to, c); err != nil {
+		conn.Close()
+		return err
+	}
+	client.reader = bufio.NewReader(client.conn)
+	ad.adws = client
+	return nil
+}
+
+func nmfFaultError(r *bufio.Reader) error {
+	fault, err := readNMFString(r)
+	if err != nil {
+		return errors.New("ADWS closed the connection with a fault")
+	}
+	return fmt.Errorf("ADWS closed the connection with a fault: %v", fault)
+}
+
+func readNMFString(r *bufio.Reader) (string, error) {
+	length, err := readMultiByteInt31(r)
+	if err != nil {
+		return "", err
+	}
+	buffer := make([]byte, length)
+	_, err = io.ReadFull(r, buffer)
+	return string(buffer), err
+}
+
+func nnsHandshakeMessage(id byte, payload []byte) []byte {
+	return append([]byte{id, 1, 0, byte(len(payload) >> 8), byte(len(payload))}, payload...)
+}
+
+func readNNSHandshake(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if header[0] == nnsHandshakeError {
+		return 0, nil, fmt.Errorf("ADWS rejected the authentication (error %x)", payload)
+	}
+	return header[0], payload, nil
+}
+
+// Sends the preamble for the endpoint, authenticates with NTLM in NegotiateStream and returns the protected
+// connection that SOAP messages go over
+func adwsHandshake(conn net.Conn, via string, c *ntlmClient) (net.Conn, error) {
+	preamble := []byte{nmfVersion, 1, 0, nmfMode, nmfModeDuplex, nmfVia}
+	preamble = appendNBFXString(preamble, via)
+	preamble = append(preamble, nmfKnownEncoding, nmfEncodingBinarySess, nmfUpgradeRequest)
+	preamble = appendNBFXString(preamble, "application/negotiate")
+	if _, err := conn.Write(preamble); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	reply, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch reply {
+	case nmfUpgradeReply:
+	case nmfFault:
+		return nil, nmfFaultError(reader)
+	default:
+		return nil, fmt.Errorf("Unexpected reply 0x%02x to the ADWS preamble", reply)
+	}
+
+	if _, err = conn.Write(nnsHandshakeMessage(nnsHandshakeInProgress, c.Negotiate())); err != nil {
+		return nil, err
+	}
+	_, challenge, err := readNNSHandshake(reader)
+	if err != nil {
+		return nil, err
+	}
+	authenticate, err := c.Authenticate(challenge)
+	if err != nil {
+		return nil, err
+	}
+	// Our side is done after this one, the server tells if it agrees
+	if _, err = conn.Write(nnsHandshakeMessage(nnsHandshakeDone, authenticate)); err != nil {
+		return nil, err
+	}
+	if id, _, err := readNNSHandshake(reader); err != nil {
+		return nil, err
+	} else if id != nnsHandshakeDone {
+		return nil, fmt.Errorf("Unexpected NegotiateStream message 0x%02x after authenticating to ADWS", id)
+	}
+
+	protected := &securityLayerConn{
+		Conn:         &bufferedConn{Conn: conn, reader: reader},
+		wrap:         c.session.Wrap,
+		unwrap:       c.session.Unwrap,
+		littleendian: true,
+		maxwrite:     nnsMaxPayload,
+	}
+	if _, err = protected.Write([]byte{nmfPreambleEnd}); err != nil {
+		return nil, err
+	}
+	var ack [1]byte
+	if _, err = io.ReadFull(protected, ack[:]); err != nil {
+		return nil, err
+	}
+	if ack[0] != nmfPreambleAck {
+		return nil, fmt.Errorf("Unexpected reply 0x%02x to the end of the ADWS preamble", ack[0])
+	}
+	return protected, nil
+}
+
+// Connection that reads through a buffer first, for when a bufio.Reader has been used on it
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func xmlEscape(s string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(s))
+	return buffer.String()
+}
+
+// Sends a SOAP request with the body and returns the first element in the body of the response, which must have
+// the local name in expect, or else it's a fault
+func (a *adwsClient) call(action, body, expect string) (*xmlNode, error) {
+	messageid, _ := uuid.NewV4()
+	envelope := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing"` +
+		` xmlns:ad="http://schemas.microsoft.com/2008/1/ActiveDirectory" xmlns:addata="http://schemas.microsoft.com/2008/1/ActiveDirectory/Data"` +
+		` xmlns:wsen="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<s:Header>` +
+		`<a:Action s:mustUnderstand="1">` + action + `</a:Action>` +
+		`<ad:instance>` + a.instance + `</ad:instance>` +
+		`<a:MessageID>urn:uuid:` + messageid.String() + `</a:MessageID>` +
+		`<a:ReplyTo><a:Address>http://www.w3.org/2005/08/addressing/anonymous</a:Address></a:ReplyTo>` +
+		`<a:To s:mustUnderstand="1">` + xmlEscape(a.to) + `</a:To>` +
+		`</s:Header>` +
+		`<s:Body>` + body + `</s:Body>` +
+		`</s:Envelope>`
+	document, err := encodeNBFX(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	// No strings of our own in the session dictionary
+	payload := append(appendMultiByteInt31(nil, 0), document...)
+	message := append(appendMultiByteInt31([]byte{nmfSizedEnvelope}, len(payload)), payload...)
+	if _, err = a.conn.Write(message); err != nil {
+		return nil, err
+	}
+
+	record, err := a.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch record {
+	case nmfSizedEnvelope:
+	case nmfFault:
+		return nil, nmfFaultError(a.reader)
+	case nmfEnd:
+		return nil, errors.New("ADWS ended the session")
+	default:
+		return nil, fmt.Errorf("Unexpected ADWS record type 0x%02x", record)
+	}
+	size, err := readMultiByteInt31(a.reader)
+	if err != nil {
+		return nil, err
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(a.reader, payload); err != nil {
+		return nil, err
+	}
+
+	// Strings the server adds to the session dictionary come first
+	r := bytes.NewReader(payload)
+	dictionarysize, err := readMultiByteInt31(r)
+	if err != nil {
+		return nil, err
+	}
+	start := len(payload) - r.Len()
+	if start+dictionarysize > len(payload) {
+		return nil, errors.New("ADWS session dictionary is larger than the message")
+	}
+	dictionary := bytes.NewReader(payload[start : start+dictionarysize])
+	for dictionary.Len() > 0 {
+		length, err := readMultiByteInt31(dictionary)
+		if err != nil || length > dictionary.Len() {
+			return nil, errors.New("Bad string in ADWS session dictionary")
+		}
+		s := make([]byte, length)
+		dictionary.Read(s)
+		a.session = append(a.session, string(s))
+	}
+
+	response, err := decodeNBFX(payload[start+dictionarysize:], a.session)
+	if err != nil {
+		return nil, fmt.Errorf("Problem decoding ADWS response: %v", err)
+	}
+	if len(response.Children) == 0 {
+		return nil, errors.New("ADWS response without a body")
+	}
+	responsebody := response.Children[len(response.Children)-1]
+	if len(responsebody.Children) == 0 {
+		return nil, errors.New("ADWS response with an empty body")
+	}
+	result := responsebody.Children[0]
+	if result.LocalName() != expect {
+		return nil, fmt.Errorf("ADWS returned a fault: %v", result.AllText())
+	}
+	return result, nil
+}
+
+var adwsScopes = map[int]string{
+	ldap.ScopeBaseObject:   "base",
+	ldap.ScopeSingleLevel:  "onelevel",
+	ldap.ScopeWholeSubtree: "subtree",
+}
+
+// Enumerates the objects query finds in the scope, with the attributes or everything if there are none. SD flags
+// other than 0 asks for those parts of the security descriptor, like the SD flags control in LDAP
+func (a *adwsClient) Enumerate(searchbase string, scope int, query string, attributes []string, sdflags int64, pagesize int, progress func()) ([]*RawObject, error) {
+	if a.binary == nil {
+		if err := a.loadSchema(); err != nil {
+			return nil, fmt.Errorf("Problem reading the schema over ADWS: %v", err)
+		}
+	}
+
+	selected := attributes
+	if len(selected) == 0 {
+		selected = a.everything
+	}
+	var selection strings.Builder
+	selection.WriteString(`<ad:Selection Dialect="http://schemas.microsoft.com/2008/1/ActiveDirectory/Dialect/XPath-Level-1">`)
+	selection.WriteString(`<ad:SelectionProperty>addata:distinguishedName</ad:SelectionProperty>`)
+	for _, attribute := range selected {
+		if strings.EqualFold(attribute, "distinguishedName") {
+			continue
+		}
+		selection.WriteString(`<ad:SelectionProperty>addata:` + xmlEscape(attribute) + `</ad:SelectionProperty>`)
+	}
+	selection.WriteString(`</ad:Selection>`)
+	var controls string
+	if sdflags != 0 {
+		// BER of a sequence with the flags as an integer, they always fit in a byte
+		value := []byte{0x30, 0x03, 0x02, 0x01, byte(sdflags)}
+		controls = `<ad:controls><ad:control type="1.2.840.113556.1.4.801" criticality="true">` +
+			`<ad:controlValue xsi:type="xsd:base64Binary">` + base64.StdEncoding.EncodeToString(value) + `</ad:controlValue>` +
+			`</ad:control></ad:controls>`
+	}
+
+	response, err := a.call("http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate",
+		`<wsen:Enumerate><wsen:Filter Dialect="http://schemas.microsoft.com/2008/1/ActiveDirectory/Dialect/LdapQuery">`+
+			`<adlq:LdapQuery xmlns:adlq="http://schemas.microsoft.com/2008/1/ActiveDirectory/Dialect/LdapQuery">`+
+			`<adlq:Filter>`+xmlEscape(query)+`</adlq:Filter>`+
+			`<adlq:BaseObject>`+xmlEscape(searchbase)+`</adlq:BaseObject>`+
+			`<adlq:Scope>`+adwsScopes[scope]+`</adlq:Scope>`+
+			`</adlq:LdapQuery></wsen:Filter>`+selection.String()+controls+`</wsen:Enumerate>`,
+		"EnumerateResponse")
+	if err != nil {
+		return nil, err
+	}
+
+	if pagesize <= 0 || pagesize > adwsMaxElements {
+		pagesize = adwsMaxElements
+	}
+	requested := make(map[string]struct{})
+	for _, attribute := range attributes {
+		requested[strings.ToLower(attribute)] = struct{}{}
+	}
+	context := response.Child("EnumerationContext")
+	if context == nil {
+		return nil, errors.New("No enumeration context in ADWS response")
+	}
+	var objects []*RawObject
+	for {
+		response, err = a.call("http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull",
+			`<wsen:Pull><wsen:EnumerationContext>`+xmlEscape(context.Text)+`</wsen:EnumerationContext>`+
+				`<wsen:MaxElements>`+strconv.Itoa(pagesize)+`</wsen:MaxElements></wsen:Pull>`,
+			"PullResponse")
+		if err != nil {
+			return objects, err
+		}
+		if items := response.Child("Items"); items != nil {
+			for _, item := range items.Children {
+				object := a.rawObject(item)
+				if _, found := requested["distinguishedname"]; !found && len(attributes) > 0 {
+					delete(object.Attributes, "distinguishedName")
+				}
+				objects = append(objects, object)
+				if progress != nil {
+					progress()
+				}
+			}
+		}
+		if response.Child("EndOfSequence") != nil {
+			return objects, nil
+		}
+		// The server can hand out a new context for the next pull
+		if next := response.Child("EnumerationContext"); next != nil {
+			context = next
+		}
+	}
+}
+
+// Reads the names and syntaxes of all attributes, to know which ones are binary and what to ask for to get everything
+func (a *adwsClient) loadSchema() error {
+	a.binary = make(map[string]struct{})
+	attributes, err := a.Enumerate(a.schemadn, ldap.ScopeSingleLevel, "(objectClass=attributeSchema)",
+		[]string{"lDAPDisplayName", "attributeSyntax", "systemFlags"}, 0, adwsMaxElements, nil)
+	if err != nil {
+		a.binary = nil
+		return err
+	}
+	for _, attribute := range attributes {
+		name := attribute.Attributes["lDAPDisplayName"]
+		if len(name) == 0 {
+			continue
+		}
+		if syntax := attribute.Attributes["attributeSyntax"]; len(syntax) > 0 {
+			if _, found := adwsBinarySyntaxes[syntax[0]]; found {
+				a.binary[strings.ToLower(name[0])] = struct{}{}
+			}
+		}
+		if flags := attribute.Attributes["systemFlags"]; len(flags) > 0 {
+			if value, _ := strconv.Atoi(flags[0]); value&attributeIsConstructed != 0 {
+				continue
+			}
+		}
+		a.everything = append(a.everything, name[0])
+	}
+	log.Debug().Msgf("Schema read over ADWS has %v attributes, %v of them binary", len(attributes), len(a.binary))
+	return nil
+}
+
+// Converts an object from an enumeration to what an LDAP dump would have given. Each attribute is an element with
+// a value element for each value, and binary values are base64 unless they come as bytes
+func (a *adwsClient) rawObject(item *xmlNode) *RawObject {
+	object := &RawObject{}
+	object.init()
+	for _, attribute := range item.Children {
+		name := attribute.LocalName()
+		switch name {
+		case "objectReferenceProperty", "container-hierarchy-parent", "relativeDistinguishedName":
+			// Added by ADWS, not from the directory
+			continue
+		}
+		_, binary := a.binary[strings.ToLower(name)]
+		values := make([]string, 0, len(attribute.Children))
+		for _, value := range attribute.Children {
+			if binary && !value.Binary {
+				if raw, err := value.Bytes(); err == nil {
+					values = append(values, string(raw))
+					continue
+				}
+			}
+			values = append(values, value.Text)
+		}
+		if name == "distinguishedName" && len(values) > 0 {
+			object.DistinguishedName = values[0]
+		}
+		object.Attributes[name] = values
+	}
+	return object
+}
+
+func (a *adwsClient) Close() error {
+	a.conn.Write([]byte{nmfEnd})
+	return a.conn.Close()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/pierrec/lz4"
+	"github.com/rs/zerolog/log"
+)
+
+// Big values that are rarely read after loading are kept compressed, and uncompressed when asked for. The raw security
+// descriptor is only needed for showing the object, as the parsed one is cached, and certificates are only looked at
+// in the details. Security descriptors are mostly GUIDs and SIDs repeated over and over, so they compress well
+var compressedAttributes = make(map[Attribute]struct{})
+
+func init() {
+	// Here and not in the map, so the names don't get attribute numbers before the imported ones in attributes.go
+	for _, name := range []string{"nTSecurityDescriptor", "userCertificate", "cACertificate", "crossCertificatePair",
+		"certificateRevocationList", "authorityRevocationList", "deltaRevocationList", "mSMQSignCertificates",
+		"msDS-KeyCredentialLink"} {
+		compressedAttributes[NewAttribute(name)] = struct{}{}
+	}
+}
+
+// Values of an attribute smaller than this in all are not worth compressing
+const compressMinimumSize = 256
+
+// Turned off with -nocompress, for CPU over memory
+var CompressAttributes = true
+
+// Size of the compressed values, and what they were before
+var compressedBytes, uncompressedBytes int
+
+// Packs the length and data of each value and compresses that, after the number of values and the packed size. Returns
+// nil if it doesn't get smaller
+func compressValues(values []string) []byte {
+	var size int
+	for _, value := range values {
+		size += len(value)
+	}
+	if size < compressMinimumSize {
+		return nil
+	}
+	packed := make([]byte, size+binary.MaxVarintLen64*len(values))
+	var n int
+	for _, value := range values {
+		n += binary.PutUvarint(packed[n:], uint64(len(value)))
+		n += copy(packed[n:], value)
+	}
+	packed = packed[:n]
+
+	compressed := make([]byte, 2*binary.MaxVarintLen64+lz4.CompressBlockBound(len(packed)))
+	header := binary.PutUvarint(compressed, uint64(len(values)))
+	header += binary.PutUvarint(compressed[header:], uint64(len(packed)))
+	n, err := lz4.CompressBlock(packed, compressed[header:], nil)
+	if err != nil || n == 0 || header+n >= size {
+		// Incompressible
+		return nil
+	}
+	// Don't keep the slack of the worst case buffer
+	return append([]byte(nil), compressed[:header+n]...)
+}
+
+// The number of values, without uncompressing them
+func compressedValueCount(compressed []byte) int {
+	count, _ := binary.Uvarint(compressed)
+	return int(count)
+}
+
+func uncompressValues(compressed []byte) []string {
+	count, header := binary.Uvarint(compressed)
+	packedsize, n := binary.Uvarint(compressed[header:])
+	header += n
+	packed := make([]byte, packedsize)
+	if _, err := lz4.UncompressBlock(compressed[header:], packed); err != nil {
+		log.Error().Msgf("Problem uncompressing attribute values: %v", err)
+		return nil
+	}
+	values := make([]string, 0, count)
+	for i := uint64(0); i < count; i++ {
+		length, n := binary.Uvarint(packed)
+		values = append(values, string(packed[n:n+int(length)]))
+		packed = packed[n+int(length):]
+	}
+	return values
+}
+
+// Keeps the values of the attribute compressed on the object if it's one of the compressed ones and that saves
+// memory. Only used when loading, before others can see the object
+func (o *Object) compressValues(attribute Attribute, values []string) bool {
+	if !CompressAttributes {
+		return false
+	}
+	if _, found := compressedAttributes[attribute]; !found {
+		return false
+	}
+	compressed := compressValues(values)
+	if compressed == nil {
+		return false
+	}
+	if o.compressed == nil {
+		o.compressed = make(map[Attribute][]byte)
+	}
+	o.compressed[attribute] = compressed
+	compressedBytes += len(compressed)
+	for _, value := range values {
+		uncompressedBytes += len(value)
+	}
+	return true
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/gofrs/uuid"
+)
+
+// Turns a raw binary attribute value into something readable. The first return value is
+// a short string rendering, the second one is a structure suitable for JSON output
+type AttributeDecoder func(value string) (string, interface{}, error)
+
+// Attributes that are stored as binary blobs, and how to make sense of them
+var AttributeDecoders = map[Attribute]AttributeDecoder{
+	ObjectSid:            decodeSID,
+	SIDHistory:           decodeSID,
+	ObjectGUID:           decodeGUID,
+	NTSecurityDescriptor: decodeSecurityDescriptor,
+	LogonHours:           decodeLogonHours,
+	UserParameters:       decodeUserParameters,
+}
+
+// Returns the decoder for the attribute, from the list above or from the syntax in the schema
+func DecoderFor(attr Attribute) (AttributeDecoder, bool) {
+	if decoder, found := AttributeDecoders[attr]; found {
+		return decoder, true
+	}
+	switch SyntaxOf(attr) {
+	case SyntaxSID:
+		return decodeSID, true
+	case SyntaxGUID:
+		return decodeGUID, true
+	case SyntaxSecurityDescriptor:
+		return decodeSecurityDescriptor, true
+	}
+	return nil, false
+}
+
+func decodeSID(value string) (string, interface{}, error) {
+	sid, _, err := ParseSID([]byte(value))
+	if err != nil {
+		return "", nil, err
+	}
+	return sid.String(), sid.ToString(), nil
+}
+
+func decodeGUID(value string) (string, interface{}, error) {
+	u, err := uuid.FromBytes([]byte(value))
+	if err != nil {
+		return "", nil, err
+	}
+	// AD stores the first three parts little endian
+	guid := SwapUUIDEndianess(u).String()
+	return guid, guid, nil
+}
+
+type decodedACE struct {
+	Type                string   `json:"type"`
+	SID                 string   `json:"sid"`
+	Principal           string   `json:"principal,omitempty"`
+	Rights              []string `json:"rights"`
+	ObjectType          string   `json:"objecttype,omitempty"`
+	InheritedObjectType string   `json:"inheritedobjecttype,omitempty"`
+	Inherited           bool     `json:"inherited,omitempty"`
+}
+
+type decodedSecurityDescriptor struct {
+	Control []string     `json:"control"`
+	Owner   string       `json:"owner,omitempty"`
+	Group   string       `json:"group,omitempty"`
+	DACL    []decodedACE `json:"dacl,omitempty"`
+	SACL    []decodedACE `json:"sacl,omitempty"`
+}
+
+// Returns the GUID with the name of the right, class or attribute it refers to if we know it
+func describeGUID(u uuid.UUID) string {
+	if o, found := AllRights[u]; found {
+		return fmt.Sprintf("%v (right %v)", u, o.OneAttr(Name))
+	} else if o, found := AllSchemaClasses[u]; found {
+		return fmt.Sprintf("%v (class %v)", u, o.OneAttr(Name))
+	} else if o, found := AllSchemaAttributes[u]; found {
+		return fmt.Sprintf("%v (attribute %v)", u, o.OneAttr(Name))
+	}
+	return u.String()
+}
+
+func decodeACL(acl ACL) []decodedACE {
+	var result []decodedACE
+	for _, ace := range acl.Entries {
+		dace := decodedACE{
+			Type:      ace.TypeString(),
+			SID:       ace.SID.ToString(),
+			Rights:    ace.Rights(),
+			Inherited: ace.ACEFlags&ACEFLAG_INHERITED_ACE != 0,
+		}
+		if o, found := AllObjects.FindSID(ace.SID); found {
+			dace.Principal = o.DN()
+		}
+		if ace.Flags&OBJECT_TYPE_PRESENT != 0 {
+			dace.ObjectType = describeGUID(ace.ObjectType)
+		}
+		if ace.Flags&INHERITED_OBJECT_TYPE_PRESENT != 0 {
+			dace.InheritedObjectType = describeGUID(ace.InheritedObjectType)
+		}
+		result = append(result, dace)
+	}
+	return result
+}
+
+func decodeSecurityDescriptor(value string) (string, interface{}, error) {
+	sd, err := ParseSecurityDescriptor([]byte(value))
+	if err != nil {
+		return "", nil, err
+	}
+	result := decodedSecurityDescriptor{
+		Control: sd.ControlFlags(),
+	}
+	if !sd.Owner.IsNull() {
+		result.Owner = sd.Owner.String()
+	}
+	if !sd.Group.IsNull() {
+		result.Group = sd.Group.String()
+	}
+	if sd.Control&CONTROLFLAG_DACL_PRESENT != 0 {
+		result.DACL = decodeACL(sd.DACL)
+	}
+	if sd.Control&CONTROLFLAG_SACL_PRESENT != 0 {
+		result.SACL = decodeACL(sd.SACL)
+	}
+	return fmt.Sprintf("Owner %v, %v DACL entries", result.Owner, len(result.DACL)), result, nil
+}
+
+type decodedLogonHours struct {
+	Day   string `json:"day"`
+	Hours []int  `json:"hours"` // UTC hours where logon is permitted
+}
+
+// logonHours is 21 bytes with one bit per hour of the week, starting Sunday 00:00 UTC
+func decodeLogonHours(value string) (string, interface{}, error) {
+	if len(value) != 21 {
+		return "", nil, fmt.Errorf("logonHours should be 21 bytes, not %v", len(value))
+	}
+	days := []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+	result := make([]decodedLogonHours, len(days))
+	var permitted int
+	var rendered []string
+	for day := range days {
+		result[day].Day = days[day]
+		result[day].Hours = []int{}
+		for hour := 0; hour < 24; hour++ {
+			bit := day*24 + hour
+			if value[bit/8]&(1<<(bit%8)) != 0 {
+				result[day].Hours = append(result[day].Hours, hour)
+			}
+		}
+		permitted += len(result[day].Hours)
+		if len(result[day].Hours) > 0 && len(result[day].Hours) < 24 {
+			rendered = append(rendered, fmt.Sprintf("%v %v", days[day][:3], hourRanges(result[day].Hours)))
+		}
+	}
+	switch permitted {
+	case 0:
+		return "No logon permitted", result, nil
+	case 7 * 24:
+		return "All hours permitted", result, nil
+	}
+	return "Restricted (UTC): " + strings.Join(rendered, ", "), result, nil
+}
+
+// Renders 8,9,10,11,14 as 08-12,14-15
+func hourRanges(hours []int) string {
+	var ranges []string
+	for i := 0; i < len(hours); i++ {
+		start := hours[i]
+		for i+1 < len(hours) && hours[i+1] == hours[i]+1 {
+			i++
+		}
+		ranges = append(ranges, fmt.Sprintf("%02d-%02d", start, hours[i]+1))
+	}
+	return strings.Join(ranges, ",")
+}
+
+// Terminal Services properties that hold a number, the rest are strings
+var tsNumericProperties = []string{
+	"CtxCfgPresent",
+	"CtxCfgFlags1",
+	"CtxCallBack",
+	"CtxKeyboardLayout",
+	"CtxNWLogonServer",
+	"CtxMaxConnectionTime",
+	"CtxMaxDisconnectionTime",
+	"CtxMaxIdleTime",
+	"CtxShadow",
+}
+
+// userParameters holds the Terminal Services settings in a binary structure, that LDAP returns as if it was a string
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-tsts/3b2c4e68-8b31-4e44-9ad9-d2b5e88b2cb2
+func parseTerminalServicesProperties(value string) (map[string]interface{}, error) {
+	// Convert back into the UTF-16LE bytes the DC has stored
+	var data []byte
+	for _, r := range utf16.Encode([]rune(value)) {
+		data = append(data, byte(r), byte(r>>8))
+	}
+
+	// 96 bytes of reserved data, then a 'P' signature and the property count
+	if len(data) < 100 || binary.LittleEndian.Uint16(data[96:]) != 'P' {
+		return nil, errors.New("No Terminal Services properties found")
+	}
+	count := int(binary.LittleEndian.Uint16(data[98:]))
+	data = data[100:]
+
+	result := make(map[string]interface{})
+	for i := 0; i < count; i++ {
+		if len(data) < 6 {
+			return nil, errors.New("Truncated Terminal Services property")
+		}
+		namelength := int(binary.LittleEndian.Uint16(data[0:]))
+		valuelength := int(binary.LittleEndian.Uint16(data[2:]))
+		data = data[6:]
+		if len(data) < namelength+valuelength {
+			return nil, errors.New("Truncated Terminal Services property")
+		}
+		nameunits := make([]uint16, namelength/2)
+		for j := range nameunits {
+			nameunits[j] = binary.LittleEndian.Uint16(data[j*2:])
+		}
+		name := string(utf16.Decode(nameunits))
+
+		// Values are hex encoded as ASCII
+		propvalue, err := hex.DecodeString(string(data[namelength : namelength+valuelength]))
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode Terminal Services property %v: %v", name, err)
+		}
+		data = data[namelength+valuelength:]
+
+		str := strings.TrimRight(string(propvalue), "\x00")
+		printable := strings.IndexFunc(str, func(r rune) bool { return !unicode.IsPrint(r) }) == -1
+		switch {
+		case len(propvalue) == 4 && StringInSlice(name, tsNumericProperties):
+			result[name] = binary.LittleEndian.Uint32(propvalue)
+		case printable:
+			result[name] = str
+		default:
+			result[name] = hex.EncodeToString(propvalue)
+		}
+	}
+	return result, nil
+}
+
+func decodeUserParameters(value string) (string, interface{}, error) {
+	properties, err := parseTerminalServicesProperties(value)
+	if err != nil {
+		return "", nil, err
+	}
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "Terminal Services settings: " + strings.Join(names, ", "), properties, nil
+}
+
+// Logon to Remote Desktop Session Host is denied if this bit is set in CtxCfgFlags1
+const TS_LOGON_DISABLED = 0x00010000
+
+// Newer schemas expose the Terminal Services settings as msTS* attributes, backed by userParameters. Fill
+// them in from the blob if the DC didn't, so they can be queried the same way regardless of DC version
+func (o *Object) expandUserParameters() error {
+	userparameters := o.OneAttr(UserParameters)
+	if userparameters == "" {
+		return nil
+	}
+	properties, err := parseTerminalServicesProperties(userparameters)
+	if err != nil {
+		return err
+	}
+	for property, attribute := range map[string]Attribute{
+		"CtxWFProfilePath":  MSTSProfilePath,
+		"CtxWFHomeDir":      MSTSHomeDirectory,
+		"CtxWFHomeDirDrive": MSTSHomeDrive,
+	} {
+		if value, ok := properties[property].(string); ok && o.OneAttr(attribute) == "" {
+			o.SetAttr(attribute, value)
+		}
+	}
+	if flags, ok := properties["CtxCfgFlags1"].(uint32); ok && o.OneAttr(MSTSAllowLogon) == "" {
+		if flags&TS_LOGON_DISABLED != 0 {
+			o.SetAttr(MSTSAllowLogon, "FALSE")
+		} else {
+			o.SetAttr(MSTSAllowLogon, "TRUE")
+		}
+	}
+	return nil
+}
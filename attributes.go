@@ -11,6 +11,8 @@ var attributenames = make(map[string]Attribute)
 var attributenums []string
 var attributepopularity []int
 var attributesizes []int
+var attributeobjects []int  // objects loaded from the dump with this attribute, imported or not
+var attributeredacted []int // of those, how many were redacted at dump time
 
 var (
 	NonExistingAttribute        = NewAttribute("*NON EXISTING ATTRIBUTE*")
@@ -54,6 +56,10 @@ var (
 	Name                        = NewAttribute("name")
 	DisplayName                 = NewAttribute("displayName")
 	LDAPDisplayName             = NewAttribute("lDAPDisplayName") // Attribute-Schema
+	AttributeSyntax             = NewAttribute("attributeSyntax") // Attribute-Schema
+	RangeLower                  = NewAttribute("rangeLower")      // Attribute-Schema
+	RangeUpper                  = NewAttribute("rangeUpper")      // Attribute-Schema
+	LinkID                      = NewAttribute("linkID")          // Attribute-Schema
 	Description                 = NewAttribute("description")
 	SAMAccountName              = NewAttribute("sAMAccountName")
 	ObjectSid                   = NewAttribute("objectSid")
@@ -62,13 +68,51 @@ var (
 	WhenCreated                 = NewAttribute("whenCreated")
 	WhenChanged                 = NewAttribute("whenChanged")
 	SIDHistory                  = NewAttribute("sIDHistory")
+	UserParameters              = NewAttribute("userParameters")
+	UserWorkstations            = NewAttribute("userWorkstations")
+	ProfilePath                 = NewAttribute("profilePath")
+	HomeDirectory               = NewAttribute("homeDirectory")
+	DNSHostName                 = NewAttribute("dNSHostName")
+	MSTSProfilePath             = NewAttribute("msTSProfilePath")
+	MSTSHomeDirectory           = NewAttribute("msTSHomeDirectory")
+	MSTSHomeDrive               = NewAttribute("msTSHomeDrive")
+	MSTSAllowLogon              = NewAttribute("msTSAllowLogon")
 	LastLogon                   = NewAttribute("lastLogon")
 	LastLogonTimestamp          = NewAttribute("lastLogonTimestamp")
 	MSDSGroupMSAMembership      = NewAttribute("msDS-GroupMSAMembership")
 	MSDSHostServiceAccount      = NewAttribute("msDS-HostServiceAccount")
 	MSDSHostServiceAccountBL    = NewAttribute("msDS-HostServiceAccountBL")
-	MSmcsAdmPwdExpirationTime   = NewAttribute("ms-mcs-AdmPwdExpirationTime") // LAPS password timeout
+	MSmcsAdmPwdExpirationTime   = NewAttribute("ms-mcs-AdmPwdExpirationTime")   // LAPS password timeout
+	LAPSPasswordExpirationTime  = NewAttribute("msLAPS-PasswordExpirationTime") // Windows LAPS password timeout
+	LAPSPassword                = NewAttribute("msLAPS-Password")
+	LAPSEncryptedPassword       = NewAttribute("msLAPS-EncryptedPassword")
+	LAPSEncryptedDSRMPassword   = NewAttribute("msLAPS-EncryptedDSRMPassword")
 	SecurityIdentifier          = NewAttribute("securityIdentifier")
+	UserPrincipalName           = NewAttribute("userPrincipalName")
+	Mail                        = NewAttribute("mail")
+	EmployeeID                  = NewAttribute("employeeID")
+	ServerReference             = NewAttribute("serverReference")
+	MSDSSupportedEncTypes       = NewAttribute("msDS-SupportedEncryptionTypes")
+	MSDSConsistencyGuid         = NewAttribute("mS-DS-ConsistencyGuid")
+	CertificateTemplates        = NewAttribute("certificateTemplates")
+	PKIExtendedKeyUsage         = NewAttribute("pKIExtendedKeyUsage")
+	MSPKICertificateNameFlag    = NewAttribute("msPKI-Certificate-Name-Flag")
+	MSPKIEnrollmentFlag         = NewAttribute("msPKI-Enrollment-Flag")
+	MSPKIRASignature            = NewAttribute("msPKI-RA-Signature")
+	MSPKIRAApplicationPolicies  = NewAttribute("msPKI-RA-Application-Policies")
+	MSPKICertApplicationPolicy  = NewAttribute("msPKI-Certificate-Application-Policy")
+	MSPKITemplateSchemaVersion  = NewAttribute("msPKI-Template-Schema-Version")
+	MSPKIEnrollmentServers      = NewAttribute("msPKI-Enrollment-Servers")
+	MSDSAllowedToActOnBehalf    = NewAttribute("msDS-AllowedToActOnBehalfOfOtherIdentity")
+	MSDSKeyCredentialLink       = NewAttribute("msDS-KeyCredentialLink")
+	MSDSDeviceID                = NewAttribute("msDS-DeviceID")
+	MSSMSSiteCode               = NewAttribute("mSSMSSiteCode")
+	MSSMSMPName                 = NewAttribute("mSSMSMPName")
+	MSDSAllowedToDelegateTo     = NewAttribute("msDS-AllowedToDelegateTo")
+	MSDSMachineAccountQuota     = NewAttribute("ms-DS-MachineAccountQuota")
+	MSExchMailboxSD             = NewAttribute("msExchMailboxSecurityDescriptor")
+	MSExchDelegateListLink      = NewAttribute("msExchDelegateListLink")
+	GPCFileSysPath              = NewAttribute("gPCFileSysPath")
 	TrustDirection              = NewAttribute("trustDirection")
 	TrustAttributes             = NewAttribute("trustAttributes")
 	TrustPartner                = NewAttribute("trustPartner")
@@ -80,6 +124,8 @@ var (
 	MetaUnconstrainedDelegation = NewAttribute("_unconstraineddelegation")
 	MetaConstrainedDelegation   = NewAttribute("_constraineddelegation")
 	MetaHasSPN                  = NewAttribute("_hasspn")
+	MetaKerberoastable          = NewAttribute("_kerberoastable")
+	MetaASREPRoastable          = NewAttribute("_asreproastable")
 	MetaPasswordAge             = NewAttribute("_passwordage")
 	MetaLastLoginAge            = NewAttribute("_lastloginage")
 	MetaAccountDisabled         = NewAttribute("_accountdisabled")
@@ -92,6 +138,41 @@ var (
 	MetaServer                  = NewAttribute("_server")
 	MetaType                    = NewAttribute("_type")
 	MetaLAPSInstalled           = NewAttribute("_haslaps")
+	MetaLogonHoursRestricted    = NewAttribute("_logonhoursrestricted")
+	MetaWorkstationRestricted   = NewAttribute("_workstationrestricted")
+	MetaGPPDriveMaps            = NewAttribute("_gppdrivemaps")
+	MetaGPPPrinters             = NewAttribute("_gppprinters")
+	MetaGPPSharePaths           = NewAttribute("_gppsharepaths")
+	MetaGPPCredentials          = NewAttribute("_gppcredentials")
+	MetaGPOLocalGroups          = NewAttribute("_gpolocalgroups")
+	MetaGPOPrivileges           = NewAttribute("_gpoprivileges")
+	MetaGPORegistry             = NewAttribute("_gporegistry")
+	MetaGPOScheduledTasks       = NewAttribute("_gposcheduledtasks")
+	MetaGPOScripts              = NewAttribute("_gposcripts")
+	MetaTrustDirection          = NewAttribute("_trustdirection")
+	MetaTrustType               = NewAttribute("_trusttype")
+	MetaSIDFiltering            = NewAttribute("_sidfiltering")
+	MetaTrustPartner            = NewAttribute("_trustpartner")
+	MetaStub                    = NewAttribute("_stub")
+	MetaEntra                   = NewAttribute("_entra")
+	MetaEntraRoles              = NewAttribute("_entraroles")
+	MetaEntraPrivileged         = NewAttribute("_entraprivileged")
+	MetaEntraDeviceID           = NewAttribute("_entradeviceid")
+	MetaKeyCredentials          = NewAttribute("_keycredentials")
+	MetaRogueKeyCredential      = NewAttribute("_roguekeycredential")
+	MetaMFARegistered           = NewAttribute("_mfaregistered")
+	MetaPasswordBlank           = NewAttribute("_passwordblank")
+	MetaLMHash                  = NewAttribute("_lmhash")
+	MetaPasswordShared          = NewAttribute("_passwordshared")
+	MetaMFAEnforcedBy           = NewAttribute("_mfaenforcedby")
+	MetaIdP                     = NewAttribute("_idp")
+	MetaIdPRoles                = NewAttribute("_idproles")
+	MetaIdPPrivileged           = NewAttribute("_idpprivileged")
+	MetaIdPApps                 = NewAttribute("_idpapps")
+	MetaIdPADAccount            = NewAttribute("_idpadaccount")
+	MetaAWS                     = NewAttribute("_aws")
+	MetaAWSPolicies             = NewAttribute("_awspolicies")
+	MetaAWSAdmin                = NewAttribute("_awsadmin")
 	// The rest is skipped
 	_ = NewAttribute("member")
 	_ = NewAttribute("member;range=0-4999")
@@ -121,6 +202,8 @@ func NewAttribute(name string) Attribute {
 	attributenums = append(attributenums, name)
 	attributepopularity = append(attributepopularity, 1)
 	attributesizes = append(attributesizes, 0)
+	attributeobjects = append(attributeobjects, 0)
+	attributeredacted = append(attributeredacted, 0)
 	return Attribute(newindex)
 }
 
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AWSIdentityCenter is the permission sets and account assignments from an AWS IAM Identity Center (AWS SSO)
+// instance, with the groups and users from its identity store. Written by the collect-aws command to
+// <datapath>/<name>.aws.json
+type AWSIdentityCenter struct {
+	Name            string                 `json:"name"`
+	Region          string                 `json:"region"`
+	InstanceArn     string                 `json:"instanceArn"`
+	IdentityStoreID string                 `json:"identityStoreId"`
+	Groups          []AWSGroup             `json:"groups"`
+	Users           []AWSUser              `json:"users"`
+	PermissionSets  []AWSPermissionSet     `json:"permissionSets"`
+	Assignments     []AWSAccountAssignment `json:"assignments"`
+}
+
+type AWSExternalID struct {
+	Issuer string `json:"issuer"`
+	ID     string `json:"id"`
+}
+
+type AWSGroup struct {
+	ID          string          `json:"id"`
+	DisplayName string          `json:"displayName"`
+	ExternalIDs []AWSExternalID `json:"externalIds"`
+	Members     []string        `json:"members"` // User IDs
+}
+
+type AWSUser struct {
+	ID          string          `json:"id"`
+	UserName    string          `json:"userName"`
+	DisplayName string          `json:"displayName"`
+	ExternalIDs []AWSExternalID `json:"externalIds"`
+}
+
+type AWSPermissionSet struct {
+	Arn             string   `json:"arn"`
+	Name            string   `json:"name"`
+	Description     string   `json:"description"`
+	ManagedPolicies []string `json:"managedPolicies"`
+	InlinePolicy    string   `json:"inlinePolicy"`
+}
+
+type AWSAccountAssignment struct {
+	AccountID        string `json:"accountId"`
+	PermissionSetArn string `json:"permissionSetArn"`
+	PrincipalType    string `json:"principalType"` // GROUP or USER
+	PrincipalID      string `json:"principalId"`
+}
+
+// Managed policies that give control of the account, or enough to get it
+var awsAdminPolicies = []string{
+	"AdministratorAccess",
+	"PowerUserAccess",
+	"IAMFullAccess",
+}
+
+func (ps AWSPermissionSet) admin() bool {
+	for _, policy := range ps.ManagedPolicies {
+		if StringInSlice(policy, awsAdminPolicies) {
+			return true
+		}
+	}
+	// Inline policies allowing everything
+	policy := strings.Replace(ps.InlinePolicy, " ", "", -1)
+	return strings.Contains(policy, `"Action":"*"`) || strings.Contains(policy, `"Action":["*"]`)
+}
+
+func LoadAWSIdentityCenters(datapath string) error {
+	files, err := filepath.Glob(filepath.Join(datapath, "*.aws.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var ic AWSIdentityCenter
+		if err = qjson.Unmarshal(data, &ic); err != nil {
+			log.Warn().Msgf("Problem loading AWS IAM Identity Center data from %v: %v", file, err)
+			continue
+		}
+		if ic.Name == "" {
+			ic.Name = strings.TrimSuffix(filepath.Base(file), ".aws.json")
+		}
+		linked := ic.addObjects()
+		log.Info().Msgf("Loaded %v permission sets with %v account assignments from AWS IAM Identity Center %v, %v groups and users linked to directory accounts",
+			len(ic.PermissionSets), len(ic.Assignments), ic.Name, linked)
+	}
+	return nil
+}
+
+func (ic *AWSIdentityCenter) dn(kind, id string) string {
+	return "CN=" + id + ",CN=" + kind + ",CN=" + ic.Name + ",CN=AWS IAM Identity Center"
+}
+
+// Adds identity store groups and users, accounts, and a role object per permission set provisioned in an account.
+// Identity store groups and users are linked to the AD or Entra ID objects they are provisioned from
+func (ic *AWSIdentityCenter) addObjects() int {
+	memberof := make(map[string][]string)
+	for _, group := range ic.Groups {
+		for _, member := range group.Members {
+			memberof[member] = append(memberof[member], ic.dn("Groups", group.ID))
+		}
+	}
+
+	var linked int
+	for _, group := range ic.Groups {
+		o := &Object{
+			DistinguishedName: ic.dn("Groups", group.ID),
+			Attributes: map[Attribute][]string{
+				Name:           {group.DisplayName},
+				DisplayName:    {group.DisplayName},
+				ObjectClass:    {"top", "awsGroup"},
+				ObjectCategory: {"CN=Group,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MetaAWS:        {ic.Name},
+			},
+		}
+		AllObjects.Add(o)
+		if sources := awsDirectorySources(group.ExternalIDs, group.DisplayName, ObjectTypeGroup); len(sources) > 0 {
+			awsSources[o] = sources
+			linked++
+		}
+	}
+	for _, user := range ic.Users {
+		o := &Object{
+			DistinguishedName: ic.dn("Users", user.ID),
+			Attributes: map[Attribute][]string{
+				Name:              {user.UserName},
+				DisplayName:       {user.DisplayName},
+				UserPrincipalName: {user.UserName},
+				ObjectClass:       {"top", "awsUser"},
+				ObjectCategory:    {"CN=Person,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MemberOf:          memberof[user.ID],
+				MetaAWS:           {ic.Name},
+			},
+		}
+		AllObjects.Add(o)
+		if sources := awsDirectorySources(user.ExternalIDs, user.UserName, ObjectTypeUser); len(sources) > 0 {
+			awsSources[o] = sources
+			linked++
+		}
+	}
+
+	permissionsets := make(map[string]AWSPermissionSet)
+	for _, ps := range ic.PermissionSets {
+		permissionsets[ps.Arn] = ps
+	}
+	for _, assignment := range ic.Assignments {
+		ps, found := permissionsets[assignment.PermissionSetArn]
+		if !found {
+			continue
+		}
+		account, found := AllObjects.Find(ic.dn("Accounts", assignment.AccountID))
+		if !found {
+			account = &Object{
+				DistinguishedName: ic.dn("Accounts", assignment.AccountID),
+				Attributes: map[Attribute][]string{
+					Name:        {assignment.AccountID},
+					Description: {"AWS account"},
+					ObjectClass: {"top", "awsAccount"},
+					MetaAWS:     {ic.Name},
+				},
+			}
+			AllObjects.Add(account)
+		}
+		role, found := AllObjects.Find("CN=" + ps.Name + "," + account.DN())
+		if !found {
+			role = &Object{
+				DistinguishedName: "CN=" + ps.Name + "," + account.DN(),
+				Attributes: map[Attribute][]string{
+					Name:        {ps.Name + " in " + assignment.AccountID},
+					Description: {ps.Description},
+					ObjectClass: {"top", "awsPermissionSet"},
+					MetaAWS:     {ic.Name},
+				},
+			}
+			if len(ps.ManagedPolicies) > 0 {
+				role.SetValues(MetaAWSPolicies, ps.ManagedPolicies...)
+			}
+			if ps.admin() {
+				role.SetAttr(MetaAWSAdmin, "1")
+				awsAdminRoles[account] = append(awsAdminRoles[account], role)
+			}
+			AllObjects.Add(role)
+		}
+		var principal *Object
+		if assignment.PrincipalType == "GROUP" {
+			principal, found = AllObjects.Find(ic.dn("Groups", assignment.PrincipalID))
+		} else {
+			principal, found = AllObjects.Find(ic.dn("Users", assignment.PrincipalID))
+		}
+		if found {
+			awsAssignments[role] = append(awsAssignments[role], principal)
+		}
+	}
+	return linked
+}
+
+// Identity store group or user -> the AD and Entra ID objects it's provisioned from
+var awsSources = make(map[*Object][]*Object)
+
+// Role (permission set in an account) -> identity store groups and users assigned to it
+var awsAssignments = make(map[*Object][]*Object)
+
+// Account -> roles with admin permissions in it
+var awsAdminRoles = make(map[*Object][]*Object)
+
+// Finds the directory objects an identity store group or user comes from. Provisioning from Entra ID sets the
+// external ID to the Entra object ID, and the Entra object may itself be synced from AD. Without that, groups are
+// matched on name with AD groups, users on user principal name
+func awsDirectorySources(externalids []AWSExternalID, name string, objecttype ObjectType) []*Object {
+	var results []*Object
+	for _, externalid := range externalids {
+		for _, tenant := range AllTenants {
+			if objecttype == ObjectTypeGroup {
+				for _, group := range tenant.Groups {
+					if group.ID != externalid.ID {
+						continue
+					}
+					if o, found := AllObjects.Find(tenant.dn("Groups", group.ID)); found {
+						results = append(results, o)
+					}
+					if sid, err := SIDFromString(group.OnPremisesSecurityIdentifier); err == nil {
+						if o, found := AllObjects.FindSID(sid); found {
+							results = append(results, o)
+						}
+					}
+				}
+			} else if o, found := AllObjects.Find(tenant.dn("Users", externalid.ID)); found {
+				results = append(results, o)
+			}
+		}
+	}
+	if len(results) > 0 || name == "" {
+		return results
+	}
+
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != objecttype || o.SID().IsNull() {
+			continue
+		}
+		if (objecttype == ObjectTypeGroup && strings.EqualFold(o.OneAttr(Name), name)) ||
+			(objecttype == ObjectTypeUser && strings.EqualFold(o.OneAttr(UserPrincipalName), name)) {
+			results = append(results, o)
+		}
+	}
+	if objecttype == ObjectTypeGroup && len(results) > 1 {
+		// Same name in several domains, can't tell which one
+		return nil
+	}
+	return results
+}
+
+// Returns what can act as o in AWS: directory objects for identity store groups and users, assigned groups and
+// users for roles, and admin roles for accounts. The reasoning is recorded on the connection
+func awsIdentityCenterSources(o *Object) []*Object {
+	var results []*Object
+	for _, source := range awsSources[o] {
+		SetEdgeReason(source, o, PwnAWSIdentityCenter, "provisioned to the AWS identity store as "+o.OneAttr(Name))
+		results = append(results, source)
+	}
+	for _, principal := range awsAssignments[o] {
+		SetEdgeReason(principal, o, PwnAWSIdentityCenter, "assigned permission set "+o.OneAttr(Name))
+		results = append(results, principal)
+	}
+	for _, role := range awsAdminRoles[o] {
+		SetEdgeReason(role, o, PwnAWSIdentityCenter, "permission set has "+strings.Join(role.Attr(MetaAWSPolicies), ", ")+" in the account")
+		results = append(results, role)
+	}
+	return results
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Collects permission sets, account assignments and the identity store from AWS IAM Identity Center. Needs
+// credentials allowed to call the sso:List*, sso:Describe*, sso:Get* and identitystore:List* actions in the management
+// (or delegated administrator) account
+type AWSCollector struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	client http.Client
+}
+
+// Calls an AWS JSON 1.1 API, signed with signature version 4
+func (c *AWSCollector) call(service, target string, request, response interface{}) error {
+	body, err := qjson.Marshal(request)
+	if err != nil {
+		return err
+	}
+	host := service + "." + c.Region + ".amazonaws.com"
+	signingname := service
+	if service == "sso" {
+		target = "SWBExternalService." + target
+	} else {
+		target = "AWSIdentityStore." + target
+	}
+
+	now := time.Now().UTC()
+	amzdate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	headers := [][2]string{
+		{"content-type", "application/x-amz-json-1.1"},
+		{"host", host},
+		{"x-amz-date", amzdate},
+	}
+	if c.SessionToken != "" {
+		headers = append(headers, [2]string{"x-amz-security-token", c.SessionToken})
+	}
+	headers = append(headers, [2]string{"x-amz-target", target})
+
+	var canonicalheaders, signedheaders string
+	for _, header := range headers {
+		canonicalheaders += header[0] + ":" + header[1] + "\n"
+		if signedheaders != "" {
+			signedheaders += ";"
+		}
+		signedheaders += header[0]
+	}
+	payloadhash := sha256.Sum256(body)
+	canonicalrequest := "POST\n/\n\n" + canonicalheaders + "\n" + signedheaders + "\n" + hex.EncodeToString(payloadhash[:])
+	requesthash := sha256.Sum256([]byte(canonicalrequest))
+	scope := date + "/" + c.Region + "/" + signingname + "/aws4_request"
+	stringtosign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(requesthash[:])
+
+	key := []byte("AWS4" + c.SecretAccessKey)
+	for _, part := range []string{date, c.Region, signingname, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringtosign))
+
+	req, err := http.NewRequest("POST", "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for _, header := range headers {
+		if header[0] != "host" {
+			req.Header.Set(header[0], header[1])
+		}
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKeyID+"/"+scope+", SignedHeaders="+signedheaders+", Signature="+signature)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v returned %v: %v", target, resp.Status, string(data))
+	}
+	return qjson.Unmarshal(data, response)
+}
+
+// Parameters for the calls used, unset ones are left out
+type awsRequest struct {
+	InstanceArn      string `json:",omitempty"`
+	PermissionSetArn string `json:",omitempty"`
+	AccountId        string `json:",omitempty"`
+	IdentityStoreId  string `json:",omitempty"`
+	GroupId          string `json:",omitempty"`
+	NextToken        string `json:",omitempty"`
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (c *AWSCollector) Collect() (AWSIdentityCenter, error) {
+	c.client.Timeout = 60 * time.Second
+	ic := AWSIdentityCenter{Region: c.Region}
+
+	var instances struct {
+		Instances []struct {
+			InstanceArn     string
+			IdentityStoreId string
+		}
+	}
+	if err := c.call("sso", "ListInstances", awsRequest{}, &instances); err != nil {
+		return ic, err
+	}
+	if len(instances.Instances) == 0 {
+		return ic, errors.New("No IAM Identity Center instance found in region " + c.Region)
+	}
+	ic.InstanceArn = instances.Instances[0].InstanceArn
+	ic.IdentityStoreID = instances.Instances[0].IdentityStoreId
+	ic.Name = ic.IdentityStoreID
+
+	// Permission sets
+	var permissionsetarns []string
+	for nexttoken := ""; ; {
+		var page struct {
+			PermissionSets []string
+			NextToken      string
+		}
+		request := awsRequest{InstanceArn: ic.InstanceArn, NextToken: nexttoken}
+		if err := c.call("sso", "ListPermissionSets", request, &page); err != nil {
+			return ic, err
+		}
+		permissionsetarns = append(permissionsetarns, page.PermissionSets...)
+		if nexttoken = page.NextToken; nexttoken == "" {
+			break
+		}
+	}
+	log.Info().Msgf("Found %v permission sets, getting their policies and assignments", len(permissionsetarns))
+
+	for _, arn := range permissionsetarns {
+		request := awsRequest{InstanceArn: ic.InstanceArn, PermissionSetArn: arn}
+		var describe struct {
+			PermissionSet struct {
+				Name        string
+				Description string
+			}
+		}
+		if err := c.call("sso", "DescribePermissionSet", request, &describe); err != nil {
+			return ic, err
+		}
+		ps := AWSPermissionSet{Arn: arn, Name: describe.PermissionSet.Name, Description: describe.PermissionSet.Description}
+
+		var policies struct {
+			AttachedManagedPolicies []struct {
+				Name string
+			}
+		}
+		if err := c.call("sso", "ListManagedPoliciesInPermissionSet", request, &policies); err != nil {
+			return ic, err
+		}
+		for _, policy := range policies.AttachedManagedPolicies {
+			ps.ManagedPolicies = append(ps.ManagedPolicies, policy.Name)
+		}
+		var inline struct {
+			InlinePolicy string
+		}
+		if err := c.call("sso", "GetInlinePolicyForPermissionSet", request, &inline); err != nil {
+			return ic, err
+		}
+		ps.InlinePolicy = inline.InlinePolicy
+		ic.PermissionSets = append(ic.PermissionSets, ps)
+
+		var accounts struct {
+			AccountIds []string
+		}
+		if err := c.call("sso", "ListAccountsForProvisionedPermissionSet", request, &accounts); err != nil {
+			return ic, err
+		}
+		for _, account := range accounts.AccountIds {
+			for nexttoken := ""; ; {
+				assignmentrequest := awsRequest{InstanceArn: ic.InstanceArn, PermissionSetArn: arn, AccountId: account, NextToken: nexttoken}
+				var page struct {
+					AccountAssignments []struct {
+						PrincipalType string
+						PrincipalId   string
+					}
+					NextToken string
+				}
+				if err := c.call("sso", "ListAccountAssignments", assignmentrequest, &page); err != nil {
+					return ic, err
+				}
+				for _, assignment := range page.AccountAssignments {
+					ic.Assignments = append(ic.Assignments, AWSAccountAssignment{
+						AccountID:        account,
+						PermissionSetArn: arn,
+						PrincipalType:    assignment.PrincipalType,
+						PrincipalID:      assignment.PrincipalId,
+					})
+				}
+				if nexttoken = page.NextToken; nexttoken == "" {
+					break
+				}
+			}
+		}
+	}
+
+	// Identity store
+	type externalids []struct {
+		Issuer string
+		Id     string
+	}
+	convert := func(ids externalids) []AWSExternalID {
+		var results []AWSExternalID
+		for _, id := range ids {
+			results = append(results, AWSExternalID{Issuer: id.Issuer, ID: id.Id})
+		}
+		return results
+	}
+	for nexttoken := ""; ; {
+		request := awsRequest{IdentityStoreId: ic.IdentityStoreID, NextToken: nexttoken}
+		var page struct {
+			Groups []struct {
+				GroupId     string
+				DisplayName string
+				ExternalIds externalids
+			}
+			NextToken string
+		}
+		if err := c.call("identitystore", "ListGroups", request, &page); err != nil {
+			return ic, err
+		}
+		for _, group := range page.Groups {
+			ic.Groups = append(ic.Groups, AWSGroup{ID: group.GroupId, DisplayName: group.DisplayName, ExternalIDs: convert(group.ExternalIds)})
+		}
+		if nexttoken = page.NextToken; nexttoken == "" {
+			break
+		}
+	}
+	for i, group := range ic.Groups {
+		for nexttoken := ""; ; {
+			request := awsRequest{IdentityStoreId: ic.IdentityStoreID, GroupId: group.ID, NextToken: nexttoken}
+			var page struct {
+				GroupMemberships []struct {
+					MemberId struct {
+						UserId string
+					}
+				}
+				NextToken string
+			}
+			if err := c.call("identitystore", "ListGroupMemberships", request, &page); err != nil {
+				return ic, err
+			}
+			for _, membership := range page.GroupMemberships {
+				ic.Groups[i].Members = append(ic.Groups[i].Members, membership.MemberId.UserId)
+			}
+			if nexttoken = page.NextToken; nexttoken == "" {
+				break
+			}
+		}
+	}
+	for nexttoken := ""; ; {
+		request := awsRequest{IdentityStoreId: ic.IdentityStoreID, NextToken: nexttoken}
+		var page struct {
+			Users []struct {
+				UserId      string
+				UserName    string
+				DisplayName string
+				ExternalIds externalids
+			}
+			NextToken string
+		}
+		if err := c.call("identitystore", "ListUsers", request, &page); err != nil {
+			return ic, err
+		}
+		for _, user := range page.Users {
+			ic.Users = append(ic.Users, AWSUser{ID: user.UserId, UserName: user.UserName, DisplayName: user.DisplayName, ExternalIDs: convert(user.ExternalIds)})
+		}
+		if nexttoken = page.NextToken; nexttoken == "" {
+			break
+		}
+	}
+	return ic, nil
+}
+
+// Collects from IAM Identity Center using the standard AWS_* environment variables for credentials, and saves it
+// in the data folder, where analyze picks it up
+func CollectAWS(region, name, datapath string) error {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	collector := AWSCollector{
+		Region:          region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if collector.Region == "" || collector.AccessKeyID == "" || collector.SecretAccessKey == "" {
+		return errors.New("Set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (and AWS_SESSION_TOKEN for temporary credentials), and the region with -awsregion or AWS_REGION")
+	}
+	ic, err := collector.Collect()
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		ic.Name = name
+	}
+	data, err := qjson.MarshalIndent(ic, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(datapath, ic.Name+".aws.json")
+	log.Info().Msgf("Saving %v permission sets, %v account assignments, %v groups and %v users to %v", len(ic.PermissionSets), len(ic.Assignments), len(ic.Groups), len(ic.Users), filename)
+	return os.WriteFile(filename, data, 0600)
+}
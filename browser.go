@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Returns true if we're running under Windows Subsystem for Linux, where the browser lives on the Windows side
+func runningInWSL() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	version, err := os.ReadFile("/proc/version")
+	return err == nil && strings.Contains(strings.ToLower(string(version)), "microsoft")
+}
+
+// The URL a local browser should use for the webservice listening on bind
+func localURL(bind string) string {
+	host, port, err := net.SplitHostPort(bind)
+	if err != nil {
+		return "http://" + bind
+	}
+	// Listening on all interfaces, but the browser needs something to connect to
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// Opens the URL in the users browser, trying the options for the platform in order
+func OpenBrowser(url string) error {
+	var candidates [][]string
+	if browser := os.Getenv("BROWSER"); browser != "" {
+		for _, command := range strings.Split(browser, string(os.PathListSeparator)) {
+			candidates = append(candidates, []string{command})
+		}
+	}
+	switch {
+	case runtime.GOOS == "windows":
+		candidates = append(candidates, []string{"rundll32", "url.dll,FileProtocolHandler"})
+	case runtime.GOOS == "darwin":
+		candidates = append(candidates, []string{"open"})
+	case runningInWSL():
+		candidates = append(candidates,
+			[]string{"wslview"},
+			[]string{"rundll32.exe", "url.dll,FileProtocolHandler"},
+			[]string{"cmd.exe", "/c", "start", ""},
+		)
+	default:
+		candidates = append(candidates,
+			[]string{"xdg-open"},
+			[]string{"gio", "open"},
+			[]string{"sensible-browser"},
+			[]string{"x-www-browser"},
+			[]string{"firefox"},
+		)
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+		if err := exec.Command(candidate[0], append(candidate[1:], url)...).Start(); err == nil {
+			return nil
+		}
+	}
+	return errors.New("No way of launching a browser found, open " + url + " manually")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Reads a config file with default values for command line flags, one per line as "flag=value". Empty lines and
+// lines starting with # are ignored
+func LoadConfigFile(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		equals := strings.Index(line, "=")
+		if equals == -1 {
+			return nil, fmt.Errorf("Line %v in %v should be: flag=value", i+1, filename)
+		}
+		settings[strings.TrimPrefix(strings.TrimSpace(line[:equals]), "-")] = strings.TrimSpace(line[equals+1:])
+	}
+	return settings, nil
+}
+
+// Writes settings in the format LoadConfigFile reads
+func SaveConfigFile(filename, comment string, settings map[string]string) error {
+	var names []string
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var config strings.Builder
+	for _, line := range strings.Split(comment, "\n") {
+		config.WriteString("# " + line + "\n")
+	}
+	for _, name := range names {
+		config.WriteString(name + "=" + settings[name] + "\n")
+	}
+	return os.WriteFile(filename, []byte(config.String()), 0600)
+}
+
+// Sets flags from the settings, except the ones that are already set - on the command line, or by something
+// applied earlier
+func applyFlagSettings(flags *flag.FlagSet, settings map[string]string, source string) error {
+	set := make(map[string]struct{})
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = struct{}{}
+	})
+	for name, value := range settings {
+		if _, found := set[name]; found {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("%v can't set -%v: %v", source, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// An object per line in the JSON files from convert. Attributes with values that aren't text, like security
+// descriptors and SIDs, are in Binary instead, base64 encoded, so converting back gives the same dump
+type jsonObject struct {
+	DistinguishedName string              `json:"distinguishedName"`
+	Attributes        map[string][]string `json:"attributes"`
+	Binary            map[string][][]byte `json:"binary,omitempty"`
+}
+
+// Writes the objects in a dump file as newline delimited JSON
+func ConvertDumpToJSON(dumpfile, jsonfile string) error {
+	outfile, err := os.Create(jsonfile)
+	if err != nil {
+		return err
+	}
+	defer outfile.Close()
+	w := bufio.NewWriter(outfile)
+	encoder := qjson.NewEncoder(w)
+
+	var count int
+	var encodeerr error
+	err = readDumpFile(dumpfile, func(o *RawObject) {
+		if encodeerr != nil {
+			return
+		}
+		object := jsonObject{
+			DistinguishedName: o.DistinguishedName,
+			Attributes:        make(map[string][]string),
+		}
+		for name, values := range o.Attributes {
+			binary := false
+			for _, value := range values {
+				if !utf8.ValidString(value) {
+					binary = true
+					break
+				}
+			}
+			if !binary {
+				object.Attributes[name] = values
+				continue
+			}
+			if object.Binary == nil {
+				object.Binary = make(map[string][][]byte)
+			}
+			for _, value := range values {
+				object.Binary[name] = append(object.Binary[name], []byte(value))
+			}
+		}
+		encodeerr = encoder.Encode(object)
+		count++
+	})
+	if err == nil {
+		err = encodeerr
+	}
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Wrote %v objects to %v", count, jsonfile)
+	return w.Flush()
+}
+
+// Writes a dump file from newline delimited JSON in the format from ConvertDumpToJSON
+func ConvertJSONToDump(jsonfile, dumpfile string) error {
+	infile, err := os.Open(jsonfile)
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+	reader := bufio.NewReader(infile)
+
+	var count int
+	err = writeDumpStream(dumpfile, func(e *msgp.Writer) error {
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err == io.EOF && len(bytes.TrimSpace(line)) == 0 {
+				return nil
+			}
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var object jsonObject
+			if err = qjson.Unmarshal(line, &object); err != nil {
+				return fmt.Errorf("Object %v: %v", count+1, err)
+			}
+			o := RawObject{
+				DistinguishedName: object.DistinguishedName,
+				Attributes:        object.Attributes,
+			}
+			if o.Attributes == nil {
+				o.Attributes = make(map[string][]string)
+			}
+			for name, values := range object.Binary {
+				for _, value := range values {
+					o.Attributes[name] = append(o.Attributes[name], string(value))
+				}
+			}
+			if err = o.EncodeMsg(e); err != nil {
+				return err
+			}
+			count++
+		}
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Wrote %v objects to %v", count, dumpfile)
+	return nil
+}
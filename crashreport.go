@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/rs/zerolog/log"
+)
+
+// With -crashreports, panics - in the analysis of an object or anywhere else - are written to a report file in the
+// data folder, and sent to -crashreporturl if given. Dumps come in all shapes, and the report tells what went wrong
+// where without the data: the stack trace, and for an object only the names of the attributes and the lengths of
+// the values. Panic messages are only kept from the Go runtime, as others can contain values
+
+var (
+	CrashReports       bool
+	CrashReportURL     string
+	CrashReportFolder  = "."
+	CrashReportCommand string // The command being run, as parsed - the arguments can have passwords in them
+)
+
+type CrashReport struct {
+	Time     time.Time              `json:"time"`
+	Go       string                 `json:"go"`
+	Platform string                 `json:"platform"`
+	Command  string                 `json:"command,omitempty"`
+	Stage    string                 `json:"stage,omitempty"` // decode, convert or analyze if it was while handling an object
+	Panic    string                 `json:"panic"`
+	Stack    string                 `json:"stack"`
+	Object   []CrashReportAttribute `json:"object,omitempty"`
+	Count    int                    `json:"count"` // Panics with the same stack trace in this run
+}
+
+type CrashReportAttribute struct {
+	Name    string `json:"name"`
+	Lengths []int  `json:"lengths"` // Of the values, which are left out
+}
+
+// Stack trace checksum -> report, so an object type that breaks something is reported once, not for every object
+var (
+	crashReports    = make(map[uint64]*CrashReport)
+	crashReportLock sync.Mutex
+)
+
+// Only what the Go runtime says, the rest could be anything from the data
+func scrubPanic(r interface{}) string {
+	if err, ok := r.(runtime.Error); ok {
+		return err.Error()
+	}
+	return fmt.Sprintf("%T (message left out)", r)
+}
+
+func scrubAttributes(attributes map[string][]string) []CrashReportAttribute {
+	var result []CrashReportAttribute
+	for name, values := range attributes {
+		attribute := CrashReportAttribute{Name: name, Lengths: make([]int, len(values))}
+		for i, value := range values {
+			attribute.Lengths[i] = len(value)
+		}
+		result = append(result, attribute)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Records a panic, from a recover in the goroutine that panicked so the stack trace is the one that matters. raw or
+// the object with the DN gives the attributes, if the panic was from handling one
+func reportCrash(stage, dn string, raw *RawObject, r interface{}) {
+	if !CrashReports {
+		return
+	}
+	stack := debug.Stack()
+	// The file and line of each frame, as the goroutine and arguments are different every time
+	h := xxhash.New64()
+	for _, line := range strings.Split(string(stack), "\n") {
+		if strings.HasPrefix(line, "\t") {
+			h.WriteString(line)
+		}
+	}
+	key := h.Sum64()
+
+	crashReportLock.Lock()
+	report, found := crashReports[key]
+	if !found {
+		report = &CrashReport{
+			Time:     time.Now(),
+			Go:       runtime.Version(),
+			Platform: runtime.GOOS + "/" + runtime.GOARCH,
+			Stage:    stage,
+			Panic:    scrubPanic(r),
+			Stack:    string(stack),
+		}
+		report.Command = CrashReportCommand
+		if raw != nil {
+			report.Object = scrubAttributes(raw.Attributes)
+		} else if o, found := AllObjects.Find(dn); found && dn != "" {
+			attributes := make(map[string][]string)
+			for attribute, values := range o.AttributesSnapshot() {
+				attributes[attribute.String()] = values
+			}
+			report.Object = scrubAttributes(attributes)
+		}
+		crashReports[key] = report
+	}
+	report.Count++
+	if found && report.Count&(report.Count-1) != 0 {
+		// Only written again now and then with the count, as it can be every object
+		crashReportLock.Unlock()
+		return
+	}
+	data, err := qjson.MarshalIndent(report, "", "  ")
+	crashReportLock.Unlock()
+	if err != nil {
+		log.Error().Msgf("Problem making crash report: %v", err)
+		return
+	}
+
+	filename := filepath.Join(CrashReportFolder, fmt.Sprintf("adalanche-crash-%x.json", key))
+	if err = os.WriteFile(filename, data, 0600); err != nil {
+		log.Error().Msgf("Problem writing crash report: %v", err)
+		return
+	}
+	if found {
+		return
+	}
+	log.Warn().Msgf("Crash report written to %v, it has no values from your data", filename)
+
+	if CrashReportURL != "" {
+		client := &http.Client{Timeout: 10 * time.Second}
+		response, err := client.Post(CrashReportURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Warn().Msgf("Problem sending crash report: %v", err)
+			return
+		}
+		response.Body.Close()
+		if response.StatusCode/100 != 2 {
+			log.Warn().Msgf("Sending crash report failed with %v", response.Status)
+			return
+		}
+		log.Info().Msgf("Crash report sent to %v", CrashReportURL)
+	}
+}
+
+// Deferred at the top of goroutines that don't recover themselves, to report the panic before it ends everything
+func CrashHandler() {
+	if r := recover(); r != nil {
+		reportCrash("", "", nil, r)
+		panic(r)
+	}
+}
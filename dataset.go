@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/pierrec/lz4"
+	"github.com/rs/zerolog/log"
+	"github.com/schollz/progressbar/v3"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Held for reading by the webservice while it answers a request, and for writing while the data is replaced,
+// so a request sees either the old data or the new data, never something half loaded
+var datasetLock sync.RWMutex
+
+// Goes up by one every time data has been loaded and analyzed, so clients can tell when it was replaced
+var DatasetVersion int
+
+// The domains that are loaded, so the data can be loaded again or other domains switched to from the UI
+var DatasetDomains string
+var datasetImportAll bool
+
+// Loads the dumps for the comma separated domains and everything else collected in datapath, and analyzes it
+func LoadDataset(domains, datapath string, importall bool) error {
+	if WarmStart {
+		ws, err := readWarmStart(domains, datapath, importall)
+		if err == nil {
+			ws.install(domains, datapath)
+			DatasetDomains, datasetImportAll = domains, importall
+			DatasetVersion++
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			log.Info().Msgf("Not using the warm start file: %v", err)
+		}
+	}
+
+	for _, domain := range strings.Split(domains, ",") {
+		if AllObjects.Base == "" { // Shoot me, this is horrible
+			AllObjects.Base = "dc=" + strings.Replace(domain, ".", ",dc=", -1)
+			AllObjects.Domain = domain
+		}
+
+		dumpfile := datasetDumpFile(datapath, domain)
+		if strings.HasSuffix(dumpfile, ".sqlite") {
+			if err := loadSQLiteDump(dumpfile, importall); err != nil {
+				return fmt.Errorf("Problem loading %v: %v", dumpfile, err)
+			}
+			continue
+		}
+
+		cachefile, err := os.Open(dumpfile)
+		if err != nil {
+			return fmt.Errorf("Problem opening domain cache file: %v", err)
+		}
+		bcachefile := lz4.NewReader(cachefile)
+
+		cachestat, _ := cachefile.Stat()
+
+		loadbar := progressbar.NewOptions(int(cachestat.Size()),
+			progressbar.OptionSetDescription("Loading objects from "+domain+" ..."),
+			progressbar.OptionShowBytes(true),
+			progressbar.OptionThrottle(time.Second*1),
+			progressbar.OptionOnCompletion(func() { fmt.Println() }),
+		)
+
+		d := msgp.NewReader(bcachefile)
+		// d := msgp.NewReader(&progressbar.Reader{bcachefile, &loadbar})
+
+		// Load all the stuff
+		var lastpos int64
+		for {
+			var rawObject RawObject
+			err = rawObject.DecodeMsg(d)
+
+			pos, _ := cachefile.Seek(0, io.SeekCurrent)
+			loadbar.Add(int(pos - lastpos))
+			lastpos = pos
+
+			if err == nil {
+				guardObject("convert", rawObject.DistinguishedName, &rawObject, func() {
+					AllObjects.Add(rawObject.ToObject(importall))
+				})
+			} else if msgp.Cause(err) == io.EOF {
+				break
+			} else {
+				// The rest of the file can't be read after this
+				RecordProblem("decode", "", nil, fmt.Sprintf("object %v in %v can't be decoded, ignoring the rest of the file: %v", len(AllObjects.AsArray())+1, cachefile.Name(), err))
+				break
+			}
+		}
+		cachefile.Close()
+		loadbar.Finish()
+	}
+
+	log.Debug().Msgf("Loaded %v ojects", len(AllObjects.AsArray()))
+	if strippedBytes > 0 {
+		log.Info().Msgf("Left out %v of photos and other attributes not used in analysis, use -keepattributes to load them", formatBytes(strippedBytes))
+	}
+	if compressedBytes > 0 {
+		log.Debug().Msgf("Compressed %v of security descriptors and certificates to %v", formatBytes(uncompressedBytes), formatBytes(compressedBytes))
+	}
+
+	if err := LoadLocalMachines(datapath); err != nil {
+		log.Warn().Msgf("Problem loading local machine data: %v", err)
+	}
+
+	if err := LoadEntraTenants(datapath); err != nil {
+		log.Warn().Msgf("Problem loading Entra ID data: %v", err)
+	}
+
+	if err := LoadIdPDirectories(datapath); err != nil {
+		log.Warn().Msgf("Problem loading identity provider data: %v", err)
+	}
+
+	if err := LoadAWSIdentityCenters(datapath); err != nil {
+		log.Warn().Msgf("Problem loading AWS IAM Identity Center data: %v", err)
+	}
+
+	if err := LoadMetadataCSVs(datapath); err != nil {
+		log.Warn().Msgf("Problem loading metadata: %v", err)
+	}
+
+	// Copy of \\domain\SYSVOL\domain\Policies
+	for _, domain := range strings.Split(domains, ",") {
+		policiespath := filepath.Join(datapath, domain+".sysvol")
+		if _, err := os.Stat(policiespath); err == nil {
+			if err = LoadGPPFromSYSVOL(policiespath); err != nil {
+				log.Warn().Msgf("Problem loading Group Policy Preferences from %v: %v", policiespath, err)
+			}
+			if err = LoadGPOSettings(policiespath); err != nil {
+				log.Warn().Msgf("Problem loading Group Policy settings from %v: %v", policiespath, err)
+			}
+		}
+	}
+
+	ProcessObjects(domains)
+
+	if WarmStart {
+		if err := writeWarmStart(domains, datapath, importall); err != nil {
+			log.Warn().Msgf("Problem saving the warm start file: %v", err)
+		}
+	}
+
+	DatasetDomains, datasetImportAll = domains, importall
+	DatasetVersion++
+	return nil
+}
+
+// Loads a made up domain from the demo generator instead of collected data, so adalanche can be tried without
+// access to AD. The same options give the same domain, so everyone trying it sees the same thing
+func LoadDemoDataset(options DemoOptions) {
+	AllObjects.Base = "dc=" + strings.Replace(options.Domain, ".", ",dc=", -1)
+	AllObjects.Domain = options.Domain
+	for _, raw := range GenerateDemo(options) {
+		AllObjects.Add(raw.ToObject(true))
+	}
+
+	ProcessObjects(options.Domain)
+
+	DatasetDomains, datasetImportAll = options.Domain, true
+	DatasetVersion++
+}
+
+// The dump of the domain, or the SQLite database from converting it if there is only that
+func datasetDumpFile(datapath, domain string) string {
+	dumpfile := filepath.Join(datapath, domain+".objects.lz4.msgp")
+	if _, err := os.Stat(dumpfile); os.IsNotExist(err) {
+		sqlitefile := filepath.Join(datapath, domain+".objects.sqlite")
+		if _, err = os.Stat(sqlitefile); err == nil {
+			return sqlitefile
+		}
+	}
+	return dumpfile
+}
+
+// The options adalanche was started with, so the data is analyzed the same way when it's reloaded
+var DatasetOptions []string
+
+// Loads and analyzes the data again in another adalanche process, which saves it to the warm start file, and then
+// puts that in place of what is loaded. The webservice keeps answering from the old data until then, and only waits
+// while the objects are set up from the file. If anything fails the old data stays
+func ReloadDataset(domains, datapath string, importall bool) error {
+	if err := RunHook("preanalyze", domains, datapath); err != nil {
+		return err
+	}
+
+	if err := analyzeSeparately(domains, datapath, importall); err != nil {
+		return fmt.Errorf("Problem analyzing the data, keeping what is loaded: %v", err)
+	}
+	ws, err := readWarmStart(domains, datapath, importall)
+	if !WarmStart {
+		// It was only written for this
+		os.Remove(filepath.Join(datapath, warmStartFile))
+	}
+	if err != nil {
+		return fmt.Errorf("Problem reading the analyzed data, keeping what is loaded: %v", err)
+	}
+
+	datasetLock.Lock()
+	resetObjects()
+	ws.install(domains, datapath)
+	DatasetDomains, datasetImportAll = domains, importall
+	DatasetVersion++
+	datasetLock.Unlock()
+
+	if err = RunHook("postanalyze", domains, datapath); err != nil {
+		log.Warn().Msgf("%v", err)
+	}
+	return nil
+}
+
+// Runs the warmstart command with the options adalanche was started with, for the domains given. The hooks are run
+// by the process that reloads
+func analyzeSeparately(domains, datapath string, importall bool) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	arguments := append([]string{}, DatasetOptions...)
+	arguments = append(arguments,
+		"-datapath="+datapath,
+		"-domain="+domains,
+		"-importall="+strconv.FormatBool(importall),
+		"-demo=false",
+		"-hookpreanalyze=",
+		"-hookpostanalyze=",
+		"warmstart",
+	)
+	cmd := exec.Command(executable, arguments...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// Fingerprint of the names, sizes and modification times of the files in datapath
+func datasetFingerprint(datapath string) uint64 {
+	h := xxhash.New64()
+	info := make([]byte, 16)
+	filepath.Walk(datapath, func(path string, fi os.FileInfo, err error) error {
+		// The warm start file, saved queries and crash reports are written by us, and aren't part of the data. Uploads
+		// are written to a temporary file first, and only count once they're renamed into place
+		if err != nil || fi.IsDir() || fi.Name() == warmStartFile || fi.Name() == savedQueriesFile || strings.HasPrefix(fi.Name(), ".warmstart-") || strings.HasPrefix(fi.Name(), ".queries-") || strings.HasPrefix(fi.Name(), ".upload-") || strings.HasPrefix(fi.Name(), "adalanche-crash-") {
+			return nil
+		}
+		h.WriteString(path)
+		binary.LittleEndian.PutUint64(info[0:], uint64(fi.Size()))
+		binary.LittleEndian.PutUint64(info[8:], uint64(fi.ModTime().UnixNano()))
+		h.Write(info)
+		return nil
+	})
+	return h.Sum64()
+}
+
+// Checks datapath every interval, and reloads everything when files have changed. Changes are only picked up once
+// the files have been left alone for an interval, so a dump that is still being written isn't loaded. The domains
+// loaded are the ones loaded at the time, as they can be switched in the UI
+func MonitorDataset(datapath string, interval time.Duration) {
+	loaded := datasetFingerprint(datapath)
+	last := loaded
+	for range time.Tick(interval) {
+		current := datasetFingerprint(datapath)
+		if current != last {
+			last = current
+			continue
+		}
+		if current == loaded {
+			continue
+		}
+		loaded = current
+
+		log.Info().Msgf("Data in %v has changed, reloading ...", datapath)
+		start := time.Now()
+		datasetLock.RLock()
+		domains, importall := DatasetDomains, datasetImportAll
+		datasetLock.RUnlock()
+		if err := ReloadDataset(domains, datapath, importall); err != nil {
+			log.Error().Msgf("Problem reloading data: %v", err)
+			continue
+		}
+		log.Info().Msgf("Reloaded data in %v, now at version %v", time.Since(start).Round(time.Second), DatasetVersion)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+)
+
+// Constrained delegation lets an account get tickets as other users to the services listed in msDS-AllowedToDelegateTo
+// (S4U2Proxy). The service class in the ticket isn't protected, so a ticket for one service on a host can be rewritten
+// for any other (Rubeus /altservice), and a delegation to http/server is as good as one to cifs/server - whoever controls
+// the account can be an admin on the host. With protocol transition (TRUSTED_TO_AUTH_FOR_DELEGATION) the account can get
+// the ticket for anyone without them logging on (S4U2Self). Without it, a forwardable ticket from the user is needed,
+// which an attacker gets through resource based constrained delegation to the account. Accounts that are sensitive and
+// can't be delegated, and members of Protected Users, can't be impersonated either way
+
+// Returns the computer with the host name from an SPN, trying the short name if the full name isn't known
+func spnComputer(spn string) (*Object, bool) {
+	host := spnHost(spn)
+	if host == "" {
+		return nil, false
+	}
+	if computer, found := AllObjects.FindComputer(host); found {
+		return computer, true
+	}
+	if dot := strings.Index(host, "."); dot != -1 {
+		return AllObjects.FindComputer(host[:dot])
+	}
+	return nil, false
+}
+
+// Computer -> the accounts that can delegate to services on it -> why
+var delegationSources map[*Object]map[*Object]string
+
+func buildConstrainedDelegation() {
+	delegationSources = make(map[*Object]map[*Object]string)
+	for _, o := range AllObjects.AsArray() {
+		spns := o.Attr(MSDSAllowedToDelegateTo)
+		if len(spns) == 0 {
+			continue
+		}
+		uac, _ := o.AttrInt(UserAccountControl)
+		if uac&UAC_ACCOUNTDISABLE != 0 {
+			continue
+		}
+		targets := make(map[*Object][]string)
+		for _, spn := range spns {
+			if computer, found := spnComputer(spn); found {
+				targets[computer] = append(targets[computer], spn)
+			}
+		}
+		for computer, targetspns := range targets {
+			reason := "Constrained delegation to " + strings.Join(targetspns, ", ")
+			if uac&UAC_TRUSTED_TO_AUTH_FOR_DELEGATION != 0 {
+				reason += ", with protocol transition so it can impersonate users without them logging on"
+			} else {
+				reason += ", without protocol transition so it needs a forwardable ticket from the user (or resource based delegation to itself)"
+			}
+			if delegationSources[computer] == nil {
+				delegationSources[computer] = make(map[*Object]string)
+			}
+			delegationSources[computer][o] = reason
+		}
+	}
+}
+
+// Returns the accounts that can delegate to a service on the computer
+func constrainedDelegators(computer *Object) []*Object {
+	var results []*Object
+	for account, reason := range delegationSources[computer] {
+		SetEdgeReason(account, computer, PwnCanDelegateTo, reason)
+		results = append(results, account)
+	}
+	return results
+}
+
+// Accounts with unconstrained delegation get the TGT of whoever authenticates to them. Anyone in the domain can make a
+// computer authenticate somewhere with the printer bug (SpoolSample) or PetitPotam, so with control of such an account
+// the TGT of a domain controller is a coerced logon away - and with that DCSync. Domain controllers have unconstrained
+// delegation themselves and are left out as sources. TGTs aren't delegated over trusts to other forests since 2019, so
+// targets are in the same domain or the same forest
+
+// Enabled accounts with unconstrained delegation that aren't domain controllers
+var unconstrainedHosts []*Object
+
+func buildUnconstrainedHosts() {
+	unconstrainedHosts = nil
+	for _, o := range AllObjects.AsArray() {
+		uac, ok := o.AttrInt(UserAccountControl)
+		if !ok || uac&UAC_TRUSTED_FOR_DELEGATION == 0 || uac&(UAC_ACCOUNTDISABLE|UAC_SERVER_TRUST_ACCOUNT|UAC_PARTIAL_SECRETS_ACCOUNT) != 0 {
+			continue
+		}
+		unconstrainedHosts = append(unconstrainedHosts, o)
+	}
+}
+
+// Returns true if the objects are in the same domain, or in domains in the same forest
+func sameForest(a, b *Object) bool {
+	adomain, bdomain := a.SID().StripRID(), b.SID().StripRID()
+	if adomain == bdomain {
+		return true
+	}
+	for _, pair := range [][2]SID{{adomain, bdomain}, {bdomain, adomain}} {
+		if trust, found := domainTrusts[pair]; found && trust.OneAttr(MetaTrustType) == "withinforest" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the accounts with unconstrained delegation that can get the TGT of the computer by coercing it to
+// authenticate to them. Only tier 0 computers are targets, the rest would be a link from every such host to every
+// computer
+func coercedTGTHolders(computer *Object) []*Object {
+	if len(unconstrainedHosts) == 0 {
+		return nil
+	}
+	uac, _ := computer.AttrInt(UserAccountControl)
+	if uac&UAC_NOT_DELEGATED != 0 || computer.OneAttr(MetaProtectedUser) == "1" {
+		return nil
+	}
+	if uac&UAC_SERVER_TRUST_ACCOUNT == 0 && !computer.IsTier0() {
+		return nil
+	}
+	var results []*Object
+	for _, host := range unconstrainedHosts {
+		if !sameForest(host, computer) {
+			continue
+		}
+		reason := "Unconstrained delegation on " + host.Label() + ", so coercing " + computer.Label() + " to authenticate to it (printer bug, PetitPotam) leaves its TGT there"
+		if host.Type() != ObjectTypeComputer {
+			reason += " - the account isn't a computer, so a host name for one of its SPNs has to point to a machine under control"
+		}
+		SetEdgeReason(host, computer, PwnCoerceToTGT, reason)
+		results = append(results, host)
+	}
+	return results
+}
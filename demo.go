@@ -0,0 +1,812 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Settings for a made up directory
+type DemoOptions struct {
+	Domain            string    // DNS name, like demo.local
+	Users             int       // Number of users, the number of computers and groups follows from this
+	Misconfigurations int       // Number of weaknesses to put in, spread over the kinds in demoMisconfigurations
+	Seed              int64     // The same seed and options give the same directory
+	Now               time.Time // Timestamps are made relative to this, zero means the current time
+}
+
+// Schema classes used by the made up objects, with their real schemaIDGUIDs
+var demoClasses = []struct {
+	name, cn, guid string
+	superclass     string
+	category       string // cn of the default object category, blank if it's the class itself
+}{
+	{"top", "Top", "bf967ab7-0de6-11d0-a285-00aa003049e2", "", ""},
+	{"person", "Person", "bf967aa7-0de6-11d0-a285-00aa003049e2", "top", ""},
+	{"organizationalPerson", "Organizational-Person", "bf967aa4-0de6-11d0-a285-00aa003049e2", "person", "Person"},
+	{"user", "User", "bf967aba-0de6-11d0-a285-00aa003049e2", "organizationalPerson", "Person"},
+	{"computer", "Computer", "bf967a86-0de6-11d0-a285-00aa003049e2", "user", ""},
+	{"msDS-GroupManagedServiceAccount", "ms-DS-Group-Managed-Service-Account", "7b8b558a-93a5-4af7-adca-c017e67f1057", "computer", ""},
+	{"group", "Group", "bf967a9c-0de6-11d0-a285-00aa003049e2", "top", ""},
+	{"organizationalUnit", "Organizational-Unit", "bf967aa5-0de6-11d0-a285-00aa003049e2", "top", ""},
+	{"container", "Container", "bf967a8b-0de6-11d0-a285-00aa003049e2", "top", ""},
+	{"groupPolicyContainer", "Group-Policy-Container", "f30e3bc2-9ff0-11d1-b603-0000f80367c1", "container", ""},
+	{"domain", "Domain", "19195a5a-6da0-11d0-afd3-00c04fd930c9", "top", ""},
+	{"domainDNS", "Domain-DNS", "19195a5b-6da0-11d0-afd3-00c04fd930c9", "domain", ""},
+	{"builtinDomain", "Builtin-Domain", "bf967a81-0de6-11d0-a285-00aa003049e2", "top", ""},
+	{"trustedDomain", "Trusted-Domain", "bf967ab8-0de6-11d0-a285-00aa003049e2", "top", ""},
+	{"foreignSecurityPrincipal", "Foreign-Security-Principal", "89e31c12-8530-11d0-afda-00c04fd930c9", "top", ""},
+	{"controlAccessRight", "Control-Access-Right", "8297931e-86d3-11d0-afda-00c04fd930c9", "top", ""},
+	{"classSchema", "Class-Schema", "bf967a83-0de6-11d0-a285-00aa003049e2", "top", ""},
+	{"attributeSchema", "Attribute-Schema", "bf967a80-0de6-11d0-a285-00aa003049e2", "top", ""},
+}
+
+// Schema attributes, so the analysis knows their syntax
+var demoAttributes = []struct {
+	name, cn, guid, syntax string
+}{
+	{"objectSid", "Object-Sid", "bf9679e8-0de6-11d0-a285-00aa003049e2", "2.5.5.17"},
+	{"objectGUID", "Object-Guid", "bf9679e7-0de6-11d0-a285-00aa003049e2", "2.5.5.10"},
+	{"sIDHistory", "SID-History", "17eb4278-d167-11d0-b002-0000f80367c1", "2.5.5.17"},
+	{"nTSecurityDescriptor", "NT-Security-Descriptor", "bf9679e3-0de6-11d0-a285-00aa003049e2", "2.5.5.15"},
+	{"userAccountControl", "User-Account-Control", "bf967a68-0de6-11d0-a285-00aa003049e2", "2.5.5.9"},
+	{"primaryGroupID", "Primary-Group-ID", "bf967a00-0de6-11d0-a285-00aa003049e2", "2.5.5.9"},
+	{"adminCount", "Admin-Count", "bf967918-0de6-11d0-a285-00aa003049e2", "2.5.5.9"},
+	{"pwdLastSet", "Pwd-Last-Set", "bf967a0a-0de6-11d0-a285-00aa003049e2", "2.5.5.16"},
+	{"lastLogonTimestamp", "Last-Logon-Timestamp", "c0e20a04-0e5a-4ff3-9482-5efeaecd7060", "2.5.5.16"},
+	{"whenChanged", "When-Changed", "bf967a77-0de6-11d0-a285-00aa003049e2", "2.5.5.11"},
+	{"whenCreated", "When-Created", "bf967a78-0de6-11d0-a285-00aa003049e2", "2.5.5.11"},
+	{"member", "Member", "bf9679c0-0de6-11d0-a285-00aa003049e2", "2.5.5.1"},
+	{"servicePrincipalName", "Service-Principal-Name", "f3a64788-5306-11d1-a9c5-0000f80367c1", "2.5.5.12"},
+	{"gPLink", "GP-Link", "f30e3bbe-9ff0-11d1-b603-0000f80367c1", "2.5.5.12"},
+	{"msLAPS-PasswordExpirationTime", "ms-LAPS-PasswordExpirationTime", "e2ea3a3b-0e8b-4dc3-9aa7-2e8e6a5c1c4e", "2.5.5.16"},
+	{"msLAPS-Password", "ms-LAPS-Password", "a0f4b7d2-4a6a-4d1c-8b2f-3c1f5e9d7a61", "2.5.5.12"},
+	{"msLAPS-EncryptedPassword", "ms-LAPS-EncryptedPassword", "b5c3a1e8-6f2d-4e9b-9c7a-1d8e4f2a6b53", "2.5.5.10"},
+	{"msDS-KeyCredentialLink", "ms-DS-Key-Credential-Link", "5b47d60f-6090-40b2-9f37-2a4de88f3063", "2.5.5.7"},
+}
+
+func demoAttributeGUID(name string) string {
+	for _, attribute := range demoAttributes {
+		if attribute.name == name {
+			return attribute.guid
+		}
+	}
+	return ""
+}
+
+// Extended rights, so ACEs are shown with names
+var demoRights = []struct {
+	name, cn, guid string
+}{
+	{"User-Change-Password", "User-Change-Password", "ab721a53-1e2f-11d0-9819-00aa0040529b"},
+	{"User-Force-Change-Password", "User-Force-Change-Password", "00299570-246d-11d0-a768-00aa006e0529"},
+	{"DS-Replication-Get-Changes", "DS-Replication-Get-Changes", "1131f6aa-9c07-11d1-f79f-00c04fc2dcd2"},
+	{"DS-Replication-Get-Changes-All", "DS-Replication-Get-Changes-All", "1131f6ad-9c07-11d1-f79f-00c04fc2dcd2"},
+}
+
+var (
+	demoFirstNames = []string{"Anna", "Ben", "Carla", "David", "Emma", "Frank", "Grace", "Henrik", "Ida", "Jonas",
+		"Karen", "Lars", "Maria", "Niels", "Olivia", "Peter", "Rita", "Simon", "Tina", "Ulrik",
+		"Vera", "William", "Yasmin", "Zoe", "Ahmed", "Bettina", "Carlos", "Dorte", "Erik", "Fatima"}
+	demoLastNames = []string{"Andersen", "Baker", "Clarke", "Dahl", "Evans", "Fischer", "Garcia", "Hansen", "Ivanova", "Jensen",
+		"Kowalski", "Larsen", "Miller", "Nielsen", "Olsen", "Petersen", "Quinn", "Rasmussen", "Schmidt", "Thomsen",
+		"Ulriksen", "Vestergaard", "Wilson", "Young", "Zimmermann"}
+	demoDepartments = []string{"Sales", "Finance", "HR", "IT", "Engineering", "Marketing", "Support", "Legal"}
+	demoProjects    = []string{"Apollo", "Borealis", "Cobalt", "Delta", "Ember", "Falcon", "Granite", "Horizon", "Iris", "Juniper"}
+)
+
+const demoFullControl = 0x000F01FF
+
+type demoGenerator struct {
+	options   DemoOptions
+	rnd       *rand.Rand
+	base      string
+	config    string
+	schema    string
+	domainsid SID
+	nextrid   uint32
+
+	objects []*RawObject
+	owners  map[*RawObject]SID
+	aces    map[*RawObject][]ACE
+	sids    map[*RawObject]SID
+
+	root              *RawObject
+	users, admins     []*RawObject
+	departmentgroups  []*RawObject
+	privilegedgroups  []*RawObject
+	servers           []*RawObject
+	gpos              []*RawObject
+	departmentunits   []*RawObject
+	domaincontrollers []*RawObject
+	samaccountnames   map[string]struct{}
+
+	exchangepermissions, exchangeserver *RawObject
+}
+
+// Makes up a directory that looks like a real one, with users, computers, groups, OUs, GPOs and security descriptors,
+// and puts the requested number of misconfigurations in it. Everything is returned as it would be from a dump
+func GenerateDemo(options DemoOptions) []*RawObject {
+	g := newDemoGenerator(options)
+	g.generateSchema()
+	g.generateDomain()
+	g.generatePrincipals()
+
+	kinds := make(map[string]int)
+	for i := 0; i < options.Misconfigurations; i++ {
+		misconfiguration := demoMisconfigurations[i%len(demoMisconfigurations)]
+		log.Info().Msgf("Demo weakness: %v", misconfiguration.inject(g))
+		kinds[misconfiguration.name]++
+	}
+
+	g.generateSecurityDescriptors()
+	log.Info().Msgf("Generated %v objects in %v with %v weaknesses of %v kinds", len(g.objects), options.Domain, options.Misconfigurations, len(kinds))
+	return g.objects
+}
+
+func newDemoGenerator(options DemoOptions) *demoGenerator {
+	if options.Now.IsZero() {
+		options.Now = time.Now()
+	}
+	g := &demoGenerator{
+		options:         options,
+		rnd:             rand.New(rand.NewSource(options.Seed)),
+		base:            "DC=" + strings.Replace(options.Domain, ".", ",DC=", -1),
+		owners:          make(map[*RawObject]SID),
+		aces:            make(map[*RawObject][]ACE),
+		sids:            make(map[*RawObject]SID),
+		samaccountnames: make(map[string]struct{}),
+		nextrid:         1100,
+	}
+	g.config = "CN=Configuration," + g.base
+	g.schema = "CN=Schema," + g.config
+	g.domainsid, _ = SIDFromString(fmt.Sprintf("S-1-5-21-%v-%v-%v", 1000000000+g.rnd.Int31n(1000000000), 1000000000+g.rnd.Int31n(1000000000), 1000000000+g.rnd.Int31n(1000000000)))
+	return g
+}
+
+func (g *demoGenerator) guid() string {
+	var u uuid.UUID
+	g.rnd.Read(u[:])
+	u.SetVersion(uuid.V4)
+	u.SetVariant(uuid.VariantRFC4122)
+	return string(u.Bytes())
+}
+
+func (g *demoGenerator) pick(objects []*RawObject) *RawObject {
+	return objects[g.rnd.Intn(len(objects))]
+}
+
+// A time between the given number of days ago and now
+func (g *demoGenerator) past(days int) time.Time {
+	return g.options.Now.Add(-time.Duration(g.rnd.Int63n(int64(days)*24*int64(time.Hour)) + int64(time.Hour)))
+}
+
+// A time between t and now
+func (g *demoGenerator) since(t time.Time) time.Time {
+	return t.Add(time.Duration(g.rnd.Int63n(int64(g.options.Now.Sub(t)))))
+}
+
+func demoGeneralizedTime(t time.Time) string {
+	return t.UTC().Format("20060102150405") + ".0Z"
+}
+
+func demoFiletime(t time.Time) string {
+	return strconv.FormatUint(uint64(t.Unix()+11644473600)*10000000, 10)
+}
+
+// Adds an object of the class, with the attributes every object has
+func (g *demoGenerator) add(dn, class string, attributes map[string][]string) *RawObject {
+	var classes []string
+	var category string
+	for c := class; c != ""; {
+		found := false
+		for _, dc := range demoClasses {
+			if dc.name == c {
+				if category == "" {
+					category = dc.category
+					if category == "" {
+						category = dc.cn
+					}
+				}
+				classes = append([]string{c}, classes...)
+				c, found = dc.superclass, true
+				break
+			}
+		}
+		if !found {
+			panic("demo class " + c + " is not in the schema")
+		}
+	}
+
+	o := &RawObject{
+		DistinguishedName: dn,
+		Attributes:        attributes,
+	}
+	if o.Attributes == nil {
+		o.Attributes = make(map[string][]string)
+	}
+	rdn := dn[strings.Index(dn, "=")+1:]
+	if comma := strings.Index(rdn, ","); comma != -1 {
+		rdn = rdn[:comma]
+	}
+	created := g.past(5 * 365)
+	o.Attributes["distinguishedName"] = []string{dn}
+	o.Attributes["name"] = []string{rdn}
+	o.Attributes["objectClass"] = classes
+	o.Attributes["objectCategory"] = []string{"CN=" + category + "," + g.schema}
+	o.Attributes["objectGUID"] = []string{g.guid()}
+	o.Attributes["whenCreated"] = []string{demoGeneralizedTime(created)}
+	o.Attributes["whenChanged"] = []string{demoGeneralizedTime(g.since(created))}
+	g.objects = append(g.objects, o)
+	return o
+}
+
+// Adds a security principal, with a SID from the domain unless one is given
+func (g *demoGenerator) addPrincipal(dn, class, samaccountname string, sid SID, attributes map[string][]string) *RawObject {
+	if sid == "" {
+		sid = g.domainsid.AddSubAuthority(g.nextrid)
+		g.nextrid++
+	}
+	o := g.add(dn, class, attributes)
+	o.Attributes["objectSid"] = []string{string(sid)}
+	if samaccountname != "" {
+		o.Attributes["sAMAccountName"] = []string{samaccountname}
+		g.samaccountnames[strings.ToLower(samaccountname)] = struct{}{}
+	}
+	g.sids[o] = sid
+	return o
+}
+
+func (g *demoGenerator) addGroup(dn, name string, sid SID, grouptype int32) *RawObject {
+	return g.addPrincipal(dn, "group", name, sid, map[string][]string{
+		"groupType":      {strconv.Itoa(int(grouptype))},
+		"sAMAccountType": {"268435456"},
+	})
+}
+
+func (g *demoGenerator) addUser(dn, samaccountname string, sid SID, uac int) *RawObject {
+	pwdlastset := g.past(400)
+	return g.addPrincipal(dn, "user", samaccountname, sid, map[string][]string{
+		"userAccountControl": {strconv.Itoa(uac)},
+		"sAMAccountType":     {"805306368"},
+		"primaryGroupID":     {"513"},
+		"userPrincipalName":  {samaccountname + "@" + g.options.Domain},
+		"pwdLastSet":         {demoFiletime(pwdlastset)},
+		"lastLogonTimestamp": {demoFiletime(g.since(pwdlastset))},
+	})
+}
+
+func (g *demoGenerator) addComputer(dn, name, operatingsystem string, uac int, primarygroup string) *RawObject {
+	hostname := strings.ToLower(name) + "." + g.options.Domain
+	return g.addPrincipal(dn, "computer", name+"$", "", map[string][]string{
+		"userAccountControl":   {strconv.Itoa(uac)},
+		"sAMAccountType":       {"805306369"},
+		"primaryGroupID":       {primarygroup},
+		"dNSHostName":          {hostname},
+		"operatingSystem":      {operatingsystem},
+		"servicePrincipalName": {"HOST/" + hostname, "HOST/" + name},
+		"pwdLastSet":           {demoFiletime(g.past(30))},
+		"lastLogonTimestamp":   {demoFiletime(g.past(14))},
+	})
+}
+
+// Adds a Windows Hello for Business key to the account, as the DN-Binary value in msDS-KeyCredentialLink. The device is
+// the objectGUID of a computer, or anything for a key someone else added
+func (g *demoGenerator) addKeyCredential(o *RawObject, device string, created time.Time) {
+	entry := func(identifier byte, data []byte) []byte {
+		header := []byte{0, 0, identifier}
+		binary.LittleEndian.PutUint16(header, uint16(len(data)))
+		return append(header, data...)
+	}
+	filetime := func(t time.Time) []byte {
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(t.Unix()+11644473600)*10000000)
+		return data
+	}
+	// A public key as BCRYPT_RSAKEY_BLOB, with made up modulus
+	material := append([]byte("RSA1"), make([]byte, 23+256)...)
+	g.rnd.Read(material[27:])
+	keyid := sha256.Sum256(material)
+
+	var rest []byte
+	rest = append(rest, entry(keyCredentialKeyMaterial, material)...)
+	rest = append(rest, entry(keyCredentialKeyUsage, []byte{KeyUsageNGC})...)
+	rest = append(rest, entry(keyCredentialKeySource, []byte{0})...)
+	rest = append(rest, entry(keyCredentialDeviceID, []byte(device))...)
+	rest = append(rest, entry(keyCredentialCustomKeyInformation, []byte{1, 0})...)
+	rest = append(rest, entry(keyCredentialLastLogon, filetime(g.since(created)))...)
+	rest = append(rest, entry(keyCredentialCreationTime, filetime(created))...)
+	// The hash is of the entries after it
+	keyhash := sha256.Sum256(rest)
+
+	blob := []byte{0, 2, 0, 0}
+	blob = append(blob, entry(keyCredentialKeyID, keyid[:])...)
+	blob = append(blob, entry(keyCredentialKeyHash, keyhash[:])...)
+	blob = append(blob, rest...)
+	o.Attributes["msDS-KeyCredentialLink"] = append(o.Attributes["msDS-KeyCredentialLink"], fmt.Sprintf("B:%d:%X:%s", len(blob)*2, blob, o.DistinguishedName))
+}
+
+// Makes a unique sAMAccountName from the name
+func (g *demoGenerator) accountName(name string) string {
+	name = strings.ToLower(name)
+	candidate := name
+	for i := 2; ; i++ {
+		if _, taken := g.samaccountnames[candidate]; !taken {
+			return candidate
+		}
+		candidate = name + strconv.Itoa(i)
+	}
+}
+
+func (g *demoGenerator) addMember(group, member *RawObject) {
+	for _, existing := range group.Attributes["member"] {
+		if existing == member.DistinguishedName {
+			return
+		}
+	}
+	group.Attributes["member"] = append(group.Attributes["member"], member.DistinguishedName)
+	member.Attributes["memberOf"] = append(member.Attributes["memberOf"], group.DistinguishedName)
+}
+
+func (g *demoGenerator) allow(o *RawObject, sid SID, mask uint32) {
+	g.aces[o] = append(g.aces[o], ACE{Type: ACETYPE_ACCESS_ALLOWED, Mask: mask, SID: sid})
+}
+
+func (g *demoGenerator) allowObject(o *RawObject, sid SID, mask uint32, objecttype uuid.UUID) {
+	g.aces[o] = append(g.aces[o], ACE{Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: mask, SID: sid, Flags: OBJECT_TYPE_PRESENT, ObjectType: objecttype})
+}
+
+func (g *demoGenerator) wellKnown(sid string) SID {
+	result, _ := SIDFromString(sid)
+	return result
+}
+
+// The Exchange security groups with a server in them, made the first time a weakness needs them
+func (g *demoGenerator) exchange() (permissions, server *RawObject) {
+	if g.exchangepermissions == nil {
+		const universalsecurity = -2147483640
+		ou := "OU=Microsoft Exchange Security Groups," + g.base
+		g.add(ou, "organizationalUnit", nil)
+		g.exchangepermissions = g.addGroup("CN=Exchange Windows Permissions,"+ou, "Exchange Windows Permissions", "", universalsecurity)
+		subsystem := g.addGroup("CN=Exchange Trusted Subsystem,"+ou, "Exchange Trusted Subsystem", "", universalsecurity)
+		g.addMember(g.exchangepermissions, subsystem)
+		g.exchangeserver = g.pick(g.servers)
+		g.addMember(subsystem, g.exchangeserver)
+	}
+	return g.exchangepermissions, g.exchangeserver
+}
+
+func (g *demoGenerator) generateSchema() {
+	g.add(g.config, "container", nil)
+	g.add(g.schema, "container", nil)
+	for _, class := range demoClasses {
+		u, _ := uuid.FromString(class.guid)
+		category := class.category
+		if category == "" {
+			category = class.cn
+		}
+		g.add("CN="+class.cn+","+g.schema, "classSchema", map[string][]string{
+			"lDAPDisplayName":       {class.name},
+			"schemaIDGUID":          {string(SwapUUIDEndianess(u).Bytes())},
+			"defaultObjectCategory": {"CN=" + category + "," + g.schema},
+		})
+	}
+	for _, attribute := range demoAttributes {
+		u, _ := uuid.FromString(attribute.guid)
+		o := g.add("CN="+attribute.cn+","+g.schema, "attributeSchema", map[string][]string{
+			"lDAPDisplayName": {attribute.name},
+			"schemaIDGUID":    {string(SwapUUIDEndianess(u).Bytes())},
+			"attributeSyntax": {attribute.syntax},
+		})
+		if attribute.name == "objectGUID" {
+			o.Attributes["rangeLower"] = []string{"16"}
+			o.Attributes["rangeUpper"] = []string{"16"}
+		}
+	}
+	g.add("CN=Extended-Rights,"+g.config, "container", nil)
+	for _, right := range demoRights {
+		g.add("CN="+right.cn+",CN=Extended-Rights,"+g.config, "controlAccessRight", map[string][]string{
+			"displayName": {right.name},
+			"rightsGuid":  {right.guid},
+		})
+	}
+
+	g.add("CN=WellKnown Security Principals,"+g.config, "container", nil)
+	for sid, name := range map[string]string{
+		"S-1-1-0":  "Everyone",
+		"S-1-5-9":  "Enterprise Domain Controllers",
+		"S-1-5-10": "Self",
+		"S-1-5-11": "Authenticated Users",
+		"S-1-5-18": "Local System",
+	} {
+		g.addPrincipal("CN="+name+",CN=WellKnown Security Principals,"+g.config, "foreignSecurityPrincipal", "", g.wellKnown(sid), nil)
+	}
+}
+
+func (g *demoGenerator) generateDomain() {
+	g.root = g.addPrincipal(g.base, "domainDNS", "", g.domainsid, map[string][]string{
+		"ms-DS-MachineAccountQuota": {"10"},
+		"dc":                        {strings.Split(g.options.Domain, ".")[0]},
+	})
+	for _, dn := range []string{"CN=Users", "CN=Computers", "CN=System", "CN=AdminSDHolder,CN=System", "CN=Policies,CN=System"} {
+		g.add(dn+","+g.base, "container", nil)
+	}
+	g.addPrincipal("CN=Builtin,"+g.base, "builtinDomain", "", g.wellKnown("S-1-5-32"), nil)
+	for _, ou := range []string{"Domain Controllers", "Servers", "Workstations", "Service Accounts", "Admins"} {
+		g.add("OU="+ou+","+g.base, "organizationalUnit", nil)
+	}
+	for _, department := range demoDepartments {
+		g.departmentunits = append(g.departmentunits, g.add("OU="+department+","+g.base, "organizationalUnit", nil))
+	}
+
+	// Group policies, the two default ones and one per department
+	gpo := func(guid, displayname string, linkedto ...string) {
+		dn := "CN={" + strings.ToUpper(guid) + "},CN=Policies,CN=System," + g.base
+		g.gpos = append(g.gpos, g.add(dn, "groupPolicyContainer", map[string][]string{
+			"displayName":    {displayname},
+			"gPCFileSysPath": {`\\` + g.options.Domain + `\SysVol\` + g.options.Domain + `\Policies\{` + strings.ToUpper(guid) + `}`},
+			"flags":          {"0"},
+		}))
+		for _, target := range linkedto {
+			for _, o := range g.objects {
+				if o.DistinguishedName == target {
+					o.Attributes["gPLink"] = []string{strings.Join(o.Attributes["gPLink"], "") + "[LDAP://" + dn + ";0]"}
+				}
+			}
+		}
+	}
+	gpo("31B2F340-016D-11D2-945F-00C04FB984F9", "Default Domain Policy", g.base)
+	gpo("6AC1786C-016D-11D2-945F-00C04FB984F9", "Default Domain Controllers Policy", "OU=Domain Controllers,"+g.base)
+	for _, ou := range g.departmentunits {
+		u, _ := uuid.FromBytes([]byte(g.guid()))
+		gpo(u.String(), ou.Attributes["name"][0]+" Settings", ou.DistinguishedName)
+	}
+	gpo("8F2E4C9A-35B1-4D6E-9A7C-2B3D4E5F6071", "Workstation Baseline", "OU=Workstations,"+g.base)
+	gpo("1C9D7E3B-6A2F-4B8E-8D5C-7E6F5A4B3C21", "Server Baseline", "OU=Servers,"+g.base)
+}
+
+func (g *demoGenerator) generatePrincipals() {
+	users := "CN=Users," + g.base
+	builtin := "CN=Builtin," + g.base
+	const globalsecurity, builtinsecurity = -2147483646, -2147483643
+
+	// Built in groups and accounts
+	for _, bg := range []struct {
+		rid        int
+		name       string
+		privileged bool
+	}{
+		{544, "Administrators", true}, {545, "Users", false}, {548, "Account Operators", true},
+		{549, "Server Operators", true}, {550, "Print Operators", true}, {551, "Backup Operators", true},
+		{555, "Remote Desktop Users", false},
+	} {
+		group := g.addGroup("CN="+bg.name+","+builtin, bg.name, g.wellKnown("S-1-5-32-"+strconv.Itoa(bg.rid)), builtinsecurity)
+		if bg.privileged {
+			group.Attributes["adminCount"] = []string{"1"}
+			g.privilegedgroups = append(g.privilegedgroups, group)
+		}
+	}
+	domaingroup := func(rid uint32, name string, privileged bool) *RawObject {
+		group := g.addGroup("CN="+name+","+users, name, g.domainsid.AddSubAuthority(rid), globalsecurity)
+		if privileged {
+			group.Attributes["adminCount"] = []string{"1"}
+			g.privilegedgroups = append(g.privilegedgroups, group)
+		}
+		return group
+	}
+	domainadmins := domaingroup(512, "Domain Admins", true)
+	domaingroup(513, "Domain Users", false)
+	domaingroup(514, "Domain Guests", false)
+	domaingroup(515, "Domain Computers", false)
+	domaingroup(516, "Domain Controllers", true)
+	schemaadmins := domaingroup(518, "Schema Admins", true)
+	enterpriseadmins := domaingroup(519, "Enterprise Admins", true)
+	domaingroup(520, "Group Policy Creator Owners", false)
+	administrators := g.privilegedgroups[0]
+	g.addMember(administrators, domainadmins)
+	g.addMember(administrators, enterpriseadmins)
+
+	administrator := g.addUser("CN=Administrator,"+users, "Administrator", g.domainsid.AddSubAuthority(500), UAC_NORMAL_ACCOUNT|UAC_DONT_EXPIRE_PASSWORD)
+	administrator.Attributes["adminCount"] = []string{"1"}
+	for _, group := range []*RawObject{domainadmins, enterpriseadmins, schemaadmins, administrators} {
+		g.addMember(group, administrator)
+	}
+	g.admins = append(g.admins, administrator)
+	g.addUser("CN=Guest,"+users, "Guest", g.domainsid.AddSubAuthority(501), UAC_NORMAL_ACCOUNT|UAC_ACCOUNTDISABLE|UAC_PASSWD_NOTREQD)
+	g.addUser("CN=krbtgt,"+users, "krbtgt", g.domainsid.AddSubAuthority(502), UAC_NORMAL_ACCOUNT|UAC_ACCOUNTDISABLE)
+
+	// Domain controllers
+	for i := 1; i <= 2; i++ {
+		name := fmt.Sprintf("DC%02d", i)
+		g.domaincontrollers = append(g.domaincontrollers, g.addComputer("CN="+name+",OU=Domain Controllers,"+g.base, name, "Windows Server 2019 Datacenter", UAC_SERVER_TRUST_ACCOUNT|UAC_TRUSTED_FOR_DELEGATION, "516"))
+	}
+
+	// Groups for departments and projects
+	for _, ou := range g.departmentunits {
+		department := ou.Attributes["name"][0]
+		g.departmentgroups = append(g.departmentgroups, g.addGroup("CN="+department+" Users,"+ou.DistinguishedName, g.accountName(department+"-users"), "", globalsecurity))
+	}
+	var projectgroups []*RawObject
+	for i := 0; i < g.options.Users/25; i++ {
+		name := demoProjects[i%len(demoProjects)] + " Project"
+		if i >= len(demoProjects) {
+			name += " " + strconv.Itoa(i/len(demoProjects)+1)
+		}
+		ou := g.pick(g.departmentunits)
+		projectgroups = append(projectgroups, g.addGroup("CN="+name+","+ou.DistinguishedName, g.accountName(strings.Replace(name, " ", "-", -1)), "", globalsecurity))
+	}
+
+	// People
+	for i := 0; i < g.options.Users; i++ {
+		department := g.rnd.Intn(len(demoDepartments))
+		first := demoFirstNames[g.rnd.Intn(len(demoFirstNames))]
+		last := demoLastNames[g.rnd.Intn(len(demoLastNames))]
+		samaccountname := g.accountName(first + "." + last)
+		uac := UAC_NORMAL_ACCOUNT
+		if g.rnd.Intn(20) == 0 {
+			uac |= UAC_ACCOUNTDISABLE
+		}
+		if g.rnd.Intn(10) == 0 {
+			uac |= UAC_DONT_EXPIRE_PASSWORD
+		}
+		user := g.addUser("CN="+first+" "+last+" ("+samaccountname+"),"+g.departmentunits[department].DistinguishedName, samaccountname, "", uac)
+		user.Attributes["displayName"] = []string{first + " " + last}
+		user.Attributes["givenName"] = []string{first}
+		user.Attributes["sn"] = []string{last}
+		user.Attributes["department"] = []string{demoDepartments[department]}
+		user.Attributes["mail"] = []string{samaccountname + "@" + g.options.Domain}
+		g.addMember(g.departmentgroups[department], user)
+		for j := g.rnd.Intn(3); j > 0 && len(projectgroups) > 0; j-- {
+			g.addMember(g.pick(projectgroups), user)
+		}
+		g.users = append(g.users, user)
+
+		// Some IT people have a separate admin account
+		if demoDepartments[department] == "IT" && len(g.admins) < 2+g.options.Users/200 {
+			admin := g.addUser("CN=adm-"+samaccountname+",OU=Admins,"+g.base, g.accountName("adm-"+samaccountname), "", UAC_NORMAL_ACCOUNT)
+			admin.Attributes["displayName"] = []string{first + " " + last + " (admin)"}
+			admin.Attributes["adminCount"] = []string{"1"}
+			g.addMember(domainadmins, admin)
+			g.admins = append(g.admins, admin)
+		}
+	}
+
+	// Service accounts
+	for _, service := range []string{"sql", "backup", "web", "sccm"} {
+		samaccountname := g.accountName("svc-" + service)
+		g.users = append(g.users, g.addUser("CN="+samaccountname+",OU=Service Accounts,"+g.base, samaccountname, "", UAC_NORMAL_ACCOUNT|UAC_DONT_EXPIRE_PASSWORD))
+	}
+
+	// Computers, with Windows LAPS on the workstations where IT support can read the passwords
+	var itsupport SID
+	for i, department := range demoDepartments {
+		if department == "IT" {
+			itsupport = g.sids[g.departmentgroups[i]]
+		}
+	}
+	lapspassword := uuid.Must(uuid.FromString(demoAttributeGUID("msLAPS-Password")))
+	var workstations []*RawObject
+	for i := 1; i <= g.options.Users/2+1; i++ {
+		name := fmt.Sprintf("WS%04d", i)
+		workstation := g.addComputer("CN="+name+",OU=Workstations,"+g.base, name, "Windows 10 Enterprise", UAC_WORKSTATION_TRUST_ACCOUNT, "515")
+		workstation.Attributes["msLAPS-PasswordExpirationTime"] = []string{demoFiletime(g.options.Now.AddDate(0, 0, 30))}
+		g.allowObject(workstation, itsupport, RIGHT_DS_READ_PROPERTY|RIGHT_DS_CONTROL_ACCESS, lapspassword)
+		workstations = append(workstations, workstation)
+	}
+	// Hybrid joined workstations have a key of their own, and some people use Windows Hello for Business on theirs
+	for _, workstation := range workstations {
+		if g.rnd.Intn(4) == 0 {
+			g.addKeyCredential(workstation, workstation.Attributes["objectGUID"][0], g.past(365))
+		}
+	}
+	for _, user := range g.users {
+		if g.rnd.Intn(10) == 0 {
+			g.addKeyCredential(user, g.pick(workstations).Attributes["objectGUID"][0], g.past(365))
+		}
+	}
+	for i := 1; i <= g.options.Users/20+2; i++ {
+		name := fmt.Sprintf("SRV%03d", i)
+		g.servers = append(g.servers, g.addComputer("CN="+name+",OU=Servers,"+g.base, name, "Windows Server 2016 Standard", UAC_WORKSTATION_TRUST_ACCOUNT, "515"))
+	}
+
+	// Group managed service accounts, with the servers running the service allowed to retrieve the password
+	for _, service := range []string{"web", "sql"} {
+		samaccountname := g.accountName("gmsa-"+service) + "$"
+		gmsa := g.addPrincipal("CN="+strings.TrimSuffix(samaccountname, "$")+",OU=Service Accounts,"+g.base, "msDS-GroupManagedServiceAccount", samaccountname, "", map[string][]string{
+			"userAccountControl":   {strconv.Itoa(UAC_WORKSTATION_TRUST_ACCOUNT)},
+			"sAMAccountType":       {"805306369"},
+			"primaryGroupID":       {"515"},
+			"servicePrincipalName": {"HTTP/" + service + "." + g.options.Domain},
+			"pwdLastSet":           {demoFiletime(g.past(30))},
+		})
+		var readers SecurityDescriptor
+		readers.Owner = g.wellKnown("S-1-5-32-544")
+		for i := 0; i < 2; i++ {
+			readers.DACL.Entries = append(readers.DACL.Entries, ACE{Type: ACETYPE_ACCESS_ALLOWED, Mask: demoFullControl, SID: g.sids[g.pick(g.servers)]})
+		}
+		gmsa.Attributes["msDS-GroupMSAMembership"] = []string{string(readers.Bytes())}
+	}
+}
+
+// The permissions a fresh domain has, plus whatever the misconfigurations added
+func (g *demoGenerator) generateSecurityDescriptors() {
+	domainadmins := g.domainsid.AddSubAuthority(512)
+	enterpriseadmins := g.domainsid.AddSubAuthority(519)
+	accountoperators := g.wellKnown("S-1-5-32-548")
+	authenticatedusers := g.wellKnown("S-1-5-11")
+	self := g.wellKnown("S-1-5-10")
+	system := g.wellKnown("S-1-5-18")
+
+	for _, dc := range []SID{g.domainsid.AddSubAuthority(516), g.wellKnown("S-1-5-9"), g.wellKnown("S-1-5-32-544")} {
+		g.allowObject(g.root, dc, RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChanges)
+		g.allowObject(g.root, dc, RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChangesAll)
+	}
+
+	for _, o := range g.objects {
+		var aces []ACE
+		add := func(sid SID, mask uint32) {
+			aces = append(aces, ACE{Type: ACETYPE_ACCESS_ALLOWED, Mask: mask, SID: sid})
+		}
+		add(domainadmins, demoFullControl)
+		add(enterpriseadmins, demoFullControl)
+		add(system, demoFullControl)
+		add(authenticatedusers, RIGHT_READ_CONTROL|RIGHT_DS_LIST_CONTENTS|RIGHT_DS_READ_PROPERTY|RIGHT_DS_LIST_OBJECT)
+
+		if _, principal := g.sids[o]; principal && len(o.Attributes["adminCount"]) == 0 {
+			// Protected accounts and groups get the permissions from AdminSDHolder, which doesn't include Account Operators
+			switch o.Attributes["objectClass"][len(o.Attributes["objectClass"])-1] {
+			case "user", "computer", "group":
+				add(accountoperators, demoFullControl)
+			}
+		}
+		if StringInSlice("user", o.Attributes["objectClass"]) {
+			aces = append(aces, ACE{Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_CONTROL_ACCESS, SID: self, Flags: OBJECT_TYPE_PRESENT, ObjectType: uuid.Must(uuid.FromString("ab721a53-1e2f-11d0-9819-00aa0040529b"))})
+		}
+		if StringInSlice("groupPolicyContainer", o.Attributes["objectClass"]) {
+			// Without security filtering a GPO applies to everyone
+			aces = append(aces, ACE{Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_CONTROL_ACCESS, SID: authenticatedusers, Flags: OBJECT_TYPE_PRESENT, ObjectType: ExtendedRightApplyGroupPolicy})
+		}
+		aces = append(aces, g.aces[o]...)
+
+		owner, found := g.owners[o]
+		if !found {
+			owner = domainadmins
+		}
+		sd := SecurityDescriptor{
+			Owner: owner,
+			Group: domainadmins,
+			DACL:  ACL{Entries: aces},
+		}
+		o.Attributes["nTSecurityDescriptor"] = []string{string(sd.Bytes())}
+	}
+}
+
+func demoName(o *RawObject) string {
+	if name := o.Attributes["sAMAccountName"]; len(name) > 0 {
+		return name[0]
+	}
+	if name := o.Attributes["displayName"]; len(name) > 0 {
+		return name[0]
+	}
+	return o.Attributes["name"][0]
+}
+
+// Weaknesses that are put into the made up directory, each returns a description of what it did
+var demoMisconfigurations = []struct {
+	name   string
+	inject func(g *demoGenerator) string
+}{
+	{"kerberoastable", func(g *demoGenerator) string {
+		user := g.pick(g.users)
+		user.Attributes["servicePrincipalName"] = append(user.Attributes["servicePrincipalName"], "MSSQLSvc/"+strings.ToLower(demoName(g.pick(g.servers)))+"."+g.options.Domain+":1433")
+		if g.rnd.Intn(2) == 0 {
+			g.addMember(g.pick(g.privilegedgroups), user)
+			return fmt.Sprintf("%v has an SPN and is in a privileged group (%v)", demoName(user), user.Attributes["memberOf"][len(user.Attributes["memberOf"])-1])
+		}
+		return fmt.Sprintf("%v has an SPN, so anyone can get a ticket to crack offline", demoName(user))
+	}},
+	{"asreproastable", func(g *demoGenerator) string {
+		user := g.pick(g.users)
+		uac, _ := strconv.Atoi(user.Attributes["userAccountControl"][0])
+		user.Attributes["userAccountControl"] = []string{strconv.Itoa(uac | UAC_DONT_REQ_PREAUTH)}
+		return fmt.Sprintf("%v doesn't require Kerberos preauthentication", demoName(user))
+	}},
+	{"genericall-on-group", func(g *demoGenerator) string {
+		user, group := g.pick(g.users), g.pick(g.privilegedgroups)
+		g.allow(group, g.sids[user], demoFullControl)
+		return fmt.Sprintf("%v has full control of %v", demoName(user), demoName(group))
+	}},
+	{"unconstrained-delegation", func(g *demoGenerator) string {
+		server := g.pick(g.servers)
+		uac, _ := strconv.Atoi(server.Attributes["userAccountControl"][0])
+		server.Attributes["userAccountControl"] = []string{strconv.Itoa(uac | UAC_TRUSTED_FOR_DELEGATION)}
+		return fmt.Sprintf("%v is trusted for unconstrained delegation", demoName(server))
+	}},
+	{"constrained-delegation", func(g *demoGenerator) string {
+		server, dc := g.pick(g.servers), g.pick(g.domaincontrollers)
+		uac, _ := strconv.Atoi(server.Attributes["userAccountControl"][0])
+		server.Attributes["userAccountControl"] = []string{strconv.Itoa(uac | UAC_TRUSTED_TO_AUTH_FOR_DELEGATION)}
+		server.Attributes["msDS-AllowedToDelegateTo"] = append(server.Attributes["msDS-AllowedToDelegateTo"], "ldap/"+dc.Attributes["dNSHostName"][0])
+		return fmt.Sprintf("%v can delegate to LDAP on %v with protocol transition", demoName(server), demoName(dc))
+	}},
+	{"reset-password-on-admin", func(g *demoGenerator) string {
+		group, admin := g.pick(g.departmentgroups), g.pick(g.admins)
+		g.allowObject(admin, g.sids[group], RIGHT_DS_CONTROL_ACCESS, ResetPwd)
+		return fmt.Sprintf("Members of %v can reset the password of %v", demoName(group), demoName(admin))
+	}},
+	{"nested-privileged-group", func(g *demoGenerator) string {
+		group, privileged := g.pick(g.departmentgroups), g.pick(g.privilegedgroups)
+		g.addMember(privileged, group)
+		return fmt.Sprintf("%v is a member of %v", demoName(group), demoName(privileged))
+	}},
+	{"dcsync", func(g *demoGenerator) string {
+		user := g.pick(g.users)
+		g.allowObject(g.root, g.sids[user], RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChanges)
+		g.allowObject(g.root, g.sids[user], RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChangesAll)
+		return fmt.Sprintf("%v can replicate password hashes from the domain (DCSync)", demoName(user))
+	}},
+	{"sid-history", func(g *demoGenerator) string {
+		user, group := g.pick(g.users), g.pick(g.privilegedgroups)
+		user.Attributes["sIDHistory"] = append(user.Attributes["sIDHistory"], string(g.sids[group]))
+		return fmt.Sprintf("%v has the SID of %v in sIDHistory", demoName(user), demoName(group))
+	}},
+	{"exchange-windows-permissions", func(g *demoGenerator) string {
+		permissions, server := g.exchange()
+		g.allow(g.root, g.sids[permissions], RIGHT_WRITE_DACL)
+		return fmt.Sprintf("%v can change the permissions on the domain, and %v is an Exchange server in it", demoName(permissions), demoName(server))
+	}},
+	{"mailbox-full-access", func(g *demoGenerator) string {
+		user, admin := g.pick(g.users), g.pick(g.admins)
+		var mailbox SecurityDescriptor
+		if existing := admin.Attributes["msExchMailboxSecurityDescriptor"]; len(existing) > 0 {
+			mailbox, _ = ParseSecurityDescriptor([]byte(existing[0]))
+		} else {
+			mailbox.Owner = g.wellKnown("S-1-5-10")
+			mailbox.DACL.Entries = []ACE{{Type: ACETYPE_ACCESS_ALLOWED, Mask: mailboxRightFullAccess | mailboxRightChangePermission | RIGHT_READ_CONTROL, SID: g.wellKnown("S-1-5-10")}}
+		}
+		mailbox.DACL.Entries = append(mailbox.DACL.Entries, ACE{Type: ACETYPE_ACCESS_ALLOWED, Mask: mailboxRightFullAccess | RIGHT_READ_CONTROL, SID: g.sids[user]})
+		admin.Attributes["msExchMailboxSecurityDescriptor"] = []string{string(mailbox.Bytes())}
+		admin.Attributes["msExchDelegateListLink"] = append(admin.Attributes["msExchDelegateListLink"], user.DistinguishedName)
+		return fmt.Sprintf("%v has full access to the mailbox of %v", demoName(user), demoName(admin))
+	}},
+	{"writable-gpo", func(g *demoGenerator) string {
+		group, gpo := g.pick(g.departmentgroups), g.gpos[g.rnd.Intn(2)]
+		g.allow(gpo, g.sids[group], RIGHT_DS_WRITE_PROPERTY|RIGHT_GENERIC_WRITE)
+		return fmt.Sprintf("Members of %v can change %v", demoName(group), demoName(gpo))
+	}},
+	{"computer-owner", func(g *demoGenerator) string {
+		user, server := g.pick(g.users), g.pick(g.servers)
+		g.owners[server] = g.sids[user]
+		return fmt.Sprintf("%v owns %v, because they joined it to the domain", demoName(user), demoName(server))
+	}},
+	{"write-keycredentiallink", func(g *demoGenerator) string {
+		group, admin := g.pick(g.departmentgroups), g.pick(g.admins)
+		g.allowObject(admin, g.sids[group], RIGHT_DS_WRITE_PROPERTY, AttributeMSDSKeyCredentialLink)
+		return fmt.Sprintf("Members of %v can add shadow credentials to %v", demoName(group), demoName(admin))
+	}},
+	{"shadow-credentials", func(g *demoGenerator) string {
+		user := g.pick(g.users)
+		if g.rnd.Intn(2) == 0 {
+			user = g.pick(g.admins)
+		}
+		g.addKeyCredential(user, g.guid(), g.past(IRDays))
+		return fmt.Sprintf("%v has a key credential from a device that doesn't exist, someone can log on as them", demoName(user))
+	}},
+	{"writedacl-on-ou", func(g *demoGenerator) string {
+		user := g.pick(g.users)
+		for _, o := range g.objects {
+			if o.DistinguishedName == "OU=Admins,"+g.base {
+				g.allow(o, g.sids[user], RIGHT_WRITE_DACL)
+				g.aces[o][len(g.aces[o])-1].ACEFlags = ACEFLAG_INHERIT_ACE
+			}
+		}
+		return fmt.Sprintf("%v can change permissions on the OU with the admin accounts", demoName(user))
+	}},
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Detection content for the attack paths that can't be closed right away. Each rule watches for exploitation of
+// one kind of connection, with the accounts and objects from the data filled in, and comes with what to set up in
+// Microsoft Defender for Identity and auditing for it to work
+
+type sigmaField struct {
+	Field  string
+	Values []string
+}
+
+type detectionRule struct {
+	Name        string
+	Title       string
+	Description string
+	Level       string
+	Suggestion  string // Defender for Identity and auditing
+	Expected    string // Known false positives
+	Sources     []*Object
+	Targets     []*Object
+	Selection   []sigmaField
+	Filter      []sigmaField
+}
+
+// Replication rights on the domain, the last one is for read only domain controllers
+var detectionReplicationGUIDs = []string{
+	"1131f6aa-9c07-11d1-f79f-00c04fc2dcd2",
+	"1131f6ad-9c07-11d1-f79f-00c04fc2dcd2",
+	"89e95b76-444d-4c62-991a-0facbeda640c",
+}
+
+// Principals that aren't supposed to do this, leaving out admins, domain controllers and the default key admins
+func detectionUnexpected(o *Object) bool {
+	sid := o.SID()
+	if sid.IsNull() {
+		return !o.IsTier0()
+	}
+	return !irExpected(sid) && !adcsIsDCPrincipal(o)
+}
+
+// Returns the connections made with the method, from sources that aren't supposed to have them
+func detectionEdges(method PwnMethod) (sources, targets []*Object) {
+	seensources := make(map[*Object]struct{})
+	for _, target := range AllObjects.AsArray() {
+		var found bool
+		for _, pwninfo := range target.PwnableBySnapshot() {
+			source := pwninfo.Target
+			if pwninfo.Method&method == 0 || source == target || !detectionUnexpected(source) {
+				continue
+			}
+			found = true
+			if _, seen := seensources[source]; !seen {
+				seensources[source] = struct{}{}
+				sources = append(sources, source)
+			}
+		}
+		if found {
+			targets = append(targets, target)
+		}
+	}
+	return sources, targets
+}
+
+// Returns the sAMAccountNames of the accounts, with the members of groups included as events name the account
+func detectionAccountNames(principals []*Object) []string {
+	names := make(map[string]struct{})
+	seen := make(map[*Object]struct{})
+	queue := append([]*Object(nil), principals...)
+	for len(queue) > 0 {
+		o := queue[0]
+		queue = queue[1:]
+		if _, found := seen[o]; found {
+			continue
+		}
+		seen[o] = struct{}{}
+		if o.Type() == ObjectTypeGroup {
+			queue = append(queue, o.Members(false)...)
+			continue
+		}
+		if name := o.OneAttr(SAMAccountName); name != "" {
+			names[name] = struct{}{}
+		}
+	}
+	return sortedKeys(names)
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	results := make([]string, 0, len(set))
+	for key := range set {
+		results = append(results, key)
+	}
+	sort.Strings(results)
+	return results
+}
+
+func detectionLabels(objects []*Object) string {
+	var labels []string
+	for _, o := range objects {
+		labels = append(labels, o.Label())
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ", ")
+}
+
+// Returns the rules for the connections found in the data, rules without anything to watch are left out
+func detectionRules() []detectionRule {
+	var rules []detectionRule
+
+	if domain, found := AllObjects.Find(AllObjects.Base); found {
+		var holders []*Object
+		for _, holder := range dcsyncHolders(domain) {
+			if detectionUnexpected(holder) {
+				holders = append(holders, holder)
+			}
+		}
+		if accounts := detectionAccountNames(holders); len(accounts) > 0 {
+			rules = append(rules, detectionRule{
+				Name:        "DCSync",
+				Title:       "Replication of directory secrets by an account with DCSync rights that isn't a domain controller",
+				Description: "Holders of the replication rights on the domain besides domain controllers and admins: " + detectionLabels(holders),
+				Level:       "critical",
+				Suggestion:  "Defender for Identity alerts on replication requests from computers that aren't domain controllers (Suspected DCSync attack), tag the accounts as sensitive. Event 4662 needs auditing of Directory Service Access on the domain root",
+				Expected:    "Entra Connect (MSOL_ accounts) replicating password hashes for password hash sync",
+				Sources:     holders,
+				Targets:     []*Object{domain},
+				Selection: []sigmaField{
+					{"EventID", []string{"4662"}},
+					{"AccessMask", []string{"0x100"}},
+					{"Properties|contains", detectionReplicationGUIDs},
+					{"SubjectUserName", accounts},
+				},
+			})
+		}
+	}
+
+	var delegated, delegators []*Object
+	for _, o := range AllObjects.AsArray() {
+		rawsd := o.OneAttr(MSDSAllowedToActOnBehalf)
+		if rawsd == "" {
+			continue
+		}
+		sd, err := ParseSecurityDescriptor([]byte(rawsd))
+		if err != nil {
+			continue
+		}
+		delegated = append(delegated, o)
+		for _, acl := range sd.DACL.Entries {
+			delegators = append(delegators, AllObjects.FindOrAddSID(acl.SID))
+		}
+	}
+	if services := detectionAccountNames(delegated); len(services) > 0 {
+		rules = append(rules, detectionRule{
+			Name:        "RBCD",
+			Title:       "Service ticket through resource based constrained delegation to a computer that has it configured",
+			Description: "Computers with msDS-AllowedToActOnBehalfOfOtherIdentity: " + detectionLabels(delegated) + " - allowed to delegate to them: " + detectionLabels(delegators),
+			Level:       "high",
+			Suggestion:  "Tag the computers as sensitive in Defender for Identity, and check that the delegation is still needed. Event 4769 needs auditing of Kerberos Service Ticket Operations on the domain controllers",
+			Expected:    "The services the delegation was configured for",
+			Sources:     delegators,
+			Targets:     delegated,
+			Selection: []sigmaField{
+				{"EventID", []string{"4769"}},
+				{"ServiceName", services},
+			},
+			Filter: []sigmaField{
+				{"TransitedServices", []string{"-"}},
+			},
+		})
+	}
+
+	for _, watched := range []struct {
+		method              PwnMethod
+		name, title, attr   string
+		level, what, advice string
+		expected            string
+	}{
+		{PwnWriteAllowedToAct, "RBCDWrite", "Resource based constrained delegation configured on a computer by an account that isn't an admin", "msDS-AllowedToActOnBehalfOfOtherIdentity",
+			"high", "Can configure resource based constrained delegation", "Defender for Identity needs Directory Service Changes auditing with a SACL auditing writes of msDS-AllowedToActOnBehalfOfOtherIdentity on the computers to see event 5136",
+			"Admins setting up delegation on purpose"},
+		{PwnWriteKeyCredentialLink, "ShadowCredentials", "Shadow credentials added to an account by writing msDS-KeyCredentialLink", "msDS-KeyCredentialLink",
+			"high", "Can add shadow credentials", "Audit writes of msDS-KeyCredentialLink with a SACL on the accounts so event 5136 is logged, and tag them as sensitive in Defender for Identity. Computers writing their own key with Windows Hello for Business is normal",
+			"Windows Hello for Business and device registration writing keys for their own accounts"},
+	} {
+		sources, targets := detectionEdges(watched.method)
+		if len(targets) == 0 {
+			continue
+		}
+		var dns []string
+		for _, target := range targets {
+			dns = append(dns, target.DN())
+		}
+		sort.Strings(dns)
+		rules = append(rules, detectionRule{
+			Name:        watched.name,
+			Title:       watched.title,
+			Description: watched.what + ": " + detectionLabels(sources),
+			Level:       watched.level,
+			Suggestion:  watched.advice,
+			Expected:    watched.expected,
+			Sources:     sources,
+			Targets:     targets,
+			Selection: []sigmaField{
+				{"EventID", []string{"5136"}},
+				{"AttributeLDAPDisplayName", []string{watched.attr}},
+				{"ObjectDN", dns},
+			},
+		})
+	}
+	return rules
+}
+
+func sigmaQuote(value string) string {
+	if _, err := strconv.Atoi(value); err == nil {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func writeSigmaFields(w io.Writer, name string, fields []sigmaField) {
+	fmt.Fprintf(w, "    %v:\n", name)
+	for _, field := range fields {
+		if len(field.Values) == 1 {
+			fmt.Fprintf(w, "        %v: %v\n", field.Field, sigmaQuote(field.Values[0]))
+			continue
+		}
+		fmt.Fprintf(w, "        %v:\n", field.Field)
+		for _, value := range field.Values {
+			fmt.Fprintf(w, "            - %v\n", sigmaQuote(value))
+		}
+	}
+}
+
+// Writes the rules as a Sigma rule collection, with ids that stay the same for the same domain
+func WriteSigmaRules(w io.Writer, rules []detectionRule) {
+	date := dataTimestamp()
+	if date.IsZero() {
+		date = time.Now()
+	}
+	for i, rule := range rules {
+		if i > 0 {
+			fmt.Fprintln(w, "---")
+		}
+		id := uuid.NewV5(uuid.NamespaceURL, "adalanche/"+strings.ToLower(AllObjects.Base)+"/"+rule.Name)
+		fmt.Fprintf(w, "title: %v\n", sigmaQuote(rule.Title))
+		fmt.Fprintf(w, "id: %v\n", id)
+		fmt.Fprintf(w, "status: experimental\n")
+		fmt.Fprintf(w, "description: %v\n", sigmaQuote(rule.Description+". "+rule.Suggestion))
+		fmt.Fprintf(w, "author: adalanche\n")
+		fmt.Fprintf(w, "date: %v\n", date.Format("2006-01-02"))
+		fmt.Fprintf(w, "tags:\n    - attack.credential_access\n    - attack.privilege_escalation\n")
+		fmt.Fprintf(w, "logsource:\n    product: windows\n    service: security\n")
+		fmt.Fprintf(w, "detection:\n")
+		writeSigmaFields(w, "selection", rule.Selection)
+		condition := "selection"
+		if len(rule.Filter) > 0 {
+			writeSigmaFields(w, "filter", rule.Filter)
+			condition = "selection and not filter"
+		}
+		fmt.Fprintf(w, "    condition: %v\n", condition)
+		fmt.Fprintf(w, "falsepositives:\n    - %v\n", sigmaQuote(rule.Expected))
+		fmt.Fprintf(w, "level: %v\n", rule.Level)
+	}
+}
+
+func ExportDetections(filename string) (int, error) {
+	rules := detectionRules()
+	df, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer df.Close()
+	WriteSigmaRules(df, rules)
+	return len(rules), nil
+}
+
+func detectionsReport() []Finding {
+	var findings []Finding
+	for _, rule := range detectionRules() {
+		for _, target := range rule.Targets {
+			findings = append(findings, Finding{target.DN(), rule.Name + " - " + rule.Description + " - " + rule.Suggestion})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Ports a domain controller normally listens on, and what they're for
+var diagnosePorts = []struct {
+	Port        int
+	Description string
+}{
+	{389, "LDAP"},
+	{636, "LDAPS"},
+	{3268, "Global Catalog"},
+	{3269, "Global Catalog over TLS"},
+	{9389, "AD Web Services"},
+}
+
+// One way of connecting to test, and how it went
+type diagnoseResult struct {
+	Transport TLSmode
+	Port      uint16
+	AuthMode  byte
+	Err       error
+}
+
+func (dr diagnoseResult) score() int {
+	var score int
+	if dr.Transport != NoTLS {
+		score += 2
+	}
+	if mode := AuthModeString(dr.AuthMode); mode != "simple" && mode != "unauth" {
+		score++
+	}
+	return score
+}
+
+// Checks each way of connecting to the domain controllers, and explains why the ones that don't work fail. If
+// server is blank the domain controllers are found in DNS. Bind modes needing credentials are only tried if a user
+// is given
+func RunDiagnose(domain, server, user, password, authdomain string) error {
+	servers := []string{server}
+	if server == "" {
+		servers = FindDomainControllers(domain)
+		if len(servers) == 0 {
+			log.Error().Msgf("DNS: no domain controllers found for %v (_ldap._tcp.dc._msdcs.%v), is this machine using the domain DNS servers? Use -server to test a specific one", domain, domain)
+			return nil
+		}
+		log.Info().Msgf("DNS: found %v domain controllers for %v: %v", len(servers), domain, strings.Join(servers, ", "))
+	}
+
+	var modes []byte
+	if user != "" {
+		for _, modename := range []string{"simple", "md5", "ntlm"} {
+			authmode, _ := ParseAuthMode(modename)
+			modes = append(modes, authmode)
+		}
+		if len(password) == 32 && !strings.ContainsAny(strings.ToLower(password), "ghijklmnopqrstuvwxyz") {
+			// Looks like an NT hash
+			authmode, _ := ParseAuthMode("ntlmpth")
+			modes = append(modes, authmode)
+		}
+	} else {
+		log.Info().Msg("No username given, only testing anonymous and integrated binds")
+		authmode, _ := ParseAuthMode("unauth")
+		modes = append(modes, authmode)
+	}
+	if runtime.GOOS == "windows" {
+		authmode, _ := ParseAuthMode("ntlmsspi")
+		modes = append(modes, authmode)
+	}
+	if os.Getenv("KRB5CCNAME") != "" {
+		authmode, _ := ParseAuthMode("gssapi")
+		modes = append(modes, authmode)
+	}
+
+	for _, server := range servers {
+		log.Info().Msgf("--- %v ---", server)
+
+		open := make(map[int]bool)
+		for _, port := range diagnosePorts {
+			start := time.Now()
+			if err := probePort(server, port.Port); err != nil {
+				log.Warn().Msgf("Port %v (%v): not reachable - %v", port.Port, port.Description, err)
+				continue
+			}
+			open[port.Port] = true
+			log.Info().Msgf("Port %v (%v): open, %v", port.Port, port.Description, time.Since(start).Round(time.Millisecond))
+		}
+
+		// Clock, the rootDSE can be read with an anonymous bind
+		for _, port := range []int{389, 636} {
+			if !open[port] {
+				continue
+			}
+			ad := AD{Domain: domain, Server: server, Port: uint16(port), TLSMode: NoTLS, IgnoreCert: true}
+			if port == 636 {
+				ad.TLSMode = TLS
+			}
+			if err := ad.Connect(0); err != nil {
+				log.Warn().Msgf("Clock: could not read the time from %v - %v", server, explainBindError(err))
+				break
+			}
+			if skew, err := ad.CheckClockSkew(); err != nil {
+				log.Warn().Msgf("Clock: could not read the time from %v - %v", server, err)
+			} else if skew <= MaxClockSkew && skew >= -MaxClockSkew {
+				log.Info().Msgf("Clock: local clock is %v off, within the %v Kerberos allows", skew, MaxClockSkew)
+			}
+			ad.Disconnect()
+			break
+		}
+
+		var trusted bool
+		for _, port := range []int{636, 3269} {
+			if !open[port] {
+				continue
+			}
+			chain, trusterr, err := probeTLS(server, port)
+			if err != nil {
+				log.Warn().Msgf("Port %v: TLS handshake failed - %v", port, err)
+				continue
+			}
+			if len(chain) > 0 {
+				cert := chain[0]
+				log.Info().Msgf("Port %v: certificate for %v issued by %v, valid until %v",
+					port, cert.Subject.CommonName, cert.Issuer.CommonName, cert.NotAfter.Format("2006-01-02"))
+				if time.Now().After(cert.NotAfter) {
+					log.Warn().Msgf("Port %v: certificate expired %v", port, cert.NotAfter.Format("2006-01-02"))
+				}
+			}
+			if trusterr != nil {
+				log.Warn().Msgf("Port %v: certificate is not trusted, -ignorecert is needed - %v", port, trusterr)
+			} else if port == 636 {
+				trusted = true
+			}
+		}
+
+		var tests []diagnoseResult
+		if open[389] {
+			for _, transport := range []TLSmode{NoTLS, StartTLS} {
+				for _, authmode := range modes {
+					tests = append(tests, diagnoseResult{Transport: transport, Port: 389, AuthMode: authmode})
+				}
+			}
+		}
+		if open[636] {
+			for _, authmode := range modes {
+				tests = append(tests, diagnoseResult{Transport: TLS, Port: 636, AuthMode: authmode})
+			}
+		}
+
+		var working []diagnoseResult
+		for i := range tests {
+			test := &tests[i]
+			ad := AD{
+				Domain:     domain,
+				Server:     server,
+				Port:       test.Port,
+				User:       user,
+				Password:   password,
+				AuthDomain: authdomain,
+				TLSMode:    test.Transport,
+				IgnoreCert: !trusted,
+			}
+			if user != "" {
+				ad.User = user + "@" + domain
+			}
+			test.Err = tryBind(ad, test.AuthMode)
+			if test.Err == nil {
+				log.Info().Msgf("%v on port %v with %v bind: works", test.Transport, test.Port, AuthModeString(test.AuthMode))
+				working = append(working, *test)
+				continue
+			}
+			log.Warn().Msgf("%v on port %v with %v bind: %v", test.Transport, test.Port, AuthModeString(test.AuthMode), explainBindError(test.Err))
+			if message := test.Err.Error(); strings.Contains(message, "data 52e") || strings.Contains(message, "data 775") {
+				// Every other combination fails the same way, and counts towards locking the account out
+				log.Error().Msg("Stopping, as the credentials are rejected")
+				return nil
+			}
+		}
+
+		if len(working) == 0 {
+			log.Error().Msgf("No combination works against %v", server)
+			continue
+		}
+		// Prefer encrypted transports, and binds that don't send the password in clear text
+		best := working[0]
+		for _, result := range working[1:] {
+			if result.score() > best.score() {
+				best = result
+			}
+		}
+		log.Info().Msgf("Recommended for %v: -server %v -port %v -tlsmode %v -ignorecert=%v -authmode %v",
+			server, server, best.Port, best.Transport, !trusted, AuthModeString(best.AuthMode))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The uncompressed draw.io file format, which diagrams.net and the Confluence and wiki plugins open
+type DrawIOFile struct {
+	XMLName xml.Name      `xml:"mxfile"`
+	Host    string        `xml:"host,attr"`
+	Diagram DrawIODiagram `xml:"diagram"`
+}
+
+type DrawIODiagram struct {
+	Name  string       `xml:"name,attr"`
+	Cells []DrawIOCell `xml:"mxGraphModel>root>mxCell"`
+}
+
+type DrawIOCell struct {
+	Id       string          `xml:"id,attr"`
+	Value    string          `xml:"value,attr,omitempty"`
+	Style    string          `xml:"style,attr,omitempty"`
+	Vertex   int             `xml:"vertex,attr,omitempty"`
+	Edge     int             `xml:"edge,attr,omitempty"`
+	Parent   string          `xml:"parent,attr,omitempty"`
+	Source   string          `xml:"source,attr,omitempty"`
+	Target   string          `xml:"target,attr,omitempty"`
+	Geometry *DrawIOGeometry `xml:"mxGeometry,omitempty"`
+}
+
+type DrawIOGeometry struct {
+	X        int    `xml:"x,attr,omitempty"`
+	Y        int    `xml:"y,attr,omitempty"`
+	Width    int    `xml:"width,attr,omitempty"`
+	Height   int    `xml:"height,attr,omitempty"`
+	Relative int    `xml:"relative,attr,omitempty"`
+	As       string `xml:"as,attr"`
+}
+
+// Node size and spacing in the layout
+const (
+	drawioNodeWidth  = 180
+	drawioNodeHeight = 50
+	drawioColumnGap  = 100
+	drawioRowGap     = 30
+)
+
+// Writes the graph as a draw.io diagram. draw.io doesn't lay out what it opens, so the objects are put in columns
+// by how many steps they are from the targets, with the targets in the last column
+func WriteDrawIO(w io.Writer, pg PwnGraph, name string, aggregate bool) error {
+	neighbours := make(map[*Object][]*Object)
+	for _, connection := range pg.Connections {
+		neighbours[connection.Source] = append(neighbours[connection.Source], connection.Target)
+		neighbours[connection.Target] = append(neighbours[connection.Target], connection.Source)
+	}
+	steps := make(map[*Object]int)
+	var queue []*Object
+	for _, target := range pg.Targets {
+		if _, found := steps[target]; !found {
+			steps[target] = 0
+			queue = append(queue, target)
+		}
+	}
+	maxsteps := 0
+	for i := 0; i < len(queue); i++ {
+		for _, next := range neighbours[queue[i]] {
+			if _, found := steps[next]; !found {
+				steps[next] = steps[queue[i]] + 1
+				if steps[next] > maxsteps {
+					maxsteps = steps[next]
+				}
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	file := DrawIOFile{
+		Host: "adalanche",
+		Diagram: DrawIODiagram{
+			Name: name,
+			Cells: []DrawIOCell{
+				{Id: "0"},
+				{Id: "1", Parent: "0"},
+			},
+		},
+	}
+	ids := make(map[*Object]string)
+	rows := make(map[int]int)
+	for i, object := range pg.Implicated {
+		column, found := steps[object]
+		if !found {
+			// Not connected to the targets, off to the side
+			column = maxsteps + 1
+		}
+		style := "rounded=1;whiteSpace=wrap;html=1;"
+		if found && column == 0 {
+			style += "fillColor=#f8cecc;strokeColor=#b85450;"
+		}
+		ids[object] = fmt.Sprintf("n%v", i)
+		file.Diagram.Cells = append(file.Diagram.Cells, DrawIOCell{
+			Id:     ids[object],
+			Value:  object.Label(),
+			Style:  style,
+			Vertex: 1,
+			Parent: "1",
+			Geometry: &DrawIOGeometry{
+				X:      (maxsteps - column + 1) * (drawioNodeWidth + drawioColumnGap),
+				Y:      rows[column] * (drawioNodeHeight + drawioRowGap),
+				Width:  drawioNodeWidth,
+				Height: drawioNodeHeight,
+				As:     "geometry",
+			},
+		})
+		rows[column]++
+	}
+	for i, edge := range ExportEdges(pg, aggregate) {
+		style := "endArrow=classic;html=1;"
+		if edge.ReverseMethods != 0 {
+			style = "startArrow=classic;" + style
+		}
+		file.Diagram.Cells = append(file.Diagram.Cells, DrawIOCell{
+			Id:       fmt.Sprintf("e%v", i),
+			Value:    edge.Label(),
+			Style:    style,
+			Edge:     1,
+			Parent:   "1",
+			Source:   ids[edge.Source],
+			Target:   ids[edge.Target],
+			Geometry: &DrawIOGeometry{Relative: 1, As: "geometry"},
+		})
+	}
+
+	fmt.Fprint(w, xml.Header)
+	xe := xml.NewEncoder(w)
+	xe.Indent("", "  ")
+	return xe.Encode(file)
+}
+
+func ExportDrawIO(pg PwnGraph, filename string, name string, aggregate bool) error {
+	df, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+	return WriteDrawIO(df, pg, name, aggregate)
+}
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	ldap "github.com/lkarlslund/ldap/v3"
+	"github.com/rs/zerolog/log"
+	"github.com/schollz/progressbar/v3"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// A subtree to dump from the directory
+type NamingContext struct {
+	Name     string // Used for logging
+	DN       string
+	Optional bool // Don't fail the dump if this can't be read, application partitions are not always there
+}
+
+// The naming contexts that make up a normal domain dump
+func DefaultNamingContexts(rootdn string) []NamingContext {
+	return []NamingContext{
+		{Name: "schema", DN: "CN=Schema,CN=Configuration," + rootdn},
+		{Name: "configuration", DN: "CN=Configuration," + rootdn},
+		{Name: "forest DNS", DN: "DC=ForestDnsZones," + rootdn, Optional: true},
+		{Name: "domain DNS", DN: "DC=DomainDnsZones," + rootdn, Optional: true},
+		{Name: "main AD", DN: rootdn},
+	}
+}
+
+// Splits a ; separated list of DNs, as DNs themselves contain commas
+func SplitDNList(list string) []string {
+	var result []string
+	for _, dn := range strings.Split(list, ";") {
+		dn = strings.TrimSpace(dn)
+		if dn != "" {
+			result = append(result, dn)
+		}
+	}
+	return result
+}
+
+// Returns true if the DN is one of the excluded subtrees or below one of them
+func dnExcluded(dn string, exclusions []string) bool {
+	dn = strings.ToLower(dn)
+	for _, exclusion := range exclusions {
+		exclusion = strings.ToLower(exclusion)
+		if dn == exclusion || strings.HasSuffix(dn, ","+exclusion) {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes objects read from a file instead of the directory (NTDS.dit, snapshots) to a dump, leaving out excluded
+// subtrees and the attributes not asked for, like a dump from the directory would
+type fileDumpWriter struct {
+	e          *msgp.Writer
+	exclusions []string
+	redactor   *Redactor
+	wanted     map[string]struct{}
+
+	written, skipped int
+}
+
+func newFileDumpWriter(e *msgp.Writer, exclusions []string, redactor *Redactor, attributes []string) *fileDumpWriter {
+	w := fileDumpWriter{
+		e:          e,
+		exclusions: exclusions,
+		redactor:   redactor,
+	}
+	if len(attributes) > 0 && !(len(attributes) == 1 && attributes[0] == "*") {
+		w.wanted = make(map[string]struct{})
+		for _, attribute := range attributes {
+			w.wanted[strings.ToLower(strings.TrimSpace(attribute))] = struct{}{}
+		}
+	}
+	return &w
+}
+
+func (w *fileDumpWriter) Write(object *RawObject) error {
+	if dnExcluded(object.DistinguishedName, w.exclusions) {
+		w.skipped++
+		return nil
+	}
+	if w.wanted != nil {
+		for name := range object.Attributes {
+			if _, found := w.wanted[strings.ToLower(name)]; !found {
+				delete(object.Attributes, name)
+			}
+		}
+	}
+	if w.redactor != nil {
+		w.redactor.Redact(object)
+	}
+	w.written++
+	return object.EncodeMsg(w.e)
+}
+
+// Logs how many objects were written from the file
+func (w *fileDumpWriter) Done(filename string) {
+	if w.skipped > 0 {
+		log.Info().Msgf("Skipped %v objects in excluded subtrees", w.skipped)
+	}
+	log.Info().Msgf("Dumped %v objects from %v", w.written, filename)
+}
+
+// Dumps the naming contexts in order, and writes the objects that are not in an excluded subtree. With parallel
+// above one, that many connections dump parts of the naming contexts at the same time
+func DumpNamingContexts(ad *AD, contexts []NamingContext, exclusions []string, redactor *Redactor, query string, attributes []string, nosacl bool, pagesize int, parallel int, e *msgp.Writer) error {
+	if parallel > 1 {
+		return dumpNamingContextsParallel(ad, contexts, exclusions, redactor, query, attributes, nosacl, pagesize, parallel, e)
+	}
+
+	dumpbar := progressbar.NewOptions(0,
+		progressbar.OptionSetDescription("Dumping..."),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetItsString("objects"),
+		progressbar.OptionOnCompletion(func() { fmt.Println() }),
+		progressbar.OptionThrottle(time.Second*1),
+	)
+
+	var withoutsd int
+	for _, nc := range contexts {
+		rawobjects, missingsd, err := dumpNamingContext(ad, nc, exclusions, redactor, query, attributes, nosacl, pagesize)
+		if err != nil {
+			return err
+		}
+		withoutsd += missingsd
+
+		log.Debug().Msgf("Saving %v %v objects ...", len(rawobjects), nc.Name)
+		for _, object := range rawobjects {
+			err = object.EncodeMsg(e)
+			if err != nil {
+				return fmt.Errorf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
+			}
+			dumpbar.Add(1)
+		}
+	}
+	dumpbar.Finish()
+
+	if withoutsd > 0 {
+		log.Warn().Msgf("%v objects are in the dump without a security descriptor, analysis of who controls them is incomplete", withoutsd)
+	}
+
+	return nil
+}
+
+// Dumps the objects in the naming context that are not in an excluded subtree, redacted and ready to be saved.
+// Returns them along with how many are without a security descriptor. Optional naming contexts that can't be
+// read return nothing
+func dumpNamingContext(ad *AD, nc NamingContext, exclusions []string, redactor *Redactor, query string, attributes []string, nosacl bool, pagesize int) ([]*RawObject, int, error) {
+	if dnExcluded(nc.DN, exclusions) {
+		log.Info().Msgf("Skipping %v objects, %v is excluded", nc.Name, nc.DN)
+		return nil, 0, nil
+	}
+	log.Info().Msgf("Dumping %v objects ...", nc.Name)
+	return dumpNamingContextPart(ad, dumpPart{nc: nc, dn: nc.DN, scope: ldap.ScopeWholeSubtree}, exclusions, redactor, query, attributes, nosacl, pagesize)
+}
+
+// A part of a naming context that is dumped on its own, so several can be dumped at the same time
+type dumpPart struct {
+	nc    NamingContext
+	dn    string
+	scope int
+}
+
+func dumpNamingContextPart(ad *AD, part dumpPart, exclusions []string, redactor *Redactor, query string, attributes []string, nosacl bool, pagesize int) ([]*RawObject, int, error) {
+	var rawobjects []*RawObject
+	for _, search := range pruneDumpPart(ad, part, exclusions, pagesize) {
+		objects, err := ad.DumpScope(search.dn, search.scope, query, attributes, nosacl, pagesize)
+		if err != nil {
+			if part.nc.Optional {
+				log.Warn().Msgf("Problem dumping %v objects (maybe it doesn't exist): %v", part.nc.Name, err)
+				return nil, 0, nil
+			}
+			return nil, 0, err
+		}
+		rawobjects = append(rawobjects, objects...)
+	}
+
+	var withoutsd int
+	if wantsSecurityDescriptor(attributes) {
+		withoutsd = retryMissingSecurityDescriptors(ad, rawobjects, nosacl)
+	}
+
+	var skipped int
+	result := rawobjects[:0]
+	for _, object := range rawobjects {
+		if dnExcluded(object.DistinguishedName, exclusions) {
+			skipped++
+			continue
+		}
+		if redactor != nil {
+			redactor.Redact(object)
+		}
+		result = append(result, object)
+	}
+	if skipped > 0 {
+		log.Info().Msgf("Skipped %v %v objects in excluded subtrees", skipped, part.nc.Name)
+	}
+	return result, withoutsd, nil
+}
+
+// Returns true if an excluded subtree is somewhere below the DN
+func dnHasExcludedBelow(dn string, exclusions []string) bool {
+	dn = strings.ToLower(dn)
+	for _, exclusion := range exclusions {
+		if strings.HasSuffix(strings.ToLower(exclusion), ","+dn) {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits the part into searches that don't reach into excluded subtrees, so they aren't read from the directory at
+// all. The objects on the way down to an exclusion are read one at a time, and the subtrees next to them whole. If
+// the children can't be listed the part is searched as it is, and what is excluded is left out after reading it
+func pruneDumpPart(ad *AD, part dumpPart, exclusions []string, pagesize int) []dumpPart {
+	if part.scope != ldap.ScopeWholeSubtree || !dnHasExcludedBelow(part.dn, exclusions) {
+		return []dumpPart{part}
+	}
+	children, err := ad.Children(part.dn, pagesize)
+	if err != nil {
+		return []dumpPart{part}
+	}
+	searches := []dumpPart{{nc: part.nc, dn: part.dn, scope: ldap.ScopeBaseObject}}
+	for _, child := range children {
+		if !dnExcluded(child, exclusions) {
+			searches = append(searches, pruneDumpPart(ad, dumpPart{nc: part.nc, dn: child, scope: ldap.ScopeWholeSubtree}, exclusions, pagesize)...)
+		}
+	}
+	return searches
+}
+
+// Naming contexts with more objects than this directly below them are dumped in one go, as splitting something
+// flat like the schema into a part per object gains nothing
+const maxSplitChildren = 100
+
+// Splits the naming contexts into the naming context object itself and a part for each subtree right below it, so
+// one connection isn't left with the whole domain while the others are done with the small naming contexts
+func splitNamingContexts(ad *AD, contexts []NamingContext, exclusions []string, pagesize int) []dumpPart {
+	var parts []dumpPart
+	for _, nc := range contexts {
+		if dnExcluded(nc.DN, exclusions) {
+			log.Info().Msgf("Skipping %v objects, %v is excluded", nc.Name, nc.DN)
+			continue
+		}
+		children, err := ad.Children(nc.DN, pagesize)
+		if err != nil || len(children) > maxSplitChildren {
+			// Problems reading it are handled when dumping it
+			parts = append(parts, dumpPart{nc: nc, dn: nc.DN, scope: ldap.ScopeWholeSubtree})
+			continue
+		}
+		parts = append(parts, dumpPart{nc: nc, dn: nc.DN, scope: ldap.ScopeBaseObject})
+		for _, child := range children {
+			if !dnExcluded(child, exclusions) {
+				parts = append(parts, dumpPart{nc: nc, dn: child, scope: ldap.ScopeWholeSubtree})
+			}
+		}
+	}
+	return parts
+}
+
+func dumpNamingContextsParallel(ad *AD, contexts []NamingContext, exclusions []string, redactor *Redactor, query string, attributes []string, nosacl bool, pagesize int, parallel int, e *msgp.Writer) error {
+	parts := splitNamingContexts(ad, contexts, exclusions, pagesize)
+	log.Info().Msgf("Dumping %v naming contexts in %v parts over %v connections ...", len(contexts), len(parts), parallel)
+
+	type partresult struct {
+		objects   []*RawObject
+		withoutsd int
+		err       error
+	}
+	// Buffered, so workers can move on when results are not picked up after an error
+	results := make([]chan partresult, len(parts))
+	work := make(chan int, len(parts))
+	for i := range parts {
+		results[i] = make(chan partresult, 1)
+		work <- i
+	}
+	close(work)
+
+	// Progress bars from several dumps at once make a mess of the terminal, so just show the total
+	ad.quiet = true
+	for worker := 0; worker < parallel && worker < len(parts); worker++ {
+		go func(worker int) {
+			conn := ad
+			if worker > 0 {
+				other, err := ad.ConnectAgain()
+				if err != nil {
+					log.Warn().Msgf("Could not open connection %v to %v, dumping with fewer: %v", worker+1, ad.Server, explainBindError(err))
+					return
+				}
+				defer other.Disconnect()
+				conn = other
+			}
+			for i := range work {
+				log.Debug().Msgf("Dumping %v from %v objects ...", parts[i].dn, parts[i].nc.Name)
+				objects, withoutsd, err := dumpNamingContextPart(conn, parts[i], exclusions, redactor, query, attributes, nosacl, pagesize)
+				results[i] <- partresult{objects, withoutsd, err}
+			}
+		}(worker)
+	}
+
+	dumpbar := progressbar.NewOptions(0,
+		progressbar.OptionSetDescription("Dumping..."),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetItsString("objects"),
+		progressbar.OptionOnCompletion(func() { fmt.Println() }),
+		progressbar.OptionThrottle(time.Second*1),
+	)
+
+	// Saved in the same order as a dump over one connection. Referrals and naming contexts below others can
+	// return the same object from more than one part
+	saved := make(map[string]struct{})
+	var withoutsd int
+	for i := range parts {
+		result := <-results[i]
+		if result.err != nil {
+			return result.err
+		}
+		withoutsd += result.withoutsd
+		for _, object := range result.objects {
+			dn := strings.ToLower(object.DistinguishedName)
+			if _, found := saved[dn]; found {
+				continue
+			}
+			saved[dn] = struct{}{}
+			if err := object.EncodeMsg(e); err != nil {
+				return fmt.Errorf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
+			}
+			dumpbar.Add(1)
+		}
+	}
+	dumpbar.Finish()
+
+	if withoutsd > 0 {
+		log.Warn().Msgf("%v objects are in the dump without a security descriptor, analysis of who controls them is incomplete", withoutsd)
+	}
+
+	return nil
+}
+
+func wantsSecurityDescriptor(attributes []string) bool {
+	return wantsAttribute(attributes, "nTSecurityDescriptor")
+}
+
+// Returns true if the attribute is dumped with this list of attributes to get
+func wantsAttribute(attributes []string, attribute string) bool {
+	if len(attributes) == 0 {
+		return true
+	}
+	for _, wanted := range attributes {
+		if strings.EqualFold(wanted, attribute) || wanted == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Fewer missing security descriptors than this are always retried. Parts of a dump can be a handful of objects, where
+// one or two without a security descriptor would otherwise look like the account can't read any
+const sdRetryGiveUpMinimum = 100
+
+// Gets ntSecurityDescriptor again for objects that came back without it. First from the same domain controller
+// asking for less of it (with or without the SACL, DACL only), then from the other domain controllers, as
+// permissions and replication can differ. Returns how many are still without one
+func retryMissingSecurityDescriptors(ad *AD, objects []*RawObject, nosacl bool) int {
+	var missing []*RawObject
+	for _, object := range objects {
+		if len(object.Attributes["nTSecurityDescriptor"]) == 0 {
+			missing = append(missing, object)
+		}
+	}
+	if len(missing) == 0 {
+		return 0
+	}
+	if len(missing) >= sdRetryGiveUpMinimum && len(missing) > len(objects)/2 {
+		// Not a problem with some objects, the account can't read them at all with these settings
+		log.Warn().Msgf("%v of %v objects came back without a security descriptor, try toggling -nosacl or using an account with more permissions", len(missing), len(objects))
+		return len(missing)
+	}
+	log.Info().Msgf("%v objects came back without a security descriptor, retrying them", len(missing))
+
+	// SD flags used for the dump, and others to try
+	var dumpflags, otherflags int64 = 15, 7
+	if nosacl {
+		dumpflags, otherflags = 7, 15
+	}
+
+	retry := func(conn *AD, source string, flagsets ...int64) {
+		var stillmissing []*RawObject
+		for _, object := range missing {
+			var found bool
+			for _, flags := range flagsets {
+				fetched, err := conn.fetch(object.DistinguishedName, []string{"nTSecurityDescriptor"}, []ldap.Control{sdFlagsControl(flags)})
+				if err == nil && fetched != nil && len(fetched.Attributes["nTSecurityDescriptor"]) > 0 {
+					object.Attributes["nTSecurityDescriptor"] = fetched.Attributes["nTSecurityDescriptor"]
+					found = true
+					break
+				}
+			}
+			if !found {
+				stillmissing = append(stillmissing, object)
+			}
+		}
+		if recovered := len(missing) - len(stillmissing); recovered > 0 {
+			log.Info().Msgf("Got %v security descriptors from %v", recovered, source)
+		}
+		missing = stillmissing
+	}
+
+	retry(ad, ad.Server+" with other flags", otherflags, 4)
+	for _, server := range FindDomainControllers(ad.Domain) {
+		if len(missing) == 0 {
+			break
+		}
+		if strings.EqualFold(server, ad.Server) {
+			continue
+		}
+		other := AD{
+			Domain:     ad.Domain,
+			Server:     server,
+			Port:       ad.Port,
+			User:       ad.User,
+			Password:   ad.Password,
+			AuthDomain: ad.AuthDomain,
+			TLSMode:    ad.TLSMode,
+			IgnoreCert: ad.IgnoreCert,
+			CCache:     ad.CCache,
+			ADWS:       ad.ADWS,
+		}
+		if err := other.Connect(ad.authmode); err != nil {
+			log.Warn().Msgf("Could not connect to %v to retry security descriptors: %v", server, explainBindError(err))
+			continue
+		}
+		retry(&other, server, dumpflags, otherflags, 4)
+		other.Disconnect()
+	}
+	return len(missing)
+}
+
+// Attributes the analysis can't do without, so they can't be redacted
+var unredactableAttributes = []string{
+	"distinguishedName",
+	"name",
+	"objectClass",
+	"objectCategory",
+	"objectSid",
+	"objectGUID",
+	"nTSecurityDescriptor",
+	"member",
+	"memberOf",
+	"primaryGroupID",
+	"sAMAccountName",
+	"userAccountControl",
+	"schemaIDGUID",
+	"rightsGuid",
+	"lDAPDisplayName",
+}
+
+// Redactor hashes or drops sensitive attributes before they're written to the dump
+type Redactor struct {
+	attributes map[string]struct{}
+	drop       bool
+	key        []byte
+}
+
+// Returns a redactor for the comma separated list of attributes, mode is either hash or drop
+func NewRedactor(attributes string, mode string) (*Redactor, error) {
+	r := Redactor{
+		attributes: make(map[string]struct{}),
+	}
+	switch strings.ToLower(mode) {
+	case "hash":
+		// Random key per dump, so equal values still match each other but can't be looked up in a dictionary
+		r.key = make([]byte, 32)
+		if _, err := rand.Read(r.key); err != nil {
+			return nil, err
+		}
+	case "drop":
+		r.drop = true
+	default:
+		return nil, fmt.Errorf("Unknown redaction mode %v", mode)
+	}
+	for _, attribute := range strings.Split(attributes, ",") {
+		attribute = strings.ToLower(strings.TrimSpace(attribute))
+		if attribute == "" {
+			continue
+		}
+		for _, unredactable := range unredactableAttributes {
+			if strings.EqualFold(attribute, unredactable) {
+				return nil, fmt.Errorf("Attribute %v is needed for analysis and can't be redacted", unredactable)
+			}
+		}
+		r.attributes[attribute] = struct{}{}
+	}
+	return &r, nil
+}
+
+func (r *Redactor) Redact(object *RawObject) {
+	for name, values := range object.Attributes {
+		if _, found := r.attributes[strings.ToLower(name)]; !found {
+			continue
+		}
+		if r.drop {
+			delete(object.Attributes, name)
+			continue
+		}
+		redacted := make([]string, len(values))
+		for i, value := range values {
+			mac := hmac.New(sha256.New, r.key)
+			mac.Write([]byte(value))
+			redacted[i] = "redacted:" + hex.EncodeToString(mac.Sum(nil)[:16])
+		}
+		object.Attributes[name] = redacted
+	}
+}
+
+// A named set of dump options, so the right attributes, page sizes and modules can be picked without knowing all the flags
+type DumpProfile struct {
+	Name        string
+	Description string
+	Settings    map[string]string // Flag name -> value
+}
+
+// Attributes the analysis uses: identity, type, memberships, security descriptors, delegation, GPOs, trusts and the schema
+var analysisAttributes = []string{
+	"distinguishedName", "objectClass", "objectCategory", "name", "displayName", "sAMAccountName", "sAMAccountType",
+	"objectSid", "objectGUID", "sIDHistory", "nTSecurityDescriptor", "member", "memberOf", "primaryGroupID", "groupType",
+	"userAccountControl", "adminCount", "servicePrincipalName", "dNSHostName", "operatingSystem", "userPrincipalName",
+	"msDS-AllowedToDelegateTo", "msDS-AllowedToActOnBehalfOfOtherIdentity", "msDS-GroupMSAMembership",
+	"msDS-HostServiceAccount", "ms-Mcs-AdmPwdExpirationTime", "gPLink", "gPOptions", "gPCFileSysPath", "dsHeuristics",
+	"msLAPS-PasswordExpirationTime", "msLAPS-EncryptedPassword", "msLAPS-EncryptedDSRMPassword",
+	"msDS-KeyCredentialLink", "msDS-DeviceID", "securityIdentifier", "trustDirection", "trustAttributes", "trustPartner",
+	"lDAPDisplayName", "schemaIDGUID", "attributeSecurityGUID", "rightsGuid", "appliesTo", "validAccesses", "linkID",
+}
+
+// Just enough to resolve users, computers and groups in collected session and local admin data
+var sessionAttributes = []string{
+	"distinguishedName", "objectClass", "objectCategory", "name", "sAMAccountName", "objectSid", "objectGUID",
+	"member", "memberOf", "primaryGroupID", "userAccountControl", "dNSHostName", "userPrincipalName", "lastLogonTimestamp",
+	"lDAPDisplayName", "schemaIDGUID",
+}
+
+var DumpProfiles = []DumpProfile{
+	{
+		Name:        "full",
+		Description: "Everything, following referrals to other domains",
+		Settings: map[string]string{
+			"attributes":     "",
+			"nosacl":         "true",
+			"pagesize":       "1000",
+			"chasereferrals": "true",
+		},
+	},
+	{
+		Name:        "acl-only",
+		Description: "Only the attributes the analysis uses, no personal data like descriptions, mail or phone numbers",
+		Settings: map[string]string{
+			"attributes": strings.Join(analysisAttributes, ","),
+			"nosacl":     "true",
+			"pagesize":   "1000",
+		},
+	},
+	{
+		Name:        "stealth",
+		Description: "Attributes the analysis uses in small pages, without asking for SACLs or following referrals, to blend in with normal LDAP traffic",
+		Settings: map[string]string{
+			"attributes":     strings.Join(analysisAttributes, ","),
+			"nosacl":         "true",
+			"pagesize":       "100",
+			"chasereferrals": "false",
+		},
+	},
+	{
+		Name:        "sessions",
+		Description: "Just what is needed to map collected sessions and local admins to users, computers and groups",
+		Settings: map[string]string{
+			"attributes": strings.Join(sessionAttributes, ","),
+			"nosacl":     "true",
+			"pagesize":   "1000",
+		},
+	},
+}
+
+func FindDumpProfile(name string) (DumpProfile, bool) {
+	for _, profile := range DumpProfiles {
+		if strings.EqualFold(profile.Name, name) {
+			return profile, true
+		}
+	}
+	return DumpProfile{}, false
+}
+
+// Applies the profile settings to the flags, except the ones set explicitly on the command line or in the config file
+func (dp DumpProfile) Apply(flags *flag.FlagSet) error {
+	return applyFlagSettings(flags, dp.Settings, "Profile "+dp.Name)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extra information about why a connection exists, for the cases where the method alone doesn't tell the story
+type EdgeDetail struct {
+	Method    PwnMethod `json:"method"`
+	Reason    string    `json:"reason"`
+	Collected time.Time `json:"collected,omitempty"` // Set for connections from data that goes stale, like sessions
+	Chance    float64   `json:"chance,omitempty"`    // Set for connections that only work some of the time, like cracking a password
+}
+
+// Source -> Target -> details, kept on the side as most connections have none
+var AllEdgeDetails = make(map[PwnPair][]EdgeDetail)
+
+// Guards AllEdgeDetails, as the analyzers that record details run in parallel
+var edgedetaillock sync.RWMutex
+
+var (
+	// Confidence in a timestamped connection halves for every period of this age
+	EdgeHalfLife = 7 * 24 * time.Hour
+	// Timestamped connections older than this are left out of analysis, zero keeps them all
+	EdgeMaxAge = 30 * 24 * time.Hour
+)
+
+func edgeDetail(source, target *Object, method PwnMethod) *EdgeDetail {
+	pair := PwnPair{Source: source, Target: target}
+	for i, detail := range AllEdgeDetails[pair] {
+		if detail.Method == method {
+			return &AllEdgeDetails[pair][i]
+		}
+	}
+	AllEdgeDetails[pair] = append(AllEdgeDetails[pair], EdgeDetail{Method: method})
+	return &AllEdgeDetails[pair][len(AllEdgeDetails[pair])-1]
+}
+
+// Records why source can pwn target using method
+func SetEdgeReason(source, target *Object, method PwnMethod, reason string) {
+	edgedetaillock.Lock()
+	edgeDetail(source, target, method).Reason = reason
+	edgedetaillock.Unlock()
+}
+
+// Adds a reason to the ones recorded, for connections that can come from more than one place - like a local admin
+// of several computers a user is logged on to
+func AddEdgeReason(source, target *Object, method PwnMethod, reason string) {
+	edgedetaillock.Lock()
+	detail := edgeDetail(source, target, method)
+	switch {
+	case detail.Reason == "":
+		detail.Reason = reason
+	case !strings.Contains("; "+detail.Reason+"; ", "; "+reason+"; "):
+		detail.Reason += "; " + reason
+	}
+	edgedetaillock.Unlock()
+}
+
+// Records when the data behind the connection was collected, so it can age. When it comes from more than one place
+// the newest time is kept, as the connection is there as long as one of them is
+func SetEdgeCollected(source, target *Object, method PwnMethod, collected time.Time) {
+	edgedetaillock.Lock()
+	if detail := edgeDetail(source, target, method); collected.After(detail.Collected) {
+		detail.Collected = collected
+	}
+	edgedetaillock.Unlock()
+}
+
+// Records the chance (0-1) that the connection can be used, for the ones that take luck
+func SetEdgeChance(source, target *Object, method PwnMethod, chance float64) {
+	edgedetaillock.Lock()
+	edgeDetail(source, target, method).Chance = chance
+	edgedetaillock.Unlock()
+}
+
+// Returns the confidence (0-1) that a connection with the collection time still exists
+func ageConfidence(collected time.Time) float64 {
+	if collected.IsZero() || EdgeHalfLife <= 0 {
+		return 1
+	}
+	age := time.Since(collected)
+	if age <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(age)/float64(EdgeHalfLife))
+}
+
+// Returns the confidence in the connection through the methods given, from the best of them. Methods without a
+// collection time or a chance always work, so the second return value is false if any of them is in play
+func EdgeConfidence(source, target *Object, methods PwnMethod) (float64, bool) {
+	uncertain := make(map[PwnMethod]float64)
+	edgedetaillock.RLock()
+	details := AllEdgeDetails[PwnPair{Source: source, Target: target}]
+	edgedetaillock.RUnlock()
+	for _, detail := range details {
+		if methods&detail.Method == 0 || detail.Collected.IsZero() && detail.Chance == 0 {
+			continue
+		}
+		confidence := ageConfidence(detail.Collected)
+		if detail.Chance > 0 {
+			confidence *= detail.Chance
+		}
+		uncertain[detail.Method] = confidence
+	}
+	var best float64
+	for i := 0; i < 64; i++ {
+		method := PwnMethod(1 << i)
+		if methods&method == 0 {
+			continue
+		}
+		confidence, found := uncertain[method]
+		if !found {
+			return 1, false
+		}
+		best = math.Max(best, confidence)
+	}
+	return best, true
+}
+
+// Returns the methods without the ones that are based on data older than EdgeMaxAge
+func withoutStaleMethods(source, target *Object, methods PwnMethod) PwnMethod {
+	if EdgeMaxAge <= 0 {
+		return methods
+	}
+	edgedetaillock.RLock()
+	details := AllEdgeDetails[PwnPair{Source: source, Target: target}]
+	edgedetaillock.RUnlock()
+	for _, detail := range details {
+		if !detail.Collected.IsZero() && time.Since(detail.Collected) > EdgeMaxAge {
+			methods &^= detail.Method
+		}
+	}
+	return methods
+}
+
+// Returns the reasons recorded for the connection, limited to the methods given
+func EdgeReasons(source, target *Object, methods PwnMethod) []string {
+	var reasons []string
+	edgedetaillock.RLock()
+	details := AllEdgeDetails[PwnPair{Source: source, Target: target}]
+	edgedetaillock.RUnlock()
+	for _, detail := range details {
+		if methods&detail.Method == 0 {
+			continue
+		}
+		reason := detail.Method.String() + ": " + detail.Reason
+		if !detail.Collected.IsZero() {
+			reason += fmt.Sprintf(" (collected %v days ago, confidence %.0f%%)", int(time.Since(detail.Collected).Hours()/24), ageConfidence(detail.Collected)*100)
+		}
+		if detail.Chance > 0 {
+			reason += fmt.Sprintf(" (works with a chance of about %.0f%%)", detail.Chance*100)
+		}
+		reasons = append(reasons, reason)
+	}
+	// Memberships are too many to record a reason for each, so it's worked out here
+	if methods&PwnMemberOfGroup != 0 {
+		reasons = append(reasons, PwnMemberOfGroup.String()+": "+source.MembershipSource(target))
+	}
+	return reasons
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EntraTenant is data from Entra ID (Azure AD), loaded from <datapath>/*.entra.json files. Field names follow
+// Microsoft Graph, so responses from there can be stored as they are
+type EntraTenant struct {
+	TenantID                  string                         `json:"tenantId"`
+	Users                     []EntraUser                    `json:"users"`
+	Groups                    []EntraGroup                   `json:"groups"`
+	DirectoryRoles            []EntraDirectoryRole           `json:"directoryRoles"`
+	ConditionalAccessPolicies []EntraConditionalAccessPolicy `json:"conditionalAccessPolicies"`
+	RoleAssignments           []EntraRoleAssignment          `json:"roleAssignments"`
+	ServicePrincipals         []EntraServicePrincipal        `json:"servicePrincipals"`
+	Applications              []EntraApplication             `json:"applications"`
+	Devices                   []EntraDevice                  `json:"devices"`
+}
+
+type EntraUser struct {
+	ID                           string `json:"id"`
+	UserPrincipalName            string `json:"userPrincipalName"`
+	DisplayName                  string `json:"displayName"`
+	AccountEnabled               *bool  `json:"accountEnabled"`
+	OnPremisesSecurityIdentifier string `json:"onPremisesSecurityIdentifier"`
+	OnPremisesImmutableID        string `json:"onPremisesImmutableId"`
+
+	// From reports/authenticationMethods/userRegistrationDetails, nil if not collected
+	IsMFARegistered   *bool    `json:"isMfaRegistered"`
+	MethodsRegistered []string `json:"methodsRegistered"`
+}
+
+type EntraGroup struct {
+	ID                           string   `json:"id"`
+	DisplayName                  string   `json:"displayName"`
+	OnPremisesSecurityIdentifier string   `json:"onPremisesSecurityIdentifier"`
+	Members                      []string `json:"members"` // Object IDs of users and groups
+}
+
+type EntraDirectoryRole struct {
+	ID             string   `json:"id"`
+	DisplayName    string   `json:"displayName"`
+	RoleTemplateID string   `json:"roleTemplateId"`
+	Members        []string `json:"members"` // Object IDs of users and groups
+}
+
+// From roleManagement/directory/roleAssignments, which also has the roles given to service principals and roles
+// that are assigned but not activated as a directoryRole
+type EntraRoleAssignment struct {
+	ID               string `json:"id"`
+	PrincipalID      string `json:"principalId"`
+	RoleDefinitionID string `json:"roleDefinitionId"` // Same as the roleTemplateId for built-in roles
+	DirectoryScopeID string `json:"directoryScopeId"` // / for the whole tenant
+}
+
+type EntraServicePrincipal struct {
+	ID                     string `json:"id"`
+	AppID                  string `json:"appId"`
+	DisplayName            string `json:"displayName"`
+	ServicePrincipalType   string `json:"servicePrincipalType"`
+	AccountEnabled         *bool  `json:"accountEnabled"`
+	AppOwnerOrganizationID string `json:"appOwnerOrganizationId"`
+}
+
+type EntraApplication struct {
+	ID             string `json:"id"`
+	AppID          string `json:"appId"`
+	DisplayName    string `json:"displayName"`
+	SignInAudience string `json:"signInAudience"`
+}
+
+type EntraDevice struct {
+	ID                           string   `json:"id"`
+	DeviceID                     string   `json:"deviceId"`
+	DisplayName                  string   `json:"displayName"`
+	OperatingSystem              string   `json:"operatingSystem"`
+	OperatingSystemVersion       string   `json:"operatingSystemVersion"`
+	TrustType                    string   `json:"trustType"` // AzureAd, ServerAd (hybrid joined) or Workplace (registered)
+	AccountEnabled               *bool    `json:"accountEnabled"`
+	OnPremisesSecurityIdentifier string   `json:"onPremisesSecurityIdentifier"`
+	RegisteredOwners             []string `json:"registeredOwners"` // Object IDs of users
+}
+
+type EntraConditionalAccessPolicy struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	State       string `json:"state"` // enabled, disabled or enabledForReportingButNotEnforced
+	Conditions  struct {
+		Users struct {
+			IncludeUsers  []string `json:"includeUsers"`
+			ExcludeUsers  []string `json:"excludeUsers"`
+			IncludeGroups []string `json:"includeGroups"`
+			ExcludeGroups []string `json:"excludeGroups"`
+			IncludeRoles  []string `json:"includeRoles"`
+			ExcludeRoles  []string `json:"excludeRoles"`
+		} `json:"users"`
+	} `json:"conditions"`
+	GrantControls *struct {
+		Operator        string   `json:"operator"`
+		BuiltInControls []string `json:"builtInControls"`
+	} `json:"grantControls"`
+}
+
+// Role template IDs for the directory roles that can take over the tenant, or the on-prem AD via sync
+var entraPrivilegedRoles = map[string]string{
+	"62e90394-69f5-4237-9190-012177145e10": "Global Administrator",
+	"e8611ab8-c189-46e8-94e1-60213ab1f814": "Privileged Role Administrator",
+	"7be44c8a-adaf-4e2a-84d6-ab2649e08a13": "Privileged Authentication Administrator",
+	"194ae4cb-b126-40b2-bd5b-6091b380977d": "Security Administrator",
+	"b1be1c3e-b65d-4f19-8427-f6fa0d97feb9": "Conditional Access Administrator",
+	"8ac3fc64-6eca-42ea-9e69-59f4c7b60eb2": "Hybrid Identity Administrator",
+	"9b895d92-2cd3-44c7-9d02-a6ac2d5ea5c3": "Application Administrator",
+	"158c047a-c907-4556-b7ef-446551a6b5f7": "Cloud Application Administrator",
+	"c4e39bd9-1100-46d3-8c65-fb160da0071f": "Authentication Administrator",
+	"fe930be7-5e62-47db-91af-98c3a49a38b1": "User Administrator",
+	"729827e3-9c14-49f7-bb1b-9608f156bbb8": "Helpdesk Administrator",
+	"29232cdf-9323-42fd-ade2-1d097af3e4de": "Exchange Administrator",
+	"f28a1f50-f6e7-4571-818b-6a12f2af6b6c": "SharePoint Administrator",
+	"3a2c62db-5318-420d-8d74-23affee5d9d5": "Intune Administrator",
+	"d29b2b05-8046-44ba-8758-1e26182fcf32": "Directory Synchronization Accounts",
+}
+
+var AllTenants []*EntraTenant
+
+func LoadEntraTenants(datapath string) error {
+	files, err := filepath.Glob(filepath.Join(datapath, "*.entra.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var tenant EntraTenant
+		if err = qjson.Unmarshal(data, &tenant); err != nil {
+			log.Warn().Msgf("Problem loading Entra ID data from %v: %v", file, err)
+			continue
+		}
+		tenant.addObjects()
+		AllTenants = append(AllTenants, &tenant)
+		log.Info().Msgf("Loaded %v users, %v groups, %v roles, %v service principals, %v devices and %v conditional access policies from Entra ID tenant %v",
+			len(tenant.Users), len(tenant.Groups), len(tenant.DirectoryRoles), len(tenant.ServicePrincipals), len(tenant.Devices), len(tenant.ConditionalAccessPolicies), tenant.TenantID)
+	}
+	return nil
+}
+
+func (t *EntraTenant) dn(kind, id string) string {
+	return "CN=" + id + ",CN=" + kind + ",CN=" + t.TenantID + ",CN=Entra ID"
+}
+
+// Adds the tenant wide role assignments as members of their roles, adding the roles that aren't activated
+func (t *EntraTenant) assignRoles() {
+	for _, assignment := range t.RoleAssignments {
+		if assignment.DirectoryScopeID != "/" && assignment.DirectoryScopeID != "" {
+			continue
+		}
+		i := -1
+		for j, role := range t.DirectoryRoles {
+			if role.RoleTemplateID == assignment.RoleDefinitionID || role.ID == assignment.RoleDefinitionID {
+				i = j
+				break
+			}
+		}
+		if i == -1 {
+			name, found := entraPrivilegedRoles[assignment.RoleDefinitionID]
+			if !found {
+				name = "Role " + assignment.RoleDefinitionID
+			}
+			t.DirectoryRoles = append(t.DirectoryRoles, EntraDirectoryRole{
+				ID:             assignment.RoleDefinitionID,
+				DisplayName:    name,
+				RoleTemplateID: assignment.RoleDefinitionID,
+			})
+			i = len(t.DirectoryRoles) - 1
+		}
+		if !StringInSlice(assignment.PrincipalID, t.DirectoryRoles[i].Members) {
+			t.DirectoryRoles[i].Members = append(t.DirectoryRoles[i].Members, assignment.PrincipalID)
+		}
+	}
+}
+
+// Adds the users, groups, roles, service principals, app registrations and devices as objects, with memberships so
+// the usual analysis follows them
+func (t *EntraTenant) addObjects() {
+	t.assignRoles()
+
+	// Object ID -> DNs of groups and roles it's a direct member of
+	memberof := make(map[string][]string)
+	for _, group := range t.Groups {
+		for _, member := range group.Members {
+			memberof[member] = append(memberof[member], t.dn("Groups", group.ID))
+		}
+	}
+	for _, role := range t.DirectoryRoles {
+		for _, member := range role.Members {
+			memberof[member] = append(memberof[member], t.dn("Roles", role.ID))
+		}
+	}
+
+	for _, group := range t.Groups {
+		o := &Object{
+			DistinguishedName: t.dn("Groups", group.ID),
+			Attributes: map[Attribute][]string{
+				Name:           {group.DisplayName},
+				DisplayName:    {group.DisplayName},
+				ObjectClass:    {"top", "entraGroup"},
+				ObjectCategory: {"CN=Group,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MemberOf:       memberof[group.ID],
+				MetaEntra:      {t.TenantID},
+			},
+		}
+		AllObjects.Add(o)
+		if onprem, found := entraOnPremises(group.OnPremisesSecurityIdentifier, ""); found {
+			entraSyncSources[o] = append(entraSyncSources[o], onprem)
+		}
+	}
+
+	for _, role := range t.DirectoryRoles {
+		o := &Object{
+			DistinguishedName: t.dn("Roles", role.ID),
+			Attributes: map[Attribute][]string{
+				Name:           {role.DisplayName},
+				DisplayName:    {role.DisplayName},
+				Description:    {"Entra ID directory role"},
+				ObjectClass:    {"top", "entraDirectoryRole"},
+				ObjectCategory: {"CN=Group,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MetaEntra:      {t.TenantID},
+			},
+		}
+		if _, found := entraPrivilegedRoles[role.RoleTemplateID]; found {
+			o.SetAttr(MetaEntraPrivileged, "1")
+		}
+		AllObjects.Add(o)
+	}
+
+	for _, sp := range t.ServicePrincipals {
+		o := &Object{
+			DistinguishedName: t.dn("ServicePrincipals", sp.ID),
+			Attributes: map[Attribute][]string{
+				Name:           {sp.DisplayName},
+				DisplayName:    {sp.DisplayName},
+				Description:    {"Entra ID service principal (" + sp.ServicePrincipalType + "), app ID " + sp.AppID},
+				ObjectClass:    {"top", "entraServicePrincipal"},
+				ObjectCategory: {"CN=Service-Principal,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MemberOf:       memberof[sp.ID],
+				MetaEntra:      {t.TenantID},
+			},
+		}
+		if sp.AccountEnabled != nil && !*sp.AccountEnabled {
+			o.SetAttr(MetaAccountDisabled, "1")
+		}
+		for _, role := range t.userRoles(sp.ID, memberof) {
+			o.AddValues(MetaEntraRoles, role.DisplayName)
+			if _, found := entraPrivilegedRoles[role.RoleTemplateID]; found {
+				o.SetAttr(MetaEntraPrivileged, "1")
+			}
+		}
+		AllObjects.Add(o)
+	}
+
+	for _, app := range t.Applications {
+		o := &Object{
+			DistinguishedName: t.dn("Applications", app.ID),
+			Attributes: map[Attribute][]string{
+				Name:           {app.DisplayName},
+				DisplayName:    {app.DisplayName},
+				Description:    {"Entra ID app registration, app ID " + app.AppID + ", sign in audience " + app.SignInAudience},
+				ObjectClass:    {"top", "entraApplication"},
+				ObjectCategory: {"CN=Application,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MetaEntra:      {t.TenantID},
+			},
+		}
+		AllObjects.Add(o)
+	}
+
+	for _, device := range t.Devices {
+		o := &Object{
+			DistinguishedName: t.dn("Devices", device.ID),
+			Attributes: map[Attribute][]string{
+				Name:                   {device.DisplayName},
+				DisplayName:            {device.DisplayName},
+				Description:            {"Entra ID device, " + device.TrustType},
+				OperatingSystem:        {device.OperatingSystem},
+				OperatingSystemVersion: {device.OperatingSystemVersion},
+				ObjectClass:            {"top", "entraDevice"},
+				ObjectCategory:         {"CN=Device,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MemberOf:               memberof[device.ID],
+				MetaEntra:              {t.TenantID},
+			},
+		}
+		if device.AccountEnabled != nil && !*device.AccountEnabled {
+			o.SetAttr(MetaAccountDisabled, "1")
+		}
+		if device.DeviceID != "" {
+			o.SetAttr(MetaEntraDeviceID, device.DeviceID)
+		}
+		AllObjects.Add(o)
+		if onprem, found := entraOnPremises(device.OnPremisesSecurityIdentifier, ""); found {
+			entraSyncSources[o] = append(entraSyncSources[o], onprem)
+			entraHybridDevices[onprem] = o
+		}
+	}
+
+	for _, user := range t.Users {
+		o := &Object{
+			DistinguishedName: t.dn("Users", user.ID),
+			Attributes: map[Attribute][]string{
+				Name:              {user.DisplayName},
+				DisplayName:       {user.DisplayName},
+				UserPrincipalName: {user.UserPrincipalName},
+				ObjectClass:       {"top", "entraUser"},
+				ObjectCategory:    {"CN=Person,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MemberOf:          memberof[user.ID],
+				MetaEntra:         {t.TenantID},
+			},
+		}
+		if user.AccountEnabled != nil && !*user.AccountEnabled {
+			o.SetAttr(MetaAccountDisabled, "1")
+		}
+
+		roles := t.userRoles(user.ID, memberof)
+		for _, role := range roles {
+			o.AddValues(MetaEntraRoles, role.DisplayName)
+			if _, found := entraPrivilegedRoles[role.RoleTemplateID]; found {
+				o.SetAttr(MetaEntraPrivileged, "1")
+			}
+		}
+		if user.IsMFARegistered != nil {
+			if *user.IsMFARegistered {
+				o.SetAttr(MetaMFARegistered, "1")
+			} else {
+				o.SetAttr(MetaMFARegistered, "0")
+			}
+		}
+		if policies := t.mfaPolicies(user.ID, roles, memberof); len(policies) > 0 {
+			o.SetValues(MetaMFAEnforcedBy, policies...)
+		}
+		AllObjects.Add(o)
+
+		// Decorate the synced on-prem account too, so it shows up in queries there
+		if onprem, found := entraOnPremises(user.OnPremisesSecurityIdentifier, user.OnPremisesImmutableID); found {
+			entraSyncSources[o] = append(entraSyncSources[o], onprem)
+			for _, attribute := range []Attribute{MetaEntraRoles, MetaEntraPrivileged, MetaMFARegistered, MetaMFAEnforcedBy} {
+				if values := o.Attr(attribute); len(values) > 0 {
+					onprem.SetValues(attribute, values...)
+				}
+			}
+		}
+	}
+
+	t.linkHybrid(memberof)
+}
+
+// Returns the directory roles the user has directly or via (nested) groups
+func (t *EntraTenant) userRoles(id string, memberof map[string][]string) []EntraDirectoryRole {
+	ids := t.memberOfRecursive(id, memberof)
+	var results []EntraDirectoryRole
+	for _, role := range t.DirectoryRoles {
+		if _, found := ids[role.ID]; found {
+			results = append(results, role)
+		}
+	}
+	return results
+}
+
+// Object IDs of the groups and roles the object is a member of, directly or via nesting
+func (t *EntraTenant) memberOfRecursive(id string, memberof map[string][]string) map[string]struct{} {
+	result := make(map[string]struct{})
+	queue := []string{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, dn := range memberof[current] {
+			// CN=<id>,...
+			parentid := strings.TrimPrefix(strings.SplitN(dn, ",", 2)[0], "CN=")
+			if _, seen := result[parentid]; !seen {
+				result[parentid] = struct{}{}
+				queue = append(queue, parentid)
+			}
+		}
+	}
+	return result
+}
+
+// Returns the names of the enabled conditional access policies that require MFA for the user. Which applications
+// and other conditions the policies cover is not evaluated, so this is the best case
+func (t *EntraTenant) mfaPolicies(id string, roles []EntraDirectoryRole, memberof map[string][]string) []string {
+	groups := t.memberOfRecursive(id, memberof)
+	roletemplates := make(map[string]struct{})
+	for _, role := range roles {
+		roletemplates[role.RoleTemplateID] = struct{}{}
+	}
+	matches := func(list []string, ids map[string]struct{}) bool {
+		for _, entry := range list {
+			if _, found := ids[entry]; found {
+				return true
+			}
+		}
+		return false
+	}
+	self := map[string]struct{}{id: {}, "All": {}}
+
+	var results []string
+	for _, policy := range t.ConditionalAccessPolicies {
+		if policy.State != "enabled" || policy.GrantControls == nil || !StringInSlice("mfa", policy.GrantControls.BuiltInControls) {
+			continue
+		}
+		users := policy.Conditions.Users
+		included := matches(users.IncludeUsers, self) || matches(users.IncludeGroups, groups) || matches(users.IncludeRoles, roletemplates)
+		excluded := matches(users.ExcludeUsers, map[string]struct{}{id: {}}) || matches(users.ExcludeGroups, groups) || matches(users.ExcludeRoles, roletemplates)
+		if included && !excluded {
+			results = append(results, policy.DisplayName)
+		}
+	}
+	return results
+}
+
+// Returns true for accounts with a privileged Entra ID role that can sign in with just a password, either because
+// no conditional access policy requires MFA for them, or because they have no MFA method registered
+func entraPrivilegedWithoutMFA(o *Object) bool {
+	if o.OneAttr(MetaEntraPrivileged) != "1" || o.Type() != ObjectTypeUser {
+		return false
+	}
+	return len(o.Attr(MetaMFAEnforcedBy)) == 0 || o.OneAttr(MetaMFARegistered) == "0"
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Collects users, groups, service principals, app registrations, role assignments, devices and conditional access
+// policies from Entra ID with Microsoft Graph. With a client secret it signs in as that app registration, which needs
+// the Directory.Read.All, Policy.Read.All and AuditLog.Read.All application permissions. Without one it uses the
+// device code flow, signing in as a user who can read the directory
+type EntraCollector struct {
+	Tenant       string // Tenant ID or domain, organizations for whatever tenant the device code user is from
+	ClientID     string
+	ClientSecret string
+
+	token        string
+	refreshtoken string
+	expires      time.Time
+	client       http.Client
+}
+
+// Microsoft Graph Command Line Tools, the public client Microsoft provides for device code sign in to Graph
+const entraDefaultClientID = "14d82eec-204b-4c2f-b7e8-296a70dab67e"
+
+const entraGraphURL = "https://graph.microsoft.com/v1.0"
+
+const entraDelegatedScopes = "https://graph.microsoft.com/Directory.Read.All https://graph.microsoft.com/Policy.Read.All https://graph.microsoft.com/AuditLog.Read.All offline_access"
+
+type entraTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (c *EntraCollector) oauth(endpoint string, form url.Values) (entraTokenResponse, error) {
+	var response entraTokenResponse
+	resp, err := c.client.PostForm("https://login.microsoftonline.com/"+url.PathEscape(c.Tenant)+"/oauth2/v2.0/"+endpoint, form)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response, err
+	}
+	if err = qjson.Unmarshal(data, &response); err != nil {
+		return response, fmt.Errorf("%v returned %v: %v", endpoint, resp.Status, string(data))
+	}
+	return response, nil
+}
+
+func (c *EntraCollector) settoken(response entraTokenResponse) {
+	c.token = response.AccessToken
+	if response.RefreshToken != "" {
+		c.refreshtoken = response.RefreshToken
+	}
+	// Renew a bit before it runs out, so a request never goes out with an expired token
+	c.expires = time.Now().Add(time.Duration(response.ExpiresIn)*time.Second - 5*time.Minute)
+}
+
+func (c *EntraCollector) signin() error {
+	if c.ClientSecret != "" {
+		response, err := c.oauth("token", url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {c.ClientID},
+			"client_secret": {c.ClientSecret},
+			"scope":         {"https://graph.microsoft.com/.default"},
+		})
+		if err != nil {
+			return err
+		}
+		if response.Error != "" {
+			return fmt.Errorf("Sign in failed: %v", response.ErrorDescription)
+		}
+		c.settoken(response)
+		return nil
+	}
+
+	if c.refreshtoken != "" {
+		response, err := c.oauth("token", url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {c.ClientID},
+			"refresh_token": {c.refreshtoken},
+			"scope":         {entraDelegatedScopes},
+		})
+		if err == nil && response.Error == "" {
+			c.settoken(response)
+			return nil
+		}
+		log.Warn().Msg("Could not renew the Entra ID sign in, sign in again")
+	}
+
+	resp, err := c.client.PostForm("https://login.microsoftonline.com/"+url.PathEscape(c.Tenant)+"/oauth2/v2.0/devicecode", url.Values{
+		"client_id": {c.ClientID},
+		"scope":     {entraDelegatedScopes},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var devicecode struct {
+		DeviceCode string `json:"device_code"`
+		Message    string `json:"message"`
+		ExpiresIn  int    `json:"expires_in"`
+		Interval   int    `json:"interval"`
+		Error      string `json:"error_description"`
+	}
+	if err = qjson.NewDecoder(resp.Body).Decode(&devicecode); err != nil {
+		return err
+	}
+	if devicecode.DeviceCode == "" {
+		return fmt.Errorf("Could not start device code sign in: %v", devicecode.Error)
+	}
+	log.Info().Msg(devicecode.Message)
+
+	interval := time.Duration(devicecode.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(devicecode.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		response, err := c.oauth("token", url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"client_id":   {c.ClientID},
+			"device_code": {devicecode.DeviceCode},
+		})
+		if err != nil {
+			return err
+		}
+		switch response.Error {
+		case "":
+			c.settoken(response)
+			return nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return fmt.Errorf("Sign in failed: %v", response.ErrorDescription)
+		}
+	}
+	return errors.New("Device code sign in timed out")
+}
+
+// Gets from Graph, waiting and trying again when throttled
+func (c *EntraCollector) get(requesturl string, result interface{}) error {
+	for {
+		if time.Now().After(c.expires) {
+			if err := c.signin(); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequest("GET", requesturl, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return qjson.Unmarshal(data, result)
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			if wait == 0 {
+				wait = 10
+			}
+			log.Debug().Msgf("Throttled by Graph, waiting %v seconds", wait)
+			time.Sleep(time.Duration(wait) * time.Second)
+		default:
+			return fmt.Errorf("%v returned %v: %v", requesturl, resp.Status, string(data))
+		}
+	}
+}
+
+// Gets all pages of a Graph collection, which are chained with @odata.nextLink
+func (c *EntraCollector) getList(path string, each func(data []byte) error) error {
+	next := entraGraphURL + path
+	for next != "" {
+		var page struct {
+			Value    []json.RawMessage `json:"value"`
+			NextLink string            `json:"@odata.nextLink"`
+		}
+		if err := c.get(next, &page); err != nil {
+			return err
+		}
+		for _, item := range page.Value {
+			if err := each(item); err != nil {
+				return err
+			}
+		}
+		next = page.NextLink
+	}
+	return nil
+}
+
+// Gets a collection into a slice of the type result points to
+func (c *EntraCollector) getAll(path string, result interface{}) error {
+	var items []json.RawMessage
+	err := c.getList(path, func(data []byte) error {
+		items = append(items, data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	data, err := qjson.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return qjson.Unmarshal(data, result)
+}
+
+func (c *EntraCollector) members(path string) ([]string, error) {
+	var members []string
+	err := c.getList(path, func(data []byte) error {
+		var member struct {
+			ID string `json:"id"`
+		}
+		err := qjson.Unmarshal(data, &member)
+		members = append(members, member.ID)
+		return err
+	})
+	return members, err
+}
+
+func (c *EntraCollector) Collect() (EntraTenant, error) {
+	c.client.Timeout = 60 * time.Second
+	if c.Tenant == "" {
+		c.Tenant = "organizations"
+	}
+	if c.ClientID == "" {
+		c.ClientID = entraDefaultClientID
+	}
+	var tenant EntraTenant
+
+	var organizations []struct {
+		ID string `json:"id"`
+	}
+	if err := c.getAll("/organization?$select=id", &organizations); err != nil {
+		return tenant, err
+	}
+	if len(organizations) == 0 {
+		return tenant, errors.New("Graph returned no organization")
+	}
+	tenant.TenantID = organizations[0].ID
+
+	err := c.getAll("/users?$top=999&$select=id,userPrincipalName,displayName,accountEnabled,onPremisesSecurityIdentifier,onPremisesImmutableId", &tenant.Users)
+	if err != nil {
+		return tenant, err
+	}
+	log.Info().Msgf("Collected %v users", len(tenant.Users))
+
+	if err = c.getAll("/groups?$top=999&$select=id,displayName,onPremisesSecurityIdentifier", &tenant.Groups); err != nil {
+		return tenant, err
+	}
+	log.Info().Msgf("Collected %v groups, getting their members", len(tenant.Groups))
+	for i, group := range tenant.Groups {
+		if tenant.Groups[i].Members, err = c.members("/groups/" + group.ID + "/members?$top=999&$select=id"); err != nil {
+			return tenant, err
+		}
+	}
+
+	if err = c.getAll("/directoryRoles?$select=id,displayName,roleTemplateId", &tenant.DirectoryRoles); err != nil {
+		return tenant, err
+	}
+	for i, role := range tenant.DirectoryRoles {
+		if tenant.DirectoryRoles[i].Members, err = c.members("/directoryRoles/" + role.ID + "/members?$select=id"); err != nil {
+			return tenant, err
+		}
+	}
+	if err = c.getAll("/roleManagement/directory/roleAssignments?$select=id,principalId,roleDefinitionId,directoryScopeId", &tenant.RoleAssignments); err != nil {
+		return tenant, err
+	}
+	log.Info().Msgf("Collected %v directory roles and %v role assignments", len(tenant.DirectoryRoles), len(tenant.RoleAssignments))
+
+	if err = c.getAll("/servicePrincipals?$top=999&$select=id,appId,displayName,servicePrincipalType,accountEnabled,appOwnerOrganizationId", &tenant.ServicePrincipals); err != nil {
+		return tenant, err
+	}
+	if err = c.getAll("/applications?$top=999&$select=id,appId,displayName,signInAudience", &tenant.Applications); err != nil {
+		return tenant, err
+	}
+	log.Info().Msgf("Collected %v service principals and %v app registrations", len(tenant.ServicePrincipals), len(tenant.Applications))
+
+	var devices []struct {
+		EntraDevice
+		RegisteredOwners []struct {
+			ID string `json:"id"`
+		} `json:"registeredOwners"`
+	}
+	if err = c.getAll("/devices?$top=999&$select=id,deviceId,displayName,operatingSystem,operatingSystemVersion,trustType,accountEnabled,onPremisesSecurityIdentifier&$expand=registeredOwners($select=id)", &devices); err != nil {
+		return tenant, err
+	}
+	for _, device := range devices {
+		for _, owner := range device.RegisteredOwners {
+			device.EntraDevice.RegisteredOwners = append(device.EntraDevice.RegisteredOwners, owner.ID)
+		}
+		tenant.Devices = append(tenant.Devices, device.EntraDevice)
+	}
+	log.Info().Msgf("Collected %v devices", len(tenant.Devices))
+
+	// These need permissions a directory reader might not have, so carry on without them
+	if err = c.getAll("/identity/conditionalAccess/policies", &tenant.ConditionalAccessPolicies); err != nil {
+		log.Warn().Msgf("Could not collect conditional access policies, MFA enforcement is unknown: %v", err)
+	}
+	var registrations []struct {
+		ID                string   `json:"id"`
+		IsMFARegistered   *bool    `json:"isMfaRegistered"`
+		MethodsRegistered []string `json:"methodsRegistered"`
+	}
+	if err = c.getAll("/reports/authenticationMethods/userRegistrationDetails", &registrations); err != nil {
+		log.Warn().Msgf("Could not collect MFA registrations: %v", err)
+	} else {
+		users := make(map[string]int)
+		for i, user := range tenant.Users {
+			users[user.ID] = i
+		}
+		for _, registration := range registrations {
+			if i, found := users[registration.ID]; found {
+				tenant.Users[i].IsMFARegistered = registration.IsMFARegistered
+				tenant.Users[i].MethodsRegistered = registration.MethodsRegistered
+			}
+		}
+	}
+	return tenant, nil
+}
+
+// Collects from Entra ID and saves it in the data folder, where analyze picks it up
+func CollectEntra(tenantid, clientid, name, datapath string) error {
+	collector := EntraCollector{
+		Tenant:       tenantid,
+		ClientID:     clientid,
+		ClientSecret: os.Getenv("ENTRA_CLIENT_SECRET"),
+	}
+	if collector.ClientSecret != "" && (tenantid == "" || clientid == "") {
+		return errors.New("Signing in with a client secret needs -entratenant and -entraclientid")
+	}
+	tenant, err := collector.Collect()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = tenant.TenantID
+	}
+	data, err := qjson.MarshalIndent(tenant, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(datapath, name+".entra.json")
+	log.Info().Msgf("Saving %v users, %v groups, %v service principals and %v devices to %v", len(tenant.Users), len(tenant.Groups), len(tenant.ServicePrincipals), len(tenant.Devices), filename)
+	return os.WriteFile(filename, data, 0600)
+}
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Reader for Extensible Storage Engine (JET Blue) databases, which is what NTDS.dit is. Only reading whole tables
+// is supported, which is all a dump needs. The format is not documented by Microsoft, this follows the description
+// from libesedb
+
+const (
+	eseSignature          = 0x89abcdef
+	eseCatalogPage        = 4 // Root page of MSysObjects, the catalog of tables and columns
+	eseStateCleanShutdown = 3
+)
+
+// Page flags
+const (
+	esePageLeaf  = 0x0002
+	esePageEmpty = 0x0008
+	esePageSpace = 0x0020
+)
+
+// Page tag flags
+const (
+	eseTagDeleted = 0x2
+	eseTagCommon  = 0x4
+)
+
+// Catalog entry types
+const (
+	eseCatalogTable     = 1
+	eseCatalogColumn    = 2
+	eseCatalogLongValue = 4
+)
+
+// Flags on tagged column values
+const (
+	eseValueCompressed   = 0x02
+	eseValueLongValue    = 0x04
+	eseValueMultiValue   = 0x08
+	eseValueTwoValues    = 0x10 // Two values, the size of the first one in the first byte
+	eseMultiValueSizeBit = 0x8000
+)
+
+type eseDatabase struct {
+	file     io.ReaderAt
+	closer   io.Closer
+	pagesize int
+	version  uint32
+	revision uint32
+	state    uint32
+	tables   map[string]*eseTable
+}
+
+type eseTable struct {
+	name    string
+	fdp     uint32 // Root page of the records
+	lvfdp   uint32 // Root page of the long values, values too big to be in the record
+	columns map[uint32]*eseColumn
+	byname  map[string]*eseColumn
+
+	longvalues map[uint64][]byte
+}
+
+type eseColumn struct {
+	id       uint32
+	name     string
+	coltype  uint32
+	size     uint32 // Of fixed size columns
+	codepage uint32 // Of text columns, 1200 is UTF-16
+}
+
+// Values of a record by column name. Multi valued columns have more than one
+type eseRecord map[string][][]byte
+
+func openESE(filename string) (*eseDatabase, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	db := &eseDatabase{file: file, closer: file}
+	if err = db.readHeader(); err == nil {
+		err = db.readCatalog()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *eseDatabase) Close() error {
+	return db.closer.Close()
+}
+
+func (db *eseDatabase) readHeader() error {
+	header := make([]byte, 240)
+	if _, err := db.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("Problem reading database header: %v", err)
+	}
+	if binary.LittleEndian.Uint32(header[4:]) != eseSignature {
+		return errors.New("Not an ESE database")
+	}
+	db.version = binary.LittleEndian.Uint32(header[8:])
+	db.state = binary.LittleEndian.Uint32(header[52:])
+	db.revision = binary.LittleEndian.Uint32(header[232:])
+	db.pagesize = int(binary.LittleEndian.Uint32(header[236:]))
+	switch db.pagesize {
+	case 2048, 4096, 8192, 16384, 32768:
+	default:
+		return fmt.Errorf("Unsupported ESE page size %v", db.pagesize)
+	}
+	return nil
+}
+
+// Pages of 16 KB and up in newer databases have a longer header, and the tag flags moved into the data
+func (db *eseDatabase) largepages() bool {
+	return db.version == 0x620 && db.revision >= 0x11 && db.pagesize > 8192
+}
+
+type esePage struct {
+	data  []byte
+	flags uint32
+	base  int // Where the data the tags point into starts
+	tags  int
+	large bool
+}
+
+func (db *eseDatabase) page(number uint32) (*esePage, error) {
+	data := make([]byte, db.pagesize)
+	if _, err := db.file.ReadAt(data, int64(number+1)*int64(db.pagesize)); err != nil {
+		return nil, fmt.Errorf("Problem reading page %v: %v", number, err)
+	}
+	page := &esePage{
+		data:  data,
+		flags: binary.LittleEndian.Uint32(data[36:]),
+		base:  40,
+		tags:  int(binary.LittleEndian.Uint16(data[34:])),
+		large: db.largepages(),
+	}
+	if page.large {
+		page.base = 80
+	}
+	if page.tags*4 > db.pagesize-page.base {
+		return nil, fmt.Errorf("Page %v has too many tags", number)
+	}
+	return page, nil
+}
+
+// Returns the data of a tag and its flags
+func (p *esePage) tag(i int) ([]byte, int, error) {
+	entry := p.data[len(p.data)-4*(i+1):]
+	size := int(binary.LittleEndian.Uint16(entry))
+	offset := int(binary.LittleEndian.Uint16(entry[2:]))
+	var flags int
+	if p.large {
+		size &= 0x7fff
+		offset &= 0x7fff
+	} else {
+		flags = offset >> 13
+		size &= 0x1fff
+		offset &= 0x1fff
+	}
+	if p.base+offset+size > len(p.data)-4*p.tags {
+		return nil, 0, errors.New("Page tag points outside the page")
+	}
+	data := p.data[p.base+offset : p.base+offset+size]
+	if p.large && size >= 2 {
+		data = append([]byte{}, data...)
+		flags = int(data[1] >> 5)
+		data[1] &= 0x1f
+	}
+	return data, flags, nil
+}
+
+// Calls back with the key and data of every entry in the leaf pages of the tree with the root page
+func (db *eseDatabase) walk(root uint32, callback func(key, data []byte) error) error {
+	return db.walkPage(root, callback, 0)
+}
+
+func (db *eseDatabase) walkPage(number uint32, callback func(key, data []byte) error, depth int) error {
+	if depth > 16 {
+		return errors.New("ESE tree is too deep, the database is probably damaged")
+	}
+	page, err := db.page(number)
+	if err != nil {
+		return err
+	}
+	if page.flags&(esePageEmpty|esePageSpace) != 0 || page.tags == 0 {
+		return nil
+	}
+	// The first tag has the start of the keys that the entries leave out
+	common, _, err := page.tag(0)
+	if err != nil {
+		return err
+	}
+	for i := 1; i < page.tags; i++ {
+		entry, flags, err := page.tag(i)
+		if err != nil {
+			return fmt.Errorf("Page %v: %v", number, err)
+		}
+		if flags&eseTagDeleted != 0 {
+			continue
+		}
+		var key []byte
+		if flags&eseTagCommon != 0 {
+			if len(entry) < 2 {
+				return fmt.Errorf("Page %v has a truncated entry", number)
+			}
+			commonsize := int(binary.LittleEndian.Uint16(entry))
+			if commonsize > len(common) {
+				commonsize = len(common)
+			}
+			key = append(key, common[:commonsize]...)
+			entry = entry[2:]
+		}
+		if len(entry) < 2 {
+			return fmt.Errorf("Page %v has a truncated entry", number)
+		}
+		localsize := int(binary.LittleEndian.Uint16(entry)) & 0x1fff
+		if 2+localsize > len(entry) {
+			return fmt.Errorf("Page %v has an entry with a key longer than itself", number)
+		}
+		key = append(key, entry[2:2+localsize]...)
+		data := entry[2+localsize:]
+
+		if page.flags&esePageLeaf != 0 {
+			if err = callback(key, data); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(data) < 4 {
+			return fmt.Errorf("Page %v has a branch entry without a child page", number)
+		}
+		if err = db.walkPage(binary.LittleEndian.Uint32(data[len(data)-4:]), callback, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads the tables and their columns from the catalog
+func (db *eseDatabase) readCatalog() error {
+	db.tables = make(map[string]*eseTable)
+	byid := make(map[uint32]*eseTable)
+	err := db.walk(eseCatalogPage, func(key, data []byte) error {
+		if len(data) < 30 {
+			return nil
+		}
+		objid := binary.LittleEndian.Uint32(data[4:])
+		entrytype := binary.LittleEndian.Uint16(data[8:])
+		id := binary.LittleEndian.Uint32(data[10:])
+		coltypeorfdp := binary.LittleEndian.Uint32(data[14:])
+		spaceusage := binary.LittleEndian.Uint32(data[18:])
+		pagesorlocale := binary.LittleEndian.Uint32(data[26:])
+
+		// The name is the first variable size column
+		var name string
+		lastvariable := int(data[1])
+		variableoffset := int(binary.LittleEndian.Uint16(data[2:]))
+		if lastvariable >= 128 && variableoffset+2 <= len(data) {
+			size := binary.LittleEndian.Uint16(data[variableoffset:])
+			start := variableoffset + (lastvariable-127)*2
+			if size&eseMultiValueSizeBit == 0 && start+int(size) <= len(data) {
+				name = string(data[start : start+int(size)])
+			}
+		}
+
+		switch entrytype {
+		case eseCatalogTable:
+			table := &eseTable{
+				name:    name,
+				fdp:     coltypeorfdp,
+				columns: make(map[uint32]*eseColumn),
+				byname:  make(map[string]*eseColumn),
+			}
+			byid[objid] = table
+			db.tables[name] = table
+		case eseCatalogColumn:
+			if table := byid[objid]; table != nil {
+				column := &eseColumn{id: id, name: name, coltype: coltypeorfdp, size: spaceusage, codepage: pagesorlocale}
+				table.columns[id] = column
+				table.byname[name] = column
+			}
+		case eseCatalogLongValue:
+			if table := byid[objid]; table != nil {
+				table.lvfdp = coltypeorfdp
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Problem reading the catalog: %v", err)
+	}
+	if len(db.tables) == 0 {
+		return errors.New("No tables in the catalog")
+	}
+	return nil
+}
+
+// Calls back with every record in the table
+func (db *eseDatabase) Records(table *eseTable, callback func(eseRecord) error) error {
+	return db.walk(table.fdp, func(key, data []byte) error {
+		record, err := db.parseRecord(table, data)
+		if err != nil {
+			return err
+		}
+		return callback(record)
+	})
+}
+
+// Records have the fixed size columns first, then the sizes and data of the variable size columns, and then the
+// tagged columns, which are the ones that are mostly empty and the multi valued ones
+func (db *eseDatabase) parseRecord(table *eseTable, data []byte) (eseRecord, error) {
+	if len(data) < 4 {
+		return nil, errors.New("Record is too short")
+	}
+	record := make(eseRecord)
+	lastfixed := int(data[0])
+	lastvariable := int(data[1])
+	variableoffset := int(binary.LittleEndian.Uint16(data[2:]))
+	if variableoffset > len(data) {
+		return nil, errors.New("Record variable data offset is outside the record")
+	}
+
+	// One bit for each fixed column that is null, right before the variable column sizes
+	nullbits := variableoffset - (lastfixed+7)/8
+	position := 4
+	for id := 1; id <= lastfixed; id++ {
+		column := table.columns[uint32(id)]
+		if column == nil {
+			return nil, fmt.Errorf("Record in %v has fixed column %v that isn't in the catalog", table.name, id)
+		}
+		size := int(column.size)
+		if position+size > nullbits || nullbits < 0 {
+			return nil, fmt.Errorf("Record in %v is too short for its fixed columns", table.name)
+		}
+		if data[nullbits+(id-1)/8]&(1<<uint((id-1)%8)) == 0 {
+			record[column.name] = [][]byte{data[position : position+size]}
+		}
+		position += size
+	}
+
+	var variablecount int
+	if lastvariable > 127 {
+		variablecount = lastvariable - 127
+	}
+	variabledata := variableoffset + variablecount*2
+	if variabledata > len(data) {
+		return nil, fmt.Errorf("Record in %v is too short for its variable columns", table.name)
+	}
+	var previous int
+	for i := 0; i < variablecount; i++ {
+		size := binary.LittleEndian.Uint16(data[variableoffset+i*2:])
+		end := int(size &^ eseMultiValueSizeBit)
+		if size&eseMultiValueSizeBit == 0 {
+			if end < previous || variabledata+end > len(data) {
+				return nil, fmt.Errorf("Record in %v has a variable column outside the record", table.name)
+			}
+			if column := table.columns[uint32(128+i)]; column != nil {
+				record[column.name] = [][]byte{data[variabledata+previous : variabledata+end]}
+			}
+		}
+		previous = end
+	}
+
+	tagged := data[variabledata+previous:]
+	if len(tagged) < 4 {
+		return record, nil
+	}
+	count := int(binary.LittleEndian.Uint16(tagged[2:])&0x3fff) / 4
+	if count == 0 || count*4 > len(tagged) {
+		return nil, fmt.Errorf("Record in %v has a bad tagged column array", table.name)
+	}
+	for i := 0; i < count; i++ {
+		id := binary.LittleEndian.Uint16(tagged[i*4:])
+		offsetvalue := binary.LittleEndian.Uint16(tagged[i*4+2:])
+		start := int(offsetvalue & 0x3fff)
+		end := len(tagged)
+		if i+1 < count {
+			end = int(binary.LittleEndian.Uint16(tagged[i*4+6:]) & 0x3fff)
+		}
+		if start > end || end > len(tagged) {
+			return nil, fmt.Errorf("Record in %v has a tagged column outside the record", table.name)
+		}
+		value := tagged[start:end]
+		var flags byte
+		if (db.largepages() || offsetvalue&0x4000 != 0) && len(value) > 0 {
+			flags = value[0]
+			value = value[1:]
+		}
+		column := table.columns[uint32(id)]
+		if column == nil {
+			continue
+		}
+		values, err := db.taggedValues(table, flags, value)
+		if err != nil {
+			return nil, fmt.Errorf("Column %v in %v: %v", column.name, table.name, err)
+		}
+		record[column.name] = values
+	}
+	return record, nil
+}
+
+func (db *eseDatabase) taggedValues(table *eseTable, flags byte, value []byte) ([][]byte, error) {
+	type part struct {
+		data      []byte
+		longvalue bool
+	}
+	var parts []part
+	switch {
+	case flags&eseValueMultiValue != 0:
+		// Offsets of the values first, the first offset tells how many there are
+		if len(value) < 2 {
+			return nil, errors.New("Multi value is too short")
+		}
+		count := int(binary.LittleEndian.Uint16(value)&0x7fff) / 2
+		if count == 0 || count*2 > len(value) {
+			return nil, errors.New("Multi value has a bad offset array")
+		}
+		for i := 0; i < count; i++ {
+			offset := binary.LittleEndian.Uint16(value[i*2:])
+			start := int(offset & 0x7fff)
+			end := len(value)
+			if i+1 < count {
+				end = int(binary.LittleEndian.Uint16(value[i*2+2:]) & 0x7fff)
+			}
+			if start > end || end > len(value) {
+				return nil, errors.New("Multi value offset outside the value")
+			}
+			parts = append(parts, part{value[start:end], offset&eseMultiValueSizeBit != 0})
+		}
+	case flags&eseValueTwoValues != 0:
+		if len(value) < 1 || int(value[0])+1 > len(value) {
+			return nil, errors.New("Two value column is too short")
+		}
+		parts = []part{{value[1 : 1+int(value[0])], false}, {value[1+int(value[0]):], false}}
+	default:
+		parts = []part{{value, flags&eseValueLongValue != 0}}
+	}
+
+	values := make([][]byte, 0, len(parts))
+	for _, p := range parts {
+		data := p.data
+		if p.longvalue {
+			var err error
+			if data, err = db.longValue(table, data); err != nil {
+				return nil, err
+			}
+		}
+		if flags&eseValueCompressed != 0 {
+			var err error
+			if data, err = eseDecompress(data); err != nil {
+				return nil, err
+			}
+		}
+		values = append(values, data)
+	}
+	return values, nil
+}
+
+// Returns a long value by the id in the record, reading all the long values of the table the first time
+func (db *eseDatabase) longValue(table *eseTable, reference []byte) ([]byte, error) {
+	if table.longvalues == nil {
+		if err := db.readLongValues(table); err != nil {
+			return nil, err
+		}
+	}
+	var id uint64
+	switch len(reference) {
+	case 4:
+		id = uint64(binary.LittleEndian.Uint32(reference))
+	case 8:
+		id = binary.LittleEndian.Uint64(reference)
+	default:
+		return nil, fmt.Errorf("Long value reference of %v bytes", len(reference))
+	}
+	value, found := table.longvalues[id]
+	if !found {
+		return nil, fmt.Errorf("Long value %v is missing", id)
+	}
+	return value, nil
+}
+
+// Long values are stored in their own tree, as a header with the id as key and then chunks with the id and the
+// offset of the chunk as key, both big endian. Newer databases can have 8 byte ids
+func (db *eseDatabase) readLongValues(table *eseTable) error {
+	table.longvalues = make(map[uint64][]byte)
+	if table.lvfdp == 0 {
+		return nil
+	}
+	type chunk struct {
+		key  []byte
+		data []byte
+	}
+	var chunks []chunk
+	idsize := 4
+	err := db.walk(table.lvfdp, func(key, data []byte) error {
+		if len(key) == 12 {
+			idsize = 8
+		}
+		chunks = append(chunks, chunk{append([]byte{}, key...), data})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Problem reading long values of %v: %v", table.name, err)
+	}
+
+	type segment struct {
+		offset uint32
+		data   []byte
+	}
+	segments := make(map[uint64][]segment)
+	for _, c := range chunks {
+		if len(c.key) != idsize+4 {
+			continue // Header with the reference count and size
+		}
+		var id uint64
+		if idsize == 8 {
+			id = binary.BigEndian.Uint64(c.key)
+		} else {
+			id = uint64(binary.BigEndian.Uint32(c.key))
+		}
+		segments[id] = append(segments[id], segment{binary.BigEndian.Uint32(c.key[idsize:]), c.data})
+	}
+	for id, parts := range segments {
+		sort.Slice(parts, func(i, j int) bool {
+			return parts[i].offset < parts[j].offset
+		})
+		var value []byte
+		for _, part := range parts {
+			value = append(value, part.data...)
+		}
+		table.longvalues[id] = value
+	}
+	return nil
+}
+
+// Compression of column values, the type is in the top five bits of the first byte
+func eseDecompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	switch data[0] >> 3 {
+	case 1, 2:
+		// Seven bits per character, for ASCII or for UTF-16 that is all ASCII. Padding bits in the last byte
+		// decode as a NUL, which text values never have
+		unicode := data[0]>>3 == 2
+		count := (len(data) - 1) * 8 / 7
+		result := make([]byte, 0, count*2)
+		var bits uint32
+		var bitcount uint
+		input := data[1:]
+		for i := 0; i < count; i++ {
+			if bitcount < 7 {
+				bits |= uint32(input[0]) << bitcount
+				input = input[1:]
+				bitcount += 8
+			}
+			if bits&0x7f == 0 {
+				break
+			}
+			result = append(result, byte(bits&0x7f))
+			if unicode {
+				result = append(result, 0)
+			}
+			bits >>= 7
+			bitcount -= 7
+		}
+		return result, nil
+	case 3:
+		// XPRESS, with the uncompressed size first
+		if len(data) < 3 {
+			return nil, errors.New("XPRESS compressed value is too short")
+		}
+		return xpressDecompress(data[3:], int(binary.LittleEndian.Uint16(data[1:])))
+	}
+	return nil, fmt.Errorf("Unsupported compression type %v", data[0]>>3)
+}
+
+// Plain LZ77 decompression (MS-XCA section 2.4)
+func xpressDecompress(input []byte, size int) ([]byte, error) {
+	output := make([]byte, 0, size)
+	var flags uint32
+	var flagcount int
+	halfbyte := -1
+	for i := 0; i < len(input); {
+		if flagcount == 0 {
+			if i+4 > len(input) {
+				break
+			}
+			flags = binary.LittleEndian.Uint32(input[i:])
+			i += 4
+			flagcount = 32
+		}
+		flagcount--
+		if flags&(1<<uint(flagcount)) == 0 {
+			if i >= len(input) {
+				break
+			}
+			if len(output) >= size {
+				return nil, errors.New("XPRESS data is longer than the value")
+			}
+			output = append(output, input[i])
+			i++
+			continue
+		}
+		if i+2 > len(input) {
+			break
+		}
+		match := int(binary.LittleEndian.Uint16(input[i:]))
+		i += 2
+		length := match % 8
+		offset := match/8 + 1
+		if length == 7 {
+			if halfbyte == -1 {
+				if i >= len(input) {
+					return nil, errors.New("XPRESS data ends in a match")
+				}
+				length = int(input[i] % 16)
+				halfbyte = i
+				i++
+			} else {
+				length = int(input[halfbyte] / 16)
+				halfbyte = -1
+			}
+			if length == 15 {
+				if i >= len(input) {
+					return nil, errors.New("XPRESS data ends in a match")
+				}
+				length = int(input[i])
+				i++
+				if length == 255 {
+					if i+2 > len(input) {
+						return nil, errors.New("XPRESS data ends in a match")
+					}
+					length = int(binary.LittleEndian.Uint16(input[i:]))
+					i += 2
+					if length == 0 {
+						if i+4 > len(input) {
+							return nil, errors.New("XPRESS data ends in a match")
+						}
+						length = int(binary.LittleEndian.Uint32(input[i:]))
+						i += 4
+					}
+					if length < 15+7 {
+						return nil, errors.New("XPRESS match length is too short")
+					}
+					length -= 15 + 7
+				}
+				length += 15
+			}
+			length += 7
+		}
+		length += 3
+		if offset > len(output) {
+			return nil, errors.New("XPRESS match before the start of the data")
+		}
+		// A damaged page can give any length, so don't let it grow past the size of the value
+		if len(output)+length > size {
+			return nil, errors.New("XPRESS data is longer than the value")
+		}
+		for j := 0; j < length; j++ {
+			output = append(output, output[len(output)-offset])
+		}
+	}
+	return output, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import "strings"
+
+// Exchange. Setup gives its groups rights all over the domain, and before the 2019 updates (or when /PrepareAD wasn't
+// run again after them) Exchange Windows Permissions has WriteDACL on the domain object itself. Exchange Trusted
+// Subsystem is a member of it and the Exchange servers are members of that, so whoever controls an Exchange server,
+// or can add members to one of the groups, can give themselves DCSync (PrivExchange). The updated ACE is inherit only,
+// so it only applies below the domain object and isn't a link here.
+// Mailbox permissions are copied to msExchMailboxSecurityDescriptor on the owner, and msExchDelegateListLink lists who
+// gets the mailbox opened in Outlook. Full access to a mailbox is access to password reset links and one time codes
+// mailed to the owner
+
+var exchangeGroupNames = []string{"Exchange Windows Permissions", "Exchange Trusted Subsystem", "Exchange Enterprise Servers"}
+
+// Mailbox rights, which aren't the same as the directory ones
+const (
+	mailboxRightFullAccess       = 0x00000001
+	mailboxRightChangePermission = RIGHT_WRITE_DACL
+	mailboxRightChangeOwner      = RIGHT_WRITE_OWNER
+)
+
+// Mailbox owner -> who has full access to it, and why
+var mailboxDelegates map[*Object]map[*Object]string
+
+func isExchangeGroup(o *Object) bool {
+	if o.Type() != ObjectTypeGroup {
+		return false
+	}
+	for _, name := range exchangeGroupNames {
+		if strings.EqualFold(o.OneAttr(SAMAccountName), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the Exchange groups that can change the permissions on the domain object
+func exchangeDomainDACLWriters(domain *Object) []*Object {
+	sd, err := domain.SecurityDescriptor()
+	if err != nil {
+		return nil
+	}
+	var results []*Object
+	for _, acl := range sd.DACL.Entries {
+		if !acl.AllowObjectClass(domain) || !acl.AllowMaskedClass(RIGHT_WRITE_DACL, NullGUID) {
+			continue
+		}
+		if group, found := AllObjects.FindSID(acl.SID); found && isExchangeGroup(group) {
+			SetEdgeReason(group, domain, PwnExchangeWriteDACL, group.OneAttr(SAMAccountName)+" has WriteDACL on "+domain.OneAttr(Name)+", as Exchange setup left it before the 2019 updates")
+			results = append(results, group)
+		}
+	}
+	return results
+}
+
+func addMailboxDelegate(owner, delegate *Object, reason string) {
+	if owner == delegate || delegate.SID() == SelfSID {
+		return
+	}
+	if mailboxDelegates[owner] == nil {
+		mailboxDelegates[owner] = make(map[*Object]string)
+	}
+	if existing, found := mailboxDelegates[owner][delegate]; found {
+		reason = existing + "; " + reason
+	}
+	mailboxDelegates[owner][delegate] = reason
+}
+
+func buildMailboxDelegates() {
+	mailboxDelegates = make(map[*Object]map[*Object]string)
+	for _, o := range AllObjects.AsArray() {
+		if data := o.OneAttr(MSExchMailboxSD); data != "" {
+			if sd, err := ParseSecurityDescriptor([]byte(data)); err == nil {
+				for _, acl := range sd.DACL.Entries {
+					if acl.Type != ACETYPE_ACCESS_ALLOWED || acl.ACEFlags&ACEFLAG_INHERIT_ONLY_ACE != 0 {
+						continue
+					}
+					var rights []string
+					if acl.Mask&mailboxRightFullAccess != 0 {
+						rights = append(rights, "FullAccess")
+					}
+					if acl.Mask&mailboxRightChangePermission != 0 {
+						rights = append(rights, "ChangePermission")
+					}
+					if acl.Mask&mailboxRightChangeOwner != 0 {
+						rights = append(rights, "ChangeOwner")
+					}
+					if len(rights) > 0 {
+						addMailboxDelegate(o, AllObjects.FindOrAddSID(acl.SID), strings.Join(rights, " and ")+" on the mailbox")
+					}
+				}
+			}
+		}
+		for _, dn := range o.Attr(MSExchDelegateListLink) {
+			if delegate, found := AllObjects.Find(dn); found {
+				addMailboxDelegate(o, delegate, "Gets the mailbox opened in Outlook (msExchDelegateListLink)")
+			}
+		}
+	}
+}
+
+// Returns who can open the mailbox of the owner
+func mailboxAccessors(owner *Object) []*Object {
+	var results []*Object
+	for delegate, reason := range mailboxDelegates[owner] {
+		SetEdgeReason(delegate, owner, PwnMailboxFullAccess, reason)
+		results = append(results, delegate)
+	}
+	return results
+}
@@ -3,13 +3,16 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"os"
 	"sort"
+	"strings"
 
+	"github.com/gofrs/uuid"
 	"github.com/rs/zerolog/log"
 )
 
-func ExportGraphViz(pg PwnGraph, filename string) error {
+func ExportGraphViz(pg PwnGraph, filename string, aggregate bool) error {
 	df, _ := os.Create(filename)
 	defer df.Close()
 
@@ -23,14 +26,232 @@ func ExportGraphViz(pg PwnGraph, filename string) error {
 		fmt.Fprintf(df, "    \"%v\" [label=\"%v\";%v];\n", object.GUID(), object.OneAttr(Name), formatting)
 	}
 	fmt.Fprintln(df, "")
-	for _, connection := range pg.Connections {
-		fmt.Fprintf(df, "    \"%v\" -> \"%v\" [label=\"%v\"];\n", connection.Source.GUID(), connection.Target.GUID(), connection.Methods.JoinedString())
+	for _, edge := range ExportEdges(pg, aggregate) {
+		var both string
+		if edge.ReverseMethods != 0 {
+			both = ";dir=both"
+		}
+		fmt.Fprintf(df, "    \"%v\" -> \"%v\" [label=\"%v\"%v];\n", edge.Source.GUID(), edge.Target.GUID(), edge.Label(), both)
 	}
 	fmt.Fprintln(df, "}")
 
 	return nil
 }
 
+// One edge in an exported graph. Aggregated, the connections both ways between two objects are one edge
+type ExportEdge struct {
+	Source, Target *Object
+	Methods        PwnMethod // From source to target
+	ReverseMethods PwnMethod // From target to source, only when aggregated
+	Count          int       // How many connections (a method one way) are in the edge, only when aggregated
+}
+
+func (e ExportEdge) Label() string {
+	label := e.Methods.JoinedString()
+	if e.ReverseMethods != 0 {
+		label += " / back: " + e.ReverseMethods.JoinedString()
+	}
+	if e.Count > 0 {
+		label += fmt.Sprintf(" (%v)", e.Count)
+	}
+	return label
+}
+
+// Returns the edges to export, one for each connection or aggregated into one for each pair of objects. Tools
+// drawing every connection on its own end up with bundles of lines between the same objects otherwise
+func ExportEdges(pg PwnGraph, aggregate bool) []ExportEdge {
+	edges := make([]ExportEdge, 0, len(pg.Connections))
+	if !aggregate {
+		for _, connection := range pg.Connections {
+			edges = append(edges, ExportEdge{Source: connection.Source, Target: connection.Target, Methods: connection.Methods})
+		}
+		return edges
+	}
+	index := make(map[PwnPair]int)
+	for _, connection := range pg.Connections {
+		if i, found := index[PwnPair{Source: connection.Target, Target: connection.Source}]; found {
+			edges[i].ReverseMethods |= connection.Methods
+			edges[i].Count += len(connection.Methods.StringSlice())
+			continue
+		}
+		pair := PwnPair{Source: connection.Source, Target: connection.Target}
+		i, found := index[pair]
+		if !found {
+			i = len(edges)
+			index[pair] = i
+			edges = append(edges, ExportEdge{Source: connection.Source, Target: connection.Target})
+		}
+		edges[i].Methods |= connection.Methods
+		edges[i].Count += len(connection.Methods.StringSlice())
+	}
+	log.Debug().Msgf("Aggregated %v connections into %v edges", len(pg.Connections), len(edges))
+	return edges
+}
+
+// Cuts a graph down before exporting it, as whole domains are too much for Graphviz to render
+type ExportFilter struct {
+	MinRadius       int  // Leave out objects with fewer than this many objects getting to the targets through them
+	MaxNodes        int  // Keep at most this many objects, the ones closest to the targets
+	CollapseMembers bool // Group members connected to the same objects in the same ways become one node
+}
+
+func FilterGraph(pg PwnGraph, mode string, filter ExportFilter) PwnGraph {
+	// Connections point from attacker to victim, so with the normal mode the objects getting to the targets are the
+	// sources, and when inverted they are the victims. Away is from the targets towards those objects
+	forward := strings.HasPrefix(mode, "normal")
+	away := make(map[*Object][]*Object)
+	for _, connection := range pg.Connections {
+		if forward {
+			away[connection.Target] = append(away[connection.Target], connection.Source)
+		} else {
+			away[connection.Source] = append(away[connection.Source], connection.Target)
+		}
+	}
+
+	targets := make(map[*Object]struct{})
+	for _, target := range pg.Targets {
+		targets[target] = struct{}{}
+	}
+
+	keep := make(map[*Object]struct{})
+	for _, object := range pg.Implicated {
+		if _, istarget := targets[object]; istarget || filter.MinRadius <= 0 || blastRadius(object, away, filter.MinRadius) >= filter.MinRadius {
+			keep[object] = struct{}{}
+		}
+	}
+
+	// An object always has more behind it than the ones it gets through, so what is left still leads to the targets.
+	// Going out from the targets keeps that true when only some can be kept
+	if filter.MaxNodes > 0 && len(keep) > filter.MaxNodes {
+		closest := make(map[*Object]struct{})
+		queue := make([]*Object, 0, filter.MaxNodes)
+		for _, target := range pg.Targets {
+			if _, found := closest[target]; !found && len(closest) < filter.MaxNodes {
+				closest[target] = struct{}{}
+				queue = append(queue, target)
+			}
+		}
+		for i := 0; i < len(queue) && len(closest) < filter.MaxNodes; i++ {
+			for _, next := range away[queue[i]] {
+				if _, kept := keep[next]; !kept {
+					continue
+				}
+				if _, found := closest[next]; !found && len(closest) < filter.MaxNodes {
+					closest[next] = struct{}{}
+					queue = append(queue, next)
+				}
+			}
+		}
+		keep = closest
+	}
+
+	var result PwnGraph
+	result.Targets = pg.Targets
+	var connections []PwnConnection
+	for _, connection := range pg.Connections {
+		_, keepsource := keep[connection.Source]
+		_, keeptarget := keep[connection.Target]
+		if keepsource && keeptarget {
+			connections = append(connections, connection)
+		}
+	}
+
+	// Members of a group that are connected to exactly the same objects in the same ways become one node
+	collapsed := make(map[*Object]*Object)
+	if filter.CollapseMembers {
+		signatures := make(map[*Object][]string)
+		groups := make(map[*Object][]*Object)
+		for _, connection := range connections {
+			signatures[connection.Source] = append(signatures[connection.Source], fmt.Sprintf("to %p %v", connection.Target, connection.Methods))
+			signatures[connection.Target] = append(signatures[connection.Target], fmt.Sprintf("from %p %v", connection.Source, connection.Methods))
+			if connection.Methods&PwnMemberOfGroup != 0 {
+				groups[connection.Source] = append(groups[connection.Source], connection.Target)
+			}
+		}
+		alike := make(map[string][]*Object)
+		var order []string
+		for _, object := range pg.Implicated {
+			if _, istarget := targets[object]; istarget || len(groups[object]) == 0 {
+				continue
+			}
+			signature := signatures[object]
+			sort.Strings(signature)
+			key := strings.Join(signature, ";")
+			if alike[key] == nil {
+				order = append(order, key)
+			}
+			alike[key] = append(alike[key], object)
+		}
+		for _, key := range order {
+			members := alike[key]
+			if len(members) < 2 {
+				continue
+			}
+			var grouplabels []string
+			for _, group := range groups[members[0]] {
+				grouplabels = append(grouplabels, group.Label())
+			}
+			u, _ := uuid.NewV4()
+			node := &Object{
+				DistinguishedName: "CN=" + u.String() + ",CN=collapsed",
+				Attributes: map[Attribute][]string{
+					Name:       {fmt.Sprintf("%v members of %v", len(members), strings.Join(grouplabels, ", "))},
+					ObjectGUID: {string(u.Bytes())},
+				},
+			}
+			for _, member := range members {
+				collapsed[member] = node
+			}
+		}
+	}
+
+	added := make(map[*Object]struct{})
+	for _, object := range pg.Implicated {
+		if _, kept := keep[object]; !kept {
+			continue
+		}
+		if node, gone := collapsed[object]; gone {
+			object = node
+		}
+		if _, found := added[object]; !found {
+			added[object] = struct{}{}
+			result.Implicated = append(result.Implicated, object)
+		}
+	}
+	merged := make(map[PwnPair]struct{})
+	for _, connection := range connections {
+		if node, gone := collapsed[connection.Source]; gone {
+			connection.Source = node
+		}
+		if node, gone := collapsed[connection.Target]; gone {
+			connection.Target = node
+		}
+		pair := PwnPair{Source: connection.Source, Target: connection.Target}
+		if _, found := merged[pair]; !found {
+			merged[pair] = struct{}{}
+			result.Connections = append(result.Connections, connection)
+		}
+	}
+
+	log.Info().Msgf("Exporting %v of %v objects and %v of %v connections", len(result.Implicated), len(pg.Implicated), len(result.Connections), len(pg.Connections))
+	return result
+}
+
+// Counts the objects getting to the targets through this one, stopping at max as that's all the filter needs
+func blastRadius(object *Object, away map[*Object][]*Object, max int) int {
+	seen := map[*Object]struct{}{object: {}}
+	queue := []*Object{object}
+	for i := 0; i < len(queue) && len(seen)-1 < max; i++ {
+		for _, next := range away[queue[i]] {
+			if _, found := seen[next]; !found {
+				seen[next] = struct{}{}
+				queue = append(queue, next)
+			}
+		}
+	}
+	return len(seen) - 1
+}
+
 /*
 type CytoID string
 
@@ -57,6 +278,8 @@ type EdgeData struct {
 	Source               string   `json:"source"`
 	Target               string   `json:"target"`
 	Methods              []string `json:"methods,omitempty"`
+	Reasons              []string `json:"reasons,omitempty"`
+	Confidence           float64  `json:"confidence,omitempty"` // Only for connections that may not work, from aging data or luck
 	PwnACLContainsDeny   bool     `json:"pwn_aclcontainsdeny,omitempty"`
 	PwnOwns              bool     `json:"pwn_owns,omitempty"`
 	PwnMemberOfGroup     bool     `json:"pwn_memberofgroup,omitempty"`
@@ -149,7 +372,7 @@ func GenerateCytoscapeJS(pg PwnGraph, alldetails bool) (CytoGraph, error) {
 				SAMAccountName.String():    object.OneAttr(SAMAccountName),
 			}}
 
-		for attr, values := range object.Attributes {
+		for attr, values := range object.AttributesSnapshot() {
 			if attr.IsMeta() || alldetails {
 				if len(values) == 1 {
 					newnode.Data[attr.String()] = values[0]
@@ -184,12 +407,19 @@ func GenerateCytoscapeJS(pg PwnGraph, alldetails bool) (CytoGraph, error) {
 			continue
 		}
 
+		var confidence float64
+		if c, uncertain := EdgeConfidence(connection.Source, connection.Target, connection.Methods); uncertain {
+			confidence = math.Max(c, 0.01) // zero would be left out
+		}
+
 		g.Elements.Edges[edgecount] = CytoEdge{
 			Data: EdgeData{
 				Id:                   fmt.Sprintf("e%v", idcount),
 				Source:               fmt.Sprintf("n%v", sourceid),
 				Target:               fmt.Sprintf("n%v", targetid),
 				Methods:              connection.Methods.StringSlice(),
+				Reasons:              EdgeReasons(connection.Source, connection.Target, connection.Methods),
+				Confidence:           confidence,
 				PwnACLContainsDeny:   PwnACLContainsDeny&connection.Methods != 0,
 				PwnOwns:              PwnOwns&connection.Methods != 0,
 				PwnGenericAll:        PwnGenericAll&connection.Methods != 0,
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Connects to a domain controller of the trusted domain, found with DNS like when joining it
+func connectTrustedDomain(from *AD, domain string) (*AD, error) {
+	servers := FindDomainControllers(domain)
+	if len(servers) == 0 {
+		// The domain name points to the domain controllers too
+		servers = []string{domain}
+	}
+	var lasterr error
+	for _, server := range servers {
+		other := from.OtherDomain(domain, server)
+		if lasterr = other.Connect(from.authmode); lasterr == nil {
+			return other, nil
+		}
+		log.Debug().Msgf("Problem connecting to %v for %v: %v", server, domain, explainBindError(lasterr))
+	}
+	return nil, fmt.Errorf("no domain controller for %v could be used, the last one said: %v", domain, explainBindError(lasterr))
+}
+
+// Dumps the domains that the dumped domain has trusts with, then the ones those have trusts with and so on, each
+// into its own file in datapath. Only trusts that let accounts from the domain in are followed, unless there are
+// credentials for the other domain in ReferralCredentials. Returns the domains in the dumps, including the first one
+func DumpTrustedDomains(ad *AD, datapath string, redactor *Redactor, query string, attributes []string, nosacl bool, pagesize int, parallel int) []string {
+	dumped := []string{strings.ToLower(ad.Domain)}
+	seen := map[string]struct{}{strings.ToLower(ad.Domain): {}}
+	queue := []*AD{ad}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		trusts, err := current.Trusts()
+		if err != nil {
+			log.Warn().Msgf("Problem listing the trusts of %v: %v", current.Domain, err)
+		}
+		for _, trust := range trusts {
+			if _, done := seen[trust.Partner]; done || trust.Partner == "" {
+				continue
+			}
+			seen[trust.Partner] = struct{}{}
+
+			if _, credentials := ad.ReferralCredentials[trust.Partner]; !credentials && trust.Direction&TRUST_DIRECTION_INBOUND == 0 {
+				log.Info().Msgf("Skipping %v, the %v trust from %v doesn't let its accounts in (add credentials for it with -referralcredentials)", trust.Partner, trustDirectionString(trust.Direction), current.Domain)
+				continue
+			}
+
+			log.Info().Msgf("Following %v %v trust from %v to %v ...", trustDirectionString(trust.Direction), trustTypeString(trust.Attributes), current.Domain, trust.Partner)
+			other, err := connectTrustedDomain(current, trust.Partner)
+			if err != nil {
+				log.Warn().Msgf("Skipping %v: %v", trust.Partner, err)
+				continue
+			}
+			contexts, err := other.NamingContexts()
+			if err != nil {
+				log.Warn().Msgf("Skipping %v, problem reading its naming contexts: %v", trust.Partner, err)
+				other.Disconnect()
+				continue
+			}
+
+			err = writeDumpStream(filepath.Join(datapath, trust.Partner+".objects.lz4.msgp"), func(e *msgp.Writer) error {
+				return DumpNamingContexts(other, contexts, nil, redactor, query, attributes, nosacl, pagesize, parallel, e)
+			})
+			if err != nil {
+				log.Warn().Msgf("Problem dumping %v: %v", trust.Partner, err)
+				other.Disconnect()
+				continue
+			}
+			dumped = append(dumped, trust.Partner)
+			queue = append(queue, other)
+		}
+		if current != ad {
+			current.Disconnect()
+		}
+	}
+	return dumped
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Global Catalog ports, for plain LDAP and StartTLS, and for LDAPS
+const (
+	GlobalCatalogPort    = 3268
+	GlobalCatalogTLSPort = 3269
+)
+
+// Connects to the Global Catalog on the same server, which answers for every domain in the forest but only with
+// the partial attribute set (group memberships, SIDs, security descriptors and a few more)
+func (ad *AD) ConnectGlobalCatalog() (*AD, error) {
+	gc := ad.OtherDomain(ad.Domain, ad.Server)
+	gc.ReferralCredentials = nil // It has the whole forest, there is nothing to refer to
+	gc.quiet = ad.quiet
+	gc.Port = GlobalCatalogPort
+	if ad.TLSMode == TLS {
+		gc.Port = GlobalCatalogTLSPort
+	}
+	return gc, gc.Connect(ad.authmode)
+}
+
+// Returns the partition that dn is in, which is the one with the longest DN that dn ends with, as domains can be
+// below other domains
+func owningPartition(dn string, partitions []Partition) (Partition, bool) {
+	dn = strings.ToLower(dn)
+	var result Partition
+	var found bool
+	for _, partition := range partitions {
+		pdn := strings.ToLower(partition.DN)
+		if (dn == pdn || strings.HasSuffix(dn, ","+pdn)) && len(pdn) > len(result.DN) {
+			result, found = partition, true
+		}
+	}
+	return result, found
+}
+
+// Dumps the forest: the partial attribute set of everything from the Global Catalog in one pass, then the naming
+// contexts of this domain in full, then the other domains in full where they can be reached. Objects from domains
+// that couldn't be dumped in full are saved with what the Global Catalog has on them, so memberships across domains
+// in the forest are still there
+func DumpForest(ad *AD, contexts []NamingContext, exclusions []string, redactor *Redactor, query string, attributes []string, nosacl bool, pagesize int, parallel int, e *msgp.Writer) error {
+	partitions, err := ad.Partitions()
+	if err != nil {
+		return fmt.Errorf("Problem listing the naming contexts in the forest: %v", err)
+	}
+
+	gc, err := ad.ConnectGlobalCatalog()
+	if err != nil {
+		return fmt.Errorf("Problem connecting to the Global Catalog on %v port %v (is it a Global Catalog?): %v", gc.Server, gc.Port, explainBindError(err))
+	}
+	log.Info().Msgf("Dumping the forest from the Global Catalog on %v ...", gc.Server)
+	gcobjects, err := gc.Dump("", query, attributes, nosacl, pagesize)
+	gc.Disconnect()
+	if err != nil {
+		return fmt.Errorf("Problem dumping from the Global Catalog: %v", err)
+	}
+	log.Info().Msgf("Got %v objects from the Global Catalog", len(gcobjects))
+
+	if err = DumpNamingContexts(ad, contexts, exclusions, redactor, query, attributes, nosacl, pagesize, parallel, e); err != nil {
+		return err
+	}
+
+	// Naming contexts that are in the dump in full, by their partition
+	full := make(map[string]struct{})
+	for _, nc := range contexts {
+		if partition, found := owningPartition(nc.DN, partitions); found {
+			full[strings.ToLower(partition.DN)] = struct{}{}
+		}
+	}
+
+	for _, partition := range partitions {
+		if _, done := full[strings.ToLower(partition.DN)]; done || !partition.Domain {
+			continue
+		}
+		if _, followed := ad.referralsfollowed[strings.ToLower(partition.DN)]; followed {
+			// Dumped in full by following a referral
+			full[strings.ToLower(partition.DN)] = struct{}{}
+			continue
+		}
+		other := ad.OtherDomain(partition.DNSRoot, partition.DNSRoot)
+		if err := other.Connect(ad.authmode); err != nil {
+			log.Warn().Msgf("Can't connect to %v, it only has what the Global Catalog has: %v", partition.DNSRoot, explainBindError(err))
+			continue
+		}
+		objects, withoutsd, err := dumpNamingContext(other, NamingContext{Name: partition.DNSRoot, DN: partition.DN}, exclusions, redactor, query, attributes, nosacl, pagesize)
+		other.Disconnect()
+		if err != nil {
+			log.Warn().Msgf("Problem dumping %v, it only has what the Global Catalog has: %v", partition.DNSRoot, err)
+			continue
+		}
+		for _, object := range objects {
+			if err := object.EncodeMsg(e); err != nil {
+				return fmt.Errorf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
+			}
+		}
+		if withoutsd > 0 {
+			log.Warn().Msgf("%v objects from %v are in the dump without a security descriptor, analysis of who controls them is incomplete", withoutsd, partition.DNSRoot)
+		}
+		full[strings.ToLower(partition.DN)] = struct{}{}
+	}
+
+	var added int
+	for _, object := range gcobjects {
+		if partition, found := owningPartition(object.DistinguishedName, partitions); found {
+			if _, done := full[strings.ToLower(partition.DN)]; done {
+				continue
+			}
+		}
+		if dnExcluded(object.DistinguishedName, exclusions) {
+			continue
+		}
+		if redactor != nil {
+			redactor.Redact(object)
+		}
+		if err := object.EncodeMsg(e); err != nil {
+			return fmt.Errorf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
+		}
+		added++
+	}
+	if added > 0 {
+		log.Info().Msgf("Saved %v objects with only the attributes from the Global Catalog", added)
+	}
+	return nil
+}
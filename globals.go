@@ -31,3 +31,40 @@ func init() {
 	AllObjects.Init("")
 	AllObjects.Add(AttackerObject)
 }
+
+// Forgets everything loaded and found by the analysis, so another set of objects can be analyzed in the same run
+func resetObjects() {
+	AttackerObject.CanPwn, AttackerObject.PwnableBy = nil, nil
+	AllObjects = Objects{}
+	AllObjects.Init("")
+	AllObjects.Add(AttackerObject)
+	AllRights = make(map[uuid.UUID]*Object)
+	AllSchemaClasses = make(map[uuid.UUID]*Object)
+	AllSchemaAttributes = make(map[uuid.UUID]*Object)
+	attributeSyntaxes = make(map[Attribute]AttributeSyntaxType)
+	PwnAnalyzers = PwnAnalyzers[:builtinPwnAnalyzers]
+	SecurityDescriptorCache = make(map[uint32]*SecurityDescriptor)
+	AllEdgeDetails = make(map[PwnPair][]EdgeDetail)
+	AllMachines = make(map[string]*LocalMachine)
+	AllTenants = nil
+	idpAccounts = make(map[*Object]*Object)
+	idpAdmins = make(map[string][]*Object)
+	awsSources = make(map[*Object][]*Object)
+	awsAssignments = make(map[*Object][]*Object)
+	awsAdminRoles = make(map[*Object][]*Object)
+	entraSyncSources = make(map[*Object][]*Object)
+	entraHybridDevices = make(map[*Object]*Object)
+	entraOwnedDevices = make(map[*Object][]*Object)
+	entraConnectHosts = make(map[*Object]*Object)
+	entraRoleAdmins = make(map[*Object][]*Object)
+	entraImmutableIDs = nil
+	gpoSettings = make(map[*Object]*GPOSettings)
+	for attribute := range attributeobjects {
+		attributeobjects[attribute], attributeredacted[attribute] = 0, 0
+	}
+	strippedBytes = 0
+	compressedBytes, uncompressedBytes = 0, 0
+	problemlock.Lock()
+	AllProblems = nil
+	problemlock.Unlock()
+}
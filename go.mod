@@ -5,7 +5,6 @@ go 1.16
 require (
 	github.com/OneOfOne/xxhash v1.2.8
 	github.com/Showmax/go-fqdn v1.0.0
-	github.com/alexbrainman/sspi v0.0.0-20210105120005-909beea2cc74 // indirect
 	github.com/elazarl/go-bindata-assetfs v1.0.1
 	github.com/frankban/quicktest v1.13.0 // indirect
 	github.com/gin-gonic/gin v1.7.2
@@ -15,19 +14,21 @@ require (
 	github.com/gomarkdown/markdown v0.0.0-20210514010506-3b9f47219fe7
 	github.com/gorilla/mux v1.8.0
 	github.com/icza/gox v0.0.0-20201215141822-6edfac6c05b5
+	github.com/jcmturner/gokrb5/v8 v8.4.2
 	github.com/json-iterator/go v1.1.11
-	github.com/lkarlslund/ldap/v3 v3.2.4-0.20210621153959-85555023df29 // indirect
+	github.com/lkarlslund/ldap/v3 v3.2.4-0.20210621153959-85555023df29
 	github.com/lkarlslund/stringdedup v0.2.1
-	github.com/lkarlslund/time-timespan v0.0.0-20210712111050-6e7c565fa001 // indirect
+	github.com/lkarlslund/time-timespan v0.0.0-20210712111050-6e7c565fa001
 	github.com/mattn/go-colorable v0.1.8
 	github.com/pierrec/lz4 v2.6.1+incompatible
 	github.com/rs/zerolog v1.23.0
 	github.com/schollz/progressbar/v3 v3.8.1
-	github.com/stretchr/testify v1.7.0 // indirect
 	github.com/tinylib/msgp v1.1.5
+	github.com/xitongsys/parquet-go v1.6.2
 	golang.org/x/crypto v0.0.0-20210616213533-5ff15b29337e
-	golang.org/x/sys v0.0.0-20210616094352-59db8d763f22 // indirect
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac
 	golang.org/x/text v0.3.6
-	gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+	modernc.org/sqlite v1.14.2
 )
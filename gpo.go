@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Which GPOs apply to a user or computer. The links in gPLink on the domain and the OUs apply to the objects below
+// them, and links on a site to the computers in it. Blocking inheritance with gPOptions on a container stops the
+// links above it, except the enforced ones. Security filtering leaves out the objects that don't have the Apply
+// Group Policy right on the GPO. WMI filters are evaluated on the client, so they're not taken into account
+
+// Flags of a link in gPLink
+const (
+	gpLinkDisabled = 1
+	gpLinkEnforced = 2
+)
+
+var ExtendedRightApplyGroupPolicy, _ = uuid.FromString("{EDACFD8F-FFB3-11D1-B41D-00A0C968F939}")
+
+type gpoLink struct {
+	GPO       *Object
+	Container *Object
+	Enforced  bool
+}
+
+// Container -> enabled GPO links on it
+var gpoContainerLinks map[*Object][]gpoLink
+
+// Domain controller -> the site its server object is in. Sites of other computers depend on their IP address
+var gpoDCSites map[*Object]*Object
+
+// GPO -> SIDs with the Apply Group Policy right on it
+var gpoApplySIDs map[*Object]map[SID]struct{}
+
+// SIDs every user and computer has in its token
+var gpoImplicitSIDs = []string{"S-1-1-0", "S-1-5-11"}
+
+// Parses gPLink, which looks like [LDAP://cn={GUID},cn=policies,cn=system,DC=contoso,DC=local;0][...]
+func parseGPLink(container *Object) []gpoLink {
+	var results []gpoLink
+	gplinks := strings.TrimSpace(container.OneAttr(GPLink))
+	for _, link := range strings.Split(gplinks, "]") {
+		link = strings.TrimPrefix(strings.TrimSpace(link), "[")
+		if link == "" {
+			continue
+		}
+		separator := strings.LastIndex(link, ";")
+		prefix := strings.Index(link, "//")
+		if separator == -1 || prefix == -1 || prefix > separator {
+			log.Warn().Msgf("Error parsing gPLink on %v: %v", container.DN(), gplinks)
+			continue
+		}
+		flags, err := strconv.Atoi(link[separator+1:])
+		if err != nil {
+			log.Warn().Msgf("Error parsing gPLink on %v: %v", container.DN(), gplinks)
+			continue
+		}
+		// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-gpol/08090b22-bc16-49f4-8e10-f27a8fb16d18
+		if flags&gpLinkDisabled != 0 {
+			continue
+		}
+		gpodn := link[prefix+2 : separator]
+		gpo, found := AllObjects.Find(gpodn)
+		if !found {
+			log.Debug().Msgf("GPO %v linked on %v is not in the data", gpodn, container.DN())
+			continue
+		}
+		results = append(results, gpoLink{GPO: gpo, Container: container, Enforced: flags&gpLinkEnforced != 0})
+	}
+	return results
+}
+
+// Finds the GPO links, the sites of the domain controllers and the security filtering of the GPOs
+func buildGPOLinks() {
+	gpoContainerLinks = make(map[*Object][]gpoLink)
+	gpoDCSites = make(map[*Object]*Object)
+	gpoApplySIDs = make(map[*Object]map[SID]struct{})
+
+	for _, o := range AllObjects.AsArray() {
+		if o.OneAttr(GPLink) != "" {
+			if links := parseGPLink(o); len(links) > 0 {
+				gpoContainerLinks[o] = links
+			}
+		}
+
+		// CN=DC1,CN=Servers,CN=Site,CN=Sites,CN=Configuration,...
+		if reference := o.OneAttr(ServerReference); reference != "" && StringInSlice("server", o.Attr(ObjectClass)) {
+			dc, found := AllObjects.Find(reference)
+			if !found {
+				continue
+			}
+			if servers, found := AllObjects.Parent(o); found {
+				if site, found := AllObjects.Parent(servers); found && StringInSlice("site", site.Attr(ObjectClass)) {
+					gpoDCSites[dc] = site
+				}
+			}
+		}
+
+		if o.Type() == ObjectTypeGroupPolicyContainer {
+			sd, err := o.SecurityDescriptor()
+			if err != nil {
+				continue
+			}
+			sids := make(map[SID]struct{})
+			for _, acl := range sd.DACL.Entries {
+				if acl.AllowObjectClass(o) && acl.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, ExtendedRightApplyGroupPolicy) {
+					sids[acl.SID] = struct{}{}
+				}
+			}
+			gpoApplySIDs[o] = sids
+		}
+	}
+}
+
+// Returns the links of the GPOs that apply to the user or computer, farthest first like they're processed
+func gpoAppliedLinks(o *Object) []gpoLink {
+	var results []gpoLink
+	var blocked bool
+	add := func(container *Object) {
+		// Closer links are found first, so they go in front of the farther ones
+		var links []gpoLink
+		for _, link := range gpoContainerLinks[container] {
+			if !blocked || link.Enforced {
+				links = append(links, link)
+			}
+		}
+		results = append(links, results...)
+	}
+
+	p := o
+	for {
+		var hasparent bool
+		p, hasparent = AllObjects.Parent(p)
+		if !hasparent {
+			break
+		}
+		add(p)
+		if options, ok := p.AttrInt(GPOptions); ok && options&1 != 0 {
+			// Inheritance is blocked, so only enforced links from above here apply
+			blocked = true
+		}
+	}
+	if site, found := gpoDCSites[o]; found {
+		add(site)
+	}
+
+	var filtered []gpoLink
+	for _, link := range results {
+		if gpoAppliesTo(link.GPO, o) {
+			filtered = append(filtered, link)
+		}
+	}
+	return filtered
+}
+
+// Does security filtering on the GPO let it apply to the user or computer?
+func gpoAppliesTo(gpo, o *Object) bool {
+	sids, found := gpoApplySIDs[gpo]
+	if !found {
+		// No security descriptor, so assume it applies
+		return true
+	}
+	if _, found := sids[o.SID()]; found {
+		return true
+	}
+	for _, sid := range gpoImplicitSIDs {
+		binsid, _ := SIDFromString(sid)
+		if _, found := sids[binsid]; found {
+			return true
+		}
+	}
+	for _, group := range o.MemberOfRecursive() {
+		if _, found := sids[group.SID()]; found {
+			return true
+		}
+	}
+	return false
+}
+
+func gpoLinkReason(link gpoLink, o *Object) string {
+	reason := "linked on " + link.Container.Label()
+	if site, found := gpoDCSites[o]; found && site == link.Container {
+		reason = "linked on site " + site.OneAttr(Name) + ", which the domain controller is in"
+	}
+	if link.Enforced {
+		reason += " (enforced)"
+	}
+	return reason
+}
+
+// Returns the GPOs that apply to the object, with the links they apply through as the reason
+func gpoAffecting(o *Object, method PwnMethod) []*Object {
+	var results []*Object
+	for _, link := range gpoAppliedLinks(o) {
+		SetEdgeReason(link.GPO, o, method, gpoLinkReason(link, o))
+		results = append(results, link.GPO)
+	}
+	return results
+}
+
+// GPOs by how many users and computers they apply to, with who can change them
+func gpoReachReport() []Finding {
+	type reach struct {
+		gpo                     *Object
+		computers, users, tier0 int
+	}
+	var reaches []reach
+	for _, gpo := range AllObjects.AsArray() {
+		if gpo.Type() != ObjectTypeGroupPolicyContainer {
+			continue
+		}
+		r := reach{gpo: gpo}
+		for _, pwninfo := range gpo.CanPwnSnapshot() {
+			switch {
+			case pwninfo.Method&PwnComputerAffectedByGPO != 0:
+				r.computers++
+			case pwninfo.Method&PwnUserAffectedByGPO != 0:
+				r.users++
+			default:
+				continue
+			}
+			if pwninfo.Target.IsTier0() {
+				r.tier0++
+			}
+		}
+		if r.computers+r.users > 0 {
+			reaches = append(reaches, r)
+		}
+	}
+	sort.Slice(reaches, func(i, j int) bool {
+		if reaches[i].tier0 != reaches[j].tier0 {
+			return reaches[i].tier0 > reaches[j].tier0
+		}
+		return reaches[i].computers+reaches[i].users > reaches[j].computers+reaches[j].users
+	})
+
+	var findings []Finding
+	for _, r := range reaches {
+		detail := fmt.Sprintf("GPO %v applies to %v computers and %v users", Default(r.gpo.OneAttr(DisplayName), r.gpo.OneAttr(Name)), r.computers, r.users)
+		if r.tier0 > 0 {
+			detail += fmt.Sprintf(", %v of them tier 0", r.tier0)
+		}
+		var editors []*Object
+		for _, controller := range adcsControllers(r.gpo) {
+			if detectionUnexpected(controller) {
+				editors = append(editors, controller)
+			}
+		}
+		if len(editors) > 0 {
+			detail += " - can be changed by " + detectionLabels(editors)
+		}
+		findings = append(findings, Finding{r.gpo.DN(), detail})
+	}
+	return findings
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Group Policy Preferences items with a Properties element - a path for Drive, SharedPrinter, PortPrinter, and an
+// account for User, NTService, Task and DataSource
+type gppFile struct {
+	Items []gppItem `xml:",any"`
+}
+
+type gppItem struct {
+	XMLName    xml.Name
+	Name       string `xml:"name,attr"`
+	Properties struct {
+		Action    string `xml:"action,attr"`
+		Path      string `xml:"path,attr"`
+		Letter    string `xml:"letter,attr"`
+		UserName  string `xml:"userName,attr"`
+		Username  string `xml:"username,attr"` // Printers.xml uses lowercase
+		RunAs     string `xml:"runAs,attr"`
+		Account   string `xml:"accountName,attr"`
+		CPassword string `xml:"cpassword,attr"`
+	} `xml:"Properties"`
+}
+
+// Loads Drives.xml and Printers.xml from a copy of the SYSVOL Policies folder, and decorates
+// the GPOs with what they map and whether they or other preferences contain credentials
+func LoadGPPFromSYSVOL(policiespath string) error {
+	gpos := make(map[string]*Object)
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() == ObjectTypeGroupPolicyContainer {
+			gpos[strings.ToLower(o.OneAttr(Name))] = o
+		}
+	}
+
+	var items int
+	err := filepath.Walk(policiespath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		// Policies\{GUID}\User\Preferences\Drives\Drives.xml
+		relative, _ := filepath.Rel(policiespath, path)
+		filename := strings.ToLower(info.Name())
+		if !strings.HasSuffix(filename, ".xml") || !strings.Contains(strings.ToLower(filepath.ToSlash(relative)), "/preferences/") {
+			return nil
+		}
+		mappings := filename == "drives.xml" || filename == "printers.xml"
+
+		guid := strings.ToLower(strings.SplitN(filepath.ToSlash(relative), "/", 2)[0])
+		gpo, found := gpos[guid]
+		if !found {
+			log.Warn().Msgf("Found %v for GPO %v, which is not in the directory", path, guid)
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var gf gppFile
+		if err = xml.Unmarshal(data, &gf); err != nil {
+			log.Warn().Msgf("Problem parsing %v: %v", path, err)
+			return nil
+		}
+
+		for _, item := range gf.Items {
+			if item.Properties.Action == "D" {
+				continue // Deletes the mapping
+			}
+			if mappings && item.Properties.Path != "" {
+				mapping := item.Properties.Path
+				if item.Properties.Letter != "" {
+					mapping += " (" + item.Properties.Letter + ":)"
+				}
+				if filename == "drives.xml" {
+					gpo.AddValues(MetaGPPDriveMaps, mapping)
+				} else {
+					gpo.AddValues(MetaGPPPrinters, mapping)
+				}
+				// The users the GPO applies to open files and install drivers from these, see userSharePaths
+				if _, _, ok := parseUNC(item.Properties.Path); ok && !StringInSlice(item.Properties.Path, gpo.Attr(MetaGPPSharePaths)) {
+					gpo.AddValues(MetaGPPSharePaths, item.Properties.Path)
+				}
+				items++
+			}
+			if item.Properties.CPassword != "" {
+				// The key to decrypt this was published by Microsoft, so this is as good as plaintext (MS14-025)
+				gpo.AddValues(MetaGPPCredentials,
+					Default(item.Properties.UserName, item.Properties.Username, item.Properties.RunAs, item.Properties.Account)+" in "+info.Name()+" for "+Default(item.Properties.Path, item.Name))
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		log.Info().Msgf("Loaded %v drive and printer mappings from Group Policy Preferences", items)
+	}
+	return err
+}
+
+// Returns the containers that link to the GPO
+func gpoLinkedContainers(gpo *Object) []*Object {
+	var results []*Object
+	dn := strings.ToLower(gpo.DN())
+	for _, o := range AllObjects.AsArray() {
+		if strings.Contains(strings.ToLower(o.OneAttr(GPLink)), dn) {
+			results = append(results, o)
+		}
+	}
+	return results
+}
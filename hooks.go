@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Commands run before and after dumping and analyzing, so notifications, archiving and such can be hooked in. They
+// run through the shell with the context in environment variables: ADALANCHE_HOOK (predump, postdump, preanalyze or
+// postanalyze), ADALANCHE_DOMAIN, ADALANCHE_DATAPATH and ADALANCHE_DUMPFILE when dumping. After analysis ADALANCHE_SUMMARY is the
+// name of a JSON file with a summary of the findings, which is removed when the command is done
+var Hooks = make(map[string]string)
+
+// Summary of the analysis for the postanalyze hook
+type HookSummary struct {
+	Domains     string         `json:"domains"`
+	Analyzed    time.Time      `json:"analyzed"`
+	Version     int            `json:"version"`
+	Objects     int            `json:"objects"`
+	Connections int            `json:"connections"`
+	Tier0       int            `json:"tier0"`
+	Problems    int            `json:"problems"`
+	Findings    map[string]int `json:"findings"` // Report name -> number of findings
+}
+
+func hookSummary(domains string) HookSummary {
+	summary := HookSummary{
+		Domains:  domains,
+		Analyzed: time.Now(),
+		Version:  DatasetVersion,
+		Findings: make(map[string]int),
+	}
+	for _, o := range AllObjects.AsArray() {
+		summary.Objects++
+		summary.Connections += len(o.CanPwnSnapshot())
+		if o.IsTier0() {
+			summary.Tier0++
+		}
+	}
+	problemlock.Lock()
+	summary.Problems = len(AllProblems)
+	problemlock.Unlock()
+	for _, report := range Reports {
+		summary.Findings[report.Name] = len(report.Generate())
+	}
+	return summary
+}
+
+// Runs the command for the phase if there is one. A failing predump or preanalyze command stops what was about to
+// happen, so the error is returned for those
+func RunHook(phase, domains, datapath string) error {
+	command := Hooks[phase]
+	if command == "" {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"ADALANCHE_HOOK="+phase,
+		"ADALANCHE_DOMAIN="+domains,
+		"ADALANCHE_DATAPATH="+datapath,
+	)
+	if phase == "predump" || phase == "postdump" {
+		cmd.Env = append(cmd.Env, "ADALANCHE_DUMPFILE="+filepath.Join(datapath, domains+".objects.lz4.msgp"))
+	}
+
+	if phase == "postanalyze" {
+		summaryfile, err := os.CreateTemp("", "adalanche-summary-*.json")
+		if err != nil {
+			return fmt.Errorf("Problem writing the summary for the %v hook: %v", phase, err)
+		}
+		defer os.Remove(summaryfile.Name())
+		datasetLock.RLock()
+		summary := hookSummary(domains)
+		datasetLock.RUnlock()
+		err = json.NewEncoder(summaryfile).Encode(summary)
+		summaryfile.Close()
+		if err != nil {
+			return fmt.Errorf("Problem writing the summary for the %v hook: %v", phase, err)
+		}
+		cmd.Env = append(cmd.Env, "ADALANCHE_SUMMARY="+summaryfile.Name())
+	}
+
+	log.Info().Msgf("Running %v hook: %v", phase, command)
+	start := time.Now()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("The %v hook failed: %v", phase, err)
+	}
+	log.Debug().Msgf("The %v hook finished in %v", phase, time.Since(start))
+	return nil
+}
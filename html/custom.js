@@ -15,13 +15,13 @@ function setquery(query, depth, methods, mode) {
         $('#querytext').val(query);
     }
     if (depth) {
-        $('#maxdepth').val(query);
+        $('#maxdepth').val(depth);
     }
     if (methods) {
         // Clear all
         $('#pwnfilter > div > label .active').button("toggle");
         if (methods == "default") {
-            $('#pwnfilter > div > label > input [default]').button("toggle");
+            $('#pwnfilter > div > label > input[default]').button("toggle");
         } else {
             marr = methods.split(" ")
             for (i in marr) {
@@ -355,6 +355,13 @@ $(function() {
                         "target-arrow-shape": "triangle"
                     }
                 },
+                {
+                    selector: 'edge[confidence]',
+                    style: {
+                        opacity: "mapData(confidence, 0, 1, 0.3, 1)",
+                        "line-style": "dashed"
+                    }
+                },
                 {
                     selector: 'edge[pwn_aclcontainsdeny]',
                     style: {
@@ -427,8 +434,8 @@ $(function() {
                 // A menu item must have either onClickFunction or submenu or both
                 menuItems: [{
                         id: 'target', // ID of menu item
-                        content: 'Set as route target', // Display content of menu item
-                        tooltipText: 'Node is set as target of routing operation', // Tooltip text for menu item
+                        content: t("menu.target"), // Display content of menu item
+                        tooltipText: t("menu.target.tooltip"), // Tooltip text for menu item
                         // image: {src : "remove.svg", width : 12, height : 12, x : 6, y : 4}, // menu icon
                         // Filters the elements to have this menu item on cxttap
                         // If the selector is not truthy no elements will have this menu item on cxttap
@@ -446,8 +453,8 @@ $(function() {
                     },
                     {
                         id: 'source',
-                        content: 'Route to target',
-                        tooltipText: 'Find shortest route to target selected previously',
+                        content: t("menu.route"),
+                        tooltipText: t("menu.route.tooltip"),
                         selector: 'node',
                         onClickFunction: function(event) {
                             findroute(event.target);
@@ -455,8 +462,8 @@ $(function() {
                     },
                     {
                         id: 'whatcanipwn',
-                        content: 'What can this node pwn?',
-                        tooltipText: 'Does inverse search on this node (clears graph)',
+                        content: t("menu.whatcanipwn"),
+                        tooltipText: t("menu.whatcanipwn.tooltip"),
                         selector: 'node',
                         onClickFunction: function(event) {
                             $("#querytext").val("(distinguishedname=" + event.target.attr("distinguishedname") + ")")
@@ -466,8 +473,8 @@ $(function() {
                     },
                     {
                         id: 'whocanpwn',
-                        content: 'Who can pwn this node?',
-                        tooltipText: 'Does normal search for this node (clears graph)',
+                        content: t("menu.whocanpwn"),
+                        tooltipText: t("menu.whocanpwn.tooltip"),
                         selector: 'node',
                         onClickFunction: function(event) {
                             $("#querytext").val("(distinguishedname=" + event.target.attr("distinguishedname") + ")")
@@ -514,13 +521,21 @@ $(function() {
     }
 
     function renderedge(ele) {
-        return rendernode(ele.source()) + rendermethods(ele.data("methods")) + rendernode(ele.target());
+        return rendernode(ele.source()) + rendermethods(ele.data("methods")) + renderreasons(ele.data("reasons")) + rendernode(ele.target());
+    }
+
+    function renderreasons(reasons) {
+        s = ""
+        for (i in reasons) {
+            s += '<div class="small">' + $("<div>").text(reasons[i]).html() + '</div>';
+        }
+        return s
     }
 
     function rendermethods(methods) {
         s = ""
         for (i in methods) {
-            s += '<span class="badge badge-warning">' + methods[i] + '</span>';
+            s += methodbadge(methods[i]);
         }
         return s
     }
@@ -582,7 +597,7 @@ $(function() {
         if (dfs.path) {
             dfs.path.select();
             console.log(dfs.distance);
-            $("#route").html("Path details<br>").show();
+            $("#route").html(t("route.details") + "<br>").show();
             dfs.path.forEach(function(ele) {
                 if (ele.isNode()) {
                     $("#route").append(rendernode(ele));
@@ -591,7 +606,7 @@ $(function() {
                 }
             })
         } else {
-            $("#route").html("No path found").show()
+            $("#route").html(t("route.notfound")).show()
         }
     }
 
@@ -612,7 +627,7 @@ $(function() {
     $("#queryform").submit(function analyze(e) {
         e.preventDefault(); // avoid to execute the actual submit of the form.
 
-        $("#status").html("Loading ...").show()
+        $("#status").html(t("status.loading")).show()
 
         $.ajax({
             type: "GET",
@@ -623,18 +638,72 @@ $(function() {
                 $("#route").hide();
                 $("#details").hide();
                 $("#status").html(
-                    data.targets + " targets can " + (!$("#inverted").is(":checked") ? "be reached via " : "reach ") + data.links + " possible pwns " + (!$("#inverted").is(":checked") ? "from" : "to") + ":<hr/>" +
-                    data.users + " users<br>" +
-                    data.computers + " computers<br>" +
-                    data.groups + " groups<br>" +
-                    data.others + " others<hr/>" +
-                    data.total + " total objects in analysis"
+                    t(!$("#inverted").is(":checked") ? "status.result" : "status.resultinverted", { targets: data.targets, links: data.links }) + "<hr/>" +
+                    t("status.users", { count: data.users }) + "<br>" +
+                    t("status.computers", { count: data.computers }) + "<br>" +
+                    t("status.groups", { count: data.groups }) + "<br>" +
+                    t("status.others", { count: data.others }) + "<hr/>" +
+                    t("status.total", { count: data.total })
                 ).show()
 
                 initgraph(data.elements);
+                filltable(data.elements, function(id) {
+                    var ele = cy.getElementById(id);
+                    cy.elements().unselect();
+                    ele.select();
+                    cy.animate({ center: { eles: ele } });
+                    $("#details").html(ele.isNode() ? rendernode(ele) : renderedge(ele)).show();
+                });
+            },
+            error: function(xhr, status, error) {
+                $("#status").html(t("status.loadproblem") + "<br>" + xhr.responseText).show()
+            }
+        });
+    });
+
+    function renderdiffside(title, side) {
+        s = '<h5>' + title + '</h5>';
+        for (i in side.objects) {
+            s += '<div>' + side.objects[i] + '</div>';
+        }
+        for (i in side.connections) {
+            s += '<div>' + side.connections[i].source + ' ' + rendermethods(side.connections[i].methods) + ' ' + side.connections[i].target + '</div>';
+        }
+        if (!side.objects && !side.connections) {
+            s += '<div>' + t("compare.nodifferences") + '</div>';
+        }
+        return s
+    }
+
+    // Run the current query and another one, and show what only one of them finds
+    $("#comparequery").on("click", function() {
+        comparequery = prompt(t("query.compareprompt"), $("#querytext").val());
+        if (comparequery == null) {
+            return
+        }
+        if ($("#querymode").val() == "") {
+            $("#querymode").val("normal");
+        }
+
+        $("#status").html(t("status.comparing")).show()
+
+        $.ajax({
+            type: "GET",
+            url: "diff",
+            data: $("#queryform, #optionsform").serialize() + "&" + $.param({ "comparequery": comparequery }),
+            dataType: "json",
+            success: function(data) {
+                $("#status").html(
+                    t("compare.result", { objects: (data.onlyinquery.objects || []).length, connections: (data.onlyinquery.connections || []).length }) + "<br>" +
+                    t("compare.resultcompared", { objects: (data.onlyincompare.objects || []).length, connections: (data.onlyincompare.connections || []).length })
+                ).show()
+                $("#details").html(
+                    renderdiffside(t("compare.current"), data.onlyinquery) + '<hr/>' +
+                    renderdiffside(t("compare.compared"), data.onlyincompare)
+                ).show();
             },
             error: function(xhr, status, error) {
-                $("#status").html("Problem loading graph:<br>" + xhr.responseText).show()
+                $("#status").html(t("status.compareproblem") + "<br>" + xhr.responseText).show()
             }
         });
     });
@@ -651,7 +720,7 @@ $(function() {
         changetimer = setTimeout(function() {
             $.ajax({
                 type: "GET",
-                url: "/validatequery",
+                url: "validatequery",
                 data: {
                     "query": $("#querytext").val()
                 },
@@ -677,20 +746,21 @@ $(function() {
 
     $.ajax({
         type: "GET",
-        url: "/pwnmethods",
+        url: "pwnmethods",
         dataType: "json",
         success: function(methods) {
+            setmethodhelp(methods);
             // buttons = '<div class="w-50 col-sm btn-group" data-toggle="buttons">';
             buttons = "";
             for (i in methods) {
                 // buttons += `<button type="checkbox" name="` + methods[i].name + `" class="w-50 btn btn-primary btm-xs` + (methods[i].defaultenabled ? " active" : "") + `" data-toggle="button" aria-pressed="` + (methods[i].defaultenabled ? "true" : "false") + `" autocomplete="off">` + methods[i].name + `</button>`;
                 buttons += `
                 <div class="btn-group-toggle d-inline" data-toggle="buttons">
-                    <label class="btn btn-light btn-xs w-auto` + (methods[i].defaultenabled ? " active" : "") + `">
+                    <label class="btn btn-light btn-xs w-auto` + (methods[i].defaultenabled ? " active" : "") + `" title="` + $("<div>").text(methods[i].description).html().replace(/"/g, "&quot;") + `">
                         <input type="checkbox" ` + (methods[i].defaultenabled ? "default" : "") + ` id="` + methods[i].name + `" name="` + methods[i].name + `"` + (methods[i].defaultenabled ? " checked" : "") + `>` +
                     methods[i].name +
                     `</label>
-                </div>`;
+                </div><a href="#" class="methodhelpbutton text-light small mr-1" role="button" data-method="` + methods[i].name + `" aria-label="` + t("methodhelp.show", { method: methods[i].name }) + `">?</a>`;
                 // class="w-50 btn btn-primary btm-xs` + (methods[i].defaultenabled ? " active" : "") + `" data-toggle="button" aria-pressed="` + (methods[i].defaultenabled ? "true" : "false") + `" autocomplete="off">` + methods[i].name + `</button>`;
             }
             // buttons += '</div>';
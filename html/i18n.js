@@ -0,0 +1,75 @@
+// Translations of the UI text. The strings come from locale/<language> (locales/<language>.json, or the same file
+// in -localepath), which has the English ones for what isn't translated. Elements with data-i18n get their text
+// from it, and data-i18n-<attribute> sets that attribute. Code uses t("key", {name: value}) for {name} placeholders
+
+var i18nstrings = {};
+
+function t(key, args) {
+    var s = i18nstrings[key];
+    if (s === undefined) {
+        s = key;
+    }
+    for (name in args) {
+        s = s.split("{" + name + "}").join(args[name]);
+    }
+    return s;
+}
+
+function applylocale(root) {
+    $(root).find("[data-i18n]").addBack("[data-i18n]").each(function() {
+        $(this).text(t($(this).attr("data-i18n")));
+    });
+    $(root).find("*").addBack().each(function() {
+        for (var i = 0; i < this.attributes.length; i++) {
+            var attribute = this.attributes[i];
+            if (attribute.name.startsWith("data-i18n-")) {
+                this.setAttribute(attribute.name.substring("data-i18n-".length), t(attribute.value));
+            }
+        }
+    });
+}
+
+// Chosen in the UI, or what the browser prefers
+function preferredlanguage() {
+    return localStorage.getItem("language") || navigator.language || "en";
+}
+
+function loadlocale(language, done) {
+    $.ajax({
+        type: "GET",
+        url: "locale/" + encodeURIComponent(language),
+        dataType: "json",
+        success: function(strings) {
+            i18nstrings = strings;
+        },
+        complete: done
+    });
+}
+
+// Page setup in custom.js waits for the strings
+$.holdReady(true);
+loadlocale(preferredlanguage(), function() {
+    $.holdReady(false);
+});
+
+$(function() {
+    applylocale(document);
+
+    $.ajax({
+        type: "GET",
+        url: "locales",
+        dataType: "json",
+        success: function(locales) {
+            var current = i18nstrings["_code"];
+            for (i in locales) {
+                $("#language").append($("<option>").val(locales[i].code).text(locales[i].name).prop("selected", locales[i].code == current));
+            }
+        }
+    });
+
+    // Everything is built with the strings, so the page is loaded again
+    $("#language").on("change", function() {
+        localStorage.setItem("language", $(this).val());
+        location.reload();
+    });
+});
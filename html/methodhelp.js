@@ -0,0 +1,69 @@
+// Help for the methods, from pwnmethods. Methods on edges and in the filter have the description as a tooltip, and
+// clicking them (or the ? next to a filter) shows what it is, how it is abused, how to fix it and where to read more
+
+var methodhelp = {};
+
+function setmethodhelp(methods) {
+    methods.forEach(function(method) {
+        methodhelp[method.name] = method;
+    });
+}
+
+function methoddescription(name) {
+    return methodhelp[name] ? methodhelp[name].description : "";
+}
+
+// A badge for the method, that shows the help when clicked
+function methodbadge(name) {
+    return $("<span class='badge badge-warning methodbadge' role='button' tabindex='0'>").attr("data-method", name).attr("title", methoddescription(name)).text(name).prop("outerHTML");
+}
+
+function showmethodhelp(name) {
+    var help = methodhelp[name];
+    if (!help) {
+        return;
+    }
+    var box = $("#methodhelp").empty();
+    box.append($("<button type='button' class='close text-white' data-i18n-aria-label='methodhelp.close'>").html("&times;").attr("aria-label", t("methodhelp.close")).on("click", closemethodhelp));
+    box.append($("<h5 id='methodhelptitle'>").text(name));
+    box.append($("<p>").text(help.description || t("methodhelp.nodescription")));
+    if (help.abuse) {
+        box.append($("<h6>").text(t("methodhelp.abuse")), $("<p class='small'>").text(help.abuse));
+    }
+    if (help.remediation) {
+        box.append($("<h6>").text(t("methodhelp.remediation")), $("<p class='small'>").text(help.remediation));
+    }
+    if (help.references && help.references.length > 0) {
+        var list = $("<ul class='small pl-3 mb-0'>");
+        help.references.forEach(function(reference) {
+            list.append($("<li>").append($("<a target='_blank' rel='noopener noreferrer'>").attr("href", reference).text(reference)));
+        });
+        box.append($("<h6>").text(t("methodhelp.references")), list);
+    }
+    box.show();
+}
+
+function closemethodhelp() {
+    $("#methodhelp").hide();
+}
+
+$(function() {
+    $(document).on("click", ".methodbadge, .methodhelpbutton", function(event) {
+        showmethodhelp($(this).attr("data-method"));
+        event.preventDefault();
+        event.stopPropagation();
+    });
+
+    $(document).on("keydown", ".methodbadge", function(event) {
+        if (event.key == "Enter" || event.key == " ") {
+            showmethodhelp($(this).attr("data-method"));
+            event.preventDefault();
+        }
+    });
+
+    $("#methodhelp").on("keydown", function(event) {
+        if (event.key == "Escape") {
+            closemethodhelp();
+        }
+    });
+});
@@ -0,0 +1,299 @@
+// Command palette, opened with Ctrl+K (Cmd+K on Mac). Typing filters the commands - saved and sample queries, turning
+// methods on and off, switching data and the table - and searches the objects on the server to jump to them. Arrow
+// keys move between the results, Enter runs one and Escape closes
+
+var palettecommands = [];
+var paletteobjects = [];
+var paletteactive = 0;
+var palettereturnfocus;
+var palettesearchtimer;
+
+// Limits what is shown, the object search is limited on the server too
+var palettemax = 50;
+
+function runsaved(query) {
+    setquery(query.query, query.depth, query.methods, query.mode);
+    $("#queryform").submit();
+}
+
+function currentmethods() {
+    return $("#pwnfilter input:checked").map(function() { return this.id; }).get().join(" ");
+}
+
+function switchdataset(domains) {
+    $("#status").html(t("palette.switching", { domains: domains })).show();
+    $.ajax({
+        type: "POST",
+        url: "dataset",
+        data: { domains: domains },
+        success: function() {
+            $("#queryform").submit();
+        },
+        error: function(xhr) {
+            $("#status").html(t("palette.switchproblem") + "<br>" + $("<div>").text(xhr.responseText).html()).show();
+        }
+    });
+}
+
+function jumpto(object) {
+    var node = window.cy ? cy.nodes().filter(function(node) { return node.data("distinguishedname") == object.distinguishedname; }) : [];
+    if (node.length > 0 && tableselect) {
+        tableselect(node.id());
+        return;
+    }
+    // Not in the graph, so show who can pwn it
+    setquery("(distinguishedname=" + object.distinguishedname + ")", null, null, "normal");
+    $("#queryform").submit();
+}
+
+// Everything but the objects, built when the palette opens as methods, saved queries and data change
+function buildcommands(done) {
+    var commands = [];
+    $("#predefinedqueries a[query]").each(function() {
+        var a = this;
+        commands.push({
+            label: t("palette.sample", { name: $(a).text() }),
+            run: function() {
+                setquery(a.getAttribute("query"), a.getAttribute("depth"), a.getAttribute("methods"), a.getAttribute("mode"));
+                $("#queryform").submit();
+            }
+        });
+    });
+    commands.push({
+        label: t("palette.save"),
+        run: function() {
+            var name = prompt(t("palette.saveprompt"));
+            if (!name) {
+                return;
+            }
+            $.ajax({
+                type: "POST",
+                url: "queries",
+                contentType: "application/json",
+                data: JSON.stringify({ name: name, query: $("#querytext").val(), mode: $("#querymode").val(), depth: parseInt($("#maxdepth").val()), methods: currentmethods() }),
+                success: function() {
+                    $("#status").html(t("palette.saved", { name: $("<div>").text(name).html() })).show();
+                },
+                error: function(xhr) {
+                    $("#status").html(t("palette.saveproblem") + "<br>" + $("<div>").text(xhr.responseText).html()).show();
+                }
+            });
+        }
+    });
+    commands.push({
+        label: t("palette.table"),
+        run: function() {
+            showtable(true);
+        }
+    });
+    $("#pwnfilter input").each(function() {
+        var input = this;
+        commands.push({
+            label: t(input.checked ? "palette.methodoff" : "palette.methodon", { method: input.id }),
+            run: function() {
+                $(input).parent().button("toggle");
+                $("#queryform").submit();
+            }
+        });
+    });
+
+    var queries = $.getJSON("queries");
+    var datasets = $.getJSON("datasets");
+    $.when(queries, datasets).always(function() {
+        var saved = queries.responseJSON || [];
+        saved.forEach(function(query) {
+            commands.unshift({
+                label: t("palette.run", { name: query.name }),
+                detail: query.query,
+                run: function() { runsaved(query); }
+            });
+            commands.push({
+                label: t("palette.delete", { name: query.name }),
+                run: function() {
+                    $.ajax({
+                        type: "DELETE",
+                        url: "queries/" + encodeURIComponent(query.name),
+                        success: function() {
+                            $("#status").html(t("palette.deleted", { name: $("<div>").text(query.name).html() })).show();
+                        }
+                    });
+                }
+            });
+        });
+        var data = datasets.responseJSON;
+        if (data) {
+            var loaded = (data.loaded || []).join(",");
+            (data.available || []).forEach(function(domain) {
+                if (domain != loaded) {
+                    commands.push({
+                        label: t("palette.dataset", { domains: domain }),
+                        run: function() { switchdataset(domain); }
+                    });
+                }
+            });
+            var all = (data.available || []).join(",");
+            if (data.available && data.available.length > 1 && all != loaded) {
+                commands.push({
+                    label: t("palette.dataset", { domains: all }),
+                    run: function() { switchdataset(all); }
+                });
+            }
+        }
+        palettecommands = commands;
+        done();
+    });
+}
+
+function paletteresults() {
+    var words = $("#paletteinput").val().toLowerCase().split(" ").filter(function(word) { return word != ""; });
+    var results = palettecommands.filter(function(command) {
+        var text = command.label.toLowerCase();
+        return words.every(function(word) { return text.indexOf(word) != -1; });
+    });
+    return results.concat(paletteobjects).slice(0, palettemax);
+}
+
+function renderpalette() {
+    var results = paletteresults();
+    if (paletteactive >= results.length) {
+        paletteactive = Math.max(results.length - 1, 0);
+    }
+    var list = $("#paletteitems").empty();
+    results.forEach(function(result, i) {
+        var item = $("<li role='option' class='px-2 py-1'>").attr("id", "paletteitem-" + i).attr("aria-selected", i == paletteactive ? "true" : "false").text(result.label);
+        if (result.detail) {
+            item.append($("<div class='small'>").text(result.detail));
+        }
+        item.toggleClass("bg-light text-dark", i == paletteactive);
+        item.on("mousedown", function(event) {
+            // Keeps focus in the input
+            event.preventDefault();
+        });
+        item.on("click", function() {
+            runpalette(result);
+        });
+        list.append(item);
+    });
+    if (results.length == 0) {
+        list.append($("<li class='px-2 py-1'>").text(t("palette.nothing")));
+    }
+    $("#paletteinput").attr("aria-activedescendant", results.length > 0 ? "paletteitem-" + paletteactive : null);
+    $("#palettecount").text(t("palette.count", { count: results.length }));
+    var active = document.getElementById("paletteitem-" + paletteactive);
+    if (active) {
+        active.scrollIntoView({ block: "nearest" });
+    }
+}
+
+function searchobjects() {
+    var text = $("#paletteinput").val();
+    if (text.length < 2) {
+        paletteobjects = [];
+        renderpalette();
+        return;
+    }
+    $.getJSON("search", { q: text }, function(objects) {
+        if ($("#paletteinput").val() != text) {
+            return;
+        }
+        paletteobjects = (objects || []).map(function(object) {
+            return {
+                label: t("palette.object", { name: object.name, type: object.type }),
+                detail: object.distinguishedname,
+                run: function() { jumpto(object); }
+            };
+        });
+        renderpalette();
+    });
+}
+
+function runpalette(result) {
+    closepalette();
+    result.run();
+}
+
+// Closed from the keyboard, so focus goes back to where it was
+function closepalette() {
+    showpalette(false);
+    if (palettereturnfocus) {
+        palettereturnfocus.focus();
+    }
+}
+
+function showpalette(show) {
+    if (show) {
+        palettereturnfocus = document.activeElement;
+        paletteobjects = [];
+        paletteactive = 0;
+        $("#paletteinput").val("");
+        $("#palette").show();
+        $("#paletteinput").attr("aria-expanded", "true").focus();
+        buildcommands(renderpalette);
+    } else {
+        $("#palette").hide();
+        $("#paletteinput").attr("aria-expanded", "false");
+    }
+}
+
+$(function() {
+    $(document).on("keydown", function(event) {
+        if ((event.ctrlKey || event.metaKey) && event.key.toLowerCase() == "k") {
+            if ($("#palette").is(":visible")) {
+                closepalette();
+            } else {
+                showpalette(true);
+            }
+            event.preventDefault();
+        }
+    });
+
+    $("#paletteinput").on("input", function() {
+        paletteactive = 0;
+        renderpalette();
+        clearTimeout(palettesearchtimer);
+        palettesearchtimer = setTimeout(searchobjects, 200);
+    });
+
+    $("#paletteinput").on("keydown", function(event) {
+        var results = paletteresults();
+        switch (event.key) {
+            case "ArrowDown":
+                paletteactive = Math.min(paletteactive + 1, results.length - 1);
+                break;
+            case "ArrowUp":
+                paletteactive = Math.max(paletteactive - 1, 0);
+                break;
+            case "PageDown":
+                paletteactive = Math.min(paletteactive + 10, results.length - 1);
+                break;
+            case "PageUp":
+                paletteactive = Math.max(paletteactive - 10, 0);
+                break;
+            case "Enter":
+                if (results[paletteactive]) {
+                    runpalette(results[paletteactive]);
+                }
+                event.preventDefault();
+                return;
+            case "Escape":
+                closepalette();
+                event.preventDefault();
+                return;
+            default:
+                return;
+        }
+        event.preventDefault();
+        renderpalette();
+    });
+
+    // Clicking somewhere else closes it, and focus stays there
+    $("#paletteinput").on("blur", function() {
+        if ($("#palette").is(":visible")) {
+            showpalette(false);
+        }
+    });
+
+    $("#palettetoggle").on("click", function() {
+        showpalette(true);
+    });
+});
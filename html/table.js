@@ -0,0 +1,181 @@
+// The analysis result as tables of objects and connections, for screen readers and keyboard users, and for sorting
+// and filtering what is hard to see in the graph. Headers sort, the filter matches any cell, arrow keys move between
+// rows and Enter shows the row in the graph
+
+var tablecolumns = {
+    objects: [
+        { key: "table.name", value: function(node) { return node.name || node.label; } },
+        { key: "table.account", value: function(node) { return node.samaccountname; } },
+        { key: "table.type", value: function(node) { return node._type; } },
+        { key: "table.target", value: function(node) { return node._querytarget ? t("table.yes") : ""; } },
+        { key: "table.dn", value: function(node) { return node.distinguishedname; } }
+    ],
+    connections: [
+        { key: "table.from", value: function(edge, nodes) { return nodename(nodes[edge.source]); } },
+        { key: "table.methods", value: function(edge) { return (edge.methods || []).join(", "); } },
+        { key: "table.to", value: function(edge, nodes) { return nodename(nodes[edge.target]); } },
+        { key: "table.confidence", numeric: true, value: function(edge) { return edge.confidence ? Math.round(edge.confidence * 100) : 100; } },
+        { key: "table.reasons", value: function(edge) { return (edge.reasons || []).join(" "); } }
+    ]
+};
+
+// Rows of each table as [id, cell values]
+var tablerows = { objects: [], connections: [] };
+var tablesort = { objects: { column: 0, ascending: true }, connections: { column: 0, ascending: true } };
+var tableselect;
+
+function nodename(node) {
+    if (!node) {
+        return "";
+    }
+    return node.name || node.label || node.distinguishedname;
+}
+
+// Called with every new result. select(id) shows the node or edge in the graph
+function filltable(elements, select) {
+    tableselect = select;
+    var nodes = {};
+    for (i in elements.nodes) {
+        nodes[elements.nodes[i].data.id] = elements.nodes[i].data;
+    }
+    tablerows.objects = [];
+    for (i in elements.nodes) {
+        var node = elements.nodes[i].data;
+        tablerows.objects.push([node.id, tablecolumns.objects.map(function(column) { return column.value(node, nodes); })]);
+    }
+    tablerows.connections = [];
+    for (i in elements.edges) {
+        var edge = elements.edges[i].data;
+        tablerows.connections.push([edge.id, tablecolumns.connections.map(function(column) { return column.value(edge, nodes); })]);
+    }
+    rendertable("objects");
+    rendertable("connections");
+}
+
+function rendertable(name) {
+    var columns = tablecolumns[name];
+    var sort = tablesort[name];
+    var filter = $("#" + name + "filter").val().toLowerCase();
+
+    var head = $("<tr>");
+    columns.forEach(function(column, i) {
+        var th = $("<th scope='col'>").attr("aria-sort", i == sort.column ? (sort.ascending ? "ascending" : "descending") : "none");
+        var button = $("<button type='button' class='btn btn-link btn-sm p-0 text-white'>").text(t(column.key) + (i == sort.column ? (sort.ascending ? " ▲" : " ▼") : ""));
+        button.on("click", function() {
+            tablesort[name] = { column: i, ascending: i == sort.column ? !sort.ascending : true };
+            rendertable(name);
+            $("#" + name + "table thead button").eq(i).focus();
+        });
+        head.append(th.append(button));
+    });
+
+    var rows = tablerows[name].filter(function(row) {
+        return filter == "" || row[1].some(function(value) { return String(value || "").toLowerCase().indexOf(filter) != -1; });
+    });
+    rows.sort(function(a, b) {
+        var x = a[1][sort.column];
+        var y = b[1][sort.column];
+        var result = columns[sort.column].numeric ? x - y : String(x || "").localeCompare(String(y || ""));
+        return sort.ascending ? result : -result;
+    });
+
+    var body = $("<tbody>");
+    rows.forEach(function(row) {
+        var tr = $("<tr tabindex='-1'>").attr("data-id", row[0]);
+        row[1].forEach(function(value, i) {
+            tr.append((i == 0 ? $("<th scope='row'>") : $("<td>")).text(value === undefined ? "" : value));
+        });
+        body.append(tr);
+    });
+    // One row can be tabbed to, the arrow keys move from there
+    body.children().first().attr("tabindex", "0");
+
+    $("#" + name + "table").empty().append($("<thead>").append(head)).append(body);
+    $("#" + name + "count").text(t("table.count", { shown: rows.length, total: tablerows[name].length }));
+}
+
+function showtable(show) {
+    $("#tableview").toggle(show);
+    $("#tabletoggle").attr("aria-pressed", show ? "true" : "false");
+    if (show) {
+        $("#objectsfilter").focus();
+    } else {
+        $("#tabletoggle").focus();
+    }
+}
+
+$(function() {
+    $("#tabletoggle").on("click", function() {
+        showtable(!$("#tableview").is(":visible"));
+    });
+    $("#tableclose").on("click", function() {
+        showtable(false);
+    });
+
+    $("#objectsfilter, #connectionsfilter").on("input", function() {
+        rendertable($(this).attr("id").replace("filter", ""));
+    });
+
+    $("#tableview").on("keydown", function(event) {
+        if (event.key == "Escape") {
+            showtable(false);
+            event.preventDefault();
+        }
+    });
+
+    $("#tableview").on("keydown", "tbody tr", function(event) {
+        var row = $(this);
+        var next;
+        switch (event.key) {
+            case "ArrowDown":
+                next = row.next();
+                break;
+            case "ArrowUp":
+                next = row.prev();
+                break;
+            case "PageDown":
+                next = row.nextAll().eq(9);
+                if (next.length == 0) {
+                    next = row.siblings().last();
+                }
+                break;
+            case "PageUp":
+                next = row.prevAll().eq(9);
+                if (next.length == 0) {
+                    next = row.siblings().first();
+                }
+                break;
+            case "Home":
+                next = row.siblings().addBack().first();
+                break;
+            case "End":
+                next = row.siblings().addBack().last();
+                break;
+            case "Enter":
+                showtable(false);
+                if (tableselect) {
+                    tableselect(row.attr("data-id"));
+                }
+                event.preventDefault();
+                return;
+            default:
+                return;
+        }
+        event.preventDefault();
+        if (next.length > 0) {
+            row.attr("tabindex", "-1");
+            next.attr("tabindex", "0").focus();
+        }
+    });
+
+    $("#tableview").on("click", "tbody tr", function() {
+        $(this).siblings("[tabindex='0']").attr("tabindex", "-1");
+        $(this).attr("tabindex", "0").focus();
+    });
+    $("#tableview").on("dblclick", "tbody tr", function() {
+        showtable(false);
+        if (tableselect) {
+            tableselect($(this).attr("data-id"));
+        }
+    });
+});
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strings"
+)
+
+// Hybrid identity, where Entra ID objects are synced from AD by Entra Connect (Azure AD Connect). Synced users are
+// found by the on-prem SID, or by the immutable ID which is the base64 of mS-DS-ConsistencyGuid or objectGUID
+
+// Entra ID object -> the AD objects it's synced from
+var entraSyncSources = make(map[*Object][]*Object)
+
+// AD computer -> the Entra ID device it's hybrid joined as
+var entraHybridDevices = make(map[*Object]*Object)
+
+// Entra ID user -> devices it's the registered owner of
+var entraOwnedDevices = make(map[*Object][]*Object)
+
+// Sync account in AD or Entra ID -> the Entra Connect server with its credentials
+var entraConnectHosts = make(map[*Object]*Object)
+
+// Entra ID user -> roles that can reset its password or authentication methods
+var entraRoleAdmins = make(map[*Object][]*Object)
+
+// Immutable ID -> AD user, built on first use as it needs the AD objects loaded
+var entraImmutableIDs map[string]*Object
+
+// The MSOL_ account description has the name of the Entra Connect server
+var entraConnectDescription = regexp.MustCompile(`(?i)running on computer (\S+)`)
+
+// Roles that can reset passwords and authentication methods of all users, and those that can only for users
+// without an admin role
+var entraResetAllRoles = []string{
+	"62e90394-69f5-4237-9190-012177145e10", // Global Administrator
+	"7be44c8a-adaf-4e2a-84d6-ab2649e08a13", // Privileged Authentication Administrator
+}
+
+var entraResetUserRoles = []string{
+	"fe930be7-5e62-47db-91af-98c3a49a38b1", // User Administrator
+	"729827e3-9c14-49f7-bb1b-9608f156bbb8", // Helpdesk Administrator
+	"c4e39bd9-1100-46d3-8c65-fb160da0071f", // Authentication Administrator
+	"966707d0-3269-4727-9be2-8c3a10f19b9d", // Password Administrator
+}
+
+func buildEntraImmutableIDs() {
+	entraImmutableIDs = make(map[string]*Object)
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != ObjectTypeUser || o.OneAttr(MetaEntra) != "" {
+			continue
+		}
+		for _, attribute := range []Attribute{MSDSConsistencyGuid, ObjectGUID} {
+			if guid := o.OneAttr(attribute); len(guid) == 16 {
+				entraImmutableIDs[base64.StdEncoding.EncodeToString([]byte(guid))] = o
+			}
+		}
+	}
+}
+
+// Finds the AD object an Entra ID object is synced from
+func entraOnPremises(sidstring, immutableid string) (*Object, bool) {
+	if sid, err := SIDFromString(sidstring); err == nil {
+		if o, found := AllObjects.FindSID(sid); found && o.OneAttr(MetaEntra) == "" {
+			return o, true
+		}
+	}
+	if immutableid == "" {
+		return nil, false
+	}
+	if entraImmutableIDs == nil {
+		buildEntraImmutableIDs()
+	}
+	o, found := entraImmutableIDs[immutableid]
+	return o, found
+}
+
+// Finds the Entra Connect servers from the sync accounts, device owners, and which roles can reset which users
+func (t *EntraTenant) linkHybrid(memberof map[string][]string) {
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != ObjectTypeUser || !strings.HasPrefix(strings.ToUpper(o.OneAttr(SAMAccountName)), "MSOL_") {
+			continue
+		}
+		if match := entraConnectDescription.FindStringSubmatch(o.OneAttr(Description)); match != nil {
+			if computer, found := AllObjects.FindComputer(match[1]); found {
+				entraConnectHosts[o] = computer
+			}
+		}
+	}
+
+	// Sync_<server>_<installation>@<tenant>.onmicrosoft.com
+	for _, user := range t.Users {
+		parts := strings.Split(strings.SplitN(user.UserPrincipalName, "@", 2)[0], "_")
+		if len(parts) < 3 || !strings.EqualFold(parts[0], "Sync") {
+			continue
+		}
+		computer, found := AllObjects.FindComputer(strings.Join(parts[1:len(parts)-1], "_"))
+		if !found {
+			continue
+		}
+		if o, found := AllObjects.Find(t.dn("Users", user.ID)); found {
+			entraConnectHosts[o] = computer
+		}
+	}
+
+	for _, device := range t.Devices {
+		o, found := AllObjects.Find(t.dn("Devices", device.ID))
+		if !found {
+			continue
+		}
+		for _, owner := range device.RegisteredOwners {
+			if user, found := AllObjects.Find(t.dn("Users", owner)); found {
+				entraOwnedDevices[user] = append(entraOwnedDevices[user], o)
+			}
+		}
+	}
+
+	var resetall, resetusers []*Object
+	for _, role := range t.DirectoryRoles {
+		o, found := AllObjects.Find(t.dn("Roles", role.ID))
+		if !found {
+			continue
+		}
+		if StringInSlice(role.RoleTemplateID, entraResetAllRoles) {
+			resetall = append(resetall, o)
+		} else if StringInSlice(role.RoleTemplateID, entraResetUserRoles) {
+			resetusers = append(resetusers, o)
+		}
+	}
+	for _, user := range t.Users {
+		o, found := AllObjects.Find(t.dn("Users", user.ID))
+		if !found {
+			continue
+		}
+		entraRoleAdmins[o] = append(entraRoleAdmins[o], resetall...)
+		if len(t.userRoles(user.ID, memberof)) == 0 {
+			entraRoleAdmins[o] = append(entraRoleAdmins[o], resetusers...)
+		}
+	}
+}
+
+// Returns the AD objects an Entra ID object is synced from, and for hybrid joined devices the AD computer
+func entraSyncedFrom(o *Object) []*Object {
+	var results []*Object
+	for _, source := range entraSyncSources[o] {
+		SetEdgeReason(source, o, PwnEntraSync, "synced to Entra ID by Entra Connect, so control on-prem carries over to the cloud")
+		results = append(results, source)
+	}
+	return results
+}
+
+// Returns the Entra Connect server holding the credentials of the sync account
+func entraConnectServers(o *Object) []*Object {
+	computer, found := entraConnectHosts[o]
+	if !found {
+		return nil
+	}
+	if o.OneAttr(MetaEntra) != "" {
+		SetEdgeReason(computer, o, PwnEntraConnect, "Entra Connect server stores the credentials of the Entra ID sync account")
+	} else {
+		SetEdgeReason(computer, o, PwnEntraConnect, "Entra Connect server stores the password of the AD sync account, which can replicate password hashes")
+	}
+	return []*Object{computer}
+}
+
+// Returns the Entra ID devices where the primary refresh token of the user can be stolen: hybrid joined computers
+// with a session from the synced AD account, and devices the user registered
+func entraPRTStealers(o *Object) []*Object {
+	var results []*Object
+	for _, onprem := range entraSyncSources[o] {
+		for _, host := range sessionHosts[onprem] {
+			device, found := entraHybridDevices[host.Computer]
+			if !found {
+				continue
+			}
+			AddEdgeReason(device, o, PwnEntraPRT, "hybrid joined and "+onprem.OneAttr(Name)+" is logged on to "+host.Computer.OneAttr(Name)+", so the primary refresh token is there")
+			SetEdgeCollected(device, o, PwnEntraPRT, host.Seen)
+			results = append(results, device)
+		}
+	}
+	for _, device := range entraOwnedDevices[o] {
+		SetEdgeReason(device, o, PwnEntraPRT, "registered owner of the device, which likely has the primary refresh token")
+		results = append(results, device)
+	}
+	return results
+}
+
+// Returns the directory roles that can reset the password or authentication methods of the Entra ID user
+func entraResetAdmins(o *Object) []*Object {
+	for _, role := range entraRoleAdmins[o] {
+		SetEdgeReason(role, o, PwnEntraRoleAdmin, role.OneAttr(Name)+" can reset the password and authentication methods")
+	}
+	return entraRoleAdmins[o]
+}
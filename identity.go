@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+)
+
+// Keys shared by more accounts than this are probably functional (shared mailbox, HR default value) and are ignored
+const maxSamePersonAccounts = 5
+
+var samePersonIndex map[string][]*Object
+
+// Returns the values that identify the person behind an account, prefixed with what they are
+func identityKeys(o *Object) []string {
+	var keys []string
+	for prefix, attribute := range map[string]Attribute{
+		"upn:":        UserPrincipalName,
+		"mail:":       Mail,
+		"employeeid:": EmployeeID,
+	} {
+		if value := strings.ToLower(strings.TrimSpace(o.OneAttr(attribute))); value != "" {
+			keys = append(keys, prefix+value)
+		}
+	}
+	return keys
+}
+
+func buildSamePersonIndex() {
+	samePersonIndex = make(map[string][]*Object)
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != ObjectTypeUser {
+			continue
+		}
+		for _, key := range identityKeys(o) {
+			samePersonIndex[key] = append(samePersonIndex[key], o)
+		}
+	}
+}
+
+// Returns accounts in other domains that seem to belong to the same person as o. This is a heuristic, so
+// an account in the same domain with the same mail address is not considered the same person
+func samePersonAccounts(o *Object) []*Object {
+	domainsid := o.SID().StripRID()
+	if domainsid.IsNull() {
+		return nil
+	}
+
+	var results []*Object
+	for _, key := range identityKeys(o) {
+		candidates := samePersonIndex[key]
+		if len(candidates) > maxSamePersonAccounts {
+			continue
+		}
+		for _, candidate := range candidates {
+			if candidate.SID().IsNull() || candidate.SID().StripRID() == domainsid {
+				continue
+			}
+			var duplicate bool
+			for _, result := range results {
+				if result == candidate {
+					duplicate = true
+				}
+			}
+			if !duplicate {
+				results = append(results, candidate)
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// IdPDirectory is users, groups, apps and admin roles from an identity provider like Okta or any SCIM service,
+// as written by the collect-idp command to <datapath>/<name>.idp.json
+type IdPDirectory struct {
+	Name   string     `json:"name"`
+	Type   string     `json:"type"` // okta or scim
+	URL    string     `json:"url"`
+	Users  []IdPUser  `json:"users"`
+	Groups []IdPGroup `json:"groups"`
+	Apps   []IdPApp   `json:"apps"`
+}
+
+type IdPUser struct {
+	ID          string   `json:"id"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName"`
+	Email       string   `json:"email"`
+	EmployeeID  string   `json:"employeeId"`
+	ExternalID  string   `json:"externalId"`
+	Active      bool     `json:"active"`
+	Source      string   `json:"source"` // Where the account is mastered, ACTIVE_DIRECTORY for users from an Okta AD agent
+	Roles       []string `json:"roles"`
+}
+
+type IdPGroup struct {
+	ID          string   `json:"id"`
+	DisplayName string   `json:"displayName"`
+	Members     []string `json:"members"` // User IDs
+	Roles       []string `json:"roles"`
+}
+
+type IdPApp struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Label      string   `json:"label"`
+	SignOnMode string   `json:"signOnMode"`
+	Users      []string `json:"users"`
+	Groups     []string `json:"groups"`
+}
+
+// Okta admin roles that can reset passwords or change how users authenticate, which for users mastered in AD
+// is pushed back to AD through the agent. SCIM has no standard roles, so there any role with admin in the
+// name is considered privileged
+var idpPrivilegedRoles = []string{
+	"SUPER_ADMIN",
+	"ORG_ADMIN",
+	"APP_ADMIN",
+	"USER_ADMIN",
+	"HELP_DESK_ADMIN",
+}
+
+func idpPrivilegedRole(idptype, role string) bool {
+	if idptype == "scim" {
+		return strings.Contains(strings.ToLower(role), "admin")
+	}
+	return StringInSlice(strings.ToUpper(role), idpPrivilegedRoles)
+}
+
+func LoadIdPDirectories(datapath string) error {
+	files, err := filepath.Glob(filepath.Join(datapath, "*.idp.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var directory IdPDirectory
+		if err = qjson.Unmarshal(data, &directory); err != nil {
+			log.Warn().Msgf("Problem loading identity provider data from %v: %v", file, err)
+			continue
+		}
+		if directory.Name == "" {
+			directory.Name = strings.TrimSuffix(filepath.Base(file), ".idp.json")
+		}
+		linked := directory.addObjects()
+		log.Info().Msgf("Loaded %v users, %v groups and %v apps from %v identity provider %v, %v users linked to AD accounts",
+			len(directory.Users), len(directory.Groups), len(directory.Apps), directory.Type, directory.Name, linked)
+	}
+	return nil
+}
+
+func (d *IdPDirectory) dn(kind, id string) string {
+	return "CN=" + id + ",CN=" + kind + ",CN=" + d.Name + ",CN=Identity Providers"
+}
+
+// Adds users, groups and apps as objects. Users mastered in AD are linked to the AD account with the same
+// user principal name (or mail), so admins in the identity provider connect to them
+func (d *IdPDirectory) addObjects() int {
+	memberof := make(map[string][]string)
+	for _, group := range d.Groups {
+		for _, member := range group.Members {
+			memberof[member] = append(memberof[member], d.dn("Groups", group.ID))
+		}
+	}
+	apps := make(map[string][]string)
+	for _, app := range d.Apps {
+		for _, user := range app.Users {
+			apps[user] = append(apps[user], app.Label)
+		}
+		for _, group := range app.Groups {
+			for _, g := range d.Groups {
+				if g.ID == group {
+					for _, member := range g.Members {
+						apps[member] = append(apps[member], app.Label)
+					}
+				}
+			}
+		}
+	}
+
+	for _, group := range d.Groups {
+		o := &Object{
+			DistinguishedName: d.dn("Groups", group.ID),
+			Attributes: map[Attribute][]string{
+				Name:           {group.DisplayName},
+				DisplayName:    {group.DisplayName},
+				ObjectClass:    {"top", "idpGroup"},
+				ObjectCategory: {"CN=Group,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MetaIdP:        {d.Name},
+			},
+		}
+		for _, role := range group.Roles {
+			o.AddValues(MetaIdPRoles, role)
+			if idpPrivilegedRole(d.Type, role) {
+				o.SetAttr(MetaIdPPrivileged, "1")
+			}
+		}
+		AllObjects.Add(o)
+	}
+
+	for _, app := range d.Apps {
+		AllObjects.Add(&Object{
+			DistinguishedName: d.dn("Apps", app.ID),
+			Attributes: map[Attribute][]string{
+				Name:        {app.Label},
+				DisplayName: {app.Label},
+				Description: {app.Name + " (" + app.SignOnMode + ")"},
+				ObjectClass: {"top", "idpApp"},
+				MetaIdP:     {d.Name},
+			},
+		})
+	}
+
+	// AD users by UPN and mail
+	adusers := make(map[string]*Object)
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != ObjectTypeUser || o.SID().IsNull() {
+			continue
+		}
+		for _, attribute := range []Attribute{Mail, UserPrincipalName} {
+			if value := strings.ToLower(o.OneAttr(attribute)); value != "" {
+				adusers[value] = o
+			}
+		}
+	}
+
+	var linked int
+	for _, user := range d.Users {
+		o := &Object{
+			DistinguishedName: d.dn("Users", user.ID),
+			Attributes: map[Attribute][]string{
+				Name:              {user.UserName},
+				DisplayName:       {user.DisplayName},
+				UserPrincipalName: {user.UserName},
+				ObjectClass:       {"top", "idpUser"},
+				ObjectCategory:    {"CN=Person,CN=Schema,CN=Configuration," + AllObjects.Base},
+				MemberOf:          memberof[user.ID],
+				MetaIdP:           {d.Name},
+			},
+		}
+		if user.Email != "" {
+			o.SetAttr(Mail, user.Email)
+		}
+		if user.EmployeeID != "" {
+			o.SetAttr(EmployeeID, user.EmployeeID)
+		}
+		if !user.Active {
+			o.SetAttr(MetaAccountDisabled, "1")
+		}
+		if len(apps[user.ID]) > 0 {
+			o.SetValues(MetaIdPApps, apps[user.ID]...)
+		}
+		for _, role := range user.Roles {
+			o.AddValues(MetaIdPRoles, role)
+			if idpPrivilegedRole(d.Type, role) {
+				o.SetAttr(MetaIdPPrivileged, "1")
+			}
+		}
+		AllObjects.Add(o)
+
+		if strings.EqualFold(user.Source, "ACTIVE_DIRECTORY") {
+			adaccount, found := adusers[strings.ToLower(user.UserName)]
+			if !found {
+				adaccount, found = adusers[strings.ToLower(user.Email)]
+			}
+			if found {
+				idpAccounts[adaccount] = o
+				o.SetAttr(MetaIdPADAccount, adaccount.DN())
+				linked++
+			}
+		}
+	}
+
+	idpAdmins[d.Name] = nil
+	for _, o := range AllObjects.AsArray() {
+		if o.OneAttr(MetaIdP) == d.Name && o.OneAttr(MetaIdPPrivileged) == "1" {
+			idpAdmins[d.Name] = append(idpAdmins[d.Name], o)
+		}
+	}
+	return linked
+}
+
+// AD account -> the identity provider user that is mastered from it
+var idpAccounts = make(map[*Object]*Object)
+
+// Identity provider name -> users and groups holding privileged roles
+var idpAdmins = make(map[string][]*Object)
+
+// Returns the admins in the identity provider that can manage the account, either because it's a user in the
+// identity provider, or because it's an AD account mastered there through an agent that writes changes back
+func idpAdminsOf(o *Object) []*Object {
+	idpuser := o
+	if linked, found := idpAccounts[o]; found {
+		idpuser = linked
+	}
+	idp := idpuser.OneAttr(MetaIdP)
+	if idp == "" || idpuser.Type() != ObjectTypeUser {
+		return nil
+	}
+	var results []*Object
+	for _, admin := range idpAdmins[idp] {
+		if admin == idpuser {
+			continue
+		}
+		reason := "holds " + strings.Join(admin.Attr(MetaIdPRoles), ", ") + " in " + idp
+		if idpuser != o {
+			reason += ", which writes changes to " + o.DN() + " back to AD through the directory agent"
+		}
+		SetEdgeReason(admin, o, PwnIdPAdmin, reason)
+		results = append(results, admin)
+	}
+	return results
+}
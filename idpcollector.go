@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Collects users, groups, apps and admin roles from an identity provider using its API
+type IdPCollector struct {
+	Type  string // okta or scim
+	URL   string // Okta org URL (https://contoso.okta.com) or SCIM base URL (https://idp/scim/v2)
+	Token string // Okta API token or SCIM bearer token
+
+	client http.Client
+}
+
+func (c *IdPCollector) Collect() (IdPDirectory, error) {
+	c.URL = strings.TrimSuffix(c.URL, "/")
+	c.client.Timeout = 60 * time.Second
+	directory := IdPDirectory{
+		Type: strings.ToLower(c.Type),
+		URL:  c.URL,
+	}
+	if u, err := url.Parse(c.URL); err == nil {
+		directory.Name = u.Hostname()
+	}
+	var err error
+	switch directory.Type {
+	case "okta":
+		err = c.collectOkta(&directory)
+	case "scim":
+		err = c.collectSCIM(&directory)
+	default:
+		err = fmt.Errorf("Unknown identity provider type %v", c.Type)
+	}
+	return directory, err
+}
+
+func (c *IdPCollector) get(requesturl string, result interface{}) (string, error) {
+	req, err := http.NewRequest("GET", requesturl, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Type == "okta" {
+		req.Header.Set("Authorization", "SSWS "+c.Token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%v returned %v: %v", requesturl, resp.Status, string(data))
+	}
+	return resp.Header.Get("Link"), qjson.Unmarshal(data, result)
+}
+
+var oktaNextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// Gets all pages of an Okta list, which are chained with Link headers
+func (c *IdPCollector) getOktaList(path string, each func(data []byte) error) error {
+	next := c.URL + path
+	for next != "" {
+		var page []json.RawMessage
+		link, err := c.get(next, &page)
+		if err != nil {
+			return err
+		}
+		for _, item := range page {
+			if err = each(item); err != nil {
+				return err
+			}
+		}
+		next = ""
+		if match := oktaNextLink.FindStringSubmatch(link); match != nil {
+			next = match[1]
+		}
+	}
+	return nil
+}
+
+type oktaUser struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Profile struct {
+		Login          string `json:"login"`
+		Email          string `json:"email"`
+		FirstName      string `json:"firstName"`
+		LastName       string `json:"lastName"`
+		DisplayName    string `json:"displayName"`
+		EmployeeNumber string `json:"employeeNumber"`
+	} `json:"profile"`
+	Credentials struct {
+		Provider struct {
+			Type string `json:"type"`
+			Name string `json:"name"`
+		} `json:"provider"`
+	} `json:"credentials"`
+}
+
+type oktaRole struct {
+	Type string `json:"type"`
+}
+
+func (c *IdPCollector) oktaRoles(path string) ([]string, error) {
+	var roles []string
+	err := c.getOktaList(path, func(data []byte) error {
+		var role oktaRole
+		err := qjson.Unmarshal(data, &role)
+		roles = append(roles, role.Type)
+		return err
+	})
+	return roles, err
+}
+
+func (c *IdPCollector) collectOkta(directory *IdPDirectory) error {
+	err := c.getOktaList("/api/v1/users?limit=200", func(data []byte) error {
+		var user oktaUser
+		if err := qjson.Unmarshal(data, &user); err != nil {
+			return err
+		}
+		displayname := user.Profile.DisplayName
+		if displayname == "" {
+			displayname = strings.TrimSpace(user.Profile.FirstName + " " + user.Profile.LastName)
+		}
+		directory.Users = append(directory.Users, IdPUser{
+			ID:          user.ID,
+			UserName:    user.Profile.Login,
+			DisplayName: displayname,
+			Email:       user.Profile.Email,
+			EmployeeID:  user.Profile.EmployeeNumber,
+			Active:      user.Status == "ACTIVE" || user.Status == "PASSWORD_EXPIRED" || user.Status == "RECOVERY",
+			Source:      user.Credentials.Provider.Type,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Collected %v users, getting their admin roles", len(directory.Users))
+	for i, user := range directory.Users {
+		if directory.Users[i].Roles, err = c.oktaRoles("/api/v1/users/" + user.ID + "/roles"); err != nil {
+			return err
+		}
+	}
+
+	err = c.getOktaList("/api/v1/groups?limit=200", func(data []byte) error {
+		var group struct {
+			ID      string `json:"id"`
+			Profile struct {
+				Name string `json:"name"`
+			} `json:"profile"`
+		}
+		err := qjson.Unmarshal(data, &group)
+		directory.Groups = append(directory.Groups, IdPGroup{ID: group.ID, DisplayName: group.Profile.Name})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Collected %v groups, getting their members and admin roles", len(directory.Groups))
+	for i, group := range directory.Groups {
+		err = c.getOktaList("/api/v1/groups/"+group.ID+"/users?limit=200", func(data []byte) error {
+			var user oktaUser
+			err := qjson.Unmarshal(data, &user)
+			directory.Groups[i].Members = append(directory.Groups[i].Members, user.ID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if directory.Groups[i].Roles, err = c.oktaRoles("/api/v1/groups/" + group.ID + "/roles"); err != nil {
+			return err
+		}
+	}
+
+	err = c.getOktaList("/api/v1/apps?limit=200", func(data []byte) error {
+		var app IdPApp
+		err := qjson.Unmarshal(data, &app)
+		directory.Apps = append(directory.Apps, app)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Collected %v apps, getting their assignments", len(directory.Apps))
+	for i, app := range directory.Apps {
+		err = c.getOktaList("/api/v1/apps/"+app.ID+"/users?limit=500", func(data []byte) error {
+			var assignment struct {
+				ID string `json:"id"`
+			}
+			err := qjson.Unmarshal(data, &assignment)
+			directory.Apps[i].Users = append(directory.Apps[i].Users, assignment.ID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = c.getOktaList("/api/v1/apps/"+app.ID+"/groups?limit=200", func(data []byte) error {
+			var assignment struct {
+				ID string `json:"id"`
+			}
+			err := qjson.Unmarshal(data, &assignment)
+			directory.Apps[i].Groups = append(directory.Apps[i].Groups, assignment.ID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type scimListResponse struct {
+	TotalResults int               `json:"totalResults"`
+	ItemsPerPage int               `json:"itemsPerPage"`
+	Resources    []json.RawMessage `json:"Resources"`
+}
+
+type scimMultiValue struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary"`
+}
+
+// Gets all resources from a SCIM endpoint, using index based paging (RFC 7644 section 3.4.2.4)
+func (c *IdPCollector) getSCIMList(resource string, each func(data []byte) error) error {
+	for startindex := 1; ; {
+		var page scimListResponse
+		if _, err := c.get(fmt.Sprintf("%v/%v?startIndex=%v&count=100", c.URL, resource, startindex), &page); err != nil {
+			return err
+		}
+		for _, item := range page.Resources {
+			if err := each(item); err != nil {
+				return err
+			}
+		}
+		startindex += len(page.Resources)
+		if len(page.Resources) == 0 || startindex > page.TotalResults {
+			return nil
+		}
+	}
+}
+
+func (c *IdPCollector) collectSCIM(directory *IdPDirectory) error {
+	err := c.getSCIMList("Users", func(data []byte) error {
+		var user struct {
+			ID          string           `json:"id"`
+			UserName    string           `json:"userName"`
+			DisplayName string           `json:"displayName"`
+			ExternalID  string           `json:"externalId"`
+			Active      *bool            `json:"active"`
+			Emails      []scimMultiValue `json:"emails"`
+			Roles       []scimMultiValue `json:"roles"`
+			Enterprise  struct {
+				EmployeeNumber string `json:"employeeNumber"`
+			} `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"`
+		}
+		if err := qjson.Unmarshal(data, &user); err != nil {
+			return err
+		}
+		idpuser := IdPUser{
+			ID:          user.ID,
+			UserName:    user.UserName,
+			DisplayName: user.DisplayName,
+			ExternalID:  user.ExternalID,
+			EmployeeID:  user.Enterprise.EmployeeNumber,
+			Active:      user.Active == nil || *user.Active,
+		}
+		for _, email := range user.Emails {
+			if idpuser.Email == "" || email.Primary {
+				idpuser.Email = email.Value
+			}
+		}
+		for _, role := range user.Roles {
+			idpuser.Roles = append(idpuser.Roles, role.Value)
+		}
+		directory.Users = append(directory.Users, idpuser)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.getSCIMList("Groups", func(data []byte) error {
+		var group struct {
+			ID          string           `json:"id"`
+			DisplayName string           `json:"displayName"`
+			Members     []scimMultiValue `json:"members"`
+		}
+		if err := qjson.Unmarshal(data, &group); err != nil {
+			return err
+		}
+		idpgroup := IdPGroup{ID: group.ID, DisplayName: group.DisplayName}
+		for _, member := range group.Members {
+			idpgroup.Members = append(idpgroup.Members, member.Value)
+		}
+		directory.Groups = append(directory.Groups, idpgroup)
+		return nil
+	})
+}
+
+// Collects from the identity provider and saves it in the data folder, where analyze picks it up
+func CollectIdP(idptype, idpurl, token, name, datapath string) error {
+	if idpurl == "" || token == "" {
+		return errors.New("Both -idpurl and -idptoken are needed to collect from an identity provider")
+	}
+	collector := IdPCollector{Type: strings.ToLower(idptype), URL: idpurl, Token: token}
+	directory, err := collector.Collect()
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		directory.Name = name
+	}
+	data, err := qjson.MarshalIndent(directory, "", "  ")
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(datapath, directory.Name+".idp.json")
+	log.Info().Msgf("Saving %v users, %v groups and %v apps to %v", len(directory.Users), len(directory.Groups), len(directory.Apps), filename)
+	return os.WriteFile(filename, data, 0600)
+}
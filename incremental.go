@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// What an incremental dump needs to know about the dump before it, saved next to the dump file
+type DumpState struct {
+	Server              string    `json:"server"` // dsServiceName of the domain controller the change number is from
+	HighestCommittedUSN int64     `json:"highestCommittedUSN"`
+	Settings            string    `json:"settings"` // Changes here mean the dump file has other objects or attributes
+	Dumped              time.Time `json:"dumped"`
+}
+
+func dumpStateFilename(dumpfilename string) string {
+	return strings.TrimSuffix(dumpfilename, ".lz4.msgp") + ".state.json"
+}
+
+func loadDumpState(filename string) (DumpState, error) {
+	var state DumpState
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return state, err
+	}
+	err = qjson.Unmarshal(data, &state)
+	return state, err
+}
+
+func saveDumpState(filename string, state DumpState) error {
+	data, err := qjson.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0600)
+}
+
+// Everything that decides what ends up in the dump file, so a dump is only built on one made the same way
+func dumpSettings(contexts []NamingContext, exclusions []string, redactor *Redactor, query string, attributes []string, nosacl bool) string {
+	var parts []string
+	for _, nc := range contexts {
+		parts = append(parts, "context="+strings.ToLower(nc.DN))
+	}
+	for _, exclusion := range exclusions {
+		parts = append(parts, "exclude="+strings.ToLower(exclusion))
+	}
+	if redactor != nil {
+		var redacted []string
+		for attribute := range redactor.attributes {
+			redacted = append(redacted, attribute)
+		}
+		sort.Strings(redacted)
+		parts = append(parts, "redact="+strings.Join(redacted, ","))
+	}
+	parts = append(parts, "query="+query, "attributes="+strings.ToLower(strings.Join(attributes, ",")), fmt.Sprintf("nosacl=%v", nosacl))
+	return strings.Join(parts, ";")
+}
+
+// Dumps only what has changed since the last dump from the same domain controller and merges it into the dump
+// file, or dumps everything if that can't be done. Changes are found by uSNChanged, which anyone who can read the
+// objects can search on. Deleted, moved and renamed objects are found by listing the objectGUID of everything
+func DumpIncremental(ad *AD, filename string, contexts []NamingContext, exclusions []string, redactor *Redactor, query string, attributes []string, nosacl bool, pagesize int) error {
+	if redactor != nil && !redactor.drop {
+		return errors.New("Incremental dumps can't use hashed redaction, as the key is new for every dump and values would no longer match, use -redactmode drop")
+	}
+	if ad.ReferralCredentials != nil {
+		log.Warn().Msg("Referrals are not followed in incremental dumps, as change numbers are per domain controller")
+		ad.ReferralCredentials = nil
+	}
+
+	// Objects are matched across dumps by objectGUID
+	if len(attributes) > 0 && !wantsAttribute(attributes, "objectGUID") {
+		attributes = append(attributes, "objectGUID")
+	}
+
+	// Read this before dumping, so changes made while dumping are picked up by the next dump
+	server, usn, err := ad.HighestCommittedUSN()
+	if err != nil {
+		return fmt.Errorf("Problem reading highestCommittedUSN from %v: %v", ad.Server, err)
+	}
+
+	statefilename := dumpStateFilename(filename)
+	settings := dumpSettings(contexts, exclusions, redactor, query, attributes, nosacl)
+
+	state, err := loadDumpState(statefilename)
+	incremental := true
+	switch {
+	case err != nil:
+		log.Info().Msgf("No state from an earlier dump (%v), dumping everything", err)
+		incremental = false
+	case !strings.EqualFold(state.Server, server):
+		log.Info().Msgf("Last dump was from %v and this is %v, change numbers are per domain controller so dumping everything (use -server to stick to one)", state.Server, server)
+		incremental = false
+	case state.Settings != settings:
+		log.Info().Msg("Dump settings have changed since the last dump, dumping everything")
+		incremental = false
+	case state.HighestCommittedUSN > usn:
+		log.Info().Msgf("Change number on %v has gone back since the last dump (restored from backup?), dumping everything", server)
+		incremental = false
+	}
+
+	var previous []*RawObject
+	if incremental {
+		err = readDumpFile(filename, func(object *RawObject) {
+			previous = append(previous, object)
+		})
+		if err != nil {
+			log.Info().Msgf("Problem reading the last dump (%v), dumping everything", err)
+			incremental = false
+		}
+	}
+
+	var objects []*RawObject
+	var withoutsd int
+	if incremental {
+		log.Info().Msgf("Dumping changes on %v since %v (change number %v)", ad.Server, state.Dumped.Local().Format(time.RFC3339), state.HighestCommittedUSN)
+		changedquery := fmt.Sprintf("(&(uSNChanged>=%v)%v)", state.HighestCommittedUSN+1, query)
+
+		var changed []*RawObject
+		present := make(map[string]string)
+		var unlisted []string
+		for _, nc := range contexts {
+			rawobjects, missingsd, err := dumpNamingContext(ad, nc, exclusions, redactor, changedquery, attributes, nosacl, pagesize)
+			if err != nil {
+				return err
+			}
+			changed = append(changed, rawobjects...)
+			withoutsd += missingsd
+
+			if dnExcluded(nc.DN, exclusions) {
+				continue
+			}
+			listed, err := ad.Dump(nc.DN, query, []string{"objectGUID"}, false, pagesize)
+			if err != nil {
+				if nc.Optional {
+					// Keep what we have, rather than taking it as deleted
+					unlisted = append(unlisted, nc.DN)
+					continue
+				}
+				return err
+			}
+			for _, object := range listed {
+				present[rawObjectKey(object)] = object.DistinguishedName
+			}
+		}
+
+		var stats mergeStatistics
+		objects, stats = mergeDump(previous, changed, present, unlisted)
+		log.Info().Msgf("%v objects changed and %v are new, %v were removed and %v moved or renamed, dump now has %v objects",
+			stats.changed, stats.added, stats.removed, stats.renamed, len(objects))
+	} else {
+		for _, nc := range contexts {
+			rawobjects, missingsd, err := dumpNamingContext(ad, nc, exclusions, redactor, query, attributes, nosacl, pagesize)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, rawobjects...)
+			withoutsd += missingsd
+		}
+	}
+
+	if withoutsd > 0 {
+		log.Warn().Msgf("%v objects are in the dump without a security descriptor, analysis of who controls them is incomplete", withoutsd)
+	}
+
+	// Replace the dump in one go, so it's never left half written
+	if err = writeDumpFile(filename+".tmp", objects); err != nil {
+		os.Remove(filename + ".tmp")
+		return err
+	}
+	if err = os.Rename(filename+".tmp", filename); err != nil {
+		return err
+	}
+
+	return saveDumpState(statefilename, DumpState{
+		Server:              server,
+		HighestCommittedUSN: usn,
+		Settings:            settings,
+		Dumped:              time.Now(),
+	})
+}
+
+// Identifies the object across renames and moves, by objectGUID if it has one
+func rawObjectKey(object *RawObject) string {
+	if guid := rawValues(object, "objectGUID"); len(guid) > 0 {
+		return guid[0]
+	}
+	return "dn:" + strings.ToLower(object.DistinguishedName)
+}
+
+// Values of the attribute, whatever case the server returned the name in
+func rawValues(object *RawObject, attribute string) []string {
+	if values, found := object.Attributes[attribute]; found {
+		return values
+	}
+	for name, values := range object.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+func setRawValues(object *RawObject, attribute string, values []string) {
+	for name := range object.Attributes {
+		if strings.EqualFold(name, attribute) {
+			object.Attributes[name] = values
+			return
+		}
+	}
+	object.Attributes[attribute] = values
+}
+
+type mergeStatistics struct {
+	changed, added, removed, renamed int
+}
+
+// Merges the changed objects into the previous dump. Objects not in present (key -> current DN) are gone, unless
+// they're below one of the unlisted DNs. As only changed objects are dumped again, references to objects that
+// were moved or renamed are updated, references to removed objects are dropped, and memberOf on unchanged
+// objects follows the changes to member on the groups
+func mergeDump(previous, changed []*RawObject, present map[string]string, unlisted []string) ([]*RawObject, mergeStatistics) {
+	var stats mergeStatistics
+
+	changedbykey := make(map[string]*RawObject, len(changed))
+	for _, object := range changed {
+		changedbykey[rawObjectKey(object)] = object
+	}
+
+	renamed := make(map[string]string) // Lowercased old DN -> new DN
+	removed := make(map[string]struct{})
+	memberofadded := make(map[string][]string)              // Lowercased member DN -> group DNs
+	memberofremoved := make(map[string]map[string]struct{}) // Lowercased member DN -> lowercased group DNs
+	type membershipchange struct {
+		group  string
+		before []string
+		after  []string
+	}
+	var membershipchanges []membershipchange
+
+	var result []*RawObject
+	seen := make(map[string]struct{}, len(previous))
+	for _, object := range previous {
+		key := rawObjectKey(object)
+		seen[key] = struct{}{}
+		if updated, found := changedbykey[key]; found {
+			stats.changed++
+			if !strings.EqualFold(updated.DistinguishedName, object.DistinguishedName) {
+				renamed[strings.ToLower(object.DistinguishedName)] = updated.DistinguishedName
+				stats.renamed++
+			}
+			membershipchanges = append(membershipchanges, membershipchange{
+				group:  updated.DistinguishedName,
+				before: rawValues(object, "member"),
+				after:  rawValues(updated, "member"),
+			})
+			result = append(result, updated)
+			continue
+		}
+		dn, found := present[key]
+		if !found {
+			if dnExcluded(object.DistinguishedName, unlisted) {
+				result = append(result, object)
+				continue
+			}
+			removed[strings.ToLower(object.DistinguishedName)] = struct{}{}
+			stats.removed++
+			continue
+		}
+		if dn != object.DistinguishedName {
+			// Moved or renamed without other changes, like everything below an OU that was moved
+			renamed[strings.ToLower(object.DistinguishedName)] = dn
+			object.DistinguishedName = dn
+			stats.renamed++
+		}
+		result = append(result, object)
+	}
+	for _, object := range changed {
+		if _, found := seen[rawObjectKey(object)]; !found {
+			stats.added++
+			membershipchanges = append(membershipchanges, membershipchange{
+				group: object.DistinguishedName,
+				after: rawValues(object, "member"),
+			})
+			result = append(result, object)
+		}
+	}
+
+	// Work out how memberOf changes on the members of groups that changed
+	for _, change := range membershipchanges {
+		before := make(map[string]struct{}, len(change.before))
+		for _, member := range change.before {
+			if newdn, found := renamed[strings.ToLower(member)]; found {
+				member = newdn
+			}
+			before[strings.ToLower(member)] = struct{}{}
+		}
+		after := make(map[string]struct{}, len(change.after))
+		for _, member := range change.after {
+			member = strings.ToLower(member)
+			after[member] = struct{}{}
+			if _, found := before[member]; !found {
+				memberofadded[member] = append(memberofadded[member], change.group)
+			}
+		}
+		for member := range before {
+			if _, found := after[member]; !found {
+				if memberofremoved[member] == nil {
+					memberofremoved[member] = make(map[string]struct{})
+				}
+				memberofremoved[member][strings.ToLower(change.group)] = struct{}{}
+			}
+		}
+	}
+
+	// Fix up the objects that were not dumped again, the changed ones are current already
+	for _, object := range result {
+		if _, found := changedbykey[rawObjectKey(object)]; found {
+			continue
+		}
+		if len(renamed) > 0 || len(removed) > 0 {
+			for name, values := range object.Attributes {
+				if strings.EqualFold(name, "nTSecurityDescriptor") {
+					continue
+				}
+				var fixedup bool
+				fixed := make([]string, 0, len(values))
+				for _, value := range values {
+					if strings.Contains(value, "=") {
+						lowered := strings.ToLower(value)
+						if _, gone := removed[lowered]; gone {
+							fixedup = true
+							continue
+						}
+						if newdn, moved := renamed[lowered]; moved {
+							value = newdn
+							fixedup = true
+						}
+					}
+					fixed = append(fixed, value)
+				}
+				if fixedup {
+					object.Attributes[name] = fixed
+				}
+			}
+		}
+
+		dn := strings.ToLower(object.DistinguishedName)
+		added, removedfrom := memberofadded[dn], memberofremoved[dn]
+		if len(added) == 0 && len(removedfrom) == 0 {
+			continue
+		}
+		var memberof []string
+		already := make(map[string]struct{})
+		for _, group := range append(rawValues(object, "memberOf"), added...) {
+			lowered := strings.ToLower(group)
+			if _, found := removedfrom[lowered]; found {
+				continue
+			}
+			if _, found := already[lowered]; found {
+				continue
+			}
+			already[lowered] = struct{}{}
+			memberof = append(memberof, group)
+		}
+		setRawValues(object, "memberOf", memberof)
+	}
+
+	return result, stats
+}
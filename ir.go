@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Indicators that the domain is already compromised, for incident response. Changes are judged against a window of
+// IRDays before the newest change in the data, so an old dump is looked at as of when it was taken. AD keeps no
+// timestamps on single values or ACEs, so for those the last change of the whole object is what there is
+var IRDays = 30
+
+const (
+	irCritical = iota
+	irHigh
+	irMedium
+)
+
+var irPriorityNames = []string{"Critical", "High", "Medium"}
+
+// Principals that are supposed to have control of privileged objects, besides tier 0
+var irExpectedSIDs = map[string]struct{}{
+	"S-1-3-0":      {}, // Creator Owner
+	"S-1-5-9":      {}, // Enterprise Domain Controllers
+	"S-1-5-10":     {}, // Self
+	"S-1-5-18":     {}, // Local System
+	"S-1-5-32-544": {}, // Administrators
+}
+
+// Key Admins and Enterprise Key Admins manage msDS-KeyCredentialLink on everything by default
+var irExpectedRIDs = []uint32{500, 502, 512, 516, 518, 519, 526, 527}
+
+type irFinding struct {
+	priority int
+	when     time.Time
+	finding  Finding
+}
+
+func irExpected(sid SID) bool {
+	if _, found := irExpectedSIDs[sid.ToString()]; found {
+		return true
+	}
+	if strings.HasPrefix(sid.ToString(), "S-1-5-21-") {
+		rid := sid.RID()
+		for _, expected := range irExpectedRIDs {
+			if rid == expected {
+				return true
+			}
+		}
+	}
+	if principal, found := AllObjects.FindSID(sid); found {
+		return principal.IsTier0()
+	}
+	return false
+}
+
+// SIDs that make whoever has them in their SID history an admin
+func irPrivileged(sid SID) bool {
+	rid := sid.RID()
+	for _, tier0rid := range tier0RIDs {
+		if rid == tier0rid {
+			return true
+		}
+	}
+	return irExpected(sid)
+}
+
+func irPrincipalName(sid SID) string {
+	if principal, found := AllObjects.FindSID(sid); found {
+		return principal.Label() + " (" + sid.ToString() + ")"
+	}
+	return sid.ToString()
+}
+
+// The rights an allow ACE gives that are enough to take over the object
+func irDangerousRights(ace ACE, o *Object) []string {
+	if !ace.AllowObjectClass(o) {
+		return nil
+	}
+	var rights []string
+	for _, right := range []struct {
+		mask   uint32
+		object uuid.UUID
+		name   string
+	}{
+		{RIGHT_GENERIC_ALL, NullGUID, "GenericAll"},
+		{RIGHT_WRITE_DACL, NullGUID, "WriteDACL"},
+		{RIGHT_WRITE_OWNER, NullGUID, "WriteOwner"},
+		{RIGHT_GENERIC_WRITE, NullGUID, "GenericWrite"},
+		{RIGHT_DS_WRITE_PROPERTY, NullGUID, "WriteAllProperties"},
+		{RIGHT_DS_WRITE_PROPERTY, AttributeMember, "WriteMember"},
+		{RIGHT_DS_CONTROL_ACCESS, NullGUID, "AllExtendedRights"},
+		{RIGHT_DS_CONTROL_ACCESS, ResetPwd, "ResetPassword"},
+		{RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChangesAll, "DCSync"},
+	} {
+		if right.object != NullGUID && (ace.Type != ACETYPE_ACCESS_ALLOWED_OBJECT || ace.Flags&OBJECT_TYPE_PRESENT == 0 || ace.ObjectType == NullGUID) {
+			// Already covered by the rights for all properties or extended rights
+			continue
+		}
+		if ace.AllowMaskedClass(right.mask, right.object) {
+			rights = append(rights, right.name)
+		}
+	}
+	return rights
+}
+
+// Read only domain controllers replicate too, but don't have the server trust flag
+func irIsDC(o *Object) bool {
+	uac, _ := o.AttrInt(UserAccountControl)
+	return o.OneAttr(MetaServer) == "1" || uac&UAC_PARTIAL_SECRETS_ACCOUNT != 0
+}
+
+// Owners and explicit ACEs giving control of a privileged object to principals that aren't admins
+func irACL(sd *SecurityDescriptor, o *Object, isadminsdholder, isdomain bool, changed time.Time, changedinwindow bool, add func(int, time.Time, *Object, string, ...interface{})) {
+	if !sd.Owner.IsNull() && !irExpected(sd.Owner) {
+		priority := irMedium
+		if changedinwindow {
+			priority = irHigh
+		}
+		add(priority, changed, o, "Owned by non admin %v", irPrincipalName(sd.Owner))
+	}
+	for _, ace := range sd.DACL.Entries {
+		if ace.ACEFlags&ACEFLAG_INHERITED_ACE != 0 || irExpected(ace.SID) {
+			continue
+		}
+		rights := irDangerousRights(ace, o)
+		if len(rights) == 0 {
+			continue
+		}
+		priority := irMedium
+		switch {
+		case isadminsdholder, isdomain && (StringInSlice("DCSync", rights) || StringInSlice("AllExtendedRights", rights) || StringInSlice("GenericAll", rights)):
+			priority = irCritical
+		case changedinwindow:
+			priority = irHigh
+		}
+		add(priority, changed, o, "Grants %v to non admin %v", strings.Join(rights, ", "), irPrincipalName(ace.SID))
+	}
+}
+
+func irInWindow(t time.Time, ok bool, since time.Time) bool {
+	return ok && !t.IsZero() && t.After(since)
+}
+
+func irReport() []Finding {
+	objects := AllObjects.AsArray()
+	since := dataTimestamp().AddDate(0, 0, -IRDays)
+
+	keydevices := knownKeyCredentialDevices()
+
+	var results []irFinding
+	add := func(priority int, when time.Time, o *Object, format string, args ...interface{}) {
+		results = append(results, irFinding{priority, when, Finding{o.DN(), irPriorityNames[priority] + " - " + fmt.Sprintf(format, args...)}})
+	}
+
+	for _, o := range objects {
+		created, hascreated := o.AttrTimestamp(WhenCreated)
+		changed, haschanged := o.AttrTimestamp(WhenChanged)
+		changedinwindow := irInWindow(changed, haschanged, since)
+
+		// SID history with SIDs from the same domain or of privileged groups is how golden tickets are made to last
+		for _, sid := range o.SIDHistory() {
+			switch {
+			case sid.StripRID() == o.SID().StripRID():
+				add(irCritical, changed, o, "SID history has %v from its own domain", irPrincipalName(sid))
+			case irPrivileged(sid):
+				add(irCritical, changed, o, "SID history has privileged %v", irPrincipalName(sid))
+			case changedinwindow:
+				add(irMedium, changed, o, "SID history has %v, object changed %v", irPrincipalName(sid), changed.Format("2006-01-02"))
+			}
+		}
+
+		if adminCount, ok := o.AttrInt(AdminCount); ok && adminCount > 0 {
+			if irInWindow(created, hascreated, since) {
+				add(irHigh, created, o, "New account protected by AdminSDHolder (adminCount), created %v", created.Format("2006-01-02 15:04:05"))
+			} else if changedinwindow && !o.IsTier0() {
+				add(irMedium, changed, o, "Has adminCount without being tier 0 (removed admin or backdoor), changed %v", changed.Format("2006-01-02 15:04:05"))
+			}
+		}
+
+		// Shadow credentials left behind, keys that let someone log on as the account without the password
+		if o.OneAttr(MetaRogueKeyCredential) == "1" {
+			keys, _ := o.KeyCredentials()
+			for _, kc := range keys {
+				reason := keyCredentialRogue(o, kc, keydevices)
+				if reason == "" {
+					continue
+				}
+				when, hascreated := kc.Created, !kc.Created.IsZero()
+				if !hascreated {
+					when = changed
+				}
+				priority := irMedium
+				switch {
+				case o.IsTier0() || irIsDC(o):
+					priority = irCritical
+				case irInWindow(when, true, since):
+					priority = irHigh
+				}
+				created := "unknown"
+				if hascreated {
+					created = kc.Created.Format("2006-01-02 15:04:05")
+				}
+				add(priority, when, o, "Key credential Windows didn't add (%v), can be used to log on as the account, created %v", reason, created)
+			}
+		}
+
+		isadminsdholder := strings.HasPrefix(strings.ToLower(o.DN()), "cn=adminsdholder,cn=system,")
+		isdomain := StringInSlice("domainDNS", o.Attr(ObjectClass))
+		if isadminsdholder && changedinwindow {
+			add(irHigh, changed, o, "AdminSDHolder was changed %v, its ACL is copied to all protected accounts and groups", changed.Format("2006-01-02 15:04:05"))
+		}
+
+		// Control of privileged objects given to principals that aren't admins
+		if isadminsdholder || isdomain || o.IsTier0() {
+			if sd, err := o.SecurityDescriptor(); err == nil {
+				irACL(sd, o, isadminsdholder, isdomain, changed, changedinwindow, add)
+			}
+		}
+
+		// DCShadow registers a computer as a DC with replication SPNs and an nTDSDSA object, and cleans up later
+		if o.Type() == ObjectTypeComputer && !irIsDC(o) {
+			for _, spn := range o.Attr(ServicePrincipalName) {
+				if strings.HasPrefix(spn, "GC/") || strings.HasPrefix(strings.ToUpper(spn), "E3514235-4B06-11D1-AB04-00C04FC2DCD2/") {
+					add(irCritical, changed, o, "Computer that isn't a domain controller has replication SPN %v", spn)
+				}
+			}
+		}
+		if StringInSlice("nTDSDSA", o.Attr(ObjectClass)) {
+			server, found := AllObjects.Find(o.ParentDN())
+			if !found {
+				continue
+			}
+			reference := server.OneAttr(ServerReference)
+			dc, found := AllObjects.Find(reference)
+			if reference == "" || !found || !irIsDC(dc) {
+				add(irCritical, changed, o, "Domain controller registration without a domain controller computer account behind it (%v)", Default(reference, "no serverReference"))
+			} else if irInWindow(created, hascreated, since) {
+				add(irHigh, created, o, "New domain controller %v registered %v", dc.Label(), created.Format("2006-01-02 15:04:05"))
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].priority != results[j].priority {
+			return results[i].priority < results[j].priority
+		}
+		return results[i].when.After(results[j].when)
+	})
+	findings := make([]Finding, len(results))
+	for i, result := range results {
+		findings[i] = result.finding
+	}
+	return findings
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	krbcrypto "github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	ldap "github.com/lkarlslund/ldap/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Returns the credential cache to use, the one given or the one from KRB5CCNAME
+func ccachePath(ccache string) (string, error) {
+	if ccache == "" {
+		ccache = os.Getenv("KRB5CCNAME")
+	}
+	if ccache == "" {
+		return "", errors.New("No Kerberos credential cache, use -ccache or set KRB5CCNAME")
+	}
+	for _, cachetype := range []string{"KEYRING:", "KCM:", "API:", "MEMORY:", "DIR:", "MSLSA:"} {
+		if strings.HasPrefix(ccache, cachetype) {
+			return "", fmt.Errorf("Only file credential caches are supported, not %v", ccache)
+		}
+	}
+	return strings.TrimPrefix(ccache, "FILE:"), nil
+}
+
+// Sets up a Kerberos client from a credential cache, with the domain controller as KDC. If there's a krb5.conf
+// (KRB5_CONFIG or /etc/krb5.conf) it's used instead, for more complicated setups
+func kerberosClient(domain, server, ccache string) (*client.Client, error) {
+	path, err := ccachePath(ccache)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := credentials.LoadCCache(path)
+	if err != nil {
+		return nil, fmt.Errorf("Problem loading Kerberos credential cache %v: %v", path, err)
+	}
+
+	configfile := os.Getenv("KRB5_CONFIG")
+	if configfile == "" {
+		configfile = "/etc/krb5.conf"
+	}
+	var cfg *config.Config
+	if _, err = os.Stat(configfile); err == nil {
+		cfg, err = config.Load(configfile)
+	} else {
+		realm := cc.DefaultPrincipal.Realm
+		cfg, err = config.NewFromString(fmt.Sprintf(`[libdefaults]
+ default_realm = %v
+ dns_lookup_kdc = true
+ udp_preference_limit = 1
+[realms]
+ %v = {
+  kdc = %v:88
+ }
+[domain_realm]
+ .%v = %v
+ %v = %v
+`, realm, realm, server, strings.ToLower(domain), strings.ToUpper(domain), strings.ToLower(domain), strings.ToUpper(domain)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Problem with Kerberos configuration: %v", err)
+	}
+	cl, err := client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, fmt.Errorf("Problem using Kerberos credential cache %v: %v", path, err)
+	}
+	return cl, nil
+}
+
+// GSS-API checksum flags (RFC 4121 section 4.1.1.1)
+const (
+	gssMutual = 2
+	gssConf   = 16
+	gssInteg  = 32
+)
+
+// Hash of the channel bindings for a TLS connection (RFC 5929 tls-server-end-point), which domain controllers
+// requiring extended protection check
+func channelBindings(cert *x509.Certificate) []byte {
+	hash := crypto.SHA256
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		hash = crypto.SHA512
+	}
+	h := hash.New()
+	h.Write(cert.Raw)
+	appdata := append([]byte("tls-server-end-point:"), h.Sum(nil)...)
+
+	// gss_channel_bindings_struct with no addresses, as it's hashed by Windows
+	bindings := make([]byte, 20, 20+len(appdata))
+	binary.LittleEndian.PutUint32(bindings[16:], uint32(len(appdata)))
+	bindings = append(bindings, appdata...)
+	sum := md5.Sum(bindings)
+	return sum[:]
+}
+
+// Wraps an AP-REQ in the GSS-API framing with the Kerberos mechanism OID (RFC 1964 section 1.1)
+func gssInitialContextToken(apreq []byte) []byte {
+	inner := append([]byte{0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x12, 0x01, 0x02, 0x02, 0x01, 0x00}, apreq...)
+	var length []byte
+	switch l := len(inner); {
+	case l < 0x80:
+		length = []byte{byte(l)}
+	case l < 0x100:
+		length = []byte{0x81, byte(l)}
+	case l < 0x10000:
+		length = []byte{0x82, byte(l >> 8), byte(l)}
+	default:
+		length = []byte{0x83, byte(l >> 16), byte(l >> 8), byte(l)}
+	}
+	return append(append([]byte{0x60}, length...), inner...)
+}
+
+// Returns the Kerberos message inside a GSS-API token, skipping the framing, mechanism OID and token ID
+func gssInnerToken(token []byte) ([]byte, error) {
+	if len(token) < 2 || token[0] != 0x60 {
+		return nil, errors.New("Not a GSS-API token")
+	}
+	i := 2
+	if token[1]&0x80 != 0 {
+		i += int(token[1] & 0x7f)
+	}
+	if len(token) < i+2 || token[i] != 0x06 {
+		return nil, errors.New("No mechanism in GSS-API token")
+	}
+	i += 2 + int(token[i+1]) + 2 // OID and token ID
+	if len(token) <= i {
+		return nil, errors.New("GSS-API token is too short")
+	}
+	return token[i:], nil
+}
+
+func saslBindPacket(id int64, mechanism string, credentials []byte) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	request := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindRequest, nil, "Bind Request")
+	request.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "Version"))
+	request.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "User Name"))
+	auth := ber.Encode(ber.ClassContext, ber.TypeConstructed, 3, "", "authentication")
+	auth.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, mechanism, "SASL Mech"))
+	if credentials != nil {
+		auth.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(credentials), "Credentials"))
+	}
+	request.AppendChild(auth)
+	packet.AppendChild(request)
+	return packet
+}
+
+// Sends an LDAP request on a connection the ldap package isn't handling yet, and returns the result code and the
+// response. Errors other than SASL bind in progress are returned as ldap errors
+func rawLDAPExchange(conn net.Conn, packet *ber.Packet) (int64, *ber.Packet, error) {
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		return 0, nil, err
+	}
+	response, err := ber.ReadPacket(conn)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(response.Children) < 2 || len(response.Children[1].Children) < 3 {
+		return 0, nil, errors.New("Invalid LDAP response")
+	}
+	code, _ := response.Children[1].Children[0].Value.(int64)
+	if code != 0 && code != ldap.LDAPResultSaslBindInProgress {
+		return code, response, ldap.GetLDAPError(response)
+	}
+	return code, response, nil
+}
+
+// StartTLS on a connection the ldap package isn't handling yet
+func rawStartTLS(conn net.Conn, config *tls.Config) (*tls.Conn, error) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 1, "MessageID"))
+	request := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationExtendedRequest, nil, "Start TLS")
+	request.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, "1.3.6.1.4.1.1466.20037", "TLS Extended Command"))
+	packet.AppendChild(request)
+	if _, _, err := rawLDAPExchange(conn, packet); err != nil {
+		return nil, err
+	}
+	tlsconn := tls.Client(conn, config)
+	return tlsconn, tlsconn.Handshake()
+}
+
+// Does a SASL GSSAPI bind (RFC 4752) with a Kerberos ticket for the LDAP service on the server. Over TLS the channel
+// bindings are included, without TLS the integrity security layer is negotiated so the connection is signed. The
+// returned connection must be used from then on
+func gssapiBind(conn net.Conn, cl *client.Client, server string, peercert *x509.Certificate) (net.Conn, error) {
+	spn := "ldap/" + server
+	tkt, sessionkey, err := cl.GetServiceTicket(spn)
+	if err != nil {
+		return nil, fmt.Errorf("Problem getting a Kerberos ticket for %v: %v", spn, err)
+	}
+
+	auth, err := types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+	if err != nil {
+		return nil, err
+	}
+	etype, err := krbcrypto.GetEtype(sessionkey.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	if err = auth.GenerateSeqNumberAndSubKey(sessionkey.KeyType, etype.GetKeyByteSize()); err != nil {
+		return nil, err
+	}
+	checksum := make([]byte, 24)
+	binary.LittleEndian.PutUint32(checksum[0:4], 16)
+	flags := uint32(gssMutual)
+	if peercert != nil {
+		copy(checksum[4:20], channelBindings(peercert))
+	} else {
+		flags |= gssInteg | gssConf
+	}
+	binary.LittleEndian.PutUint32(checksum[20:24], flags)
+	auth.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  checksum,
+	}
+	apreq, err := messages.NewAPReq(tkt, sessionkey, auth)
+	if err != nil {
+		return nil, err
+	}
+	apreq.APOptions = types.NewKrbFlags()
+	types.SetFlag(&apreq.APOptions, 2) // Mutual required
+	apreqbytes, err := apreq.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	// AP-REQ, answered by an AP-REP carrying the key the server wants to use from now on. Mutual authentication was
+	// asked for, so without a valid AP-REP it could be anyone answering
+	code, response, err := rawLDAPExchange(conn, saslBindPacket(1, "GSSAPI", gssInitialContextToken(apreqbytes)))
+	if err != nil {
+		return nil, err
+	}
+	if code != ldap.LDAPResultSaslBindInProgress {
+		return nil, fmt.Errorf("Server didn't answer the Kerberos ticket with mutual authentication (result %v)", code)
+	}
+	token, err := gssInnerToken(saslServerCredentials(response))
+	if err != nil {
+		return nil, fmt.Errorf("Problem reading the Kerberos AP-REP: %v", err)
+	}
+	var aprep messages.APRep
+	if err = aprep.Unmarshal(token); err != nil {
+		return nil, fmt.Errorf("Problem reading the Kerberos AP-REP: %v", err)
+	}
+	decrypted, err := krbcrypto.DecryptEncPart(aprep.EncPart, sessionkey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return nil, fmt.Errorf("Problem decrypting the Kerberos AP-REP, the server doesn't have the key for %v: %v", spn, err)
+	}
+	var encpart messages.EncAPRepPart
+	if err = encpart.Unmarshal(decrypted); err != nil {
+		return nil, fmt.Errorf("Problem reading the Kerberos AP-REP: %v", err)
+	}
+	// The server proves it could read the authenticator by sending its time back (RFC 4120 section 3.2.4)
+	if encpart.CTime.Unix() != auth.CTime.Unix() || encpart.Cusec != auth.Cusec {
+		return nil, errors.New("Kerberos AP-REP doesn't match the authenticator sent")
+	}
+	key := auth.SubKey
+	if len(encpart.Subkey.KeyValue) > 0 {
+		key = encpart.Subkey
+	}
+
+	// Empty response, answered by a wrap token with the security layers the server supports
+	code, response, err = rawLDAPExchange(conn, saslBindPacket(2, "GSSAPI", []byte{}))
+	if err != nil {
+		return nil, err
+	}
+	if code == 0 {
+		return conn, nil
+	}
+	offerbytes := saslServerCredentials(response)
+	var offer gssapi.WrapToken
+	if err = offer.Unmarshal(offerbytes, true); err != nil {
+		return nil, fmt.Errorf("Problem reading GSSAPI security layer offer: %v", err)
+	}
+	if offer.Flags&0x04 == 0 {
+		// No acceptor subkey, so it's ours
+		key = auth.SubKey
+	}
+
+	// Integrity without TLS if the server offers it, so the connection is signed. The maximum message size is what
+	// the server offered
+	session := &gssapiSession{
+		key:   key,
+		flags: offer.Flags & 0x04,
+		ec:    uint16(etype.GetHMACBitLength() / 8),
+		seq:   uint64(auth.SeqNumber),
+	}
+	// The offer is signed like everything else from the server, so the security layer can't be changed on the way
+	offerpayload, err := session.Unwrap(offerbytes)
+	if err != nil {
+		return nil, fmt.Errorf("Problem verifying GSSAPI security layer offer: %v", err)
+	}
+	layer := []byte{1, 0, 0, 0}
+	if peercert == nil && len(offerpayload) == 4 && offerpayload[0]&2 != 0 {
+		layer = append([]byte{2}, offerpayload[1:]...)
+	}
+	replybytes, err := session.Wrap(layer)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err = rawLDAPExchange(conn, saslBindPacket(3, "GSSAPI", replybytes)); err != nil {
+		return nil, err
+	}
+	if layer[0] == 1 {
+		return conn, nil
+	}
+	return &securityLayerConn{Conn: conn, wrap: session.Wrap, unwrap: session.Unwrap}, nil
+}
+
+// Integrity protection of messages after a GSSAPI bind, with wrap tokens that aren't sealed (RFC 4121 section 4.2.4)
+type gssapiSession struct {
+	key   types.EncryptionKey
+	flags byte
+	ec    uint16
+	seq   uint64
+}
+
+func (s *gssapiSession) Wrap(message []byte) ([]byte, error) {
+	token := gssapi.WrapToken{
+		Flags:     s.flags,
+		EC:        s.ec,
+		SndSeqNum: s.seq,
+		Payload:   message,
+	}
+	if err := token.SetCheckSum(s.key, keyusage.GSSAPI_INITIATOR_SEAL); err != nil {
+		return nil, err
+	}
+	s.seq++
+	return token.Marshal()
+}
+
+func (s *gssapiSession) Unwrap(data []byte) ([]byte, error) {
+	if len(data) > 16 {
+		// Undo the rotation Windows may do (RFC 4121 section 4.2.5)
+		if rrc := int(binary.BigEndian.Uint16(data[6:8])); rrc != 0 {
+			body := data[16:]
+			rrc %= len(body)
+			data = append(append(append([]byte{}, data[:16]...), body[rrc:]...), body[:rrc]...)
+		}
+	}
+	var token gssapi.WrapToken
+	if err := token.Unmarshal(data, true); err != nil {
+		return nil, err
+	}
+	if token.Flags&0x02 != 0 {
+		return nil, errors.New("Sealed GSSAPI messages are not supported")
+	}
+	if _, err := token.Verify(s.key, keyusage.GSSAPI_ACCEPTOR_SEAL); err != nil {
+		return nil, err
+	}
+	return token.Payload, nil
+}
+
+// Returns serverSaslCreds from a bind response, if it's there
+func saslServerCredentials(response *ber.Packet) []byte {
+	if response == nil || len(response.Children) < 2 {
+		return nil
+	}
+	for _, child := range response.Children[1].Children {
+		if child.ClassType == ber.ClassContext && child.Tag == 7 {
+			return child.Data.Bytes()
+		}
+	}
+	return nil
+}
+
+// Connects and binds with Kerberos using the ticket cache, for use from machines that are not domain joined
+func (ad *AD) connectKerberos() error {
+	cl, err := kerberosClient(ad.Domain, ad.Server, ad.CCache)
+	if err != nil {
+		return err
+	}
+	if directoryProxied {
+		// gokrb5 talks to the KDC on its own, so only the LDAP connection goes through the proxy
+		log.Warn().Msgf("Kerberos requests to the KDC don't go through the proxy, the credential cache needs a ticket for ldap/%v unless the KDC is reachable", ad.Server)
+	}
+	conn, peercert, err := ad.dialTransport()
+	if err != nil {
+		return err
+	}
+	bound, err := gssapiBind(conn, cl, ad.Server, peercert)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	ad.conn = ldap.NewConn(bound, peercert != nil)
+	ad.conn.Start()
+	return nil
+}
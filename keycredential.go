@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// msDS-KeyCredentialLink holds the public keys an account can log on with using PKINIT instead of a password, added by
+// Windows Hello for Business or hybrid join. Anyone who can write it can add a key of their own and get a ticket and
+// the NT hash of the account (shadow credentials, Whisker). Only NGC keys are accepted by the KDC. Windows uses the
+// Entra ID device ID as DeviceId, which for hybrid joined computers is the objectGUID of the computer, where the tools
+// make up a random one
+
+const keyCredentialVersion2 = 0x200
+
+// Entry identifiers in KEYCREDENTIALLINK_BLOB
+const (
+	keyCredentialKeyID                = 0x01
+	keyCredentialKeyHash              = 0x02
+	keyCredentialKeyMaterial          = 0x03
+	keyCredentialKeyUsage             = 0x04
+	keyCredentialKeySource            = 0x05
+	keyCredentialDeviceID             = 0x06
+	keyCredentialCustomKeyInformation = 0x07
+	keyCredentialLastLogon            = 0x08
+	keyCredentialCreationTime         = 0x09
+)
+
+const (
+	KeyUsageNGC  = 0x01
+	KeyUsageFIDO = 0x07
+	KeyUsageFEK  = 0x08
+)
+
+var keyUsageNames = map[byte]string{
+	KeyUsageNGC:  "NGC",
+	KeyUsageFIDO: "FIDO",
+	KeyUsageFEK:  "FEK",
+}
+
+type KeyCredential struct {
+	KeyID       []byte
+	KeyMaterial []byte
+	Usage       byte
+	Source      byte // 0 is AD, 1 is Entra ID
+	DeviceID    uuid.UUID
+	LastLogon   time.Time
+	Created     time.Time
+	Owner       string // DN in the DN-Binary value, the account it's on
+}
+
+func (kc KeyCredential) UsageString() string {
+	if name, found := keyUsageNames[kc.Usage]; found {
+		return name
+	}
+	return "usage " + strconv.Itoa(int(kc.Usage))
+}
+
+// The DeviceId as Windows shows it
+func (kc KeyCredential) Device() string {
+	if kc.DeviceID == uuid.Nil {
+		return "no device"
+	}
+	return SwapUUIDEndianess(kc.DeviceID).String()
+}
+
+// Parses a value of msDS-KeyCredentialLink, as DN-Binary (B:<number of hex digits>:<hex>:<DN>) from LDAP or as the
+// blob itself
+func ParseKeyCredential(value string) (KeyCredential, error) {
+	var kc KeyCredential
+	blob := []byte(value)
+	if strings.HasPrefix(value, "B:") {
+		parts := strings.SplitN(value, ":", 4)
+		if len(parts) != 4 {
+			return kc, errors.New("malformed DN-Binary value")
+		}
+		digits, err := strconv.Atoi(parts[1])
+		if err != nil || digits != len(parts[2]) {
+			return kc, errors.New("DN-Binary length doesn't match the data")
+		}
+		if blob, err = hex.DecodeString(parts[2]); err != nil {
+			return kc, err
+		}
+		kc.Owner = parts[3]
+	}
+
+	if len(blob) < 4 {
+		return kc, errors.New("key credential is too short")
+	}
+	if version := binary.LittleEndian.Uint32(blob); version != keyCredentialVersion2 {
+		return kc, fmt.Errorf("unsupported key credential version 0x%x", version)
+	}
+	for data := blob[4:]; len(data) > 0; {
+		if len(data) < 3 {
+			return kc, errors.New("truncated key credential entry")
+		}
+		length := int(binary.LittleEndian.Uint16(data))
+		identifier := data[2]
+		if len(data) < 3+length {
+			return kc, errors.New("truncated key credential entry")
+		}
+		entry := data[3 : 3+length]
+		data = data[3+length:]
+
+		switch identifier {
+		case keyCredentialKeyID:
+			kc.KeyID = entry
+		case keyCredentialKeyMaterial:
+			kc.KeyMaterial = entry
+		case keyCredentialKeyUsage:
+			if length == 1 {
+				kc.Usage = entry[0]
+			}
+		case keyCredentialKeySource:
+			if length == 1 {
+				kc.Source = entry[0]
+			}
+		case keyCredentialDeviceID:
+			kc.DeviceID = uuid.FromBytesOrNil(entry)
+		case keyCredentialLastLogon:
+			if length == 8 {
+				kc.LastLogon = FiletimeToTime(binary.LittleEndian.Uint64(entry))
+			}
+		case keyCredentialCreationTime:
+			if length == 8 {
+				kc.Created = FiletimeToTime(binary.LittleEndian.Uint64(entry))
+			}
+		}
+	}
+	return kc, nil
+}
+
+// The key credentials on the object that parse, and the problem with the last one that doesn't
+func (o *Object) KeyCredentials() ([]KeyCredential, error) {
+	var results []KeyCredential
+	var problem error
+	for _, value := range o.Attr(MSDSKeyCredentialLink) {
+		kc, err := ParseKeyCredential(value)
+		if err != nil {
+			problem = err
+			continue
+		}
+		results = append(results, kc)
+	}
+	return results, problem
+}
+
+// Device IDs Windows could have used for a key: computers (hybrid joined), registered devices written back to AD and
+// Entra ID devices
+func knownKeyCredentialDevices() map[uuid.UUID]*Object {
+	devices := make(map[uuid.UUID]*Object)
+	for _, o := range AllObjects.AsArray() {
+		switch {
+		case o.Type() == ObjectTypeComputer:
+			if guid := o.GUID(); guid != uuid.Nil {
+				devices[guid] = o
+			}
+		case StringInSlice("msDS-Device", o.Attr(ObjectClass)):
+			if guid := uuid.FromBytesOrNil([]byte(o.OneAttr(MSDSDeviceID))); guid != uuid.Nil {
+				devices[guid] = o
+			}
+		case o.OneAttr(MetaEntraDeviceID) != "":
+			if guid, err := uuid.FromString(o.OneAttr(MetaEntraDeviceID)); err == nil {
+				devices[SwapUUIDEndianess(guid)] = o
+			}
+		}
+	}
+	return devices
+}
+
+// Why a key credential on o looks like it was added by someone else than Windows, or "" if it doesn't
+func keyCredentialRogue(o *Object, kc KeyCredential, devices map[uuid.UUID]*Object) string {
+	if kc.Usage != KeyUsageNGC {
+		// The KDC doesn't take it for logon
+		return ""
+	}
+	if kc.DeviceID == uuid.Nil {
+		return "has no DeviceId"
+	}
+	if o.Type() == ObjectTypeComputer {
+		// A computer only adds its own key
+		if kc.DeviceID != o.GUID() {
+			return "DeviceId " + kc.Device() + " isn't the computer's objectGUID"
+		}
+		return ""
+	}
+	if _, found := devices[kc.DeviceID]; !found {
+		return "DeviceId " + kc.Device() + " isn't a computer or device in the data"
+	}
+	return ""
+}
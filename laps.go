@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Windows LAPS (built into Windows since April 2023) stores the password in msLAPS-Password, or encrypted in
+// msLAPS-EncryptedPassword (msLAPS-EncryptedDSRMPassword on domain controllers). An encrypted password can only be
+// read by someone who can read the attribute and is the authorized decryptor: Domain Admins, unless Group Policy sets
+// ADPasswordEncryptionPrincipal. The decryptor is also in the encrypted blob, which is used if the dump has it
+
+const windowsLAPSPolicyKey = `HKLM\Software\Microsoft\Windows\CurrentVersion\Policies\LAPS`
+
+type windowsLAPSPolicy struct {
+	Encrypted *bool   // Not set by the GPO if nil
+	Decryptor *Object // Not set by the GPO if nil
+}
+
+// GPO -> Windows LAPS settings in it
+var windowsLAPSPolicies map[*Object]windowsLAPSPolicy
+
+// Finds the Windows LAPS settings in the GPOs, from the Registry.pol files loaded from SYSVOL
+func buildWindowsLAPSPolicies() {
+	windowsLAPSPolicies = make(map[*Object]windowsLAPSPolicy)
+	for gpo, settings := range gpoSettings {
+		var policy windowsLAPSPolicy
+		var found bool
+		for _, value := range settings.Registry {
+			if !strings.EqualFold(value.Key, windowsLAPSPolicyKey) {
+				continue
+			}
+			switch strings.ToLower(value.Value) {
+			case "adpasswordencryptionenabled":
+				encrypted := value.Data != "0"
+				policy.Encrypted = &encrypted
+				found = true
+			case "adpasswordencryptionprincipal":
+				if decryptor, resolved := resolveAccount(value.Data); resolved {
+					policy.Decryptor = decryptor
+					found = true
+				} else {
+					log.Warn().Msgf("Windows LAPS decryptor %v set by GPO %v is not in the data", value.Data, gpo.Label())
+				}
+			}
+		}
+		if found {
+			windowsLAPSPolicies[gpo] = policy
+		}
+	}
+}
+
+// Returns the principal in the protection descriptor (SID=S-1-5-21-...) of an encrypted password, which is in UTF-16
+// in the CMS envelope after the 16 byte header
+func windowsLAPSBlobDecryptor(blob string) (SID, bool) {
+	marker := string([]byte{'S', 0, 'I', 0, 'D', 0, '=', 0})
+	start := strings.Index(blob, marker)
+	if start == -1 {
+		return "", false
+	}
+	var chars []uint16
+	for i := start + len(marker); i+1 < len(blob); i += 2 {
+		c := uint16(blob[i]) | uint16(blob[i+1])<<8
+		if c != 'S' && c != '-' && (c < '0' || c > '9') {
+			break
+		}
+		chars = append(chars, c)
+	}
+	sid, err := SIDFromString(string(utf16.Decode(chars)))
+	return sid, err == nil
+}
+
+// Is the password of the computer encrypted, and who can decrypt it? Unknown if neither the dump nor Group Policy
+// tells, as it depends on the domain functional level and the Windows LAPS version on the computer
+func windowsLAPSEncryption(o *Object) (encrypted, known bool, decryptor *Object) {
+	if len(o.Attr(LAPSPassword)) > 0 {
+		return false, true, nil
+	}
+	for _, attribute := range []Attribute{LAPSEncryptedPassword, LAPSEncryptedDSRMPassword} {
+		if blob := o.OneAttr(attribute); blob != "" {
+			encrypted, known = true, true
+			if sid, found := windowsLAPSBlobDecryptor(blob); found {
+				decryptor = AllObjects.FindOrAddSID(sid)
+			}
+		}
+	}
+	// Closer GPOs are processed later and win, but what is in the dump is what happened
+	fromdump := known
+	for _, link := range gpoAppliedLinks(o) {
+		policy, found := windowsLAPSPolicies[link.GPO]
+		if !found {
+			continue
+		}
+		if policy.Encrypted != nil && !fromdump {
+			encrypted, known = *policy.Encrypted, true
+		}
+		if policy.Decryptor != nil && decryptor == nil {
+			decryptor = policy.Decryptor
+		}
+	}
+	if decryptor == nil {
+		// Domain Admins of the domain the computer is in
+		if domainadmins, found := AllObjects.FindSID(o.SID().StripRID().AddSubAuthority(512)); found {
+			decryptor = domainadmins
+		}
+	}
+	return encrypted, known, decryptor
+}
+
+func windowsLAPSCanDecrypt(o, decryptor *Object) bool {
+	if decryptor == nil || o == decryptor {
+		return decryptor != nil
+	}
+	for _, group := range o.MemberOfRecursive() {
+		if group == decryptor {
+			return true
+		}
+	}
+	return false
+}
+
+// Reading the Windows LAPS password, with the schemaIDGUIDs of the password attributes in the schema
+func MakeWindowsLAPSPwnAnalyzer(attributes map[string]uuid.UUID) PwnAnalyzer {
+	return PwnAnalyzer{
+		Method:      PwnReadLAPSPassword,
+		Description: "Can read the Windows LAPS password of the computer, and decrypt it if it is encrypted",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeComputer || len(o.Attr(LAPSPasswordExpirationTime)) == 0 {
+				return nil
+			}
+			sd, err := o.SecurityDescriptor()
+			if err != nil {
+				return nil
+			}
+			encrypted, known, decryptor := windowsLAPSEncryption(o)
+
+			var results []*Object
+			seen := make(map[*Object]struct{})
+			for _, acl := range sd.DACL.Entries {
+				if !acl.AllowObjectClass(o) {
+					continue
+				}
+				for _, name := range []string{"msLAPS-Password", "msLAPS-EncryptedPassword", "msLAPS-EncryptedDSRMPassword"} {
+					guid, found := attributes[name]
+					// Confidential attributes need the control access right too
+					if !found || !acl.AllowMaskedClass(RIGHT_DS_READ_PROPERTY|RIGHT_DS_CONTROL_ACCESS, guid) {
+						continue
+					}
+					reader := AllObjects.FindOrAddSID(acl.SID)
+					if _, done := seen[reader]; done {
+						continue
+					}
+					var reason string
+					if name == "msLAPS-Password" {
+						if known && encrypted {
+							continue
+						}
+						reason = "can read " + name
+						if !known {
+							reason += ", if the password isn't encrypted"
+						}
+					} else {
+						if (known && !encrypted) || !windowsLAPSCanDecrypt(reader, decryptor) {
+							continue
+						}
+						reason = "can read " + name + " and decrypt it as " + decryptor.Label()
+					}
+					seen[reader] = struct{}{}
+					SetEdgeReason(reader, o, PwnReadLAPSPassword, reason)
+					results = append(results, reader)
+				}
+			}
+			return results
+		},
+	}
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	ber "github.com/go-asn1-ber/asn1-ber"
 	ldap "github.com/lkarlslund/ldap/v3"
+	"github.com/rs/zerolog/log"
 	"github.com/schollz/progressbar/v3"
 )
 
@@ -31,46 +37,159 @@ type AD struct {
 	AuthDomain string
 	TLSMode    TLSmode
 	IgnoreCert bool
+	CCache     string // Kerberos credential cache for gssapi binds, blank means KRB5CCNAME
+	ADWS       bool   // Collect over Active Directory Web Services instead of LDAP
+
+	// Referral chasing - nil means referrals are ignored
+	ReferralCredentials map[string]ReferralCredential
+
+	authmode          byte
+	referralsfollowed map[string]struct{}
+	quiet             bool // No progress bar from Dump, for dumps running at the same time
 
 	conn *ldap.Conn
+	adws *adwsClient
+}
+
+// Credentials to use when following a referral to another domain
+type ReferralCredential struct {
+	User     string
+	Password string
+}
+
+// Reads credentials for referred domains, one per line as "domain username password". Empty lines and
+// lines starting with # are ignored
+func LoadReferralCredentials(filename string) (map[string]ReferralCredential, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]ReferralCredential)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Line %v in %v should be: domain username password", i+1, filename)
+		}
+		result[strings.ToLower(fields[0])] = ReferralCredential{
+			User:     fields[1],
+			Password: fields[2],
+		}
+	}
+	return result, nil
+}
+
+// Bind modes, the index is the authmode passed to Connect
+var authModes = []string{"unauth", "simple", "md5", "ntlm", "ntlmpth", "ntlmsspi", "gssapi"}
+
+func ParseAuthMode(name string) (byte, error) {
+	for i, mode := range authModes {
+		if strings.EqualFold(mode, name) {
+			return byte(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown LDAP authentication mode %v", name)
+}
+
+func AuthModeString(authmode byte) string {
+	if int(authmode) < len(authModes) {
+		return authModes[authmode]
+	}
+	return fmt.Sprintf("unknown (%v)", authmode)
+}
+
+// Connects without the ldap package, for binds it can't do. Returns the certificate of the server if TLS is used,
+// for the channel bindings
+func (ad *AD) dialTransport() (net.Conn, *x509.Certificate, error) {
+	address := net.JoinHostPort(ad.Server, strconv.Itoa(int(ad.Port)))
+	tlsconfig := &tls.Config{
+		ServerName:         ad.Server,
+		InsecureSkipVerify: ad.IgnoreCert,
+	}
+	switch ad.TLSMode {
+	case NoTLS, StartTLS:
+		conn, err := dialDirectoryTimeout(address, 30*time.Second)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ad.TLSMode == NoTLS {
+			return conn, nil, nil
+		}
+		tlsconn, err := rawStartTLS(conn, tlsconfig)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		return tlsconn, tlsconn.ConnectionState().PeerCertificates[0], nil
+	case TLS:
+		tlsconn, err := dialDirectoryTLS(address, 30*time.Second, tlsconfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tlsconn, tlsconn.ConnectionState().PeerCertificates[0], nil
+	}
+	return nil, nil, errors.New("Unknown transport mode")
 }
 
 func (ad *AD) Connect(authmode byte) error {
 	if ad.AuthDomain == "" {
 		ad.AuthDomain = ad.Domain
 	}
+	if ad.ADWS {
+		ad.authmode = authmode
+		return ad.connectADWS(authmode)
+	}
+	switch authmode {
+	case 3, 4:
+		ad.authmode = authmode
+		return ad.connectNTLM(authmode == 4)
+	case 6:
+		ad.authmode = authmode
+		return ad.connectKerberos()
+	}
+	address := net.JoinHostPort(ad.Server, strconv.Itoa(int(ad.Port)))
 	switch ad.TLSMode {
 	case NoTLS:
-		conn, err := ldap.Dial("tcp", fmt.Sprintf("%s:%d", ad.Server, ad.Port))
+		conn, err := dialDirectoryTimeout(address, 30*time.Second)
 		if err != nil {
 			return err
 		}
-		ad.conn = conn
+		ad.conn = ldap.NewConn(conn, false)
+		ad.conn.Start()
 	case StartTLS:
-		conn, err := ldap.Dial("tcp", fmt.Sprintf("%s:%d", ad.Server, ad.Port))
+		conn, err := dialDirectoryTimeout(address, 30*time.Second)
 		if err != nil {
 			return err
 		}
+		ad.conn = ldap.NewConn(conn, false)
+		ad.conn.Start()
 
-		err = conn.StartTLS(&tls.Config{ServerName: ad.Server})
+		err = ad.conn.StartTLS(&tls.Config{ServerName: ad.Server})
 		if err != nil {
+			ad.conn.Close()
+			ad.conn = nil
 			return err
 		}
-		ad.conn = conn
 	case TLS:
 		config := &tls.Config{
 			ServerName:         ad.Server,
 			InsecureSkipVerify: ad.IgnoreCert,
 		}
-		conn, err := ldap.DialTLS("tcp", fmt.Sprintf("%s:%d", ad.Server, ad.Port), config)
+		conn, err := dialDirectoryTLS(address, 30*time.Second, config)
 		if err != nil {
 			return err
 		}
-		ad.conn = conn
+		ad.conn = ldap.NewConn(conn, true)
+		ad.conn.Start()
 	default:
 		return errors.New("Unknown transport mode")
 	}
 
+	ad.authmode = authmode
+
 	var err error
 	switch authmode {
 	case 0:
@@ -79,10 +198,6 @@ func (ad *AD) Connect(authmode byte) error {
 		err = ad.conn.Bind(ad.User, ad.Password)
 	case 2:
 		err = ad.conn.MD5Bind(ad.AuthDomain, ad.User, ad.Password)
-	case 3:
-		err = ad.conn.NTLMBind(ad.AuthDomain, ad.User, ad.Password)
-	case 4:
-		err = ad.conn.NTLMBindWithHash(ad.AuthDomain, ad.User, ad.Password)
 	case 5:
 		err = ad.conn.NTLMSSPIBind()
 	default:
@@ -96,6 +211,9 @@ func (ad *AD) Connect(authmode byte) error {
 }
 
 func (ad *AD) Disconnect() error {
+	if ad.adws != nil {
+		return ad.adws.Close()
+	}
 	if ad.conn == nil {
 		return errors.New("Not connected")
 	}
@@ -103,12 +221,219 @@ func (ad *AD) Disconnect() error {
 	return nil
 }
 
+// Returns the clock of the domain controller, from currentTime in the rootDSE (readable without binding)
+func (ad *AD) ServerTime() (time.Time, error) {
+	if ad.conn == nil {
+		return time.Time{}, errors.New("Not connected")
+	}
+	request := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"currentTime"}, nil)
+	response, err := ad.conn.Search(request)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(response.Entries) != 1 {
+		return time.Time{}, errors.New("No rootDSE returned")
+	}
+	return time.Parse("20060102150405.0Z0700", response.Entries[0].GetAttributeValue("currentTime"))
+}
+
+// Returns the domain controller as the DN of its NTDS settings (dsServiceName), and the highest change number it
+// has committed. Change numbers are local to each domain controller, so the two only make sense together
+func (ad *AD) HighestCommittedUSN() (string, int64, error) {
+	if ad.conn == nil {
+		return "", 0, errors.New("Not connected")
+	}
+	request := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"dsServiceName", "highestCommittedUSN"}, nil)
+	response, err := ad.conn.Search(request)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(response.Entries) != 1 {
+		return "", 0, errors.New("No rootDSE returned")
+	}
+	usn, err := strconv.ParseInt(response.Entries[0].GetAttributeValue("highestCommittedUSN"), 10, 64)
+	return response.Entries[0].GetAttributeValue("dsServiceName"), usn, err
+}
+
+// A naming context in the forest, from its crossRef object in the configuration
+type Partition struct {
+	DN      string // nCName
+	DNSRoot string
+	Domain  bool // The naming context of a domain, not the schema, configuration or an application partition
+}
+
+// Bit in systemFlags of a crossRef for the naming context of a domain
+const crossRefNTDSDomain = 0x2
+
+// Returns the naming contexts in the forest, from the crossRef objects below CN=Partitions in the configuration
+func (ad *AD) Partitions() ([]Partition, error) {
+	if ad.conn == nil {
+		return nil, errors.New("Not connected")
+	}
+	request := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"configurationNamingContext"}, nil)
+	response, err := ad.conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Entries) != 1 {
+		return nil, errors.New("No rootDSE returned")
+	}
+	request = ldap.NewSearchRequest("CN=Partitions,"+response.Entries[0].GetAttributeValue("configurationNamingContext"),
+		ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=crossRef)", []string{"nCName", "dnsRoot", "systemFlags"}, nil)
+	response, err = ad.conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	var partitions []Partition
+	for _, entry := range response.Entries {
+		flags, _ := strconv.ParseInt(entry.GetAttributeValue("systemFlags"), 10, 64)
+		partitions = append(partitions, Partition{
+			DN:      entry.GetAttributeValue("nCName"),
+			DNSRoot: strings.ToLower(entry.GetAttributeValue("dnsRoot")),
+			Domain:  flags&crossRefNTDSDomain != 0,
+		})
+	}
+	return partitions, nil
+}
+
+// Returns the naming contexts the server has for its domain, from the rootDSE. Unlike DefaultNamingContexts this
+// gets the schema and configuration right for domains that are not the root of their forest
+func (ad *AD) NamingContexts() ([]NamingContext, error) {
+	if ad.conn == nil {
+		return nil, errors.New("Not connected")
+	}
+	request := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"schemaNamingContext", "configurationNamingContext", "rootDomainNamingContext", "defaultNamingContext"}, nil)
+	response, err := ad.conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Entries) != 1 {
+		return nil, errors.New("No rootDSE returned")
+	}
+	rootdse := response.Entries[0]
+	return []NamingContext{
+		{Name: "schema", DN: rootdse.GetAttributeValue("schemaNamingContext")},
+		{Name: "configuration", DN: rootdse.GetAttributeValue("configurationNamingContext")},
+		{Name: "forest DNS", DN: "DC=ForestDnsZones," + rootdse.GetAttributeValue("rootDomainNamingContext"), Optional: true},
+		{Name: "domain DNS", DN: "DC=DomainDnsZones," + rootdse.GetAttributeValue("defaultNamingContext"), Optional: true},
+		{Name: "main AD", DN: rootdse.GetAttributeValue("defaultNamingContext")},
+	}, nil
+}
+
+// A trust from a trustedDomain object, as seen from the domain it's in
+type DomainTrust struct {
+	Partner    string // DNS name of the other domain
+	Direction  int64
+	Attributes int64
+}
+
+// Returns the trusts of the domain, from the trustedDomain objects in CN=System
+func (ad *AD) Trusts() ([]DomainTrust, error) {
+	if ad.conn == nil {
+		return nil, errors.New("Not connected")
+	}
+	request := ldap.NewSearchRequest("CN=System,"+ad.RootDn(), ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=trustedDomain)", []string{"trustPartner", "trustDirection", "trustAttributes"}, nil)
+	response, err := ad.conn.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	var trusts []DomainTrust
+	for _, entry := range response.Entries {
+		direction, _ := strconv.ParseInt(entry.GetAttributeValue("trustDirection"), 10, 64)
+		attributes, _ := strconv.ParseInt(entry.GetAttributeValue("trustAttributes"), 10, 64)
+		trusts = append(trusts, DomainTrust{
+			Partner:    strings.ToLower(entry.GetAttributeValue("trustPartner")),
+			Direction:  direction,
+			Attributes: attributes,
+		})
+	}
+	return trusts, nil
+}
+
+// Kerberos rejects tickets when the clocks differ by more than this (the default in AD)
+const MaxClockSkew = 5 * time.Minute
+
+// Returns how far the local clock is ahead of the domain controller (negative if behind), and warns if it's
+// enough to break Kerberos
+func (ad *AD) CheckClockSkew() (time.Duration, error) {
+	start := time.Now()
+	servertime, err := ad.ServerTime()
+	if err != nil {
+		return 0, err
+	}
+	// Compare to the middle of the round trip, currentTime has a resolution of one second anyway
+	skew := start.Add(time.Since(start) / 2).Sub(servertime).Round(time.Second)
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		log.Warn().Msgf("Local clock is %v off from %v (%v) - Kerberos fails with more than %v, sync the clock (w32tm /resync or ntpdate %v)",
+			skew, ad.Server, servertime.Local().Format(time.RFC3339), MaxClockSkew, ad.Server)
+	}
+	return skew, nil
+}
+
 func (ad *AD) RootDn() string {
 	return "dc=" + strings.Replace(ad.Domain, ".", ",dc=", -1)
 }
 
+// Opens another connection to the same server with the same credentials, for dumping in parallel
+func (ad *AD) ConnectAgain() (*AD, error) {
+	other := &AD{
+		Domain:              ad.Domain,
+		Server:              ad.Server,
+		Port:                ad.Port,
+		User:                ad.User,
+		Password:            ad.Password,
+		AuthDomain:          ad.AuthDomain,
+		TLSMode:             ad.TLSMode,
+		IgnoreCert:          ad.IgnoreCert,
+		CCache:              ad.CCache,
+		ADWS:                ad.ADWS,
+		ReferralCredentials: ad.ReferralCredentials,
+		quiet:               ad.quiet,
+	}
+	return other, other.Connect(ad.authmode)
+}
+
+// Returns the DNs of the objects directly below dn
+func (ad *AD) Children(dn string, chunkSize int) ([]string, error) {
+	if ad.adws != nil {
+		objects, err := ad.adws.Enumerate(dn, ldap.ScopeSingleLevel, "(objectClass=*)", []string{"distinguishedName"}, 0, chunkSize, nil)
+		if err != nil {
+			return nil, err
+		}
+		children := make([]string, len(objects))
+		for i, object := range objects {
+			children[i] = object.DistinguishedName
+		}
+		return children, nil
+	}
+	request := ldap.NewSearchRequest(dn, ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"1.1"}, nil)
+	response, err := ad.conn.SearchWithPaging(request, uint32(chunkSize))
+	if err != nil {
+		return nil, err
+	}
+	children := make([]string, len(response.Entries))
+	for i, entry := range response.Entries {
+		children[i] = entry.DN
+	}
+	return children, nil
+}
+
 func (ad *AD) Dump(searchbase string, query string, attributes []string, nosacl bool, chunkSize int) ([]*RawObject, error) {
+	return ad.DumpScope(searchbase, ldap.ScopeWholeSubtree, query, attributes, nosacl, chunkSize)
+}
+
+// Dumps the objects matching the query at the scope (ldap.ScopeBaseObject, ScopeSingleLevel or ScopeWholeSubtree)
+// from searchbase
+func (ad *AD) DumpScope(searchbase string, scope int, query string, attributes []string, nosacl bool, chunkSize int) ([]*RawObject, error) {
 	bar := progressbar.NewOptions(-1,
+		progressbar.OptionSetVisibility(!ad.quiet),
 		progressbar.OptionSetDescription("Dumping from "+searchbase+" ..."),
 		progressbar.OptionShowCount(),
 		progressbar.OptionShowIts(),
@@ -120,12 +445,7 @@ func (ad *AD) Dump(searchbase string, query string, attributes []string, nosacl
 	var controls []ldap.Control
 
 	if nosacl {
-		sdcontrol := &ControlInteger{
-			ControlType:  "1.2.840.113556.1.4.801",
-			Criticality:  true,
-			ControlValue: int64(7),
-		}
-		controls = append(controls, sdcontrol)
+		controls = append(controls, noSACLControl())
 	}
 
 	if chunkSize > 0 {
@@ -137,12 +457,32 @@ func (ad *AD) Dump(searchbase string, query string, attributes []string, nosacl
 		query = "(objectClass=*)"
 	}
 
+	// Remember what we've dumped, so referrals back into it are not followed
+	if ad.referralsfollowed == nil {
+		ad.referralsfollowed = make(map[string]struct{})
+	}
+	ad.referralsfollowed[strings.ToLower(searchbase)] = struct{}{}
+
+	if ad.adws != nil {
+		var sdflags int64
+		if nosacl {
+			sdflags = 7
+		}
+		objects, err := ad.adws.Enumerate(searchbase, scope, query, attributes, sdflags, chunkSize, func() { bar.Add(1) })
+		bar.Finish()
+		if err != nil {
+			return objects, fmt.Errorf("Failed to enumerate over ADWS: %w", err)
+		}
+		return objects, nil
+	}
+
 	var objects []*RawObject
+	var referrals []string
 
 	for {
 		request := ldap.NewSearchRequest(
 			searchbase, // The base dn to search
-			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			scope, ldap.NeverDerefAliases, 0, 0, false,
 			query,      // The filter to apply
 			attributes, // A list attributes to retrieve
 			controls,
@@ -162,6 +502,7 @@ func (ad *AD) Dump(searchbase string, query string, attributes []string, nosacl
 				bar.Add(1)
 			}
 		}
+		referrals = append(referrals, response.Referrals...)
 
 		responseControl := ldap.FindControl(response.Controls, ldap.ControlTypePaging)
 		if rctrl, ok := responseControl.(*ldap.ControlPaging); rctrl != nil && ok && len(rctrl.Cookie) != 0 {
@@ -177,9 +518,141 @@ func (ad *AD) Dump(searchbase string, query string, attributes []string, nosacl
 
 	bar.Finish()
 
+	if ad.ReferralCredentials != nil {
+		for _, referral := range referrals {
+			referredobjects, err := ad.followReferral(referral, query, attributes, nosacl, chunkSize)
+			if err != nil {
+				log.Warn().Msgf("Problem following referral %v: %v", referral, err)
+				continue
+			}
+			objects = append(objects, referredobjects...)
+		}
+	} else if len(referrals) > 0 {
+		log.Info().Msgf("Ignored %v referrals from %v, use -chasereferrals to follow them", len(referrals), searchbase)
+	}
+
 	return objects, nil
 }
 
+// Asks for the parts of ntSecurityDescriptor in flags (1 owner, 2 group, 4 DACL, 8 SACL). Leaving out the SACL
+// lets normal users read it
+func sdFlagsControl(flags int64) ldap.Control {
+	return &ControlInteger{
+		ControlType:  "1.2.840.113556.1.4.801",
+		Criticality:  true,
+		ControlValue: flags,
+	}
+}
+
+func noSACLControl() ldap.Control {
+	return sdFlagsControl(7)
+}
+
+// Gets a single object, or nil if it doesn't exist
+func (ad *AD) Fetch(dn string, attributes []string, nosacl bool) (*RawObject, error) {
+	var controls []ldap.Control
+	if nosacl {
+		controls = append(controls, noSACLControl())
+	}
+	return ad.fetch(dn, attributes, controls)
+}
+
+func (ad *AD) fetch(dn string, attributes []string, controls []ldap.Control) (*RawObject, error) {
+	if ad.adws != nil {
+		var sdflags int64
+		for _, control := range controls {
+			if sdcontrol, ok := control.(*ControlInteger); ok && sdcontrol.ControlType == "1.2.840.113556.1.4.801" {
+				sdflags = sdcontrol.ControlValue
+			}
+		}
+		objects, err := ad.adws.Enumerate(dn, ldap.ScopeBaseObject, "(objectClass=*)", attributes, sdflags, 1, nil)
+		if err != nil || len(objects) != 1 {
+			return nil, err
+		}
+		return objects[0], nil
+	}
+	request := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", attributes, controls)
+	response, err := ad.conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Entries) != 1 {
+		return nil, nil
+	}
+	object := &RawObject{}
+	return object, object.IngestLDAP(response.Entries[0])
+}
+
+// Settings for connecting to another domain in the forest, with the credentials for it from ReferralCredentials if
+// there are any, or else the same ones as this
+func (ad *AD) OtherDomain(domain, server string) *AD {
+	other := &AD{
+		Domain:              domain,
+		Server:              server,
+		Port:                ad.Port,
+		User:                ad.User,
+		Password:            ad.Password,
+		AuthDomain:          ad.AuthDomain,
+		TLSMode:             ad.TLSMode,
+		IgnoreCert:          ad.IgnoreCert,
+		CCache:              ad.CCache,
+		ADWS:                ad.ADWS,
+		ReferralCredentials: ad.ReferralCredentials,
+		authmode:            ad.authmode,
+	}
+	if credential, found := ad.ReferralCredentials[domain]; found {
+		other.User = credential.User
+		if !strings.Contains(other.User, "@") {
+			other.User += "@" + domain
+		}
+		other.Password = credential.Password
+		other.AuthDomain = domain
+	}
+	return other
+}
+
+// Connects to the server in the referral, and dumps the objects from there
+func (ad *AD) followReferral(referral string, query string, attributes []string, nosacl bool, chunkSize int) ([]*RawObject, error) {
+	u, err := url.Parse(referral)
+	if err != nil {
+		return nil, err
+	}
+	searchbase, err := url.PathUnescape(strings.TrimPrefix(u.Path, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if searchbase == "" {
+		return nil, errors.New("Referral has no base DN")
+	}
+	if _, done := ad.referralsfollowed[strings.ToLower(searchbase)]; done {
+		return nil, nil
+	}
+
+	// DC=child,DC=contoso,DC=local -> child.contoso.local
+	var domainparts []string
+	for _, rdn := range strings.Split(searchbase, ",") {
+		if strings.HasPrefix(strings.ToLower(rdn), "dc=") {
+			domainparts = append(domainparts, rdn[3:])
+		}
+	}
+	domain := strings.ToLower(strings.Join(domainparts, "."))
+
+	referred := ad.OtherDomain(domain, u.Hostname())
+	referred.referralsfollowed = ad.referralsfollowed
+
+	log.Info().Msgf("Following referral to %v on %v", searchbase, referred.Server)
+	if err = referred.Connect(ad.authmode); err != nil {
+		return nil, err
+	}
+	defer referred.Disconnect()
+
+	return referred.Dump(searchbase, query, attributes, nosacl, chunkSize)
+}
+
 type ControlInteger struct {
 	ControlType  string
 	Criticality  bool
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The UI text is in html/locales/<language>.json, with English in en.json. Translations in the folder given with
+// -localepath are used over the built in ones, so a team can make and use one without building adalanche. A
+// translation only needs the strings it has, the rest are English. "_language" is the name shown in the picker
+
+const defaultLocale = "en"
+
+var LocalePath string
+
+// Language tags like "de" or "pt-br", which also keeps the name from going anywhere else on disk
+var localeCodeRE = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+
+func readLocale(assets http.FileSystem, code string) (map[string]string, error) {
+	var data []byte
+	var err error
+	if LocalePath != "" {
+		data, err = os.ReadFile(filepath.Join(LocalePath, code+".json"))
+	}
+	if LocalePath == "" || os.IsNotExist(err) {
+		var file http.File
+		if file, err = assets.Open("/locales/" + code + ".json"); err == nil {
+			data, err = io.ReadAll(file)
+			file.Close()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]string
+	return result, json.Unmarshal(data, &result)
+}
+
+// The strings for a language on top of the English ones. A region falls back to the language, so pt-br uses pt if
+// there's no pt-br. "_code" is the locale that was found
+func localeStrings(assets http.FileSystem, language string) (map[string]string, error) {
+	language = strings.ToLower(language)
+	if !localeCodeRE.MatchString(language) {
+		return nil, errors.New("invalid language " + language)
+	}
+	result, err := readLocale(assets, defaultLocale)
+	if err != nil {
+		return nil, err
+	}
+	result["_code"] = defaultLocale
+	for code := language; code != defaultLocale; {
+		if translated, err := readLocale(assets, code); err == nil {
+			for key, value := range translated {
+				result[key] = value
+			}
+			result["_code"] = code
+			break
+		}
+		cut := strings.LastIndex(code, "-")
+		if cut == -1 {
+			break
+		}
+		code = code[:cut]
+	}
+	return result, nil
+}
+
+type localeInfo struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// The locales in -localepath and the built in ones
+func availableLocales(assets http.FileSystem) []localeInfo {
+	codes := make(map[string]struct{})
+	if LocalePath != "" {
+		if files, err := os.ReadDir(LocalePath); err == nil {
+			for _, file := range files {
+				codes[strings.TrimSuffix(strings.ToLower(file.Name()), ".json")] = struct{}{}
+			}
+		}
+	}
+	if dir, err := assets.Open("/locales"); err == nil {
+		if files, err := dir.Readdir(-1); err == nil {
+			for _, file := range files {
+				codes[strings.TrimSuffix(strings.ToLower(file.Name()), ".json")] = struct{}{}
+			}
+		}
+		dir.Close()
+	}
+
+	var result []localeInfo
+	for code := range codes {
+		if !localeCodeRE.MatchString(code) {
+			continue
+		}
+		if translated, err := readLocale(assets, code); err == nil {
+			name := translated["_language"]
+			if name == "" {
+				name = code
+			}
+			result = append(result, localeInfo{Code: code, Name: name})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Code < result[j].Code
+	})
+	return result
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// LocalMachine is data collected on a machine itself, that you can't get from LDAP. It is loaded from
+// <datapath>/*.localmachine.json files, one per machine
+type LocalMachine struct {
+	Name        string         `json:"name"` // NetBIOS or DNS name
+	Shares      []LocalShare   `json:"shares,omitempty"`
+	Services    []LocalService `json:"services,omitempty"`
+	LocalAdmins []string       `json:"localadmins,omitempty"` // SIDs of the members of the local Administrators group
+	RDPUsers    []string       `json:"rdpusers,omitempty"`    // SIDs of the members of the local Remote Desktop Users group
+	DCOMUsers   []string       `json:"dcomusers,omitempty"`   // SIDs of the members of the local Distributed COM Users group
+	Sessions    []LocalSession `json:"sessions,omitempty"`
+	SCCM        *LocalSCCM     `json:"sccm,omitempty"`
+	Collected   time.Time      `json:"collected,omitempty"` // When the data was collected
+}
+
+type LocalShare struct {
+	Name                    string `json:"name"`
+	Path                    string `json:"path"`
+	ShareSecurityDescriptor []byte `json:"sharesecuritydescriptor,omitempty"` // Share permissions, self relative and base64 encoded
+	SecurityDescriptor      []byte `json:"securitydescriptor,omitempty"`      // NTFS permissions on the shared folder, self relative and base64 encoded
+}
+
+type LocalService struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayname,omitempty"`
+	Account     string `json:"account"` // Log on as, like DOMAIN\user, user@domain or LocalSystem
+	StartMode   string `json:"startmode,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+// Configuration Manager (SCCM) client and site server settings
+type LocalSCCM struct {
+	SiteCode           string   `json:"sitecode"`                     // Site the client is assigned to, or the site of the site server
+	ManagementPoint    string   `json:"managementpoint,omitempty"`    // Current management point of the client
+	SiteServer         bool     `json:"siteserver,omitempty"`         // Machine is the site server
+	ClientPushAccounts []string `json:"clientpushaccounts,omitempty"` // Client push installation accounts, on the site server
+}
+
+type LocalSession struct {
+	User      string    `json:"user"`                // SID, DOMAIN\user or user@domain
+	LogonType string    `json:"logontype,omitempty"` // Interactive, RemoteInteractive, Network, Batch, Service ...
+	Seen      time.Time `json:"seen,omitempty"`      // When the session was seen, defaults to when the machine data was collected
+}
+
+// Network logons don't leave reusable credentials in LSASS
+func (ls LocalSession) ExposesCredentials() bool {
+	return !strings.EqualFold(ls.LogonType, "Network")
+}
+
+// Collected machines, lowercased name -> machine
+var AllMachines = make(map[string]*LocalMachine)
+
+func LoadLocalMachines(datapath string) error {
+	files, err := filepath.Glob(filepath.Join(datapath, "*.localmachine.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var machine LocalMachine
+		if err = qjson.Unmarshal(data, &machine); err != nil {
+			log.Warn().Msgf("Problem loading local machine data from %v: %v", file, err)
+			continue
+		}
+		AllMachines[strings.ToLower(machine.Name)] = &machine
+		if _, found := AllObjects.FindComputer(machine.Name); !found {
+			log.Warn().Msgf("Local machine data for %v does not match any computer in the directory", machine.Name)
+		}
+	}
+	if len(files) > 0 {
+		log.Info().Msgf("Loaded data from %v local machines", len(AllMachines))
+	}
+	return nil
+}
+
+func FindLocalMachine(name string) (*LocalMachine, bool) {
+	name = strings.ToLower(name)
+	if machine, found := AllMachines[name]; found {
+		return machine, true
+	}
+	// Try short name if we got a DNS name or the other way around
+	if computer, found := AllObjects.FindComputer(name); found {
+		for _, alias := range []string{strings.TrimSuffix(computer.OneAttr(SAMAccountName), "$"), computer.OneAttr(DNSHostName)} {
+			if machine, found := AllMachines[strings.ToLower(alias)]; found {
+				return machine, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Returns the collected data for a computer object
+func localMachineOf(o *Object) (*LocalMachine, bool) {
+	if o.Type() != ObjectTypeComputer {
+		return nil, false
+	}
+	if machine, found := FindLocalMachine(o.OneAttr(DNSHostName)); found {
+		return machine, true
+	}
+	return FindLocalMachine(strings.TrimSuffix(o.OneAttr(SAMAccountName), "$"))
+}
+
+func (lm *LocalMachine) Share(name string) (*LocalShare, bool) {
+	for i, share := range lm.Shares {
+		if strings.EqualFold(share.Name, name) {
+			return &lm.Shares[i], true
+		}
+	}
+	return nil, false
+}
+
+const (
+	FILE_WRITE_DATA       = 0x00000002
+	FILE_ADD_SUBDIRECTORY = 0x00000004
+	FILE_WRITE_ACCESS     = FILE_WRITE_DATA | FILE_ADD_SUBDIRECTORY | RIGHT_GENERIC_WRITE | RIGHT_GENERIC_ALL | RIGHT_WRITE_DACL | RIGHT_WRITE_OWNER
+)
+
+var broadSIDs = []string{
+	"S-1-1-0",      // Everyone
+	"S-1-5-11",     // Authenticated Users
+	"S-1-5-32-545", // Users
+}
+
+// Returns SIDs with allow ACEs granting write access in the security descriptor, or nil if it could not be parsed
+func writersOf(rawsd []byte) map[SID]struct{} {
+	sd, err := ParseSecurityDescriptor(rawsd)
+	if err != nil {
+		return nil
+	}
+	writers := make(map[SID]struct{})
+	for _, ace := range sd.DACL.Entries {
+		if ace.Type == ACETYPE_ACCESS_ALLOWED && ace.Mask&FILE_WRITE_ACCESS != 0 {
+			writers[ace.SID] = struct{}{}
+		}
+	}
+	return writers
+}
+
+// Writers returns the SIDs that can write to the share through both share and NTFS permissions. Deny entries and
+// group nesting between the two lists are not evaluated, so this is an approximation
+func (ls LocalShare) Writers() []SID {
+	ntfswriters := writersOf(ls.SecurityDescriptor)
+	if ntfswriters == nil {
+		return nil
+	}
+	sharewriters := writersOf(ls.ShareSecurityDescriptor)
+	var sharewideopen bool
+	if sharewriters == nil {
+		sharewideopen = true // no share permissions collected, so only NTFS counts
+	} else {
+		for _, broadsid := range broadSIDs {
+			sid, _ := SIDFromString(broadsid)
+			if _, found := sharewriters[sid]; found {
+				sharewideopen = true
+			}
+		}
+	}
+
+	var result []SID
+	for sid := range ntfswriters {
+		if _, found := sharewriters[sid]; sharewideopen || found {
+			result = append(result, sid)
+		}
+	}
+	return result
+}
+
+// Returns true if one of the broad groups like Everyone can write to the share
+func (ls LocalShare) WritableByEveryone() bool {
+	for _, writer := range ls.Writers() {
+		for _, broadsid := range broadSIDs {
+			if writer.ToString() == broadsid {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Splits \\server\share\some\path into server and share
+func parseUNC(path string) (server, share string, ok bool) {
+	if !strings.HasPrefix(path, `\\`) {
+		return "", "", false
+	}
+	parts := strings.SplitN(path[2:], `\`, 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// A UNC path a user loads or runs files from, and where it's set
+type userSharePath struct {
+	Path   string
+	Source string
+}
+
+// Returns the UNC paths for profiles and home directories of a user, which are loaded or executed at logon, and the
+// drives and printers mapped for the user by Group Policy Preferences in the GPOs that apply to it
+func userSharePaths(o *Object) []userSharePath {
+	var paths []userSharePath
+	add := func(path, source string) {
+		if _, _, ok := parseUNC(path); !ok {
+			return
+		}
+		for _, existing := range paths {
+			if strings.EqualFold(existing.Path, path) {
+				return
+			}
+		}
+		paths = append(paths, userSharePath{Path: path, Source: source})
+	}
+	for _, attribute := range []Attribute{ProfilePath, HomeDirectory, MSTSProfilePath, MSTSHomeDirectory} {
+		add(o.OneAttr(attribute), attribute.String())
+	}
+	for _, link := range gpoAppliedLinks(o) {
+		for _, path := range link.GPO.Attr(MetaGPPSharePaths) {
+			add(path, "mapped by GPO "+link.GPO.Label())
+		}
+	}
+	return paths
+}
+
+// Returns the directory account or group from a SID, DOMAIN\user or user@domain, or false for built in accounts and
+// local users
+func resolveAccount(account string) (*Object, bool) {
+	account = strings.TrimSpace(account)
+	if strings.HasPrefix(account, "S-1-") {
+		if sid, err := SIDFromString(account); err == nil {
+			return AllObjects.FindSID(sid)
+		}
+		return nil, false
+	}
+	lower := strings.ToLower(account)
+	if lower == "" || lower == "localsystem" || strings.HasPrefix(lower, `.\`) ||
+		strings.HasPrefix(lower, `nt authority\`) || strings.HasPrefix(lower, `nt service\`) {
+		return nil, false
+	}
+
+	var domain, name string
+	var upn bool
+	if backslash := strings.Index(account, `\`); backslash != -1 {
+		domain, name = account[:backslash], account[backslash+1:]
+	} else if at := strings.Index(account, "@"); at != -1 {
+		upn = true
+		name, domain = account, account[at+1:]
+	} else {
+		name = account
+	}
+
+	var candidates []*Object
+	for _, o := range AllObjects.AsArray() {
+		switch o.Type() {
+		case ObjectTypeUser, ObjectTypeManagedServiceAccount, ObjectTypeComputer, ObjectTypeGroup:
+		default:
+			continue
+		}
+		if (upn && strings.EqualFold(o.OneAttr(UserPrincipalName), name)) || (!upn && strings.EqualFold(o.OneAttr(SAMAccountName), name)) {
+			candidates = append(candidates, o)
+		}
+	}
+	if len(candidates) > 1 && domain != "" {
+		// Same name in several domains, use the one where the first part of the domain matches (NetBIOS names usually do)
+		domain = strings.ToLower(strings.SplitN(domain, ".", 2)[0])
+		var matching []*Object
+		for _, candidate := range candidates {
+			dn := strings.ToLower(candidate.DN())
+			if start := strings.Index(dn, ",dc="); start != -1 && strings.HasPrefix(dn[start+4:], domain) {
+				matching = append(matching, candidate)
+			}
+		}
+		candidates = matching
+	}
+	if len(candidates) != 1 {
+		return nil, false
+	}
+	return candidates[0], true
+}
+
+type serviceHost struct {
+	Computer *Object
+	Machine  *LocalMachine
+	Services []string
+}
+
+// Service account -> where it runs services
+var serviceHosts map[*Object][]serviceHost
+
+func buildServiceHosts() {
+	serviceHosts = make(map[*Object][]serviceHost)
+	for _, machine := range AllMachines {
+		computer, found := AllObjects.FindComputer(machine.Name)
+		if !found {
+			continue
+		}
+		hosts := make(map[*Object]*serviceHost)
+		for _, service := range machine.Services {
+			account, found := resolveAccount(service.Account)
+			if !found || account == computer {
+				continue
+			}
+			if hosts[account] == nil {
+				hosts[account] = &serviceHost{Computer: computer, Machine: machine}
+			}
+			hosts[account].Services = append(hosts[account].Services, service.Name)
+		}
+		for account, host := range hosts {
+			serviceHosts[account] = append(serviceHosts[account], *host)
+		}
+	}
+}
+
+// Returns the accounts running services on the computer, which get code execution there
+func serviceAccountsOn(computer *Object) []*Object {
+	var results []*Object
+	for account, hosts := range serviceHosts {
+		for _, host := range hosts {
+			if host.Computer == computer {
+				SetEdgeReason(account, computer, PwnRunsServicesOn, "logon account for "+strings.Join(host.Services, ", "))
+				results = append(results, account)
+			}
+		}
+	}
+	return results
+}
+
+// Returns the principals that can dump the credentials of a service account from the LSA secrets on the machines
+// where it runs services: the computers themselves, and the members of their local Administrators group
+func serviceCredentialDumpers(account *Object) []*Object {
+	var results []*Object
+	for _, host := range serviceHosts[account] {
+		reason := "password is stored for " + strings.Join(host.Services, ", ") + " on " + host.Computer.OneAttr(Name)
+		SetEdgeReason(host.Computer, account, PwnCanDumpCredsOf, reason)
+		results = append(results, host.Computer)
+		for _, admin := range localAdminsOf(host.Machine) {
+			SetEdgeReason(admin, account, PwnCanDumpCredsOf, reason+", as local administrator")
+			results = append(results, admin)
+		}
+	}
+	return results
+}
+
+// Returns the directory principals in the local Administrators group of the machine. Local accounts and
+// built in groups can't be resolved and are left out
+func localAdminsOf(machine *LocalMachine) []*Object {
+	return localGroupMembers(machine.LocalAdmins)
+}
+
+// Resolves the SIDs of the members of a local group to directory principals
+func localGroupMembers(sids []string) []*Object {
+	var results []*Object
+	for _, sidstring := range sids {
+		sid, err := SIDFromString(sidstring)
+		if err != nil {
+			continue
+		}
+		if admin, found := AllObjects.FindSID(sid); found {
+			results = append(results, admin)
+		}
+	}
+	return results
+}
+
+type sessionHost struct {
+	Computer   *Object
+	Machine    *LocalMachine
+	LogonTypes []string
+	Seen       time.Time // Most recent session
+}
+
+// User -> where it's logged on with credentials left in memory
+var sessionHosts map[*Object][]sessionHost
+
+func buildSessionHosts() {
+	sessionHosts = make(map[*Object][]sessionHost)
+	for _, machine := range AllMachines {
+		computer, found := AllObjects.FindComputer(machine.Name)
+		if !found {
+			continue
+		}
+		hosts := make(map[*Object]*sessionHost)
+		for _, session := range machine.Sessions {
+			if !session.ExposesCredentials() {
+				continue
+			}
+			user, found := resolveAccount(session.User)
+			if !found || user == computer {
+				continue
+			}
+			if hosts[user] == nil {
+				hosts[user] = &sessionHost{Computer: computer, Machine: machine}
+			}
+			logontype := session.LogonType
+			if logontype == "" {
+				logontype = "unknown logon type"
+			}
+			if !StringInSlice(logontype, hosts[user].LogonTypes) {
+				hosts[user].LogonTypes = append(hosts[user].LogonTypes, logontype)
+			}
+			seen := session.Seen
+			if seen.IsZero() {
+				seen = machine.Collected
+			}
+			if seen.After(hosts[user].Seen) {
+				hosts[user].Seen = seen
+			}
+		}
+		for user, host := range hosts {
+			sessionHosts[user] = append(sessionHosts[user], *host)
+		}
+	}
+}
+
+// Returns the principals that can steal credentials of the user from LSASS on the machines it's logged on to:
+// the computers themselves, and the members of their local Administrators group
+func sessionCredentialStealers(user *Object) []*Object {
+	var results []*Object
+	for _, host := range sessionHosts[user] {
+		reason := "logged on to " + host.Computer.OneAttr(Name) + " (" + strings.Join(host.LogonTypes, ", ") + ")"
+		if user.OneAttr(MetaProtectedUser) == "1" {
+			reason += ", member of Protected Users so only Kerberos tickets are exposed"
+		}
+		AddEdgeReason(host.Computer, user, PwnCanStealCredentialsOf, reason)
+		SetEdgeCollected(host.Computer, user, PwnCanStealCredentialsOf, host.Seen)
+		results = append(results, host.Computer)
+		for _, admin := range localAdminsOf(host.Machine) {
+			if admin == user {
+				continue
+			}
+			AddEdgeReason(admin, user, PwnCanStealCredentialsOf, reason+", as local administrator")
+			SetEdgeCollected(admin, user, PwnCanStealCredentialsOf, host.Seen)
+			results = append(results, admin)
+		}
+	}
+	return results
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Collects sessions, the members of the local Administrators, Remote Desktop Users and Distributed COM Users groups
+// and the services from Windows machines into .localmachine.json files, like the computer collection of SharpHound.
+// It uses the Windows APIs as the current user, so it runs on Windows, and everything but the network sessions
+// needs local admin on the machines. Without a list of machines it collects from the one it runs on, so it can be
+// deployed as a scheduled task writing to a share
+
+// Machines collected from at the same time
+const machineCollectorThreads = 16
+
+// A user connected to a share on the machine, from NetSessionEnum. The user is logged on to the client, not the
+// machine the session is on
+type networkSession struct {
+	Client string
+	User   string
+}
+
+// Reads the machines to collect from: comma separated, or @file with one per line
+func parseMachineList(machines string) ([]string, error) {
+	if strings.HasPrefix(machines, "@") {
+		data, err := os.ReadFile(machines[1:])
+		if err != nil {
+			return nil, err
+		}
+		machines = strings.ReplaceAll(string(data), "\n", ",")
+	}
+	var results []string
+	for _, machine := range strings.Split(machines, ",") {
+		if machine = strings.TrimSpace(machine); machine != "" {
+			results = append(results, machine)
+		}
+	}
+	return results, nil
+}
+
+// Returns the name of the client of a network session, which is \\name or \\address
+func networkSessionClient(client string) string {
+	client = strings.TrimPrefix(client, `\\`)
+	if net.ParseIP(client) == nil {
+		return strings.ToLower(client)
+	}
+	if names, err := net.LookupAddr(client); err == nil && len(names) > 0 {
+		return strings.ToLower(strings.TrimSuffix(names[0], "."))
+	}
+	return ""
+}
+
+func CollectMachines(machines, datapath string) error {
+	targets, err := parseMachineList(machines)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		// This machine
+		targets = []string{""}
+	}
+	if err = os.MkdirAll(datapath, 0700); err != nil {
+		return err
+	}
+
+	var lock sync.Mutex
+	collected := make(map[string]*LocalMachine)
+	var clientsessions []networkSession
+	var failed int
+
+	work := make(chan string)
+	var workers sync.WaitGroup
+	for i := 0; i < machineCollectorThreads; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for target := range work {
+				machine, sessions, err := collectMachine(target)
+				lock.Lock()
+				if err != nil {
+					log.Warn().Msgf("Problem collecting from %v: %v", Default(target, "this machine"), err)
+					failed++
+				} else {
+					collected[strings.ToLower(machine.Name)] = machine
+					clientsessions = append(clientsessions, sessions...)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, target := range targets {
+		work <- target
+	}
+	close(work)
+	workers.Wait()
+
+	// Network sessions are logons on the client they come from
+	clients := make(map[string]*LocalMachine)
+	for _, session := range clientsessions {
+		client := networkSessionClient(session.Client)
+		if client == "" {
+			continue
+		}
+		machine := collected[client]
+		if machine == nil {
+			machine = clients[client]
+		}
+		if machine == nil {
+			machine = &LocalMachine{Name: client, Collected: time.Now()}
+			// Don't lose what was collected on the client before
+			if data, err := os.ReadFile(filepath.Join(datapath, client+".localmachine.json")); err == nil {
+				qjson.Unmarshal(data, machine)
+			}
+			clients[client] = machine
+		}
+		machine.Sessions = append(machine.Sessions, LocalSession{User: session.User, Seen: time.Now()})
+	}
+	for name, machine := range clients {
+		collected[name] = machine
+	}
+
+	for _, machine := range collected {
+		data, err := qjson.MarshalIndent(machine, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(datapath, machine.Name+".localmachine.json"), data, 0600); err != nil {
+			return err
+		}
+	}
+	log.Info().Msgf("Collected from %v machines (%v failed), and sessions on %v clients, into %v", len(targets)-failed, failed, len(clients), datapath)
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "errors"
+
+func collectMachine(target string) (*LocalMachine, []networkSession, error) {
+	return nil, nil, errors.New("Collecting from machines uses the Windows APIs, so it only works on Windows")
+}
@@ -0,0 +1,224 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+var (
+	netapi32                    = windows.NewLazySystemDLL("netapi32.dll")
+	procNetSessionEnum          = netapi32.NewProc("NetSessionEnum")
+	procNetWkstaUserEnum        = netapi32.NewProc("NetWkstaUserEnum")
+	procNetLocalGroupGetMembers = netapi32.NewProc("NetLocalGroupGetMembers")
+)
+
+const MAX_PREFERRED_LENGTH = 0xFFFFFFFF
+
+type SESSION_INFO_10 struct {
+	ClientName *uint16
+	UserName   *uint16
+	Time       uint32
+	IdleTime   uint32
+}
+
+type WKSTA_USER_INFO_1 struct {
+	UserName     *uint16
+	LogonDomain  *uint16
+	OtherDomains *uint16
+	LogonServer  *uint16
+}
+
+type LOCALGROUP_MEMBERS_INFO_0 struct {
+	SID *windows.SID
+}
+
+var serviceStartModes = map[uint32]string{
+	windows.SERVICE_BOOT_START:   "Boot",
+	windows.SERVICE_SYSTEM_START: "System",
+	mgr.StartAutomatic:           "Auto",
+	mgr.StartManual:              "Manual",
+	mgr.StartDisabled:            "Disabled",
+}
+
+// Calls one of the NetXxxEnum functions until it has returned everything, and each for every entry of the given size
+func netEnum(call func(buffer **byte, entriesread, totalentries, resume *uint32) uintptr, size uintptr, each func(entry unsafe.Pointer)) error {
+	var resume uint32
+	for {
+		var buffer *byte
+		var entriesread, totalentries uint32
+		status := syscall.Errno(call(&buffer, &entriesread, &totalentries, &resume))
+		if status != 0 && status != windows.ERROR_MORE_DATA {
+			return status
+		}
+		for i := uintptr(0); i < uintptr(entriesread); i++ {
+			each(unsafe.Pointer(uintptr(unsafe.Pointer(buffer)) + i*size))
+		}
+		if buffer != nil {
+			windows.NetApiBufferFree(buffer)
+		}
+		if status == 0 {
+			return nil
+		}
+	}
+}
+
+func collectMachine(target string) (*LocalMachine, []networkSession, error) {
+	name := strings.ToLower(target)
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, nil, err
+		}
+		name = strings.ToLower(hostname)
+	}
+	var server *uint16
+	if target != "" {
+		var err error
+		if server, err = windows.UTF16PtrFromString(target); err != nil {
+			return nil, nil, err
+		}
+	}
+	netbiosname := strings.SplitN(name, ".", 2)[0]
+	machine := &LocalMachine{Name: name, Collected: time.Now()}
+
+	// Everything can fail on its own (missing rights, services not running), it's only a problem if nothing worked
+	var collected bool
+	var lasterr error
+	result := func(what string, err error) {
+		if err != nil {
+			log.Debug().Msgf("Could not collect %v from %v: %v", what, name, err)
+			lasterr = err
+			return
+		}
+		collected = true
+	}
+
+	seen := make(map[string]struct{})
+	addSession := func(user string) {
+		if _, found := seen[strings.ToLower(user)]; found {
+			return
+		}
+		seen[strings.ToLower(user)] = struct{}{}
+		machine.Sessions = append(machine.Sessions, LocalSession{User: user, LogonType: "Interactive"})
+	}
+
+	// Users logged on to the machine, including services and scheduled tasks
+	result("logged on users", netEnum(func(buffer **byte, entriesread, totalentries, resume *uint32) uintptr {
+		status, _, _ := procNetWkstaUserEnum.Call(uintptr(unsafe.Pointer(server)), 1, uintptr(unsafe.Pointer(buffer)), MAX_PREFERRED_LENGTH,
+			uintptr(unsafe.Pointer(entriesread)), uintptr(unsafe.Pointer(totalentries)), uintptr(unsafe.Pointer(resume)))
+		return status
+	}, unsafe.Sizeof(WKSTA_USER_INFO_1{}), func(entry unsafe.Pointer) {
+		info := (*WKSTA_USER_INFO_1)(entry)
+		user, domain := windows.UTF16PtrToString(info.UserName), windows.UTF16PtrToString(info.LogonDomain)
+		// Computer account and local users
+		if user == "" || strings.HasSuffix(user, "$") || strings.EqualFold(domain, netbiosname) {
+			return
+		}
+		addSession(domain + `\` + user)
+	}))
+
+	// Loaded user profiles, over the Remote Registry service
+	users, err := registry.OpenRemoteKey(target, registry.USERS)
+	if err == nil {
+		var sids []string
+		sids, err = users.ReadSubKeyNames(-1)
+		users.Close()
+		for _, sid := range sids {
+			if strings.HasPrefix(sid, "S-1-5-21-") && !strings.HasSuffix(sid, "_Classes") {
+				addSession(sid)
+			}
+		}
+	}
+	result("loaded user profiles", err)
+
+	// Users connected to shares on the machine, which are logged on to the client they come from
+	var sessions []networkSession
+	self := os.Getenv("USERNAME")
+	result("network sessions", netEnum(func(buffer **byte, entriesread, totalentries, resume *uint32) uintptr {
+		status, _, _ := procNetSessionEnum.Call(uintptr(unsafe.Pointer(server)), 0, 0, 10, uintptr(unsafe.Pointer(buffer)), MAX_PREFERRED_LENGTH,
+			uintptr(unsafe.Pointer(entriesread)), uintptr(unsafe.Pointer(totalentries)), uintptr(unsafe.Pointer(resume)))
+		return status
+	}, unsafe.Sizeof(SESSION_INFO_10{}), func(entry unsafe.Pointer) {
+		info := (*SESSION_INFO_10)(entry)
+		user := windows.UTF16PtrToString(info.UserName)
+		// Computer accounts, anonymous and our own session from collecting
+		if user == "" || strings.HasSuffix(user, "$") || strings.EqualFold(user, self) {
+			return
+		}
+		sessions = append(sessions, networkSession{Client: windows.UTF16PtrToString(info.ClientName), User: user})
+	}))
+
+	// Local groups have localized names, so they're looked up by their well known SIDs
+	for _, group := range []struct {
+		sid     string
+		members *[]string
+	}{
+		{"S-1-5-32-544", &machine.LocalAdmins},
+		{"S-1-5-32-555", &machine.RDPUsers},
+		{"S-1-5-32-562", &machine.DCOMUsers},
+	} {
+		sid, err := windows.StringToSid(group.sid)
+		if err != nil {
+			return nil, nil, err
+		}
+		groupname, _, _, err := sid.LookupAccount(target)
+		if err != nil {
+			result("local group "+group.sid, err)
+			continue
+		}
+		groupnameptr, err := windows.UTF16PtrFromString(groupname)
+		if err != nil {
+			return nil, nil, err
+		}
+		result("local group "+groupname, netEnum(func(buffer **byte, entriesread, totalentries, resume *uint32) uintptr {
+			status, _, _ := procNetLocalGroupGetMembers.Call(uintptr(unsafe.Pointer(server)), uintptr(unsafe.Pointer(groupnameptr)), 0, uintptr(unsafe.Pointer(buffer)), MAX_PREFERRED_LENGTH,
+				uintptr(unsafe.Pointer(entriesread)), uintptr(unsafe.Pointer(totalentries)), uintptr(unsafe.Pointer(resume)))
+			return status
+		}, unsafe.Sizeof(LOCALGROUP_MEMBERS_INFO_0{}), func(entry unsafe.Pointer) {
+			*group.members = append(*group.members, (*LOCALGROUP_MEMBERS_INFO_0)(entry).SID.String())
+		}))
+	}
+
+	// Services and the accounts they log on as
+	manager, err := mgr.ConnectRemote(target)
+	if err == nil {
+		var services []string
+		services, err = manager.ListServices()
+		for _, servicename := range services {
+			service, err := manager.OpenService(servicename)
+			if err != nil {
+				continue
+			}
+			config, err := service.Config()
+			service.Close()
+			if err != nil {
+				continue
+			}
+			machine.Services = append(machine.Services, LocalService{
+				Name:        servicename,
+				DisplayName: config.DisplayName,
+				Account:     config.ServiceStartName,
+				StartMode:   serviceStartModes[config.StartType],
+				Path:        config.BinaryPathName,
+			})
+		}
+		manager.Disconnect()
+	}
+	result("services", err)
+
+	if !collected {
+		return nil, nil, lasterr
+	}
+	return machine, sessions, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// By default any user can add 10 computer accounts to the domain (ms-DS-MachineAccountQuota on the domain object),
+// without any Create Computer objects rights, and knows the password of them. A computer account with a known password
+// is what resource based constrained delegation and several relay attacks need, and it's in Domain Computers with what
+// that group can do. That's shown as a computer object that doesn't exist yet, which Authenticated Users can create
+
+// Synthetic computer -> the domain whose quota lets it be created
+var machineAccountQuotaNodes map[*Object]*Object
+
+// Adds a synthetic computer to the domains that let users create computer accounts. It's in Domain Computers by the
+// primary group, so it's added before the memberships are worked out
+func addMachineAccountQuotaNodes() {
+	machineAccountQuotaNodes = make(map[*Object]*Object)
+	for _, domain := range AllObjects.AsArray() {
+		if !StringInSlice("domainDNS", domain.Attr(ObjectClass)) || len(domain.SID()) == 0 {
+			continue
+		}
+		quota, ok := domain.AttrInt(MSDSMachineAccountQuota)
+		if !ok || quota <= 0 {
+			continue
+		}
+		// RID 0 isn't used for accounts, so it doesn't clash with anything real
+		node := &Object{
+			DistinguishedName: "CN=MachineAccountQuota,CN=synthetic," + domain.DN(),
+			Attributes: map[Attribute][]string{
+				Name:               {"New computer account (ms-DS-MachineAccountQuota)"},
+				Description:        {"Synthetic computer that any user in the domain can create, up to " + strconv.FormatInt(quota, 10) + " of them"},
+				ObjectCategory:     {"CN=Computer,CN=Schema,CN=Configuration," + AllObjects.Base},
+				ObjectClass:        {"top", "person", "organizationalPerson", "user", "computer"},
+				ObjectSid:          {string(domain.SID().AddSubAuthority(0))},
+				PrimaryGroupID:     {"515"},
+				UserAccountControl: {strconv.Itoa(UAC_WORKSTATION_TRUST_ACCOUNT)},
+			},
+		}
+		AllObjects.Add(node)
+		machineAccountQuotaNodes[node] = domain
+		log.Debug().Msgf("Users can create %v computer accounts in %v", quota, domain.DN())
+	}
+}
+
+// Returns Authenticated Users if the object is the synthetic computer for a domain
+func machineAccountCreators(o *Object) []*Object {
+	domain, found := machineAccountQuotaNodes[o]
+	if !found {
+		return nil
+	}
+	authenticatedusers := AllObjects.FindOrAddSID(AuthenticatedUsersSID)
+	SetEdgeReason(authenticatedusers, o, PwnCreateComputer, "ms-DS-MachineAccountQuota is "+domain.OneAttr(MSDSMachineAccountQuota)+" on "+domain.Label()+", so any user can add computer accounts with a password they choose (PowerMad, impacket addcomputer.py)")
+	return []*Object{authenticatedusers}
+}
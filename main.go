@@ -3,25 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
-	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/Showmax/go-fqdn"
-	"github.com/gofrs/uuid"
 	jsoniter "github.com/json-iterator/go"
-	"github.com/pierrec/lz4"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/schollz/progressbar/v3"
 	"github.com/tinylib/msgp/msgp"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -48,7 +40,28 @@ func showUsage() {
 	log.Info().Msg(`  dump - to dump an AD into a compressed file`)
 	log.Info().Msg(`  analyze - launches embedded webservice`)
 	log.Info().Msg(`  dump-analyze - dumps an AD and launches embedded webservice`)
+	log.Info().Msg(`  warmstart - load and analyze the data and save it for -warmstart, so analyze starts from it (used for reloads by -monitor)`)
 	log.Info().Msg(`  export - save analysis to graph files`)
+	log.Info().Msg(`  report - print findings from all reports, or just the one given with -report`)
+	log.Info().Msg(`  export-detections - write Sigma rules watching the DCSync, delegation and shadow credential paths found in the data`)
+	log.Info().Msg(`  collect-idp - collect users, groups, apps and admin roles from Okta or a SCIM service`)
+	log.Info().Msg(`  collect-aws - collect permission sets and account assignments from AWS IAM Identity Center`)
+	log.Info().Msg(`  collect-servicenow - collect environment, owner and applications of computers from the ServiceNow CMDB`)
+	log.Info().Msg(`  collect-entra - collect users, groups, service principals, roles and devices from Entra ID with Microsoft Graph`)
+	log.Info().Msg(`  collect-sysvol - copy the Group Policy files from SYSVOL on a domain controller over SMB, for the settings that aren't in LDAP`)
+	log.Info().Msg(`  upload - send the data files to the webservice on another machine, which loads them`)
+	log.Info().Msg(`  collect-machines - collect sessions, local group members and services from Windows machines (run on Windows)`)
+	log.Info().Msg(`  verify - compare random objects from the dump with the directory, to find out if data was lost`)
+	log.Info().Msg(`  diagnose - test connecting to the domain controllers in every way, and explain why the failing ones fail`)
+	log.Info().Msg(`  setup - find a working way to connect to the domain and save it to the config file`)
+	log.Info().Msg(`  selftest - run the analyzers on the bundled test corpora, or the corpus files and folders given after the command`)
+	log.Info().Msg(`  convert - turn a dump into newline delimited JSON for jq and other tools, or back with -convertto dump, or into SQLite with -convertto sqlite`)
+	log.Info().Msg(`  generate-demo - write a dump of a made up domain with weaknesses in it (-domain, default demo.local), for demos and testing`)
+	log.Info().Msg(`Add -demo to analyze, report or export to use a made up domain instead of collected data, without writing anything`)
+	log.Info().Msg(`Dump profiles (-profile):`)
+	for _, profile := range DumpProfiles {
+		log.Info().Msgf("  %v - %v", profile.Name, profile.Description)
+	}
 	log.Info().Msg(`Options:`)
 
 	flag.CommandLine.VisitAll(func(f *flag.Flag) {
@@ -76,6 +89,8 @@ func showUsage() {
 }
 
 func main() {
+	defer CrashHandler()
+
 	server := flag.String("server", "", "DC to connect to, use IP or full hostname ex. -dc=\"dc.contoso.local\", random DC is auto-detected if not supplied")
 	port := flag.Int("port", 636, "LDAP port to connect to (389 or 636 typical)")
 	domain := flag.String("domain", "", "domain suffix to analyze (auto-detected if not supplied)")
@@ -86,35 +101,186 @@ func main() {
 
 	ignoreCert := flag.Bool("ignorecert", true, "Disable certificate checks")
 
-	authmodeString := flag.String("authmode", "ntlmsspi", "Bind mode: unauth, simple, md5, ntlm, ntlmpth (password is hash), ntlmsspi (current user, default)")
+	defaultauthmode := "ntlmsspi"
 	if runtime.GOOS != "windows" {
 		// change default for non windows platofrms
-		authmodeString = flag.String("authmode", "ntlm", "Bind mode: unauth, simple, md5, ntlm, ntlmpth (password is hash), ntlmsspi (current user, default)")
+		defaultauthmode = "ntlm"
 	}
+	authmodeString := flag.String("authmode", defaultauthmode, "Bind mode: unauth, simple, md5, ntlm, ntlmpth (password is hash), ntlmsspi (current user, windows only), gssapi (Kerberos ticket from -ccache)")
+	ccache := flag.String("ccache", "", "Kerberos credential cache file for -authmode gssapi, blank means KRB5CCNAME")
 
+	proxyurl := flag.String("proxy", "", "SOCKS5 proxy for connections to the domain controllers and DNS lookups, like socks5://127.0.0.1:1080 (ssh -D or chisel)")
 	authdomain := flag.String("authdomain", "", "domain for authentication, if using ntlm auth")
 
 	datapath := flag.String("datapath", "data", "folder to store cached ldap data")
 	dumpquery := flag.String("dumpquery", "(objectClass=*)", "LDAP query for dump, defaults to everything")
 	analyzequery := flag.String("analyzequery", "(&(objectClass=group)(|(name=Domain Admins)(name=Enterprise Admins)))", "LDAP query to locate targets for analysis")
 	importall := flag.Bool("importall", false, "Load all attributes from dump (expands search options, but at the cost of memory")
+	keepattributes := flag.String("keepattributes", "", "Comma separated list of attributes to load even though they're left out by default for taking up memory without being used in analysis (thumbnailPhoto, jpegPhoto, photo, thumbnailLogo, audio, msExchUMSpokenName, userSMIMECertificate, msDS-ReplAttributeMetaData, msDS-ReplValueMetaData), or * for all of them")
+	crashreports := flag.Bool("crashreports", false, "Write a report to the data folder when something crashes, with the stack trace and the attribute names and value lengths of the object it was working on, but no values")
+	crashreporturl := flag.String("crashreporturl", "", "Also send crash reports to this URL, as JSON in a POST request (needs -crashreports)")
+	warmstart := flag.Bool("warmstart", false, "Save everything after loading and analyzing to a file in the data folder, and start from that the next time if the data and settings haven't changed")
+	nocompress := flag.Bool("nocompress", false, "Keep raw security descriptors and certificates uncompressed in memory, using more memory to save a little CPU when they're shown")
 	exportinverted := flag.Bool("exportinverted", false, "Invert analysis, discover how much damage targets can do")
-	exporttype := flag.String("exporttype", "cytoscapejs", "Graph type to export (cytoscapejs, graphviz)")
+	exporttype := flag.String("exporttype", "cytoscapejs", "Graph type to export (cytoscapejs, graphviz, mermaid, drawio, parquet, opengraph, static)")
+	exportqueries := flag.String("exportqueries", "", "File with queries for static export, one per line as: title<TAB>query (defaults to analyzequery)")
+	exportmethods := flag.String("exportmethods", "", "Comma separated list of methods to follow when exporting, blank means all of them")
+	exportminradius := flag.Int("exportminradius", 0, "Leave out objects from graph exports with fewer than this many objects getting to the targets through them")
+	exportmaxnodes := flag.Int("exportmaxnodes", 0, "Export at most this many objects in graphs, the ones closest to the targets (0 is no limit)")
+	exportaggregate := flag.Bool("exportaggregate", false, "Export the connections both ways between two objects as one edge, with the methods and how many connections it stands for")
+	exportcollapse := flag.Bool("exportcollapse", false, "Collapse group members with no other connections in graph exports into one node per group")
+	searchbases := flag.String("searchbases", "", "Semicolon separated list of DNs to dump instead of the schema, configuration, DNS and domain naming contexts")
+	extrasearchbases := flag.String("extrasearchbases", "", "Semicolon separated list of DNs to dump in addition to the others, like application partitions")
+	excludebases := flag.String("excludebases", "", "Semicolon separated list of subtrees to leave out of the dump")
+	redact := flag.String("redact", "", "Comma separated list of sensitive attributes to redact in the dump, like description,info,proxyAddresses")
+	redactmode := flag.String("redactmode", "hash", "How to redact attributes: hash (values can still be compared) or drop")
+	configfile := flag.String("config", "adalanche.conf", "File with default values for options, one per line as option=value (written by the setup command)")
+	profile := flag.String("profile", "", "Dump profile setting attributes, page size, SACL and referral options (full, acl-only, stealth, sessions), flags given explicitly take precedence")
 	attributesparam := flag.String("attributes", "", "Comma seperated list of attributes to get, blank means everything")
 	debuglogging := flag.Bool("debug", false, "Enable debug logging")
 	nosacl := flag.Bool("nosacl", true, "Request data with NO SACL flag, allows normal users to dump ntSecurityDescriptor field")
+	chasereferrals := flag.Bool("chasereferrals", false, "Follow referrals to other domains during dump, using the same credentials unless -referralcredentials is given")
+	referralcredentials := flag.String("referralcredentials", "", "File with credentials for referred domains, one per line as: domain username password")
+	verifysamples := flag.Int("verifysamples", 100, "Number of random objects from the dump to compare with the directory in verify")
+	gc := flag.Bool("gc", false, "Dump the whole forest from the Global Catalog (port 3268, or 3269 with TLS), and every domain in it in full where possible")
+	followtrusts := flag.Bool("followtrusts", false, "Also dump the domains that this domain has trusts with, and the ones those have trusts with and so on, into a file per domain")
+	protocol := flag.String("protocol", "ldap", "Protocol to dump with: ldap, or adws for Active Directory Web Services on port 9389 (with -authmode ntlm or ntlmpth)")
+	adexplorer := flag.String("adexplorer", "", "Sysinternals AD Explorer snapshot (.dat) to dump from instead of a domain controller")
+	ntds := flag.String("ntds", "", "NTDS.dit file to dump from instead of a domain controller, like from an IFM copy or a backup")
+	ntdssystem := flag.String("ntdssystem", "", "SYSTEM hive from the same domain controller as -ntds (registry\\SYSTEM in an IFM copy), to check for blank, LM and shared passwords - the hashes are not put in the dump")
+	sharphound := flag.String("sharphound", "", "SharpHound collection (.zip) to dump from instead of a domain controller, sessions and local administrators go to .localmachine.json files")
+	parallel := flag.Int("parallel", 1, "Dump over this many connections at the same time, splitting naming contexts into the subtrees right below them")
+	incremental := flag.Bool("incremental", false, "Only dump what has changed since the last dump from the same domain controller, and merge it into that dump")
 	pagesize := flag.Int("pagesize", 1000, "Chunk requests into pages of this count of objects")
+	monitor := flag.Duration("monitor", 0, "Check the data folder this often while analyzing, and reload when dumps or other data has changed (0 disables)")
+	resultcache := flag.Int("resultcache", 100, "Number of analysis results the webservice keeps, so repeated queries are answered at once (0 disables)")
 	bind := flag.String("bind", "127.0.0.1:8080", "Address and port of webservice to bind to")
 	nobrowser := flag.Bool("nobrowser", false, "Don't launch browser after starting webservice")
+	basepathparam := flag.String("basepath", "", "Serve the UI and API below this path too (like /adalanche), for use behind a reverse proxy")
+	openurl := flag.String("openurl", "", "URL to open in the browser, if the webservice is reached in another way than the bind address (reverse proxy, WSL, port forward)")
+	localepath := flag.String("localepath", "", "Folder with UI translations (<language>.json, like de.json) to use over the built in ones")
+	convertto := flag.String("convertto", "json", "What the convert command converts to: json (from the dump), dump (from the JSON) or sqlite (from the dump, with the edges from analyzing it)")
+	convertfile := flag.String("convertfile", "", "JSON or SQLite file for the convert command (defaults to the dump file name with .json or .sqlite instead of .lz4.msgp)")
+	sqlitefilter := flag.String("sqlitefilter", "", "SQL condition on the objects table (id, dn) choosing which objects to load when the domain is a SQLite dump, like \"dn LIKE '%ou=servers,%'\"")
+	reportname := flag.String("report", "", "Report to run with the report command, blank means all of them")
+	groupby := flag.String("groupby", "", "Attribute to group the findings of the report command by, like _department from a metadata CSV")
+	tier0query := flag.String("tier0query", "", "LDAP query for groups and accounts to treat as tier 0 on top of the built-in admin groups, like \"(|(name=ADM-*)(sAMAccountName=svc_backup))\" - members of matching groups are tier 0 too")
+	metarules := flag.String("metarules", "", "File with rules for custom meta attributes set on objects before analysis, like a business unit from the OU path (attribute, value and optional query per line, separated by tabs)")
+	irdays := flag.Int("irdays", 30, "Days before the newest change in the data that changes count as recent in the IndicatorsOfCompromise report")
+	idptype := flag.String("idptype", "okta", "Identity provider to collect from with collect-idp (okta, scim)")
+	idpurl := flag.String("idpurl", "", "Okta org URL (https://contoso.okta.com) or SCIM base URL (https://idp.contoso.com/scim/v2)")
+	idptoken := flag.String("idptoken", "", "Okta API token or SCIM bearer token")
+	idpname := flag.String("idpname", "", "Name for the collected identity provider data (defaults to the host name from the URL)")
+	servicenowurl := flag.String("servicenowurl", "", "ServiceNow instance URL (https://contoso.service-now.com) for collect-servicenow")
+	servicenowuser := flag.String("servicenowuser", "", "ServiceNow user to collect with, with the password in SERVICENOW_PASSWORD (or use an OAuth token in SERVICENOW_TOKEN)")
+	servicenowname := flag.String("servicenowname", "", "Name for the collected ServiceNow data (defaults to the instance name from the URL)")
+	awsregion := flag.String("awsregion", "", "AWS region of the IAM Identity Center instance for collect-aws (defaults to AWS_REGION)")
+	awsname := flag.String("awsname", "", "Name for the collected AWS data (defaults to the identity store ID)")
+	entratenant := flag.String("entratenant", "", "Entra ID tenant ID or domain for collect-entra (defaults to the tenant of the user signing in)")
+	entraclientid := flag.String("entraclientid", "", "Client ID of the app registration to collect from Entra ID with, signing in with the secret in ENTRA_CLIENT_SECRET (defaults to device code sign in with Microsoft Graph Command Line Tools)")
+	entraname := flag.String("entraname", "", "Name for the collected Entra ID data (defaults to the tenant ID)")
+	sessionhalflife := flag.Duration("sessionhalflife", EdgeHalfLife, "Confidence in connections from session data halves for every period of this age")
+	sessionmaxage := flag.Duration("sessionmaxage", EdgeMaxAge, "Leave out connections from session data older than this, 0 keeps all")
+	demousers := flag.Int("demousers", 500, "Number of users in the domain made by generate-demo, computers and groups are scaled to fit")
+	demomisconfigurations := flag.Int("demomisconfigurations", 20, "Number of weaknesses generate-demo puts in the domain")
+	demoseed := flag.Int64("demoseed", 1, "Random seed for generate-demo, the same seed gives the same domain")
+	hookpredump := flag.String("hookpredump", "", "Command to run before dumping, the dump is skipped if it fails")
+	hookpostdump := flag.String("hookpostdump", "", "Command to run after dumping")
+	hookpreanalyze := flag.String("hookpreanalyze", "", "Command to run before loading and analyzing the data, which is skipped if it fails")
+	hookpostanalyze := flag.String("hookpostanalyze", "", "Command to run after the data is analyzed, with a JSON summary of the findings in the file named by ADALANCHE_SUMMARY")
+	demo := flag.Bool("demo", false, "Analyze a made up domain (demo.local) with weaknesses in it instead of collected data, to try adalanche without access to AD - -demousers, -demomisconfigurations and -demoseed apply")
+	uploadurl := flag.String("uploadurl", "", "Webservice to send data files to with the upload command, like https://adalanche.contoso.local:8080")
+	uploadmaxsize := flag.Int64("uploadmaxsize", UploadMaxSize>>20, "Largest upload in MB the webservice takes")
+	uploadtoken := flag.String("uploadtoken", "", "Token that collectors upload data files to the webservice with, which enables uploads on the webservice (defaults to ADALANCHE_UPLOAD_TOKEN)")
+	machines := flag.String("machines", "", "Machines to collect from with collect-machines, comma separated or @file with one per line (blank is the machine it runs on)")
+	querymatch := flag.String("querymatch", "ad", "String matching in queries: ad (case insensitive unless schema says otherwise), loose (also ignores accents), exact")
 
 	flag.Parse()
 
+	var configgiven bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			configgiven = true
+		}
+	})
+	if settings, err := LoadConfigFile(*configfile); err == nil {
+		if err = applyFlagSettings(flag.CommandLine, settings, "Config file "+*configfile); err != nil {
+			log.Fatal().Msgf("%v", err)
+		}
+	} else if configgiven || !os.IsNotExist(err) {
+		log.Fatal().Msgf("Problem loading config file %v: %v", *configfile, err)
+	}
+
+	if *profile != "" {
+		dumpprofile, found := FindDumpProfile(*profile)
+		if !found {
+			log.Fatal().Msgf("Unknown dump profile %v", *profile)
+		}
+		if err := dumpprofile.Apply(flag.CommandLine); err != nil {
+			log.Fatal().Msgf("%v", err)
+		}
+	}
+
 	if !*debuglogging {
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	} else {
 		log.Debug().Msg("Debug logging enabled")
 	}
 
+	EdgeHalfLife = *sessionhalflife
+	EdgeMaxAge = *sessionmaxage
+	SQLiteFilter = *sqlitefilter
+	IRDays = *irdays
+	KeepAttributes(*keepattributes)
+	CompressAttributes = !*nocompress
+	WarmStart = *warmstart
+	Hooks["predump"] = *hookpredump
+	Hooks["postdump"] = *hookpostdump
+	Hooks["preanalyze"] = *hookpreanalyze
+	Hooks["postanalyze"] = *hookpostanalyze
+	CrashReports = *crashreports
+	CrashReportURL = *crashreporturl
+	CrashReportFolder = *datapath
+	LocalePath = *localepath
+	// Rules first, the tier 0 query can use their attributes
+	MetaRulesFile = *metarules
+	if err := LoadMetaRules(MetaRulesFile); err != nil {
+		log.Fatal().Msgf("Problem loading -metarules %v: %v", MetaRulesFile, err)
+	}
+	Tier0Query = *tier0query
+	if Tier0Query != "" {
+		if _, err := ParseQueryStrict(Tier0Query); err != nil {
+			log.Fatal().Msgf("Problem parsing -tier0query: %v", err)
+		}
+	}
+
+	UploadMaxSize = *uploadmaxsize << 20
+	if *uploadtoken == "" {
+		*uploadtoken = os.Getenv("ADALANCHE_UPLOAD_TOKEN")
+	}
+
+	basepath := strings.TrimSuffix(*basepathparam, "/")
+	if basepath != "" && !strings.HasPrefix(basepath, "/") {
+		basepath = "/" + basepath
+	}
+
+	switch strings.ToLower(*querymatch) {
+	case "ad":
+		QueryMatchMode = MatchAD
+	case "loose":
+		QueryMatchMode = MatchInsensitive
+	case "exact":
+		QueryMatchMode = MatchExact
+	default:
+		log.Fatal().Msgf("Unknown query match mode %v", *querymatch)
+	}
+
+	if *proxyurl != "" {
+		if err := SetProxy(*proxyurl); err != nil {
+			log.Fatal().Msgf("Problem with proxy %v: %v", *proxyurl, err)
+		}
+	}
+
 	log.Info().Msg("adalanche (c) 2020-2021 Lars Karlslund, released under GPLv3, This program comes with ABSOLUTELY NO WARRANTY")
 
 	// Ensure the cache folder is available
@@ -132,18 +298,106 @@ func main() {
 	} else {
 		command = flag.Arg(0)
 	}
+	CrashReportCommand = command
+	DatasetOptions = os.Args[1 : len(os.Args)-flag.NArg()]
+	if command == "warmstart" {
+		WarmStart = true
+	}
+
+	if command == "collect-idp" {
+		if err := CollectIdP(*idptype, *idpurl, *idptoken, *idpname, *datapath); err != nil {
+			log.Fatal().Msgf("Problem collecting from identity provider: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "collect-servicenow" {
+		if err := CollectServiceNow(*servicenowurl, *servicenowuser, *servicenowname, *datapath); err != nil {
+			log.Fatal().Msgf("Problem collecting from ServiceNow: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "collect-aws" {
+		if err := CollectAWS(*awsregion, *awsname, *datapath); err != nil {
+			log.Fatal().Msgf("Problem collecting from AWS IAM Identity Center: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "collect-entra" {
+		if err := CollectEntra(*entratenant, *entraclientid, *entraname, *datapath); err != nil {
+			log.Fatal().Msgf("Problem collecting from Entra ID: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "collect-machines" {
+		if err := CollectMachines(*machines, *datapath); err != nil {
+			log.Fatal().Msgf("Problem collecting from machines: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "upload" {
+		if *uploadurl == "" || *uploadtoken == "" {
+			log.Fatal().Msg("Missing -uploadurl or -uploadtoken - please provide these on commandline")
+		}
+		if err := UploadFiles(*uploadurl, *uploadtoken, *datapath, flag.Args()[1:]); err != nil {
+			log.Fatal().Msgf("Problem uploading: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "setup" {
+		if err := RunSetup(*configfile); err != nil {
+			log.Fatal().Msgf("Setup failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "selftest" {
+		if err := RunSelfTest(flag.Args()[1:]); err != nil {
+			log.Fatal().Msgf("Selftest failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "generate-demo" {
+		if *domain == "" {
+			*domain = "demo.local"
+		}
+		objects := GenerateDemo(DemoOptions{
+			Domain:            *domain,
+			Users:             *demousers,
+			Misconfigurations: *demomisconfigurations,
+			Seed:              *demoseed,
+		})
+		if err := writeDumpFile(filepath.Join(*datapath, *domain+".objects.lz4.msgp"), objects); err != nil {
+			log.Fatal().Msgf("Problem writing demo dump: %v", err)
+		}
+		log.Info().Msgf("Demo dump written, run 'adalanche -domain %v analyze' to look at it", *domain)
+		os.Exit(0)
+	}
+
+	if *demo {
+		switch command {
+		case "analyze", "dump-analyze", "report", "export", "export-detections":
+		default:
+			log.Fatal().Msgf("-demo is for looking at the made up domain, it doesn't work with %v", command)
+		}
+		if *domain == "" {
+			*domain = "demo.local"
+		}
+		if command == "dump-analyze" {
+			command = "analyze"
+		}
+	}
 
 	// Auto detect domain if not supplied
 	if *domain == "" {
 		log.Info().Msg("No domain supplied, auto-detecting")
-		*domain = strings.ToLower(os.Getenv("USERDNSDOMAIN"))
-		if *domain == "" {
-			// That didn't work, lets try something else
-			f, err := fqdn.FqdnHostname()
-			if err == nil {
-				*domain = strings.ToLower(f[strings.Index(f, ".")+1:])
-			}
-		}
+		*domain = DetectDomain()
 		if *domain == "" {
 			log.Fatal().Msg("Domain auto-detection failed")
 		} else {
@@ -151,35 +405,149 @@ func main() {
 		}
 	}
 
-	// Dump data?
+	if command == "diagnose" {
+		var password string
+		if *user != "" {
+			password = *pass
+			if password == "" {
+				fmt.Printf("Please enter password for %v: ", *user)
+				passwd, err := terminal.ReadPassword(int(syscall.Stdin))
+				fmt.Println()
+				if err == nil {
+					password = string(passwd)
+				}
+			}
+		}
+		if err := RunDiagnose(*domain, *server, *user, password, *authdomain); err != nil {
+			log.Fatal().Msgf("Diagnostics failed: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "collect-sysvol" {
+		if *domain == "" {
+			log.Fatal().Msg("Missing domain name - please provider this on commandline")
+		}
+		if *server == "" {
+			if servers := FindDomainControllers(*domain); len(servers) != 0 {
+				*server = servers[0]
+				log.Info().Msgf("AD controller detected as: %v", *server)
+			} else {
+				log.Fatal().Msg("AD controller auto-detection failed, use -server xxxx parameter")
+			}
+		}
+		var username string
+		var ishash bool
+		switch strings.ToLower(*authmodeString) {
+		case "ntlm", "ntlmpth":
+			ishash = strings.EqualFold(*authmodeString, "ntlmpth")
+			username = *user
+			if username == "" {
+				log.Fatal().Msg("Missing username - please provider this on commandline")
+			}
+			if *pass == "" {
+				fmt.Printf("Please enter password for %v: ", username)
+				passwd, err := terminal.ReadPassword(int(syscall.Stdin))
+				fmt.Println()
+				if err == nil {
+					*pass = string(passwd)
+				}
+			}
+		case "ntlmsspi":
+			log.Info().Msg("Reading SYSVOL as the current user")
+		default:
+			log.Fatal().Msgf("Collecting SYSVOL works with -authmode ntlm, ntlmpth or ntlmsspi, not %v", *authmodeString)
+		}
+		if err := CollectSYSVOL(*domain, *server, username, *pass, *authdomain, ishash, *datapath); err != nil {
+			log.Fatal().Msgf("Problem collecting from SYSVOL: %v", err)
+		}
+		os.Exit(0)
+	}
+
+	if command == "convert" {
+		dumpfilename := filepath.Join(*datapath, *domain+".objects.lz4.msgp")
+		jsonfilename := *convertfile
+		if jsonfilename == "" {
+			jsonfilename = filepath.Join(*datapath, *domain+".objects.json")
+		}
+		var err error
+		switch strings.ToLower(*convertto) {
+		case "json":
+			err = ConvertDumpToJSON(dumpfilename, jsonfilename)
+		case "dump":
+			err = ConvertJSONToDump(jsonfilename, dumpfilename)
+		case "sqlite":
+			sqlitefilename := *convertfile
+			if sqlitefilename == "" {
+				sqlitefilename = filepath.Join(*datapath, *domain+".objects.sqlite")
+			}
+			err = ConvertDumpToSQLite(*domain, *datapath, dumpfilename, sqlitefilename)
+		default:
+			log.Fatal().Msgf("Unknown conversion %v, use json, dump or sqlite", *convertto)
+		}
+		if err != nil {
+			log.Fatal().Msgf("Problem converting: %v", err)
+		}
+		os.Exit(0)
+	}
+
 	if command == "dump" || command == "dump-analyze" {
+		if err := RunHook("predump", *domain, *datapath); err != nil {
+			log.Fatal().Msgf("%v", err)
+		}
+	}
+
+	// Dump data?
+	if (command == "dump" || command == "dump-analyze") && (*ntds != "" || *adexplorer != "" || *sharphound != "") {
+		var source string
+		for _, file := range []string{*ntds, *adexplorer, *sharphound} {
+			if file != "" {
+				if source != "" {
+					log.Fatal().Msg("Dump from one of -ntds, -adexplorer or -sharphound, not more")
+				}
+				source = file
+			}
+		}
+		var attributes []string
+		if *attributesparam != "" {
+			attributes = strings.Split(*attributesparam, ",")
+		}
+
+		var redactor *Redactor
+		if *redact != "" {
+			var err error
+			redactor, err = NewRedactor(*redact, *redactmode)
+			if err != nil {
+				log.Fatal().Msgf("Problem setting up redaction: %v", err)
+			}
+		}
+
+		err := writeDumpStream(filepath.Join(*datapath, *domain+".objects.lz4.msgp"), func(e *msgp.Writer) error {
+			switch {
+			case *ntds != "":
+				return DumpNTDS(*ntds, *ntdssystem, SplitDNList(*excludebases), redactor, attributes, e)
+			case *adexplorer != "":
+				return DumpADExplorerSnapshot(*adexplorer, SplitDNList(*excludebases), redactor, attributes, e)
+			}
+			return DumpSharpHound(*sharphound, *datapath, SplitDNList(*excludebases), redactor, attributes, e)
+		})
+		if err != nil {
+			log.Fatal().Msgf("Problem dumping %v: %v", source, err)
+		}
+	} else if command == "dump" || command == "dump-analyze" || command == "verify" {
 		if *domain != "" && *server == "" {
 			// Auto-detect server
-			cname, servers, err := net.LookupSRV("", "", "_ldap._tcp.dc._msdcs."+*domain)
-			if err == nil && cname != "" && len(servers) != 0 {
-				*server = servers[0].Target
+			if servers := FindDomainControllers(*domain); len(servers) != 0 {
+				*server = servers[0]
 				log.Info().Msgf("AD controller detected as: %v", *server)
 			} else {
 				log.Warn().Msg("AD controller auto-detection failed, use -server xxxx parameter")
 			}
 		}
 
-		var authmode byte
-		switch *authmodeString {
-		case "unauth":
-			authmode = 0
-		case "simple":
-			authmode = 1
-		case "md5":
-			authmode = 2
-		case "ntlm":
-			authmode = 3
-		case "ntlmpth":
-			authmode = 4
-		case "ntlmsspi":
-			authmode = 5
-		default:
-			log.Error().Msgf("Unknown LDAP authentication mode %v", *authmodeString)
+		authmode, err := ParseAuthMode(*authmodeString)
+		if err != nil {
+			log.Error().Msgf("%v", err)
 			showUsage()
 		}
 
@@ -194,7 +562,9 @@ func main() {
 		}
 
 		var username string
-		if authmode != 5 {
+		if authmode == 6 {
+			log.Info().Msg("Using Kerberos ticket from credential cache")
+		} else if authmode != 5 {
 			if *user == "" {
 				// Auto-detect user
 				*user = os.Getenv("USERNAME")
@@ -236,11 +606,36 @@ func main() {
 			AuthDomain: *authdomain,
 			TLSMode:    tlsm,
 			IgnoreCert: *ignoreCert,
+			CCache:     *ccache,
+		}
+
+		switch strings.ToLower(*protocol) {
+		case "ldap":
+		case "adws":
+			if *incremental || *gc || *followtrusts {
+				log.Fatal().Msg("Dumps over ADWS can't be incremental, from the Global Catalog or follow trusts, use -protocol ldap for those")
+			}
+			ad.ADWS = true
+		default:
+			log.Fatal().Msgf("Unknown protocol %v, use ldap or adws", *protocol)
+		}
+
+		if *chasereferrals || *referralcredentials != "" {
+			ad.ReferralCredentials = make(map[string]ReferralCredential)
+			if *referralcredentials != "" {
+				ad.ReferralCredentials, err = LoadReferralCredentials(*referralcredentials)
+				if err != nil {
+					log.Fatal().Msgf("Problem loading referral credentials: %v", err)
+				}
+			}
 		}
 
 		err = ad.Connect(authmode)
 		if err != nil {
-			log.Fatal().Msgf("Problem connecting to AD: %v", err)
+			log.Fatal().Msgf("Problem connecting to AD: %v", explainBindError(err))
+		}
+		if skew, err := ad.CheckClockSkew(); err == nil {
+			log.Debug().Msgf("Local clock is %v off from the domain controller", skew)
 		}
 
 		var attributes []string
@@ -248,108 +643,74 @@ func main() {
 			attributes = strings.Split(*attributesparam, ",")
 		}
 
-		outfile, err := os.Create(filepath.Join(*datapath, *domain+".objects.lz4.msgp"))
-		if err != nil {
-			log.Fatal().Msgf("Problem opening domain cache file: %v", err)
-		}
-		boutfile := lz4.NewWriter(outfile)
-		boutfile.Header.CompressionLevel = 10
-		e := msgp.NewWriter(boutfile)
-
-		dumpbar := progressbar.NewOptions(0,
-			progressbar.OptionSetDescription("Dumping..."),
-			progressbar.OptionShowCount(),
-			progressbar.OptionShowIts(),
-			progressbar.OptionSetItsString("objects"),
-			progressbar.OptionOnCompletion(func() { fmt.Println() }),
-			progressbar.OptionThrottle(time.Second*1),
-		)
-
-		log.Info().Msg("Dumping schema objects ...")
-		rawobjects, err := ad.Dump("CN=Schema,CN=Configuration,"+ad.RootDn(), *dumpquery, attributes, *nosacl, *pagesize)
-		if err != nil {
-			log.Fatal().Msgf("Problem dumping AD: %v", err)
-		}
-		log.Debug().Msgf("Saving %v schema objects ...", len(rawobjects))
-		for _, object := range rawobjects {
-			err = object.EncodeMsg(e)
+		if command == "verify" {
+			var redacted []string
+			if *redact != "" {
+				redacted = strings.Split(*redact, ",")
+			}
+			err = VerifyDump(&ad, filepath.Join(*datapath, *domain+".objects.lz4.msgp"), *verifysamples, attributes, redacted, *nosacl)
 			if err != nil {
-				log.Fatal().Msgf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
+				log.Fatal().Msgf("Problem verifying dump: %v", err)
 			}
-			dumpbar.Add(1)
+			ad.Disconnect()
+			os.Exit(0)
 		}
 
-		log.Info().Msg("Dumping configuration objects ...")
-		rawobjects, err = ad.Dump("CN=Configuration,"+ad.RootDn(), *dumpquery, attributes, *nosacl, *pagesize)
-		if err != nil {
-			log.Fatal().Msgf("Problem dumping AD: %v", err)
+		contexts := DefaultNamingContexts(ad.RootDn())
+		if *searchbases != "" {
+			contexts = nil
+			for _, dn := range SplitDNList(*searchbases) {
+				contexts = append(contexts, NamingContext{Name: dn, DN: dn})
+			}
 		}
-		log.Debug().Msgf("Saving %v configuration objects ...", len(rawobjects))
-		for _, object := range rawobjects {
-			err = object.EncodeMsg(e)
+		for _, dn := range SplitDNList(*extrasearchbases) {
+			contexts = append(contexts, NamingContext{Name: dn, DN: dn})
+		}
+
+		var redactor *Redactor
+		if *redact != "" {
+			redactor, err = NewRedactor(*redact, *redactmode)
 			if err != nil {
-				log.Fatal().Msgf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
+				log.Fatal().Msgf("Problem setting up redaction: %v", err)
 			}
-			dumpbar.Add(1)
 		}
 
-		log.Info().Msg("Dumping forest DNS objects ...")
-		rawobjects, err = ad.Dump("DC=ForestDnsZones,"+ad.RootDn(), *dumpquery, attributes, *nosacl, *pagesize)
-		if err != nil {
-			log.Warn().Msgf("Problem dumping forest DNS zones (maybe it doesn't exist): %v", err)
-		} else {
-			log.Debug().Msgf("Saving %v forest DNS objects ...", len(rawobjects))
-			for _, object := range rawobjects {
-				err = object.EncodeMsg(e)
-				if err != nil {
-					log.Fatal().Msgf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
-				}
-				dumpbar.Add(1)
-			}
+		dumpfilename := filepath.Join(*datapath, *domain+".objects.lz4.msgp")
+		if *incremental && *gc {
+			log.Fatal().Msg("Dumps from the Global Catalog can't be incremental")
 		}
-		log.Info().Msg("Dumping domain DNS objects ...")
-		rawobjects, err = ad.Dump("DC=DomainDnsZones,"+ad.RootDn(), *dumpquery, attributes, *nosacl, *pagesize)
-		if err != nil {
-			log.Warn().Msgf("Problem dumping domain DNS zones (maybe it doesn't exist): %v", err)
+		if *incremental {
+			err = DumpIncremental(&ad, dumpfilename, contexts, SplitDNList(*excludebases), redactor, *dumpquery, attributes, *nosacl, *pagesize)
+			if err != nil {
+				log.Fatal().Msgf("Problem dumping AD: %v", err)
+			}
 		} else {
-			log.Debug().Msgf("Saving %v domain DNS objects ...", len(rawobjects))
-			for _, object := range rawobjects {
-				err = object.EncodeMsg(e)
-				if err != nil {
-					log.Fatal().Msgf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
+			err = writeDumpStream(dumpfilename, func(e *msgp.Writer) error {
+				if *gc {
+					return DumpForest(&ad, contexts, SplitDNList(*excludebases), redactor, *dumpquery, attributes, *nosacl, *pagesize, *parallel, e)
 				}
-				dumpbar.Add(1)
+				return DumpNamingContexts(&ad, contexts, SplitDNList(*excludebases), redactor, *dumpquery, attributes, *nosacl, *pagesize, *parallel, e)
+			})
+			if err != nil {
+				log.Fatal().Msgf("Problem dumping AD: %v", err)
 			}
 		}
 
-		log.Info().Msg("Dumping main AD objects ...")
-		rawobjects, err = ad.Dump(ad.RootDn(), *dumpquery, attributes, *nosacl, *pagesize)
-		if err != nil {
-			log.Fatal().Msgf("Problem dumping AD: %v", err)
+		if *followtrusts {
+			domains := DumpTrustedDomains(&ad, *datapath, redactor, *dumpquery, attributes, *nosacl, *pagesize, *parallel)
+			log.Info().Msgf("Dumped %v domains, analyze them together with -domain %v", len(domains), strings.Join(domains, ","))
 		}
-		log.Debug().Msgf("Saving %v AD objects ...", len(rawobjects))
-		for _, object := range rawobjects {
-
-			// Let's check if it this is a GPO and then add a fake attribute if it affects local groups
-			// if object.Attributes[]
-
-			err = object.EncodeMsg(e)
-			if err != nil {
-				log.Fatal().Msgf("Problem encoding LDAP object %v: %v", object.DistinguishedName, err)
-			}
-			dumpbar.Add(1)
-		}
-		dumpbar.Finish()
 
 		err = ad.Disconnect()
 		if err != nil {
 			log.Fatal().Msgf("Problem disconnecting from AD: %v", err)
 		}
+	}
 
-		e.Flush()
-		boutfile.Close()
-		outfile.Close()
-
+	if command == "dump" || command == "dump-analyze" {
+		if err := RunHook("postdump", *domain, *datapath); err != nil {
+			log.Warn().Msgf("%v", err)
+		}
 	}
 
 	if command == "dump" {
@@ -362,289 +723,22 @@ func main() {
 		showUsage()
 	}
 
-	for _, domain := range strings.Split(*domain, ",") {
-		if AllObjects.Base == "" { // Shoot me, this is horrible
-			AllObjects.Base = "dc=" + strings.Replace(domain, ".", ",dc=", -1)
-			AllObjects.Domain = domain
-		}
-
-		cachefile, err := os.Open(filepath.Join(*datapath, domain+".objects.lz4.msgp"))
-		if err != nil {
-			log.Fatal().Msgf("Problem opening domain cache file: %v", err)
-		}
-		bcachefile := lz4.NewReader(cachefile)
-
-		cachestat, _ := cachefile.Stat()
-
-		loadbar := progressbar.NewOptions(int(cachestat.Size()),
-			progressbar.OptionSetDescription("Loading objects from "+domain+" ..."),
-			progressbar.OptionShowBytes(true),
-			progressbar.OptionThrottle(time.Second*1),
-			progressbar.OptionOnCompletion(func() { fmt.Println() }),
-		)
-
-		d := msgp.NewReader(bcachefile)
-		// d := msgp.NewReader(&progressbar.Reader{bcachefile, &loadbar})
-
-		// Load all the stuff
-		var lastpos int64
-		for {
-			var rawObject RawObject
-			err = rawObject.DecodeMsg(d)
-
-			pos, _ := cachefile.Seek(0, io.SeekCurrent)
-			loadbar.Add(int(pos - lastpos))
-			lastpos = pos
-
-			if err == nil {
-				newObject := rawObject.ToObject(*importall)
-				AllObjects.Add(&newObject)
-			} else if msgp.Cause(err) == io.EOF {
-				break
-			} else {
-				log.Fatal().Msgf("Problem decoding object: %v", err)
-			}
-		}
-		cachefile.Close()
-		loadbar.Finish()
-	}
-
-	log.Debug().Msgf("Loaded %v ojects", len(AllObjects.AsArray()))
-
-	// Add our known SIDs if they're missing
-	for sid, name := range knownsids {
-		binsid, err := SIDFromString(sid)
-		if err != nil {
-			log.Fatal().Msgf("Problem parsing SID %v", sid)
-		}
-		if _, found := AllObjects.FindSID(binsid); !found {
-			dn := "CN=" + name + ",CN=microsoft-builtin"
-			log.Info().Msgf("Adding missing well known SID %v (%v) as %v", name, sid, dn)
-			AllObjects.Add(&Object{
-				DistinguishedName: dn,
-				Attributes: map[Attribute][]string{
-					Name:           {name},
-					ObjectSid:      {string(binsid)},
-					ObjectClass:    {"person", "user", "top"},
-					ObjectCategory: {"Group"},
-				},
-			})
-		}
+	if err := RunHook("preanalyze", *domain, *datapath); err != nil {
+		log.Fatal().Msgf("%v", err)
 	}
-
-	// ShowAttributePopularity()
-
-	// Generate member of chains
-	processbar := progressbar.NewOptions(int(len(AllObjects.dnmap)),
-		progressbar.OptionSetDescription("Processing objects..."),
-		progressbar.OptionShowCount(),
-		progressbar.OptionShowIts(),
-		progressbar.OptionSetItsString("objects"),
-		progressbar.OptionOnCompletion(func() { fmt.Println() }),
-		progressbar.OptionThrottle(time.Second*1),
-	)
-
-	// everyonesid, _ := SIDFromString("S-1-1-0")
-	// everyone, ok := AllObjects.FindSID(everyonesid)
-	// if !ok {
-	// 	log.Fatal().Msgf("Could not locate Everyone, aborting")
-	// }
-
-	// authenticateduserssid, _ := SIDFromString("S-1-5-11")
-	// authenticatedusers, ok := AllObjects.FindSID(authenticateduserssid)
-	// if !ok {
-	// 	log.Fatal().Msgf("Could not locate Authenticated Users, aborting")
-	// }
-
-	log.Info().Msg("Pre-processing directory data ...")
-	for _, object := range AllObjects.AsArray() {
-		processbar.Add(1)
-		object.MemberOf()
-
-		// Crude special handling for Everyone and Authenticated Users
-		// if object.Type() == ObjectTypeUser || object.Type() == ObjectTypeComputer || object.Type() == ObjectTypeManagedServiceAccount {
-		// 	everyone.imamemberofyou(object)
-		// 	authenticatedusers.imamemberofyou(object)
-		// 	object.memberof = append(object.memberof, everyone, authenticatedusers)
-		// }
-
-		object.SetAttr(MetaType, object.Type().String())
-		if lastlogon, ok := object.AttrTimestamp(LastLogonTimestamp); ok {
-			object.SetAttr(MetaLastLoginAge, strconv.Itoa(int(time.Since(lastlogon)/time.Hour)))
-		}
-		if passwordlastset, ok := object.AttrTimestamp(PwdLastSet); ok {
-			object.SetAttr(MetaPasswordAge, strconv.Itoa(int(time.Since(passwordlastset)/time.Hour)))
-		}
-		if strings.Contains(strings.ToLower(object.OneAttr(OperatingSystem)), "linux") {
-			object.SetAttr(MetaLinux, "1")
-		}
-		if strings.Contains(strings.ToLower(object.OneAttr(OperatingSystem)), "windows") {
-			object.SetAttr(MetaWindows, "1")
-		}
-		if len(object.Attr(MSmcsAdmPwdExpirationTime)) > 0 {
-			object.SetAttr(MetaLAPSInstalled, "1")
-		}
-		if uac, ok := object.AttrInt(UserAccountControl); ok {
-			if uac&UAC_TRUSTED_FOR_DELEGATION != 0 {
-				object.SetAttr(MetaUnconstrainedDelegation, "1")
-			}
-			if uac&UAC_TRUSTED_TO_AUTH_FOR_DELEGATION != 0 {
-				object.SetAttr(MetaConstrainedDelegation, "1")
-			}
-			if uac&UAC_NOT_DELEGATED != 0 {
-				log.Debug().Msgf("%v has can't be used as delegation", object.DN())
-			}
-			if uac&UAC_WORKSTATION_TRUST_ACCOUNT != 0 {
-				object.SetAttr(MetaWorkstation, "1")
-			}
-			if uac&UAC_SERVER_TRUST_ACCOUNT != 0 {
-				object.SetAttr(MetaServer, "1")
-			}
-			if uac&UAC_ACCOUNTDISABLE != 0 {
-				object.SetAttr(MetaAccountDisabled, "1")
-			}
-			if uac&UAC_PASSWD_CANT_CHANGE != 0 {
-				object.SetAttr(MetaPasswordCantChange, "1")
-			}
-			if uac&UAC_DONT_EXPIRE_PASSWORD != 0 {
-				object.SetAttr(MetaPasswordNoExpire, "1")
-			}
-			if uac&UAC_PASSWD_NOTREQD != 0 {
-				object.SetAttr(MetaPasswordNotRequired, "1")
-			}
-		}
-
-		if object.Type() == ObjectTypeTrust {
-			// http://www.frickelsoft.net/blog/?p=211
-			var direction string
-			dir, _ := object.AttrInt(TrustDirection)
-			switch dir {
-			case 0:
-				direction = "disabled"
-			case 1:
-				direction = "incoming"
-			case 2:
-				direction = "outgoing"
-			case 3:
-				direction = "bidirectional"
-			}
-
-			attr, _ := object.AttrInt(TrustAttributes)
-			log.Debug().Msgf("Domain has a %v trust with %v", direction, object.OneAttr(TrustPartner))
-			if dir&2 != 0 && attr&4 != 0 {
-				log.Debug().Msgf("SID filtering is not enabled, so pwn %v and pwn this AD too", object.OneAttr(TrustPartner))
-			}
-		}
-
-		// Special types of Objects
-		if object.HasAttrValue(ObjectClass, "controlAccessRight") {
-			u, err := uuid.FromString(object.OneAttr(A("rightsGuid")))
-			// log.Debug().Msgf("Adding right %v %v", u, object.OneAttr(DisplayName))
-			if err == nil {
-				AllRights[u] = object
-			}
-		} else if object.HasAttrValue(ObjectClass, "attributeSchema") {
-			objectGUID, err := uuid.FromBytes([]byte(object.OneAttr(A("schemaIDGUID"))))
-			objectGUID = SwapUUIDEndianess(objectGUID)
-			// log.Debug().Msgf("Adding schema attribute %v %v", u, object.OneAttr(Name))
-			if err == nil {
-				AllSchemaAttributes[objectGUID] = object
-				switch object.OneAttr(Name) {
-				case "ms-Mcs-AdmPwd":
-					log.Info().Msg("Detected LAPS schema extension, adding extra analyzer")
-					PwnAnalyzers = append(PwnAnalyzers, PwnAnalyzer{
-						Method: PwnReadLAPSPassword,
-						ObjectAnalyzer: func(o *Object) []*Object {
-							var results []*Object
-							// Only for computers
-							if o.Type() != ObjectTypeComputer {
-								return results
-							}
-							// ... that has LAPS installed
-							if len(o.Attr(MSmcsAdmPwdExpirationTime)) == 0 {
-								return results
-							}
-							// Analyze ACL
-							sd, err := o.SecurityDescriptor()
-							if err != nil {
-								return results
-							}
-							for _, acl := range sd.DACL.Entries {
-								if acl.Type == ACETYPE_ACCESS_ALLOWED_OBJECT && acl.Mask&RIGHT_DS_READ_PROPERTY != 0 && acl.ObjectType == objectGUID {
-									results = append(results, AllObjects.FindOrAddSID(acl.SID))
-								}
-							}
-							return results
-						},
-					})
-				}
-			}
-		} else if object.HasAttrValue(ObjectClass, "classSchema") {
-			u, err := uuid.FromBytes([]byte(object.OneAttr(A("schemaIDGUID"))))
-			u = SwapUUIDEndianess(u)
-			// log.Debug().Msgf("Adding schema class %v %v", u, object.OneAttr(Name))
-			if err == nil {
-				AllSchemaClasses[u] = object
-			}
-		}
-	}
-	processbar.Finish()
-
-	// This sucks in a very bad way, Objects really needs to be an AD object :-\
-	ad := AD{
-		Domain: *domain,
-	}
-
-	// Find dsHeuristics, this defines groups EXCLUDED From AdminSDHolder application
-
-	// https://social.technet.microsoft.com/wiki/contents/articles/22331.adminsdholder-protected-groups-and-security-descriptor-propagator.aspx#What_is_a_protected_group
-
-	var excluded string
-	if ds, found := AllObjects.Find("CN=Directory Service,CN=Windows NT,CN=Services,CN=Configuration," + ad.RootDn()); found {
-		excluded = ds.OneAttr(DsHeuristics)
-	}
-
-	// Let's see if we can find the AdminSDHolder container
-	if adminsdholder, found := AllObjects.Find("cn=AdminSDHolder,cn=System," + ad.RootDn()); found {
-		// We found it - so we know it can theoretically "pwn" any object with AdminCount > 0
-		PwnAnalyzers = append(PwnAnalyzers, MakeAdminSDHolderPwnanalyzerFunc(adminsdholder, excluded))
+	if *demo {
+		LoadDemoDataset(DemoOptions{
+			Domain:            *domain,
+			Users:             *demousers,
+			Misconfigurations: *demomisconfigurations,
+			Seed:              *demoseed,
+		})
+	} else if err := LoadDataset(*domain, *datapath, *importall); err != nil {
+		log.Fatal().Msgf("%v", err)
 	}
-
-	// Generate member of chains
-	pwnbar := progressbar.NewOptions(int(len(AllObjects.dnmap)),
-		progressbar.OptionSetDescription("Analyzing who can pwn who ..."),
-		progressbar.OptionShowCount(),
-		progressbar.OptionShowIts(),
-		progressbar.OptionSetItsString("objects"),
-		// progressbar.OptionShowBytes(true),
-		progressbar.OptionOnCompletion(func() { fmt.Println() }),
-		progressbar.OptionThrottle(time.Second*1),
-	)
-
-	var pwnlinks int
-	for _, object := range AllObjects.AsArray() {
-		pwnbar.Add(1)
-		// log.Info().Msg(object.String())
-		for _, analyzer := range PwnAnalyzers {
-			for _, pwnobject := range analyzer.ObjectAnalyzer(object) {
-				if pwnobject == object || pwnobject.SID() == object.SID() { // SID check solves (some) dual-AD analysis problems
-					// We don't care about self owns
-					continue
-				}
-
-				// Ignore these, SELF = self own, Creator/Owner always has full rights
-				if pwnobject.SID() == SelfSID || pwnobject.SID() == CreatorOwnerSID || pwnobject.SID() == SystemSID {
-					continue
-				}
-				// log.Debug().Msgf("Detected that %v can pwn %v by %v", pwnobject.DN(), object.DN(), analyzer.Method)
-				pwnobject.CanPwn = pwnobject.CanPwn.Set(object, analyzer.Method)
-				object.PwnableBy = object.PwnableBy.Set(pwnobject, analyzer.Method)
-				pwnlinks++
-			}
-		}
+	if err := RunHook("postanalyze", *domain, *datapath); err != nil {
+		log.Warn().Msgf("%v", err)
 	}
-	pwnbar.Finish()
-	log.Debug().Msgf("Detected %v ways to pwn objects", pwnlinks)
 
 	switch command {
 	case "exportacls":
@@ -662,6 +756,30 @@ func main() {
 
 		log.Info().Msg("Done")
 	case "export":
+		methods, err := ParsePwnMethods(*exportmethods)
+		if err != nil {
+			log.Fatal().Msgf("Problem with export methods: %v", err)
+		}
+		if *exporttype == "static" {
+			queries := [][2]string{{*analyzequery, *analyzequery}}
+			if *exportqueries != "" {
+				queries, err = LoadStaticQueries(*exportqueries)
+				if err != nil {
+					log.Fatal().Msgf("Problem loading queries to export: %v", err)
+				}
+			}
+			mode := "normal"
+			if *exportinverted {
+				mode = "inverted"
+			}
+			folder := "adalanche-static-" + *domain
+			if err = ExportStaticSite(queries, mode, methods, folder); err != nil {
+				log.Fatal().Msgf("Problem exporting static site: %v", err)
+			}
+			log.Info().Msgf("Done, open %v in a browser", filepath.Join(folder, "index.html"))
+			break
+		}
+
 		log.Info().Msg("Finding most valuable assets ...")
 		q, err := ParseQueryStrict(*analyzequery)
 		if err != nil {
@@ -676,11 +794,29 @@ func main() {
 		if *exportinverted {
 			mode = "inverted"
 		}
-		resultgraph := AnalyzeObjects(includeobjects, nil, PwnMethod(PwnAllMethods), mode, 99)
+		resultgraph := AnalyzeObjects(includeobjects, nil, methods, mode, 99)
+		if *exportminradius > 0 || *exportmaxnodes > 0 || *exportcollapse {
+			resultgraph = FilterGraph(resultgraph, mode, ExportFilter{
+				MinRadius:       *exportminradius,
+				MaxNodes:        *exportmaxnodes,
+				CollapseMembers: *exportcollapse,
+			})
+		}
 
+		if (*exporttype == "mermaid" || *exporttype == "drawio") && len(resultgraph.Implicated) > diagramMaxNodes {
+			log.Warn().Msgf("Exporting %v objects, %v is meant for single paths - narrow the query or use -exportmaxnodes", len(resultgraph.Implicated), *exporttype)
+		}
 		switch *exporttype {
 		case "graphviz":
-			err = ExportGraphViz(resultgraph, "adalanche-"+*domain+".dot")
+			err = ExportGraphViz(resultgraph, "adalanche-"+*domain+".dot", *exportaggregate)
+		case "mermaid":
+			err = ExportMermaid(resultgraph, "adalanche-"+*domain+".mmd", *exportaggregate)
+		case "drawio":
+			err = ExportDrawIO(resultgraph, "adalanche-"+*domain+".drawio", *analyzequery, *exportaggregate)
+		case "parquet":
+			err = ExportParquet(resultgraph, "adalanche-"+*domain, *exportaggregate)
+		case "opengraph":
+			err = ExportOpenGraph(resultgraph, "adalanche-opengraph-"+*domain+".json")
 		case "cytoscapejs":
 			err = ExportCytoscapeJS(resultgraph, "adalanche-cytoscape-js-"+*domain+".json")
 		default:
@@ -692,10 +828,58 @@ func main() {
 		}
 
 		log.Info().Msg("Done")
+	case "export-detections":
+		filename := "adalanche-sigma-" + *domain + ".yml"
+		rules, err := ExportDetections(filename)
+		if err != nil {
+			log.Fatal().Msgf("Problem exporting detections: %v", err)
+		}
+		log.Info().Msgf("Wrote %v Sigma rules to %v", rules, filename)
+	case "report":
+		var found bool
+		for _, report := range Reports {
+			if *reportname != "" && !strings.EqualFold(report.Name, *reportname) {
+				continue
+			}
+			found = true
+			findings := report.Generate()
+			fmt.Printf("%v - %v finding(s)\n%v\n", report.Name, len(findings), report.Description)
+			if *groupby != "" {
+				for _, group := range GroupFindings(findings, A(*groupby)) {
+					fmt.Printf("  %v=%v - %v finding(s)\n", *groupby, Default(group.Value, "(none)"), len(group.Findings))
+					for _, finding := range group.Findings {
+						fmt.Printf("    %v: %v\n", finding.DN, finding.Detail)
+					}
+				}
+			} else {
+				for _, finding := range findings {
+					fmt.Printf("  %v: %v\n", finding.DN, finding.Detail)
+				}
+			}
+			fmt.Println()
+		}
+		if !found {
+			log.Fatal().Msgf("Unknown report %v", *reportname)
+		}
+	case "warmstart":
+		// LoadDataset saved it
 	case "analyze", "dump-analyze":
 		quit := make(chan bool)
 
-		srv := webservice(*bind)
+		analysisCache.SetSize(*resultcache)
+		srv := webservice(*bind, basepath, *datapath, *uploadtoken)
+
+		if *uploadtoken != "" && *monitor == 0 {
+			// Uploaded files are loaded when the monitor sees them
+			*monitor = time.Minute
+			log.Info().Msg("Uploads are enabled, checking the data folder for changes every minute")
+		}
+		if *monitor > 0 {
+			go func() {
+				defer CrashHandler()
+				MonitorDataset(*datapath, *monitor)
+			}()
+		}
 
 		go func() {
 			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -707,20 +891,12 @@ func main() {
 
 		// Launch browser
 		if !*nobrowser {
-			var err error
-			url := "http://" + *bind
-			switch runtime.GOOS {
-			case "linux":
-				err = exec.Command("xdg-open", url).Start()
-			case "windows":
-				err = exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
-			case "darwin":
-				err = exec.Command("open", url).Start()
-			default:
-				err = fmt.Errorf("unsupported platform")
+			url := localURL(*bind) + basepath + "/"
+			if *openurl != "" {
+				url = *openurl
 			}
-			if err != nil {
-				log.Debug().Msgf("Problem launching browser: %v", err)
+			if err := OpenBrowser(url); err != nil {
+				log.Warn().Msgf("Problem launching browser: %v", err)
 			}
 		}
 
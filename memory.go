@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// Rough overheads of the Go data structures, good enough to see where memory goes but not exact
+const (
+	stringHeaderSize = int(unsafe.Sizeof(""))
+	sliceHeaderSize  = int(unsafe.Sizeof([]string(nil)))
+	mapEntrySize     = 16 // Bucket share, tophash and load factor slack, on top of the key and value
+	pointerSize      = int(unsafe.Sizeof((*Object)(nil)))
+)
+
+// Attributes with the most data are listed individually in the memory report
+const memoryReportTopAttributes = 15
+
+func formatBytes(size int) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%v bytes", size)
+}
+
+// The DN column holds what the memory is used for, as this is a summary of the whole dataset. Sizes are estimates
+// from the number and length of the values, compare them to the heap size on the first line
+func memoryReport() []Finding {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	findings := []Finding{{
+		"Total",
+		fmt.Sprintf("Go heap has %v in use, %v taken from the OS in all", formatBytes(int(mem.HeapInuse)), formatBytes(int(mem.Sys))),
+	}}
+
+	objects := AllObjects.AsArray()
+
+	type attributeusage struct {
+		attribute Attribute
+		objects   int
+		values    int
+		bytes     int
+	}
+	usage := make(map[Attribute]*attributeusage)
+	var objectbytes, edges int
+	securitydescriptors := make(map[*SecurityDescriptor]struct{})
+	for _, o := range objects {
+		objectbytes += int(unsafe.Sizeof(*o)) + len(o.DN())
+		edges += len(o.CanPwnSnapshot()) + len(o.PwnableBySnapshot())
+		o.lock.RLock()
+		for attribute, values := range o.Attributes {
+			u := usage[attribute]
+			if u == nil {
+				u = &attributeusage{attribute: attribute}
+				usage[attribute] = u
+			}
+			u.objects++
+			u.values += len(values)
+			u.bytes += sliceHeaderSize + 2 + mapEntrySize // Attribute is a uint16
+			for _, value := range values {
+				u.bytes += stringHeaderSize + len(value)
+			}
+		}
+		// What they take up compressed
+		for attribute, compressed := range o.compressed {
+			u := usage[attribute]
+			if u == nil {
+				u = &attributeusage{attribute: attribute}
+				usage[attribute] = u
+			}
+			u.objects++
+			u.values += compressedValueCount(compressed)
+			u.bytes += sliceHeaderSize + 2 + mapEntrySize + len(compressed)
+		}
+		if o.sdcache != nil {
+			securitydescriptors[o.sdcache] = struct{}{}
+		}
+		o.lock.RUnlock()
+	}
+	findings = append(findings, Finding{"Objects", fmt.Sprintf("%v objects, %v without attributes", len(objects), formatBytes(objectbytes))})
+
+	var attributebytes, values, unusedbytes int
+	analysisused := make(map[Attribute]struct{})
+	for _, name := range analysisAttributes {
+		analysisused[A(name)] = struct{}{}
+	}
+	sorted := make([]*attributeusage, 0, len(usage))
+	for _, u := range usage {
+		sorted = append(sorted, u)
+		attributebytes += u.bytes
+		values += u.values
+		if u.attribute.IsMeta() {
+			continue
+		}
+		if _, found := analysisused[u.attribute]; !found {
+			unusedbytes += u.bytes
+		}
+	}
+	findings = append(findings, Finding{"Attributes", fmt.Sprintf("%v values in %v different attributes, %v", values, len(usage), formatBytes(attributebytes))})
+	if strippedBytes > 0 {
+		findings = append(findings, Finding{"Stripped attributes", fmt.Sprintf("%v of photos and other attributes not used in analysis left out when loading (-keepattributes loads them)", formatBytes(strippedBytes))})
+	}
+	if compressedBytes > 0 {
+		findings = append(findings, Finding{"Compressed attributes", fmt.Sprintf("%v of raw security descriptors and certificates kept compressed as %v (-nocompress turns it off)", formatBytes(uncompressedBytes), formatBytes(compressedBytes))})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].bytes > sorted[j].bytes
+	})
+	if len(sorted) > memoryReportTopAttributes {
+		sorted = sorted[:memoryReportTopAttributes]
+	}
+	for _, u := range sorted {
+		detail := fmt.Sprintf("%v values on %v objects, %v", u.values, u.objects, formatBytes(u.bytes))
+		if _, found := analysisused[u.attribute]; !found && !u.attribute.IsMeta() {
+			detail += ", not used by the analysis"
+		}
+		findings = append(findings, Finding{"Attribute " + u.attribute.String(), detail})
+	}
+
+	var aces, sdbytes int
+	for sd := range securitydescriptors {
+		aces += len(sd.DACL.Entries) + len(sd.SACL.Entries)
+		sdbytes += int(unsafe.Sizeof(*sd)) + len(sd.Owner) + len(sd.Group)
+		for _, acl := range []ACL{sd.DACL, sd.SACL} {
+			for _, ace := range acl.Entries {
+				sdbytes += int(unsafe.Sizeof(ace)) + len(ace.SID)
+			}
+		}
+	}
+	findings = append(findings, Finding{"Security descriptors", fmt.Sprintf("%v different ones shared by the objects, with %v ACEs, %v", len(securitydescriptors), aces, formatBytes(sdbytes))})
+
+	dns, sids, guids, computers := AllObjects.IndexCounts()
+	indexbytes := dns*(stringHeaderSize+pointerSize+mapEntrySize) + sids*(stringHeaderSize+pointerSize+mapEntrySize) +
+		guids*(16+pointerSize+mapEntrySize) + computers*(stringHeaderSize+pointerSize+mapEntrySize) +
+		len(objects)*(2*pointerSize+mapEntrySize) // The object set and the array
+	findings = append(findings, Finding{"Indexes", fmt.Sprintf("%v DNs, %v SIDs, %v GUIDs and %v computer names, %v", dns, sids, guids, computers, formatBytes(indexbytes))})
+
+	edgedetaillock.RLock()
+	var details int
+	for _, pairdetails := range AllEdgeDetails {
+		details += len(pairdetails)
+	}
+	edgedetaillock.RUnlock()
+	edgebytes := edges*int(unsafe.Sizeof(PwnInfo{})) + details*int(unsafe.Sizeof(EdgeDetail{}))
+	findings = append(findings, Finding{"Edges", fmt.Sprintf("%v connections (each kept in both ends), %v with details, %v", edges/2, details, formatBytes(edgebytes))})
+
+	// What to do about it
+	if attributebytes > 0 && unusedbytes*4 > attributebytes {
+		var unused []string
+		for _, u := range sorted {
+			if _, found := analysisused[u.attribute]; !found && !u.attribute.IsMeta() {
+				unused = append(unused, u.attribute.String())
+			}
+		}
+		findings = append(findings, Finding{"Hint", fmt.Sprintf("%v (%v%%) of attribute data is in attributes the analysis doesn't use (like %v), dump with -profile acl-only or pick what you need with -attributes to save memory and dump time",
+			formatBytes(unusedbytes), unusedbytes*100/attributebytes, strings.Join(unused, ", "))})
+	}
+	if len(objects) > 0 && edges/len(objects) > 100 {
+		findings = append(findings, Finding{"Hint", "There are many connections per object, look for ACEs granting broad rights to large groups, as each of those is a connection to every object it covers"})
+	}
+	return findings
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Mermaid and draw.io are for single paths pasted into documentation, they don't lay out big graphs well
+const diagramMaxNodes = 50
+
+// Quotes a label for Mermaid, which takes HTML entities but not backslash escapes
+func mermaidLabel(label string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(label) + `"`
+}
+
+// Writes the graph as a Mermaid flowchart, which wikis and Markdown renderers draw from the text
+func WriteMermaid(w io.Writer, pg PwnGraph, aggregate bool) {
+	targets := make(map[*Object]struct{})
+	for _, target := range pg.Targets {
+		targets[target] = struct{}{}
+	}
+
+	fmt.Fprintln(w, "flowchart LR")
+	ids := make(map[*Object]int)
+	for id, object := range pg.Implicated {
+		ids[object] = id
+		fmt.Fprintf(w, "    n%v[%v]\n", id, mermaidLabel(object.Label()))
+	}
+	for _, edge := range ExportEdges(pg, aggregate) {
+		arrow := "-->"
+		if edge.ReverseMethods != 0 {
+			arrow = "<-->"
+		}
+		fmt.Fprintf(w, "    n%v %v|%v| n%v\n", ids[edge.Source], arrow, mermaidLabel(edge.Label()), ids[edge.Target])
+	}
+
+	var targetids []string
+	for _, object := range pg.Implicated {
+		if _, istarget := targets[object]; istarget {
+			targetids = append(targetids, fmt.Sprintf("n%v", ids[object]))
+		}
+	}
+	if len(targetids) > 0 {
+		fmt.Fprintln(w, "    classDef target fill:#f8d7da,stroke:#dc3545")
+		fmt.Fprintf(w, "    class %v target\n", strings.Join(targetids, ","))
+	}
+}
+
+func ExportMermaid(pg PwnGraph, filename string, aggregate bool) error {
+	df, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+	WriteMermaid(df, pg, aggregate)
+	return nil
+}
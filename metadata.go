@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Business context from outside AD, like owner, department, criticality and asset tag, from CSV files in datapath
+// named <name>.metadata.csv. The first row names the columns, and a sAMAccountName or objectSid column says which
+// object a row is for (DOMAIN\name works too, and computers can be given without the $). The other columns are set
+// on the object as meta attributes with the column name in lowercase without spaces and such, so "Asset tag" becomes
+// _assettag, to be queried and to group reports by
+func LoadMetadataCSVs(datapath string) error {
+	files, err := filepath.Glob(filepath.Join(datapath, "*.metadata.csv"))
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	// Objects by lowercase sAMAccountName, there can be more than one with several domains loaded
+	accounts := make(map[string][]*Object)
+	for _, o := range AllObjects.AsArray() {
+		if samaccountname := o.OneAttr(SAMAccountName); samaccountname != "" {
+			accounts[strings.ToLower(samaccountname)] = append(accounts[strings.ToLower(samaccountname)], o)
+		}
+	}
+
+	for _, file := range files {
+		rows, unmatched, err := loadMetadataCSV(file, accounts)
+		if err != nil {
+			log.Warn().Msgf("Problem loading metadata from %v: %v", file, err)
+			continue
+		}
+		log.Info().Msgf("Loaded metadata from %v, %v of %v rows matched an object", file, rows-unmatched, rows)
+	}
+	return nil
+}
+
+// Returns the meta attribute name for a column
+func metadataAttributeName(column string) string {
+	var name strings.Builder
+	name.WriteString("_")
+	for _, r := range strings.ToLower(column) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			name.WriteRune(r)
+		}
+	}
+	return name.String()
+}
+
+func loadMetadataCSV(file string, accounts map[string][]*Object) (rows, unmatched int, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return 0, 0, fmt.Errorf("no header row: %v", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff") // Excel puts a byte order mark first
+	}
+	samcolumn, sidcolumn := -1, -1
+	attributes := make([]Attribute, len(header))
+	for i, column := range header {
+		switch metadataAttributeName(column) {
+		case "_samaccountname":
+			samcolumn = i
+		case "_objectsid", "_sid":
+			sidcolumn = i
+		case "_":
+			// Nothing usable in the name, so the column is skipped
+		default:
+			attributes[i] = NewAttribute(metadataAttributeName(column))
+		}
+	}
+	if samcolumn == -1 && sidcolumn == -1 {
+		return 0, 0, fmt.Errorf("needs a sAMAccountName or objectSid column")
+	}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return rows, unmatched, err
+		}
+		rows++
+
+		var objects []*Object
+		if sidcolumn != -1 && sidcolumn < len(record) && record[sidcolumn] != "" {
+			if sid, err := SIDFromString(strings.TrimSpace(record[sidcolumn])); err == nil {
+				if o, found := AllObjects.FindSID(sid); found {
+					objects = append(objects, o)
+				}
+			}
+		}
+		if len(objects) == 0 && samcolumn != -1 && samcolumn < len(record) && record[samcolumn] != "" {
+			name := strings.ToLower(strings.TrimSpace(record[samcolumn]))
+			if backslash := strings.LastIndex(name, `\`); backslash != -1 {
+				name = name[backslash+1:]
+			}
+			objects = accounts[name]
+			if len(objects) == 0 {
+				objects = accounts[name+"$"]
+			}
+		}
+		if len(objects) == 0 {
+			unmatched++
+			continue
+		}
+
+		for i, value := range record {
+			if i >= len(attributes) || attributes[i] == 0 || strings.TrimSpace(value) == "" {
+				continue
+			}
+			for _, o := range objects {
+				o.SetAttr(attributes[i], strings.TrimSpace(value))
+			}
+		}
+	}
+	return rows, unmatched, nil
+}
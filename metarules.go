@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Rules for custom meta attributes, like a business unit taken from the OU path, so they can be used in queries, in
+// -tier0query and in reports. Each line in the file is an attribute, a value and an optional LDAP query, separated by
+// tabs. The value can have {attribute} for the first value of an attribute on the object and {ou:1} for the OU at the
+// top of the path (2 for the one below it and so on, -1 for the one the object is in). The first rule for an attribute
+// that matches the object and has a value sets it, so put the defaults last:
+//
+//	_businessunit	{ou:1}
+//	_environment	production	(name=PRD-*)
+//	_environment	test
+var MetaRulesFile string
+
+type metaRule struct {
+	attribute Attribute
+	value     string
+	query     Query
+}
+
+var metaRules []metaRule
+
+// The rules as read, for the warm start key
+var metaRulesText string
+
+// Reads the rules, the attributes have to start with _ so they don't clash with the ones from the directory
+func LoadMetaRules(filename string) error {
+	metaRules, metaRulesText = nil, ""
+	if filename == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	metaRulesText = string(data)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("line %v: expected attribute, value and an optional query separated by tabs", i+1)
+		}
+		name := strings.TrimSpace(fields[0])
+		if !strings.HasPrefix(name, "_") || len(name) < 2 {
+			return fmt.Errorf("line %v: custom attribute %v has to start with _", i+1, name)
+		}
+		rule := metaRule{
+			attribute: NewAttribute(name),
+			value:     strings.TrimSpace(fields[1]),
+		}
+		if len(fields) == 3 && strings.TrimSpace(fields[2]) != "" {
+			if rule.query, err = ParseQueryStrict(strings.TrimSpace(fields[2])); err != nil {
+				return fmt.Errorf("line %v: problem parsing query: %v", i+1, err)
+			}
+		}
+		metaRules = append(metaRules, rule)
+	}
+	return nil
+}
+
+// Returns the OUs in the path of the DN, the top one first
+func dnOUs(dn string) []string {
+	var ous []string
+	for _, rdn := range strings.Split(dn, ",") {
+		if len(rdn) > 3 && strings.EqualFold(rdn[:3], "ou=") {
+			ous = append([]string{rdn[3:]}, ous...)
+		}
+	}
+	return ous
+}
+
+// Fills in the placeholders in the value, returning false if one of them has nothing for the object
+func expandMetaValue(o *Object, value string) (string, bool) {
+	var result strings.Builder
+	for {
+		start := strings.Index(value, "{")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(value[start:], "}")
+		if end == -1 {
+			break
+		}
+		result.WriteString(value[:start])
+		placeholder := value[start+1 : start+end]
+		value = value[start+end+1:]
+
+		var expanded string
+		if strings.HasPrefix(strings.ToLower(placeholder), "ou:") {
+			ous := dnOUs(o.DN())
+			n, err := strconv.Atoi(placeholder[3:])
+			if err == nil && n > 0 && n <= len(ous) {
+				expanded = ous[n-1]
+			} else if err == nil && n < 0 && -n <= len(ous) {
+				expanded = ous[len(ous)+n]
+			}
+		} else if attribute := A(placeholder); attribute != NonExistingAttribute {
+			expanded = o.OneAttr(attribute)
+		}
+		if expanded == "" {
+			return "", false
+		}
+		result.WriteString(expanded)
+	}
+	result.WriteString(value)
+	return result.String(), result.Len() > 0
+}
+
+// Sets the custom meta attributes on all objects
+func applyMetaRules() {
+	if len(metaRules) == 0 {
+		return
+	}
+	var count int
+	for _, o := range AllObjects.AsArray() {
+		done := make(map[Attribute]struct{})
+		for _, rule := range metaRules {
+			if _, found := done[rule.attribute]; found {
+				continue
+			}
+			if rule.query != nil && !rule.query.Evaluate(o) {
+				continue
+			}
+			if value, ok := expandMetaValue(o, rule.value); ok {
+				o.SetAttr(rule.attribute, value)
+				done[rule.attribute] = struct{}{}
+				count++
+			}
+		}
+	}
+	log.Info().Msgf("Set %v custom meta attributes from %v rules", count, len(metaRules))
+}
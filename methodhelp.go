@@ -0,0 +1,361 @@
+package main
+
+// What each method means, how it's used and how to get rid of it, for the UI. The description of a method is the one
+// on its analyzer when it has one, the ones here are for methods set elsewhere
+
+type PwnMethodHelp struct {
+	Description string   `json:"description"`
+	Abuse       string   `json:"abuse,omitempty"`
+	Remediation string   `json:"remediation,omitempty"`
+	References  []string `json:"references,omitempty"`
+}
+
+var pwnMethodHelp = map[PwnMethod]PwnMethodHelp{
+	PwnCreateUser: {
+		Description: "Can create user objects in the container or OU",
+		Abuse:       "Create a user and use it as a foothold, or get a policy or permission that applies to everything in the OU applied to it",
+		Remediation: "Remove Create User objects for everyone but the admins of the OU",
+		References:  []string{"https://attack.mitre.org/techniques/T1136/002/"},
+	},
+	PwnCreateGroup: {
+		Description: "Can create group objects in the container or OU",
+		Abuse:       "Create a group that gets a permission or a policy that applies to everything in the OU",
+		Remediation: "Remove Create Group objects for everyone but the admins of the OU",
+	},
+	PwnCreateComputer: {
+		Description: "Can create computer objects in the container or OU, or any computer account when ms-DS-MachineAccountQuota is above 0",
+		Abuse:       "Create a computer account with a known password (PowerMad, impacket addcomputer.py) and use it for resource based constrained delegation or relaying",
+		Remediation: "Remove Create Computer objects for everyone but those joining computers to the domain, and set ms-DS-MachineAccountQuota to 0",
+		References:  []string{"https://attack.mitre.org/techniques/T1136/002/"},
+	},
+	PwnCreateAnyObject: {
+		Description: "Can create objects of any class in the container or OU",
+		Abuse:       "Create users, groups or computers to use as footholds",
+		Remediation: "Remove Create All Child Objects for everyone but the admins of the OU",
+	},
+	PwnDeleteChildrenTarget: {
+		Description: "Can delete objects in the container or OU, which can be used to make room for an object under the attackers control",
+		Abuse:       "Delete an object and create one with the same name that the attacker controls",
+		Remediation: "Remove Delete All Child Objects for everyone but the admins of the OU",
+	},
+	PwnDeleteObject: {
+		Description: "Can delete the object",
+		Abuse:       "Delete the object and create one with the same name that the attacker controls, or disrupt what depends on it",
+		Remediation: "Remove Delete for everyone but the admins of the object",
+	},
+	PwnInheritsSecurity: {
+		Description: "Object inherits the permissions of the container or OU it is in, so those who can change the permissions there can change them here",
+		Abuse:       "Add an inheritable ACE on the container or OU that gives full control of the objects in it",
+		Remediation: "Protect the permissions on the container or OU, or turn off inheritance on objects that need other permissions",
+	},
+	PwnACLContainsDeny: {
+		Description: "The permissions on the object have deny entries, which are used before the allow entries and may stop some of the other connections to it",
+		Remediation: "Check that the deny entries do what was meant, as adalanche doesn't evaluate them for every principal",
+	},
+	PwnResetPassword: {
+		Description: "Can reset the password of the account without knowing the old one",
+		Abuse:       "Set a new password (net user /domain, Set-ADAccountPassword, rpcclient setuserinfo2) and log on as the account",
+		Remediation: "Remove the Reset Password extended right for everyone but helpdesk and admins of the account",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnOwns: {
+		Description: "Owns the object, and owners can always change the permissions on it",
+		Abuse:       "Give yourself full control in the permissions of the object, then use it like GenericAll",
+		Remediation: "Set the owner to Domain Admins or the admins of the object",
+		References:  []string{"https://attack.mitre.org/techniques/T1222/001/"},
+	},
+	PwnGenericAll: {
+		Description: "Has full control of the object",
+		Abuse:       "Reset the password of a user, add members to a group, write msDS-AllowedToActOnBehalfOfOtherIdentity on a computer or change its permissions",
+		Remediation: "Remove Full Control for everyone but the admins of the object",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnWriteAll: {
+		Description: "Can write every attribute of the object (Generic Write)",
+		Abuse:       "Add members to a group, set a script path or SPN on a user, or write msDS-KeyCredentialLink or msDS-AllowedToActOnBehalfOfOtherIdentity",
+		Remediation: "Remove Generic Write for everyone but the admins of the object",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnWritePropertyAll: {
+		Description: "Can write all properties of the object",
+		Abuse:       "Like WriteAll, add members to a group or write the attributes that let you log on as a user or computer",
+		Remediation: "Remove Write All Properties for everyone but the admins of the object",
+	},
+	PwnTakeOwnership: {
+		Description: "Can make itself the owner of the object, and owners can always change the permissions on it",
+		Abuse:       "Take ownership (Set-DomainObjectOwner, impacket owneredit.py), give yourself full control and use it like GenericAll",
+		Remediation: "Remove Modify Owner for everyone but the admins of the object",
+		References:  []string{"https://attack.mitre.org/techniques/T1222/001/"},
+	},
+	PwnWriteDACL: {
+		Description: "Can change the permissions on the object",
+		Abuse:       "Give yourself full control (Add-DomainObjectAcl, impacket dacledit.py) and use it like GenericAll, or give yourself DCSync on a domain",
+		Remediation: "Remove Modify Permissions for everyone but the admins of the object",
+		References:  []string{"https://attack.mitre.org/techniques/T1222/001/"},
+	},
+	PwnWriteSPN: {
+		Description: "Can write servicePrincipalName on the account",
+		Abuse:       "Add an SPN to a user, get a service ticket for it and crack the password offline (targeted Kerberoasting)",
+		Remediation: "Remove write access to servicePrincipalName for everyone but the admins of the account",
+		References:  []string{"https://attack.mitre.org/techniques/T1558/003/"},
+	},
+	PwnWriteValidatedSPN: {
+		Description: "Can write servicePrincipalName on the account within the rules of Validated write to service principal name",
+		Abuse:       "Add an SPN to the account, get a service ticket for it and crack the password offline",
+		Remediation: "Remove Validated write to service principal name for everyone but the account itself and its admins",
+		References:  []string{"https://attack.mitre.org/techniques/T1558/003/"},
+	},
+	PwnWriteAllowedToAct: {
+		Abuse:       "Create or take over an account with an SPN, put it in msDS-AllowedToActOnBehalfOfOtherIdentity and get tickets to the computer as any user with S4U (Rubeus s4u, impacket getST.py)",
+		Remediation: "Remove write access to msDS-AllowedToActOnBehalfOfOtherIdentity, put admins in Protected Users and set ms-DS-MachineAccountQuota to 0",
+		References:  []string{"https://shenaniganslabs.io/2019/01/28/Wagging-the-Dog.html"},
+	},
+	PwnAddMember: {
+		Description: "Can add members to the group",
+		Abuse:       "Add yourself to the group (net group /add /domain, Add-ADGroupMember) and get what the group has",
+		Remediation: "Remove write access to member for everyone but the admins of the group",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnAddMemberGroupAttr: {
+		Description: "Can add members to the group through the Membership property set",
+		Abuse:       "Add yourself to the group and get what the group has",
+		Remediation: "Remove write access to the Membership property set for everyone but the admins of the group",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnAddSelfMember: {
+		Description: "Can add itself to the group (Validated write to group membership)",
+		Abuse:       "Add yourself to the group and get what the group has",
+		Remediation: "Remove Add/remove self as member for everyone that shouldn't be able to join the group",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnReadMSAPassword: {
+		Abuse:       "Read msDS-ManagedPassword (gMSADumper, GMSAPasswordReader) and use the NT hash of the account",
+		Remediation: "Only put the computers running the service in msDS-GroupMSAMembership",
+		References:  []string{"https://learn.microsoft.com/en-us/windows-server/security/group-managed-service-accounts/group-managed-service-accounts-overview"},
+	},
+	PwnHasMSA: {
+		Description: "Computer runs as the standalone managed service account, so it has its password",
+		Abuse:       "Take over the computer and dump the password of the account from the LSA secrets",
+		Remediation: "Treat the computer as at least as important as the account",
+		References:  []string{"https://attack.mitre.org/techniques/T1003/004/"},
+	},
+	PwnWriteKeyCredentialLink: {
+		Abuse:       "Add a key (Whisker, Certipy shadow, pyWhisker), get a TGT for the account with PKINIT and the NT hash with it",
+		Remediation: "Remove write access to msDS-KeyCredentialLink for everyone but the account itself, and remove keys that Windows didn't add",
+		References:  []string{"https://posts.specterops.io/shadow-credentials-abusing-key-trust-account-mapping-for-takeover-8ee1a53566ab"},
+	},
+	PwnWriteAttributeSecurityGUID: {
+		Abuse:       "Move a protected attribute into a property set you can write, then write it",
+		Remediation: "Remove write access to attributeSecurityGUID on schema attributes for everyone but Schema Admins",
+	},
+	PwnSIDHistoryEquality: {
+		Abuse:       "Log on as the account, the SIDs in sIDHistory are in the logon token",
+		Remediation: "Clear sIDHistory after migrations (Set-ADUser -Remove @{sIDHistory=...}) and have SID filtering on trusts",
+		References:  []string{"https://attack.mitre.org/techniques/T1134/005/"},
+	},
+	PwnAllExtendedRights: {
+		Description: "Has all extended rights on the object, which includes resetting passwords and on a domain replicating secrets",
+		Abuse:       "Reset the password of a user, read LAPS passwords on a computer or DCSync a domain",
+		Remediation: "Remove All Extended Rights for everyone but the admins of the object",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnCanDCSync: {
+		Abuse:       "Get the hashes of every account (mimikatz lsadump::dcsync, impacket secretsdump.py -just-dc) and forge tickets with the krbtgt hash",
+		Remediation: "Only domain controllers, Administrators, Domain Admins and Enterprise Admins should have both replication rights on the domain",
+		References:  []string{"https://attack.mitre.org/techniques/T1003/006/"},
+	},
+	PwnReadLAPSPassword: {
+		Description: "Can read the local administrator password set by LAPS on the computer",
+		Abuse:       "Read ms-Mcs-AdmPwd or msLAPS-Password (Get-LapsADPassword, LAPSToolkit) and log on to the computer as the local administrator",
+		Remediation: "Only give the read or decrypt password rights to the admins of the computer",
+		References:  []string{"https://learn.microsoft.com/en-us/windows-server/identity/laps/laps-overview"},
+	},
+	PwnMemberOfGroup: {
+		Description: "Is a member of the group, and has everything the group has",
+		Remediation: "Remove members that don't need what the group has",
+	},
+	PwnHasSPN: {
+		Abuse:       "Get a service ticket for the account (Rubeus kerberoast, impacket GetUserSPNs.py) and crack it (hashcat -m 13100)",
+		Remediation: "Use long random passwords or group managed service accounts, and only allow AES for the account",
+		References:  []string{"https://attack.mitre.org/techniques/T1558/003/"},
+	},
+	PwnHasSPNNoPreauth: {
+		Abuse:       "Ask for a TGT for the account (Rubeus asreproast, impacket GetNPUsers.py) and crack it (hashcat -m 18200)",
+		Remediation: "Turn on Kerberos preauthentication for the account",
+		References:  []string{"https://attack.mitre.org/techniques/T1558/004/"},
+	},
+	PwnAdminSDHolderOverwriteACL: {
+		Description: "Protected accounts and groups (adminCount=1) get the permissions of AdminSDHolder every hour, so whoever can change AdminSDHolder controls all of them",
+		Abuse:       "Add an ACE to AdminSDHolder and wait for SDProp to copy it to Domain Admins and the other protected objects",
+		Remediation: "Only Domain Admins, Enterprise Admins and Administrators should be able to change AdminSDHolder, and check it for extra ACEs",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnComputerAffectedByGPO: {
+		Abuse:       "Add a scheduled task or startup script to the GPO (SharpGPOAbuse, pyGPOAbuse) and wait for the computer to apply it",
+		Remediation: "Only the admins of the computers should be able to change the GPOs linked to them",
+		References:  []string{"https://attack.mitre.org/techniques/T1484/001/"},
+	},
+	PwnGPOMachineConfigPartOfGPO: {
+		Description: "Machine part of the GPO in SYSVOL, so whoever can change the files changes the GPO",
+		Abuse:       "Change the files of the GPO in SYSVOL and wait for the computers to apply it",
+		Remediation: "Only the admins of the GPO should be able to write to its folder in SYSVOL",
+		References:  []string{"https://attack.mitre.org/techniques/T1484/001/"},
+	},
+	PwnGPOUserConfigPartOfGPO: {
+		Description: "User part of the GPO in SYSVOL, so whoever can change the files changes the GPO",
+		Abuse:       "Change the files of the GPO in SYSVOL and wait for the users to log on",
+		Remediation: "Only the admins of the GPO should be able to write to its folder in SYSVOL",
+		References:  []string{"https://attack.mitre.org/techniques/T1484/001/"},
+	},
+	PwnLocalAdminRights: {
+		Abuse:       "Log on to the computer as an admin (PsExec, WMI, WinRM) and dump the credentials there",
+		Remediation: "Remove broad groups from the local Administrators group, and use LAPS for the local administrator",
+		References:  []string{"https://attack.mitre.org/techniques/T1078/002/"},
+	},
+	PwnLocalRDPRights: {
+		Abuse:       "Log on with Remote Desktop and escalate locally, or take over sessions of others",
+		Remediation: "Only give Remote Desktop access to those who need it",
+		References:  []string{"https://attack.mitre.org/techniques/T1021/001/"},
+	},
+	PwnLocalDCOMRights: {
+		Abuse:       "Run code through DCOM objects like MMC20.Application (impacket dcomexec.py)",
+		Remediation: "Only give Distributed COM Users membership to those who need it",
+		References:  []string{"https://attack.mitre.org/techniques/T1021/003/"},
+	},
+	PwnWriteProfileOrHomeDir: {
+		Abuse:       "Put a shortcut, script or DLL in the profile, home directory or mapped drive that the user will load, or a driver on the mapped print share",
+		Remediation: "Only the user and admins should be able to write to the profile and home directory, and only admins to shares mapped by Group Policy",
+	},
+	PwnHostsProfileOrHomeDir: {
+		Abuse:       "As an admin on the file or print server, put files in the profile, home directory or mapped drive that the user will load",
+		Remediation: "Host profiles and home directories of admins on servers in the same tier",
+	},
+	PwnSamePersonHeuristic: {
+		Abuse:       "Try the password of one account on the other, or use the mailbox of one to reset the other",
+		Remediation: "Admins should have separate passwords for each account and forest",
+	},
+	PwnForeignIdentity: {
+		Abuse:       "Use the account in the trusted domain to get what it was given here",
+		Remediation: "Remove permissions and memberships given to principals in other domains that don't need them, and use selective authentication",
+		References:  []string{"https://attack.mitre.org/techniques/T1482/"},
+	},
+	PwnDomainTrust: {
+		Abuse:       "Use accounts in the trusted domain to enumerate and log on to the trusting domain, and look for what they were given there",
+		Remediation: "Remove trusts that are no longer needed, and use selective authentication and SID filtering on the ones that are",
+		References:  []string{"https://attack.mitre.org/techniques/T1482/"},
+	},
+	PwnTrustAbuse: {
+		Abuse:       "As an admin in the trusted domain, forge a golden or inter-realm ticket with the SID of Enterprise Admins or another admin group in the trusting domain as extra SID (mimikatz kerberos::golden /sids, impacket ticketer.py -extra-sid)",
+		Remediation: "Enable SID filtering (netdom trust /quarantine or /enablesidhistory:no) on trusts to other forests. Domains in the same forest are one security boundary, so treat the admins of each of them as admins of all of them",
+		References:  []string{"https://attack.mitre.org/techniques/T1134/005/"},
+	},
+	PwnIdPAdmin: {
+		Abuse:       "Reset the password or MFA of the account in the identity provider and log on as it",
+		Remediation: "Limit the admins in the identity provider, and keep privileged AD accounts out of it",
+		References:  []string{"https://attack.mitre.org/techniques/T1078/004/"},
+	},
+	PwnAWSIdentityCenter: {
+		Abuse:       "Log on to AWS through IAM Identity Center with the account and use the permission set",
+		Remediation: "Limit who is in the groups that are assigned admin permission sets",
+		References:  []string{"https://attack.mitre.org/techniques/T1078/004/"},
+	},
+	PwnRunsServicesOn: {
+		Abuse:       "Take over the account and log on to the computer as it",
+		Remediation: "Run services as group managed service accounts or virtual accounts instead of users",
+	},
+	PwnCanDumpCredsOf: {
+		Abuse:       "As an admin on the computer, dump the LSA secrets (mimikatz lsadump::secrets, impacket secretsdump.py) for the password of the service account",
+		Remediation: "Run services as group managed service accounts or virtual accounts, and don't run services as admins on lower tier computers",
+		References:  []string{"https://attack.mitre.org/techniques/T1003/004/"},
+	},
+	PwnCanStealCredentialsOf: {
+		Abuse:       "As an admin on the computer, dump LSASS (mimikatz sekurlsa::logonpasswords, procdump) for the hashes and tickets of the logged on account",
+		Remediation: "Don't log on to lower tier computers with admin accounts, and put admins in Protected Users",
+		References:  []string{"https://attack.mitre.org/techniques/T1003/001/"},
+	},
+	PwnEntraSync: {
+		Abuse:       "Take over the AD object and let Entra Connect sync it, or change its password with password hash sync turned on",
+		Remediation: "Don't sync privileged AD accounts, and use cloud only accounts for Entra ID admin roles",
+		References:  []string{"https://attack.mitre.org/techniques/T1078/004/"},
+	},
+	PwnEntraConnect: {
+		Abuse:       "As an admin on the Entra Connect server, get the credentials of the sync accounts (AADInternals) and use them to reset passwords or DCSync",
+		Remediation: "Treat the Entra Connect server as tier 0",
+	},
+	PwnEntraPRT: {
+		Abuse:       "As an admin on the device, steal the primary refresh token of the user (ROADtoken, AADInternals) and use it to sign in to Entra ID",
+		Remediation: "Don't sign in to lower tier devices with admin accounts, and require compliant devices for admin roles",
+		References:  []string{"https://attack.mitre.org/techniques/T1550/001/"},
+	},
+	PwnEntraRoleAdmin: {
+		Abuse:       "Reset the password or authentication methods of the user in Entra ID and sign in as them",
+		Remediation: "Limit the admin roles, and use Privileged Identity Management and administrative units",
+		References:  []string{"https://attack.mitre.org/techniques/T1098/"},
+	},
+	PwnADCSESC1: {
+		Abuse:       "Request a certificate for a domain admin (Certipy req -upn, Certify request /altname) and log on with it",
+		Remediation: "Turn off Supply in the request or require manager approval, and remove enrollment rights for broad groups",
+		References:  []string{"https://posts.specterops.io/certified-pre-owned-d95910965cd2"},
+	},
+	PwnADCSESC4: {
+		Abuse:       "Change the template so it's vulnerable to ESC1 (Certipy template), or publish templates on the CA, then request a certificate as anyone",
+		Remediation: "Only the PKI admins should be able to change templates, CAs and the PKI objects in the configuration partition",
+		References:  []string{"https://posts.specterops.io/certified-pre-owned-d95910965cd2"},
+	},
+	PwnADCSESC8: {
+		Abuse:       "Coerce a domain controller to authenticate (PetitPotam, printer bug) and relay it to the web enrollment (impacket ntlmrelayx.py --adcs) for a certificate as the domain controller",
+		Remediation: "Turn off HTTP web enrollment or require HTTPS with Extended Protection for Authentication",
+		References:  []string{"https://posts.specterops.io/certified-pre-owned-d95910965cd2", "https://attack.mitre.org/techniques/T1187/"},
+	},
+	PwnADCSNTAuth: {
+		Abuse:       "Add the certificate of a CA you made to NTAuthCertificates (certutil -dspublish -f ca.crt NTAuthCA), then forge a logon certificate for anyone with it",
+		Remediation: "Only the PKI admins should be able to change NTAuthCertificates, and watch it for new certificates",
+		References:  []string{"https://posts.specterops.io/certified-pre-owned-d95910965cd2"},
+	},
+	PwnSCCMManages: {
+		Abuse:       "With control of the site server, deploy an application or script to the clients (SharpSCCM exec)",
+		Remediation: "Treat the site server as important as the most important client, and put tier 0 computers in a site of their own",
+		References:  []string{"https://github.com/subat0mik/Misconfiguration-Manager"},
+	},
+	PwnUserAffectedByGPO: {
+		Abuse:       "Add a logon script or scheduled task to the GPO (SharpGPOAbuse, pyGPOAbuse) and wait for the user to log on",
+		Remediation: "Only the admins of the users should be able to change the GPOs linked to them",
+		References:  []string{"https://attack.mitre.org/techniques/T1484/001/"},
+	},
+	PwnCanDelegateTo: {
+		Abuse:       "With the password or hash of the account, get a ticket as an admin to the service and rewrite it for cifs or host on the same computer (Rubeus s4u /altservice, impacket getST.py -impersonate)",
+		Remediation: "Remove delegation that isn't needed, use Kerberos only instead of protocol transition, and mark admin accounts as sensitive and can't be delegated or put them in Protected Users",
+		References:  []string{"https://attack.mitre.org/techniques/T1558/"},
+	},
+	PwnCoerceToTGT: {
+		Abuse:       "Watch for tickets on the host (Rubeus monitor, krbrelayx), make the computer authenticate to it (SpoolSample, PetitPotam, Coercer) and use its TGT - for a domain controller, DCSync with it",
+		Remediation: "Replace unconstrained delegation with constrained or resource based delegation, turn off the Print Spooler on domain controllers, and mark admin accounts as sensitive and can't be delegated",
+		References:  []string{"https://attack.mitre.org/techniques/T1187/", "https://attack.mitre.org/techniques/T1558/"},
+	},
+	PwnExchangeWriteDACL: {
+		Abuse:       "As a member or an Exchange server, give an account both replication rights on the domain (impacket ntlmrelayx.py --escalate-user, PrivExchange) and DCSync",
+		Remediation: "Install the Exchange updates from 2019 or later and run setup /PrepareAD, or use split permissions",
+		References:  []string{"https://dirkjanm.io/abusing-exchange-one-api-call-away-from-domain-admin/"},
+	},
+	PwnMailboxFullAccess: {
+		Abuse:       "Open the mailbox (Outlook, EWS, MailSniper) and read the password reset mails and codes, or reset passwords that mail a link",
+		Remediation: "Remove mailbox permissions that aren't needed, especially on the mailboxes of admins",
+		References:  []string{"https://attack.mitre.org/techniques/T1114/002/"},
+	},
+}
+
+// The description from the analyzer of the method, or from the help
+func (m PwnMethod) Description() string {
+	for _, analyzer := range PwnAnalyzers {
+		if analyzer.Method == m && analyzer.Description != "" {
+			return analyzer.Description
+		}
+	}
+	return pwnMethodHelp[m].Description
+}
+
+func (m PwnMethod) Help() PwnMethodHelp {
+	help := pwnMethodHelp[m]
+	help.Description = m.Description()
+	return help
+}
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gofrs/uuid"
+)
+
+// .NET Binary Format: XML Data Structure (MC-NBFX), the binary XML that Windows Communication Foundation services
+// like ADWS talk. Only what's needed to talk to them is here: requests are written with inline strings, and
+// responses are read into a tree of elements
+
+// XML element read from binary XML. Names are with the prefix, as namespaces aren't resolved. Names from the static
+// dictionary that isn't included here come out as "static:<id>", which doesn't matter for the parts of the responses
+// that are used
+type xmlNode struct {
+	Name       string
+	Attributes map[string]string
+	Text       string
+	Binary     bool // Text is raw bytes from a bytes record
+	Children   []*xmlNode
+}
+
+// Returns the name without the prefix
+func (n *xmlNode) LocalName() string {
+	if i := strings.LastIndex(n.Name, ":"); i != -1 {
+		return n.Name[i+1:]
+	}
+	return n.Name
+}
+
+// Returns the first child with the local name, or nil
+func (n *xmlNode) Child(localname string) *xmlNode {
+	for _, child := range n.Children {
+		if child.LocalName() == localname {
+			return child
+		}
+	}
+	return nil
+}
+
+// Returns the text of the element and everything in it, for error messages
+func (n *xmlNode) AllText() string {
+	texts := []string{}
+	if text := strings.TrimSpace(n.Text); text != "" && !n.Binary {
+		texts = append(texts, text)
+	}
+	for _, child := range n.Children {
+		if text := child.AllText(); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// Attribute with the local name, regardless of prefix
+func (n *xmlNode) Attribute(localname string) (string, bool) {
+	for name, value := range n.Attributes {
+		if name == localname || strings.HasSuffix(name, ":"+localname) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Record types (MC-NBFX section 2.2). Text records have a variant one higher that ends the element as well
+const (
+	nbfxEndElement                    = 0x01
+	nbfxComment                       = 0x02
+	nbfxArray                         = 0x03
+	nbfxShortAttribute                = 0x04
+	nbfxAttribute                     = 0x05
+	nbfxShortDictionaryAttribute      = 0x06
+	nbfxDictionaryAttribute           = 0x07
+	nbfxShortXmlnsAttribute           = 0x08
+	nbfxXmlnsAttribute                = 0x09
+	nbfxShortDictionaryXmlnsAttribute = 0x0a
+	nbfxDictionaryXmlnsAttribute      = 0x0b
+	nbfxPrefixDictionaryAttributeA    = 0x0c
+	nbfxPrefixAttributeA              = 0x26
+	nbfxShortElement                  = 0x40
+	nbfxElement                       = 0x41
+	nbfxShortDictionaryElement        = 0x42
+	nbfxDictionaryElement             = 0x43
+	nbfxPrefixDictionaryElementA      = 0x44
+	nbfxPrefixElementA                = 0x5e
+	nbfxZeroText                      = 0x80
+	nbfxOneText                       = 0x82
+	nbfxFalseText                     = 0x84
+	nbfxTrueText                      = 0x86
+	nbfxInt8Text                      = 0x88
+	nbfxInt16Text                     = 0x8a
+	nbfxInt32Text                     = 0x8c
+	nbfxInt64Text                     = 0x8e
+	nbfxFloatText                     = 0x90
+	nbfxDoubleText                    = 0x92
+	nbfxDecimalText                   = 0x94
+	nbfxDateTimeText                  = 0x96
+	nbfxChars8Text                    = 0x98
+	nbfxChars16Text                   = 0x9a
+	nbfxChars32Text                   = 0x9c
+	nbfxBytes8Text                    = 0x9e
+	nbfxBytes16Text                   = 0xa0
+	nbfxBytes32Text                   = 0xa2
+	nbfxStartListText                 = 0xa4
+	nbfxEndListText                   = 0xa6
+	nbfxEmptyText                     = 0xa8
+	nbfxDictionaryText                = 0xaa
+	nbfxUniqueIdText                  = 0xac
+	nbfxTimeSpanText                  = 0xae
+	nbfxUuidText                      = 0xb0
+	nbfxUInt64Text                    = 0xb2
+	nbfxBoolText                      = 0xb4
+	nbfxUnicodeChars8Text             = 0xb6
+	nbfxUnicodeChars16Text            = 0xb8
+	nbfxUnicodeChars32Text            = 0xba
+	nbfxQNameDictionaryText           = 0xbc
+)
+
+// Writes a MultiByteInt31, 7 bits at a time with the high bit set on all but the last byte. NMF uses the same
+func appendMultiByteInt31(buffer []byte, value int) []byte {
+	for value >= 0x80 {
+		buffer = append(buffer, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(buffer, byte(value))
+}
+
+func readMultiByteInt31(r io.ByteReader) (int, error) {
+	var value int
+	for shift := 0; shift < 35; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+	return 0, errors.New("MultiByteInt31 is too long")
+}
+
+func appendNBFXString(buffer []byte, s string) []byte {
+	return append(appendMultiByteInt31(buffer, len(s)), s...)
+}
+
+// Converts an XML document to binary XML, using inline strings for all names and text. Whitespace between
+// elements is dropped
+func encodeNBFX(document string) ([]byte, error) {
+	decoder := xml.NewDecoder(strings.NewReader(document))
+	var result []byte
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case t.Name.Space == "":
+				result = appendNBFXString(append(result, nbfxShortElement), t.Name.Local)
+			case len(t.Name.Space) == 1 && t.Name.Space[0] >= 'a' && t.Name.Space[0] <= 'z':
+				result = appendNBFXString(append(result, nbfxPrefixElementA+t.Name.Space[0]-'a'), t.Name.Local)
+			default:
+				result = appendNBFXString(appendNBFXString(append(result, nbfxElement), t.Name.Space), t.Name.Local)
+			}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					result = appendNBFXString(append(result, nbfxShortXmlnsAttribute), attr.Value)
+					continue
+				case attr.Name.Space == "xmlns":
+					result = appendNBFXString(appendNBFXString(append(result, nbfxXmlnsAttribute), attr.Name.Local), attr.Value)
+					continue
+				case attr.Name.Space == "":
+					result = appendNBFXString(append(result, nbfxShortAttribute), attr.Name.Local)
+				default:
+					result = appendNBFXString(appendNBFXString(append(result, nbfxAttribute), attr.Name.Space), attr.Name.Local)
+				}
+				result = appendNBFXText(result, attr.Value)
+			}
+		case xml.CharData:
+			if text := string(t); strings.TrimSpace(text) != "" {
+				result = appendNBFXText(result, text)
+			}
+		case xml.EndElement:
+			result = append(result, nbfxEndElement)
+		}
+	}
+}
+
+func appendNBFXText(buffer []byte, text string) []byte {
+	switch {
+	case len(text) < 1<<8:
+		buffer = append(buffer, nbfxChars8Text, byte(len(text)))
+	case len(text) < 1<<16:
+		buffer = append(buffer, nbfxChars16Text, byte(len(text)), byte(len(text)>>8))
+	default:
+		buffer = append(buffer, nbfxChars32Text, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(buffer[len(buffer)-4:], uint32(len(text)))
+	}
+	return append(buffer, text...)
+}
+
+// Reads binary XML into a tree, with names from the session dictionary (strings the other end has sent earlier in
+// the session, with odd ids)
+type nbfxReader struct {
+	data    *bytes.Reader
+	session []string
+}
+
+func decodeNBFX(data []byte, session []string) (*xmlNode, error) {
+	r := &nbfxReader{data: bytes.NewReader(data), session: session}
+	root := &xmlNode{}
+	if err := r.readContent(root); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(root.Children) == 0 {
+		return nil, errors.New("Binary XML without any elements")
+	}
+	return root.Children[0], nil
+}
+
+func (r *nbfxReader) dictionaryString() (string, error) {
+	id, err := readMultiByteInt31(r.data)
+	if err != nil {
+		return "", err
+	}
+	if id&1 == 0 {
+		return "static:" + strconv.Itoa(id>>1), nil
+	}
+	if id>>1 >= len(r.session) {
+		return "", fmt.Errorf("Session dictionary string %v is not known", id)
+	}
+	return r.session[id>>1], nil
+}
+
+func (r *nbfxReader) inlineString() (string, error) {
+	length, err := readMultiByteInt31(r.data)
+	if err != nil {
+		return "", err
+	}
+	return r.chars(length)
+}
+
+func (r *nbfxReader) chars(length int) (string, error) {
+	if length > r.data.Len() {
+		return "", io.ErrUnexpectedEOF
+	}
+	buffer := make([]byte, length)
+	_, err := io.ReadFull(r.data, buffer)
+	return string(buffer), err
+}
+
+func (r *nbfxReader) fixed(length int) ([]byte, error) {
+	buffer := make([]byte, length)
+	_, err := io.ReadFull(r.data, buffer)
+	return buffer, err
+}
+
+func prefixed(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + ":" + name
+}
+
+// Reads the content of parent until its end element (or the end of the data for the document itself)
+func (r *nbfxReader) readContent(parent *xmlNode) error {
+	for {
+		recordtype, err := r.data.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case recordtype == nbfxEndElement:
+			return nil
+		case recordtype == nbfxComment:
+			if _, err = r.inlineString(); err != nil {
+				return err
+			}
+		case recordtype == nbfxArray:
+			if err = r.readArray(parent); err != nil {
+				return err
+			}
+		case recordtype >= nbfxShortElement && recordtype <= nbfxPrefixElementA+25:
+			element, err := r.readElement(recordtype)
+			if err != nil {
+				return err
+			}
+			parent.Children = append(parent.Children, element)
+			if err = r.readContent(element); err != nil {
+				return err
+			}
+		case recordtype >= nbfxZeroText && recordtype <= nbfxQNameDictionaryText+1:
+			text, binary, err := r.readText(recordtype &^ 1)
+			if err != nil {
+				return err
+			}
+			parent.Text += text
+			parent.Binary = parent.Binary || binary
+			if recordtype&1 != 0 {
+				return nil
+			}
+		default:
+			return fmt.Errorf("Unexpected binary XML record type 0x%02x", recordtype)
+		}
+	}
+}
+
+// Reads an element record and the attribute records following it
+func (r *nbfxReader) readElement(recordtype byte) (*xmlNode, error) {
+	var prefix, name string
+	var err error
+	switch {
+	case recordtype == nbfxShortElement:
+		name, err = r.inlineString()
+	case recordtype == nbfxElement:
+		if prefix, err = r.inlineString(); err == nil {
+			name, err = r.inlineString()
+		}
+	case recordtype == nbfxShortDictionaryElement:
+		name, err = r.dictionaryString()
+	case recordtype == nbfxDictionaryElement:
+		if prefix, err = r.inlineString(); err == nil {
+			name, err = r.dictionaryString()
+		}
+	case recordtype < nbfxPrefixElementA:
+		prefix = string(rune('a' + recordtype - nbfxPrefixDictionaryElementA))
+		name, err = r.dictionaryString()
+	default:
+		prefix = string(rune('a' + recordtype - nbfxPrefixElementA))
+		name, err = r.inlineString()
+	}
+	if err != nil {
+		return nil, err
+	}
+	element := &xmlNode{Name: prefixed(prefix, name), Attributes: make(map[string]string)}
+
+	for {
+		next, err := r.data.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if next < nbfxShortAttribute || next > nbfxPrefixAttributeA+25 {
+			r.data.UnreadByte()
+			return element, nil
+		}
+		var prefix, name string
+		xmlns := false
+		switch {
+		case next == nbfxShortAttribute:
+			name, err = r.inlineString()
+		case next == nbfxAttribute:
+			if prefix, err = r.inlineString(); err == nil {
+				name, err = r.inlineString()
+			}
+		case next == nbfxShortDictionaryAttribute:
+			name, err = r.dictionaryString()
+		case next == nbfxDictionaryAttribute:
+			if prefix, err = r.inlineString(); err == nil {
+				name, err = r.dictionaryString()
+			}
+		case next == nbfxShortXmlnsAttribute:
+			xmlns = true
+			_, err = r.inlineString()
+		case next == nbfxXmlnsAttribute:
+			xmlns = true
+			if _, err = r.inlineString(); err == nil {
+				_, err = r.inlineString()
+			}
+		case next == nbfxShortDictionaryXmlnsAttribute:
+			xmlns = true
+			_, err = r.dictionaryString()
+		case next == nbfxDictionaryXmlnsAttribute:
+			xmlns = true
+			if _, err = r.inlineString(); err == nil {
+				_, err = r.dictionaryString()
+			}
+		case next < nbfxPrefixAttributeA:
+			prefix = string(rune('a' + next - nbfxPrefixDictionaryAttributeA))
+			name, err = r.dictionaryString()
+		default:
+			prefix = string(rune('a' + next - nbfxPrefixAttributeA))
+			name, err = r.inlineString()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if xmlns {
+			// Namespaces aren't resolved, names are matched by their local part
+			continue
+		}
+		texttype, err := r.data.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		value, _, err := r.readText(texttype &^ 1)
+		if err != nil {
+			return nil, err
+		}
+		element.Attributes[prefixed(prefix, name)] = value
+	}
+}
+
+// Reads an array record: an element without attributes, and a number of values of one type that each make an
+// element like it
+func (r *nbfxReader) readArray(parent *xmlNode) error {
+	recordtype, err := r.data.ReadByte()
+	if err != nil {
+		return err
+	}
+	template, err := r.readElement(recordtype)
+	if err != nil {
+		return err
+	}
+	if end, err := r.data.ReadByte(); err != nil || end != nbfxEndElement {
+		return errors.New("Binary XML array element is not empty")
+	}
+	texttype, err := r.data.ReadByte()
+	if err != nil {
+		return err
+	}
+	count, err := readMultiByteInt31(r.data)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		text, binary, err := r.readText(texttype &^ 1)
+		if err != nil {
+			return err
+		}
+		parent.Children = append(parent.Children, &xmlNode{Name: template.Name, Attributes: template.Attributes, Text: text, Binary: binary})
+	}
+	return nil
+}
+
+// .NET ticks are 100 ns since year 1
+var dotnetEpoch = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func ticksToTime(ticks int64) time.Time {
+	// Too far apart for a single Duration
+	days := ticks / (24 * 3600 * 10000000)
+	return dotnetEpoch.AddDate(0, 0, int(days)).Add(time.Duration(ticks%(24*3600*10000000)) * 100)
+}
+
+// GUIDs are in the Windows byte order, like objectGUID
+func guidString(b []byte) string {
+	u, _ := uuid.FromBytes([]byte{b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6], b[8], b[9], b[10], b[11], b[12], b[13], b[14], b[15]})
+	return u.String()
+}
+
+// Reads the value of a text record as a string, with the record type without the end element bit. Bytes come back
+// raw and with binary set
+func (r *nbfxReader) readText(recordtype byte) (string, bool, error) {
+	var size int
+	switch recordtype {
+	case nbfxZeroText:
+		return "0", false, nil
+	case nbfxOneText:
+		return "1", false, nil
+	case nbfxFalseText:
+		return "false", false, nil
+	case nbfxTrueText:
+		return "true", false, nil
+	case nbfxEmptyText, nbfxStartListText, nbfxEndListText:
+		return "", false, nil
+	case nbfxInt8Text, nbfxBoolText:
+		size = 1
+	case nbfxInt16Text:
+		size = 2
+	case nbfxInt32Text, nbfxFloatText:
+		size = 4
+	case nbfxInt64Text, nbfxDoubleText, nbfxDateTimeText, nbfxTimeSpanText, nbfxUInt64Text:
+		size = 8
+	case nbfxDecimalText, nbfxUniqueIdText, nbfxUuidText:
+		size = 16
+	case nbfxChars8Text, nbfxBytes8Text, nbfxUnicodeChars8Text:
+		size = 1
+	case nbfxChars16Text, nbfxBytes16Text, nbfxUnicodeChars16Text:
+		size = 2
+	case nbfxChars32Text, nbfxBytes32Text, nbfxUnicodeChars32Text:
+		size = 4
+	case nbfxDictionaryText:
+		text, err := r.dictionaryString()
+		return text, false, err
+	case nbfxQNameDictionaryText:
+		prefix, err := r.data.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		name, err := r.dictionaryString()
+		return string(rune('a'+prefix)) + ":" + name, false, err
+	default:
+		return "", false, fmt.Errorf("Unexpected binary XML text record type 0x%02x", recordtype)
+	}
+	value, err := r.fixed(size)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Variable length ones have the length first
+	var length int
+	switch size {
+	case 1:
+		length = int(value[0])
+	case 2:
+		length = int(binary.LittleEndian.Uint16(value))
+	case 4:
+		length = int(int32(binary.LittleEndian.Uint32(value)))
+	}
+
+	switch recordtype {
+	case nbfxInt8Text:
+		return strconv.Itoa(int(int8(value[0]))), false, nil
+	case nbfxBoolText:
+		return strconv.FormatBool(value[0] != 0), false, nil
+	case nbfxInt16Text:
+		return strconv.Itoa(int(int16(length))), false, nil
+	case nbfxInt32Text:
+		return strconv.Itoa(length), false, nil
+	case nbfxInt64Text:
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(value)), 10), false, nil
+	case nbfxUInt64Text:
+		return strconv.FormatUint(binary.LittleEndian.Uint64(value), 10), false, nil
+	case nbfxFloatText:
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(value))), 'g', -1, 32), false, nil
+	case nbfxDoubleText:
+		return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(value)), 'g', -1, 64), false, nil
+	case nbfxDecimalText:
+		// Flags with the scale and sign, then 96 bits of value high part first
+		scale := int(value[2])
+		unscaled := new(big.Int).SetBytes([]byte{value[7], value[6], value[5], value[4], value[15], value[14], value[13], value[12], value[11], value[10], value[9], value[8]})
+		digits := unscaled.String()
+		if scale > 0 {
+			for len(digits) <= scale {
+				digits = "0" + digits
+			}
+			digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+		}
+		if value[3]&0x80 != 0 {
+			digits = "-" + digits
+		}
+		return digits, false, nil
+	case nbfxDateTimeText:
+		ticks := int64(binary.LittleEndian.Uint64(value) & 0x3fffffffffffffff)
+		return ticksToTime(ticks).Format(time.RFC3339Nano), false, nil
+	case nbfxTimeSpanText:
+		return (time.Duration(int64(binary.LittleEndian.Uint64(value))) * 100).String(), false, nil
+	case nbfxUniqueIdText:
+		return "urn:uuid:" + guidString(value), false, nil
+	case nbfxUuidText:
+		return guidString(value), false, nil
+	}
+
+	if length < 0 {
+		return "", false, errors.New("Negative length in binary XML text record")
+	}
+	switch recordtype {
+	case nbfxBytes8Text, nbfxBytes16Text, nbfxBytes32Text:
+		text, err := r.chars(length)
+		return text, true, err
+	case nbfxUnicodeChars8Text, nbfxUnicodeChars16Text, nbfxUnicodeChars32Text:
+		raw, err := r.chars(length)
+		if err != nil {
+			return "", false, err
+		}
+		runes := make([]uint16, len(raw)/2)
+		for i := range runes {
+			runes[i] = binary.LittleEndian.Uint16([]byte(raw[i*2:]))
+		}
+		return string(utf16.Decode(runes)), false, nil
+	}
+	text, err := r.chars(length)
+	return text, false, err
+}
+
+// Binary values in the text of an element, either as raw bytes or as base64
+func (n *xmlNode) Bytes() ([]byte, error) {
+	if n.Binary {
+		return []byte(n.Text), nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(n.Text))
+}
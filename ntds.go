@@ -0,0 +1,859 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Dumping from NTDS.dit, the database of a domain controller, like an IFM copy (ntdsutil "ifm create") or one from a
+// backup. The objects come out like they would from LDAP, so the analysis can't tell the difference.
+//
+// Every attribute is a column in the data table named ATT, a letter for the syntax and the internal id of the
+// attribute (ATTm589825 is name). The schema is in the same table, so that is read first to find the LDAP names.
+
+// Columns needed to read the schema itself
+const (
+	ntdsObjectClass     = "ATTc0"
+	ntdsName            = "ATTm589825"
+	ntdsAttributeID     = "ATTc131102"
+	ntdsAttributeSyntax = "ATTc131104"
+	ntdsGovernsID       = "ATTc131094"
+	ntdsLinkID          = "ATTj131122"
+	ntdsLDAPDisplayName = "ATTm131532"
+	ntdsIntID           = "ATTj591540" // msDS-IntId, schema extensions use this as column id instead of the attributeID
+)
+
+// Syntaxes of linked attributes where the values are more than a DN
+const (
+	ntdsSyntaxDNBinary = 0x80007 // 2.5.5.7
+	ntdsSyntaxDNString = 0x8000e // 2.5.5.14
+)
+
+const ntdsInstanceTypeWritable = 0x4
+
+// Encrypted with the boot key from the SYSTEM hive, LDAP never returns them so neither does this. The password hashes
+// are only looked at by checkPasswords
+var ntdsSecretAttributes = map[string]struct{}{
+	"unicodePwd":              {},
+	"dBCSPwd":                 {},
+	"ntPwdHistory":            {},
+	"lmPwdHistory":            {},
+	"supplementalCredentials": {},
+	"currentValue":            {},
+	"priorValue":              {},
+	"trustAuthIncoming":       {},
+	"trustAuthOutgoing":       {},
+	"initialAuthIncoming":     {},
+	"initialAuthOutgoing":     {},
+	"pekList":                 {},
+}
+
+// OID attributes pointing to classes or attributes, LDAP returns the lDAPDisplayName of those instead of the OID
+var ntdsSchemaReferences = map[string]struct{}{
+	"objectClass":          {},
+	"subClassOf":           {},
+	"auxiliaryClass":       {},
+	"systemAuxiliaryClass": {},
+	"mustContain":          {},
+	"systemMustContain":    {},
+	"mayContain":           {},
+	"systemMayContain":     {},
+	"possSuperiors":        {},
+	"systemPossSuperiors":  {},
+}
+
+// Prefixes of the OIDs that internal ids are made from, until the prefixMap of the schema is read (MS-DRSR 5.16.4)
+var ntdsDefaultPrefixes = map[uint32]string{
+	0:  "2.5.4",
+	1:  "2.5.6",
+	2:  "1.2.840.113556.1.2",
+	3:  "1.2.840.113556.1.3",
+	4:  "2.16.840.1.101.2.2.1",
+	5:  "2.16.840.1.101.2.2.3",
+	6:  "2.16.840.1.101.2.1.5",
+	7:  "2.16.840.1.101.2.1.4",
+	8:  "2.5.5",
+	9:  "1.2.840.113556.1.4",
+	10: "1.2.840.113556.1.5",
+}
+
+type ntdsAttribute struct {
+	name   string
+	syntax uint32
+}
+
+type ntdsRow struct {
+	dnt     uint32
+	pdnt    uint32
+	rdntype uint32
+	object  bool // Phantoms, stand-ins for objects in other domains, are not
+	record  eseRecord
+}
+
+type ntdsReader struct {
+	db   *eseDatabase
+	data *eseTable
+
+	rows       map[uint32]*ntdsRow
+	order      []uint32
+	dns        map[uint32]string
+	attributes map[uint32]ntdsAttribute // By internal id
+	columns    map[string]string        // LDAP name to column name
+	classes    map[uint32]string
+	links      map[uint32]string // By linkID
+	prefixes   map[uint32][]byte
+	sds        map[uint64][]byte
+
+	unsupported map[string]struct{}
+}
+
+// Dumps the objects from an NTDS.dit file that are not in an excluded subtree, redacted like a dump from LDAP. With
+// the SYSTEM hive from the same domain controller the password hashes are checked too
+func DumpNTDS(filename, systemhive string, exclusions []string, redactor *Redactor, attributes []string, e *msgp.Writer) error {
+	db, err := openESE(filename)
+	if err != nil {
+		return fmt.Errorf("Problem opening %v: %v", filename, err)
+	}
+	defer db.Close()
+	if db.state != eseStateCleanShutdown {
+		log.Warn().Msgf("%v was not shut down cleanly, changes only in the transaction logs are missing (recover it with esentutl /r first, or use an IFM copy)", filename)
+	}
+
+	n := ntdsReader{
+		db:          db,
+		rows:        make(map[uint32]*ntdsRow),
+		dns:         make(map[uint32]string),
+		attributes:  make(map[uint32]ntdsAttribute),
+		columns:     make(map[string]string),
+		classes:     make(map[uint32]string),
+		links:       make(map[uint32]string),
+		prefixes:    make(map[uint32][]byte),
+		sds:         make(map[uint64][]byte),
+		unsupported: make(map[string]struct{}),
+	}
+	if n.data = db.tables["datatable"]; n.data == nil {
+		return errors.New("No datatable in the database, this is not an NTDS.dit file")
+	}
+
+	log.Info().Msgf("Reading objects from %v ...", filename)
+	if err = n.readRows(); err != nil {
+		return err
+	}
+	n.readSchema()
+	if err = n.readSecurityDescriptors(); err != nil {
+		return err
+	}
+
+	objects := n.objects()
+	if err = n.readLinks(objects); err != nil {
+		return err
+	}
+	for name := range n.unsupported {
+		log.Debug().Msgf("Attribute %v has a syntax that isn't read from NTDS.dit, leaving it out", name)
+	}
+	if systemhive != "" {
+		bootkey, err := ReadBootKey(systemhive)
+		if err != nil {
+			return fmt.Errorf("Problem reading the boot key from %v: %v", systemhive, err)
+		}
+		if err = n.checkPasswords(objects, bootkey); err != nil {
+			return err
+		}
+	}
+
+	w := newFileDumpWriter(e, exclusions, redactor, attributes)
+	for _, dnt := range n.order {
+		if object, found := objects[dnt]; found {
+			if err = w.Write(object); err != nil {
+				return err
+			}
+		}
+	}
+	w.Done(filename)
+	return nil
+}
+
+// Reads every row of the data table, objects and phantoms, as they're all needed for DNs
+func (n *ntdsReader) readRows() error {
+	err := n.db.Records(n.data, func(record eseRecord) error {
+		dnt, ok := ntdsUint32(record, "DNT_col")
+		if !ok {
+			return nil
+		}
+		row := &ntdsRow{dnt: dnt, record: record}
+		row.pdnt, _ = ntdsUint32(record, "PDNT_col")
+		row.rdntype, _ = ntdsUint32(record, "RDNtyp_col")
+		if values := record["OBJ_col"]; len(values) > 0 && len(values[0]) > 0 {
+			row.object = values[0][0] != 0
+		}
+		n.rows[dnt] = row
+		n.order = append(n.order, dnt)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Problem reading datatable: %v", err)
+	}
+	sort.Slice(n.order, func(i, j int) bool {
+		return n.order[i] < n.order[j]
+	})
+	return nil
+}
+
+func (n *ntdsReader) readSchema() {
+	for index, oid := range ntdsDefaultPrefixes {
+		n.prefixes[index] = encodeOID(oid)
+	}
+	for _, row := range n.rows {
+		name := ntdsString(row.record, ntdsLDAPDisplayName)
+		if name == "" {
+			continue
+		}
+		if id, ok := ntdsUint32(row.record, ntdsAttributeID); ok {
+			syntax, _ := ntdsUint32(row.record, ntdsAttributeSyntax)
+			n.attributes[id] = ntdsAttribute{name: name, syntax: syntax}
+			if intid, ok := ntdsUint32(row.record, ntdsIntID); ok {
+				n.attributes[intid] = n.attributes[id]
+			}
+			if linkid, ok := ntdsUint32(row.record, ntdsLinkID); ok {
+				n.links[linkid] = name
+			}
+		}
+		if id, ok := ntdsUint32(row.record, ntdsGovernsID); ok {
+			n.classes[id] = name
+		}
+	}
+
+	for name := range n.data.byname {
+		if _, id, ok := ntdsColumn(name); ok {
+			if attribute, found := n.attributes[id]; found {
+				n.columns[attribute.name] = name
+			}
+		}
+	}
+
+	// Prefixes the domain controller added for OIDs not in the defaults are on the schema naming context
+	for _, row := range n.rows {
+		for _, value := range row.record[n.columns["prefixMap"]] {
+			n.readPrefixMap(value)
+		}
+	}
+	log.Debug().Msgf("Read %v attributes and %v classes from the schema", len(n.attributes), len(n.classes))
+}
+
+// The prefix map is a count, the total size and then the index, length and BER encoding of each prefix
+func (n *ntdsReader) readPrefixMap(data []byte) {
+	if len(data) < 8 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(data)
+	position := 8
+	for i := uint32(0); i < count; i++ {
+		if position+4 > len(data) {
+			return
+		}
+		index := uint32(binary.LittleEndian.Uint16(data[position:]))
+		length := int(binary.LittleEndian.Uint16(data[position+2:]))
+		position += 4
+		if position+length > len(data) {
+			return
+		}
+		n.prefixes[index] = append([]byte{}, data[position:position+length]...)
+		position += length
+	}
+}
+
+func (n *ntdsReader) readSecurityDescriptors() error {
+	table := n.db.tables["sd_table"]
+	if table == nil {
+		// Windows 2000 has the security descriptors in the objects themselves
+		return nil
+	}
+	err := n.db.Records(table, func(record eseRecord) error {
+		ids, values := record["sd_id"], record["sd_value"]
+		if len(ids) == 0 || len(ids[0]) != 8 || len(values) == 0 {
+			return nil
+		}
+		n.sds[binary.LittleEndian.Uint64(ids[0])] = values[0]
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Problem reading security descriptors: %v", err)
+	}
+	return nil
+}
+
+// Returns the objects LDAP would return by DNT, leaving out phantoms, deleted objects and the partial copies of
+// other domains on a Global Catalog
+func (n *ntdsReader) objects() map[uint32]*RawObject {
+	isdeleted := n.columns["isDeleted"]
+	instancetype := n.columns["instanceType"]
+
+	objects := make(map[uint32]*RawObject)
+	for _, dnt := range n.order {
+		row := n.rows[dnt]
+		if !row.object || len(row.record[ntdsObjectClass]) == 0 {
+			continue
+		}
+		if deleted, ok := ntdsUint32(row.record, isdeleted); ok && deleted != 0 {
+			continue
+		}
+		if instance, ok := ntdsUint32(row.record, instancetype); ok && instance&ntdsInstanceTypeWritable == 0 {
+			continue
+		}
+		dn := n.dn(dnt, 0)
+		if dn == "" {
+			continue
+		}
+
+		var object RawObject
+		object.init()
+		object.DistinguishedName = dn
+		for column, raw := range row.record {
+			syntax, id, ok := ntdsColumn(column)
+			if !ok {
+				continue
+			}
+			attribute, found := n.attributes[id]
+			if !found {
+				continue
+			}
+			if _, secret := ntdsSecretAttributes[attribute.name]; secret {
+				continue
+			}
+			values := n.values(attribute.name, syntax, n.data.byname[column], raw)
+			if len(values) > 0 {
+				object.Attributes[attribute.name] = values
+			}
+		}
+		object.Attributes["distinguishedName"] = []string{dn}
+		objects[dnt] = &object
+	}
+	return objects
+}
+
+// Well known hashes of a blank password
+var (
+	ntdsBlankNTHash = []byte{0x31, 0xd6, 0xcf, 0xe0, 0xd1, 0x6a, 0xe9, 0x31, 0xb7, 0x3c, 0x59, 0xd7, 0xe0, 0xc0, 0x89, 0xc0}
+	ntdsBlankLMHash = []byte{0xaa, 0xd3, 0xb4, 0x35, 0xb5, 0x14, 0x04, 0xee, 0xaa, 0xd3, 0xb4, 0x35, 0xb5, 0x14, 0x04, 0xee}
+)
+
+// Decrypts the password hashes with the boot key, and marks the accounts with a blank password, with an LM hash
+// kept and with the same password as other accounts (numbered, so the accounts sharing one can be found). The hashes
+// themselves never go in the dump, as LDAP doesn't return them
+func (n *ntdsReader) checkPasswords(objects map[uint32]*RawObject, bootkey []byte) error {
+	var peks [][]byte
+	for _, dnt := range n.order {
+		if values := n.rows[dnt].record[n.columns["pekList"]]; len(values) > 0 && len(values[0]) > 0 {
+			var err error
+			if peks, err = decryptPEKList(values[0], bootkey); err != nil {
+				return fmt.Errorf("Problem decrypting the password encryption keys, is the SYSTEM hive from the same domain controller: %v", err)
+			}
+			break
+		}
+	}
+	if len(peks) == 0 {
+		return errors.New("No password encryption keys in NTDS.dit")
+	}
+
+	nthashes := make(map[string][]*RawObject)
+	var blank, lm, problems int
+	for _, dnt := range n.order {
+		object, found := objects[dnt]
+		if !found || len(object.Attributes["objectSid"]) == 0 {
+			continue
+		}
+		rid := SID(object.Attributes["objectSid"][0]).RID()
+		record := n.rows[dnt].record
+		if values := record[n.columns["unicodePwd"]]; len(values) > 0 {
+			hash, err := decryptNTDSHash(values[0], peks, rid)
+			if err != nil {
+				problems++
+				continue
+			}
+			if bytes.Equal(hash, ntdsBlankNTHash) {
+				object.Attributes[MetaPasswordBlank.String()] = []string{"1"}
+				blank++
+			} else {
+				nthashes[string(hash)] = append(nthashes[string(hash)], object)
+			}
+		}
+		if values := record[n.columns["dBCSPwd"]]; len(values) > 0 {
+			if hash, err := decryptNTDSHash(values[0], peks, rid); err == nil && !bytes.Equal(hash, ntdsBlankLMHash) {
+				object.Attributes[MetaLMHash.String()] = []string{"1"}
+				lm++
+			}
+		}
+	}
+
+	// Numbered in the order the accounts are in, so the same database gives the same numbers
+	var shared [][]*RawObject
+	for _, accounts := range nthashes {
+		if len(accounts) > 1 {
+			shared = append(shared, accounts)
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		return shared[i][0].DistinguishedName < shared[j][0].DistinguishedName
+	})
+	for i, accounts := range shared {
+		for _, object := range accounts {
+			object.Attributes[MetaPasswordShared.String()] = []string{strconv.Itoa(i + 1)}
+		}
+	}
+
+	log.Info().Msgf("Checked password hashes: %v blank, %v with an LM hash, %v passwords used by more than one account", blank, lm, len(shared))
+	if problems > 0 {
+		log.Warn().Msgf("%v password hashes couldn't be decrypted", problems)
+	}
+	return nil
+}
+
+// Decrypts the password encryption keys (PEKs) in the pekList attribute of the domain with the boot key. Before
+// Windows Server 2016 it's RC4 with a key made from the boot key, after that AES with the boot key
+func decryptPEKList(peklist, bootkey []byte) ([][]byte, error) {
+	if len(peklist) < 24+32 {
+		return nil, errors.New("pekList is too short")
+	}
+	salt, encrypted := peklist[8:24], peklist[24:]
+	var peks [][]byte
+	switch binary.LittleEndian.Uint32(peklist) {
+	case 2:
+		h := md5.New()
+		h.Write(bootkey)
+		for i := 0; i < 1000; i++ {
+			h.Write(salt)
+		}
+		decrypted, err := ntdsRC4(h.Sum(nil), encrypted)
+		if err != nil {
+			return nil, err
+		}
+		// After a 32 byte header, keys with a 4 byte header
+		for position := 32; position+20 <= len(decrypted); position += 20 {
+			peks = append(peks, decrypted[position+4:position+20])
+		}
+	case 3:
+		decrypted, err := ntdsAES(bootkey, salt, encrypted)
+		if err != nil {
+			return nil, err
+		}
+		// After a 32 byte header, keys with their index in front
+		for position := 32; position+20 <= len(decrypted); position += 20 {
+			if binary.LittleEndian.Uint32(decrypted[position:]) != uint32(len(peks)) {
+				break
+			}
+			peks = append(peks, decrypted[position+4:position+20])
+		}
+	default:
+		return nil, fmt.Errorf("Unknown pekList version %v", binary.LittleEndian.Uint32(peklist))
+	}
+	if len(peks) == 0 {
+		return nil, errors.New("No keys in pekList")
+	}
+	return peks, nil
+}
+
+// Decrypts an NT or LM hash: first with the PEK it says (RC4, or AES from Windows Server 2016), then with DES keys
+// made from the RID of the account
+func decryptNTDSHash(value []byte, peks [][]byte, rid uint32) ([]byte, error) {
+	if len(value) < 24+16 {
+		return nil, errors.New("Encrypted hash is too short")
+	}
+	index := int(value[4])
+	if index >= len(peks) {
+		return nil, fmt.Errorf("Hash is encrypted with key %v, which isn't in pekList", index)
+	}
+	var hash []byte
+	var err error
+	if binary.LittleEndian.Uint32(value) == 0x13 {
+		if len(value) < 28+16 {
+			return nil, errors.New("Encrypted hash is too short")
+		}
+		hash, err = ntdsAES(peks[index], value[8:24], value[28:44])
+	} else {
+		h := md5.New()
+		h.Write(peks[index])
+		h.Write(value[8:24])
+		hash, err = ntdsRC4(h.Sum(nil), value[24:40])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rb [4]byte
+	binary.LittleEndian.PutUint32(rb[:], rid)
+	result := make([]byte, 16)
+	for i, key := range [][]byte{
+		{rb[0], rb[1], rb[2], rb[3], rb[0], rb[1], rb[2]},
+		{rb[3], rb[0], rb[1], rb[2], rb[3], rb[0], rb[1]},
+	} {
+		block, err := des.NewCipher(ntdsDESKey(key))
+		if err != nil {
+			return nil, err
+		}
+		block.Decrypt(result[i*8:], hash[i*8:i*8+8])
+	}
+	return result, nil
+}
+
+func ntdsRC4(key, data []byte) ([]byte, error) {
+	stream, err := rc4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]byte, len(data))
+	stream.XORKeyStream(result, data)
+	return result, nil
+}
+
+// AES-128 in CBC mode, with the data padded with zeros to whole blocks
+func ntdsAES(key, iv, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padded := make([]byte, (len(data)+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	copy(padded, data)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(padded, padded)
+	return padded, nil
+}
+
+// Spreads 7 bytes over the 8 bytes of a DES key, 7 bits in each
+func ntdsDESKey(in []byte) []byte {
+	key := []byte{
+		in[0] >> 1,
+		(in[0]&0x01)<<6 | in[1]>>2,
+		(in[1]&0x03)<<5 | in[2]>>3,
+		(in[2]&0x07)<<4 | in[3]>>4,
+		(in[3]&0x0f)<<3 | in[4]>>5,
+		(in[4]&0x1f)<<2 | in[5]>>6,
+		(in[5]&0x3f)<<1 | in[6]>>7,
+		in[6] & 0x7f,
+	}
+	for i := range key {
+		key[i] <<= 1
+	}
+	return key
+}
+
+// Linked attributes like member are in their own table, with the forward link on one object and the back link
+// (like memberOf) on the other
+func (n *ntdsReader) readLinks(objects map[uint32]*RawObject) error {
+	table := n.db.tables["link_table"]
+	if table == nil {
+		return errors.New("No link_table in the database")
+	}
+	syntaxes := make(map[string]uint32)
+	for _, attribute := range n.attributes {
+		syntaxes[attribute.name] = attribute.syntax
+	}
+
+	var count int
+	err := n.db.Records(table, func(record eseRecord) error {
+		from, ok1 := ntdsUint32(record, "link_DNT")
+		to, ok2 := ntdsUint32(record, "backlink_DNT")
+		base, ok3 := ntdsUint32(record, "link_base")
+		if !ok1 || !ok2 || !ok3 {
+			return nil
+		}
+		// Removed values are kept around until they've replicated, marked with when they were deleted
+		if deleted, ok := ntdsUint64(record, "link_deltime"); ok && deleted != 0 {
+			return nil
+		}
+		fromdn, todn := n.dn(from, 0), n.dn(to, 0)
+		if fromdn == "" || todn == "" {
+			return nil
+		}
+
+		forward := n.links[base*2]
+		if object := objects[from]; object != nil && forward != "" {
+			value := todn
+			if data := record["link_data"]; len(data) > 0 {
+				switch syntaxes[forward] {
+				case ntdsSyntaxDNBinary:
+					value = fmt.Sprintf("B:%d:%X:%s", len(data[0])*2, data[0], todn)
+				case ntdsSyntaxDNString:
+					value = fmt.Sprintf("S:%d:%s:%s", len(utf16String(data[0])), utf16String(data[0]), todn)
+				}
+			}
+			object.Attributes[forward] = append(object.Attributes[forward], value)
+			count++
+		}
+		if back := n.links[base*2+1]; back != "" {
+			if object := objects[to]; object != nil {
+				object.Attributes[back] = append(object.Attributes[back], fromdn)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Problem reading link_table: %v", err)
+	}
+	log.Debug().Msgf("Read %v links", count)
+	return nil
+}
+
+// Returns the DN of the row, or blank for the root and anything not connected to it
+func (n *ntdsReader) dn(dnt uint32, depth int) string {
+	if dn, found := n.dns[dnt]; found {
+		return dn
+	}
+	row := n.rows[dnt]
+	if row == nil || row.pdnt == 0 || depth > 256 {
+		return ""
+	}
+	name := ntdsString(row.record, ntdsName)
+	if name == "" {
+		return ""
+	}
+	rdntype := "CN"
+	if attribute, found := n.attributes[row.rdntype]; found {
+		rdntype = attribute.name
+		if len(rdntype) <= 2 {
+			rdntype = strings.ToUpper(rdntype)
+		}
+	}
+	dn := rdntype + "=" + escapeRDN(name)
+	if parent := n.rows[row.pdnt]; parent != nil && parent.pdnt != 0 {
+		parentdn := n.dn(row.pdnt, depth+1)
+		if parentdn == "" {
+			return ""
+		}
+		dn += "," + parentdn
+	}
+	n.dns[dnt] = dn
+	return dn
+}
+
+// Converts the values of a column to what LDAP returns, the syntax is the letter in the column name
+func (n *ntdsReader) values(attribute string, syntax byte, column *eseColumn, raw [][]byte) []string {
+	values := make([]string, 0, len(raw))
+	for _, value := range raw {
+		switch syntax {
+		case 'b': // DN, as the DNT of the object
+			if len(value) == 4 {
+				if dn := n.dn(binary.LittleEndian.Uint32(value), 0); dn != "" {
+					values = append(values, dn)
+				}
+			}
+		case 'c': // OID, as an internal id
+			if len(value) != 4 {
+				continue
+			}
+			id := binary.LittleEndian.Uint32(value)
+			if _, reference := ntdsSchemaReferences[attribute]; reference {
+				if name, found := n.classes[id]; found {
+					values = append(values, name)
+					continue
+				}
+				if schemaattribute, found := n.attributes[id]; found {
+					values = append(values, schemaattribute.name)
+					continue
+				}
+			}
+			if oid := n.oid(id); oid != "" {
+				values = append(values, oid)
+			}
+		case 'd', 'e', 'f', 'g', 'm': // Strings
+			if column != nil && column.codepage == 1200 {
+				values = append(values, utf16String(value))
+			} else {
+				values = append(values, string(value))
+			}
+		case 'i': // Boolean
+			if len(value) == 4 {
+				if binary.LittleEndian.Uint32(value) != 0 {
+					values = append(values, "TRUE")
+				} else {
+					values = append(values, "FALSE")
+				}
+			}
+		case 'j': // Integer
+			if len(value) == 4 {
+				values = append(values, strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(value))), 10))
+			}
+		case 'k': // Octet string
+			values = append(values, string(value))
+		case 'l': // Time, as seconds since 1601
+			if len(value) == 8 {
+				seconds := int64(binary.LittleEndian.Uint64(value))
+				t := time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(seconds) * time.Second)
+				values = append(values, t.Format("20060102150405.0Z"))
+			}
+		case 'p': // Security descriptor, as the id of one in the shared table
+			if len(value) == 8 && len(n.sds) > 0 {
+				if sd, found := n.sds[binary.LittleEndian.Uint64(value)]; found {
+					values = append(values, string(sd))
+				}
+			} else if len(value) > 8 {
+				values = append(values, string(value))
+			}
+		case 'q': // Large integer
+			if len(value) == 8 {
+				values = append(values, strconv.FormatInt(int64(binary.LittleEndian.Uint64(value)), 10))
+			}
+		case 'r': // SID, with the last sub authority big endian so they sort by RID
+			if len(value) >= 12 {
+				sid := append([]byte{}, value...)
+				last := len(sid) - 4
+				sid[last], sid[last+1], sid[last+2], sid[last+3] = sid[last+3], sid[last+2], sid[last+1], sid[last]
+				values = append(values, string(sid))
+			}
+		default:
+			n.unsupported[attribute] = struct{}{}
+			return nil
+		}
+	}
+	return values
+}
+
+// Returns the OID an internal id stands for: the prefix from the prefix map and the last part of the OID in
+// the low 16 bits, BER encoded in one or two bytes
+func (n *ntdsReader) oid(id uint32) string {
+	prefix, found := n.prefixes[id>>16]
+	if !found {
+		return ""
+	}
+	encoded := append([]byte{}, prefix...)
+	low := id & 0xffff
+	if low < 0x80 {
+		encoded = append(encoded, byte(low))
+	} else {
+		encoded = append(encoded, byte(low>>8)|0x80, byte(low))
+	}
+	return decodeOID(encoded)
+}
+
+// Returns the syntax letter and internal attribute id from a data table column name like ATTm589825
+func ntdsColumn(name string) (byte, uint32, bool) {
+	if len(name) < 5 || !strings.HasPrefix(name, "ATT") {
+		return 0, 0, false
+	}
+	id, err := strconv.ParseUint(name[4:], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return name[3], uint32(id), true
+}
+
+func ntdsUint32(record eseRecord, column string) (uint32, bool) {
+	values := record[column]
+	if len(values) == 0 || len(values[0]) < 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(values[0]), true
+}
+
+func ntdsUint64(record eseRecord, column string) (uint64, bool) {
+	values := record[column]
+	if len(values) == 0 || len(values[0]) < 8 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(values[0]), true
+}
+
+func ntdsString(record eseRecord, column string) string {
+	values := record[column]
+	if len(values) == 0 {
+		return ""
+	}
+	return utf16String(values[0])
+}
+
+func utf16String(data []byte) string {
+	runes := make([]uint16, len(data)/2)
+	for i := range runes {
+		runes[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(runes))
+}
+
+// Escapes an RDN value the way AD returns it in DNs
+func escapeRDN(value string) string {
+	var result strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", r):
+			result.WriteRune('\\')
+			result.WriteRune(r)
+		case r == '\n':
+			result.WriteString("\\0A")
+		case (r == '#' || r == ' ') && i == 0, r == ' ' && i == len(value)-1:
+			result.WriteRune('\\')
+			result.WriteRune(r)
+		default:
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+func encodeOID(oid string) []byte {
+	var arcs []uint64
+	for _, part := range strings.Split(oid, ".") {
+		arc, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil
+		}
+		arcs = append(arcs, arc)
+	}
+	if len(arcs) < 2 {
+		return nil
+	}
+	arcs = append([]uint64{arcs[0]*40 + arcs[1]}, arcs[2:]...)
+	var result []byte
+	for _, arc := range arcs {
+		var encoded []byte
+		for {
+			encoded = append([]byte{byte(arc & 0x7f)}, encoded...)
+			arc >>= 7
+			if arc == 0 {
+				break
+			}
+		}
+		for i := 0; i < len(encoded)-1; i++ {
+			encoded[i] |= 0x80
+		}
+		result = append(result, encoded...)
+	}
+	return result
+}
+
+func decodeOID(encoded []byte) string {
+	var arcs []string
+	var arc uint64
+	for i, b := range encoded {
+		arc = arc<<7 | uint64(b&0x7f)
+		if b&0x80 != 0 {
+			continue
+		}
+		if len(arcs) == 0 {
+			first := arc / 40
+			if first > 2 {
+				first = 2
+			}
+			arcs = append(arcs, strconv.FormatUint(first, 10), strconv.FormatUint(arc-first*40, 10))
+		} else {
+			arcs = append(arcs, strconv.FormatUint(arc, 10))
+		}
+		arc = 0
+		if i == len(encoded)-1 {
+			return strings.Join(arcs, ".")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/lkarlslund/ldap/v3"
+	"golang.org/x/crypto/md4"
+)
+
+// NTLM (MS-NLMP) is done here rather than in the ldap package, as binding to domain controllers that require channel
+// binding or LDAP signing needs the channel binding AV pair, a MIC and session security
+
+// Negotiate flags (MS-NLMP section 2.2.2.5)
+const (
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateSign             = 0x00000010
+	ntlmNegotiateSeal             = 0x00000020
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+	ntlmNegotiateTargetInfo       = 0x00800000
+	ntlmNegotiateVersion          = 0x02000000
+	ntlmNegotiate128              = 0x20000000
+	ntlmNegotiateKeyExchange      = 0x40000000
+	ntlmNegotiate56               = 0x80000000
+)
+
+// AV pair IDs in the target info (MS-NLMP section 2.2.2.1)
+const (
+	ntlmAvEOL             = 0
+	ntlmAvFlags           = 6
+	ntlmAvTimestamp       = 7
+	ntlmAvTargetName      = 9
+	ntlmAvChannelBindings = 10
+)
+
+// Windows 10 version 2004, NTLM revision 15
+var ntlmVersion = []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15}
+
+type ntlmClient struct {
+	user, domain string
+	nthash       []byte
+	spn          string
+	bindings     []byte // MD5 of the channel bindings, zeros without TLS
+	flags        uint32
+
+	negotiate  []byte
+	session    *ntlmSession
+	sessionkey []byte // Exported session key, which SMB signs with
+}
+
+// Sets up NTLM for user (user, user@domain or DOMAIN\user) with a password or an NT hash in hex. Without a domain
+// the one the server names in the challenge is used. With a certificate the channel bindings for it are sent,
+// without one signing and sealing is asked for
+func newNTLMClient(user, domain, secret string, ishash bool, server string, peercert *x509.Certificate) (*ntlmClient, error) {
+	c := &ntlmClient{
+		user:     user,
+		domain:   domain,
+		spn:      "ldap/" + server,
+		bindings: make([]byte, 16),
+		flags: ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign |
+			ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo | ntlmNegotiateVersion | ntlmNegotiate128 |
+			ntlmNegotiateKeyExchange | ntlmNegotiate56,
+	}
+	if i := strings.Index(user, `\`); i != -1 {
+		c.domain, c.user = user[:i], user[i+1:]
+	}
+	if ishash {
+		hash, err := hex.DecodeString(secret)
+		if err != nil || len(hash) != 16 {
+			return nil, errors.New("NT hash must be 32 hex characters")
+		}
+		c.nthash = hash
+	} else {
+		h := md4.New()
+		h.Write(ntlmUnicode(secret))
+		c.nthash = h.Sum(nil)
+	}
+	if peercert != nil {
+		c.bindings = channelBindings(peercert)
+	} else {
+		c.flags |= ntlmNegotiateSign | ntlmNegotiateSeal
+	}
+	return c, nil
+}
+
+func ntlmUnicode(s string) []byte {
+	runes := utf16.Encode([]rune(s))
+	result := make([]byte, len(runes)*2)
+	for i, r := range runes {
+		binary.LittleEndian.PutUint16(result[i*2:], r)
+	}
+	return result
+}
+
+// Returns the NEGOTIATE_MESSAGE
+func (c *ntlmClient) Negotiate() []byte {
+	message := make([]byte, 40)
+	copy(message, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(message[8:], 1)
+	binary.LittleEndian.PutUint32(message[12:], c.flags)
+	// No domain or workstation, both fields stay empty
+	copy(message[32:], ntlmVersion)
+	c.negotiate = message
+	return message
+}
+
+// Returns a field (length, allocated length and offset) from a message, or nil if it's outside the message
+func ntlmField(message []byte, offset int) []byte {
+	if len(message) < offset+8 {
+		return nil
+	}
+	length := int(binary.LittleEndian.Uint16(message[offset:]))
+	start := int(binary.LittleEndian.Uint32(message[offset+4:]))
+	if start+length > len(message) {
+		return nil
+	}
+	return message[start : start+length]
+}
+
+// Calls back for each AV pair in the target info until the end
+func ntlmAvPairs(targetinfo []byte, callback func(id uint16, value []byte)) {
+	for len(targetinfo) >= 4 {
+		id := binary.LittleEndian.Uint16(targetinfo)
+		length := int(binary.LittleEndian.Uint16(targetinfo[2:]))
+		if id == ntlmAvEOL || len(targetinfo) < 4+length {
+			return
+		}
+		callback(id, targetinfo[4:4+length])
+		targetinfo = targetinfo[4+length:]
+	}
+}
+
+func ntlmAvPair(id uint16, value []byte) []byte {
+	pair := make([]byte, 4, 4+len(value))
+	binary.LittleEndian.PutUint16(pair, id)
+	binary.LittleEndian.PutUint16(pair[2:], uint16(len(value)))
+	return append(pair, value...)
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// NTLMv2 response and session base key (MS-NLMP section 3.3.2)
+func ntlmv2Response(nthash []byte, user, domain string, serverchallenge, clientchallenge []byte, timestamp uint64, targetinfo []byte) ([]byte, []byte) {
+	ntowf := hmacMD5(nthash, ntlmUnicode(strings.ToUpper(user)+domain))
+	temp := make([]byte, 28, 28+len(targetinfo)+4)
+	temp[0], temp[1] = 1, 1
+	binary.LittleEndian.PutUint64(temp[8:], timestamp)
+	copy(temp[16:], clientchallenge)
+	temp = append(temp, targetinfo...)
+	temp = append(temp, 0, 0, 0, 0)
+	proof := hmacMD5(ntowf, serverchallenge, temp)
+	return append(proof, temp...), hmacMD5(ntowf, proof)
+}
+
+// Returns the AUTHENTICATE_MESSAGE answering the CHALLENGE_MESSAGE from the server
+func (c *ntlmClient) Authenticate(challenge []byte) ([]byte, error) {
+	if len(challenge) < 48 || string(challenge[:8]) != "NTLMSSP\x00" || binary.LittleEndian.Uint32(challenge[8:]) != 2 {
+		return nil, errors.New("Not an NTLM challenge")
+	}
+	flags := binary.LittleEndian.Uint32(challenge[20:]) & c.flags
+	if flags&ntlmNegotiateExtendedSecurity == 0 {
+		return nil, errors.New("Server doesn't support NTLMv2 session security")
+	}
+	if c.flags&ntlmNegotiateSeal != 0 && flags&ntlmNegotiateSeal == 0 {
+		return nil, errors.New("Server doesn't support NTLM sealing")
+	}
+	serverchallenge := challenge[24:32]
+	domain := c.domain
+	if domain == "" {
+		domain = ntlmString(ntlmField(challenge, 12))
+	}
+
+	// Target info from the server with our additions, the MIC flag, channel bindings and service name
+	var timestamp uint64
+	var avflags uint32
+	var targetinfo []byte
+	ntlmAvPairs(ntlmField(challenge, 40), func(id uint16, value []byte) {
+		switch id {
+		case ntlmAvFlags:
+			if len(value) == 4 {
+				avflags = binary.LittleEndian.Uint32(value)
+			}
+			return
+		case ntlmAvTimestamp:
+			if len(value) == 8 {
+				timestamp = binary.LittleEndian.Uint64(value)
+			}
+		case ntlmAvTargetName, ntlmAvChannelBindings:
+			return
+		}
+		targetinfo = append(targetinfo, ntlmAvPair(id, value)...)
+	})
+	mic := timestamp != 0
+	if mic {
+		avflags |= 2
+	} else {
+		timestamp = uint64(time.Now().UnixNano()/100 + 116444736000000000)
+	}
+	if avflags != 0 {
+		value := make([]byte, 4)
+		binary.LittleEndian.PutUint32(value, avflags)
+		targetinfo = append(targetinfo, ntlmAvPair(ntlmAvFlags, value)...)
+	}
+	targetinfo = append(targetinfo, ntlmAvPair(ntlmAvChannelBindings, c.bindings)...)
+	targetinfo = append(targetinfo, ntlmAvPair(ntlmAvTargetName, ntlmUnicode(c.spn))...)
+	targetinfo = append(targetinfo, ntlmAvPair(ntlmAvEOL, nil)...)
+
+	clientchallenge := make([]byte, 8)
+	if _, err := rand.Read(clientchallenge); err != nil {
+		return nil, err
+	}
+	ntresponse, sessionbasekey := ntlmv2Response(c.nthash, c.user, domain, serverchallenge, clientchallenge, timestamp, targetinfo)
+	lmresponse := make([]byte, 24)
+	if !mic {
+		// LMv2, which is left out when there's a MIC
+		copy(lmresponse, hmacMD5(hmacMD5(c.nthash, ntlmUnicode(strings.ToUpper(c.user)+domain)), serverchallenge, clientchallenge))
+		copy(lmresponse[16:], clientchallenge)
+	}
+
+	exportedkey := sessionbasekey
+	var encryptedkey []byte
+	if flags&ntlmNegotiateKeyExchange != 0 {
+		exportedkey = make([]byte, 16)
+		if _, err := rand.Read(exportedkey); err != nil {
+			return nil, err
+		}
+		encryptedkey = make([]byte, 16)
+		cipher, _ := rc4.NewCipher(sessionbasekey)
+		cipher.XORKeyStream(encryptedkey, exportedkey)
+	}
+
+	// Fixed part with the MIC is 88 bytes, the fields follow in the payload
+	message := make([]byte, 88)
+	copy(message, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(message[8:], 3)
+	for _, field := range []struct {
+		offset int
+		value  []byte
+	}{
+		{12, lmresponse},
+		{20, ntresponse},
+		{28, ntlmUnicode(domain)},
+		{36, ntlmUnicode(c.user)},
+		{44, nil},
+		{52, encryptedkey},
+	} {
+		binary.LittleEndian.PutUint16(message[field.offset:], uint16(len(field.value)))
+		binary.LittleEndian.PutUint16(message[field.offset+2:], uint16(len(field.value)))
+		binary.LittleEndian.PutUint32(message[field.offset+4:], uint32(len(message)))
+		message = append(message, field.value...)
+	}
+	binary.LittleEndian.PutUint32(message[60:], flags)
+	copy(message[64:], ntlmVersion)
+	if mic {
+		copy(message[72:], hmacMD5(exportedkey, c.negotiate, challenge, message))
+	}
+
+	c.session = newNTLMSession(flags, exportedkey)
+	c.sessionkey = exportedkey
+	return message, nil
+}
+
+func ntlmString(b []byte) string {
+	runes := make([]uint16, len(b)/2)
+	for i := range runes {
+		runes[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(runes))
+}
+
+// Signing and sealing of messages after the bind, with extended session security (MS-NLMP section 3.4)
+type ntlmSession struct {
+	flags                  uint32
+	clientsign, serversign []byte
+	clientseal, serverseal *rc4.Cipher
+	sendseq, receiveseq    uint32
+}
+
+func newNTLMSession(flags uint32, exportedkey []byte) *ntlmSession {
+	sealkey := func(magic string) *rc4.Cipher {
+		key := exportedkey
+		switch {
+		case flags&ntlmNegotiate128 != 0:
+		case flags&ntlmNegotiate56 != 0:
+			key = key[:7]
+		default:
+			key = key[:5]
+		}
+		sum := md5.Sum(append(append([]byte{}, key...), magic...))
+		cipher, _ := rc4.NewCipher(sum[:])
+		return cipher
+	}
+	signkey := func(magic string) []byte {
+		sum := md5.Sum(append(append([]byte{}, exportedkey...), magic...))
+		return sum[:]
+	}
+	return &ntlmSession{
+		flags:      flags,
+		clientsign: signkey("session key to client-to-server signing key magic constant\x00"),
+		serversign: signkey("session key to server-to-client signing key magic constant\x00"),
+		clientseal: sealkey("session key to client-to-server sealing key magic constant\x00"),
+		serverseal: sealkey("session key to server-to-client sealing key magic constant\x00"),
+	}
+}
+
+func (s *ntlmSession) signature(key []byte, handle *rc4.Cipher, seq uint32, message []byte) []byte {
+	signature := make([]byte, 16)
+	binary.LittleEndian.PutUint32(signature, 1)
+	binary.LittleEndian.PutUint32(signature[12:], seq)
+	checksum := hmacMD5(key, signature[12:], message)[:8]
+	if s.flags&ntlmNegotiateKeyExchange != 0 {
+		handle.XORKeyStream(checksum, checksum)
+	}
+	copy(signature[4:], checksum)
+	return signature
+}
+
+// Seals a message, returning the signature followed by the sealed message
+func (s *ntlmSession) Wrap(message []byte) ([]byte, error) {
+	sealed := make([]byte, len(message))
+	s.clientseal.XORKeyStream(sealed, message)
+	signature := s.signature(s.clientsign, s.clientseal, s.sendseq, message)
+	s.sendseq++
+	return append(signature, sealed...), nil
+}
+
+// Unseals a message from the server and checks its signature
+func (s *ntlmSession) Unwrap(data []byte) ([]byte, error) {
+	if len(data) < 16 {
+		return nil, errors.New("NTLM sealed message is too short")
+	}
+	message := make([]byte, len(data)-16)
+	s.serverseal.XORKeyStream(message, data[16:])
+	if !hmac.Equal(s.signature(s.serversign, s.serverseal, s.receiveseq, message), data[:16]) {
+		return nil, errors.New("NTLM signature from the server doesn't match")
+	}
+	s.receiveseq++
+	return message, nil
+}
+
+func sicilyBindPacket(id int64, tag ber.Tag, message []byte) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	request := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindRequest, nil, "Bind Request")
+	request.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "Version"))
+	request.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "User Name"))
+	request.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, tag, string(message), "NTLM"))
+	packet.AppendChild(request)
+	return packet
+}
+
+// Does an NTLM bind the way Windows does (sicilyNegotiate and sicilyResponse, MS-ADTS section 5.1.1.1.3). Without
+// TLS the connection is sealed afterwards, so the returned connection must be used from then on
+func ntlmBind(conn net.Conn, c *ntlmClient) (net.Conn, error) {
+	_, response, err := rawLDAPExchange(conn, sicilyBindPacket(1, 10, c.Negotiate()))
+	if err != nil {
+		return nil, err
+	}
+	// The challenge comes back in the matchedDN of the response
+	if len(response.Children[1].Children) < 2 {
+		return nil, errors.New("No NTLM challenge in bind response")
+	}
+	authenticate, err := c.Authenticate(response.Children[1].Children[1].Data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err = rawLDAPExchange(conn, sicilyBindPacket(2, 11, authenticate)); err != nil {
+		return nil, err
+	}
+	if c.flags&ntlmNegotiateSeal == 0 {
+		return conn, nil
+	}
+	return &securityLayerConn{Conn: conn, wrap: c.session.Wrap, unwrap: c.session.Unwrap}, nil
+}
+
+// Domain to authenticate in, blank unless it's another one than the one collected from
+func (ad *AD) ntlmDomain() string {
+	if !strings.Contains(ad.User, "@") && !strings.EqualFold(ad.AuthDomain, ad.Domain) {
+		return ad.AuthDomain
+	}
+	return ""
+}
+
+// Connects and binds with NTLM, with channel bindings over TLS and sealing without
+func (ad *AD) connectNTLM(ishash bool) error {
+	conn, peercert, err := ad.dialTransport()
+	if err != nil {
+		return err
+	}
+	c, err := newNTLMClient(ad.User, ad.ntlmDomain(), ad.Password, ishash, ad.Server, peercert)
+	if err == nil {
+		var bound net.Conn
+		if bound, err = ntlmBind(conn, c); err == nil {
+			ad.conn = ldap.NewConn(bound, peercert != nil)
+			ad.conn.Start()
+			return nil
+		}
+	}
+	conn.Close()
+	return err
+}
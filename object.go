@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/OneOfOne/xxhash"
@@ -36,6 +37,10 @@ const (
 )
 
 type Object struct {
+	// Guards everything below that changes after loading, so the analysis and the webservice can use objects from
+	// several goroutines. Attributes and the pwn sets must be changed with the methods here
+	lock sync.RWMutex
+
 	DistinguishedName string
 	Attributes        map[Attribute][]string
 
@@ -54,11 +59,14 @@ type Object struct {
 	guidcached bool
 	guid       uuid.UUID
 
+	memberoflock sync.Mutex // Held while finding the groups, which changes other objects
 	memberofinit bool
 	memberof     []*Object
 	members      []*Object
 
 	sdcache *SecurityDescriptor
+
+	compressed map[Attribute][]byte // Big values that are rarely used, uncompressed when asked for
 }
 
 type Connection struct {
@@ -72,18 +80,21 @@ func NewObject() *Object {
 	return &result
 }
 
-func (o Object) MarshalJSON() ([]byte, error) {
+func (o *Object) MarshalJSON() ([]byte, error) {
 	// result := make(map[string][]string)
 	// for attr, values := range o.Attributes {
 	// 	result[attr.Name()] = values
 	// }
-	return jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(&o.Attributes)
+	attributes := o.AttributesSnapshot()
+	return jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(&attributes)
 }
 
-func (o Object) DN() string {
+func (o *Object) DN() string {
 	if o.DistinguishedName == "" {
 		// !?!?
+		o.lock.RLock()
 		dn, found := o.Attributes[DistinguishedName]
+		o.lock.RUnlock()
 		if !found {
 			log.Fatal().Msgf("Object has no distinguishedName!?")
 		}
@@ -95,7 +106,7 @@ func (o Object) DN() string {
 	return o.DistinguishedName
 }
 
-func (o Object) Label() string {
+func (o *Object) Label() string {
 	return Default(
 		o.OneAttr(LDAPDisplayName),
 		o.OneAttr(DisplayName),
@@ -105,7 +116,7 @@ func (o Object) Label() string {
 	)
 }
 
-func (o Object) ParentDN() string {
+func (o *Object) ParentDN() string {
 	firstcomma := strings.Index(o.DN(), ",")
 	if firstcomma >= 0 {
 		return o.DN()[firstcomma+1:]
@@ -113,42 +124,51 @@ func (o Object) ParentDN() string {
 	return ""
 }
 
-func (o Object) Type() ObjectType {
-	if o.objecttype > 0 {
-		return o.objecttype
+func (o *Object) Type() ObjectType {
+	o.lock.RLock()
+	objecttype := o.objecttype
+	o.lock.RUnlock()
+	if objecttype > 0 {
+		return objecttype
 	}
 
 	category := o.OneAttrRendered(ObjectCategory)
 
 	switch category {
 	case "Person":
-		o.objecttype = ObjectTypeUser
+		objecttype = ObjectTypeUser
 	case "Group":
-		o.objecttype = ObjectTypeGroup
+		objecttype = ObjectTypeGroup
 	case "Foreign-Security-Principal":
-		o.objecttype = ObjectTypeForeignSecurityPrincipal
+		objecttype = ObjectTypeForeignSecurityPrincipal
 	case "ms-DS-Group-Managed-Service-Account":
-		o.objecttype = ObjectTypeManagedServiceAccount
+		objecttype = ObjectTypeManagedServiceAccount
 	case "Organizational-Unit":
-		o.objecttype = ObjectTypeOrganizationalUnit
+		objecttype = ObjectTypeOrganizationalUnit
 	case "Container":
-		o.objecttype = ObjectTypeContainer
+		objecttype = ObjectTypeContainer
 	case "Computer":
-		o.objecttype = ObjectTypeComputer
+		objecttype = ObjectTypeComputer
 	case "Group-Policy-Container":
-		o.objecttype = ObjectTypeGroupPolicyContainer
-	case "Domain Trust":
-		o.objecttype = ObjectTypeTrust
+		objecttype = ObjectTypeGroupPolicyContainer
+	case "Trusted-Domain", "Domain Trust":
+		objecttype = ObjectTypeTrust
 	case "Attribute-Schema":
-		o.objecttype = ObjectTypeAttributeSchema
+		objecttype = ObjectTypeAttributeSchema
 	default:
-		o.objecttype = ObjectTypeOther
+		objecttype = ObjectTypeOther
 	}
-	return o.objecttype
+	o.lock.Lock()
+	o.objecttype = objecttype
+	o.lock.Unlock()
+	return objecttype
 }
 
 func (o *Object) ObjectClassGUIDs() []uuid.UUID {
-	if len(o.objectclassguids) == 0 {
+	o.lock.RLock()
+	objectclassguids := o.objectclassguids
+	o.lock.RUnlock()
+	if len(objectclassguids) == 0 {
 		for _, class := range o.Attr(ObjectClass) {
 			if oto, found := AllObjects.FindClass(class); found {
 				var err error
@@ -159,18 +179,24 @@ func (o *Object) ObjectClassGUIDs() []uuid.UUID {
 					log.Fatal().Msgf("Sorry, could not translate SchemaIDGUID for class %v", class)
 				} else {
 					og = SwapUUIDEndianess(og)
-					o.objectclassguids = append(o.objectclassguids, og)
+					objectclassguids = append(objectclassguids, og)
 				}
 			} else {
 				log.Fatal().Msgf("Sorry, could not resolve object class %v, perhaps you didn't get a dump of the schema?", class)
 			}
 		}
+		o.lock.Lock()
+		o.objectclassguids = objectclassguids
+		o.lock.Unlock()
 	}
-	return o.objectclassguids
+	return objectclassguids
 }
 
 func (o *Object) ObjectTypeGUID() uuid.UUID {
-	if o.objecttypeguid == NullGUID {
+	o.lock.RLock()
+	objecttypeguid := o.objecttypeguid
+	o.lock.RUnlock()
+	if objecttypeguid == NullGUID {
 		typedn := o.OneAttr(ObjectCategory)
 		if typedn == "" {
 			// log.Warn().Msgf("Sorry, could not resolve object category %v for object %v, perhaps you didn't get a dump of the schema?", typedn, o.DN())
@@ -180,7 +206,7 @@ func (o *Object) ObjectTypeGUID() uuid.UUID {
 		if oto, found := AllObjects.Find(typedn); found {
 			var err error
 			classguid := oto.OneAttr(SchemaIDGUID)
-			o.objecttypeguid, err = uuid.FromBytes([]byte(classguid))
+			objecttypeguid, err = uuid.FromBytes([]byte(classguid))
 			if err != nil {
 				log.Debug().Msgf("%v", oto)
 				log.Fatal().Msgf("Sorry, could not translate SchemaIDGUID for %v", typedn)
@@ -189,19 +215,28 @@ func (o *Object) ObjectTypeGUID() uuid.UUID {
 		} else {
 			log.Fatal().Msgf("Sorry, could not resolve object category %v, perhaps you didn't get a dump of the schema?", typedn)
 		}
+		o.lock.Lock()
+		o.objecttypeguid = objecttypeguid
+		o.lock.Unlock()
 	}
-	return o.objecttypeguid
+	return objecttypeguid
 }
 
-func (o Object) Attr(attr Attribute) []string {
+func (o *Object) Attr(attr Attribute) []string {
+	o.lock.RLock()
 	r := o.Attributes[attr]
+	compressed := o.compressed[attr]
+	o.lock.RUnlock()
+	if compressed != nil {
+		return uncompressValues(compressed)
+	}
 	if len(r) == 0 && attr == DistinguishedName {
 		return []string{o.DN()}
 	}
 	return r
 }
 
-func (o Object) OneAttr(attr Attribute) string {
+func (o *Object) OneAttr(attr Attribute) string {
 	a := o.Attr(attr)
 	if len(a) == 1 {
 		return a[0]
@@ -209,7 +244,7 @@ func (o Object) OneAttr(attr Attribute) string {
 	return ""
 }
 
-func (o Object) AttrRendered(attr Attribute) []string {
+func (o *Object) AttrRendered(attr Attribute) []string {
 	values := o.Attr(attr)
 	renderedvalues := make([]string, len(values))
 	copy(renderedvalues, values)
@@ -231,7 +266,7 @@ func (o Object) AttrRendered(attr Attribute) []string {
 	return renderedvalues
 }
 
-func (o Object) OneAttrRendered(attr Attribute) string {
+func (o *Object) OneAttrRendered(attr Attribute) string {
 	a := o.AttrRendered(attr)
 	if len(a) == 1 {
 		return a[0]
@@ -239,7 +274,7 @@ func (o Object) OneAttrRendered(attr Attribute) string {
 	return ""
 }
 
-func (o Object) HasAttrValue(attr Attribute, hasvalue string) bool {
+func (o *Object) HasAttrValue(attr Attribute, hasvalue string) bool {
 	for _, value := range o.Attr(attr) {
 		if value == hasvalue {
 			return true
@@ -248,7 +283,7 @@ func (o Object) HasAttrValue(attr Attribute, hasvalue string) bool {
 	return false
 }
 
-func (o Object) AttrInt(attr Attribute) (int64, bool) {
+func (o *Object) AttrInt(attr Attribute) (int64, bool) {
 	value := o.OneAttr(attr)
 	v, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
@@ -257,17 +292,31 @@ func (o Object) AttrInt(attr Attribute) (int64, bool) {
 	return v, true
 }
 
-func (o Object) AttrTimestamp(attr Attribute) (time.Time, bool) {
+func (o *Object) AttrBool(attr Attribute) (bool, bool) {
+	switch strings.ToUpper(o.OneAttr(attr)) {
+	case "TRUE":
+		return true, true
+	case "FALSE":
+		return false, true
+	}
+	return false, false
+}
+
+func (o *Object) AttrTimestamp(attr Attribute) (time.Time, bool) {
+	switch SyntaxOf(attr) {
+	case SyntaxTime:
+		return parseDirectoryTime(o.OneAttr(attr))
+	case SyntaxInteger:
+		v, ok := o.AttrInt(attr)
+		if !ok {
+			return time.Time{}, false
+		}
+		return FiletimeToTime(uint64(v)), true
+	}
+	// Not in the schema, so guess
 	v, ok := o.AttrInt(attr)
 	if !ok {
-		value := o.OneAttr(attr)
-		if strings.HasSuffix(value, "Z") { // "20171111074031.0Z"
-			value = strings.TrimSuffix(value, "Z")  // strip "Z"
-			value = strings.TrimSuffix(value, ".0") // strip ".0"
-			t, err := time.Parse("20060102150405", value)
-			return t, err == nil
-		}
-		return time.Time{}, false
+		return parseDirectoryTime(o.OneAttr(attr))
 	}
 	t := FiletimeToTime(uint64(v))
 	// log.Debug().Msgf("Converted %v to %v", v, t)
@@ -275,46 +324,90 @@ func (o Object) AttrTimestamp(attr Attribute) (time.Time, bool) {
 }
 
 func (o *Object) imamemberofyou(member *Object) {
+	o.lock.Lock()
 	o.members = append(o.members, member)
+	o.lock.Unlock()
 }
 
 func (o *Object) Members(recursive bool) []*Object {
+	o.lock.RLock()
+	directmembers := o.members
+	o.lock.RUnlock()
 	if !recursive {
-		return o.members
-	}
-	var members map[*Object]struct{}
-	for _, directmember := range o.members {
-		members[directmember] = struct{}{}
-		if recursive {
-			for _, indirectmember := range directmember.Members(true) {
-				members[indirectmember] = struct{}{}
-			}
+		return directmembers
+	}
+	// Nested groups can loop, so keep track of where we've been
+	members := make(map[*Object]struct{})
+	queue := append([]*Object(nil), directmembers...)
+	for len(queue) > 0 {
+		member := queue[0]
+		queue = queue[1:]
+		if _, seen := members[member]; seen {
+			continue
 		}
+		members[member] = struct{}{}
+		member.lock.RLock()
+		queue = append(queue, member.members...)
+		member.lock.RUnlock()
 	}
-	membersarray := make([]*Object, len(members))
-	var i int
-	for member, _ := range members {
-		membersarray[i] = member
-		i++
+	membersarray := make([]*Object, 0, len(members))
+	for member := range members {
+		membersarray = append(membersarray, member)
 	}
 	return membersarray
 }
 
+// Groups that can be the primary group, for naming them when they're not in the data
+var primaryGroupNames = map[uint32]string{
+	513: "Domain Users",
+	514: "Domain Guests",
+	515: "Domain Computers",
+	516: "Domain Controllers",
+	521: "Read-only Domain Controllers",
+}
+
+// Returns the SID of the primary group, which is the domain SID with the RID from primaryGroupID. Membership of
+// the primary group isn't in member or memberOf
+func (o *Object) PrimaryGroupSID() (SID, bool) {
+	primaryGroupID := o.OneAttr(PrimaryGroupID)
+	if primaryGroupID == "" {
+		return "", false
+	}
+	sid := o.SID()
+	if len(sid) <= 8 {
+		return "", false
+	}
+	rid, err := strconv.ParseInt(primaryGroupID, 10, 32)
+	if err != nil {
+		return "", false
+	}
+	sidbytes := []byte(sid)
+	binary.LittleEndian.PutUint32(sidbytes[len(sid)-4:], uint32(rid))
+	return SID(sidbytes), true
+}
+
+// Returns how the object is a member of the group: by primaryGroupID, or listed in member - for groups that's nesting
+func (o *Object) MembershipSource(group *Object) string {
+	if sid, found := o.PrimaryGroupSID(); found && sid == group.SID() {
+		return "primary group (primaryGroupID), not listed in member"
+	}
+	if o.Type() == ObjectTypeGroup || o.Type() == ObjectTypeForeignSecurityPrincipal {
+		return "nested group, listed in member"
+	}
+	return "direct member, listed in member"
+}
+
 func (o *Object) MemberOf() []*Object {
+	o.memberoflock.Lock()
+	defer o.memberoflock.Unlock()
 	if !o.memberofinit {
-		primaryGroupID := o.OneAttr(PrimaryGroupID)
-		if primaryGroupID != "" {
-			sid := o.SID()
-			if len(sid) > 8 {
-				rid, err := strconv.ParseInt(primaryGroupID, 10, 32)
-				if err == nil {
-					sidbytes := []byte(sid)
-					binary.LittleEndian.PutUint32(sidbytes[len(sid)-4:], uint32(rid))
-					primarygroup := AllObjects.FindOrAddSID(SID(sidbytes))
-					primarygroup.imamemberofyou(o)
-					o.memberof = append(o.memberof, primarygroup)
-				}
-			}
+		var primarygroup *Object
+		if sid, found := o.PrimaryGroupSID(); found {
+			// Often the only membership of Domain Users or Domain Computers, so when the group isn't in the data it's
+			// added as one, or the members are lost
+			primarygroup = AllObjects.FindOrAddGroupSID(sid, Default(primaryGroupNames[sid.RID()], "Primary group "+sid.ToString()))
+			primarygroup.imamemberofyou(o)
+			o.memberof = append(o.memberof, primarygroup)
 		}
 
 		for _, memberof := range o.Attr(MemberOf) {
@@ -332,6 +425,10 @@ func (o *Object) MemberOf() []*Object {
 				log.Warn().Msgf("Possible hardening? %v is a member of %v, which is not found - adding synthetic group", o.DN(), memberof)
 				AllObjects.Add(target)
 			}
+			if target == primarygroup {
+				// Converted data sometimes lists it in memberOf too
+				continue
+			}
 			target.imamemberofyou(o)
 			o.memberof = append(o.memberof, target)
 
@@ -345,20 +442,65 @@ func (o *Object) MemberOf() []*Object {
 }
 
 func (o *Object) SetAttr(a Attribute, value string) {
-	o.Attributes[a] = []string{value}
+	o.SetValues(a, value)
+}
+
+// Replaces the values of the attribute, and forgets what was cached from it. Without values the attribute is removed,
+// so an attribute that is there always has a value
+func (o *Object) SetValues(a Attribute, values ...string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if len(values) == 0 {
+		delete(o.Attributes, a)
+	} else {
+		o.Attributes[a] = values
+	}
+	delete(o.compressed, a)
+	o.forget(a)
+}
+
+// Adds values to the attribute
+func (o *Object) AddValues(a Attribute, values ...string) {
+	if len(values) == 0 {
+		return
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if compressed, found := o.compressed[a]; found {
+		o.Attributes[a] = uncompressValues(compressed)
+		delete(o.compressed, a)
+	}
+	o.Attributes[a] = append(o.Attributes[a], values...)
+	o.forget(a)
+}
+
+func (o *Object) forget(a Attribute) {
+	switch a {
+	case ObjectSid:
+		o.sidcached = false
+	case ObjectGUID:
+		o.guidcached = false
+	case ObjectCategory:
+		o.objecttype = 0
+		o.objecttypeguid = NullGUID
+	case ObjectClass:
+		o.objectclassguids = nil
+	}
 }
 
 func (o *Object) Meta() map[string]string {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
 	result := make(map[string]string)
 	for attr, value := range o.Attributes {
-		if attr.String()[0] == '_' {
+		if attr.String()[0] == '_' && len(value) > 0 {
 			result[attr.String()] = value[0]
 		}
 	}
 	return result
 }
 
-/*func (o Object) Save(filename string) error {
+/*func (o *Object) Save(filename string) error {
 	data, err := json.Marshal(o)
 	if err != nil {
 		return err
@@ -385,7 +527,7 @@ func (o *Object) init() {
 func (o *Object) String() string {
 	var result string
 	result += "OBJECT " + o.DN() + "\n"
-	for attr, values := range o.Attributes {
+	for attr, values := range o.AttributesSnapshot() {
 		if attr == NTSecurityDescriptor {
 			continue
 		}
@@ -410,38 +552,52 @@ func (o *Object) String() string {
 }
 
 func (o *Object) SecurityDescriptor() (*SecurityDescriptor, error) {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
 	if o.sdcache == nil {
 		return nil, errors.New("No security desciptor")
 	}
 	return o.sdcache, nil
 }
 
+// Guards SecurityDescriptorCache, as objects can be converted in parallel
+var securityDescriptorCacheLock sync.Mutex
+
 func (o *Object) cacheSecurityDescriptor(rawsd []byte) error {
 	if len(rawsd) == 0 {
 		return errors.New("Empty nTSecurityDescriptor attribute!?")
 	}
 
 	cacheindex := xxhash.Checksum32(rawsd)
-	if sd, found := SecurityDescriptorCache[cacheindex]; found {
-		o.sdcache = sd
-		return nil
-	}
-
-	sd, err := ParseSecurityDescriptor([]byte(rawsd))
-	if err == nil {
-		o.sdcache = &sd
-		SecurityDescriptorCache[cacheindex] = &sd
-	}
-	return err
+	securityDescriptorCacheLock.Lock()
+	sd, found := SecurityDescriptorCache[cacheindex]
+	securityDescriptorCacheLock.Unlock()
+	if !found {
+		parsed, err := ParseSecurityDescriptor([]byte(rawsd))
+		if err != nil {
+			return err
+		}
+		sd = &parsed
+		securityDescriptorCacheLock.Lock()
+		SecurityDescriptorCache[cacheindex] = sd
+		securityDescriptorCacheLock.Unlock()
+	}
+	o.lock.Lock()
+	o.sdcache = sd
+	o.lock.Unlock()
+	return nil
 }
 
 func (o *Object) Value() int {
 	// We cache this, as it's heavy to calculate (0 = not calulated, -1 = cached zero value, otherwise the power factor)
-	if o.value != 0 {
-		if o.value == -1 {
+	o.lock.RLock()
+	cached := o.value
+	o.lock.RUnlock()
+	if cached != 0 {
+		if cached == -1 {
 			return 0
 		}
-		return o.value
+		return cached
 	}
 	var value int
 
@@ -455,8 +611,16 @@ func (o *Object) Value() int {
 		value += 1
 	}
 
+	// Takes over the cloud tenant, and probably the on-prem AD too via sync or device management
+	if o.OneAttr(MetaEntraPrivileged) == "1" {
+		value += 50
+		if entraPrivilegedWithoutMFA(o) {
+			value += 50
+		}
+	}
+
 	targets := make(map[*Object]struct{})
-	for _, cp := range o.CanPwn {
+	for _, cp := range o.CanPwnSnapshot() {
 		targets[cp.Target] = struct{}{}
 	}
 
@@ -464,36 +628,86 @@ func (o *Object) Value() int {
 		value += target.Value()
 	}
 
+	o.lock.Lock()
 	if value == 0 {
 		o.value = -1
 	} else {
 		o.value = value
 	}
+	o.lock.Unlock()
 	return value
 }
 
 func (o *Object) SID() SID {
-	if !o.sidcached {
-		o.sidcached = true
+	o.lock.RLock()
+	sid, cached := o.sid, o.sidcached
+	o.lock.RUnlock()
+	if !cached {
 		var err error
 		rawsid := o.OneAttr(ObjectSid)
 		if rawsid != "" {
-			o.sid, _, err = ParseSID([]byte(rawsid))
+			sid, _, err = ParseSID([]byte(rawsid))
 			if err != nil {
-				log.Fatal().Msgf("Could not parse SID %0x: %v", []byte(rawsid), err)
+				RecordProblem("convert", o.DN(), nil, fmt.Sprintf("Could not parse SID %0x: %v", []byte(rawsid), err))
 			}
 		}
+		o.lock.Lock()
+		o.sid, o.sidcached = sid, true
+		o.lock.Unlock()
 	}
-	return o.sid
+	return sid
 }
 
 func (o *Object) GUID() uuid.UUID {
-	if !o.guidcached {
-		rawguid := o.OneAttr(ObjectGUID)
-		o.guid = uuid.FromBytesOrNil([]byte(rawguid))
-		o.guidcached = true
+	o.lock.RLock()
+	guid, cached := o.guid, o.guidcached
+	o.lock.RUnlock()
+	if !cached {
+		guid = uuid.FromBytesOrNil([]byte(o.OneAttr(ObjectGUID)))
+		o.lock.Lock()
+		o.guid, o.guidcached = guid, true
+		o.lock.Unlock()
+	}
+	return guid
+}
+
+// Records that o can pwn target with the method, on both of them
+func (o *Object) AddPwn(target *Object, method PwnMethod) {
+	o.lock.Lock()
+	o.CanPwn = o.CanPwn.Set(target, method)
+	o.lock.Unlock()
+	target.lock.Lock()
+	target.PwnableBy = target.PwnableBy.Set(o, method)
+	target.lock.Unlock()
+}
+
+// Returns a copy of the attributes, that can be used while others are added. The values are shared, except the
+// compressed ones which are uncompressed
+func (o *Object) AttributesSnapshot() map[Attribute][]string {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	result := make(map[Attribute][]string, len(o.Attributes)+len(o.compressed))
+	for attr, values := range o.Attributes {
+		result[attr] = values
+	}
+	for attr, compressed := range o.compressed {
+		result[attr] = uncompressValues(compressed)
 	}
-	return o.guid
+	return result
+}
+
+// Returns a copy of who the object can pwn, that can be used while the analysis adds more
+func (o *Object) CanPwnSnapshot() PwnSet {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return append(PwnSet(nil), o.CanPwn...)
+}
+
+// Returns a copy of who can pwn the object, that can be used while the analysis adds more
+func (o *Object) PwnableBySnapshot() PwnSet {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return append(PwnSet(nil), o.PwnableBy...)
 }
 
 /*
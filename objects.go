@@ -2,20 +2,26 @@ package main
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/gofrs/uuid"
 	"github.com/rs/zerolog/log"
 )
 
 type Objects struct {
-	Domain    string // tld
-	Base      string // dc=blabla,dc=com
-	asarray   []*Object
-	objectmap map[*Object]struct{}
-	dnmap     map[string]*Object
-	sidmap    map[SID]*Object
-	guidmap   map[uuid.UUID]*Object
-	typecount [OBJECTTYPEMAX]int
+	Domain string // tld
+	Base   string // dc=blabla,dc=com
+
+	// Guards the indexes, so objects can be added while others look them up
+	lock sync.RWMutex
+
+	asarray     []*Object
+	objectmap   map[*Object]struct{}
+	dnmap       map[string]*Object
+	sidmap      map[SID]*Object
+	guidmap     map[uuid.UUID]*Object
+	computermap map[string]*Object // lowercased NetBIOS and DNS names
+	typecount   [OBJECTTYPEMAX + 1]int
 
 	classmap map[string]*Object // top, user, person -> schema object
 }
@@ -26,6 +32,7 @@ func (os *Objects) Init(base string) {
 	os.dnmap = make(map[string]*Object)
 	os.sidmap = make(map[SID]*Object)
 	os.guidmap = make(map[uuid.UUID]*Object)
+	os.computermap = make(map[string]*Object)
 
 	os.classmap = make(map[string]*Object)
 }
@@ -34,7 +41,15 @@ func (os *Objects) Filter(evaluate func(o *Object) bool) *Objects {
 	var result Objects
 	result.Init(os.Base)
 
+	// Evaluation can look up other objects, so it's done without holding the lock
+	os.lock.RLock()
+	objects := make([]*Object, 0, len(os.dnmap))
 	for _, object := range os.dnmap {
+		objects = append(objects, object)
+	}
+	os.lock.RUnlock()
+
+	for _, object := range objects {
 		if evaluate(object) {
 			result.Add(object)
 		}
@@ -43,6 +58,12 @@ func (os *Objects) Filter(evaluate func(o *Object) bool) *Objects {
 }
 
 func (os *Objects) Add(o *Object) {
+	os.lock.Lock()
+	defer os.lock.Unlock()
+	os.add(o)
+}
+
+func (os *Objects) add(o *Object) {
 	os.asarray = append(os.asarray, o)
 	os.objectmap[o] = struct{}{}
 	os.dnmap[strings.ToLower(o.DN())] = o
@@ -50,24 +71,20 @@ func (os *Objects) Add(o *Object) {
 		sid, _, err := ParseSID([]byte(sidstring))
 		if err == nil {
 			existing, dupe := os.sidmap[sid]
-			if dupe {
+			if dupe && existing.SID() == sid {
 				log.Warn().Msgf("Duplicate SID when trying to add %v, already exists as %v, skipping import", o.DN(), existing.DN())
 			} else {
-				// log.Print("Adding", sid)
+				// The object with the SID wins over one having it in SID history
 				os.sidmap[sid] = o
 			}
 		}
 	}
-	if sidstring := o.OneAttr(SIDHistory); sidstring != "" {
-		sid, _, err := ParseSID([]byte(sidstring))
-		if err == nil {
-			existing, dupe := os.sidmap[sid]
-			if dupe {
-				log.Warn().Msgf("Duplicate SID when trying to add SIDhistory %v, already exists as %v, skipping import", o.DN(), existing.DN())
-			} else {
-				log.Debug().Msgf("Object %v with SIDHistory added", o.DN())
-				os.sidmap[sid] = o
-			}
+	// SID history points to the object when the one it's from isn't loaded, so what is granted to it is found
+	for _, sid := range o.SIDHistory() {
+		if existing, dupe := os.sidmap[sid]; dupe {
+			log.Debug().Msgf("SID history of %v is %v, which is loaded", o.DN(), existing.DN())
+		} else {
+			os.sidmap[sid] = o
 		}
 	}
 	if guidstring := o.OneAttr(ObjectGUID); guidstring != "" {
@@ -76,6 +93,15 @@ func (os *Objects) Add(o *Object) {
 		os.guidmap[guid] = o
 	}
 
+	if o.Type() == ObjectTypeComputer {
+		if samaccountname := o.OneAttr(SAMAccountName); samaccountname != "" {
+			os.computermap[strings.ToLower(strings.TrimSuffix(samaccountname, "$"))] = o
+		}
+		if dnshostname := o.OneAttr(DNSHostName); dnshostname != "" {
+			os.computermap[strings.ToLower(dnshostname)] = o
+		}
+	}
+
 	// Attributes etc
 	ldn := o.OneAttr(LDAPDisplayName)
 	if ldn != "" {
@@ -86,24 +112,48 @@ func (os *Objects) Add(o *Object) {
 	os.typecount[o.Type()]++
 }
 
-func (os Objects) Statistics() [OBJECTTYPEMAX]int {
+func (os *Objects) Statistics() [OBJECTTYPEMAX + 1]int {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
 	return os.typecount
 }
 
-func (os Objects) AsArray() []*Object {
-	return os.asarray
+// Returns the number of entries in the DN, SID, GUID and computer name indexes
+func (os *Objects) IndexCounts() (dns, sids, guids, computers int) {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
+	return len(os.dnmap), len(os.sidmap), len(os.guidmap), len(os.computermap)
+}
+
+// Returns the objects added so far. Objects added later are not in the returned slice
+func (os *Objects) AsArray() []*Object {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
+	return os.asarray[:len(os.asarray):len(os.asarray)]
 }
 
 func (os *Objects) Contains(o *Object) (found bool) {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
 	_, found = os.objectmap[o]
 	return
 }
 
 func (os *Objects) Find(dn string) (o *Object, found bool) {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
 	o, found = os.dnmap[strings.ToLower(dn)]
 	return
 }
 
+// Finds a computer by its NetBIOS name (without the trailing $) or DNS name
+func (os *Objects) FindComputer(name string) (o *Object, found bool) {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
+	o, found = os.computermap[strings.ToLower(name)]
+	return
+}
+
 func (os *Objects) Parent(o *Object) (*Object, bool) {
 	var dn = o.DN()
 	for {
@@ -142,35 +192,82 @@ func (os *Objects) Subordinates(o *Object) *Objects {
 }
 
 func (os *Objects) FindSID(s SID) (o *Object, found bool) {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
 	o, found = os.sidmap[s]
 	return
 }
 
+// Removes an extra SID (from SID history) that points to the object, returns true if it did
+func (os *Objects) RemoveSIDAlias(s SID, o *Object) bool {
+	os.lock.Lock()
+	defer os.lock.Unlock()
+	if existing, found := os.sidmap[s]; found && existing == o && o.SID() != s {
+		delete(os.sidmap, s)
+		return true
+	}
+	return false
+}
+
+// Adds an extra SID (from SID history) that points to the object, unless something has it already
+func (os *Objects) AddSIDAlias(s SID, o *Object) bool {
+	os.lock.Lock()
+	defer os.lock.Unlock()
+	if _, found := os.sidmap[s]; found {
+		return false
+	}
+	os.sidmap[s] = o
+	return true
+}
+
 func (os *Objects) FindOrAddSID(s SID) *Object {
-	o, found := os.FindSID(s)
+	return os.findOrAddSID(s, nil)
+}
+
+// Like FindOrAddSID, but what's added is a group, so the memberships of it are followed
+func (os *Objects) FindOrAddGroupSID(s SID, name string) *Object {
+	return os.findOrAddSID(s, map[Attribute][]string{
+		Name:           {name},
+		ObjectCategory: {"CN=Group,CN=Schema,CN=Configuration," + os.Base},
+		ObjectClass:    {"top", "group"},
+		Description:    {"Synthetic group"},
+	})
+}
+
+func (os *Objects) findOrAddSID(s SID, attributes map[Attribute][]string) *Object {
+	os.lock.Lock()
+	defer os.lock.Unlock()
+	o, found := os.sidmap[s]
 	if found {
 		return o
 	}
 	u, _ := uuid.NewV4()
 	o = &Object{
-		DistinguishedName: "CN=" + s.String() + ",CN=synthetic",
+		DistinguishedName: "CN=" + s.ToString() + ",CN=synthetic",
 		Attributes: map[Attribute][]string{
-			Name:       {s.String()},
+			Name:       {s.ToString()},
 			ObjectGUID: {string(u.Bytes())},
 			ObjectSid:  {string(s)},
 		},
 	}
-	log.Info().Msgf("Adding unknown SID %v as %v", s, o.DistinguishedName)
-	os.Add(o)
+	for attr, values := range attributes {
+		o.Attributes[attr] = values
+	}
+	log.Info().Msgf("Adding unknown SID %v as %v", s.ToString(), o.DistinguishedName)
+	os.add(o)
 	return o
 }
 
 func (os *Objects) FindGUID(g uuid.UUID) (o *Object, found bool) {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
 	o, found = os.guidmap[g]
 	return
 }
 
 func (os *Objects) FindClass(class string) (o *Object, found bool) {
+	os.lock.RLock()
+	defer os.lock.RUnlock()
 	o, found = os.classmap[strings.ToLower(class)]
 	return
 }
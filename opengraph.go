@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gofrs/uuid"
+)
+
+// BloodHound OpenGraph, the JSON BloodHound CE imports arbitrary nodes and edges from. Nodes get the objectid
+// BloodHound uses for the same object (the SID, or the GUID for objects without one), so importing next to a
+// SharpHound collection connects to the nodes already there
+
+type OpenGraphFile struct {
+	Metadata OpenGraphMetadata `json:"metadata"`
+	Graph    OpenGraph         `json:"graph"`
+}
+
+type OpenGraphMetadata struct {
+	SourceKind string `json:"source_kind"`
+}
+
+type OpenGraph struct {
+	Nodes []OpenGraphNode `json:"nodes"`
+	Edges []OpenGraphEdge `json:"edges"`
+}
+
+type OpenGraphNode struct {
+	ID         string                 `json:"id"`
+	Kinds      []string               `json:"kinds"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type OpenGraphEdge struct {
+	Kind       string                 `json:"kind"`
+	Start      OpenGraphEndpoint      `json:"start"`
+	End        OpenGraphEndpoint      `json:"end"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+type OpenGraphEndpoint struct {
+	Value   string `json:"value"`
+	MatchBy string `json:"match_by"`
+}
+
+// BloodHound node kinds for the object types it has, the rest are only Adalanche kinds
+var openGraphNodeKinds = map[ObjectType]string{
+	ObjectTypeUser:                  "User",
+	ObjectTypeGroup:                 "Group",
+	ObjectTypeComputer:              "Computer",
+	ObjectTypeManagedServiceAccount: "User",
+	ObjectTypeOrganizationalUnit:    "OU",
+	ObjectTypeContainer:             "Container",
+	ObjectTypeGroupPolicyContainer:  "GPO",
+}
+
+// BloodHound edge kinds meaning the same as a method, so queries written for BloodHound find them. Everything else
+// keeps the method name
+var openGraphEdgeKinds = map[PwnMethod]string{
+	PwnMemberOfGroup:          "MemberOf",
+	PwnResetPassword:          "ForceChangePassword",
+	PwnWriteAll:               "GenericWrite",
+	PwnWriteDACL:              "WriteDacl",
+	PwnTakeOwnership:          "WriteOwner",
+	PwnReadMSAPassword:        "ReadGMSAPassword",
+	PwnWriteAllowedToAct:      "AddAllowedToAct",
+	PwnAllExtendedRights:      "AllExtendedRights",
+	PwnAddSelfMember:          "AddSelf",
+	PwnLocalAdminRights:       "AdminTo",
+	PwnLocalRDPRights:         "CanRDP",
+	PwnLocalDCOMRights:        "ExecuteDCOM",
+	PwnWriteKeyCredentialLink: "AddKeyCredentialLink",
+}
+
+// The id BloodHound gives the object, uppercase like SharpHound writes them
+func openGraphID(o *Object) string {
+	if sid := o.SID(); !sid.IsNull() {
+		return sid.ToString()
+	}
+	if guid := o.GUID(); guid != uuid.Nil {
+		return strings.ToUpper(guid.String())
+	}
+	return o.DN()
+}
+
+// Writes the graph as BloodHound OpenGraph JSON, with an edge for each method of each connection
+func WriteOpenGraph(w io.Writer, pg PwnGraph) error {
+	targets := make(map[*Object]struct{})
+	for _, target := range pg.Targets {
+		targets[target] = struct{}{}
+	}
+
+	file := OpenGraphFile{
+		Metadata: OpenGraphMetadata{SourceKind: "Adalanche"},
+		Graph: OpenGraph{
+			Nodes: make([]OpenGraphNode, 0, len(pg.Implicated)),
+			Edges: make([]OpenGraphEdge, 0, len(pg.Connections)),
+		},
+	}
+	for _, object := range pg.Implicated {
+		kinds := []string{"AdalancheObject"}
+		if kind, found := openGraphNodeKinds[object.Type()]; found {
+			kinds = []string{kind, "Base"}
+		}
+		_, istarget := targets[object]
+		properties := map[string]interface{}{
+			"name":              object.Label(),
+			"distinguishedname": object.DN(),
+			"objectid":          openGraphID(object),
+			"type":              object.Type().String(),
+			"tier0":             object.IsTier0(),
+			"target":            istarget,
+		}
+		if samaccountname := object.OneAttr(SAMAccountName); samaccountname != "" {
+			properties["samaccountname"] = samaccountname
+		}
+		file.Graph.Nodes = append(file.Graph.Nodes, OpenGraphNode{
+			ID:         openGraphID(object),
+			Kinds:      kinds,
+			Properties: properties,
+		})
+	}
+
+	for _, connection := range pg.Connections {
+		start := OpenGraphEndpoint{Value: openGraphID(connection.Source), MatchBy: "id"}
+		end := OpenGraphEndpoint{Value: openGraphID(connection.Target), MatchBy: "id"}
+		confidence, uncertain := EdgeConfidence(connection.Source, connection.Target, connection.Methods)
+		for i := 0; i < 64; i++ {
+			method := PwnMethod(1 << i)
+			if connection.Methods&method == 0 {
+				continue
+			}
+			kind, found := openGraphEdgeKinds[method]
+			if !found {
+				kind = method.String()
+			}
+			edge := OpenGraphEdge{
+				Kind:       kind,
+				Start:      start,
+				End:        end,
+				Properties: map[string]interface{}{"method": method.String()},
+			}
+			if uncertain {
+				edge.Properties["confidence"] = confidence
+			}
+			file.Graph.Edges = append(file.Graph.Edges, edge)
+		}
+	}
+
+	encoder := qjson.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(file)
+}
+
+func ExportOpenGraph(pg PwnGraph, filename string) error {
+	df, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+	return WriteOpenGraph(df, pg)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The command palette in the UI (Ctrl+K) jumps to objects, runs saved queries, turns methods on and off and switches
+// between the domains in the data folder. Queries saved from it are kept in the data folder, so everyone using the
+// same adalanche sees them
+
+const savedQueriesFile = "adalanche.queries.json"
+
+// Objects found when searching, best matches first
+const searchLimit = 25
+
+type SavedQuery struct {
+	Name    string `json:"name"`
+	Query   string `json:"query"`
+	Mode    string `json:"mode,omitempty"`
+	Depth   int    `json:"depth,omitempty"`
+	Methods string `json:"methods,omitempty"` // Space separated, like the sample queries
+}
+
+var savedQueriesLock sync.Mutex
+
+type searchResult struct {
+	DN             string `json:"distinguishedname"`
+	Name           string `json:"name"`
+	SAMAccountName string `json:"samaccountname,omitempty"`
+	Type           string `json:"type"`
+}
+
+// Objects with the text in their name, account name, display name or DN. Names that are the text come first, then
+// names starting with it
+func searchObjects(text string, limit int) []searchResult {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return nil
+	}
+	type match struct {
+		o    *Object
+		rank int
+	}
+	var matches []match
+	for _, o := range AllObjects.AsArray() {
+		rank := -1
+		for _, value := range []string{o.OneAttr(Name), o.OneAttr(SAMAccountName), o.OneAttr(DisplayName)} {
+			value = strings.ToLower(value)
+			switch {
+			case value == "":
+			case value == text:
+				rank = 0
+			case strings.HasPrefix(value, text) && (rank == -1 || rank > 1):
+				rank = 1
+			case strings.Contains(value, text) && (rank == -1 || rank > 2):
+				rank = 2
+			}
+		}
+		if rank == -1 && strings.Contains(strings.ToLower(o.DN()), text) {
+			rank = 3
+		}
+		if rank != -1 {
+			matches = append(matches, match{o, rank})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return matches[i].o.Label() < matches[j].o.Label()
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	results := make([]searchResult, len(matches))
+	for i, m := range matches {
+		results[i] = searchResult{
+			DN:             m.o.DN(),
+			Name:           m.o.Label(),
+			SAMAccountName: m.o.OneAttr(SAMAccountName),
+			Type:           m.o.Type().String(),
+		}
+	}
+	return results
+}
+
+func loadSavedQueries(datapath string) ([]SavedQuery, error) {
+	data, err := os.ReadFile(filepath.Join(datapath, savedQueriesFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var queries []SavedQuery
+	return queries, json.Unmarshal(data, &queries)
+}
+
+func writeSavedQueries(datapath string, queries []SavedQuery) error {
+	data, err := json.MarshalIndent(queries, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(datapath, ".queries-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(data)
+	if closeerr := temp.Close(); err == nil {
+		err = closeerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filepath.Join(datapath, savedQueriesFile))
+}
+
+// Saves the query, over one with the same name
+func saveQuery(datapath string, query SavedQuery) error {
+	query.Name = strings.TrimSpace(query.Name)
+	if query.Name == "" || query.Query == "" {
+		return errors.New("a saved query needs a name and a query")
+	}
+	savedQueriesLock.Lock()
+	defer savedQueriesLock.Unlock()
+	queries, err := loadSavedQueries(datapath)
+	if err != nil {
+		return err
+	}
+	var replaced bool
+	for i := range queries {
+		if strings.EqualFold(queries[i].Name, query.Name) {
+			queries[i], replaced = query, true
+		}
+	}
+	if !replaced {
+		queries = append(queries, query)
+	}
+	return writeSavedQueries(datapath, queries)
+}
+
+// Returns false if there is no query with the name
+func deleteSavedQuery(datapath, name string) (bool, error) {
+	savedQueriesLock.Lock()
+	defer savedQueriesLock.Unlock()
+	queries, err := loadSavedQueries(datapath)
+	if err != nil {
+		return false, err
+	}
+	for i := range queries {
+		if strings.EqualFold(queries[i].Name, name) {
+			return true, writeSavedQueries(datapath, append(queries[:i], queries[i+1:]...))
+		}
+	}
+	return false, nil
+}
+
+// Domains with a dump (or one converted to SQLite) in the data folder
+func availableDomains(datapath string) []string {
+	files, err := os.ReadDir(datapath)
+	if err != nil {
+		return nil
+	}
+	found := make(map[string]struct{})
+	for _, file := range files {
+		for _, suffix := range []string{".objects.lz4.msgp", ".objects.sqlite"} {
+			if strings.HasSuffix(file.Name(), suffix) && !file.IsDir() {
+				found[strings.TrimSuffix(file.Name(), suffix)] = struct{}{}
+			}
+		}
+	}
+	domains := make([]string, 0, len(found))
+	for domain := range found {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// Loads the comma separated domains instead of what is loaded now. Only domains with a dump in the data folder can
+// be loaded, so a request can't point somewhere else on disk
+func switchDataset(domains, datapath string) error {
+	available := availableDomains(datapath)
+	var wanted []string
+	for _, domain := range strings.Split(domains, ",") {
+		domain = strings.TrimSpace(domain)
+		if !StringInSlice(domain, available) {
+			return errors.New("no data for " + domain)
+		}
+		wanted = append(wanted, domain)
+	}
+	datasetLock.RLock()
+	importall := datasetImportAll
+	datasetLock.RUnlock()
+	return ReloadDataset(strings.Join(wanted, ","), datapath, importall)
+}
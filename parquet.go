@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Rows of the node and edge tables in Parquet exports. The id of a node is its position in the export, and the edges
+// point to those. Join the nodes on objectSid, sAMAccountName or distinguishedName to get to your own data
+
+type ParquetNode struct {
+	ID                int64  `parquet:"name=id, type=INT64"`
+	GUID              string `parquet:"name=objectGUID, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DistinguishedName string `parquet:"name=distinguishedName, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name              string `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SAMAccountName    string `parquet:"name=sAMAccountName, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SID               string `parquet:"name=objectSid, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Type              string `parquet:"name=type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Tier0             bool   `parquet:"name=tier0, type=BOOLEAN"`
+	Target            bool   `parquet:"name=target, type=BOOLEAN"`
+}
+
+type ParquetEdge struct {
+	Source         int64    `parquet:"name=source, type=INT64"`
+	Target         int64    `parquet:"name=target, type=INT64"`
+	Methods        []string `parquet:"name=methods, type=MAP, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	ReverseMethods []string `parquet:"name=reverse_methods, type=MAP, convertedtype=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	Count          int32    `parquet:"name=count, type=INT32"`
+	Confidence     *float64 `parquet:"name=confidence, type=DOUBLE"` // Only for connections that may not work, from aging data or luck
+}
+
+// Writes the graph as two Parquet files, basename.nodes.parquet and basename.edges.parquet
+func ExportParquet(pg PwnGraph, basename string, aggregate bool) error {
+	targets := make(map[*Object]struct{})
+	for _, target := range pg.Targets {
+		targets[target] = struct{}{}
+	}
+	ids := make(map[*Object]int64)
+
+	err := writeParquet(basename+".nodes.parquet", new(ParquetNode), func(pw *writer.ParquetWriter) error {
+		for i, object := range pg.Implicated {
+			ids[object] = int64(i)
+			_, istarget := targets[object]
+			node := ParquetNode{
+				ID:                int64(i),
+				DistinguishedName: object.DN(),
+				Name:              object.OneAttr(Name),
+				SAMAccountName:    object.OneAttr(SAMAccountName),
+				SID:               object.SID().ToString(),
+				Type:              object.Type().String(),
+				Tier0:             object.IsTier0(),
+				Target:            istarget,
+			}
+			if guid := object.GUID(); guid != uuid.Nil {
+				node.GUID = guid.String()
+			}
+			if err := pw.Write(node); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	edges := ExportEdges(pg, aggregate)
+	err = writeParquet(basename+".edges.parquet", new(ParquetEdge), func(pw *writer.ParquetWriter) error {
+		for _, edge := range edges {
+			source, found := ids[edge.Source]
+			if !found {
+				log.Error().Msg("Source object not found - this should never happen")
+				continue
+			}
+			target, found := ids[edge.Target]
+			if !found {
+				log.Error().Msg("Target object not found - this should never happen")
+				continue
+			}
+			row := ParquetEdge{
+				Source:  source,
+				Target:  target,
+				Methods: edge.Methods.StringSlice(),
+				Count:   int32(edge.Count),
+			}
+			if edge.ReverseMethods != 0 {
+				row.ReverseMethods = edge.ReverseMethods.StringSlice()
+			}
+			if !aggregate {
+				if c, uncertain := EdgeConfidence(edge.Source, edge.Target, edge.Methods); uncertain {
+					row.Confidence = &c
+				}
+			}
+			if err := pw.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Exported %v nodes and %v edges to %v.nodes.parquet and %v.edges.parquet", len(pg.Implicated), len(edges), basename, basename)
+	return nil
+}
+
+func writeParquet(filename string, schema interface{}, f func(pw *writer.ParquetWriter) error) error {
+	df, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+	pw, err := writer.NewParquetWriterFromWriter(df, schema, 4)
+	if err != nil {
+		return err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	if err = f(pw); err != nil {
+		return err
+	}
+	if err = pw.WriteStop(); err != nil {
+		return err
+	}
+	return df.Close()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Something that went wrong with a single object while loading or analyzing, kept so data quality issues can be
+// looked at instead of stopping everything or disappearing in the log
+type Problem struct {
+	Stage string             `json:"stage"` // decode, convert or analyze
+	DN    string             `json:"dn"`
+	Error string             `json:"error"`
+	Raw   []ProblemAttribute `json:"raw,omitempty"` // The object as it was in the dump, if we have it
+}
+
+type ProblemAttribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"` // Binary values are hex encoded
+}
+
+var (
+	AllProblems []Problem
+	problemlock sync.Mutex
+)
+
+// Records a problem with an object. raw is the object as dumped, and can be nil
+func RecordProblem(stage, dn string, raw *RawObject, problem interface{}) {
+	p := Problem{
+		Stage: stage,
+		DN:    dn,
+		Error: fmt.Sprintf("%v", problem),
+	}
+	if raw != nil {
+		for name, values := range raw.Attributes {
+			attribute := ProblemAttribute{Name: name}
+			for _, value := range values {
+				if !utf8.ValidString(value) {
+					value = hex.EncodeToString([]byte(value))
+				}
+				attribute.Values = append(attribute.Values, value)
+			}
+			p.Raw = append(p.Raw, attribute)
+		}
+		sort.Slice(p.Raw, func(i, j int) bool {
+			return p.Raw[i].Name < p.Raw[j].Name
+		})
+	}
+	log.Warn().Msgf("Problem during %v of %v: %v", stage, dn, p.Error)
+
+	problemlock.Lock()
+	AllProblems = append(AllProblems, p)
+	problemlock.Unlock()
+}
+
+// Runs f, recording a panic as a problem with the object instead of crashing. Returns false if f panicked
+func guardObject(stage, dn string, raw *RawObject, f func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportCrash(stage, dn, raw, r)
+			RecordProblem(stage, dn, raw, r)
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
+
+func problemsReport() []Finding {
+	problemlock.Lock()
+	defer problemlock.Unlock()
+	var findings []Finding
+	for _, p := range AllProblems {
+		findings = append(findings, Finding{DN: p.DN, Detail: p.Stage + ": " + p.Error})
+	}
+	return findings
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/schollz/progressbar/v3"
+)
+
+// Prepares the loaded objects and runs all the analyzers on them, so every object knows who can pwn it and who it can pwn
+func ProcessObjects(domain string) {
+	// Put values in the form the schema says they should be in
+	log.Debug().Msgf("Schema has syntax for %v attributes", LoadSchemaSyntaxes())
+	CoerceAttributeValues()
+
+	// Back links like memberOf aren't always in the dump, so fill them in from the forward links
+	log.Debug().Msgf("Added %v back links from forward links", ComputeBackLinks())
+
+	// Add our known SIDs if they're missing
+	for sid, name := range knownsids {
+		binsid, err := SIDFromString(sid)
+		if err != nil {
+			log.Fatal().Msgf("Problem parsing SID %v", sid)
+		}
+		if _, found := AllObjects.FindSID(binsid); !found {
+			dn := "CN=" + name + ",CN=microsoft-builtin"
+			log.Info().Msgf("Adding missing well known SID %v (%v) as %v", name, sid, dn)
+			AllObjects.Add(&Object{
+				DistinguishedName: dn,
+				Attributes: map[Attribute][]string{
+					Name:           {name},
+					ObjectSid:      {string(binsid)},
+					ObjectClass:    {"person", "user", "top"},
+					ObjectCategory: {"Group"},
+				},
+			})
+		}
+	}
+
+	// ShowAttributePopularity()
+
+	// Generate member of chains
+	processbar := progressbar.NewOptions(int(len(AllObjects.dnmap)),
+		progressbar.OptionSetDescription("Processing objects..."),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetItsString("objects"),
+		progressbar.OptionOnCompletion(func() { fmt.Println() }),
+		progressbar.OptionThrottle(time.Second*1),
+	)
+
+	// everyonesid, _ := SIDFromString("S-1-1-0")
+	// everyone, ok := AllObjects.FindSID(everyonesid)
+	// if !ok {
+	// 	log.Fatal().Msgf("Could not locate Everyone, aborting")
+	// }
+
+	// authenticateduserssid, _ := SIDFromString("S-1-5-11")
+	// authenticatedusers, ok := AllObjects.FindSID(authenticateduserssid)
+	// if !ok {
+	// 	log.Fatal().Msgf("Could not locate Authenticated Users, aborting")
+	// }
+
+	LinkTrusts()
+	addMachineAccountQuotaNodes()
+
+	log.Info().Msg("Pre-processing directory data ...")
+	// Windows LAPS attribute name -> schemaIDGUID
+	windowsLAPS := make(map[string]uuid.UUID)
+	keydevices := knownKeyCredentialDevices()
+	for _, object := range AllObjects.AsArray() {
+		processbar.Add(1)
+		object.MemberOf()
+
+		// Crude special handling for Everyone and Authenticated Users
+		// if object.Type() == ObjectTypeUser || object.Type() == ObjectTypeComputer || object.Type() == ObjectTypeManagedServiceAccount {
+		// 	everyone.imamemberofyou(object)
+		// 	authenticatedusers.imamemberofyou(object)
+		// 	object.memberof = append(object.memberof, everyone, authenticatedusers)
+		// }
+
+		object.SetAttr(MetaType, object.Type().String())
+		if lastlogon, ok := object.AttrTimestamp(LastLogonTimestamp); ok {
+			object.SetAttr(MetaLastLoginAge, strconv.Itoa(int(time.Since(lastlogon)/time.Hour)))
+		}
+		if passwordlastset, ok := object.AttrTimestamp(PwdLastSet); ok {
+			object.SetAttr(MetaPasswordAge, strconv.Itoa(int(time.Since(passwordlastset)/time.Hour)))
+		}
+		if strings.Contains(strings.ToLower(object.OneAttr(OperatingSystem)), "linux") {
+			object.SetAttr(MetaLinux, "1")
+		}
+		if strings.Contains(strings.ToLower(object.OneAttr(OperatingSystem)), "windows") {
+			object.SetAttr(MetaWindows, "1")
+		}
+		if len(object.Attr(MSmcsAdmPwdExpirationTime)) > 0 || len(object.Attr(LAPSPasswordExpirationTime)) > 0 {
+			object.SetAttr(MetaLAPSInstalled, "1")
+		}
+		keys, err := object.KeyCredentials()
+		if err != nil {
+			RecordProblem("analyze", object.DN(), nil, "Problem parsing msDS-KeyCredentialLink: "+err.Error())
+		}
+		if len(keys) > 0 {
+			object.SetAttr(MetaKeyCredentials, strconv.Itoa(len(keys)))
+			for _, kc := range keys {
+				if keyCredentialRogue(object, kc, keydevices) != "" {
+					object.SetAttr(MetaRogueKeyCredential, "1")
+				}
+			}
+		}
+		// All bits set means logon is permitted at all hours
+		if logonhours := object.OneAttr(LogonHours); logonhours != "" && logonhours != strings.Repeat("\xff", 21) {
+			object.SetAttr(MetaLogonHoursRestricted, "1")
+		}
+		if object.OneAttr(UserWorkstations) != "" {
+			object.SetAttr(MetaWorkstationRestricted, "1")
+		}
+		if err := object.expandUserParameters(); err != nil {
+			log.Debug().Msgf("Could not parse userParameters on %v: %v", object.DN(), err)
+		}
+		if uac, ok := object.AttrInt(UserAccountControl); ok {
+			if uac&UAC_TRUSTED_FOR_DELEGATION != 0 {
+				object.SetAttr(MetaUnconstrainedDelegation, "1")
+			}
+			if uac&UAC_TRUSTED_TO_AUTH_FOR_DELEGATION != 0 || len(object.Attr(MSDSAllowedToDelegateTo)) > 0 {
+				object.SetAttr(MetaConstrainedDelegation, "1")
+			}
+			if uac&UAC_NOT_DELEGATED != 0 {
+				log.Debug().Msgf("%v has can't be used as delegation", object.DN())
+			}
+			if uac&UAC_WORKSTATION_TRUST_ACCOUNT != 0 {
+				object.SetAttr(MetaWorkstation, "1")
+			}
+			if uac&UAC_SERVER_TRUST_ACCOUNT != 0 {
+				object.SetAttr(MetaServer, "1")
+			}
+			if uac&UAC_ACCOUNTDISABLE != 0 {
+				object.SetAttr(MetaAccountDisabled, "1")
+			}
+			if uac&UAC_PASSWD_CANT_CHANGE != 0 {
+				object.SetAttr(MetaPasswordCantChange, "1")
+			}
+			if uac&UAC_DONT_EXPIRE_PASSWORD != 0 {
+				object.SetAttr(MetaPasswordNoExpire, "1")
+			}
+			if uac&UAC_PASSWD_NOTREQD != 0 {
+				object.SetAttr(MetaPasswordNotRequired, "1")
+			}
+		}
+
+		if objectGUID, isattribute := indexSchemaObject(object); isattribute {
+			switch object.OneAttr(LDAPDisplayName) {
+			case "msLAPS-Password", "msLAPS-EncryptedPassword", "msLAPS-EncryptedDSRMPassword":
+				windowsLAPS[object.OneAttr(LDAPDisplayName)] = objectGUID
+			}
+			switch object.OneAttr(Name) {
+			case "ms-Mcs-AdmPwd":
+				log.Info().Msg("Detected LAPS schema extension, adding extra analyzer")
+				PwnAnalyzers = append(PwnAnalyzers, PwnAnalyzer{
+					Method: PwnReadLAPSPassword,
+					ObjectAnalyzer: func(o *Object) []*Object {
+						var results []*Object
+						// Only for computers
+						if o.Type() != ObjectTypeComputer {
+							return results
+						}
+						// ... that has LAPS installed
+						if len(o.Attr(MSmcsAdmPwdExpirationTime)) == 0 {
+							return results
+						}
+						// Analyze ACL
+						sd, err := o.SecurityDescriptor()
+						if err != nil {
+							return results
+						}
+						for _, acl := range sd.DACL.Entries {
+							if acl.Type == ACETYPE_ACCESS_ALLOWED_OBJECT && acl.Mask&RIGHT_DS_READ_PROPERTY != 0 && acl.ObjectType == objectGUID {
+								results = append(results, AllObjects.FindOrAddSID(acl.SID))
+							}
+						}
+						return results
+					},
+				})
+			}
+		}
+	}
+	processbar.Finish()
+
+	// Custom meta attributes, before the indexes so the tier 0 query can use them
+	applyMetaRules()
+
+	if len(windowsLAPS) > 0 {
+		log.Info().Msg("Detected Windows LAPS schema extension, adding extra analyzer")
+		PwnAnalyzers = append(PwnAnalyzers, MakeWindowsLAPSPwnAnalyzer(windowsLAPS))
+	}
+
+	// This sucks in a very bad way, Objects really needs to be an AD object :-\
+	ad := AD{
+		Domain: domain,
+	}
+
+	// Find dsHeuristics, this defines groups EXCLUDED From AdminSDHolder application
+
+	// https://social.technet.microsoft.com/wiki/contents/articles/22331.adminsdholder-protected-groups-and-security-descriptor-propagator.aspx#What_is_a_protected_group
+
+	var excluded string
+	if ds, found := AllObjects.Find("CN=Directory Service,CN=Windows NT,CN=Services,CN=Configuration," + ad.RootDn()); found {
+		excluded = ds.OneAttr(DsHeuristics)
+	}
+
+	// Every domain has its own AdminSDHolder container, which "pwns" the objects in the domain with AdminCount > 0
+	for _, domain := range AllObjects.AsArray() {
+		if !StringInSlice("domainDNS", domain.Attr(ObjectClass)) {
+			continue
+		}
+		if adminsdholder, found := AllObjects.Find("cn=AdminSDHolder,cn=System," + domain.DN()); found {
+			PwnAnalyzers = append(PwnAnalyzers, MakeAdminSDHolderPwnanalyzerFunc(adminsdholder, excluded))
+		}
+	}
+
+	// Generate member of chains
+	pwnbar := progressbar.NewOptions(int(len(AllObjects.dnmap)),
+		progressbar.OptionSetDescription("Analyzing who can pwn who ..."),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowIts(),
+		progressbar.OptionSetItsString("objects"),
+		// progressbar.OptionShowBytes(true),
+		progressbar.OptionOnCompletion(func() { fmt.Println() }),
+		progressbar.OptionThrottle(time.Second*1),
+	)
+
+	// Indexes the analyzers look things up in, built up front as the analyzers run in parallel
+	buildIndexes()
+
+	// Objects are analyzed in parallel, adding pwns to each other as they go
+	var pwnlinks int64
+	work := make(chan *Object, 1024)
+	var workers sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for object := range work {
+				atomic.AddInt64(&pwnlinks, int64(analyzeObject(object)))
+				pwnbar.Add(1)
+			}
+		}()
+	}
+	for _, object := range AllObjects.AsArray() {
+		work <- object
+	}
+	close(work)
+	workers.Wait()
+	pwnbar.Finish()
+	log.Debug().Msgf("Detected %v ways to pwn objects", pwnlinks)
+
+	UpdateDatasetChecksum()
+}
+
+// Special types of objects: rights, schema classes and attributes are looked up by their GUIDs. Returns the
+// schemaIDGUID if it's an attribute
+func indexSchemaObject(object *Object) (uuid.UUID, bool) {
+	if object.HasAttrValue(ObjectClass, "controlAccessRight") {
+		u, err := uuid.FromString(object.OneAttr(A("rightsGuid")))
+		// log.Debug().Msgf("Adding right %v %v", u, object.OneAttr(DisplayName))
+		if err == nil {
+			AllRights[u] = object
+		}
+	} else if object.HasAttrValue(ObjectClass, "attributeSchema") {
+		objectGUID, err := uuid.FromBytes([]byte(object.OneAttr(A("schemaIDGUID"))))
+		objectGUID = SwapUUIDEndianess(objectGUID)
+		// log.Debug().Msgf("Adding schema attribute %v %v", u, object.OneAttr(Name))
+		if err == nil {
+			AllSchemaAttributes[objectGUID] = object
+			return objectGUID, true
+		}
+	} else if object.HasAttrValue(ObjectClass, "classSchema") {
+		u, err := uuid.FromBytes([]byte(object.OneAttr(A("schemaIDGUID"))))
+		u = SwapUUIDEndianess(u)
+		// log.Debug().Msgf("Adding schema class %v %v", u, object.OneAttr(Name))
+		if err == nil {
+			AllSchemaClasses[u] = object
+		}
+	}
+	return uuid.UUID{}, false
+}
+
+// Builds the lookups from the loaded objects and the local machine and SYSVOL data, which the analyzers and reports use
+func buildIndexes() {
+	buildTier0Extra()
+	buildSamePersonIndex()
+	buildServiceHosts()
+	buildSessionHosts()
+	buildSCCM()
+	buildMailboxDelegates()
+	buildConstrainedDelegation()
+	buildUnconstrainedHosts()
+	buildGPOLinks()
+	buildWindowsLAPSPolicies()
+	buildSIDHistory()
+}
+
+// Runs all the analyzers on the object, and returns how many pwns were found
+func analyzeObject(object *Object) int {
+	var pwnlinks int
+	for _, analyzer := range PwnAnalyzers {
+		var pwnobjects []*Object
+		if !guardObject("analyze", object.DN(), nil, func() {
+			pwnobjects = analyzer.ObjectAnalyzer(object)
+		}) {
+			continue
+		}
+		for _, pwnobject := range pwnobjects {
+			if pwnobject == object || pwnobject.SID() == object.SID() { // SID check solves (some) dual-AD analysis problems
+				// We don't care about self owns
+				continue
+			}
+
+			// Ignore these, SELF = self own, Creator/Owner always has full rights
+			if pwnobject.SID() == SelfSID || pwnobject.SID() == CreatorOwnerSID || pwnobject.SID() == SystemSID {
+				continue
+			}
+			// log.Debug().Msgf("Detected that %v can pwn %v by %v", pwnobject.DN(), object.DN(), analyzer.Method)
+			pwnobject.AddPwn(object, analyzer.Method)
+			pwnlinks++
+		}
+	}
+	return pwnlinks
+}
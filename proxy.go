@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Connections to domain controllers, replaced by a SOCKS dialer with -proxy
+var directoryDialer proxy.Dialer = &net.Dialer{Timeout: 30 * time.Second}
+
+// DNS lookups for domain controllers, sent as DNS over TCP through the proxy with -proxy
+var directoryResolver = net.DefaultResolver
+
+// Is a proxy in use
+var directoryProxied bool
+
+// Sends all connections to the directory through a SOCKS5 proxy, given as socks5://[user:password@]host:port
+func SetProxy(proxyurl string) error {
+	u, err := url.Parse(proxyurl)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "socks5", "socks5h":
+	default:
+		return errors.New("Only socks5:// proxies are supported")
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "1080")
+	}
+	dialer, err := proxy.FromURL(u, &net.Dialer{Timeout: 30 * time.Second})
+	if err != nil {
+		return err
+	}
+	directoryDialer = dialer
+	directoryResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			// SOCKS can only carry TCP, and the resolver speaks DNS over TCP on stream connections
+			return dialDirectory(ctx, "tcp", address)
+		},
+	}
+	directoryProxied = true
+	return nil
+}
+
+func dialDirectory(ctx context.Context, network, address string) (net.Conn, error) {
+	if cd, ok := directoryDialer.(proxy.ContextDialer); ok {
+		return cd.DialContext(ctx, network, address)
+	}
+	return directoryDialer.Dial(network, address)
+}
+
+// Connects to the address, through the proxy if there is one
+func dialDirectoryTimeout(address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return dialDirectory(ctx, "tcp", address)
+}
+
+// Connects to the address and does the TLS handshake, through the proxy if there is one
+func dialDirectoryTLS(address string, timeout time.Duration, config *tls.Config) (*tls.Conn, error) {
+	conn, err := dialDirectoryTimeout(address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	tlsconn := tls.Client(conn, config)
+	if err = tlsconn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsconn, nil
+}
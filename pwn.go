@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/gofrs/uuid"
@@ -52,6 +54,8 @@ var (
 	DSReplicationGetChanges    = uuid.UUID{0x11, 0x31, 0xf6, 0xaa, 0x9c, 0x07, 0x11, 0xd1, 0xf7, 0x9f, 0x00, 0xc0, 0x4f, 0xc2, 0xdc, 0xd2}
 	DSReplicationGetChangesAll = uuid.UUID{0x11, 0x31, 0xf6, 0xad, 0x9c, 0x07, 0x11, 0xd1, 0xf7, 0x9f, 0x00, 0xc0, 0x4f, 0xc2, 0xdc, 0xd2}
 	DSReplicationSyncronize    = uuid.UUID{0x11, 0x31, 0xf6, 0xab, 0x9c, 0x07, 0x11, 0xd1, 0xf7, 0x9f, 0x00, 0xc0, 0x4f, 0xc2, 0xdc, 0xd2}
+	ExtendedRightEnroll, _     = uuid.FromString("{0E10C968-78FB-11D2-90D4-00C04F79DC55}")
+	ExtendedRightAutoEnroll, _ = uuid.FromString("{A05B8CC2-17BC-4802-A710-E7C15AB866A2}")
 
 	AttributeMember                                 = uuid.UUID{0xbf, 0x96, 0x79, 0xc0, 0x0d, 0xe6, 0x11, 0xd0, 0xa2, 0x85, 0x00, 0xaa, 0x00, 0x30, 0x49, 0xe2}
 	AttributeSetGroupMembership                     = uuid.UUID{0xBC, 0x0A, 0xC2, 0x40, 0x79, 0xA9, 0x11, 0xD0, 0x90, 0x20, 0x00, 0xC0, 0x4F, 0xC2, 0xD4, 0xCF}
@@ -82,6 +86,8 @@ var (
 	SelfSID, _         = SIDFromString("S-1-5-10")
 	AttackerSID, _     = SIDFromString("S-1-555-1337")
 
+	EveryoneSID, _                  = SIDFromString("S-1-1-0")
+	AuthenticatedUsersSID, _        = SIDFromString("S-1-5-11")
 	AccountOperatorsSID, _          = SIDFromString("S-1-5-32-548")
 	DAdministratorSID, _            = SIDFromString("S-1-5-21domain-500")
 	DAdministratorsSID, _           = SIDFromString("S-1-5-32-544")
@@ -98,9 +104,10 @@ var (
 
 type PwnMethod uint64
 
+// Every method is a bit, so a set of them fits in one PwnMethod. The first one is bit 0, so there's room for one more
+// method, after that PwnMethod needs a wider type
 const (
-	_ PwnMethod = 1 << iota
-	PwnCreateUser
+	PwnCreateUser PwnMethod = 1 << iota
 	PwnCreateGroup
 	PwnCreateComputer
 	PwnCreateAnyObject
@@ -127,9 +134,7 @@ const (
 	PwnWriteAttributeSecurityGUID
 	PwnSIDHistoryEquality
 	PwnAllExtendedRights
-	PwnDCReplicationGetChanges
-	PwnDCReplicationSyncronize
-	PwnDSReplicationGetChangesAll
+	PwnCanDCSync
 	PwnReadLAPSPassword
 	PwnMemberOfGroup
 	PwnHasSPN
@@ -141,6 +146,31 @@ const (
 	PwnLocalAdminRights
 	PwnLocalRDPRights
 	PwnLocalDCOMRights
+	PwnWriteProfileOrHomeDir
+	PwnHostsProfileOrHomeDir
+	PwnSamePersonHeuristic
+	PwnForeignIdentity
+	PwnIdPAdmin
+	PwnAWSIdentityCenter
+	PwnRunsServicesOn
+	PwnCanDumpCredsOf
+	PwnCanStealCredentialsOf
+	PwnEntraSync
+	PwnEntraConnect
+	PwnEntraPRT
+	PwnEntraRoleAdmin
+	PwnADCSESC1
+	PwnADCSESC4
+	PwnADCSESC8
+	PwnADCSNTAuth
+	PwnSCCMManages
+	PwnUserAffectedByGPO
+	PwnExchangeWriteDACL
+	PwnMailboxFullAccess
+	PwnDomainTrust
+	PwnTrustAbuse
+	PwnCanDelegateTo
+	PwnCoerceToTGT
 
 	PwnAllMethods uint64 = 1<<64 - 1
 )
@@ -170,6 +200,32 @@ func (m PwnMethod) StringSlice() []string {
 	return result
 }
 
+// Parses a comma separated list of method names, case doesn't matter. Blank means all of them
+func ParsePwnMethods(list string) (PwnMethod, error) {
+	if strings.TrimSpace(list) == "" {
+		return PwnMethod(PwnAllMethods), nil
+	}
+	var result PwnMethod
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		var found bool
+		for _, method := range PwnMethodValues() {
+			if strings.EqualFold(method.String(), name) {
+				result |= method
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Unknown method %v", name)
+		}
+	}
+	return result, nil
+}
+
+// Analyzers that are always there, ProcessObjects adds more depending on what is in the data
+var builtinPwnAnalyzers = len(PwnAnalyzers)
+
 var PwnAnalyzers = []PwnAnalyzer{
 	/* It's a Unicorn, dang ...
 	{
@@ -189,60 +245,23 @@ var PwnAnalyzers = []PwnAnalyzer{
 	}, */
 
 	{
-		Method: PwnComputerAffectedByGPO,
+		Method:      PwnComputerAffectedByGPO,
+		Description: "GPO applies to the computer, so whoever can change it can run code on the computer as SYSTEM",
 		ObjectAnalyzer: func(o *Object) []*Object {
-			var results []*Object
-			// Only for computers, you can't really pwn users this way
 			if o.Type() != ObjectTypeComputer {
-				return results
+				return nil
 			}
-			// Find all perent containers with GP links
-			var hasparent bool
-			p := o
-			for {
-				gpoptions := p.OneAttr(GPOptions)
-				if gpoptions == "1" {
-					// inheritance is blocked, so don't move upwards
-					break
-				}
-
-				p, hasparent = AllObjects.Parent(p)
-				if !hasparent {
-					break
-				}
-
-				gplinks := strings.Trim(p.OneAttr(GPLink), " ")
-				if len(gplinks) == 0 {
-					continue
-				}
-				// log.Debug().Msgf("GPlink for %v on container %v: %v", o.DN(), p.DN(), gplinks)
-				if !strings.HasPrefix(gplinks, "[") || !strings.HasSuffix(gplinks, "]") {
-					log.Error().Msgf("Error parsing gplink on %v: %v", o.DN(), gplinks)
-					continue
-				}
-				links := strings.Split(gplinks[1:len(gplinks)-1], "][")
-				for _, link := range links {
-					linkinfo := strings.Split(link, ";")
-					if len(linkinfo) != 2 {
-						log.Error().Msgf("Error parsing gplink on %v: %v", o.DN(), gplinks)
-						continue
-					}
-					linkedgpodn := linkinfo[0][7:] // strip LDAP:// prefix and link to this
-					linktype := linkinfo[1]
-					// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-gpol/08090b22-bc16-49f4-8e10-f27a8fb16d18
-					if linktype == "1" || linktype == "3" {
-						continue // Link is disabled
-					}
-
-					gpo, found := AllObjects.Find(linkedgpodn)
-					if !found {
-						log.Error().Msgf("Object linked to GPO that is not found %v: %v", o.DN(), linkedgpodn)
-					} else {
-						results = append(results, gpo)
-					}
-				}
+			return gpoAffecting(o, PwnComputerAffectedByGPO)
+		},
+	},
+	{
+		Method:      PwnUserAffectedByGPO,
+		Description: "GPO applies to the user, so whoever can change it can run logon scripts and scheduled tasks as the user",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeUser {
+				return nil
 			}
-			return results
+			return gpoAffecting(o, PwnUserAffectedByGPO)
 		},
 	},
 
@@ -341,6 +360,10 @@ var PwnAnalyzers = []PwnAnalyzer{
 			return results
 		},
 	},
+	{
+		Method:         PwnCreateComputer,
+		ObjectAnalyzer: machineAccountCreators,
+	},
 	{
 		Method: PwnCreateAnyObject,
 		ObjectAnalyzer: func(o *Object) []*Object {
@@ -590,44 +613,45 @@ var PwnAnalyzers = []PwnAnalyzer{
 		},
 	},
 	{
-		Method: PwnHasSPN,
+		Method:      PwnHasSPN,
+		Description: "Can get a service ticket for the account and try to crack its password offline (Kerberoasting), which any domain user can do",
 		ObjectAnalyzer: func(o *Object) []*Object {
 			var results []*Object
-			// Only computers and users
-			if o.Type() != ObjectTypeUser {
+			// Only users, as computers and managed service accounts have random passwords
+			if o.Type() != ObjectTypeUser || len(o.Attr(ServicePrincipalName)) == 0 {
 				return results
 			}
-			if len(o.Attr(ServicePrincipalName)) > 0 {
-				o.SetAttr(MetaHasSPN, "1")
-				AuthenticatedUsers, found := AllObjects.Find("CN=Authenticated Users,CN=WellKnown Security Principals,CN=Configuration," + AllObjects.Base)
-				if !found {
-					log.Error().Msgf("Could not locate Authenticated Users")
-					return results
-				}
-				o.PwnableBy.Set(AuthenticatedUsers, PwnHasSPN)
+			o.SetAttr(MetaHasSPN, "1")
+			// The KDC doesn't issue tickets for disabled accounts, and krbtgt's SPN is only for password changes
+			if o.OneAttr(MetaAccountDisabled) == "1" || o.SID().RID() == 502 {
+				return results
 			}
-			return results
+			o.SetAttr(MetaKerberoastable, "1")
+			authenticatedusers := AllObjects.FindOrAddSID(AuthenticatedUsersSID)
+			chance, why := roastChance(o)
+			SetEdgeReason(authenticatedusers, o, PwnHasSPN, "has SPN "+o.Attr(ServicePrincipalName)[0]+", "+why)
+			SetEdgeChance(authenticatedusers, o, PwnHasSPN, chance)
+			return append(results, authenticatedusers)
 		},
 	},
 	{
-		Method: PwnHasSPNNoPreauth,
+		Method:      PwnHasSPNNoPreauth,
+		Description: "Can get data encrypted with the password of the account without authenticating and try to crack it offline (AS-REP roasting), which anyone on the network can do",
 		ObjectAnalyzer: func(o *Object) []*Object {
 			var results []*Object
-			// Only computers and users
 			if o.Type() != ObjectTypeUser {
 				return results
 			}
 			uac, ok := o.AttrInt(UserAccountControl)
-			if !ok {
+			if !ok || uac&UAC_DONT_REQ_PREAUTH == 0 || uac&UAC_ACCOUNTDISABLE != 0 {
 				return results
 			}
-			if uac&0x400000 == 0 {
-				return results
-			}
-			if len(o.Attr(ServicePrincipalName)) > 0 {
-				o.PwnableBy.Set(AttackerObject, PwnHasSPNNoPreauth)
-			}
-			return results
+			o.SetAttr(MetaASREPRoastable, "1")
+			everyone := AllObjects.FindOrAddSID(EveryoneSID)
+			chance, why := roastChance(o)
+			SetEdgeReason(everyone, o, PwnHasSPNNoPreauth, "doesn't require Kerberos preauthentication, "+why)
+			SetEdgeChance(everyone, o, PwnHasSPNNoPreauth, chance)
+			return append(results, everyone)
 		},
 	},
 	{
@@ -756,19 +780,38 @@ var PwnAnalyzers = []PwnAnalyzer{
 		},
 	},
 	{
-		Method: PwnReadMSAPassword,
+		Method:      PwnReadMSAPassword,
+		Description: "Can retrieve the managed password of the group managed service account, and then use it as the account",
 		ObjectAnalyzer: func(o *Object) []*Object {
 			var results []*Object
-			msasds := o.Attr(MSDSGroupMSAMembership)
-			for _, msasd := range msasds {
+			// Who is allowed is in a security descriptor of its own, where denies win over allows
+			for _, msasd := range o.Attr(MSDSGroupMSAMembership) {
 				sd, err := ParseSecurityDescriptor([]byte(msasd))
-				if err == nil {
-					for _, acl := range sd.DACL.Entries {
-						if acl.Type == ACETYPE_ACCESS_ALLOWED {
-							results = append(results, AllObjects.FindOrAddSID(acl.SID))
-						}
+				if err != nil {
+					RecordProblem("analyze", o.DN(), nil, "Problem parsing msDS-GroupMSAMembership: "+err.Error())
+					continue
+				}
+				denied := make(map[SID]struct{})
+				for _, acl := range sd.DACL.Entries {
+					if acl.Type == ACETYPE_ACCESS_DENIED && acl.Mask&(RIGHT_DS_READ_PROPERTY|RIGHT_GENERIC_READ|RIGHT_GENERIC_ALL) != 0 {
+						denied[acl.SID] = struct{}{}
 					}
 				}
+				for _, acl := range sd.DACL.Entries {
+					if acl.Type != ACETYPE_ACCESS_ALLOWED || acl.Mask&(RIGHT_DS_READ_PROPERTY|RIGHT_GENERIC_READ|RIGHT_GENERIC_ALL) == 0 {
+						continue
+					}
+					if _, found := denied[acl.SID]; found {
+						continue
+					}
+					reader := AllObjects.FindOrAddSID(acl.SID)
+					reason := "allowed to retrieve the managed password in msDS-GroupMSAMembership"
+					if o.OneAttr(MetaAccountDisabled) == "1" {
+						reason += ", but the account is disabled"
+					}
+					SetEdgeReason(reader, o, PwnReadMSAPassword, reason)
+					results = append(results, reader)
+				}
 			}
 			return results
 		},
@@ -788,39 +831,37 @@ var PwnAnalyzers = []PwnAnalyzer{
 		},
 	},
 	{
-		Method: PwnWriteKeyCredentialLink,
+		Method:      PwnWriteKeyCredentialLink,
+		Description: "Can add a key to msDS-KeyCredentialLink and log on as the account with it (shadow credentials), if the domain controllers have certificates for PKINIT",
 		ObjectAnalyzer: func(o *Object) []*Object {
 			var results []*Object
-			// Only for groups
+			// Only for accounts
 			if o.Type() != ObjectTypeUser && o.Type() != ObjectTypeComputer {
 				return results
 			}
-			// It's a group
 			sd, err := o.SecurityDescriptor()
 			if err != nil {
 				return results
 			}
 			for _, acl := range sd.DACL.Entries {
 				if acl.AllowObjectClass(o) && acl.AllowMaskedClass(RIGHT_DS_WRITE_PROPERTY, AttributeMSDSKeyCredentialLink) {
-					results = append(results, AllObjects.FindOrAddSID(acl.SID))
+					writer := AllObjects.FindOrAddSID(acl.SID)
+					reason := "can write msDS-KeyCredentialLink"
+					if o.OneAttr(MetaAccountDisabled) == "1" {
+						reason += ", but the account is disabled"
+					}
+					SetEdgeReason(writer, o, PwnWriteKeyCredentialLink, reason)
+					results = append(results, writer)
 				}
 			}
 			return results
 		},
 	},
 	{
-		Method: PwnSIDHistoryEquality,
+		Method:      PwnSIDHistoryEquality,
+		Description: "Has the SID of the target in sIDHistory, so it has everything granted to the target and is in the groups the target is in",
 		ObjectAnalyzer: func(o *Object) []*Object {
-			var results []*Object
-			sids := o.Attr(SIDHistory)
-			for _, stringsid := range sids {
-				sid, err := SIDFromString(stringsid)
-				if err == nil {
-					target := AllObjects.FindOrAddSID(sid)
-					results = append(results, target)
-				}
-			}
-			return results
+			return sidHistoryAccounts(o)
 		},
 	},
 	{
@@ -842,100 +883,444 @@ var PwnAnalyzers = []PwnAnalyzer{
 	},
 	// LAPS password moved to pre-processing, as the attributes have different GUIDs from AD to AD (sigh)
 	{
-		Method: PwnDCReplicationGetChanges,
+		Method:      PwnCanDCSync,
+		Description: "Has both DS-Replication-Get-Changes and DS-Replication-Get-Changes-All on the domain, so it can get the password hashes of everyone by asking for them like a domain controller (DCSync)",
 		ObjectAnalyzer: func(o *Object) []*Object {
-			var results []*Object
-			sd, err := o.SecurityDescriptor()
-			if err != nil {
-				return results
+			if !StringInSlice("domainDNS", o.Attr(ObjectClass)) {
+				return nil
 			}
-			for _, acl := range sd.DACL.Entries {
-				if acl.AllowObjectClass(o) && acl.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChanges) {
-					po := AllObjects.FindOrAddSID(acl.SID)
-					info := dcsyncobjects[po]
-					info.changes = true
-					dcsyncobjects[po] = info
-				}
+			return dcsyncHolders(o)
+		},
+	},
+	{
+		Method:      PwnCanDelegateTo,
+		Description: "Account has constrained delegation to a service on the computer, so whoever controls it can get a ticket to the computer as an admin",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeComputer {
+				return nil
 			}
-			return results
+			return constrainedDelegators(o)
+		},
+	},
+	{
+		Method:      PwnCoerceToTGT,
+		Description: "Account has unconstrained delegation, so whoever controls it can make the tier 0 computer authenticate to it (printer bug, PetitPotam) and take its TGT",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeComputer {
+				return nil
+			}
+			return coercedTGTHolders(o)
 		},
 	},
 	{
-		Method: PwnDCReplicationSyncronize,
+		Method:      PwnExchangeWriteDACL,
+		Description: "Exchange group with WriteDACL on the domain object, which Exchange setup gave it before the 2019 updates, so it and the Exchange servers in it can give anyone DCSync",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if !StringInSlice("domainDNS", o.Attr(ObjectClass)) {
+				return nil
+			}
+			return exchangeDomainDACLWriters(o)
+		},
+	},
+	{
+		Method:      PwnMailboxFullAccess,
+		Description: "Can open the mailbox of the user, with the password reset links, one time codes and everything else mailed to them",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			return mailboxAccessors(o)
+		},
+	},
+	{
+		Method:      PwnHostsProfileOrHomeDir,
+		Description: "Computer hosts the share with the roaming profile or home directory of the user, or a drive or printer mapped for the user by Group Policy Preferences, so admins there can plant files the user will load",
 		ObjectAnalyzer: func(o *Object) []*Object {
 			var results []*Object
-			sd, err := o.SecurityDescriptor()
-			if err != nil {
+			if o.Type() != ObjectTypeUser {
 				return results
 			}
-			for _, acl := range sd.DACL.Entries {
-				if acl.AllowObjectClass(o) && acl.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, DSReplicationSyncronize) {
-					po := AllObjects.FindOrAddSID(acl.SID)
-					info := dcsyncobjects[po]
-					info.sync = true
-					dcsyncobjects[po] = info
+			for _, path := range userSharePaths(o) {
+				server, _, _ := parseUNC(path.Path)
+				if computer, found := AllObjects.FindComputer(server); found {
+					AddEdgeReason(computer, o, PwnHostsProfileOrHomeDir, path.Path+" ("+path.Source+")")
+					results = append(results, computer)
 				}
 			}
 			return results
 		},
 	},
 	{
-		Method: PwnDSReplicationGetChangesAll,
+		Method:      PwnWriteProfileOrHomeDir,
+		Description: "Can write to the share with the roaming profile or home directory of the user, or a drive or printer mapped for the user by Group Policy Preferences (requires collected share permissions)",
 		ObjectAnalyzer: func(o *Object) []*Object {
 			var results []*Object
-			sd, err := o.SecurityDescriptor()
-			if err != nil {
+			if o.Type() != ObjectTypeUser {
 				return results
 			}
-			for _, acl := range sd.DACL.Entries {
-				if acl.AllowObjectClass(o) && acl.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChangesAll) {
-					po := AllObjects.FindOrAddSID(acl.SID)
-					info := dcsyncobjects[po]
-					info.all = true
-					dcsyncobjects[po] = info
+			for _, path := range userSharePaths(o) {
+				server, sharename, _ := parseUNC(path.Path)
+				machine, found := FindLocalMachine(server)
+				if !found {
+					continue
+				}
+				share, found := machine.Share(sharename)
+				if !found {
+					continue
+				}
+				for _, sid := range share.Writers() {
+					writer := AllObjects.FindOrAddSID(sid)
+					AddEdgeReason(writer, o, PwnWriteProfileOrHomeDir, path.Path+" ("+path.Source+")")
+					results = append(results, writer)
 				}
 			}
 			return results
 		},
 	},
+	{
+		Method:      PwnSamePersonHeuristic,
+		Description: "Account in another domain or forest seems to belong to the same person (matching UPN, mail or employee ID), so whoever takes over one might get the other via reused passwords, mailbox access or helpdesk social engineering",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeUser {
+				return nil
+			}
+			return samePersonAccounts(o)
+		},
+	},
+	{
+		Method:      PwnForeignIdentity,
+		Description: "Principal in a trusted domain is represented by this foreign security principal, and the trust lets it authenticate here (direction, selective authentication and SID filtering are taken into account)",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeForeignSecurityPrincipal {
+				return nil
+			}
+			return crossTrustIdentity(o)
+		},
+	},
+	{
+		Method:      PwnDomainTrust,
+		Description: "Domain is trusted by this domain, so its accounts can authenticate here. The direction, transitivity, selective authentication and SID filtering of the trust are on the link",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if !StringInSlice("domainDNS", o.Attr(ObjectClass)) {
+				return nil
+			}
+			return trustedDomains(o)
+		},
+	},
+	{
+		Method:      PwnTrustAbuse,
+		Description: "Domain is trusted by this domain without SID filtering, so whoever controls it can forge tickets with admin SIDs from here in the SID history",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if !StringInSlice("domainDNS", o.Attr(ObjectClass)) {
+				return nil
+			}
+			return trustAbusers(o)
+		},
+	},
+	{
+		Method:      PwnIdPAdmin,
+		Description: "Administrator in an identity provider (Okta, SCIM) can reset the password of the account, also for AD accounts that the provider manages through a directory agent",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeUser {
+				return nil
+			}
+			return idpAdminsOf(o)
+		},
+	},
+	{
+		Method:      PwnAWSIdentityCenter,
+		Description: "Directory group or user is provisioned to AWS IAM Identity Center, is assigned a permission set in an AWS account, or the permission set gives admin rights to the account",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.OneAttr(MetaAWS) == "" {
+				return nil
+			}
+			return awsIdentityCenterSources(o)
+		},
+	},
+	{
+		Method:      PwnLocalAdminRights,
+		Description: "Member of the local Administrators group on the computer, from collected local machine data",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if machine, found := localMachineOf(o); found {
+				return localAdminsOf(machine)
+			}
+			return nil
+		},
+	},
+	{
+		Method:      PwnLocalRDPRights,
+		Description: "Member of the local Remote Desktop Users group on the computer, from collected local machine data",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if machine, found := localMachineOf(o); found {
+				return localGroupMembers(machine.RDPUsers)
+			}
+			return nil
+		},
+	},
+	{
+		Method:      PwnLocalDCOMRights,
+		Description: "Member of the local Distributed COM Users group on the computer, from collected local machine data",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if machine, found := localMachineOf(o); found {
+				return localGroupMembers(machine.DCOMUsers)
+			}
+			return nil
+		},
+	},
+	{
+		Method:      PwnRunsServicesOn,
+		Description: "Account is the logon account for a service on the computer, from collected local machine data",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeComputer {
+				return nil
+			}
+			return serviceAccountsOn(o)
+		},
+	},
+	{
+		Method:      PwnCanDumpCredsOf,
+		Description: "Computer (or a local administrator on it) runs a service as this account, so the password can be dumped from the LSA secrets",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			switch o.Type() {
+			case ObjectTypeUser, ObjectTypeManagedServiceAccount, ObjectTypeComputer:
+				return serviceCredentialDumpers(o)
+			}
+			return nil
+		},
+	},
+	{
+		Method:      PwnCanStealCredentialsOf,
+		Description: "Computer (or a local administrator on it) has a session from this account, so credentials can be stolen from LSASS",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			switch o.Type() {
+			case ObjectTypeUser, ObjectTypeManagedServiceAccount, ObjectTypeComputer:
+				return sessionCredentialStealers(o)
+			}
+			return nil
+		},
+	},
+	{
+		Method:      PwnEntraSync,
+		Description: "AD user, group or computer is synced to this Entra ID user, group or hybrid joined device, so control on-prem carries over to the cloud",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.OneAttr(MetaEntra) == "" {
+				return nil
+			}
+			return entraSyncedFrom(o)
+		},
+	},
+	{
+		Method:      PwnEntraConnect,
+		Description: "Entra Connect server stores the credentials of the sync account (MSOL_ in AD, Sync_ in Entra ID), which can be extracted by an admin on the server",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeUser {
+				return nil
+			}
+			return entraConnectServers(o)
+		},
+	},
+	{
+		Method:      PwnEntraPRT,
+		Description: "Entra ID device has the primary refresh token of the user, as the synced AD account is logged on to the hybrid joined computer or the user registered the device",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeUser || o.OneAttr(MetaEntra) == "" {
+				return nil
+			}
+			return entraPRTStealers(o)
+		},
+	},
+	{
+		Method:      PwnEntraRoleAdmin,
+		Description: "Entra ID directory role can reset the password and authentication methods of the user (Global and Privileged Authentication Administrators for everyone, User, Helpdesk, Authentication and Password Administrators for users without a role)",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeUser || o.OneAttr(MetaEntra) == "" {
+				return nil
+			}
+			return entraResetAdmins(o)
+		},
+	},
+	{
+		Method:      PwnADCSESC1,
+		Description: "Can enroll in a certificate template that gives a certificate for logging on as anyone in the domain: the enrollee supplies the subject (ESC1), or it's an any purpose (ESC2) or enrollment agent (ESC3) certificate that can request on behalf of others",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if !strings.EqualFold(o.DN(), AllObjects.Base) {
+				return nil
+			}
+			return adcsImpersonators(o)
+		},
+	},
+	{
+		Method:      PwnADCSESC4,
+		Description: "Controls a published certificate template (ESC4) or an enterprise CA object (ESC5), so certificates for logging on as anyone in the domain can be had",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if !strings.EqualFold(o.DN(), AllObjects.Base) {
+				return nil
+			}
+			return adcsPKIControllers(o)
+		},
+	},
+	{
+		Method:      PwnADCSESC8,
+		Description: "A CA has HTTP enrollment and issues authentication certificates to domain controllers, so a coerced domain controller authentication can be relayed there for a certificate as the domain controller (ESC8)",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if !strings.EqualFold(o.DN(), AllObjects.Base) {
+				return nil
+			}
+			return adcsRelayers(o)
+		},
+	},
+	{
+		Method:      PwnADCSNTAuth,
+		Description: "Can write NTAuthCertificates, so a CA of their own can be added to the ones domain controllers trust for logon, and certificates from it used to log on as anyone in the domain",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if !strings.EqualFold(o.DN(), AllObjects.Base) {
+				return nil
+			}
+			return adcsNTAuthWriters(o)
+		},
+	},
+	{
+		Method:      PwnSCCMManages,
+		Description: "Configuration Manager site server can run code as SYSTEM on the clients of its site, and its computer account is local admin on the site systems",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			if o.Type() != ObjectTypeComputer {
+				return nil
+			}
+			return sccmSiteServers(o)
+		},
+	},
+	{
+		Method:      PwnCanDumpCredsOf,
+		Description: "Configuration Manager site server stores the password of the client push installation account",
+		ObjectAnalyzer: func(o *Object) []*Object {
+			switch o.Type() {
+			case ObjectTypeUser, ObjectTypeManagedServiceAccount:
+				return sccmPushAccountDumpers(o)
+			}
+			return nil
+		},
+	},
+}
+
+// Groups the fourth hex digit of dwAdminSDExMask in dsHeuristics (the 16th character) can exclude from SDProp
+var adminSDExMaskGroups = []struct {
+	bit int64
+	sid SID
+}{
+	{1, AccountOperatorsSID},
+	{2, ServerOperatorsSID},
+	{4, PrintOperatorsSID},
+	{8, BackupOperatorsSID},
 }
 
+// SDProp copies the ACL of AdminSDHolder to every protected object (adminCount=1) in the domain every hour, so
+// whoever can change AdminSDHolder controls all of them. Groups excluded with dsHeuristics don't get it
 func MakeAdminSDHolderPwnanalyzerFunc(adminsdholder *Object, excluded string) PwnAnalyzer {
+	domain := domainOf(adminsdholder)
+	var mask int64
+	if len(excluded) >= 16 {
+		mask, _ = strconv.ParseInt(excluded[15:16], 16, 8)
+	}
 	return PwnAnalyzer{
 		Method: PwnAdminSDHolderOverwriteACL,
 		ObjectAnalyzer: func(o *Object) []*Object {
-			var results []*Object
-			return results // FIXME
-
-			// Check if object is a user account
-			// if o.Type() == ObjectTypeGroup {
-			// Let's see if this is a protected group
-			// if o.SID() == AccountOperators
-			// }
-
-			if o.Type() != ObjectTypeUser {
-				return results
+			if o == adminsdholder {
+				return nil
 			}
-			// Check if object is member of one of the protected groups
-			// mo := o.Attr(MemberOf)
-
-			//if ac, ok := o.AttrInt(AdminCount); ok && ac > 0 {
-			// This object has an AdminCount with a value more than zero, so it kinda can be pwned by the AdminSDHolder container
-			results = append(results, adminsdholder)
-			//
-			return results
+			if ac, ok := o.AttrInt(AdminCount); !ok || ac == 0 {
+				return nil
+			}
+			if domainOf(o) != domain {
+				return nil
+			}
+			for _, group := range adminSDExMaskGroups {
+				if mask&group.bit != 0 && o.SID() == group.sid {
+					return nil
+				}
+			}
+			SetEdgeReason(adminsdholder, o, PwnAdminSDHolderOverwriteACL, "adminCount is set, so SDProp replaces the ACL with the one on AdminSDHolder every hour")
+			return []*Object{adminsdholder}
 		},
 	}
 }
 
-// Objects that can DC sync
-type syncinfo struct {
-	changes bool
-	sync    bool
-	all     bool
-}
+// Returns the principals that have both replication rights on the domain, so they can DCSync. The rights can come
+// from different groups, so a member of a group with one and of another group with the other has both
+func dcsyncHolders(domain *Object) []*Object {
+	sd, err := domain.SecurityDescriptor()
+	if err != nil {
+		return nil
+	}
+	rights := make(map[SID]int)
+	for _, acl := range sd.DACL.Entries {
+		if !acl.AllowObjectClass(domain) {
+			continue
+		}
+		if acl.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChanges) {
+			rights[acl.SID] |= 1
+		}
+		if acl.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, DSReplicationGetChangesAll) {
+			rights[acl.SID] |= 2
+		}
+	}
+	var results []*Object
+	partial := make(map[*Object]struct{})
+	for sid, granted := range rights {
+		holder := AllObjects.FindOrAddSID(sid)
+		if granted == 3 {
+			SetEdgeReason(holder, domain, PwnCanDCSync, "Has DS-Replication-Get-Changes and DS-Replication-Get-Changes-All on "+domain.OneAttr(Name))
+			results = append(results, holder)
+			continue
+		}
+		partial[holder] = struct{}{}
+		for _, member := range holder.Members(true) {
+			partial[member] = struct{}{}
+		}
+	}
 
-var dcsyncobjects = make(map[*Object]syncinfo)
+	// Those with one right themselves or through a group, that get the other one elsewhere. Where one group gives both
+	// the members get there through the group
+	names := [3]string{1: "DS-Replication-Get-Changes", 2: "DS-Replication-Get-Changes-All"}
+	combinedholders := make(map[*Object]string)
+	for principal := range partial {
+		if rights[principal.SID()] == 3 {
+			continue
+		}
+		var combined int
+		var from [3][]string
+		if granted := rights[principal.SID()]; granted != 0 {
+			combined |= granted
+			from[granted] = append(from[granted], "itself")
+		}
+		var viagroup bool
+		for _, group := range principal.MemberOfRecursive() {
+			granted := rights[group.SID()]
+			if granted == 3 {
+				viagroup = true
+				break
+			}
+			if granted != 0 {
+				combined |= granted
+				from[granted] = append(from[granted], group.Label())
+			}
+		}
+		if viagroup || combined != 3 {
+			continue
+		}
+		combinedholders[principal] = "Has " + names[1] + " through " + strings.Join(from[1], ", ") + " and " + names[2] + " through " + strings.Join(from[2], ", ") + " on " + domain.OneAttr(Name)
+	}
+	for principal, reason := range combinedholders {
+		var viagroup bool
+		for _, group := range principal.MemberOfRecursive() {
+			if _, found := combinedholders[group]; found {
+				viagroup = true
+				break
+			}
+		}
+		if !viagroup {
+			SetEdgeReason(principal, domain, PwnCanDCSync, reason)
+			results = append(results, principal)
+		}
+	}
+	return results
+}
 
 type PwnGraph struct {
 	Targets     []*Object       // The ones we want to pwn
@@ -985,14 +1370,21 @@ func AnalyzeObjects(includeobjects, excludeobjects *Objects, methods PwnMethod,
 
 			var pwnlist []PwnInfo
 			if forward {
-				pwnlist = object.PwnableBy
+				pwnlist = object.PwnableBySnapshot()
 			} else {
-				pwnlist = object.CanPwn
+				pwnlist = object.CanPwnSnapshot()
 			}
 
 			for _, pwninfo := range pwnlist {
 				// If this is not a chosen method, skip it
 				detectedmethods := pwninfo.Method & methods
+
+				// Drop methods based on data that's too old to trust, like sessions
+				if forward {
+					detectedmethods = withoutStaleMethods(pwninfo.Target, object, detectedmethods)
+				} else {
+					detectedmethods = withoutStaleMethods(object, pwninfo.Target, detectedmethods)
+				}
 				if detectedmethods == 0 || detectedmethods == PwnACLContainsDeny {
 					// Nothing useful or just a deny ACL, skip it
 					continue
@@ -8,50 +8,73 @@ import (
 	"fmt"
 )
 
-const _PwnMethodName = "CreateUserCreateGroupCreateComputerCreateAnyObjectDeleteChildrenTargetDeleteObjectInheritsSecurityACLContainsDenyResetPasswordOwnsGenericAllWriteAllWritePropertyAllTakeOwnershipWriteDACLWriteSPNWriteValidatedSPNWriteAllowedToActAddMemberAddMemberGroupAttrAddSelfMemberReadMSAPasswordHasMSAWriteKeyCredentialLinkWriteAttributeSecurityGUIDSIDHistoryEqualityAllExtendedRightsDCReplicationGetChangesDCReplicationSyncronizeDSReplicationGetChangesAllReadLAPSPasswordMemberOfGroupHasSPNHasSPNNoPreauthAdminSDHolderOverwriteACLComputerAffectedByGPOGPOMachineConfigPartOfGPOGPOUserConfigPartOfGPOLocalAdminRightsLocalRDPRightsLocalDCOMRights"
+const _PwnMethodName = "CreateUserCreateGroupCreateComputerCreateAnyObjectDeleteChildrenTargetDeleteObjectInheritsSecurityACLContainsDenyResetPasswordOwnsGenericAllWriteAllWritePropertyAllTakeOwnershipWriteDACLWriteSPNWriteValidatedSPNWriteAllowedToActAddMemberAddMemberGroupAttrAddSelfMemberReadMSAPasswordHasMSAWriteKeyCredentialLinkWriteAttributeSecurityGUIDSIDHistoryEqualityAllExtendedRightsCanDCSyncReadLAPSPasswordMemberOfGroupHasSPNHasSPNNoPreauthAdminSDHolderOverwriteACLComputerAffectedByGPOGPOMachineConfigPartOfGPOGPOUserConfigPartOfGPOLocalAdminRightsLocalRDPRightsLocalDCOMRightsWriteProfileOrHomeDirHostsProfileOrHomeDirSamePersonHeuristicForeignIdentityIdPAdminAWSIdentityCenterRunsServicesOnCanDumpCredsOfCanStealCredentialsOfEntraSyncEntraConnectEntraPRTEntraRoleAdminADCSESC1ADCSESC4ADCSESC8ADCSNTAuthSCCMManagesUserAffectedByGPOExchangeWriteDACLMailboxFullAccessDomainTrustTrustAbuseCanDelegateToCoerceToTGT"
 
 var _PwnMethodMap = map[PwnMethod]string{
-	2:             _PwnMethodName[0:10],
-	4:             _PwnMethodName[10:21],
-	8:             _PwnMethodName[21:35],
-	16:            _PwnMethodName[35:50],
-	32:            _PwnMethodName[50:70],
-	64:            _PwnMethodName[70:82],
-	128:           _PwnMethodName[82:98],
-	256:           _PwnMethodName[98:113],
-	512:           _PwnMethodName[113:126],
-	1024:          _PwnMethodName[126:130],
-	2048:          _PwnMethodName[130:140],
-	4096:          _PwnMethodName[140:148],
-	8192:          _PwnMethodName[148:164],
-	16384:         _PwnMethodName[164:177],
-	32768:         _PwnMethodName[177:186],
-	65536:         _PwnMethodName[186:194],
-	131072:        _PwnMethodName[194:211],
-	262144:        _PwnMethodName[211:228],
-	524288:        _PwnMethodName[228:237],
-	1048576:       _PwnMethodName[237:255],
-	2097152:       _PwnMethodName[255:268],
-	4194304:       _PwnMethodName[268:283],
-	8388608:       _PwnMethodName[283:289],
-	16777216:      _PwnMethodName[289:311],
-	33554432:      _PwnMethodName[311:337],
-	67108864:      _PwnMethodName[337:355],
-	134217728:     _PwnMethodName[355:372],
-	268435456:     _PwnMethodName[372:395],
-	536870912:     _PwnMethodName[395:418],
-	1073741824:    _PwnMethodName[418:444],
-	2147483648:    _PwnMethodName[444:460],
-	4294967296:    _PwnMethodName[460:473],
-	8589934592:    _PwnMethodName[473:479],
-	17179869184:   _PwnMethodName[479:494],
-	34359738368:   _PwnMethodName[494:519],
-	68719476736:   _PwnMethodName[519:540],
-	137438953472:  _PwnMethodName[540:565],
-	274877906944:  _PwnMethodName[565:587],
-	549755813888:  _PwnMethodName[587:603],
-	1099511627776: _PwnMethodName[603:617],
-	2199023255552: _PwnMethodName[617:632],
+	1:                   _PwnMethodName[0:10],
+	2:                   _PwnMethodName[10:21],
+	4:                   _PwnMethodName[21:35],
+	8:                   _PwnMethodName[35:50],
+	16:                  _PwnMethodName[50:70],
+	32:                  _PwnMethodName[70:82],
+	64:                  _PwnMethodName[82:98],
+	128:                 _PwnMethodName[98:113],
+	256:                 _PwnMethodName[113:126],
+	512:                 _PwnMethodName[126:130],
+	1024:                _PwnMethodName[130:140],
+	2048:                _PwnMethodName[140:148],
+	4096:                _PwnMethodName[148:164],
+	8192:                _PwnMethodName[164:177],
+	16384:               _PwnMethodName[177:186],
+	32768:               _PwnMethodName[186:194],
+	65536:               _PwnMethodName[194:211],
+	131072:              _PwnMethodName[211:228],
+	262144:              _PwnMethodName[228:237],
+	524288:              _PwnMethodName[237:255],
+	1048576:             _PwnMethodName[255:268],
+	2097152:             _PwnMethodName[268:283],
+	4194304:             _PwnMethodName[283:289],
+	8388608:             _PwnMethodName[289:311],
+	16777216:            _PwnMethodName[311:337],
+	33554432:            _PwnMethodName[337:355],
+	67108864:            _PwnMethodName[355:372],
+	134217728:           _PwnMethodName[372:381],
+	268435456:           _PwnMethodName[381:397],
+	536870912:           _PwnMethodName[397:410],
+	1073741824:          _PwnMethodName[410:416],
+	2147483648:          _PwnMethodName[416:431],
+	4294967296:          _PwnMethodName[431:456],
+	8589934592:          _PwnMethodName[456:477],
+	17179869184:         _PwnMethodName[477:502],
+	34359738368:         _PwnMethodName[502:524],
+	68719476736:         _PwnMethodName[524:540],
+	137438953472:        _PwnMethodName[540:554],
+	274877906944:        _PwnMethodName[554:569],
+	549755813888:        _PwnMethodName[569:590],
+	1099511627776:       _PwnMethodName[590:611],
+	2199023255552:       _PwnMethodName[611:630],
+	4398046511104:       _PwnMethodName[630:645],
+	8796093022208:       _PwnMethodName[645:653],
+	17592186044416:      _PwnMethodName[653:670],
+	35184372088832:      _PwnMethodName[670:684],
+	70368744177664:      _PwnMethodName[684:698],
+	140737488355328:     _PwnMethodName[698:719],
+	281474976710656:     _PwnMethodName[719:728],
+	562949953421312:     _PwnMethodName[728:740],
+	1125899906842624:    _PwnMethodName[740:748],
+	2251799813685248:    _PwnMethodName[748:762],
+	4503599627370496:    _PwnMethodName[762:770],
+	9007199254740992:    _PwnMethodName[770:778],
+	18014398509481984:   _PwnMethodName[778:786],
+	36028797018963968:   _PwnMethodName[786:796],
+	72057594037927936:   _PwnMethodName[796:807],
+	144115188075855872:  _PwnMethodName[807:824],
+	288230376151711744:  _PwnMethodName[824:841],
+	576460752303423488:  _PwnMethodName[841:858],
+	1152921504606846976: _PwnMethodName[858:869],
+	2305843009213693952: _PwnMethodName[869:879],
+	4611686018427387904: _PwnMethodName[879:892],
+	9223372036854775808: _PwnMethodName[892:903],
 }
 
 func (i PwnMethod) String() string {
@@ -61,50 +84,73 @@ func (i PwnMethod) String() string {
 	return fmt.Sprintf("PwnMethod(%d)", i)
 }
 
-var _PwnMethodValues = []PwnMethod{2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536, 131072, 262144, 524288, 1048576, 2097152, 4194304, 8388608, 16777216, 33554432, 67108864, 134217728, 268435456, 536870912, 1073741824, 2147483648, 4294967296, 8589934592, 17179869184, 34359738368, 68719476736, 137438953472, 274877906944, 549755813888, 1099511627776, 2199023255552}
+var _PwnMethodValues = []PwnMethod{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536, 131072, 262144, 524288, 1048576, 2097152, 4194304, 8388608, 16777216, 33554432, 67108864, 134217728, 268435456, 536870912, 1073741824, 2147483648, 4294967296, 8589934592, 17179869184, 34359738368, 68719476736, 137438953472, 274877906944, 549755813888, 1099511627776, 2199023255552, 4398046511104, 8796093022208, 17592186044416, 35184372088832, 70368744177664, 140737488355328, 281474976710656, 562949953421312, 1125899906842624, 2251799813685248, 4503599627370496, 9007199254740992, 18014398509481984, 36028797018963968, 72057594037927936, 144115188075855872, 288230376151711744, 576460752303423488, 1152921504606846976, 2305843009213693952, 4611686018427387904, 9223372036854775808}
 
 var _PwnMethodNameToValueMap = map[string]PwnMethod{
-	_PwnMethodName[0:10]:    2,
-	_PwnMethodName[10:21]:   4,
-	_PwnMethodName[21:35]:   8,
-	_PwnMethodName[35:50]:   16,
-	_PwnMethodName[50:70]:   32,
-	_PwnMethodName[70:82]:   64,
-	_PwnMethodName[82:98]:   128,
-	_PwnMethodName[98:113]:  256,
-	_PwnMethodName[113:126]: 512,
-	_PwnMethodName[126:130]: 1024,
-	_PwnMethodName[130:140]: 2048,
-	_PwnMethodName[140:148]: 4096,
-	_PwnMethodName[148:164]: 8192,
-	_PwnMethodName[164:177]: 16384,
-	_PwnMethodName[177:186]: 32768,
-	_PwnMethodName[186:194]: 65536,
-	_PwnMethodName[194:211]: 131072,
-	_PwnMethodName[211:228]: 262144,
-	_PwnMethodName[228:237]: 524288,
-	_PwnMethodName[237:255]: 1048576,
-	_PwnMethodName[255:268]: 2097152,
-	_PwnMethodName[268:283]: 4194304,
-	_PwnMethodName[283:289]: 8388608,
-	_PwnMethodName[289:311]: 16777216,
-	_PwnMethodName[311:337]: 33554432,
-	_PwnMethodName[337:355]: 67108864,
-	_PwnMethodName[355:372]: 134217728,
-	_PwnMethodName[372:395]: 268435456,
-	_PwnMethodName[395:418]: 536870912,
-	_PwnMethodName[418:444]: 1073741824,
-	_PwnMethodName[444:460]: 2147483648,
-	_PwnMethodName[460:473]: 4294967296,
-	_PwnMethodName[473:479]: 8589934592,
-	_PwnMethodName[479:494]: 17179869184,
-	_PwnMethodName[494:519]: 34359738368,
-	_PwnMethodName[519:540]: 68719476736,
-	_PwnMethodName[540:565]: 137438953472,
-	_PwnMethodName[565:587]: 274877906944,
-	_PwnMethodName[587:603]: 549755813888,
-	_PwnMethodName[603:617]: 1099511627776,
-	_PwnMethodName[617:632]: 2199023255552,
+	_PwnMethodName[0:10]:    1,
+	_PwnMethodName[10:21]:   2,
+	_PwnMethodName[21:35]:   4,
+	_PwnMethodName[35:50]:   8,
+	_PwnMethodName[50:70]:   16,
+	_PwnMethodName[70:82]:   32,
+	_PwnMethodName[82:98]:   64,
+	_PwnMethodName[98:113]:  128,
+	_PwnMethodName[113:126]: 256,
+	_PwnMethodName[126:130]: 512,
+	_PwnMethodName[130:140]: 1024,
+	_PwnMethodName[140:148]: 2048,
+	_PwnMethodName[148:164]: 4096,
+	_PwnMethodName[164:177]: 8192,
+	_PwnMethodName[177:186]: 16384,
+	_PwnMethodName[186:194]: 32768,
+	_PwnMethodName[194:211]: 65536,
+	_PwnMethodName[211:228]: 131072,
+	_PwnMethodName[228:237]: 262144,
+	_PwnMethodName[237:255]: 524288,
+	_PwnMethodName[255:268]: 1048576,
+	_PwnMethodName[268:283]: 2097152,
+	_PwnMethodName[283:289]: 4194304,
+	_PwnMethodName[289:311]: 8388608,
+	_PwnMethodName[311:337]: 16777216,
+	_PwnMethodName[337:355]: 33554432,
+	_PwnMethodName[355:372]: 67108864,
+	_PwnMethodName[372:381]: 134217728,
+	_PwnMethodName[381:397]: 268435456,
+	_PwnMethodName[397:410]: 536870912,
+	_PwnMethodName[410:416]: 1073741824,
+	_PwnMethodName[416:431]: 2147483648,
+	_PwnMethodName[431:456]: 4294967296,
+	_PwnMethodName[456:477]: 8589934592,
+	_PwnMethodName[477:502]: 17179869184,
+	_PwnMethodName[502:524]: 34359738368,
+	_PwnMethodName[524:540]: 68719476736,
+	_PwnMethodName[540:554]: 137438953472,
+	_PwnMethodName[554:569]: 274877906944,
+	_PwnMethodName[569:590]: 549755813888,
+	_PwnMethodName[590:611]: 1099511627776,
+	_PwnMethodName[611:630]: 2199023255552,
+	_PwnMethodName[630:645]: 4398046511104,
+	_PwnMethodName[645:653]: 8796093022208,
+	_PwnMethodName[653:670]: 17592186044416,
+	_PwnMethodName[670:684]: 35184372088832,
+	_PwnMethodName[684:698]: 70368744177664,
+	_PwnMethodName[698:719]: 140737488355328,
+	_PwnMethodName[719:728]: 281474976710656,
+	_PwnMethodName[728:740]: 562949953421312,
+	_PwnMethodName[740:748]: 1125899906842624,
+	_PwnMethodName[748:762]: 2251799813685248,
+	_PwnMethodName[762:770]: 4503599627370496,
+	_PwnMethodName[770:778]: 9007199254740992,
+	_PwnMethodName[778:786]: 18014398509481984,
+	_PwnMethodName[786:796]: 36028797018963968,
+	_PwnMethodName[796:807]: 72057594037927936,
+	_PwnMethodName[807:824]: 144115188075855872,
+	_PwnMethodName[824:841]: 288230376151711744,
+	_PwnMethodName[841:858]: 576460752303423488,
+	_PwnMethodName[858:869]: 1152921504606846976,
+	_PwnMethodName[869:879]: 2305843009213693952,
+	_PwnMethodName[879:892]: 4611686018427387904,
+	_PwnMethodName[892:903]: 9223372036854775808,
 }
 
 // PwnMethodString retrieves an enum value from the enum constants string name.
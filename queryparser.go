@@ -52,12 +52,59 @@ func (c comparatortype) Compare(a, b int64) bool {
 	return false // I hope not
 }
 
-type LowerStringAttribute Attribute
+type MatchMode byte
 
-func (a LowerStringAttribute) Strings(o *Object) []string {
-	l := o.AttrRendered(Attribute(a))
-	for i, s := range l {
-		l[i] = strings.ToLower(s)
+const (
+	MatchAD          MatchMode = iota // Case insensitive, except for attributes the schema says are case exact
+	MatchInsensitive                  // Case and accent insensitive for all attributes
+	MatchExact                        // Everything is compared byte by byte
+)
+
+// QueryMatchMode controls how string values are compared when evaluating queries
+var QueryMatchMode = MatchAD
+
+// Attribute syntaxes that a DC compares case sensitively
+// https://docs.microsoft.com/en-us/windows/win32/adschema/syntaxes
+var caseExactSyntaxes = []string{
+	"2.5.5.3",  // String(Case)
+	"2.5.5.5",  // String(IA5) and String(Printable)
+	"2.5.5.10", // String(Octet)
+}
+
+// Looks up the attribute in the schema, and returns true if a DC would do a case exact comparison on it
+func attributeIsCaseExact(a Attribute) bool {
+	schemaobject, found := AllObjects.FindClass(a.String())
+	if !found {
+		return false
+	}
+	return StringInSlice(schemaobject.OneAttr(AttributeSyntax), caseExactSyntaxes)
+}
+
+// Returns the function used to fold values before comparison, or nil if values should be compared as is
+func matchFolder(a Attribute, caseexact bool) func(string) string {
+	switch {
+	case caseexact, QueryMatchMode == MatchExact:
+		return nil
+	case QueryMatchMode == MatchInsensitive:
+		return func(s string) string {
+			return strings.ToLower(stripAccents(s))
+		}
+	case attributeIsCaseExact(a):
+		return nil
+	}
+	return strings.ToLower
+}
+
+type foldedStringAttribute struct {
+	a    Attribute
+	fold func(string) string
+}
+
+func (a foldedStringAttribute) Strings(o *Object) []string {
+	values := o.AttrRendered(a.a)
+	l := make([]string, len(values)) // don't fold the values stored on the object
+	for i, s := range values {
+		l[i] = a.fold(s)
 	}
 	return l
 }
@@ -135,7 +182,7 @@ attributeloop:
 		}
 		switch s[0] {
 		case '\\': // Escaping
-			attributename += string(s[1])
+			attributename += s[1:2]
 			s = s[2:] // yum yum
 		case ':':
 			// Modifier
@@ -151,7 +198,7 @@ attributeloop:
 		case '~', '=', '<', '>':
 			break attributeloop
 		default:
-			attributename += string(s[0])
+			attributename += s[0:1]
 			s = s[1:]
 		}
 	}
@@ -194,12 +241,12 @@ valueloop:
 		}
 		switch s[0] {
 		case '\\': // Escaping
-			value += string(s[1])
+			value += s[1:2]
 			s = s[2:] // yum yum
 		case ')':
 			break valueloop
 		default:
-			value += string(s[0])
+			value += s[0:1]
 			s = s[1:]
 		}
 	}
@@ -238,8 +285,18 @@ valueloop:
 			}
 			return s, pwnquery{attributename == "_canpwn", method, target}, nil
 		default:
-			return "", nil, fmt.Errorf("Unknown synthetic attribute %v", attributename)
+			// Meta attributes set on the objects, like the custom ones from -metarules, are queried like any other
+			if A(attributename) == NonExistingAttribute {
+				return "", nil, fmt.Errorf("Unknown synthetic attribute %v", attributename)
+			}
+		}
+	}
+
+	if strings.EqualFold(attributename, "anr") {
+		if comparator != CompareEquals || modifier != "" {
+			return "", nil, errors.New("ANR only supports plain equality comparison")
 		}
+		return s, anrQuery(value), nil
 	}
 
 	attribute := A(attributename)
@@ -298,37 +355,41 @@ valueloop:
 		if value == "*" {
 			return s, hasAttr(attribute), nil
 		}
+
+		var values ObjectStrings = attribute
+		fold := matchFolder(attribute, casesensitive)
+		if fold != nil {
+			values = foldedStringAttribute{attribute, fold}
+		}
+
 		if strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
 			// regexp magic
 			pattern := value[1 : len(value)-1]
-			if !casesensitive {
-				pattern = strings.ToLower(pattern)
+			if fold != nil {
+				// Lowercasing the pattern would mangle escapes like \D, so let the regexp engine handle case
+				pattern = "(?i)" + pattern
+				if QueryMatchMode == MatchInsensitive {
+					pattern = stripAccents(pattern)
+				}
 			}
 			r, err := regexp.Compile(pattern)
 			if err != nil {
 				return "", nil, err
 			}
-			return s, hasRegexpMatch{attribute, r}, nil
+			return s, hasRegexpMatch{values, r}, nil
+		}
+		if fold != nil {
+			value = fold(value)
 		}
 		if strings.ContainsAny(value, "?*") {
 			// glob magic
-			pattern := value
-			if !casesensitive {
-				pattern = strings.ToLower(pattern)
-			}
-			g, err := glob.Compile(pattern)
+			g, err := glob.Compile(value)
 			if err != nil {
 				return "", nil, err
 			}
-			if casesensitive {
-				return s, hasGlobMatch{attribute, g}, nil
-			}
-			return s, hasGlobMatch{LowerStringAttribute(attribute), g}, nil
+			return s, hasGlobMatch{values, g}, nil
 		}
-		if casesensitive {
-			return s, hasStringMatch{attribute, value}, nil
-		}
-		return s, hasInsensitiveStringMatch{attribute, strings.ToLower(value)}, nil
+		return s, hasStringMatch{values, value}, nil
 	}
 
 	// the other comparators require numeric value
@@ -526,21 +587,6 @@ func (a hasStringMatch) Evaluate(o *Object) bool {
 	return false
 }
 
-// Need you to lowercase m when creating it!!
-type hasInsensitiveStringMatch struct {
-	a Attribute
-	m string
-}
-
-func (a hasInsensitiveStringMatch) Evaluate(o *Object) bool {
-	for _, value := range o.AttrRendered(a.a) {
-		if a.m == strings.ToLower(value) {
-			return true
-		}
-	}
-	return false
-}
-
 type hasGlobMatch struct {
 	a ObjectStrings
 	m glob.Glob
@@ -575,23 +621,30 @@ type recursiveDNmatcher struct {
 }
 
 func (a recursiveDNmatcher) Evaluate(o *Object) bool {
-	return recursiveDNmatchFunc(o, a.a, a.dn, 10)
+	return recursiveDNmatchFunc(o, a.a, a.dn)
 }
 
-func recursiveDNmatchFunc(o *Object, a Attribute, dn string, maxdepth int) bool {
+// Follows the DN values in attribute a (like memberOf) transitively, and returns true if dn is found anywhere in the chain
+func recursiveDNmatchFunc(o *Object, a Attribute, dn string) bool {
 	// Just to prevent loops
-	if maxdepth == 0 {
-		return false
-	}
-	// Check all attribute values for match or ancestry
-	for _, value := range o.AttrRendered(a) {
-		// We're at the end
-		if strings.ToLower(value) == strings.ToLower(dn) {
-			return true
-		}
-		// Perhaps parent matches?
-		if parent, found := AllObjects.Find(value); found {
-			return recursiveDNmatchFunc(parent, a, dn, maxdepth-1)
+	visited := map[*Object]struct{}{o: {}}
+	queue := []*Object{o}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		// Check all attribute values for match or ancestry
+		for _, value := range current.AttrRendered(a) {
+			// We're at the end
+			if strings.EqualFold(value, dn) {
+				return true
+			}
+			// Perhaps parent matches?
+			if parent, found := AllObjects.Find(value); found {
+				if _, seen := visited[parent]; !seen {
+					visited[parent] = struct{}{}
+					queue = append(queue, parent)
+				}
+			}
 		}
 	}
 	return false
@@ -604,9 +657,9 @@ type pwnquery struct {
 }
 
 func (p pwnquery) Evaluate(o *Object) bool {
-	items := o.CanPwn
+	items := o.CanPwnSnapshot()
 	if !p.canpwn {
-		items = o.PwnableBy
+		items = o.PwnableBySnapshot()
 	}
 	for _, pwninfo := range items {
 		if p.method == 0 || p.method == pwninfo.Method {
@@ -619,10 +672,69 @@ func (p pwnquery) Evaluate(o *Object) bool {
 type pwnable PwnMethod
 
 func (p pwnable) Evaluate(o *Object) bool {
-	for _, pwninfo := range o.PwnableBy {
+	for _, pwninfo := range o.PwnableBySnapshot() {
 		if pwninfo.Method == PwnMethod(p) {
 			return true
 		}
 	}
 	return false
 }
+
+// The attributes a DC searches when doing Ambiguous Name Resolution
+// https://docs.microsoft.com/en-us/windows/win32/adschema/a-anr
+var anrAttributes = []string{
+	"displayName",
+	"givenName",
+	"legacyExchangeDN",
+	"msDS-AdditionalSamAccountName",
+	"physicalDeliveryOfficeName",
+	"proxyAddresses",
+	"name",
+	"sAMAccountName",
+	"sn",
+	"mailNickname",
+}
+
+// Returns a query that matches value as a prefix, or exactly if exact is set
+func anrMatch(attribute Attribute, value string, exact bool) Query {
+	var values ObjectStrings = attribute
+	if fold := matchFolder(attribute, false); fold != nil {
+		values = foldedStringAttribute{attribute, fold}
+		value = fold(value)
+	}
+	if exact {
+		return hasStringMatch{values, value}
+	}
+	return hasGlobMatch{values, glob.MustCompile(glob.QuoteMeta(value) + "*")}
+}
+
+// Expands (anr=value) the same way a DC does: prefix match on all ANR attributes, exact match if
+// the value starts with =, and first/last name combinations if the value contains a space
+func anrQuery(value string) Query {
+	exact := strings.HasPrefix(value, "=")
+	if exact {
+		value = value[1:]
+	}
+
+	var subitems []Query
+	for _, attributename := range anrAttributes {
+		attribute := A(attributename)
+		if attribute == 0 {
+			continue // not in this dataset
+		}
+		subitems = append(subitems, anrMatch(attribute, value, exact))
+	}
+
+	if space := strings.Index(value, " "); space != -1 && !exact {
+		first, last := value[:space], strings.TrimSpace(value[space+1:])
+		givenname, sn := A("givenName"), A("sn")
+		if givenname != 0 && sn != 0 {
+			subitems = append(subitems,
+				andquery{[]Query{anrMatch(givenname, first, false), anrMatch(sn, last, false)}},
+				andquery{[]Query{anrMatch(givenname, last, false), anrMatch(sn, first, false)}},
+			)
+		}
+	}
+
+	return orquery{subitems}
+}
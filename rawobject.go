@@ -1,9 +1,10 @@
 package main
 
 import (
+	"strings"
+
 	ldap "github.com/lkarlslund/ldap/v3"
 	"github.com/lkarlslund/stringdedup"
-	"github.com/rs/zerolog/log"
 )
 
 //go:generate msgp
@@ -18,15 +19,54 @@ func (r *RawObject) init() {
 	r.Attributes = make(map[string][]string)
 }
 
-func (r *RawObject) ToObject(importall bool) Object {
-	var result Object
-	result.init()
+// Big attributes the analysis doesn't use, left out when loading unless kept with -keepattributes
+var strippedAttributes = map[Attribute]struct{}{
+	NewAttribute("thumbnailPhoto"):             {},
+	NewAttribute("jpegPhoto"):                  {},
+	NewAttribute("photo"):                      {},
+	NewAttribute("thumbnailLogo"):              {},
+	NewAttribute("audio"):                      {},
+	NewAttribute("msExchUMSpokenName"):         {},
+	NewAttribute("userSMIMECertificate"):       {},
+	NewAttribute("msDS-ReplAttributeMetaData"): {},
+	NewAttribute("msDS-ReplValueMetaData"):     {},
+}
+
+// Size of the values left out
+var strippedBytes int
+
+// Loads the comma separated attributes even though they're in the stripped ones, or all of them with *
+func KeepAttributes(keep string) {
+	for _, name := range strings.Split(keep, ",") {
+		name = strings.TrimSpace(name)
+		if name == "*" {
+			strippedAttributes = map[Attribute]struct{}{}
+			return
+		}
+		if name != "" {
+			delete(strippedAttributes, NewAttribute(name))
+		}
+	}
+}
+
+func (r *RawObject) ToObject(importall bool) *Object {
+	result := NewObject()
 	result.DistinguishedName = r.DistinguishedName
 	for name, values := range r.Attributes {
 		if len(values) == 0 || (len(values) == 1 && values[0] == "") {
 			continue
 		}
 		attribute := NewAttribute(name)
+		attributeobjects[attribute]++
+		if strings.HasPrefix(values[0], "redacted:") {
+			attributeredacted[attribute]++
+		}
+		if _, stripped := strippedAttributes[attribute]; stripped {
+			for _, value := range values {
+				strippedBytes += len(value)
+			}
+			continue
+		}
 		for valindex, value := range values {
 			// do we even want this?
 			if !importall && attribute > MAX_IMPORTED {
@@ -38,7 +78,7 @@ func (r *RawObject) ToObject(importall bool) Object {
 
 			if attribute == NTSecurityDescriptor {
 				if err := result.cacheSecurityDescriptor([]byte(value)); err != nil {
-					log.Error().Msgf("Problem parsing security descriptor: %v", err)
+					RecordProblem("convert", r.DistinguishedName, r, "Problem parsing security descriptor: "+err.Error())
 				}
 
 				continue
@@ -55,6 +95,9 @@ func (r *RawObject) ToObject(importall bool) Object {
 				values[valindex] = stringdedup.S(value)
 			}
 		}
+		if result.compressValues(attribute, values) {
+			continue
+		}
 		result.Attributes[attribute] = values
 	}
 	return result
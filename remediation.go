@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Remediation plan for tier 0. Everyone outside tier 0 is a possible attacker, so the smallest set of connections
+// to remove to cut every path into tier 0 is the connections crossing into it. Several of those go away with the same
+// change - an ACE set on an OU is inherited by all the objects below it - so connections are grouped by the change
+// that removes them, and the changes are ordered by how many can use the path and how much of tier 0 it protects
+
+// Methods coming from ACEs in the security descriptor of the target
+var remediationACLMethods = PwnCreateUser | PwnCreateGroup | PwnCreateComputer | PwnCreateAnyObject | PwnDeleteChildrenTarget |
+	PwnDeleteObject | PwnResetPassword | PwnGenericAll | PwnWriteAll | PwnWritePropertyAll | PwnTakeOwnership | PwnWriteDACL |
+	PwnWriteSPN | PwnWriteValidatedSPN | PwnWriteAllowedToAct | PwnAddMember | PwnAddMemberGroupAttr | PwnAddSelfMember |
+	PwnWriteKeyCredentialLink | PwnWriteAttributeSecurityGUID | PwnAllExtendedRights | PwnCanDCSync | PwnReadLAPSPassword |
+	PwnExchangeWriteDACL
+
+// SIDs that everyone or nearly everyone is in
+var remediationBroadSIDs = []string{"S-1-1-0", "S-1-5-7", "S-1-5-11", "S-1-5-32-545"}
+
+type remediationChange struct {
+	where   *Object
+	action  string
+	methods PwnMethod
+	sources map[*Object]struct{}
+	targets map[*Object]struct{}
+}
+
+// Anyone can use the path, so it goes first
+func remediationBroad(o *Object) bool {
+	if o == AttackerObject {
+		return true
+	}
+	sid := o.SID()
+	if sid.IsNull() {
+		return false
+	}
+	if StringInSlice(sid.ToString(), remediationBroadSIDs) {
+		return true
+	}
+	return strings.HasPrefix(sid.ToString(), "S-1-5-21-") && (sid.RID() == 513 || sid.RID() == 515)
+}
+
+// Principals can be taken over themselves, other objects like OUs and GPOs only matter when someone outside tier 0
+// can get to them
+func remediationReachable(o *Object) bool {
+	visited := make(map[*Object]struct{})
+	queue := []*Object{o}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if _, seen := visited[current]; seen {
+			continue
+		}
+		visited[current] = struct{}{}
+		if current.IsTier0() || !detectionUnexpected(current) {
+			continue
+		}
+		if !current.SID().IsNull() || current == AttackerObject {
+			return true
+		}
+		for _, pwninfo := range current.PwnableBySnapshot() {
+			if pwninfo.Method&^PwnACLContainsDeny != 0 {
+				queue = append(queue, pwninfo.Target)
+			}
+		}
+	}
+	return false
+}
+
+// Returns where the ACEs for the SID on the object are set: on the object itself, or the closest parent with an
+// inheritable ACE for it
+func remediationACLSource(o *Object, sid SID) *Object {
+	sd, err := o.SecurityDescriptor()
+	if err != nil {
+		return o
+	}
+	for _, ace := range sd.DACL.Entries {
+		if ace.SID == sid && ace.ACEFlags&ACEFLAG_INHERITED_ACE == 0 {
+			return o
+		}
+	}
+	current := o
+	for {
+		parent, found := AllObjects.Parent(current)
+		if !found {
+			return o
+		}
+		if sd, err := parent.SecurityDescriptor(); err == nil {
+			for _, ace := range sd.DACL.Entries {
+				if ace.SID == sid && ace.ACEFlags&ACEFLAG_INHERITED_ACE == 0 && ace.ACEFlags&ACEFLAG_INHERIT_ACE != 0 {
+					return parent
+				}
+			}
+		}
+		current = parent
+	}
+}
+
+func remediationLabels(set map[*Object]struct{}, max int) string {
+	var labels []string
+	for o := range set {
+		labels = append(labels, o.Label())
+	}
+	sort.Strings(labels)
+	if len(labels) > max {
+		labels = append(labels[:max], fmt.Sprintf("and %v more", len(labels)-max))
+	}
+	return strings.Join(labels, ", ")
+}
+
+func remediationReport() []Finding {
+	changes := make(map[string]*remediationChange)
+	add := func(where *Object, action string, method PwnMethod, source, target *Object) {
+		key := where.DN() + "|" + action
+		change := changes[key]
+		if change == nil {
+			change = &remediationChange{
+				where:   where,
+				action:  action,
+				sources: make(map[*Object]struct{}),
+				targets: make(map[*Object]struct{}),
+			}
+			changes[key] = change
+		}
+		change.methods |= method
+		change.sources[source] = struct{}{}
+		change.targets[target] = struct{}{}
+	}
+
+	for _, target := range AllObjects.AsArray() {
+		if !target.IsTier0() {
+			continue
+		}
+		for _, pwninfo := range target.PwnableBySnapshot() {
+			source := pwninfo.Target
+			if !remediationReachable(source) {
+				continue
+			}
+			for i := 0; i < 64; i++ {
+				method := PwnMethod(1 << i)
+				if pwninfo.Method&method == 0 {
+					continue
+				}
+				switch {
+				case method == PwnOwns:
+					add(target, "Change the owner from "+source.Label()+" to Domain Admins", method, source, target)
+				case method&remediationACLMethods != 0:
+					where := remediationACLSource(target, source.SID())
+					action := "Remove the permissions of " + source.Label()
+					if where != target {
+						action += ", they're inherited by the tier 0 objects below"
+					}
+					add(where, action, method, source, target)
+				case method == PwnReadMSAPassword:
+					add(target, "Remove "+source.Label()+" from the principals allowed to read the managed password (msDS-GroupMSAMembership)", method, source, target)
+				case method == PwnLocalAdminRights:
+					add(target, "Remove "+source.Label()+" from the local Administrators group", method, source, target)
+				case method == PwnCanStealCredentialsOf || method == PwnCanDumpCredsOf:
+					add(target, "Don't log on or run services with this account on computers outside tier 0", method, source, target)
+				case method == PwnComputerAffectedByGPO || method == PwnUserAffectedByGPO || method == PwnGPOMachineConfigPartOfGPO || method == PwnGPOUserConfigPartOfGPO:
+					add(source, "Treat the group policy as tier 0 and only let tier 0 edit it, or unlink it from tier 0 objects", method, source, target)
+				case method == PwnACLContainsDeny:
+					// Not a way in, just a warning that deny ACEs were ignored
+				case method == PwnAdminSDHolderOverwriteACL:
+					add(source, "Only let tier 0 change AdminSDHolder, SDProp copies its ACL to the protected objects", method, source, target)
+				case method == PwnInheritsSecurity:
+					add(source, "Move the tier 0 objects to an OU only tier 0 controls, or only let tier 0 control this one", method, source, target)
+				case method == PwnMemberOfGroup:
+					add(target, "Remove "+source.Label()+" from the group", method, source, target)
+				default:
+					add(target, "Remove the "+method.String()+" connection from "+source.Label(), method, source, target)
+				}
+			}
+		}
+	}
+
+	sorted := make([]*remediationChange, 0, len(changes))
+	for _, change := range changes {
+		sorted = append(sorted, change)
+	}
+	broad := func(change *remediationChange) bool {
+		for source := range change.sources {
+			if remediationBroad(source) {
+				return true
+			}
+		}
+		return false
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if bi, bj := broad(sorted[i]), broad(sorted[j]); bi != bj {
+			return bi
+		}
+		if len(sorted[i].targets) != len(sorted[j].targets) {
+			return len(sorted[i].targets) > len(sorted[j].targets)
+		}
+		return sorted[i].where.DN() < sorted[j].where.DN()
+	})
+
+	findings := make([]Finding, len(sorted))
+	for i, change := range sorted {
+		detail := fmt.Sprintf("%v. %v (%v) - cuts the paths into %v", i+1, change.action, change.methods.JoinedString(), remediationLabels(change.targets, 10))
+		if broad(change) {
+			detail += " - anyone can use this one"
+		} else if len(change.sources) > 1 {
+			detail += " from " + remediationLabels(change.sources, 10)
+		}
+		findings[i] = Finding{change.where.DN(), detail}
+	}
+	return findings
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Finding struct {
+	DN     string `json:"dn"`
+	Detail string `json:"detail"`
+}
+
+type Report struct {
+	Name        string
+	Description string
+	Generate    func() []Finding
+}
+
+var Reports = []Report{
+	{
+		Name:        "PrivilegedLogonRestrictions",
+		Description: "Tier 0 accounts that can log on outside tier 0, because they have no workstation restrictions or are allowed on non tier 0 computers",
+		Generate:    privilegedLogonRestrictionsReport,
+	},
+	{
+		Name:        "GPPCredentials",
+		Description: "Group Policy Preferences containing passwords, which anyone who can read SYSVOL can decrypt",
+		Generate:    gppCredentialsReport,
+	},
+	{
+		Name:        "Passwords",
+		Description: "Accounts with a blank password, an LM hash kept or the same password as other accounts, from dumping NTDS.dit with -ntdssystem",
+		Generate:    passwordsReport,
+	},
+	{
+		Name:        "GPPMappings",
+		Description: "Drives and printers pushed by Group Policy Preferences, where they are linked, and whether the share is writable by everyone",
+		Generate:    gppMappingsReport,
+	},
+	{
+		Name:        "GPOReach",
+		Description: "GPOs by how many computers and users they apply to and how many of those are tier 0, with who outside the admins can change them",
+		Generate:    gpoReachReport,
+	},
+	{
+		Name:        "PersonalData",
+		Description: "Attributes holding personal data that were collected in the dump, and for how many objects - for data handling statements",
+		Generate:    personalDataReport,
+	},
+	{
+		Name:        "EntraPrivilegedWithoutMFA",
+		Description: "Entra ID accounts with privileged roles that no conditional access policy requires MFA for, or that have no MFA method registered",
+		Generate:    entraPrivilegedWithoutMFAReport,
+	},
+	{
+		Name:        "Timeline",
+		Description: "When tier 0 accounts and groups, GPOs linked where tier 0 objects are and trusts were created and last changed, oldest first - for finding the window an attacker was active in",
+		Generate:    timelineReport,
+	},
+	{
+		Name:        "IndicatorsOfCompromise",
+		Description: "Signs of an attacker already in the domain - SID history, new adminCount accounts, AdminSDHolder changes, control of tier 0 given to non admins, DCShadow leftovers and shadow credentials, most severe first",
+		Generate:    irReport,
+	},
+	{
+		Name:        "TicketForging",
+		Description: "Accounts whose keys forge Kerberos tickets - krbtgt (golden tickets), trusts (inter-realm tickets), domain controllers and tier 0 services (silver tickets) - with key ages and encryption types",
+		Generate:    ticketForgingReport,
+	},
+	{
+		Name:        "SCCM",
+		Description: "Configuration Manager sites with their site servers, management points and client push accounts, site servers crossing tiers, and who else controls the System Management container",
+		Generate:    sccmReport,
+	},
+	{
+		Name:        "Remediation",
+		Description: "Changes that remove every connection into tier 0 from outside it, grouped so one change (like an ACE on an OU, or an owner) fixes all the connections it causes, and ordered with the paths anyone can use and those reaching most of tier 0 first",
+		Generate:    remediationReport,
+	},
+	{
+		Name:        "Detections",
+		Description: "Connections that can't be closed right away and how to watch them: accounts with DCSync rights, computers with resource based constrained delegation, and those that can configure it or add shadow credentials without being admins. Export matching Sigma rules with export-detections",
+		Generate:    detectionsReport,
+	},
+	{
+		Name:        "Memory",
+		Description: "Estimated memory used by attributes, security descriptors, indexes and connections, with hints on dump and load options that use less",
+		Generate:    memoryReport,
+	},
+	{
+		Name:        "Problems",
+		Description: "Objects that could not be decoded, converted or analyzed, so the results are incomplete for them - the raw data is at /problems in the web UI",
+		Generate:    problemsReport,
+	},
+}
+
+func FindReport(name string) (Report, bool) {
+	for _, report := range Reports {
+		if strings.EqualFold(report.Name, name) {
+			return report, true
+		}
+	}
+	return Report{}, false
+}
+
+// Findings for objects with the same value of an attribute, like _department from a metadata CSV
+type FindingGroup struct {
+	Value    string    `json:"value"`
+	Findings []Finding `json:"findings"`
+}
+
+// Groups the findings by the value of the attribute on the object they're about, the biggest group first. Findings
+// for objects without it, or that aren't objects, are in a group with no value last
+func GroupFindings(findings []Finding, attribute Attribute) []FindingGroup {
+	groups := make(map[string][]Finding)
+	for _, finding := range findings {
+		var value string
+		if o, found := AllObjects.Find(finding.DN); found {
+			value = o.OneAttr(attribute)
+		}
+		groups[value] = append(groups[value], finding)
+	}
+	result := make([]FindingGroup, 0, len(groups))
+	for value, groupfindings := range groups {
+		result = append(result, FindingGroup{value, groupfindings})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if (result[i].Value == "") != (result[j].Value == "") {
+			return result[j].Value == ""
+		}
+		if len(result[i].Findings) != len(result[j].Findings) {
+			return len(result[i].Findings) > len(result[j].Findings)
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+// When the data was collected, which is about the newest change in it. Ages are from then, not from now
+func dataTimestamp() time.Time {
+	var newest time.Time
+	for _, o := range AllObjects.AsArray() {
+		if changed, ok := o.AttrTimestamp(WhenChanged); ok && changed.After(newest) {
+			newest = changed
+		}
+	}
+	return newest
+}
+
+func privilegedLogonRestrictionsReport() []Finding {
+	var findings []Finding
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != ObjectTypeUser || o.OneAttr(MetaAccountDisabled) == "1" || !o.IsTier0() {
+			continue
+		}
+		if o.OneAttr(MetaWorkstationRestricted) != "1" {
+			if o.OneAttr(MetaLogonHoursRestricted) != "1" {
+				findings = append(findings, Finding{o.DN(), "No logon restrictions, can log on anywhere at any time"})
+			} else {
+				findings = append(findings, Finding{o.DN(), "Logon hours are restricted, but account can log on to any computer"})
+			}
+			continue
+		}
+		for _, workstation := range strings.Split(o.OneAttr(UserWorkstations), ",") {
+			workstation = strings.TrimSpace(workstation)
+			// userWorkstations holds NetBIOS names
+			computer, found := AllObjects.FindComputer(workstation)
+			if !found {
+				findings = append(findings, Finding{o.DN(), "Allowed to log on to " + workstation + ", which is not in the dataset"})
+			} else if !computer.IsTier0() {
+				findings = append(findings, Finding{o.DN(), "Allowed to log on to non tier 0 computer " + computer.DN()})
+			}
+		}
+	}
+	return findings
+}
+
+func gppCredentialsReport() []Finding {
+	var findings []Finding
+	for _, o := range AllObjects.AsArray() {
+		for _, credential := range o.Attr(MetaGPPCredentials) {
+			findings = append(findings, Finding{o.DN(), "Contains password for " + credential})
+		}
+	}
+	return findings
+}
+
+func passwordsReport() []Finding {
+	var findings []Finding
+	shared := make(map[string][]*Object)
+	for _, o := range AllObjects.AsArray() {
+		if o.HasAttrValue(MetaPasswordBlank, "1") {
+			findings = append(findings, Finding{o.DN(), "Password is blank"})
+		}
+		if o.HasAttrValue(MetaLMHash, "1") {
+			findings = append(findings, Finding{o.DN(), "LM hash of the password is kept, which is cracked in minutes"})
+		}
+		if group := o.OneAttr(MetaPasswordShared); group != "" {
+			shared[group] = append(shared[group], o)
+		}
+	}
+	for _, accounts := range shared {
+		for _, o := range accounts {
+			var others []string
+			for _, other := range accounts {
+				if other != o {
+					others = append(others, other.Label())
+				}
+			}
+			detail := "Same password as " + strings.Join(others, ", ")
+			if o.IsTier0() {
+				detail = "Tier 0 account with the same password as " + strings.Join(others, ", ")
+			}
+			findings = append(findings, Finding{o.DN(), detail})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].DN < findings[j].DN
+	})
+	return findings
+}
+
+func gppMappingsReport() []Finding {
+	var findings []Finding
+	for _, o := range AllObjects.AsArray() {
+		var mappings []string
+		mappings = append(mappings, o.Attr(MetaGPPDriveMaps)...)
+		mappings = append(mappings, o.Attr(MetaGPPPrinters)...)
+		if len(mappings) == 0 {
+			continue
+		}
+		var linkedto []string
+		for _, container := range gpoLinkedContainers(o) {
+			linkedto = append(linkedto, container.DN())
+		}
+		where := "not linked anywhere"
+		if len(linkedto) > 0 {
+			where = "linked to " + strings.Join(linkedto, "; ")
+		}
+		for _, mapping := range mappings {
+			detail := "Maps " + mapping + ", " + where
+			path := strings.SplitN(mapping, " (", 2)[0] // strip drive letter
+			if server, sharename, ok := parseUNC(path); ok {
+				if machine, found := FindLocalMachine(server); found {
+					if share, found := machine.Share(sharename); found && share.WritableByEveryone() {
+						detail += " - share is writable by everyone"
+					}
+				}
+			}
+			findings = append(findings, Finding{o.DN(), detail})
+		}
+	}
+	return findings
+}
+
+// Attributes that can identify or describe a person
+var personalDataAttributes = []string{
+	"cn",
+	"displayName",
+	"givenName",
+	"sn",
+	"initials",
+	"name",
+	"sAMAccountName",
+	"userPrincipalName",
+	"description",
+	"info",
+	"comment",
+	"mail",
+	"proxyAddresses",
+	"otherMailbox",
+	"telephoneNumber",
+	"otherTelephone",
+	"homePhone",
+	"mobile",
+	"otherMobile",
+	"pager",
+	"facsimileTelephoneNumber",
+	"ipPhone",
+	"streetAddress",
+	"postalAddress",
+	"postalCode",
+	"postOfficeBox",
+	"l",
+	"st",
+	"c",
+	"co",
+	"title",
+	"department",
+	"company",
+	"manager",
+	"employeeID",
+	"employeeNumber",
+	"employeeType",
+	"physicalDeliveryOfficeName",
+	"homePostalAddress",
+	"thumbnailPhoto",
+	"jpegPhoto",
+	"lastLogon",
+	"lastLogonTimestamp",
+	"logonCount",
+	"homeDirectory",
+	"profilePath",
+}
+
+// The DN column holds the attribute name, as this is a summary of the whole dataset
+func personalDataReport() []Finding {
+	var findings []Finding
+	for _, name := range personalDataAttributes {
+		attribute := A(name)
+		if attribute == NonExistingAttribute || attributeobjects[attribute] == 0 {
+			continue
+		}
+		detail := fmt.Sprintf("Collected for %v objects", attributeobjects[attribute])
+		if attributeredacted[attribute] > 0 {
+			detail += fmt.Sprintf(", redacted for %v of them", attributeredacted[attribute])
+		}
+		findings = append(findings, Finding{name, detail})
+	}
+	return findings
+}
+
+func entraPrivilegedWithoutMFAReport() []Finding {
+	var findings []Finding
+	for _, o := range AllObjects.AsArray() {
+		// Synced on-prem accounts carry the same information, so only report the cloud side
+		if o.OneAttr(MetaEntra) == "" || o.OneAttr(MetaAccountDisabled) == "1" || !entraPrivilegedWithoutMFA(o) {
+			continue
+		}
+		var problems []string
+		if len(o.Attr(MetaMFAEnforcedBy)) == 0 {
+			problems = append(problems, "no conditional access policy requires MFA")
+		}
+		if o.OneAttr(MetaMFARegistered) == "0" {
+			problems = append(problems, "no MFA method registered")
+		}
+		findings = append(findings, Finding{o.DN(), "Has " + strings.Join(o.Attr(MetaEntraRoles), ", ") + " - " + strings.Join(problems, " and ")})
+	}
+	return findings
+}
+
+// Tier 0 objects, the GPOs applying to them and trusts, with a finding for when each was created and one for when it
+// was last changed. whenChanged isn't replicated, so that is when the DC the dump is from saw the last change
+func timelineReport() []Finding {
+	type event struct {
+		when    time.Time
+		finding Finding
+	}
+	var events []event
+	add := func(o *Object, what string) {
+		created, hascreated := o.AttrTimestamp(WhenCreated)
+		if hascreated && !created.IsZero() {
+			events = append(events, event{created, Finding{o.DN(), created.UTC().Format("2006-01-02 15:04:05") + " created " + what}})
+		}
+		changed, haschanged := o.AttrTimestamp(WhenChanged)
+		if haschanged && !changed.IsZero() && (!hascreated || changed.After(created)) {
+			events = append(events, event{changed, Finding{o.DN(), changed.UTC().Format("2006-01-02 15:04:05") + " changed " + what}})
+		}
+	}
+
+	// Containers with tier 0 objects somewhere below them, as GPOs linked there apply to those
+	tier0containers := make(map[string]struct{})
+	for _, o := range AllObjects.AsArray() {
+		switch o.Type() {
+		case ObjectTypeUser, ObjectTypeComputer, ObjectTypeGroup, ObjectTypeManagedServiceAccount:
+			if !o.IsTier0() {
+				continue
+			}
+			add(o, "tier 0 "+strings.ToLower(o.Type().String()))
+			for dn := o.ParentDN(); dn != ""; {
+				tier0containers[strings.ToLower(dn)] = struct{}{}
+				comma := strings.Index(dn, ",")
+				if comma < 0 {
+					break
+				}
+				dn = dn[comma+1:]
+			}
+		case ObjectTypeTrust:
+			add(o, "trust")
+		}
+	}
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != ObjectTypeGroupPolicyContainer {
+			continue
+		}
+		var linkedto []string
+		for _, container := range gpoLinkedContainers(o) {
+			if _, tier0 := tier0containers[strings.ToLower(container.DN())]; tier0 {
+				linkedto = append(linkedto, container.DN())
+			}
+		}
+		if len(linkedto) > 0 {
+			add(o, "GPO "+o.OneAttr(DisplayName)+" linked to "+strings.Join(linkedto, "; "))
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].when.Before(events[j].when)
+	})
+	findings := make([]Finding, len(events))
+	for i, e := range events {
+		findings[i] = e.finding
+	}
+	return findings
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/OneOfOne/xxhash"
+)
+
+// Fingerprint of the loaded data, so cached results are never returned for other data than they were made from
+var DatasetChecksum uint64
+
+// Analysis results for the webservice, so the same query from several people on a shared server only runs once
+var analysisCache = newResultCache(100)
+
+// Computes DatasetChecksum from the objects and how they are connected, after everything has been analyzed
+func UpdateDatasetChecksum() {
+	h := xxhash.New64()
+	counts := make([]byte, 12)
+	for _, o := range AllObjects.AsArray() {
+		h.WriteString(o.DN())
+		o.lock.RLock()
+		binary.LittleEndian.PutUint32(counts[0:], uint32(len(o.Attributes)+len(o.compressed)))
+		binary.LittleEndian.PutUint32(counts[4:], uint32(len(o.CanPwn)))
+		binary.LittleEndian.PutUint32(counts[8:], uint32(len(o.PwnableBy)))
+		o.lock.RUnlock()
+		h.Write(counts)
+	}
+	DatasetChecksum = h.Sum64()
+}
+
+// Least recently used cache of analysis results
+type resultCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List // Most recently used first
+
+	hits, misses int
+}
+
+type resultCacheEntry struct {
+	key   string
+	graph PwnGraph
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Changes how many results are kept, 0 turns the cache off
+func (c *resultCache) SetSize(size int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.size = size
+	c.trim()
+}
+
+func (c *resultCache) trim() {
+	for c.lru.Len() > c.size {
+		delete(c.entries, c.lru.Remove(c.lru.Back()).(*resultCacheEntry).key)
+	}
+}
+
+func (c *resultCache) Get(key string) (PwnGraph, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		c.misses++
+		return PwnGraph{}, false
+	}
+	c.hits++
+	c.lru.MoveToFront(element)
+	return element.Value.(*resultCacheEntry).graph, true
+}
+
+func (c *resultCache) Add(key string, graph PwnGraph) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, found := c.entries[key]; found {
+		element.Value.(*resultCacheEntry).graph = graph
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&resultCacheEntry{key: key, graph: graph})
+	c.trim()
+}
+
+// Returns the number of cached results, and how many lookups found one and how many didn't
+func (c *resultCache) Statistics() (entries, hits, misses int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len(), c.hits, c.misses
+}
+
+// Runs the analysis for the query, or returns the result from the last time the same was asked for on the same data
+func cachedAnalysis(query string, methods PwnMethod, mode string, maxdepth int) (PwnGraph, error) {
+	key := fmt.Sprintf("%016x\x00%v\x00%x\x00%v\x00%v", DatasetChecksum, query, uint64(methods), mode, maxdepth)
+	if pg, found := analysisCache.Get(key); found {
+		return pg.copy(), nil
+	}
+	includeobjects, excludeobjects, err := queryObjects(query)
+	if err != nil {
+		return PwnGraph{}, err
+	}
+	pg := AnalyzeObjects(includeobjects, excludeobjects, methods, mode, maxdepth)
+	analysisCache.Add(key, pg)
+	return pg.copy(), nil
+}
+
+// The graph with its own slices, as the exports sort them
+func (pg PwnGraph) copy() PwnGraph {
+	return PwnGraph{
+		Targets:     append([]*Object(nil), pg.Targets...),
+		Implicated:  append([]*Object(nil), pg.Implicated...),
+		Connections: append([]PwnConnection(nil), pg.Connections...),
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Configuration Manager (SCCM). Sites publish themselves and their management points in CN=System Management,CN=System,
+// which the site servers need full control of. The site server deploys applications and scripts as SYSTEM to every
+// client in the site, has its computer account as local admin on the site systems, and stores the client push
+// installation accounts, which are local admins on the clients - so whoever controls the site server controls the site
+
+type sccmSite struct {
+	Code             string
+	Servers          []*Object
+	ManagementPoints []*Object
+	Clients          []*Object
+	PushAccounts     []*Object
+}
+
+// Site code -> site
+var sccmSites map[string]*sccmSite
+
+// Computer -> site servers that manage it, and why
+var sccmManagers map[*Object]map[*Object]string
+
+// Client push account -> site servers storing its password
+var sccmPushHolders map[*Object][]*Object
+
+func sccmContainer() (*Object, bool) {
+	return AllObjects.Find("CN=System Management,CN=System," + AllObjects.Base)
+}
+
+func sccmSiteFor(code string) *sccmSite {
+	code = strings.ToUpper(code)
+	site := sccmSites[code]
+	if site == nil {
+		site = &sccmSite{Code: code}
+		sccmSites[code] = site
+	}
+	return site
+}
+
+func sccmAdd(list []*Object, o *Object) []*Object {
+	for _, existing := range list {
+		if existing == o {
+			return list
+		}
+	}
+	return append(list, o)
+}
+
+// Computers with full control of the System Management container, which is what a site server needs to publish
+func sccmContainerServers() []*Object {
+	container, found := sccmContainer()
+	if !found {
+		return nil
+	}
+	sd, err := container.SecurityDescriptor()
+	if err != nil {
+		return nil
+	}
+	var results []*Object
+	for _, acl := range sd.DACL.Entries {
+		if !acl.AllowMaskedClass(RIGHT_GENERIC_ALL, NullGUID) {
+			continue
+		}
+		if o, found := AllObjects.FindSID(acl.SID); found && o.Type() == ObjectTypeComputer {
+			results = sccmAdd(results, o)
+		}
+	}
+	return results
+}
+
+// Finds the sites, site servers and management points in the directory and the clients and client push accounts
+// in the local machine data
+func buildSCCM() {
+	sccmSites = make(map[string]*sccmSite)
+	sccmManagers = make(map[*Object]map[*Object]string)
+	sccmPushHolders = make(map[*Object][]*Object)
+
+	if container, found := sccmContainer(); found {
+		suffix := strings.ToLower("," + container.DN())
+		for _, o := range AllObjects.AsArray() {
+			if !strings.HasSuffix(strings.ToLower(o.DN()), suffix) || o.OneAttr(MSSMSSiteCode) == "" {
+				continue
+			}
+			site := sccmSiteFor(o.OneAttr(MSSMSSiteCode))
+			classes := o.Attr(ObjectClass)
+			switch {
+			case StringInSlice("mSSMSManagementPoint", classes):
+				if computer, found := AllObjects.FindComputer(o.OneAttr(MSSMSMPName)); found {
+					site.ManagementPoints = sccmAdd(site.ManagementPoints, computer)
+				}
+			case StringInSlice("mSSMSSite", classes):
+				// The site server creates the object, so owns it with its computer account
+				if sd, err := o.SecurityDescriptor(); err == nil {
+					if owner, found := AllObjects.FindSID(sd.Owner); found && owner.Type() == ObjectTypeComputer {
+						site.Servers = sccmAdd(site.Servers, owner)
+					}
+				}
+			}
+		}
+	}
+
+	for _, machine := range AllMachines {
+		computer, found := AllObjects.FindComputer(machine.Name)
+		if !found {
+			continue
+		}
+		if machine.SCCM == nil || machine.SCCM.SiteCode == "" {
+			// Clients with the agent installed but no site recorded belong to the only site there is
+			if len(sccmSites) != 1 {
+				continue
+			}
+			for _, service := range machine.Services {
+				if strings.EqualFold(service.Name, "CcmExec") {
+					for _, site := range sccmSites {
+						site.Clients = sccmAdd(site.Clients, computer)
+					}
+					break
+				}
+			}
+			continue
+		}
+		site := sccmSiteFor(machine.SCCM.SiteCode)
+		if machine.SCCM.SiteServer {
+			site.Servers = sccmAdd(site.Servers, computer)
+			for _, account := range machine.SCCM.ClientPushAccounts {
+				if o, found := resolveAccount(account); found {
+					site.PushAccounts = sccmAdd(site.PushAccounts, o)
+				}
+			}
+			continue
+		}
+		site.Clients = sccmAdd(site.Clients, computer)
+		if mp, found := AllObjects.FindComputer(machine.SCCM.ManagementPoint); found {
+			site.ManagementPoints = sccmAdd(site.ManagementPoints, mp)
+		}
+	}
+
+	// Site servers not found from the site object or machine data are the computers that can publish
+	if servers := sccmContainerServers(); len(servers) > 0 {
+		for _, site := range sccmSites {
+			if len(site.Servers) == 0 {
+				site.Servers = servers
+			}
+		}
+	}
+
+	for _, site := range sccmSites {
+		for _, server := range site.Servers {
+			for _, client := range site.Clients {
+				sccmAddManager(client, server, "client of site "+site.Code+", the site server deploys applications and scripts to it as SYSTEM")
+			}
+			for _, mp := range site.ManagementPoints {
+				sccmAddManager(mp, server, "management point of site "+site.Code+", the computer account of the site server is local admin on its site systems")
+			}
+			for _, account := range site.PushAccounts {
+				sccmPushHolders[account] = sccmAdd(sccmPushHolders[account], server)
+			}
+		}
+	}
+}
+
+func sccmAddManager(computer, server *Object, reason string) {
+	if computer == server {
+		return
+	}
+	if sccmManagers[computer] == nil {
+		sccmManagers[computer] = make(map[*Object]string)
+	}
+	sccmManagers[computer][server] = reason
+}
+
+// Returns the site servers that can run code on the computer
+func sccmSiteServers(computer *Object) []*Object {
+	var results []*Object
+	for server, reason := range sccmManagers[computer] {
+		SetEdgeReason(server, computer, PwnSCCMManages, reason)
+		results = append(results, server)
+	}
+	return results
+}
+
+// Returns the site servers that store the password of the client push account
+func sccmPushAccountDumpers(account *Object) []*Object {
+	for _, server := range sccmPushHolders[account] {
+		SetEdgeReason(server, account, PwnCanDumpCredsOf, "client push installation account, the password is stored on site server "+server.OneAttr(Name))
+	}
+	return sccmPushHolders[account]
+}
+
+func sccmReport() []Finding {
+	var findings []Finding
+	codes := make([]string, 0, len(sccmSites))
+	for code := range sccmSites {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		site := sccmSites[code]
+		where := "Site " + code
+		if len(site.Servers) > 0 {
+			where = site.Servers[0].DN()
+		}
+		findings = append(findings, Finding{where, fmt.Sprintf("Site %v - site servers %v, management points %v, %v known clients - control of a site server is control of every client",
+			code, detectionLabels(site.Servers), detectionLabels(site.ManagementPoints), len(site.Clients))})
+		for _, account := range site.PushAccounts {
+			detail := "Client push installation account for site " + code + ", local admin on the clients and its password can be had from the site server"
+			if account.IsTier0() {
+				detail += " - it's tier 0, so installing the client on any machine exposes it"
+			}
+			findings = append(findings, Finding{account.DN(), detail})
+		}
+		for _, server := range site.Servers {
+			if !server.IsTier0() {
+				continue
+			}
+			var outside int
+			for _, client := range site.Clients {
+				if !client.IsTier0() {
+					outside++
+				}
+			}
+			if outside > 0 {
+				findings = append(findings, Finding{server.DN(), fmt.Sprintf("Tier 0 site server manages %v clients outside tier 0, which should have a site of their own", outside)})
+			}
+		}
+		var tier0 []*Object
+		for _, client := range site.Clients {
+			if client.IsTier0() {
+				tier0 = append(tier0, client)
+			}
+		}
+		for _, server := range site.Servers {
+			if !server.IsTier0() && len(tier0) > 0 {
+				findings = append(findings, Finding{server.DN(), "Site server outside tier 0 manages tier 0 computers " + detectionLabels(tier0) + ", so it is tier 0 too"})
+			}
+		}
+	}
+
+	if container, found := sccmContainer(); found {
+		servers := sccmContainerServers()
+		for _, controller := range adcsControllers(container) {
+			if !detectionUnexpected(controller) {
+				continue
+			}
+			var isserver bool
+			for _, server := range servers {
+				isserver = isserver || server == controller
+			}
+			if !isserver {
+				findings = append(findings, Finding{container.DN(), "Controlled by " + controller.Label() + ", who can publish a site or management point of their own to clients looking up their site in the directory"})
+			}
+		}
+	}
+	return findings
+}
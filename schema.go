@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// How the values of an attribute are stored, from attributeSyntax in the schema
+// https://docs.microsoft.com/en-us/windows/win32/adschema/syntaxes
+type AttributeSyntaxType byte
+
+const (
+	SyntaxUnknown            AttributeSyntaxType = iota // Not in the schema, or a syntax we don't coerce
+	SyntaxInteger                                       // 2.5.5.9 Integer and 2.5.5.16 LargeInteger (also FILETIME and intervals)
+	SyntaxBoolean                                       // 2.5.5.8
+	SyntaxTime                                          // 2.5.5.11 GeneralizedTime and UTCTime
+	SyntaxSID                                           // 2.5.5.17
+	SyntaxGUID                                          // 2.5.5.10 OctetString of exactly 16 bytes
+	SyntaxSecurityDescriptor                            // 2.5.5.15
+)
+
+var attributeSyntaxes = make(map[Attribute]AttributeSyntaxType)
+
+// Returns the syntax the schema has for the attribute
+func SyntaxOf(a Attribute) AttributeSyntaxType {
+	return attributeSyntaxes[a]
+}
+
+// Reads the syntax of every attribute from the attributeSchema objects in the dump. Returns how many were found
+func LoadSchemaSyntaxes() int {
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != ObjectTypeAttributeSchema {
+			continue
+		}
+		name := o.OneAttr(LDAPDisplayName)
+		if name == "" {
+			continue
+		}
+		var syntax AttributeSyntaxType
+		switch o.OneAttr(AttributeSyntax) {
+		case "2.5.5.9", "2.5.5.16":
+			syntax = SyntaxInteger
+		case "2.5.5.8":
+			syntax = SyntaxBoolean
+		case "2.5.5.11":
+			syntax = SyntaxTime
+		case "2.5.5.17":
+			syntax = SyntaxSID
+		case "2.5.5.10":
+			if o.OneAttr(RangeLower) == "16" && o.OneAttr(RangeUpper) == "16" {
+				syntax = SyntaxGUID
+			}
+		case "2.5.5.15":
+			syntax = SyntaxSecurityDescriptor
+		}
+		if syntax != SyntaxUnknown {
+			attributeSyntaxes[NewAttribute(name)] = syntax
+		}
+	}
+	return len(attributeSyntaxes)
+}
+
+// Returns the value in the canonical form for the syntax, or false if it doesn't fit
+func coerceValue(syntax AttributeSyntaxType, value string) (string, bool) {
+	switch syntax {
+	case SyntaxInteger:
+		trimmed := strings.TrimSpace(value)
+		if i, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+			return strconv.FormatInt(i, 10), true
+		}
+		// Large unsigned values are the same bits as negative ones
+		if u, err := strconv.ParseUint(trimmed, 10, 64); err == nil {
+			return strconv.FormatInt(int64(u), 10), true
+		}
+	case SyntaxBoolean:
+		switch strings.ToUpper(strings.TrimSpace(value)) {
+		case "TRUE", "1":
+			return "TRUE", true
+		case "FALSE", "0":
+			return "FALSE", true
+		}
+	case SyntaxTime:
+		if t, ok := parseDirectoryTime(value); ok {
+			return t.UTC().Format("20060102150405") + ".0Z", true
+		}
+	case SyntaxSID:
+		if strings.HasPrefix(value, "S-1-") {
+			// Textual SIDs from other sources than LDAP
+			if sid, err := SIDFromString(value); err == nil {
+				return string(sid), true
+			}
+		} else if sid, _, err := ParseSID([]byte(value)); err == nil {
+			return string(sid), true
+		}
+	case SyntaxGUID:
+		if len(value) == 16 {
+			return value, true
+		}
+		if u, err := uuid.FromString(value); err == nil {
+			// Textual GUIDs are in the usual order, AD stores the first three parts little endian
+			return string(SwapUUIDEndianess(u).Bytes()), true
+		}
+	case SyntaxSecurityDescriptor:
+		return value, len(value) >= 20 && value[0] == 1
+	default:
+		return value, true
+	}
+	return value, false
+}
+
+// Parses GeneralizedTime ("20171111074031.0Z") and UTCTime ("171111074031Z")
+func parseDirectoryTime(value string) (time.Time, bool) {
+	for _, layout := range []string{"20060102150405.0Z0700", "20060102150405Z0700", "060102150405Z0700"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Puts all values of attributes with a known syntax in the same form, so the analysis doesn't have to deal with
+// variations. Values that don't fit the syntax would only mislead the analysis, so they are dropped and counted in a
+// warning per attribute. If that leaves an attribute without values it's removed, and recorded as a problem
+func CoerceAttributeValues() {
+	mismatches := make(map[Attribute]int)
+	examples := make(map[Attribute]string)
+	for _, o := range AllObjects.AsArray() {
+		dn := o.DN()
+		dropped := make(map[Attribute][]string)
+		o.lock.Lock()
+		for attr, values := range o.Attributes {
+			syntax := attributeSyntaxes[attr]
+			if syntax == SyntaxUnknown || syntax == SyntaxSecurityDescriptor && attr == NTSecurityDescriptor {
+				// The security descriptor is parsed when loading
+				continue
+			}
+			var kept []string
+			for i, value := range values {
+				coerced, ok := value, true
+				if !strings.HasPrefix(value, "redacted:") {
+					coerced, ok = coerceValue(syntax, value)
+				}
+				if !ok {
+					if mismatches[attr] == 0 {
+						examples[attr] = dn
+					}
+					mismatches[attr]++
+					if kept == nil {
+						kept = append(make([]string, 0, len(values)), values[:i]...)
+					}
+					continue
+				}
+				values[i] = coerced
+				if kept != nil {
+					kept = append(kept, coerced)
+				}
+			}
+			if kept != nil {
+				dropped[attr] = kept
+			}
+		}
+		o.lock.Unlock()
+		for attr, kept := range dropped {
+			o.SetValues(attr, kept...)
+			if len(kept) == 0 {
+				RecordProblem("convert", dn, nil, fmt.Sprintf("No values of %v match the syntax in the schema, so it was dropped", attr.String()))
+			}
+		}
+	}
+	for attr, count := range mismatches {
+		log.Warn().Msgf("%v values of %v don't match the syntax in the schema and were dropped, for example on %v", count, attr.String(), examples[attr])
+	}
+}
+
+// Linked attributes are stored as a forward link on one object, and the DC computes the back link on the objects
+// it points to. Dumps don't always have the back links - they weren't asked for, the importer only had the forward
+// ones, or the linked object is in another domain - so they're computed from the forward links when loading.
+// In the schema the forward link has an even linkID, and the back link the one after it
+
+// Forward link -> back link, for dumps without the schema
+var defaultLinkedAttributes = map[string]string{
+	"member":                  "memberOf",
+	"manager":                 "directReports",
+	"managedBy":               "managedObjects",
+	"serverReference":         "serverReferenceBL",
+	"msDS-HostServiceAccount": "msDS-HostServiceAccountBL",
+	"msDS-KrbTgtLink":         "msDS-KrbTgtLinkBl",
+	"msDS-KeyCredentialLink":  "msDS-KeyCredentialLink-BL",
+}
+
+// Returns the pairs of forward and back link attributes, from the schema and the defaults
+func LoadSchemaLinks() map[Attribute]Attribute {
+	links := make(map[Attribute]Attribute)
+	for forward, back := range defaultLinkedAttributes {
+		links[NewAttribute(forward)] = NewAttribute(back)
+	}
+	byid := make(map[int64]string)
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() != ObjectTypeAttributeSchema {
+			continue
+		}
+		if linkid, ok := o.AttrInt(LinkID); ok && o.OneAttr(LDAPDisplayName) != "" {
+			byid[linkid] = o.OneAttr(LDAPDisplayName)
+		}
+	}
+	for linkid, forward := range byid {
+		if linkid%2 != 0 {
+			continue
+		}
+		if back, found := byid[linkid+1]; found {
+			links[NewAttribute(forward)] = NewAttribute(back)
+		}
+	}
+	return links
+}
+
+// Returns the DN in a DN, DN-Binary (B:<length>:<hex>:<dn>) or DN-String (S:<length>:<string>:<dn>) value
+func linkedDN(value string) string {
+	if len(value) > 2 && (value[0] == 'B' || value[0] == 'S') && value[1] == ':' {
+		if parts := strings.SplitN(value, ":", 4); len(parts) == 4 {
+			return parts[3]
+		}
+	}
+	return value
+}
+
+// Adds the back links missing for the forward links in the loaded objects, so analyzers can use either. Returns
+// how many were added
+func ComputeBackLinks() int {
+	links := LoadSchemaLinks()
+	type backlink struct {
+		target    *Object
+		attribute Attribute
+	}
+	missing := make(map[backlink][]string)
+	for _, o := range AllObjects.AsArray() {
+		for forward, back := range links {
+			for _, value := range o.Attr(forward) {
+				target, found := AllObjects.Find(linkedDN(value))
+				if !found {
+					continue
+				}
+				missing[backlink{target, back}] = append(missing[backlink{target, back}], o.DN())
+			}
+		}
+	}
+
+	var added int
+	for link, sources := range missing {
+		values := link.target.Attr(link.attribute)
+		existing := make(map[string]struct{}, len(values))
+		for _, value := range values {
+			existing[strings.ToLower(value)] = struct{}{}
+		}
+		newvalues := append([]string(nil), values...)
+		for _, source := range sources {
+			if _, found := existing[strings.ToLower(source)]; !found {
+				existing[strings.ToLower(source)] = struct{}{}
+				newvalues = append(newvalues, source)
+			}
+		}
+		if len(newvalues) > len(values) {
+			added += len(newvalues) - len(values)
+			link.target.SetValues(link.attribute, newvalues...)
+		}
+	}
+	return added
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestCoerceValue(t *testing.T) {
+	guid := uuid.Must(uuid.FromString("01020304-0506-0708-090a-0b0c0d0e0f10"))
+	binaryguid := string(SwapUUIDEndianess(guid).Bytes())
+	sid, _ := SIDFromString("S-1-5-21-1-2-3-500")
+
+	tests := []struct {
+		name   string
+		syntax AttributeSyntaxType
+		value  string
+		want   string
+		ok     bool
+	}{
+		{"binary GUID", SyntaxGUID, binaryguid, binaryguid, true},
+		{"textual GUID", SyntaxGUID, guid.String(), binaryguid, true},
+		{"braced GUID", SyntaxGUID, "{" + guid.String() + "}", binaryguid, true},
+		{"short GUID", SyntaxGUID, "abc", "", false},
+		{"empty GUID", SyntaxGUID, "", "", false},
+		{"17 byte GUID", SyntaxGUID, binaryguid + "x", "", false},
+
+		{"binary SID", SyntaxSID, string(sid), string(sid), true},
+		{"textual SID", SyntaxSID, "S-1-5-21-1-2-3-500", string(sid), true},
+		{"SID with trailing bytes", SyntaxSID, string(sid) + "\x00\x00", string(sid), true},
+		{"SID revision only", SyntaxSID, "\x01", "", false},
+		{"truncated SID", SyntaxSID, "\x01\x05", "", false},
+		{"SID with too many subauthorities", SyntaxSID, "\x01\x10" + strings.Repeat("\x00", 70), "", false},
+		{"wrong SID revision", SyntaxSID, "\x02\x01\x00\x00\x00\x00\x00\x05\x12\x00\x00\x00", "", false},
+		{"textual SID without subauthorities", SyntaxSID, "S-1", "", false},
+		{"empty SID", SyntaxSID, "", "", false},
+
+		{"GeneralizedTime", SyntaxTime, "20171111074031.0Z", "20171111074031.0Z", true},
+		{"GeneralizedTime without fraction", SyntaxTime, "20171111074031Z", "20171111074031.0Z", true},
+		{"GeneralizedTime with offset", SyntaxTime, "20171111094031.0+0200", "20171111074031.0Z", true},
+		{"UTCTime", SyntaxTime, "171111074031Z", "20171111074031.0Z", true},
+		{"date only", SyntaxTime, "20240101", "", false},
+		{"words", SyntaxTime, "yesterday", "", false},
+
+		{"FILETIME", SyntaxInteger, "131549088310000000", "131549088310000000", true},
+		{"padded integer", SyntaxInteger, " 42 ", "42", true},
+		{"large unsigned", SyntaxInteger, "18446744073709551615", "-1", true},
+		{"hex integer", SyntaxInteger, "0x1d9", "", false},
+		{"boolean", SyntaxBoolean, "true", "TRUE", true},
+		{"boolean as number", SyntaxBoolean, "0", "FALSE", true},
+		{"boolean as word", SyntaxBoolean, "yes", "", false},
+		{"unknown syntax", SyntaxUnknown, "\x01", "\x01", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := coerceValue(test.syntax, test.value)
+			if ok != test.ok {
+				t.Fatalf("coerceValue(%q) ok = %v, want %v", test.value, ok, test.ok)
+			}
+			if ok && got != test.want {
+				t.Errorf("coerceValue(%q) = %q, want %q", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCoerceAttributeValues(t *testing.T) {
+	resetObjects()
+	defer resetObjects()
+
+	guid := uuid.Must(uuid.FromString("01020304-0506-0708-090a-0b0c0d0e0f10"))
+	binaryguid := string(SwapUUIDEndianess(guid).Bytes())
+	goodsid, _ := SIDFromString("S-1-5-21-1-2-3-1104")
+
+	attributeSyntaxes[ObjectGUID] = SyntaxGUID
+	attributeSyntaxes[ObjectSid] = SyntaxSID
+	attributeSyntaxes[SIDHistory] = SyntaxSID
+	attributeSyntaxes[WhenCreated] = SyntaxTime
+	attributeSyntaxes[LastLogonTimestamp] = SyntaxInteger
+
+	tests := []struct {
+		name      string
+		attribute Attribute
+		values    []string
+		want      []string // nil when the attribute should be gone
+		problem   bool
+	}{
+		{"good GUID", ObjectGUID, []string{guid.String()}, []string{binaryguid}, false},
+		{"bad GUID", ObjectGUID, []string{"abc"}, nil, true},
+		{"good SID", ObjectSid, []string{"S-1-5-21-1-2-3-1104"}, []string{string(goodsid)}, false},
+		{"SID revision only", ObjectSid, []string{"\x01"}, nil, true},
+		{"one good and one bad sIDHistory", SIDHistory, []string{"S-1-5-21-1-2-3-1104", "\x01\x05"}, []string{string(goodsid)}, false},
+		{"bad and good sIDHistory", SIDHistory, []string{"\x01\x05", string(goodsid)}, []string{string(goodsid)}, false},
+		{"all bad sIDHistory", SIDHistory, []string{"\x01\x05", "S-1"}, nil, true},
+		{"good time", WhenCreated, []string{"171111074031Z"}, []string{"20171111074031.0Z"}, false},
+		{"bad time", WhenCreated, []string{"yesterday"}, nil, true},
+		{"redacted time", WhenCreated, []string{"redacted:1234"}, []string{"redacted:1234"}, false},
+		{"hex timestamp", LastLogonTimestamp, []string{"0x1d9"}, nil, true},
+	}
+
+	objects := make([]*Object, len(tests))
+	for i, test := range tests {
+		o := NewObject()
+		o.SetValues(DistinguishedName, "CN=Test "+test.name+",DC=example,DC=local")
+		o.SetValues(test.attribute, append([]string(nil), test.values...)...)
+		AllObjects.Add(o)
+		objects[i] = o
+	}
+
+	AllProblems = nil
+	CoerceAttributeValues()
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := objects[i]
+			values, found := o.Attributes[test.attribute]
+			if test.want == nil {
+				if found {
+					t.Errorf("%v should be gone, has %q", test.attribute.String(), values)
+				}
+			} else if strings.Join(values, "|") != strings.Join(test.want, "|") {
+				t.Errorf("%v is %q, want %q", test.attribute.String(), values, test.want)
+			}
+
+			var problem bool
+			for _, p := range AllProblems {
+				problem = problem || p.DN == o.DN() && strings.Contains(p.Error, test.attribute.String())
+			}
+			if problem != test.problem {
+				t.Errorf("problem recorded = %v, want %v", problem, test.problem)
+			}
+		})
+	}
+}
+
+func TestSetValuesWithoutValuesRemoves(t *testing.T) {
+	o := NewObject()
+	o.SetValues(DistinguishedName, "CN=Test,DC=example,DC=local")
+	o.SetValues(SIDHistory, "S-1-5-21-1-2-3-1104")
+	o.SetValues(SIDHistory)
+	if _, found := o.Attributes[SIDHistory]; found {
+		t.Error("sIDHistory is still there after setting no values")
+	}
+	if meta := o.Meta(); len(meta) != 0 {
+		t.Errorf("Meta() = %v, want nothing", meta)
+	}
+	o.AddValues(SIDHistory)
+	if _, found := o.Attributes[SIDHistory]; found {
+		t.Error("Adding no values created sIDHistory")
+	}
+}
@@ -91,8 +91,39 @@ func ParseSecurityDescriptor(data []byte) (SecurityDescriptor, error) {
 	return result, nil
 }
 
-func (sd SecurityDescriptor) String() string {
-	var result string
+// Returns the security descriptor in self relative binary form, the opposite of ParseSecurityDescriptor
+func (sd SecurityDescriptor) Bytes() []byte {
+	result := make([]byte, 20)
+	result[0] = 1
+	control := sd.Control | CONTROLFLAG_SELF_RELATIVE
+	if len(sd.DACL.Entries) > 0 {
+		control |= CONTROLFLAG_DACL_PRESENT
+	}
+	if len(sd.SACL.Entries) > 0 {
+		control |= CONTROLFLAG_SACL_PRESENT
+	}
+	binary.LittleEndian.PutUint16(result[2:], uint16(control))
+	if !sd.Owner.IsNull() {
+		binary.LittleEndian.PutUint32(result[4:], uint32(len(result)))
+		result = append(result, sd.Owner...)
+	}
+	if !sd.Group.IsNull() {
+		binary.LittleEndian.PutUint32(result[8:], uint32(len(result)))
+		result = append(result, sd.Group...)
+	}
+	if control&CONTROLFLAG_SACL_PRESENT != 0 {
+		binary.LittleEndian.PutUint32(result[12:], uint32(len(result)))
+		result = append(result, sd.SACL.Bytes()...)
+	}
+	if control&CONTROLFLAG_DACL_PRESENT != 0 {
+		binary.LittleEndian.PutUint32(result[16:], uint32(len(result)))
+		result = append(result, sd.DACL.Bytes()...)
+	}
+	return result
+}
+
+// Returns the names of the control flags that are set
+func (sd SecurityDescriptor) ControlFlags() []string {
 	var flags []string
 	if sd.Control&CONTROLFLAG_OWNER_DEFAULTED != 0 {
 		flags = append(flags, "OWNER_DEFAULTED")
@@ -124,7 +155,11 @@ func (sd SecurityDescriptor) String() string {
 	if sd.Control&CONTROLFLAG_SACL_PROTECTED != 0 {
 		flags = append(flags, "SACL_PROTECTED")
 	}
-	result = "SecurityDescriptor: " + strings.Join(flags, " | ") + "\n"
+	return flags
+}
+
+func (sd SecurityDescriptor) String() string {
+	result := "SecurityDescriptor: " + strings.Join(sd.ControlFlags(), " | ") + "\n"
 	if !sd.Owner.IsNull() {
 		result += "Owner: " + sd.Owner.String() + "\n"
 	}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// Connection protected by the security layer negotiated in the bind (RFC 4422 section 3.7), where every LDAP message
+// is wrapped and sent as four bytes of length followed by the wrapped message. This is what LDAP signing is.
+// NegotiateStream (MS-NNS) frames the same way, but with the length little endian and a limit on each message
+type securityLayerConn struct {
+	net.Conn
+	wrap         func([]byte) ([]byte, error)
+	unwrap       func([]byte) ([]byte, error)
+	littleendian bool
+	maxwrite     int // Largest message to wrap, longer writes are split up, 0 means no limit
+	pending      []byte
+}
+
+func (c *securityLayerConn) order() binary.ByteOrder {
+	if c.littleendian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+func (c *securityLayerConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		var length [4]byte
+		if _, err := io.ReadFull(c.Conn, length[:]); err != nil {
+			return 0, err
+		}
+		size := c.order().Uint32(length[:])
+		if size > 1<<26 {
+			return 0, errors.New("Security layer buffer from the server is too large")
+		}
+		buffer := make([]byte, size)
+		if _, err := io.ReadFull(c.Conn, buffer); err != nil {
+			return 0, err
+		}
+		message, err := c.unwrap(buffer)
+		if err != nil {
+			return 0, err
+		}
+		c.pending = message
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// The ldap package writes one message at a time, so each write is wrapped on its own
+func (c *securityLayerConn) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		chunk := p[written:]
+		if c.maxwrite > 0 && len(chunk) > c.maxwrite {
+			chunk = chunk[:c.maxwrite]
+		}
+		wrapped, err := c.wrap(chunk)
+		if err != nil {
+			return written, err
+		}
+		buffer := make([]byte, 4, 4+len(wrapped))
+		c.order().PutUint32(buffer, uint32(len(wrapped)))
+		if _, err = c.Conn.Write(append(buffer, wrapped...)); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return len(p), nil
+}
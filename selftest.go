@@ -0,0 +1,422 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+//go:embed selftest/*.json
+var bundledCorpora embed.FS
+
+// A selftest corpus is a handful of objects, and the connections the analyzers should (and should not) find between
+// them. Objects refer to each other by DN (relative to the domain or absolute), name or SID
+type Corpus struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Domain      string         `json:"domain"` // Defaults to corpus.local
+	Objects     []CorpusObject `json:"objects"`
+	Expect      []CorpusEdge   `json:"expect"`
+	ExpectNot   []CorpusEdge   `json:"expectNot"`
+	// Problems loading or analyzing should record, and attributes that should be gone after loading
+	ExpectProblems []CorpusProblem   `json:"expectProblems"`
+	ExpectMissing  []CorpusAttribute `json:"expectMissing"`
+}
+
+type CorpusObject struct {
+	DN         string              `json:"dn"`
+	Class      string              `json:"class"` // user, computer, group, organizationalUnit, container, groupPolicyContainer ...
+	SID        string              `json:"sid"`   // S-1-... or a RID in the domain, blank gives users, computers and groups a new RID
+	MemberOf   []string            `json:"memberOf"`
+	Attributes map[string][]string `json:"attributes"`
+	Owner      string              `json:"owner"`
+	ACEs       []CorpusACE         `json:"aces"`
+}
+
+type CorpusACE struct {
+	Principal           string   `json:"principal"`
+	Deny                bool     `json:"deny"`
+	Rights              []string `json:"rights"`     // GENERIC_ALL, WRITE_DACL, DS_CONTROL_ACCESS ... as shown for ACEs in the UI
+	ObjectType          string   `json:"objectType"` // GUID of an extended right, attribute or class
+	InheritedObjectType string   `json:"inheritedObjectType"`
+	Inherit             bool     `json:"inherit"`
+	InheritOnly         bool     `json:"inheritOnly"`
+}
+
+type CorpusProblem struct {
+	Object   string `json:"object"`
+	Contains string `json:"contains"` // Text in the problem
+}
+
+type CorpusAttribute struct {
+	Object    string `json:"object"`
+	Attribute string `json:"attribute"`
+}
+
+type CorpusEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Method string `json:"method"` // Like GenericAll or MemberOfGroup
+}
+
+var corpusRights = map[string]uint32{
+	"GENERIC_READ":               RIGHT_GENERIC_READ,
+	"GENERIC_WRITE":              RIGHT_GENERIC_WRITE,
+	"GENERIC_EXECUTE":            RIGHT_GENERIC_EXECUTE,
+	"GENERIC_ALL":                RIGHT_GENERIC_ALL,
+	"MAXIMUM_ALLOWED":            RIGHT_MAXIMUM_ALLOWED,
+	"ACCESS_SYSTEM_SECURITY":     RIGHT_ACCESS_SYSTEM_SECURITY,
+	"SYNCRONIZE":                 RIGHT_SYNCRONIZE,
+	"WRITE_OWNER":                RIGHT_WRITE_OWNER,
+	"WRITE_DACL":                 RIGHT_WRITE_DACL,
+	"READ_CONTROL":               RIGHT_READ_CONTROL,
+	"DELETE":                     RIGHT_DELETE,
+	"DS_CONTROL_ACCESS":          RIGHT_DS_CONTROL_ACCESS,
+	"DS_LIST_OBJECT":             RIGHT_DS_LIST_OBJECT,
+	"DS_DELETE_TREE":             RIGHT_DS_DELETE_TREE,
+	"DS_WRITE_PROPERTY":          RIGHT_DS_WRITE_PROPERTY,
+	"DS_READ_PROPERTY":           RIGHT_DS_READ_PROPERTY,
+	"DS_WRITE_PROPERTY_EXTENDED": RIGHT_DS_WRITE_PROPERTY_EXTENDED,
+	"DS_LIST_CONTENTS":           RIGHT_DS_LIST_CONTENTS,
+	"DS_DELETE_CHILD":            RIGHT_DS_DELETE_CHILD,
+	"DS_CREATE_CHILD":            RIGHT_DS_CREATE_CHILD,
+}
+
+// Runs the analyzers on the corpora in the given files and folders, or the bundled ones if none are given
+func RunSelfTest(paths []string) error {
+	corpora := make(map[string][]byte)
+	if len(paths) == 0 {
+		files, _ := fs.Glob(bundledCorpora, "selftest/*.json")
+		for _, file := range files {
+			data, err := bundledCorpora.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			corpora[file] = data
+		}
+	}
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			files, _ = filepath.Glob(filepath.Join(path, "*.json"))
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			corpora[file] = data
+		}
+	}
+	if len(corpora) == 0 {
+		return errors.New("No corpora to run")
+	}
+
+	var names []string
+	for name := range corpora {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failed int
+	for _, name := range names {
+		corpus, failures, err := runCorpusFile(name, corpora[name])
+		if err != nil {
+			return err
+		}
+		if len(failures) > 0 {
+			failed++
+			log.Error().Msgf("FAIL %v", corpus.Name)
+			for _, failure := range failures {
+				log.Error().Msgf("  %v", failure)
+			}
+		} else {
+			log.Info().Msgf("PASS %v (%v expected, %v not expected, %v problems, %v missing)", corpus.Name, len(corpus.Expect), len(corpus.ExpectNot), len(corpus.ExpectProblems), len(corpus.ExpectMissing))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v corpora failed", failed, len(names))
+	}
+	log.Info().Msgf("All %v corpora passed", len(names))
+	return nil
+}
+
+// Parses and runs a corpus file, returning the corpus and the expectations that were not met
+func runCorpusFile(name string, data []byte) (Corpus, []string, error) {
+	var corpus Corpus
+	if err := json.Unmarshal(data, &corpus); err != nil {
+		return corpus, nil, fmt.Errorf("Problem parsing corpus %v: %v", name, err)
+	}
+	if corpus.Name == "" {
+		corpus.Name = name
+	}
+	failures, err := runCorpus(corpus)
+	if err != nil {
+		return corpus, nil, fmt.Errorf("Problem setting up corpus %v: %v", name, err)
+	}
+	return corpus, failures, nil
+}
+
+// Loads the corpus as the only data, analyzes it and returns the expectations that were not met
+func runCorpus(corpus Corpus) ([]string, error) {
+	resetObjects()
+	if corpus.Domain == "" {
+		corpus.Domain = "corpus.local"
+	}
+	AllObjects.Base = "dc=" + strings.Replace(corpus.Domain, ".", ",dc=", -1)
+	AllObjects.Domain = corpus.Domain
+
+	// The schema and well known principals the analysis needs, and the domain itself
+	g := newDemoGenerator(DemoOptions{Domain: corpus.Domain})
+	g.generateSchema()
+	absolute := func(dn string) string {
+		if strings.HasSuffix(strings.ToLower(dn), strings.ToLower(g.base)) {
+			return dn
+		}
+		return dn + "," + g.base
+	}
+	var hasroot bool
+	for _, co := range corpus.Objects {
+		hasroot = hasroot || strings.EqualFold(absolute(co.DN), g.base)
+	}
+	if !hasroot {
+		g.addPrincipal(g.base, "domainDNS", "", g.domainsid, nil)
+	}
+
+	sidOf := func(sid string) (SID, error) {
+		if rid, err := strconv.ParseUint(sid, 10, 32); err == nil {
+			return g.domainsid.AddSubAuthority(uint32(rid)), nil
+		}
+		return SIDFromString(sid)
+	}
+	raws := make([]*RawObject, len(corpus.Objects))
+	for i, co := range corpus.Objects {
+		if co.DN == "" || co.Class == "" {
+			return nil, fmt.Errorf("object %v needs both dn and class", i+1)
+		}
+		var knownclass bool
+		for _, class := range demoClasses {
+			knownclass = knownclass || class.name == co.Class
+		}
+		if !knownclass {
+			return nil, fmt.Errorf("%v: unknown class %v", co.DN, co.Class)
+		}
+		attributes := make(map[string][]string)
+		for name, values := range co.Attributes {
+			attributes[name] = values
+		}
+		dn := absolute(co.DN)
+		switch co.Class {
+		case "user", "computer", "group", "foreignSecurityPrincipal", "domainDNS":
+			var sid SID
+			if co.SID != "" {
+				var err error
+				if sid, err = sidOf(co.SID); err != nil {
+					return nil, fmt.Errorf("%v: %v", co.DN, err)
+				}
+			} else if co.Class == "domainDNS" {
+				sid = g.domainsid
+			}
+			var samaccountname string
+			if co.Class != "foreignSecurityPrincipal" && co.Class != "domainDNS" && len(attributes["sAMAccountName"]) == 0 {
+				samaccountname = dn[3:strings.Index(dn, ",")]
+				if co.Class == "computer" {
+					samaccountname += "$"
+				}
+			}
+			raws[i] = g.addPrincipal(dn, co.Class, samaccountname, sid, attributes)
+		default:
+			raws[i] = g.add(dn, co.Class, attributes)
+		}
+		// What the corpus gives wins over what is made up, so broken values can be tested
+		for name, values := range co.Attributes {
+			raws[i].Attributes[name] = values
+		}
+	}
+
+	// References to other objects
+	find := func(reference string) *RawObject {
+		for _, o := range g.objects {
+			if strings.EqualFold(o.DistinguishedName, absolute(reference)) || strings.EqualFold(o.Attributes["name"][0], reference) {
+				return o
+			}
+		}
+		return nil
+	}
+	principal := func(reference string) (SID, error) {
+		if strings.HasPrefix(reference, "S-1-") {
+			return SIDFromString(reference)
+		}
+		if o := find(reference); o != nil {
+			if sid, found := g.sids[o]; found {
+				return sid, nil
+			}
+			return "", fmt.Errorf("%v is not a security principal", reference)
+		}
+		return "", fmt.Errorf("%v not found", reference)
+	}
+	for i, co := range corpus.Objects {
+		for _, groupname := range co.MemberOf {
+			group := find(groupname)
+			if group == nil {
+				return nil, fmt.Errorf("group %v for %v not found", groupname, co.DN)
+			}
+			g.addMember(group, raws[i])
+		}
+
+		if co.Owner == "" && len(co.ACEs) == 0 {
+			continue
+		}
+		sd := SecurityDescriptor{Control: CONTROLFLAG_DACL_PRESENT | CONTROLFLAG_GROUP_DEFAULTED}
+		if co.Owner == "" {
+			sd.Control |= CONTROLFLAG_OWNER_DEFAULTED
+		} else {
+			owner, err := principal(co.Owner)
+			if err != nil {
+				return nil, fmt.Errorf("owner of %v: %v", co.DN, err)
+			}
+			sd.Owner = owner
+		}
+		for _, ca := range co.ACEs {
+			var ace ACE
+			sid, err := principal(ca.Principal)
+			if err != nil {
+				return nil, fmt.Errorf("ACE on %v: %v", co.DN, err)
+			}
+			ace.SID = sid
+			for _, right := range ca.Rights {
+				mask, found := corpusRights[strings.ToUpper(right)]
+				if !found {
+					return nil, fmt.Errorf("ACE on %v: unknown right %v", co.DN, right)
+				}
+				ace.Mask |= mask
+			}
+			if ca.ObjectType != "" {
+				if ace.ObjectType, err = uuid.FromString(ca.ObjectType); err != nil {
+					return nil, fmt.Errorf("ACE on %v: %v", co.DN, err)
+				}
+				ace.Flags |= OBJECT_TYPE_PRESENT
+			}
+			if ca.InheritedObjectType != "" {
+				if ace.InheritedObjectType, err = uuid.FromString(ca.InheritedObjectType); err != nil {
+					return nil, fmt.Errorf("ACE on %v: %v", co.DN, err)
+				}
+				ace.Flags |= INHERITED_OBJECT_TYPE_PRESENT
+			}
+			switch {
+			case ca.Deny && ace.Flags != 0:
+				ace.Type = ACETYPE_ACCESS_DENIED_OBJECT
+			case ca.Deny:
+				ace.Type = ACETYPE_ACCESS_DENIED
+			case ace.Flags != 0:
+				ace.Type = ACETYPE_ACCESS_ALLOWED_OBJECT
+			default:
+				ace.Type = ACETYPE_ACCESS_ALLOWED
+			}
+			if ca.Inherit {
+				ace.ACEFlags |= ACEFLAG_INHERIT_ACE
+			}
+			if ca.InheritOnly {
+				ace.ACEFlags |= ACEFLAG_INHERIT_ACE | ACEFLAG_INHERIT_ONLY_ACE
+			}
+			sd.DACL.Entries = append(sd.DACL.Entries, ace)
+		}
+		raws[i].Attributes["nTSecurityDescriptor"] = []string{string(sd.Bytes())}
+	}
+
+	for _, raw := range g.objects {
+		AllObjects.Add(raw.ToObject(true))
+	}
+
+	// The analysis is chatty about well known SIDs that are added, which isn't interesting here
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	ProcessObjects(corpus.Domain)
+	zerolog.SetGlobalLevel(level)
+
+	lookup := func(reference string) *Object {
+		if o, found := AllObjects.Find(absolute(reference)); found {
+			return o
+		}
+		if o, found := AllObjects.Find(reference); found {
+			return o
+		}
+		for _, o := range AllObjects.AsArray() {
+			if strings.EqualFold(o.OneAttr(Name), reference) {
+				return o
+			}
+		}
+		return nil
+	}
+	var failures []string
+	check := func(edge CorpusEdge, expected bool) {
+		method, err := PwnMethodString(edge.Method)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("unknown method %v", edge.Method))
+			return
+		}
+		from, to := lookup(edge.From), lookup(edge.To)
+		if from == nil || to == nil {
+			failures = append(failures, fmt.Sprintf("%v or %v not found", edge.From, edge.To))
+			return
+		}
+		var found PwnMethod
+		for _, pi := range from.CanPwnSnapshot() {
+			if pi.Target == to {
+				found = pi.Method
+			}
+		}
+		if (found&method != 0) != expected {
+			var not string
+			if !expected {
+				not = "not "
+			}
+			failures = append(failures, fmt.Sprintf("expected %v %vto pwn %v with %v, found [%v]", edge.From, not, edge.To, edge.Method, found.JoinedString()))
+		}
+	}
+	for _, edge := range corpus.Expect {
+		check(edge, true)
+	}
+	for _, edge := range corpus.ExpectNot {
+		check(edge, false)
+	}
+	problemlock.Lock()
+	problems := AllProblems
+	problemlock.Unlock()
+	for _, expected := range corpus.ExpectProblems {
+		o := lookup(expected.Object)
+		if o == nil {
+			failures = append(failures, fmt.Sprintf("%v not found", expected.Object))
+			continue
+		}
+		var found bool
+		for _, problem := range problems {
+			found = found || strings.EqualFold(problem.DN, o.DN()) && strings.Contains(strings.ToLower(problem.Error), strings.ToLower(expected.Contains))
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("expected a problem with %v containing %q", expected.Object, expected.Contains))
+		}
+	}
+	for _, expected := range corpus.ExpectMissing {
+		o := lookup(expected.Object)
+		if o == nil {
+			failures = append(failures, fmt.Sprintf("%v not found", expected.Object))
+			continue
+		}
+		if values := o.Attr(NewAttribute(expected.Attribute)); len(values) > 0 {
+			failures = append(failures, fmt.Sprintf("expected %v to have no %v, found %v values", expected.Object, expected.Attribute, len(values)))
+		}
+	}
+	return failures, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+)
+
+// Runs the bundled corpora like the selftest command does
+func TestSelftestCorpora(t *testing.T) {
+	defer resetObjects()
+
+	files, err := fs.Glob(bundledCorpora, "selftest/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("No bundled corpora")
+	}
+	for _, file := range files {
+		data, err := bundledCorpora.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(file, func(t *testing.T) {
+			_, failures, err := runCorpusFile(file, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, failure := range failures {
+				t.Error(failure)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Collects environment, owner, asset tag and the applications running on them for the computers in the ServiceNow
+// CMDB with the Table API. It's saved as <name>.metadata.csv in the data folder, so the computers get _environment,
+// _owner, _assettag, _application and _criticality when the data is loaded, to prioritize paths by
+type ServiceNowCollector struct {
+	URL      string // https://contoso.service-now.com
+	User     string // Basic authentication with User and Password, or an OAuth token
+	Password string
+	Token    string
+
+	client http.Client
+}
+
+// Rows per request, the Table API has a limit of 10000
+const serviceNowPageSize = 1000
+
+type serviceNowComputer struct {
+	SysID       string `json:"sys_id"`
+	Name        string `json:"name"`
+	Environment string `json:"environment"`
+	UsedFor     string `json:"used_for"`
+	OwnedBy     string `json:"owned_by"`
+	ManagedBy   string `json:"managed_by"`
+	AssetTag    string `json:"asset_tag"`
+}
+
+type serviceNowRelation struct {
+	Parent      string `json:"parent.name"`
+	Criticality string `json:"parent.busines_criticality"` // Sic, that's what the field is called
+	Child       string `json:"child.sys_id"`
+}
+
+// Gets all the rows from the table matching the query, a page at a time
+func (c *ServiceNowCollector) getTable(table, query, fields string, each func(data []byte) error) error {
+	for offset := 0; ; offset += serviceNowPageSize {
+		parameters := url.Values{
+			"sysparm_query":                  {query},
+			"sysparm_fields":                 {fields},
+			"sysparm_display_value":          {"true"},
+			"sysparm_exclude_reference_link": {"true"},
+			"sysparm_limit":                  {strconv.Itoa(serviceNowPageSize)},
+			"sysparm_offset":                 {strconv.Itoa(offset)},
+		}
+		req, err := http.NewRequest("GET", c.URL+"/api/now/table/"+table+"?"+parameters.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		} else {
+			req.SetBasicAuth(c.User, c.Password)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%v returned %v: %v", table, resp.Status, string(data))
+		}
+		var page struct {
+			Result []json.RawMessage `json:"result"`
+		}
+		if err = qjson.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		for _, row := range page.Result {
+			if err = each(row); err != nil {
+				return err
+			}
+		}
+		if len(page.Result) < serviceNowPageSize {
+			return nil
+		}
+	}
+}
+
+// The sAMAccountName of the computer with the host name, which is the NetBIOS name cut to 15 characters
+func serviceNowAccountName(name string) string {
+	if dot := strings.Index(name, "."); dot != -1 {
+		name = name[:dot]
+	}
+	if len(name) > 15 {
+		name = name[:15]
+	}
+	return strings.ToUpper(name) + "$"
+}
+
+func (c *ServiceNowCollector) Collect(filename string) (int, error) {
+	c.URL = strings.TrimSuffix(c.URL, "/")
+	c.client.Timeout = 120 * time.Second
+
+	var computers []serviceNowComputer
+	err := c.getTable("cmdb_ci_computer", "operational_status=1", "sys_id,name,environment,used_for,owned_by,managed_by,asset_tag", func(data []byte) error {
+		var computer serviceNowComputer
+		err := qjson.Unmarshal(data, &computer)
+		if computer.Name != "" {
+			computers = append(computers, computer)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Info().Msgf("Collected %v computers, getting the applications running on them", len(computers))
+
+	ids := make(map[string]struct{}, len(computers))
+	for _, computer := range computers {
+		ids[computer.SysID] = struct{}{}
+	}
+	applications := make(map[string][]string)
+	criticality := make(map[string]string)
+	err = c.getTable("cmdb_rel_ci", "type.nameINRuns on::Runs,Depends on::Used by^child.sys_class_nameINSTANCEOFcmdb_ci_computer", "parent.name,parent.busines_criticality,child.sys_id", func(data []byte) error {
+		var relation serviceNowRelation
+		if err := qjson.Unmarshal(data, &relation); err != nil {
+			return err
+		}
+		if _, found := ids[relation.Child]; !found || relation.Parent == "" {
+			return nil
+		}
+		applications[relation.Child] = append(applications[relation.Child], relation.Parent)
+		// The most critical one, they're like "1 - most critical"
+		if relation.Criticality != "" && (criticality[relation.Child] == "" || relation.Criticality < criticality[relation.Child]) {
+			criticality[relation.Child] = relation.Criticality
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return 0, err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"sAMAccountName", "Environment", "Owner", "Asset tag", "Application", "Criticality"})
+	for _, computer := range computers {
+		apps := applications[computer.SysID]
+		sort.Strings(apps)
+		w.Write([]string{
+			serviceNowAccountName(computer.Name),
+			Default(computer.Environment, computer.UsedFor),
+			Default(computer.OwnedBy, computer.ManagedBy),
+			computer.AssetTag,
+			strings.Join(apps, ", "),
+			criticality[computer.SysID],
+		})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return len(computers), f.Close()
+}
+
+// Collects from the ServiceNow CMDB and saves it in the data folder, where analyze picks it up
+func CollectServiceNow(instanceurl, user, name, datapath string) error {
+	collector := ServiceNowCollector{
+		URL:      instanceurl,
+		User:     user,
+		Password: os.Getenv("SERVICENOW_PASSWORD"),
+		Token:    os.Getenv("SERVICENOW_TOKEN"),
+	}
+	if instanceurl == "" {
+		return errors.New("-servicenowurl is needed to collect from ServiceNow")
+	}
+	if collector.Token == "" && (user == "" || collector.Password == "") {
+		return errors.New("Collecting from ServiceNow needs -servicenowuser with the password in SERVICENOW_PASSWORD, or an OAuth token in SERVICENOW_TOKEN")
+	}
+	if name == "" {
+		name = "servicenow"
+		if u, err := url.Parse(instanceurl); err == nil && u.Hostname() != "" {
+			name = strings.SplitN(u.Hostname(), ".", 2)[0]
+		}
+	}
+	filename := filepath.Join(datapath, name+".metadata.csv")
+	computers, err := collector.Collect(filename)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Saved %v computers to %v", computers, filename)
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Showmax/go-fqdn"
+	ldap "github.com/lkarlslund/ldap/v3"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Returns the domain this machine is a member of, or blank if it can't be found
+func DetectDomain() string {
+	domain := strings.ToLower(os.Getenv("USERDNSDOMAIN"))
+	if domain == "" {
+		// That didn't work, lets try something else
+		f, err := fqdn.FqdnHostname()
+		if err == nil && strings.Contains(f, ".") {
+			domain = strings.ToLower(f[strings.Index(f, ".")+1:])
+		}
+	}
+	return domain
+}
+
+// Returns the domain controllers for the domain from DNS
+func FindDomainControllers(domain string) []string {
+	var results []string
+	cname, servers, err := directoryResolver.LookupSRV(context.Background(), "", "", "_ldap._tcp.dc._msdcs."+domain)
+	if err != nil || cname == "" {
+		return nil
+	}
+	for _, server := range servers {
+		results = append(results, strings.TrimSuffix(server.Target, "."))
+	}
+	return results
+}
+
+func probePort(server string, port int) error {
+	conn, err := dialDirectoryTimeout(net.JoinHostPort(server, strconv.Itoa(port)), 5*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// Connects with TLS and returns the certificate chain, and an error explaining why it's not trusted if it isn't
+func probeTLS(server string, port int) ([]*x509.Certificate, error, error) {
+	address := net.JoinHostPort(server, strconv.Itoa(port))
+	conn, err := dialDirectoryTLS(address, 5*time.Second, &tls.Config{ServerName: server})
+	if err == nil {
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates, nil, nil
+	}
+	trusterr := err
+	conn, err = dialDirectoryTLS(address, 5*time.Second, &tls.Config{ServerName: server, InsecureSkipVerify: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates, trusterr, nil
+}
+
+// Translates bind errors from AD into what to do about them. AD puts a Win32 error code in the diagnostic message
+// (data 52e and so on), and the LDAP result code tells if signing or encryption is required
+func explainBindError(err error) string {
+	if err == nil {
+		return ""
+	}
+	message := err.Error()
+	for code, explanation := range map[string]string{
+		"80090346":                    "channel binding (extended protection) is required by the DC, which this bind mode can't do - use ntlm or gssapi over TLS",
+		"data 52e":                    "wrong username or password",
+		"data 525":                    "user not found",
+		"data 530":                    "not permitted to log on at this time",
+		"data 531":                    "not permitted to log on from this workstation",
+		"data 532":                    "password expired",
+		"data 533":                    "account disabled",
+		"data 701":                    "account expired",
+		"data 773":                    "user must change password",
+		"data 775":                    "account locked out",
+		"krb_ap_err_skew":             "clock skew between this machine and the DC is too large for Kerberos - sync the clock",
+		"kdc_err_s_principal_unknown": "no Kerberos service principal for that server name - use the host name of the DC, not an IP address",
+		"krb_ap_err_tkt_expired":      "the Kerberos ticket in the credential cache has expired - get a new one",
+	} {
+		if strings.Contains(strings.ToLower(message), code) {
+			return explanation
+		}
+	}
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultStrongAuthRequired):
+		return "LDAP signing is required by the DC - use ntlm or gssapi, which sign without TLS, or use TLS (port 636) or StartTLS"
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultConfidentialityRequired):
+		return "the DC requires an encrypted connection - use TLS (port 636) or StartTLS"
+	case strings.Contains(message, "x509:"):
+		return "the DC certificate is not trusted (" + message + ") - use -ignorecert or add the CA to the trust store"
+	}
+	return message
+}
+
+// Tries to bind with the settings, and returns nil if it works
+func tryBind(ad AD, authmode byte) error {
+	err := ad.Connect(authmode)
+	if ad.conn != nil {
+		ad.Disconnect()
+	}
+	return err
+}
+
+// Interactive first time setup, that finds a working way to connect to the domain and saves it to the config file
+func RunSetup(configfile string) error {
+	in := bufio.NewReader(os.Stdin)
+	ask := func(question, defaultvalue string) string {
+		if defaultvalue != "" {
+			fmt.Printf("%v [%v]: ", question, defaultvalue)
+		} else {
+			fmt.Printf("%v: ", question)
+		}
+		answer, _ := in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return defaultvalue
+		}
+		return answer
+	}
+	settings := make(map[string]string)
+
+	domain := strings.ToLower(ask("Domain to collect from", DetectDomain()))
+	if domain == "" {
+		return errors.New("A domain is needed")
+	}
+	settings["domain"] = domain
+
+	// Domain controllers
+	servers := FindDomainControllers(domain)
+	if len(servers) > 0 {
+		log.Info().Msgf("Found %v domain controllers in DNS: %v", len(servers), strings.Join(servers, ", "))
+	} else {
+		log.Warn().Msgf("Could not find domain controllers for %v in DNS, is this machine using the domain DNS servers?", domain)
+	}
+	var server string
+	var tlsport, plainport bool
+	for _, candidate := range servers {
+		tlsport = probePort(candidate, 636) == nil
+		plainport = probePort(candidate, 389) == nil
+		if tlsport || plainport {
+			server = candidate
+			break
+		}
+		log.Warn().Msgf("Can't reach %v on port 389 or 636", candidate)
+	}
+	if server == "" {
+		server = ask("Domain controller to connect to", "")
+		if server == "" {
+			return errors.New("A domain controller is needed")
+		}
+		tlsport = probePort(server, 636) == nil
+		plainport = probePort(server, 389) == nil
+	}
+	log.Info().Msgf("Using %v - LDAPS (636) reachable: %v, LDAP (389) reachable: %v", server, tlsport, plainport)
+	settings["server"] = server
+
+	// Transport
+	ad := AD{Domain: domain, Server: server, AuthDomain: domain}
+	switch {
+	case tlsport:
+		ad.Port, ad.TLSMode = 636, TLS
+		if _, trusterr, err := probeTLS(server, 636); err != nil {
+			log.Warn().Msgf("TLS handshake with %v failed: %v", server, err)
+		} else if trusterr != nil {
+			log.Warn().Msgf("The certificate of %v is not trusted: %v", server, trusterr)
+			ad.IgnoreCert = true
+		} else {
+			log.Info().Msg("Certificate is trusted, certificate checks can be enabled")
+		}
+	case plainport:
+		ad.Port, ad.TLSMode = 389, NoTLS
+		log.Warn().Msg("Only plain LDAP is reachable, credentials are protected by the bind mode only and the DC may require signing")
+	default:
+		return fmt.Errorf("Can't reach %v on port 389 or 636", server)
+	}
+
+	if err := ad.Connect(0); err == nil {
+		ad.CheckClockSkew()
+		ad.Disconnect()
+	}
+
+	// Bind modes to try, integrated authentication first as it needs no password
+	var modes []string
+	if runtime.GOOS == "windows" {
+		modes = append(modes, "ntlmsspi")
+	}
+	modes = append(modes, "ntlm", "simple", "md5")
+
+	var working string
+	var asked bool
+	for _, modename := range modes {
+		authmode, _ := ParseAuthMode(modename)
+		if modename != "ntlmsspi" && !asked {
+			user := ask("Username", os.Getenv("USERNAME"))
+			fmt.Printf("Password for %v (not saved): ", user)
+			password, _ := terminal.ReadPassword(int(syscall.Stdin))
+			fmt.Println()
+			ad.User = user + "@" + domain
+			ad.Password = string(password)
+			settings["username"] = user
+			asked = true
+		}
+		err := tryBind(ad, authmode)
+		if err == nil {
+			log.Info().Msgf("Bind with %v works", modename)
+			working = modename
+			break
+		}
+		log.Warn().Msgf("Bind with %v failed: %v", modename, explainBindError(err))
+		if strings.Contains(err.Error(), "data 52e") || strings.Contains(err.Error(), "data 775") {
+			// No need to lock the account out trying the same password again
+			break
+		}
+	}
+	if working == "" {
+		return errors.New("No bind mode worked, see above for why - run 'adalanche diagnose' for details")
+	}
+	if working == "ntlmsspi" {
+		delete(settings, "username")
+	}
+	settings["authmode"] = working
+	settings["port"] = strconv.Itoa(int(ad.Port))
+	settings["tlsmode"] = ad.TLSMode.String()
+	settings["ignorecert"] = strconv.FormatBool(ad.IgnoreCert)
+
+	log.Info().Msgf("Recommended settings: -domain %v -server %v -port %v -tlsmode %v -ignorecert=%v -authmode %v",
+		domain, server, ad.Port, ad.TLSMode, ad.IgnoreCert, working)
+	if answer := ask("Save these to "+configfile+" so they are used by default", "yes"); !strings.HasPrefix(strings.ToLower(answer), "y") {
+		return nil
+	}
+	if err := SaveConfigFile(configfile, "Written by adalanche setup "+time.Now().Format(time.RFC3339)+"\nFlags given on the command line take precedence", settings); err != nil {
+		return err
+	}
+	log.Info().Msgf("Saved to %v, run 'adalanche dump' to collect", configfile)
+	return nil
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// SharpHound collections (the ZIP with users.json, computers.json, groups.json and so on, v4 and later). The objects
+// are turned back into what a dump has, with security descriptors made from the ACEs SharpHound resolved, and the
+// sessions and local administrators go to .localmachine.json files like data collected on the machines would
+
+type sharpHoundFile struct {
+	Data []sharpHoundObject `json:"data"`
+	Meta struct {
+		Type    string `json:"type"`
+		Version int    `json:"version"`
+	} `json:"meta"`
+}
+
+type sharpHoundObject struct {
+	ObjectIdentifier   string                 `json:"ObjectIdentifier"`
+	Properties         map[string]interface{} `json:"Properties"`
+	Aces               []sharpHoundACE        `json:"Aces"`
+	IsDeleted          bool                   `json:"IsDeleted"`
+	IsACLProtected     bool                   `json:"IsACLProtected"`
+	PrimaryGroupSID    string                 `json:"PrimaryGroupSID"`
+	Members            []sharpHoundPrincipal  `json:"Members"`
+	AllowedToAct       []sharpHoundPrincipal  `json:"AllowedToAct"`
+	Links              []sharpHoundLink       `json:"Links"`
+	Sessions           sharpHoundSessions     `json:"Sessions"`
+	PrivilegedSessions sharpHoundSessions     `json:"PrivilegedSessions"`
+	RegistrySessions   sharpHoundSessions     `json:"RegistrySessions"`
+	LocalAdmins        struct {
+		Results []sharpHoundPrincipal `json:"Results"`
+	} `json:"LocalAdmins"`
+	RemoteDesktopUsers struct {
+		Results []sharpHoundPrincipal `json:"Results"`
+	} `json:"RemoteDesktopUsers"`
+	DcomUsers struct {
+		Results []sharpHoundPrincipal `json:"Results"`
+	} `json:"DcomUsers"`
+	LocalGroups []struct { // SharpHound CE has all the local groups instead of LocalAdmins
+		ObjectIdentifier string                `json:"ObjectIdentifier"`
+		Results          []sharpHoundPrincipal `json:"Results"`
+	} `json:"LocalGroups"`
+}
+
+type sharpHoundACE struct {
+	PrincipalSID string `json:"PrincipalSID"`
+	RightName    string `json:"RightName"`
+	IsInherited  bool   `json:"IsInherited"`
+}
+
+type sharpHoundPrincipal struct {
+	ObjectIdentifier string `json:"ObjectIdentifier"`
+}
+
+type sharpHoundLink struct {
+	GUID       string `json:"GUID"`
+	IsEnforced bool   `json:"IsEnforced"`
+}
+
+type sharpHoundSessions struct {
+	Results []struct {
+		UserSID string `json:"UserSID"`
+	} `json:"Results"`
+}
+
+// Class of the objects in each kind of file
+var sharpHoundClasses = map[string]string{
+	"users":      "user",
+	"computers":  "computer",
+	"groups":     "group",
+	"domains":    "domainDNS",
+	"ous":        "organizationalUnit",
+	"gpos":       "groupPolicyContainer",
+	"containers": "container",
+}
+
+// The LAPS password attribute has a GUID of its own in every forest, and SharpHound doesn't say what it is. This one
+// stands in for it, so the ReadLAPSPassword ACEs can be put in the security descriptors
+var sharpHoundLAPSGUID = uuid.Must(uuid.FromString("5d6e6a1c-3f7b-4b36-9f0e-ad1a1a2b7c3d"))
+
+// Edges SharpHound resolved from the ACEs, as the ACE that gives the same edge in the analysis. Owns is the owner
+// of the security descriptor instead, and the rest have no counterpart
+var sharpHoundRights = map[string]ACE{
+	"GenericAll":               {Type: ACETYPE_ACCESS_ALLOWED, Mask: RIGHT_GENERIC_ALL},
+	"GenericWrite":             {Type: ACETYPE_ACCESS_ALLOWED, Mask: RIGHT_GENERIC_WRITE},
+	"WriteOwner":               {Type: ACETYPE_ACCESS_ALLOWED, Mask: RIGHT_WRITE_OWNER},
+	"WriteDacl":                {Type: ACETYPE_ACCESS_ALLOWED, Mask: RIGHT_WRITE_DACL},
+	"AllExtendedRights":        {Type: ACETYPE_ACCESS_ALLOWED, Mask: RIGHT_DS_CONTROL_ACCESS},
+	"ForceChangePassword":      {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_CONTROL_ACCESS, Flags: OBJECT_TYPE_PRESENT, ObjectType: ResetPwd},
+	"GetChanges":               {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_CONTROL_ACCESS, Flags: OBJECT_TYPE_PRESENT, ObjectType: DSReplicationGetChanges},
+	"GetChangesAll":            {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_CONTROL_ACCESS, Flags: OBJECT_TYPE_PRESENT, ObjectType: DSReplicationGetChangesAll},
+	"AddMember":                {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_WRITE_PROPERTY, Flags: OBJECT_TYPE_PRESENT, ObjectType: AttributeMember},
+	"AddSelf":                  {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_WRITE_PROPERTY_EXTENDED, Flags: OBJECT_TYPE_PRESENT, ObjectType: ValidateWriteSelfMembership},
+	"WriteSPN":                 {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_WRITE_PROPERTY, Flags: OBJECT_TYPE_PRESENT, ObjectType: AttributeSPN},
+	"AddKeyCredentialLink":     {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_WRITE_PROPERTY, Flags: OBJECT_TYPE_PRESENT, ObjectType: AttributeMSDSKeyCredentialLink},
+	"AddAllowedToAct":          {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_WRITE_PROPERTY, Flags: OBJECT_TYPE_PRESENT, ObjectType: AttributeAllowedToActOnBehalfOfOtherIdentity},
+	"WriteAccountRestrictions": {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_WRITE_PROPERTY, Flags: OBJECT_TYPE_PRESENT, ObjectType: AttributeAllowedToActOnBehalfOfOtherIdentity}, // The property set includes it
+	"WriteGPLink":              {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_WRITE_PROPERTY, Flags: OBJECT_TYPE_PRESENT, ObjectType: AttributeGPLink},
+	"ReadLAPSPassword":         {Type: ACETYPE_ACCESS_ALLOWED_OBJECT, Mask: RIGHT_DS_READ_PROPERTY, Flags: OBJECT_TYPE_PRESENT, ObjectType: sharpHoundLAPSGUID},
+}
+
+// Dumps the objects in a SharpHound collection that are not in an excluded subtree, and writes the sessions and
+// local administrators of the computers to datapath
+func DumpSharpHound(filename, datapath string, exclusions []string, redactor *Redactor, attributes []string, e *msgp.Writer) error {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	type sharpHoundObjects struct {
+		class     string
+		collected time.Time
+		objects   []sharpHoundObject
+	}
+	var files []sharpHoundObjects
+	for _, entry := range archive.File {
+		if !strings.HasSuffix(strings.ToLower(entry.Name), ".json") {
+			continue
+		}
+		reader, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("Problem reading %v: %v", entry.Name, err)
+		}
+		var file sharpHoundFile
+		if err = qjson.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("Problem decoding %v: %v", entry.Name, err)
+		}
+		if file.Meta.Version < 4 {
+			return fmt.Errorf("%v is from SharpHound version %v, only version 4 and later are supported", entry.Name, file.Meta.Version)
+		}
+		class, found := sharpHoundClasses[file.Meta.Type]
+		if !found {
+			log.Info().Msgf("Skipping %v with %v, which isn't imported", entry.Name, file.Meta.Type)
+			continue
+		}
+		files = append(files, sharpHoundObjects{class: class, collected: entry.Modified, objects: file.Data})
+	}
+	if len(files) == 0 {
+		return errors.New("No SharpHound data in the file")
+	}
+
+	// Members, links and so on point to SIDs and GUIDs, and the dump has DNs
+	dns := make(map[string]string)
+	var base string
+	for _, file := range files {
+		for _, object := range file.objects {
+			dn := sharpHoundString(object.Properties, "distinguishedname")
+			if dn == "" || object.IsDeleted {
+				continue
+			}
+			dns[strings.ToUpper(sharpHoundSID(object.ObjectIdentifier))] = dn
+			if file.class == "domainDNS" && base == "" {
+				base = dn
+			}
+		}
+	}
+	if base == "" {
+		for _, dn := range dns {
+			base = sharpHoundDomainDN(dn)
+			break
+		}
+	}
+
+	schema := sharpHoundSchema(base)
+	for _, object := range schema {
+		if sid := object.Attributes["objectSid"]; len(sid) > 0 {
+			dns[SID(sid[0]).ToString()] = object.DistinguishedName
+		}
+	}
+
+	// Both sides of the group memberships, as the analysis uses memberOf too. Members that aren't in the collection,
+	// like principals from other domains or well known ones, become foreign security principals
+	members := make(map[string][]string)
+	memberof := make(map[string][]string)
+	foreign := make(map[string]*RawObject)
+	for _, file := range files {
+		for _, object := range file.objects {
+			dn := sharpHoundString(object.Properties, "distinguishedname")
+			if dn == "" || object.IsDeleted {
+				continue
+			}
+			for _, member := range object.Members {
+				sid := sharpHoundSID(member.ObjectIdentifier)
+				memberdn, found := dns[strings.ToUpper(sid)]
+				if !found {
+					fsp, found := foreign[sid]
+					if !found {
+						binarysid, err := SIDFromString(sid)
+						if err != nil {
+							continue
+						}
+						fsp = sharpHoundObjectOfClass("foreignSecurityPrincipal", "CN="+sid+",CN=ForeignSecurityPrincipals,"+sharpHoundDomainDN(dn), base)
+						fsp.Attributes["objectSid"] = []string{string(binarysid)}
+						foreign[sid] = fsp
+					}
+					memberdn = fsp.DistinguishedName
+				}
+				members[dn] = append(members[dn], memberdn)
+				memberof[memberdn] = append(memberof[memberdn], dn)
+			}
+		}
+	}
+
+	w := newFileDumpWriter(e, exclusions, redactor, attributes)
+	for _, object := range schema {
+		if len(memberof[object.DistinguishedName]) > 0 {
+			object.Attributes["memberOf"] = memberof[object.DistinguishedName]
+		}
+		if err = w.Write(object); err != nil {
+			return err
+		}
+	}
+
+	var machines, skipped int
+	for _, file := range files {
+		for _, object := range file.objects {
+			dn := sharpHoundString(object.Properties, "distinguishedname")
+			if dn == "" || object.IsDeleted {
+				skipped++
+				continue
+			}
+			raw := sharpHoundRawObject(file.class, dn, object, base, dns)
+			if len(members[dn]) > 0 {
+				raw.Attributes["member"] = members[dn]
+			}
+			if len(memberof[dn]) > 0 {
+				raw.Attributes["memberOf"] = memberof[dn]
+			}
+			if err = w.Write(raw); err != nil {
+				return err
+			}
+
+			if file.class == "computer" && !dnExcluded(dn, exclusions) {
+				machine := sharpHoundLocalMachine(object, file.collected)
+				if len(machine.Sessions) == 0 && len(machine.LocalAdmins) == 0 && len(machine.RDPUsers) == 0 && len(machine.DCOMUsers) == 0 {
+					continue
+				}
+				data, err := qjson.MarshalIndent(machine, "", "  ")
+				if err != nil {
+					return err
+				}
+				if err = os.WriteFile(filepath.Join(datapath, machine.Name+".localmachine.json"), data, 0600); err != nil {
+					return err
+				}
+				machines++
+			}
+		}
+	}
+	for _, fsp := range foreign {
+		fsp.Attributes["memberOf"] = memberof[fsp.DistinguishedName]
+		if err = w.Write(fsp); err != nil {
+			return err
+		}
+	}
+	if skipped > 0 {
+		log.Info().Msgf("Skipped %v deleted objects or objects without a distinguished name", skipped)
+	}
+	if machines > 0 {
+		log.Info().Msgf("Wrote sessions and local administrators for %v computers to %v", machines, datapath)
+	}
+	w.Done(filename)
+	return nil
+}
+
+// Makes the attributes a dump would have from what SharpHound collected
+func sharpHoundRawObject(class, dn string, object sharpHoundObject, base string, dns map[string]string) *RawObject {
+	raw := sharpHoundObjectOfClass(class, dn, base)
+	properties := object.Properties
+
+	sid := sharpHoundSID(object.ObjectIdentifier)
+	if binarysid, err := SIDFromString(sid); err == nil {
+		raw.Attributes["objectSid"] = []string{string(binarysid)}
+	} else if guid, err := uuid.FromString(object.ObjectIdentifier); err == nil {
+		raw.Attributes["objectGUID"] = []string{string(SwapUUIDEndianess(guid).Bytes())}
+	}
+
+	for property, attribute := range map[string]string{
+		"description":     "description",
+		"displayname":     "displayName",
+		"email":           "mail",
+		"title":           "title",
+		"homedirectory":   "homeDirectory",
+		"userpassword":    "userPassword",
+		"operatingsystem": "operatingSystem",
+		"gpcpath":         "gPCFileSysPath",
+	} {
+		if value := sharpHoundString(properties, property); value != "" {
+			raw.Attributes[attribute] = []string{value}
+		}
+	}
+	for property, attribute := range map[string]string{
+		"serviceprincipalnames": "servicePrincipalName",
+		"allowedtodelegate":     "msDS-AllowedToDelegateTo",
+	} {
+		if values := sharpHoundStrings(properties, property); len(values) > 0 {
+			raw.Attributes[attribute] = values
+		}
+	}
+	for _, value := range sharpHoundStrings(properties, "sidhistory") {
+		if binarysid, err := SIDFromString(value); err == nil {
+			raw.Attributes["sIDHistory"] = append(raw.Attributes["sIDHistory"], string(binarysid))
+		}
+	}
+	if when, found := sharpHoundInt(properties, "whencreated"); found && when > 0 {
+		raw.Attributes["whenCreated"] = []string{time.Unix(when, 0).UTC().Format("20060102150405") + ".0Z"}
+	}
+	for property, attribute := range map[string]string{
+		"pwdlastset":         "pwdLastSet",
+		"lastlogontimestamp": "lastLogonTimestamp",
+	} {
+		if when, found := sharpHoundInt(properties, property); found && when >= 0 {
+			var filetime int64
+			if when > 0 {
+				filetime = (when + 11644473600) * 10000000
+			}
+			raw.Attributes[attribute] = []string{strconv.FormatInt(filetime, 10)}
+		}
+	}
+	if sharpHoundBool(properties, "admincount") {
+		raw.Attributes["adminCount"] = []string{"1"}
+	}
+
+	// Principals have names like JOE@CONTOSO.LOCAL and WS01.CONTOSO.LOCAL, SharpHound CE has the sAMAccountName too
+	name := sharpHoundString(properties, "name")
+	samaccountname := sharpHoundString(properties, "samaccountname")
+	switch class {
+	case "user", "computer":
+		uac := UAC_NORMAL_ACCOUNT
+		if class == "computer" {
+			uac = UAC_WORKSTATION_TRUST_ACCOUNT
+			if sharpHoundBool(properties, "isdc") || strings.HasSuffix(object.PrimaryGroupSID, "-516") {
+				uac = UAC_SERVER_TRUST_ACCOUNT
+			}
+			hostname := strings.ToLower(name)
+			raw.Attributes["dNSHostName"] = []string{hostname}
+			if samaccountname == "" {
+				samaccountname = strings.ToUpper(strings.SplitN(hostname, ".", 2)[0]) + "$"
+			}
+			if sharpHoundBool(properties, "haslaps") {
+				// The expiration time isn't collected, only that there is one
+				raw.Attributes["ms-Mcs-AdmPwdExpirationTime"] = []string{"0"}
+			}
+		}
+		for property, flag := range map[string]int{
+			"unconstraineddelegation": UAC_TRUSTED_FOR_DELEGATION,
+			"trustedtoauth":           UAC_TRUSTED_TO_AUTH_FOR_DELEGATION,
+			"dontreqpreauth":          UAC_DONT_REQ_PREAUTH,
+			"passwordnotreqd":         UAC_PASSWD_NOTREQD,
+			"pwdneverexpires":         UAC_DONT_EXPIRE_PASSWORD,
+			"sensitive":               UAC_NOT_DELEGATED,
+		} {
+			if sharpHoundBool(properties, property) {
+				uac |= flag
+			}
+		}
+		if enabled, found := properties["enabled"].(bool); found && !enabled {
+			uac |= UAC_ACCOUNTDISABLE
+		}
+		raw.Attributes["userAccountControl"] = []string{strconv.Itoa(uac)}
+		if primarygroup, err := SIDFromString(object.PrimaryGroupSID); err == nil {
+			raw.Attributes["primaryGroupID"] = []string{strconv.FormatUint(uint64(primarygroup.RID()), 10)}
+		}
+	case "group":
+		grouptype := -2147483646 // Global security group
+		if strings.HasPrefix(sid, "S-1-5-32-") {
+			grouptype = -2147483643 // Builtin
+		}
+		raw.Attributes["groupType"] = []string{strconv.Itoa(grouptype)}
+	case "groupPolicyContainer":
+		raw.Attributes["displayName"] = []string{strings.SplitN(name, "@", 2)[0]}
+	}
+	if samaccountname == "" && (class == "user" || class == "group") {
+		samaccountname = strings.SplitN(name, "@", 2)[0]
+	}
+	if samaccountname != "" {
+		raw.Attributes["sAMAccountName"] = []string{samaccountname}
+	}
+
+	var links string
+	for _, link := range object.Links {
+		gpodn, found := dns[strings.ToUpper(link.GUID)]
+		if !found {
+			gpodn = "CN={" + strings.ToUpper(link.GUID) + "},CN=Policies,CN=System," + sharpHoundDomainDN(dn)
+		}
+		options := 0
+		if link.IsEnforced {
+			options = 2
+		}
+		links += "[LDAP://" + gpodn + ";" + strconv.Itoa(options) + "]"
+	}
+	if links != "" {
+		raw.Attributes["gPLink"] = []string{links}
+	}
+
+	if len(object.AllowedToAct) > 0 {
+		var sd SecurityDescriptor
+		for _, principal := range object.AllowedToAct {
+			if binarysid, err := SIDFromString(sharpHoundSID(principal.ObjectIdentifier)); err == nil {
+				sd.DACL.Entries = append(sd.DACL.Entries, ACE{Type: ACETYPE_ACCESS_ALLOWED, Mask: demoFullControl, SID: binarysid})
+			}
+		}
+		raw.Attributes["msDS-AllowedToActOnBehalfOfOtherIdentity"] = []string{string(sd.Bytes())}
+	}
+
+	sd, gmsareaders := sharpHoundSecurityDescriptor(object)
+	raw.Attributes["nTSecurityDescriptor"] = []string{string(sd.Bytes())}
+	if len(gmsareaders.DACL.Entries) > 0 {
+		raw.Attributes["msDS-GroupMSAMembership"] = []string{string(gmsareaders.Bytes())}
+	}
+	return raw
+}
+
+// Returns the security descriptor with the ACEs SharpHound found, and the one for who can read the password if
+// the object is a group managed service account
+func sharpHoundSecurityDescriptor(object sharpHoundObject) (sd, gmsareaders SecurityDescriptor) {
+	if object.IsACLProtected {
+		sd.Control |= CONTROLFLAG_DACL_PROTECTED
+	}
+	for _, ace := range object.Aces {
+		sid, err := SIDFromString(sharpHoundSID(ace.PrincipalSID))
+		if err != nil {
+			continue
+		}
+		switch ace.RightName {
+		case "Owns":
+			sd.Owner = sid
+			continue
+		case "ReadGMSAPassword":
+			gmsareaders.DACL.Entries = append(gmsareaders.DACL.Entries, ACE{Type: ACETYPE_ACCESS_ALLOWED, Mask: demoFullControl, SID: sid})
+			continue
+		}
+		entry, found := sharpHoundRights[ace.RightName]
+		if !found {
+			log.Debug().Msgf("No counterpart for %v from %v on %v", ace.RightName, ace.PrincipalSID, object.ObjectIdentifier)
+			continue
+		}
+		entry.SID = sid
+		if ace.IsInherited {
+			entry.ACEFlags |= ACEFLAG_INHERITED_ACE
+		}
+		sd.DACL.Entries = append(sd.DACL.Entries, entry)
+	}
+	return sd, gmsareaders
+}
+
+// Collected sessions and members of the local Administrators, Remote Desktop Users and Distributed COM Users groups of the computer
+func sharpHoundLocalMachine(object sharpHoundObject, collected time.Time) LocalMachine {
+	machine := LocalMachine{
+		Name:      strings.ToLower(sharpHoundString(object.Properties, "name")),
+		Collected: collected,
+	}
+	// NetSessionEnum sessions are from where the user is logged on, the other two are logons on the computer
+	for _, sessions := range []struct {
+		found     sharpHoundSessions
+		logontype string
+	}{
+		{object.Sessions, ""},
+		{object.PrivilegedSessions, "Interactive"},
+		{object.RegistrySessions, "Interactive"},
+	} {
+		for _, session := range sessions.found.Results {
+			machine.Sessions = append(machine.Sessions, LocalSession{User: sharpHoundSID(session.UserSID), LogonType: sessions.logontype})
+		}
+	}
+	for _, localgroup := range []struct {
+		members []sharpHoundPrincipal
+		rid     string
+		sids    *[]string
+	}{
+		{object.LocalAdmins.Results, "-544", &machine.LocalAdmins},
+		{object.RemoteDesktopUsers.Results, "-555", &machine.RDPUsers},
+		{object.DcomUsers.Results, "-562", &machine.DCOMUsers},
+	} {
+		members := localgroup.members
+		for _, group := range object.LocalGroups {
+			if strings.HasSuffix(group.ObjectIdentifier, localgroup.rid) {
+				members = append(members, group.Results...)
+			}
+		}
+		for _, member := range members {
+			if sid := sharpHoundSID(member.ObjectIdentifier); !StringInSlice(sid, *localgroup.sids) {
+				*localgroup.sids = append(*localgroup.sids, sid)
+			}
+		}
+	}
+	return machine
+}
+
+// Schema classes, attributes and rights the imported objects need, which a dump would have from the directory
+func sharpHoundSchema(base string) []*RawObject {
+	config := "CN=Configuration," + base
+	schema := "CN=Schema," + config
+	objects := []*RawObject{
+		sharpHoundObjectOfClass("container", config, base),
+		sharpHoundObjectOfClass("container", schema, base),
+		sharpHoundObjectOfClass("container", "CN=Extended-Rights,"+config, base),
+	}
+	for _, class := range demoClasses {
+		u, _ := uuid.FromString(class.guid)
+		o := sharpHoundObjectOfClass("classSchema", "CN="+class.cn+","+schema, base)
+		o.Attributes["lDAPDisplayName"] = []string{class.name}
+		o.Attributes["schemaIDGUID"] = []string{string(SwapUUIDEndianess(u).Bytes())}
+		o.Attributes["defaultObjectCategory"] = []string{"CN=" + sharpHoundCategory(class.name) + "," + schema}
+		objects = append(objects, o)
+	}
+	attributes := append(demoAttributes, struct{ name, cn, guid, syntax string }{"ms-Mcs-AdmPwd", "ms-Mcs-AdmPwd", sharpHoundLAPSGUID.String(), "2.5.5.5"})
+	for _, attribute := range attributes {
+		u, _ := uuid.FromString(attribute.guid)
+		o := sharpHoundObjectOfClass("attributeSchema", "CN="+attribute.cn+","+schema, base)
+		o.Attributes["lDAPDisplayName"] = []string{attribute.name}
+		o.Attributes["schemaIDGUID"] = []string{string(SwapUUIDEndianess(u).Bytes())}
+		o.Attributes["attributeSyntax"] = []string{attribute.syntax}
+		objects = append(objects, o)
+	}
+	// The analysis looks for Authenticated Users here
+	objects = append(objects, sharpHoundObjectOfClass("container", "CN=WellKnown Security Principals,"+config, base))
+	for sid, name := range map[string]string{
+		"S-1-1-0":  "Everyone",
+		"S-1-5-9":  "Enterprise Domain Controllers",
+		"S-1-5-10": "Self",
+		"S-1-5-11": "Authenticated Users",
+		"S-1-5-18": "Local System",
+	} {
+		binarysid, _ := SIDFromString(sid)
+		o := sharpHoundObjectOfClass("foreignSecurityPrincipal", "CN="+name+",CN=WellKnown Security Principals,"+config, base)
+		o.Attributes["objectSid"] = []string{string(binarysid)}
+		objects = append(objects, o)
+	}
+	for _, right := range demoRights {
+		o := sharpHoundObjectOfClass("controlAccessRight", "CN="+right.cn+",CN=Extended-Rights,"+config, base)
+		o.Attributes["displayName"] = []string{right.name}
+		o.Attributes["rightsGuid"] = []string{right.guid}
+		objects = append(objects, o)
+	}
+	return objects
+}
+
+// An object with the class and the superclasses of it, and the object category of the class
+func sharpHoundObjectOfClass(class, dn, base string) *RawObject {
+	var classes []string
+	for c := class; c != ""; {
+		superclass := ""
+		for _, dc := range demoClasses {
+			if dc.name == c {
+				superclass = dc.superclass
+				break
+			}
+		}
+		classes = append([]string{c}, classes...)
+		c = superclass
+	}
+	rdn := dn[strings.Index(dn, "=")+1:]
+	if comma := strings.Index(rdn, ","); comma != -1 {
+		rdn = rdn[:comma]
+	}
+	var raw RawObject
+	raw.init()
+	raw.DistinguishedName = dn
+	raw.Attributes["distinguishedName"] = []string{dn}
+	raw.Attributes["name"] = []string{rdn}
+	raw.Attributes["objectClass"] = classes
+	raw.Attributes["objectCategory"] = []string{"CN=" + sharpHoundCategory(class) + ",CN=Schema,CN=Configuration," + base}
+	return &raw
+}
+
+// The cn of the default object category of the class
+func sharpHoundCategory(class string) string {
+	for _, dc := range demoClasses {
+		if dc.name == class {
+			if dc.category != "" {
+				return dc.category
+			}
+			return dc.cn
+		}
+	}
+	return class
+}
+
+// SharpHound puts the domain in front of well known SIDs, like CONTOSO.LOCAL-S-1-5-32-544
+func sharpHoundSID(identifier string) string {
+	if start := strings.Index(identifier, "-S-1-"); start != -1 {
+		return identifier[start+1:]
+	}
+	return identifier
+}
+
+// The DC= part of a distinguished name
+func sharpHoundDomainDN(dn string) string {
+	if start := strings.Index(strings.ToUpper(dn), "DC="); start != -1 {
+		return dn[start:]
+	}
+	return dn
+}
+
+func sharpHoundString(properties map[string]interface{}, name string) string {
+	value, _ := properties[name].(string)
+	return value
+}
+
+func sharpHoundStrings(properties map[string]interface{}, name string) []string {
+	values, _ := properties[name].([]interface{})
+	var result []string
+	for _, value := range values {
+		if s, ok := value.(string); ok && s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func sharpHoundBool(properties map[string]interface{}, name string) bool {
+	value, _ := properties[name].(bool)
+	return value
+}
+
+func sharpHoundInt(properties map[string]interface{}, name string) (int64, bool) {
+	value, found := properties[name].(float64)
+	return int64(value), found
+}
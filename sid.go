@@ -21,11 +21,17 @@ func ParseSID(data []byte) (SID, []byte, error) {
 	if data[0] != 0x01 {
 		return "", data, errors.New("SID revision must be 1")
 	}
+	if len(data) < 8 {
+		return "", data, errors.New("SID is shorter than its header")
+	}
 	subauthoritycount := int(data[1])
 	var sid = make([]byte, 8+4*subauthoritycount)
 	if subauthoritycount > 15 {
 		return "", data, errors.New("SID subauthority count is more than 15")
 	}
+	if len(data) < len(sid) {
+		return "", data, errors.New("SID is shorter than its subauthority count says")
+	}
 	copy(sid, data[0:len(sid)])
 	return SID(sid), data[8+subauthoritycount*4:], nil
 }
@@ -109,3 +115,22 @@ func (sid SID) RID() uint32 {
 	l := len(sid) - 4
 	return binary.LittleEndian.Uint32([]byte(sid[l:]))
 }
+
+// Returns the SID without the last subauthority, for accounts this is the domain SID
+func (sid SID) StripRID() SID {
+	if len(sid) <= 8 {
+		return sid
+	}
+	newsid := []byte(sid[:len(sid)-4])
+	newsid[1]--
+	return SID(newsid)
+}
+
+// Returns the SID with a subauthority added, for a domain SID this gives the account with that RID
+func (sid SID) AddSubAuthority(subauthority uint32) SID {
+	newsid := make([]byte, len(sid)+4)
+	copy(newsid, sid)
+	newsid[1]++
+	binary.LittleEndian.PutUint32(newsid[len(sid):], subauthority)
+	return SID(newsid)
+}
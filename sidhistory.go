@@ -0,0 +1,65 @@
+package main
+
+// An account gets the SIDs in its sIDHistory in its logon token, so it has everything granted to them and is in the
+// groups they are in. Migrations leave them behind, and an attacker adds the SID of an admin group (mimikatz
+// sid::add, or a golden ticket with extra SIDs) to come back later. Where the object with the SID is loaded - in the
+// same domain, or another domain loaded at the same time - the account gets a SIDHistoryEquality link to it. Where
+// it isn't, ACEs and memberships for the SID point to the account itself (Objects.Add)
+
+// The SIDs in sIDHistory that parse
+func (o *Object) SIDHistory() []SID {
+	var results []SID
+	for _, value := range o.Attr(SIDHistory) {
+		if sid, _, err := ParseSID([]byte(value)); err == nil {
+			results = append(results, sid)
+		}
+	}
+	return results
+}
+
+// SID history from another domain is stripped when crossing a trust with SID filtering, so it doesn't work there
+func sidHistoryFiltered(o *Object, historysid SID) *Object {
+	trust, found := domainTrusts[[2]SID{historysid.StripRID(), o.SID().StripRID()}]
+	if found && trust.OneAttr(MetaSIDFiltering) == "1" {
+		return trust
+	}
+	return nil
+}
+
+// SID -> the accounts having it in sIDHistory
+var sidHistoryHolders map[SID][]*Object
+
+func buildSIDHistory() {
+	sidHistoryHolders = make(map[SID][]*Object)
+	for _, o := range AllObjects.AsArray() {
+		for _, sid := range o.SIDHistory() {
+			if sid != o.SID() && sidHistoryFiltered(o, sid) == nil {
+				sidHistoryHolders[sid] = append(sidHistoryHolders[sid], o)
+			}
+		}
+	}
+}
+
+// The accounts that have the SID of o in their SID history
+func sidHistoryAccounts(o *Object) []*Object {
+	sid := o.SID()
+	if sid.IsNull() {
+		return nil
+	}
+	var results []*Object
+	for _, account := range sidHistoryHolders[sid] {
+		if account == o {
+			continue
+		}
+		reason := "Has the SID of " + o.Label() + " in sIDHistory"
+		if sid.StripRID() == account.SID().StripRID() {
+			// Migrations are between domains, so this was put there
+			reason += ", from its own domain"
+		} else if domain, found := AllObjects.FindSID(sid.StripRID()); found {
+			reason += ", from the domain " + domain.Label()
+		}
+		SetEdgeReason(account, o, PwnSIDHistoryEquality, reason)
+		results = append(results, account)
+	}
+	return results
+}
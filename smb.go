@@ -0,0 +1,479 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Just enough SMB2 (MS-SMB2) to read files from a share: dialects 2.0.2 and 2.1 with NTLM in SPNEGO, signing
+// everything after the session is set up, as domain controllers require that for SYSVOL, and checking the signatures
+// on what comes back so the files can't be changed on the way
+
+const (
+	smb2Negotiate      = 0x00
+	smb2SessionSetup   = 0x01
+	smb2Logoff         = 0x02
+	smb2TreeConnect    = 0x03
+	smb2Create         = 0x05
+	smb2Close          = 0x06
+	smb2Read           = 0x08
+	smb2QueryDirectory = 0x0e
+)
+
+const (
+	smb2FlagsServerToRedir = 0x00000001
+	smb2FlagsAsyncCommand  = 0x00000002
+	smb2FlagsSigned        = 0x00000008
+)
+
+const (
+	smbStatusSuccess               = 0x00000000
+	smbStatusPending               = 0x00000103
+	smbStatusNoMoreFiles           = 0x80000006
+	smbStatusEndOfFile             = 0xc0000011
+	smbStatusMoreProcessing        = 0xc0000016
+	smbStatusAccessDenied          = 0xc0000022
+	smbStatusObjectNameNotFound    = 0xc0000034
+	smbStatusObjectPathNotFound    = 0xc000003a
+	smbStatusLogonFailure          = 0xc000006d
+	smbStatusBadNetworkName        = 0xc00000cc
+	smbStatusAccountRestriction    = 0xc000006e
+	smbStatusPasswordExpired       = 0xc0000071
+	smbStatusAccountDisabled       = 0xc0000072
+	smbStatusNotSupported          = 0xc00000bb
+	smbStatusInsufficientResources = 0xc000009a
+)
+
+var smbStatusText = map[uint32]string{
+	smbStatusAccessDenied:          "access denied",
+	smbStatusObjectNameNotFound:    "not found",
+	smbStatusObjectPathNotFound:    "path not found",
+	smbStatusLogonFailure:          "logon failure, wrong username or password",
+	smbStatusBadNetworkName:        "no such share",
+	smbStatusAccountRestriction:    "account restriction",
+	smbStatusPasswordExpired:       "password expired",
+	smbStatusAccountDisabled:       "account disabled",
+	smbStatusNotSupported:          "not supported",
+	smbStatusInsufficientResources: "insufficient resources",
+}
+
+type smbError struct {
+	command uint16
+	status  uint32
+}
+
+func (e smbError) Error() string {
+	if text, found := smbStatusText[e.status]; found {
+		return fmt.Sprintf("SMB command %v failed: %v", e.command, text)
+	}
+	return fmt.Sprintf("SMB command %v failed with status 0x%08x", e.command, e.status)
+}
+
+const (
+	smbFileAttributeDirectory = 0x10
+
+	smbReadAccess   = 0x00120089 // FILE_READ_DATA, FILE_READ_EA, FILE_READ_ATTRIBUTES, READ_CONTROL and SYNCHRONIZE
+	smbShareAll     = 0x00000007
+	smbFileOpen     = 0x00000001
+	smbMaxRead      = 65536 // Larger reads need multi credit requests
+	smbCreditsAsked = 64
+)
+
+// SPNEGO (RFC 4178) and NTLMSSP object identifiers
+var (
+	spnegoOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	ntlmOID   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+)
+
+type spnegoNegTokenInit struct {
+	MechTypes []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	MechToken []byte                  `asn1:"explicit,optional,tag:2"`
+}
+
+type spnegoNegTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// Wraps the NTLM negotiate message in a GSS-API initial context token offering NTLM only
+func spnegoInit(token []byte) ([]byte, error) {
+	oid, err := asn1.Marshal(spnegoOID)
+	if err != nil {
+		return nil, err
+	}
+	init, err := asn1.MarshalWithParams(spnegoNegTokenInit{MechTypes: []asn1.ObjectIdentifier{ntlmOID}, MechToken: token}, "explicit,tag:0")
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: append(oid, init...)})
+}
+
+func spnegoResponse(token []byte) ([]byte, error) {
+	return asn1.MarshalWithParams(spnegoNegTokenResp{ResponseToken: token}, "explicit,tag:1")
+}
+
+func spnegoParseResponse(data []byte) (spnegoNegTokenResp, error) {
+	var response spnegoNegTokenResp
+	_, err := asn1.UnmarshalWithParams(data, &response, "explicit,tag:1")
+	return response, err
+}
+
+type smbClient struct {
+	conn       net.Conn
+	server     string
+	dialect    uint16
+	messageid  uint64
+	sessionid  uint64
+	treeid     uint32
+	signingkey []byte
+}
+
+type smbFileInfo struct {
+	Name  string
+	Size  int64
+	IsDir bool
+}
+
+// Connects to the server on port 445 and authenticates with NTLM
+func dialSMB(server string, c *ntlmClient) (*smbClient, error) {
+	conn, err := dialDirectoryTimeout(net.JoinHostPort(server, "445"), 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	s := &smbClient{conn: conn, server: server}
+	if err = s.negotiate(); err == nil {
+		err = s.authenticate(c)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sends a request and returns the response body after the header, waiting out interim responses
+func (s *smbClient) call(command uint16, body []byte) ([]byte, uint32, error) {
+	header := make([]byte, 64)
+	copy(header, "\xfeSMB")
+	binary.LittleEndian.PutUint16(header[4:], 64)
+	if s.dialect != 0x0202 {
+		binary.LittleEndian.PutUint16(header[6:], 1) // Credit charge
+	}
+	binary.LittleEndian.PutUint16(header[12:], command)
+	binary.LittleEndian.PutUint16(header[14:], smbCreditsAsked)
+	binary.LittleEndian.PutUint64(header[24:], s.messageid)
+	binary.LittleEndian.PutUint32(header[36:], s.treeid)
+	binary.LittleEndian.PutUint64(header[40:], s.sessionid)
+	message := append(header, body...)
+	if s.signingkey != nil {
+		binary.LittleEndian.PutUint32(message[16:], smb2FlagsSigned)
+		copy(message[48:], s.signature(message))
+	}
+	messageid := s.messageid
+	s.messageid++
+
+	frame := make([]byte, 4, 4+len(message))
+	binary.BigEndian.PutUint32(frame, uint32(len(message)))
+	if _, err := s.conn.Write(append(frame, message...)); err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		if _, err := io.ReadFull(s.conn, frame[:4]); err != nil {
+			return nil, 0, err
+		}
+		length := binary.BigEndian.Uint32(frame[:4]) & 0x00ffffff
+		if length < 64 {
+			return nil, 0, errors.New("SMB response is too short")
+		}
+		response := make([]byte, length)
+		if _, err := io.ReadFull(s.conn, response); err != nil {
+			return nil, 0, err
+		}
+		if string(response[:4]) != "\xfeSMB" {
+			return nil, 0, errors.New("Not an SMB2 response")
+		}
+		status := binary.LittleEndian.Uint32(response[8:])
+		flags := binary.LittleEndian.Uint32(response[16:])
+		if binary.LittleEndian.Uint64(response[24:]) != messageid {
+			continue // Something we're not waiting for, like an oplock break
+		}
+		if status == smbStatusPending && flags&smb2FlagsAsyncCommand != 0 {
+			continue
+		}
+		// The key is only known after the last session setup response, everything after that must be signed with it
+		if s.signingkey != nil {
+			if flags&smb2FlagsSigned == 0 {
+				return nil, 0, errors.New("SMB response isn't signed")
+			}
+			if !hmac.Equal(response[48:64], s.signature(response)) {
+				return nil, 0, errors.New("SMB response has the wrong signature")
+			}
+		}
+		if command == smb2SessionSetup {
+			s.sessionid = binary.LittleEndian.Uint64(response[40:])
+		}
+		if command == smb2TreeConnect && status == smbStatusSuccess {
+			s.treeid = binary.LittleEndian.Uint32(response[36:])
+		}
+		return response, status, nil
+	}
+}
+
+// HMAC-SHA256 of the message with the signature field zeroed, cut to the 16 bytes that go in that field
+func (s *smbClient) signature(message []byte) []byte {
+	mac := hmac.New(sha256.New, s.signingkey)
+	mac.Write(message[:48])
+	mac.Write(make([]byte, 16))
+	mac.Write(message[64:])
+	return mac.Sum(nil)[:16]
+}
+
+func (s *smbClient) negotiate() error {
+	body := make([]byte, 36, 40)
+	binary.LittleEndian.PutUint16(body[0:], 36)
+	binary.LittleEndian.PutUint16(body[2:], 2) // Dialects
+	binary.LittleEndian.PutUint16(body[4:], 1) // Signing enabled
+	if _, err := rand.Read(body[12:28]); err != nil {
+		return err
+	}
+	body = append(body, 0x02, 0x02, 0x10, 0x02) // 2.0.2 and 2.1
+	response, status, err := s.call(smb2Negotiate, body)
+	if err != nil {
+		return err
+	}
+	if status != smbStatusSuccess {
+		return smbError{smb2Negotiate, status}
+	}
+	if len(response) < 64+64 {
+		return errors.New("SMB negotiate response is too short")
+	}
+	s.dialect = binary.LittleEndian.Uint16(response[64+4:])
+	if s.dialect != 0x0202 && s.dialect != 0x0210 {
+		return fmt.Errorf("Server picked SMB dialect 0x%04x, which isn't supported", s.dialect)
+	}
+	return nil
+}
+
+func (s *smbClient) sessionSetup(token []byte) ([]byte, uint32, error) {
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body[0:], 25)
+	body[3] = 1 // Signing enabled
+	binary.LittleEndian.PutUint16(body[12:], 64+24)
+	binary.LittleEndian.PutUint16(body[14:], uint16(len(token)))
+	response, status, err := s.call(smb2SessionSetup, append(body, token...))
+	if err != nil {
+		return nil, 0, err
+	}
+	if status != smbStatusSuccess && status != smbStatusMoreProcessing {
+		return nil, status, smbError{smb2SessionSetup, status}
+	}
+	if len(response) < 64+8 {
+		return nil, status, errors.New("SMB session setup response is too short")
+	}
+	offset := int(binary.LittleEndian.Uint16(response[64+4:]))
+	length := int(binary.LittleEndian.Uint16(response[64+6:]))
+	if offset+length > len(response) {
+		return nil, status, errors.New("SMB session setup response is truncated")
+	}
+	return response[offset : offset+length], status, nil
+}
+
+func (s *smbClient) authenticate(c *ntlmClient) error {
+	c.spn = "cifs/" + s.server
+	token, err := spnegoInit(c.Negotiate())
+	if err != nil {
+		return err
+	}
+	reply, status, err := s.sessionSetup(token)
+	if err != nil {
+		return err
+	}
+	if status != smbStatusMoreProcessing {
+		return errors.New("Server didn't send an NTLM challenge")
+	}
+	challenge, err := spnegoParseResponse(reply)
+	if err != nil {
+		return fmt.Errorf("Problem parsing SPNEGO challenge: %v", err)
+	}
+	authenticate, err := c.Authenticate(challenge.ResponseToken)
+	if err != nil {
+		return err
+	}
+	if token, err = spnegoResponse(authenticate); err != nil {
+		return err
+	}
+	if _, _, err = s.sessionSetup(token); err != nil {
+		return err
+	}
+	s.signingkey = c.sessionkey
+	return nil
+}
+
+// Connects to the share, which is given without the server (like SYSVOL)
+func (s *smbClient) TreeConnect(share string) error {
+	path := ntlmUnicode(`\\` + s.server + `\` + share)
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:], 9)
+	binary.LittleEndian.PutUint16(body[4:], 64+8)
+	binary.LittleEndian.PutUint16(body[6:], uint16(len(path)))
+	_, status, err := s.call(smb2TreeConnect, append(body, path...))
+	if err != nil {
+		return err
+	}
+	if status != smbStatusSuccess {
+		return smbError{smb2TreeConnect, status}
+	}
+	return nil
+}
+
+// Opens a file or directory for reading, and returns the file ID
+func (s *smbClient) open(path string) ([]byte, error) {
+	name := ntlmUnicode(strings.TrimPrefix(strings.ReplaceAll(path, "/", `\`), `\`))
+	body := make([]byte, 56)
+	binary.LittleEndian.PutUint16(body[0:], 57)
+	binary.LittleEndian.PutUint32(body[4:], 2) // Impersonation
+	binary.LittleEndian.PutUint32(body[24:], smbReadAccess)
+	binary.LittleEndian.PutUint32(body[32:], smbShareAll)
+	binary.LittleEndian.PutUint32(body[36:], smbFileOpen)
+	binary.LittleEndian.PutUint16(body[44:], 64+56)
+	binary.LittleEndian.PutUint16(body[46:], uint16(len(name)))
+	body = append(body, name...)
+	if len(name) == 0 {
+		body = append(body, 0) // The buffer can't be empty
+	}
+	response, status, err := s.call(smb2Create, body)
+	if err != nil {
+		return nil, err
+	}
+	if status != smbStatusSuccess {
+		return nil, smbError{smb2Create, status}
+	}
+	if len(response) < 64+80 {
+		return nil, errors.New("SMB create response is too short")
+	}
+	return append([]byte(nil), response[64+64:64+80]...), nil
+}
+
+func (s *smbClient) close(fileid []byte) {
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body[0:], 24)
+	copy(body[8:], fileid)
+	s.call(smb2Close, body)
+}
+
+// Returns the files and folders in a folder on the share
+func (s *smbClient) ReadDir(path string) ([]smbFileInfo, error) {
+	fileid, err := s.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close(fileid)
+
+	pattern := ntlmUnicode("*")
+	var results []smbFileInfo
+	for first := true; ; first = false {
+		body := make([]byte, 32)
+		binary.LittleEndian.PutUint16(body[0:], 33)
+		body[2] = 0x01 // FileDirectoryInformation
+		if first {
+			body[3] = 0x01 // Restart scans
+		}
+		copy(body[8:], fileid)
+		binary.LittleEndian.PutUint16(body[24:], 64+32)
+		binary.LittleEndian.PutUint16(body[26:], uint16(len(pattern)))
+		binary.LittleEndian.PutUint32(body[28:], smbMaxRead)
+		response, status, err := s.call(smb2QueryDirectory, append(body, pattern...))
+		if err != nil {
+			return nil, err
+		}
+		if status == smbStatusNoMoreFiles {
+			return results, nil
+		}
+		if status != smbStatusSuccess {
+			return nil, smbError{smb2QueryDirectory, status}
+		}
+		offset := int(binary.LittleEndian.Uint16(response[64+2:]))
+		length := int(binary.LittleEndian.Uint32(response[64+4:]))
+		if offset+length > len(response) {
+			return nil, errors.New("SMB directory listing is truncated")
+		}
+		entries := response[offset : offset+length]
+		for len(entries) >= 64 {
+			namelength := int(binary.LittleEndian.Uint32(entries[60:]))
+			if 64+namelength > len(entries) {
+				break
+			}
+			name := ntlmString(entries[64 : 64+namelength])
+			if name != "." && name != ".." {
+				results = append(results, smbFileInfo{
+					Name:  name,
+					Size:  int64(binary.LittleEndian.Uint64(entries[40:])),
+					IsDir: binary.LittleEndian.Uint32(entries[56:])&smbFileAttributeDirectory != 0,
+				})
+			}
+			next := binary.LittleEndian.Uint32(entries[0:])
+			if next == 0 || int(next) > len(entries) {
+				break
+			}
+			entries = entries[next:]
+		}
+	}
+}
+
+// Returns the contents of a file on the share
+func (s *smbClient) ReadFile(path string) ([]byte, error) {
+	fileid, err := s.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close(fileid)
+
+	var data []byte
+	for {
+		body := make([]byte, 49)
+		binary.LittleEndian.PutUint16(body[0:], 49)
+		body[2] = 0x50 // Where the data goes in the response
+		binary.LittleEndian.PutUint32(body[4:], smbMaxRead)
+		binary.LittleEndian.PutUint64(body[8:], uint64(len(data)))
+		copy(body[16:], fileid)
+		response, status, err := s.call(smb2Read, body)
+		if err != nil {
+			return nil, err
+		}
+		if status == smbStatusEndOfFile {
+			return data, nil
+		}
+		if status != smbStatusSuccess {
+			return nil, smbError{smb2Read, status}
+		}
+		offset := int(response[64+2])
+		length := int(binary.LittleEndian.Uint32(response[64+4:]))
+		if offset+length > len(response) {
+			return nil, errors.New("SMB read response is truncated")
+		}
+		if length == 0 {
+			return data, nil
+		}
+		data = append(data, response[offset:offset+length]...)
+	}
+}
+
+func (s *smbClient) Close() error {
+	body := make([]byte, 4)
+	binary.LittleEndian.PutUint16(body[0:], 4)
+	s.call(smb2Logoff, body)
+	return s.conn.Close()
+}
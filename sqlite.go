@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
+	_ "modernc.org/sqlite"
+)
+
+// Dumps as SQLite databases, for looking at them with SQL and for loading only part of a large one. The objects and
+// attributes are the ones from the dump, the edges are what the analysis found. Values that aren't text (security
+// descriptors, SIDs, GUIDs) are blobs. The driver is SQLite translated to Go, so this works without cgo and in the
+// cross compiled release binaries
+
+// Condition on the objects table choosing which objects to load from SQLite dumps, blank loads them all
+var SQLiteFilter string
+
+const sqliteSchema = `
+CREATE TABLE objects (id INTEGER PRIMARY KEY, dn TEXT NOT NULL);
+CREATE TABLE attributes (object INTEGER NOT NULL REFERENCES objects(id), name TEXT NOT NULL, value);
+CREATE TABLE edges (source TEXT NOT NULL, target TEXT NOT NULL, method TEXT NOT NULL);
+`
+
+// Created after the data is in, as that is faster than keeping them updated
+const sqliteIndexes = `
+CREATE INDEX objects_dn ON objects(dn);
+CREATE INDEX attributes_object ON attributes(object);
+CREATE INDEX attributes_name_value ON attributes(name, value);
+CREATE INDEX edges_source ON edges(source);
+CREATE INDEX edges_target ON edges(target);
+`
+
+// Writes the objects in the dump file and the edges from analyzing the domain to a new SQLite database
+func ConvertDumpToSQLite(domain, datapath, dumpfile, sqlitefile string) error {
+	if err := os.Remove(sqlitefile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db, err := sql.Open("sqlite", sqlitefile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err = db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("Problem creating tables: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	insertobject, err := tx.Prepare("INSERT INTO objects (id, dn) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	insertattribute, err := tx.Prepare("INSERT INTO attributes (object, name, value) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	var id int64
+	var inserterr error
+	err = readDumpFile(dumpfile, func(o *RawObject) {
+		if inserterr != nil {
+			return
+		}
+		id++
+		if _, inserterr = insertobject.Exec(id, o.DistinguishedName); inserterr != nil {
+			return
+		}
+		for name, values := range o.Attributes {
+			for _, value := range values {
+				var v interface{} = value
+				if !utf8.ValidString(value) {
+					v = []byte(value)
+				}
+				if _, inserterr = insertattribute.Exec(id, name, v); inserterr != nil {
+					return
+				}
+			}
+		}
+	})
+	if err == nil {
+		err = inserterr
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Info().Msgf("Wrote %v objects to %v", id, sqlitefile)
+
+	if err = LoadDataset(domain, datapath, false); err != nil {
+		return err
+	}
+	if tx, err = db.Begin(); err != nil {
+		return err
+	}
+	insertedge, err := tx.Prepare("INSERT INTO edges (source, target, method) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	var edges int
+	for _, object := range AllObjects.AsArray() {
+		for _, pwn := range object.CanPwnSnapshot() {
+			for _, method := range pwn.Method.StringSlice() {
+				if _, err = insertedge.Exec(object.DN(), pwn.Target.DN(), method); err != nil {
+					tx.Rollback()
+					return err
+				}
+				edges++
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Info().Msgf("Wrote %v edges to %v", edges, sqlitefile)
+
+	if _, err = db.Exec(sqliteIndexes); err != nil {
+		return fmt.Errorf("Problem creating indexes: %v", err)
+	}
+	return nil
+}
+
+// Loads the objects from a SQLite dump, the ones SQLiteFilter picks if it is set
+func loadSQLiteDump(filename string, importall bool) error {
+	db, err := sql.Open("sqlite", "file:"+filename+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	query := "SELECT objects.id, objects.dn, attributes.name, attributes.value FROM objects LEFT JOIN attributes ON attributes.object = objects.id"
+	if SQLiteFilter != "" {
+		query += " WHERE objects.id IN (SELECT id FROM objects WHERE " + SQLiteFilter + ")"
+	}
+	rows, err := db.Query(query + " ORDER BY objects.id")
+	if err != nil {
+		return fmt.Errorf("Problem querying %v: %v", filename, err)
+	}
+	defer rows.Close()
+
+	var count int
+	var object *RawObject
+	var lastid int64
+	add := func() {
+		if object == nil {
+			return
+		}
+		raw := object
+		guardObject("convert", raw.DistinguishedName, raw, func() {
+			AllObjects.Add(raw.ToObject(importall))
+		})
+		count++
+	}
+	for rows.Next() {
+		var id int64
+		var dn string
+		var name sql.NullString
+		var value []byte
+		if err = rows.Scan(&id, &dn, &name, &value); err != nil {
+			return err
+		}
+		if object == nil || id != lastid {
+			add()
+			object = &RawObject{}
+			object.init()
+			object.DistinguishedName = dn
+			lastid = id
+		}
+		if name.Valid {
+			object.Attributes[name.String] = append(object.Attributes[name.String], string(value))
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	add()
+	log.Info().Msgf("Loaded %v objects from %v", count, filename)
+	return nil
+}
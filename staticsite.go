@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// A pre-rendered query result in a static site export
+type StaticGraph struct {
+	Title    string       `json:"title"`
+	Query    string       `json:"query"`
+	Mode     string       `json:"mode"`
+	Elements CytoElements `json:"elements"`
+}
+
+// Files from the web UI that the static viewer needs
+var staticSiteAssets = []string{
+	"cytoscape.min.js",
+	"icons/attacker.svg",
+	"icons/gpo.svg",
+	"icons/people-fill.svg",
+	"icons/person-fill.svg",
+	"icons/person-x-fill.svg",
+	"icons/server.svg",
+	"icons/tv-fill.svg",
+}
+
+// Reads queries for a static export from a file, one per line as "title<TAB>query" or just "query". Empty lines
+// and lines starting with # are ignored
+func LoadStaticQueries(filename string) ([][2]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var queries [][2]string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if tab := strings.Index(line, "\t"); tab != -1 {
+			queries = append(queries, [2]string{strings.TrimSpace(line[:tab]), strings.TrimSpace(line[tab+1:])})
+		} else {
+			queries = append(queries, [2]string{line, line})
+		}
+	}
+	return queries, nil
+}
+
+// Analyzes each query and writes a self contained folder with the results and a viewer, that can be opened
+// from the filesystem in any browser without running adalanche
+func ExportStaticSite(queries [][2]string, mode string, methods PwnMethod, folder string) error {
+	var graphs []StaticGraph
+	for _, query := range queries {
+		includeobjects, excludeobjects, err := queryObjects(query[1])
+		if err != nil {
+			return fmt.Errorf("Problem with query %v: %v", query[1], err)
+		}
+		pg := AnalyzeObjects(includeobjects, excludeobjects, methods, mode, 99)
+		cytograph, err := GenerateCytoscapeJS(pg, false)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Query %v implicates %v objects with %v connections", query[0], len(pg.Implicated), len(pg.Connections))
+		graphs = append(graphs, StaticGraph{
+			Title:    query[0],
+			Query:    query[1],
+			Mode:     mode,
+			Elements: cytograph.Elements,
+		})
+	}
+
+	if err := os.MkdirAll(filepath.Join(folder, "icons"), 0755); err != nil {
+		return err
+	}
+
+	// Graph data as a script, as browsers won't let pages loaded from file:// fetch JSON
+	data, err := qjson.Marshal(graphs)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("// Generated by adalanche %v\nvar graphs = %s;\n", time.Now().Format(time.RFC3339), data)
+	if err = os.WriteFile(filepath.Join(folder, "graphs.js"), []byte(script), 0644); err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(folder, "index.html"), []byte(staticSiteViewer), 0644); err != nil {
+		return err
+	}
+
+	assets := webAssets()
+	for _, name := range staticSiteAssets {
+		source, err := assets.Open("/" + name)
+		if err != nil {
+			return fmt.Errorf("Problem reading %v from web assets: %v", name, err)
+		}
+		destination, err := os.Create(filepath.Join(folder, filepath.FromSlash(name)))
+		if err != nil {
+			source.Close()
+			return err
+		}
+		_, err = io.Copy(destination, source)
+		source.Close()
+		destination.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const staticSiteViewer = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  <title>adalanche results</title>
+  <script src="cytoscape.min.js"></script>
+  <script src="graphs.js"></script>
+  <style>
+    body { margin: 0; background: #222; color: white; font-family: sans-serif; font-size: 13px; }
+    #top { position: absolute; top: 0; left: 0; right: 0; padding: 8px; background: #333; }
+    #cy { position: absolute; top: 60px; bottom: 0; left: 0; right: 0; }
+    #details { position: absolute; top: 70px; right: 10px; width: 420px; max-height: 80%; overflow: auto;
+      background: rgba(50, 50, 50, 0.9); padding: 8px; display: none; word-break: break-all; }
+    #details td { vertical-align: top; padding-right: 6px; }
+    #query { color: #aaa; margin-top: 4px; }
+  </style>
+</head>
+<body>
+  <div id="top">
+    <select id="graph"></select> <span id="summary"></span>
+    <div id="query"></div>
+  </div>
+  <div id="cy"></div>
+  <div id="details"></div>
+  <script>
+    function escape(s) {
+      return String(s).replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;");
+    }
+
+    function showdetails(data) {
+      var html = "<table>";
+      Object.keys(data).sort().forEach(function (key) {
+        if (key == "id" || key == "source" || key == "target") {
+          return;
+        }
+        html += "<tr><td>" + escape(key) + "</td><td>" + escape(data[key]) + "</td></tr>";
+      });
+      document.getElementById("details").innerHTML = html + "</table>";
+      document.getElementById("details").style.display = "block";
+    }
+
+    var style = [
+      { selector: "node", style: { content: "data(label)", color: "white", "background-width": "80%", "background-height": "80%" } },
+      { selector: 'node[name="Attacker"]', style: { "background-image": "icons/attacker.svg", "background-color": "purple" } },
+      { selector: 'node[_type="Group"]', style: { shape: "cut-rectangle", "background-image": "icons/people-fill.svg", "background-color": "yellow" } },
+      { selector: 'node[_type="User"][!_accountdisabled]', style: { shape: "rectangle", "background-image": "icons/person-fill.svg", "background-color": "green" } },
+      { selector: 'node[_type="User"][?_accountdisabled]', style: { shape: "rectangle", "background-image": "icons/person-x-fill.svg", "background-color": "lightblue" } },
+      { selector: 'node[_type="GroupPolicyContainer"]', style: { shape: "rectangle", "background-image": "icons/gpo.svg", "background-color": "lightpurple" } },
+      { selector: 'node[_type="Computer"][?_workstation]', style: { shape: "hexagon", "background-image": "icons/tv-fill.svg", "background-color": "lightgreen" } },
+      { selector: 'node[_type="Computer"][?_server]', style: { shape: "hexagon", "background-image": "icons/server.svg", "background-color": "lightgreen" } },
+      { selector: "node[?_querytarget]", style: { "background-color": "red" } },
+      { selector: "edge", style: { "curve-style": "bezier", "target-arrow-shape": "triangle" } },
+      { selector: "edge[confidence]", style: { opacity: "mapData(confidence, 0, 1, 0.3, 1)", "line-style": "dashed" } },
+      { selector: "edge[?pwn_aclcontainsdeny]", style: { "line-style": "dotted" } },
+      { selector: "edge[?pwn_memberofgroup]", style: { "target-arrow-color": "orange", "line-color": "orange" } },
+      { selector: "edge[?pwn_resetpassword]", style: { "target-arrow-color": "red", "line-color": "red" } },
+      { selector: "edge[?pwn_takeownership]", style: { "target-arrow-color": "lightgreen", "line-color": "lightgreen" } },
+      { selector: "edge[?pwn_owns]", style: { "target-arrow-color": "green", "line-color": "green" } },
+      { selector: ":selected", style: { "border-color": "white", "border-width": 6, "line-color": "white", "target-arrow-color": "white" } }
+    ];
+
+    var cy;
+    function show(index) {
+      var graph = graphs[index];
+      document.getElementById("summary").textContent = graph.elements.nodes.length + " objects, " + graph.elements.edges.length + " connections";
+      document.getElementById("query").textContent = graph.mode + ": " + graph.query;
+      document.getElementById("details").style.display = "none";
+      if (cy) {
+        cy.destroy();
+      }
+      cy = cytoscape({
+        container: document.getElementById("cy"),
+        elements: graph.elements,
+        style: style,
+        layout: { name: "cose", animate: false }
+      });
+      cy.on("tap", "node, edge", function (evt) {
+        showdetails(evt.target.data());
+      });
+      cy.on("tap", function (evt) {
+        if (evt.target === cy) {
+          document.getElementById("details").style.display = "none";
+        }
+      });
+    }
+
+    var select = document.getElementById("graph");
+    graphs.forEach(function (graph, index) {
+      var option = document.createElement("option");
+      option.value = index;
+      option.textContent = graph.title;
+      select.appendChild(option);
+    });
+    select.onchange = function () {
+      show(select.value);
+    };
+    if (graphs.length > 0) {
+      show(0);
+    }
+  </script>
+</body>
+</html>
+`
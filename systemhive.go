@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Just enough of the registry hive format (regf) to get the boot key from a SYSTEM hive, like the one saved next to
+// NTDS.dit by ntdsutil "ifm create" or with reg save HKLM\SYSTEM. The boot key is hidden in the class names of four
+// keys below Control\Lsa, in a scrambled order
+
+type registryHive struct {
+	data []byte
+}
+
+// Offsets in the hive are from the first hive bin, after the 4096 byte base block
+const registryHiveBinStart = 4096
+
+// Order the bytes from the class names go in to make the boot key
+var bootKeyPermutation = []int{8, 5, 4, 2, 11, 9, 13, 3, 0, 6, 1, 12, 14, 10, 15, 7}
+
+func openRegistryHive(filename string) (*registryHive, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < registryHiveBinStart || string(data[:4]) != "regf" {
+		return nil, errors.New("Not a registry hive")
+	}
+	return &registryHive{data: data}, nil
+}
+
+// Returns the data of the cell at the offset, without the size in front of it
+func (h *registryHive) cell(offset uint32) ([]byte, error) {
+	start := registryHiveBinStart + int(offset)
+	if offset == 0xffffffff || start+4 > len(h.data) {
+		return nil, fmt.Errorf("Cell at 0x%x is outside the hive", offset)
+	}
+	size := int(int32(binary.LittleEndian.Uint32(h.data[start:])))
+	if size < 0 {
+		size = -size // Negative is in use
+	}
+	if size < 4 || start+size > len(h.data) {
+		return nil, fmt.Errorf("Cell at 0x%x has a bad size", offset)
+	}
+	return h.data[start+4 : start+size], nil
+}
+
+// Returns the key record (nk) at the offset
+func (h *registryHive) key(offset uint32) ([]byte, error) {
+	key, err := h.cell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 76 || string(key[:2]) != "nk" {
+		return nil, fmt.Errorf("No key at 0x%x", offset)
+	}
+	return key, nil
+}
+
+func registryKeyName(key []byte) string {
+	length := int(binary.LittleEndian.Uint16(key[72:]))
+	if 76+length > len(key) {
+		return ""
+	}
+	if binary.LittleEndian.Uint16(key[2:])&0x20 != 0 {
+		return string(key[76 : 76+length]) // Stored as ASCII
+	}
+	return utf16String(key[76 : 76+length])
+}
+
+// Returns the offsets of the subkeys in a subkey list, following index roots (ri) to the lists below them
+func (h *registryHive) subkeys(offset uint32, depth int) ([]uint32, error) {
+	list, err := h.cell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) < 4 || depth > 4 {
+		return nil, errors.New("Bad subkey list")
+	}
+	count := int(binary.LittleEndian.Uint16(list[2:]))
+	entry := 4 // li and ri have just offsets, lf and lh a hash after each
+	switch string(list[:2]) {
+	case "lf", "lh":
+		entry = 8
+	case "li", "ri":
+	default:
+		return nil, fmt.Errorf("Unknown subkey list %q", list[:2])
+	}
+	if 4+count*entry > len(list) {
+		return nil, errors.New("Subkey list is truncated")
+	}
+	var result []uint32
+	for i := 0; i < count; i++ {
+		child := binary.LittleEndian.Uint32(list[4+i*entry:])
+		if string(list[:2]) == "ri" {
+			below, err := h.subkeys(child, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, below...)
+		} else {
+			result = append(result, child)
+		}
+	}
+	return result, nil
+}
+
+// Returns the key at the backslash separated path below the root key
+func (h *registryHive) find(path string) ([]byte, error) {
+	key, err := h.key(binary.LittleEndian.Uint32(h.data[0x24:]))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(path, `\`) {
+		if binary.LittleEndian.Uint32(key[20:]) == 0 {
+			return nil, fmt.Errorf("%v is not in the hive", path)
+		}
+		children, err := h.subkeys(binary.LittleEndian.Uint32(key[28:]), 0)
+		if err != nil {
+			return nil, err
+		}
+		var found []byte
+		for _, child := range children {
+			if subkey, err := h.key(child); err == nil && strings.EqualFold(registryKeyName(subkey), name) {
+				found = subkey
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("%v is not in the hive", path)
+		}
+		key = found
+	}
+	return key, nil
+}
+
+// Returns the data of a value of the key
+func (h *registryHive) value(key []byte, name string) ([]byte, error) {
+	count := int(binary.LittleEndian.Uint32(key[36:]))
+	if count == 0 {
+		return nil, fmt.Errorf("No value %v", name)
+	}
+	list, err := h.cell(binary.LittleEndian.Uint32(key[40:]))
+	if err != nil {
+		return nil, err
+	}
+	if count*4 > len(list) {
+		return nil, errors.New("Value list is truncated")
+	}
+	for i := 0; i < count; i++ {
+		value, err := h.cell(binary.LittleEndian.Uint32(list[i*4:]))
+		if err != nil || len(value) < 20 || string(value[:2]) != "vk" {
+			continue
+		}
+		length := int(binary.LittleEndian.Uint16(value[2:]))
+		if 20+length > len(value) || !strings.EqualFold(string(value[20:20+length]), name) {
+			continue
+		}
+		size := binary.LittleEndian.Uint32(value[4:])
+		if size&0x80000000 != 0 {
+			// Four bytes or less are kept where the offset would be
+			size &= 0x7fffffff
+			if size > 4 {
+				return nil, errors.New("Bad value size")
+			}
+			return value[8 : 8+size], nil
+		}
+		data, err := h.cell(binary.LittleEndian.Uint32(value[8:]))
+		if err != nil {
+			return nil, err
+		}
+		if int(size) > len(data) {
+			return nil, errors.New("Value is truncated")
+		}
+		return data[:size], nil
+	}
+	return nil, fmt.Errorf("No value %v", name)
+}
+
+// Returns the class name of the key, which most keys don't have
+func (h *registryHive) className(key []byte) (string, error) {
+	length := int(binary.LittleEndian.Uint16(key[74:]))
+	data, err := h.cell(binary.LittleEndian.Uint32(key[48:]))
+	if err != nil {
+		return "", err
+	}
+	if length > len(data) {
+		return "", errors.New("Class name is truncated")
+	}
+	return utf16String(data[:length]), nil
+}
+
+// Reads the boot key (syskey) from a SYSTEM hive, from the control set the machine was using
+func ReadBootKey(filename string) ([]byte, error) {
+	h, err := openRegistryHive(filename)
+	if err != nil {
+		return nil, err
+	}
+	selectkey, err := h.find("Select")
+	if err != nil {
+		return nil, fmt.Errorf("Problem finding the control set, is this a SYSTEM hive: %v", err)
+	}
+	current, err := h.value(selectkey, "Current")
+	if err != nil || len(current) != 4 {
+		return nil, fmt.Errorf("Problem finding the control set, is this a SYSTEM hive: %v", err)
+	}
+	lsa := fmt.Sprintf(`ControlSet%03d\Control\Lsa\`, binary.LittleEndian.Uint32(current))
+
+	var scrambled []byte
+	for _, name := range []string{"JD", "Skew1", "GBG", "Data"} {
+		key, err := h.find(lsa + name)
+		if err != nil {
+			return nil, err
+		}
+		class, err := h.className(key)
+		if err != nil {
+			return nil, fmt.Errorf("Problem reading the class name of %v: %v", name, err)
+		}
+		part, err := hex.DecodeString(class)
+		if err != nil || len(part) != 4 {
+			return nil, fmt.Errorf("Class name of %v is not part of a boot key", name)
+		}
+		scrambled = append(scrambled, part...)
+	}
+	bootkey := make([]byte, 16)
+	for i, from := range bootKeyPermutation {
+		bootkey[i] = scrambled[from]
+	}
+	return bootkey, nil
+}
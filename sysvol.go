@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Group Policy settings that are only in the files on SYSVOL, not in the directory: restricted groups and user
+// rights from GptTmpl.inf, registry settings from Registry.pol, startup and logon scripts, and scheduled tasks and
+// local groups from Group Policy Preferences. They're collected from a domain controller into <domain>.sysvol in
+// the data folder, the same place a copy of the Policies folder is loaded from
+
+// Files larger than this are left out of the collection, policies are small and big files are usually installers
+const sysvolMaxFileSize = 10 * 1024 * 1024
+
+type gpoLocalGroupMember struct {
+	Group    string // Local group, as a SID or name
+	Member   string // SID or DOMAIN\name
+	Remove   bool
+	Restrict bool // Restricted groups replace the members, preferences add to them
+}
+
+type gpoTask struct {
+	Name    string
+	Command string
+	RunAs   string
+	Scope   string // Machine or User
+}
+
+type gpoScript struct {
+	Event   string // Startup, Shutdown, Logon or Logoff
+	Command string
+}
+
+type gpoRegistryValue struct {
+	Key, Value, Data string
+}
+
+// Settings from the files of one GPO
+type GPOSettings struct {
+	LocalGroups    []gpoLocalGroupMember
+	Privileges     map[string][]string // User right -> SIDs or names
+	Registry       []gpoRegistryValue
+	ScheduledTasks []gpoTask
+	Scripts        []gpoScript
+}
+
+// GPO -> settings from SYSVOL
+var gpoSettings = make(map[*Object]*GPOSettings)
+
+type sysvolReader interface {
+	ReadDir(path string) ([]smbFileInfo, error)
+	ReadFile(path string) ([]byte, error)
+}
+
+// SYSVOL through the file system, for a UNC path on Windows or a mounted share
+type osSysvol struct {
+	root string
+}
+
+func (o osSysvol) ReadDir(path string) ([]smbFileInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(o.root, path))
+	if err != nil {
+		return nil, err
+	}
+	var results []smbFileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		results = append(results, smbFileInfo{Name: entry.Name(), Size: info.Size(), IsDir: entry.IsDir()})
+	}
+	return results, nil
+}
+
+func (o osSysvol) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(o.root, path))
+}
+
+// Copies the Policies folder of the domain from SYSVOL on the server into <datapath>/<domain>.sysvol. Without a
+// user it goes through the file system as the current user, which works with UNC paths on Windows
+func CollectSYSVOL(domain, server, user, password, authdomain string, ishash bool, datapath string) error {
+	var reader sysvolReader
+	if user == "" {
+		reader = osSysvol{root: `\\` + server + `\SYSVOL`}
+	} else {
+		c, err := newNTLMClient(user, authdomain, password, ishash, server, nil)
+		if err != nil {
+			return err
+		}
+		smb, err := dialSMB(server, c)
+		if err != nil {
+			return err
+		}
+		defer smb.Close()
+		if err = smb.TreeConnect("SYSVOL"); err != nil {
+			return err
+		}
+		reader = smb
+	}
+
+	target := filepath.Join(datapath, domain+".sysvol")
+	var files, skipped int
+	var walk func(remote, local string) error
+	walk = func(remote, local string) error {
+		entries, err := reader.ReadDir(remote)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(local, 0700); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			remotepath := remote + `\` + entry.Name
+			localpath := filepath.Join(local, entry.Name)
+			if entry.IsDir {
+				if err = walk(remotepath, localpath); err != nil {
+					log.Warn().Msgf("Problem collecting %v: %v", remotepath, err)
+				}
+				continue
+			}
+			if entry.Size > sysvolMaxFileSize {
+				log.Debug().Msgf("Skipping %v, it's %v bytes", remotepath, entry.Size)
+				skipped++
+				continue
+			}
+			data, err := reader.ReadFile(remotepath)
+			if err != nil {
+				log.Warn().Msgf("Problem collecting %v: %v", remotepath, err)
+				skipped++
+				continue
+			}
+			if err = os.WriteFile(localpath, data, 0600); err != nil {
+				return err
+			}
+			files++
+		}
+		return nil
+	}
+	if err := walk(domain+`\Policies`, target); err != nil {
+		return err
+	}
+	log.Info().Msgf("Collected %v files from SYSVOL on %v to %v (%v skipped)", files, server, target, skipped)
+	return nil
+}
+
+// Returns the text of a file, which is UTF-16 with a byte order mark for the ones written by the GPO editor
+func sysvolText(data []byte) string {
+	switch {
+	case len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe:
+		return ntlmString(data[2:])
+	case len(data) >= 3 && data[0] == 0xef && data[1] == 0xbb && data[2] == 0xbf:
+		return string(data[3:])
+	}
+	return string(data)
+}
+
+// Returns the key and value pairs in each section of an INI file, with the section names lowercased
+func sysvolINI(text string) map[string][][2]string {
+	sections := make(map[string][][2]string)
+	var section string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(line[1 : len(line)-1])
+			continue
+		}
+		if equals := strings.Index(line, "="); equals != -1 {
+			sections[section] = append(sections[section], [2]string{strings.TrimSpace(line[:equals]), strings.TrimSpace(line[equals+1:])})
+		}
+	}
+	return sections
+}
+
+// Splits a comma separated list of principals from GptTmpl.inf, where SIDs have a * in front
+func sysvolPrincipals(value string) []string {
+	var results []string
+	for _, principal := range strings.Split(value, ",") {
+		if principal = strings.TrimPrefix(strings.TrimSpace(principal), "*"); principal != "" {
+			results = append(results, principal)
+		}
+	}
+	return results
+}
+
+// Returns the name of the principal if it's a SID in the data, else what was given
+func sysvolPrincipalName(principal string) string {
+	if sid, err := SIDFromString(principal); err == nil {
+		if o, found := AllObjects.FindSID(sid); found {
+			return o.OneAttr(Name) + " (" + principal + ")"
+		}
+	}
+	return principal
+}
+
+// Restricted groups and user rights from the security template
+func (s *GPOSettings) parseGptTmpl(data []byte) {
+	sections := sysvolINI(sysvolText(data))
+	for _, pair := range sections["group membership"] {
+		// *S-1-5-32-544__Members = *S-1-5-21-...-1105 sets the members, __Memberof puts the group into others
+		key := strings.TrimPrefix(pair[0], "*")
+		switch {
+		case strings.HasSuffix(strings.ToLower(key), "__members"):
+			group := key[:len(key)-len("__members")]
+			for _, member := range sysvolPrincipals(pair[1]) {
+				s.LocalGroups = append(s.LocalGroups, gpoLocalGroupMember{Group: group, Member: member, Restrict: true})
+			}
+		case strings.HasSuffix(strings.ToLower(key), "__memberof"):
+			member := key[:len(key)-len("__memberof")]
+			for _, group := range sysvolPrincipals(pair[1]) {
+				s.LocalGroups = append(s.LocalGroups, gpoLocalGroupMember{Group: group, Member: member})
+			}
+		}
+	}
+	for _, pair := range sections["privilege rights"] {
+		if s.Privileges == nil {
+			s.Privileges = make(map[string][]string)
+		}
+		s.Privileges[pair[0]] = append(s.Privileges[pair[0]], sysvolPrincipals(pair[1])...)
+	}
+}
+
+// Registry.pol is "PReg", version 1, and then [key;value;type;size;data] entries in UTF-16 except for the binary parts
+func (s *GPOSettings) parseRegistryPol(data []byte, hive string) error {
+	if len(data) < 8 || string(data[:4]) != "PReg" {
+		return errors.New("Not a registry policy file")
+	}
+	i := 8
+	readstring := func() string {
+		start := i
+		for i+1 < len(data) && (data[i] != 0 || data[i+1] != 0) {
+			i += 2
+		}
+		result := ntlmString(data[start:i])
+		i += 4 // The terminating null and the ; after it
+		return result
+	}
+	for i+2 <= len(data) {
+		if data[i] != '[' {
+			return errors.New("Registry policy file is damaged")
+		}
+		i += 2
+		key := readstring()
+		value := readstring()
+		if i+12 > len(data) {
+			return errors.New("Registry policy file is truncated")
+		}
+		valuetype := binary.LittleEndian.Uint32(data[i:])
+		size := int(binary.LittleEndian.Uint32(data[i+6:]))
+		i += 12
+		if i+size+2 > len(data) {
+			return errors.New("Registry policy file is truncated")
+		}
+		raw := data[i : i+size]
+		i += size + 2 // Data and the ]
+
+		var rendered string
+		switch valuetype {
+		case 1, 2: // REG_SZ, REG_EXPAND_SZ
+			rendered = strings.TrimRight(ntlmString(raw), "\x00")
+		case 7: // REG_MULTI_SZ
+			rendered = strings.Join(strings.Split(strings.TrimRight(ntlmString(raw), "\x00"), "\x00"), ", ")
+		case 4: // REG_DWORD
+			if len(raw) == 4 {
+				rendered = strconv.FormatUint(uint64(binary.LittleEndian.Uint32(raw)), 10)
+			}
+		case 11: // REG_QWORD
+			if len(raw) == 8 {
+				rendered = strconv.FormatUint(binary.LittleEndian.Uint64(raw), 10)
+			}
+		default:
+			rendered = hex.EncodeToString(raw)
+		}
+		s.Registry = append(s.Registry, gpoRegistryValue{Key: hive + `\` + key, Value: value, Data: rendered})
+	}
+	return nil
+}
+
+// Immediate and regular tasks from Group Policy Preferences, in the old and the Vista and later formats
+type gppScheduledTasks struct {
+	Tasks []struct {
+		Name       string `xml:"name,attr"`
+		Properties struct {
+			Action  string `xml:"action,attr"`
+			RunAs   string `xml:"runAs,attr"`
+			AppName string `xml:"appName,attr"`
+			Args    string `xml:"args,attr"`
+			Task    struct {
+				Principals struct {
+					Principal []struct {
+						UserID string `xml:"UserId"`
+					} `xml:"Principal"`
+				} `xml:"Principals"`
+				Actions struct {
+					Exec []struct {
+						Command   string `xml:"Command"`
+						Arguments string `xml:"Arguments"`
+					} `xml:"Exec"`
+				} `xml:"Actions"`
+			} `xml:"Task"`
+		} `xml:"Properties"`
+	} `xml:",any"`
+}
+
+func (s *GPOSettings) parseScheduledTasks(data []byte, scope string) error {
+	var tasks gppScheduledTasks
+	if err := xml.Unmarshal(data, &tasks); err != nil {
+		return err
+	}
+	for _, task := range tasks.Tasks {
+		if task.Properties.Action == "D" {
+			continue
+		}
+		runas := task.Properties.RunAs
+		for _, principal := range task.Properties.Task.Principals.Principal {
+			runas = Default(runas, principal.UserID)
+		}
+		var commands []string
+		if task.Properties.AppName != "" {
+			commands = append(commands, strings.TrimSpace(task.Properties.AppName+" "+task.Properties.Args))
+		}
+		for _, exec := range task.Properties.Task.Actions.Exec {
+			commands = append(commands, strings.TrimSpace(exec.Command+" "+exec.Arguments))
+		}
+		for _, command := range commands {
+			s.ScheduledTasks = append(s.ScheduledTasks, gpoTask{Name: task.Name, Command: command, RunAs: runas, Scope: scope})
+		}
+	}
+	return nil
+}
+
+// Local groups from Group Policy Preferences
+type gppGroups struct {
+	Groups []struct {
+		Properties struct {
+			Action         string `xml:"action,attr"`
+			GroupName      string `xml:"groupName,attr"`
+			GroupSID       string `xml:"groupSid,attr"`
+			DeleteAllUsers string `xml:"deleteAllUsers,attr"`
+			Members        struct {
+				Member []struct {
+					Name   string `xml:"name,attr"`
+					Action string `xml:"action,attr"`
+					SID    string `xml:"sid,attr"`
+				} `xml:"Member"`
+			} `xml:"Members"`
+		} `xml:"Properties"`
+	} `xml:"Group"`
+}
+
+func (s *GPOSettings) parseGroups(data []byte) error {
+	var groups gppGroups
+	if err := xml.Unmarshal(data, &groups); err != nil {
+		return err
+	}
+	for _, group := range groups.Groups {
+		if group.Properties.Action == "D" {
+			continue
+		}
+		// Names like "Administrators (built-in)" are how the editor shows them
+		name := Default(group.Properties.GroupSID, strings.TrimSuffix(group.Properties.GroupName, " (built-in)"))
+		for _, member := range group.Properties.Members.Member {
+			s.LocalGroups = append(s.LocalGroups, gpoLocalGroupMember{
+				Group:    name,
+				Member:   Default(member.SID, member.Name),
+				Remove:   member.Action == "REMOVE",
+				Restrict: group.Properties.DeleteAllUsers == "1",
+			})
+		}
+	}
+	return nil
+}
+
+// scripts.ini and psscripts.ini have numbered CmdLine and Parameters keys in a section per event
+func (s *GPOSettings) parseScripts(data []byte, folder string) {
+	for section, pairs := range sysvolINI(sysvolText(data)) {
+		var event string
+		for _, known := range []string{"Startup", "Shutdown", "Logon", "Logoff"} {
+			if strings.EqualFold(section, known) {
+				event = known
+			}
+		}
+		if event == "" {
+			continue
+		}
+		commands := make(map[string]string)
+		var numbers []string
+		for _, pair := range pairs {
+			lower := strings.ToLower(pair[0])
+			switch {
+			case strings.HasSuffix(lower, "cmdline"):
+				number := lower[:len(lower)-len("cmdline")]
+				command := pair[1]
+				if !strings.Contains(command, `\`) {
+					// Relative to the folder for the event in the GPO
+					command = folder + `\` + event + `\` + command
+				}
+				commands[number] = command
+				numbers = append(numbers, number)
+			case strings.HasSuffix(lower, "parameters"):
+				number := lower[:len(lower)-len("parameters")]
+				if pair[1] != "" {
+					commands[number] += " " + pair[1]
+				}
+			}
+		}
+		for _, number := range numbers {
+			s.Scripts = append(s.Scripts, gpoScript{Event: event, Command: commands[number]})
+		}
+	}
+}
+
+// Loads the settings of the GPOs from a copy of the SYSVOL Policies folder, and decorates the GPOs with them
+func LoadGPOSettings(policiespath string) error {
+	gpos := make(map[string]*Object)
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() == ObjectTypeGroupPolicyContainer {
+			gpos[strings.ToLower(o.OneAttr(Name))] = o
+		}
+	}
+
+	loaded := make(map[*Object]*GPOSettings)
+	var files int
+	err := filepath.Walk(policiespath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		filename := strings.ToLower(info.Name())
+		switch filename {
+		case "gpttmpl.inf", "registry.pol", "scheduledtasks.xml", "groups.xml", "scripts.ini", "psscripts.ini":
+		default:
+			return nil
+		}
+
+		// Policies\{GUID}\Machine\... or Policies\{GUID}\User\...
+		relative, _ := filepath.Rel(policiespath, path)
+		parts := strings.Split(filepath.ToSlash(relative), "/")
+		if len(parts) < 3 {
+			return nil
+		}
+		gpo, found := gpos[strings.ToLower(parts[0])]
+		if !found {
+			return nil
+		}
+		scope := "Machine"
+		hive := "HKLM"
+		if strings.EqualFold(parts[1], "User") {
+			scope = "User"
+			hive = "HKCU"
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		settings := loaded[gpo]
+		if settings == nil {
+			settings = &GPOSettings{}
+			loaded[gpo] = settings
+		}
+		switch filename {
+		case "gpttmpl.inf":
+			settings.parseGptTmpl(data)
+		case "registry.pol":
+			err = settings.parseRegistryPol(data, hive)
+		case "scheduledtasks.xml":
+			err = settings.parseScheduledTasks(data, scope)
+		case "groups.xml":
+			err = settings.parseGroups(data)
+		case "scripts.ini", "psscripts.ini":
+			folder := Default(gpo.OneAttr(GPCFileSysPath), parts[0]) + `\` + strings.Join(parts[1:len(parts)-1], `\`)
+			settings.parseScripts(data, folder)
+		}
+		if err != nil {
+			log.Warn().Msgf("Problem parsing %v: %v", path, err)
+			return nil
+		}
+		files++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for gpo, settings := range loaded {
+		gpoSettings[gpo] = settings
+		for _, member := range settings.LocalGroups {
+			action := "add"
+			switch {
+			case member.Remove:
+				action = "remove"
+			case member.Restrict:
+				action = "set"
+			}
+			gpo.AddValues(MetaGPOLocalGroups, sysvolPrincipalName(member.Group)+": "+action+" "+sysvolPrincipalName(member.Member))
+		}
+		rights := make([]string, 0, len(settings.Privileges))
+		for right := range settings.Privileges {
+			rights = append(rights, right)
+		}
+		sort.Strings(rights)
+		for _, right := range rights {
+			var names []string
+			for _, principal := range settings.Privileges[right] {
+				names = append(names, sysvolPrincipalName(principal))
+			}
+			gpo.AddValues(MetaGPOPrivileges, right+": "+strings.Join(names, ", "))
+		}
+		for _, value := range settings.Registry {
+			gpo.AddValues(MetaGPORegistry, value.Key+`\`+value.Value+" = "+value.Data)
+		}
+		for _, task := range settings.ScheduledTasks {
+			gpo.AddValues(MetaGPOScheduledTasks, task.Scope+" task "+task.Name+": "+task.Command+" as "+Default(task.RunAs, "the user"))
+		}
+		for _, script := range settings.Scripts {
+			gpo.AddValues(MetaGPOScripts, script.Event+": "+script.Command)
+		}
+	}
+	log.Info().Msgf("Loaded settings from %v Group Policy files for %v GPOs", files, len(loaded))
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Bits of msDS-SupportedEncryptionTypes
+var kerberosEncryptionTypes = []struct {
+	bit  int64
+	name string
+}{
+	{0x01, "DES-CBC-CRC"},
+	{0x02, "DES-CBC-MD5"},
+	{0x04, "RC4"},
+	{0x08, "AES128"},
+	{0x10, "AES256"},
+}
+
+// Computers change their password every 30 days, so older keys mean that is turned off
+const computerKeyMaxAge = 60 * 24 * time.Hour
+
+// krbtgt should be changed (twice) at least this often, and after anyone who had domain admin leaves
+const krbtgtKeyMaxAge = 180 * 24 * time.Hour
+
+func kerberosEncryptionTypesString(o *Object) string {
+	if uac, ok := o.AttrInt(UserAccountControl); ok && uac&UAC_USE_DES_KEY_ONLY != 0 {
+		return "DES only"
+	}
+	types, ok := o.AttrInt(MSDSSupportedEncTypes)
+	if !ok || types&0x1f == 0 {
+		return "not set, so RC4 unless the domain default is changed"
+	}
+	var names []string
+	for _, encryptiontype := range kerberosEncryptionTypes {
+		if types&encryptiontype.bit != 0 {
+			names = append(names, encryptiontype.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// Key age from when the password was last set, or for trusts when the object last changed, as that is when the
+// trust password was rotated
+func kerberosKeyAge(o *Object, attribute Attribute, asof time.Time) (string, time.Duration) {
+	set, ok := o.AttrTimestamp(attribute)
+	if !ok || set.IsZero() {
+		return "key age unknown", 0
+	}
+	age := asof.Sub(set)
+	return fmt.Sprintf("key set %v (%v days old)", set.Format("2006-01-02"), int(age.Hours()/24)), age
+}
+
+// Rough chances of cracking the password of a roastable account offline. Tickets encrypted with RC4 crack about a
+// thousand times faster than AES ones, and passwords that haven't changed in years are from before today's policy
+const (
+	roastChanceRC4           = 0.4
+	roastChanceAES           = 0.1
+	roastChanceOld           = 0.3
+	roastChanceNoExpire      = 0.1
+	roastChanceMax           = 0.9
+	roastOldPasswordAge      = 3 * 365 * 24 * time.Hour
+	roastEncryptionTypes     = 0x1f
+	roastWeakEncryptionTypes = 0x07 // DES and RC4
+)
+
+// The chance of cracking the password of the account from a ticket or AS-REP encrypted with its key, and why
+func roastChance(o *Object) (float64, string) {
+	chance, why := roastChanceRC4, "RC4"
+	uac, _ := o.AttrInt(UserAccountControl)
+	if types, ok := o.AttrInt(MSDSSupportedEncTypes); ok && types&roastEncryptionTypes != 0 && types&roastWeakEncryptionTypes == 0 && uac&UAC_USE_DES_KEY_ONLY == 0 {
+		chance, why = roastChanceAES, "AES only"
+	}
+	if set, ok := o.AttrTimestamp(PwdLastSet); ok && !set.IsZero() {
+		age := time.Since(set)
+		why += fmt.Sprintf(", password %v days old", int(age.Hours()/24))
+		if age > roastOldPasswordAge {
+			chance += roastChanceOld
+		}
+	}
+	if uac&UAC_DONT_EXPIRE_PASSWORD != 0 {
+		chance += roastChanceNoExpire
+		why += ", never expires"
+	}
+	if chance > roastChanceMax {
+		chance = roastChanceMax
+	}
+	return chance, why
+}
+
+// The host an SPN is for, service/host:port/name
+func spnHost(spn string) string {
+	parts := strings.Split(spn, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.Split(parts[1], ":")[0]
+}
+
+func ticketForgingReport() []Finding {
+	asof := dataTimestamp()
+	var krbtgts, trusts, dcs, services []Finding
+	for _, o := range AllObjects.AsArray() {
+		samaccountname := strings.ToLower(o.OneAttr(SAMAccountName))
+		uac, _ := o.AttrInt(UserAccountControl)
+		switch {
+		case samaccountname == "krbtgt" || strings.HasPrefix(samaccountname, "krbtgt_"):
+			keyage, age := kerberosKeyAge(o, PwdLastSet, asof)
+			what := "Golden tickets for the whole domain"
+			if samaccountname != "krbtgt" {
+				what = "Golden tickets for the read only domain controller using it"
+			}
+			detail := what + " - " + keyage + ", encryption types " + kerberosEncryptionTypesString(o)
+			if age > krbtgtKeyMaxAge {
+				detail += " - change it twice to invalidate forged tickets"
+			}
+			krbtgts = append(krbtgts, Finding{o.DN(), detail})
+		case o.Type() == ObjectTypeTrust:
+			keyage, _ := kerberosKeyAge(o, WhenChanged, asof)
+			trusts = append(trusts, Finding{o.DN(), "Inter-realm tickets for the " + Default(o.OneAttr(MetaTrustDirection), "unknown direction") + " trust with " + o.OneAttr(TrustPartner) +
+				" - " + keyage + " (last change of the trust), encryption types " + kerberosEncryptionTypesString(o)})
+		case uac&UAC_INTERDOMAIN_TRUST_ACCOUNT != 0:
+			keyage, _ := kerberosKeyAge(o, PwdLastSet, asof)
+			trusts = append(trusts, Finding{o.DN(), "Inter-realm tickets into the trusting domain " + strings.TrimSuffix(o.OneAttr(SAMAccountName), "$") +
+				" - trust account " + keyage + ", encryption types " + kerberosEncryptionTypesString(o)})
+		case o.Type() == ObjectTypeComputer && (uac&UAC_SERVER_TRUST_ACCOUNT != 0 || uac&UAC_PARTIAL_SECRETS_ACCOUNT != 0):
+			keyage, age := kerberosKeyAge(o, PwdLastSet, asof)
+			detail := "Silver tickets for all services on the domain controller (ldap for DCSync, cifs, host) - " + keyage + ", encryption types " + kerberosEncryptionTypesString(o)
+			if age > computerKeyMaxAge {
+				detail += " - password changes look turned off"
+			}
+			dcs = append(dcs, Finding{o.DN(), detail})
+		case o.Type() == ObjectTypeUser || o.Type() == ObjectTypeManagedServiceAccount:
+			spns := o.Attr(ServicePrincipalName)
+			if len(spns) == 0 || o.OneAttr(MetaAccountDisabled) == "1" {
+				continue
+			}
+			var highvalue []string
+			for _, spn := range spns {
+				if computer, found := AllObjects.FindComputer(spnHost(spn)); found && computer.IsTier0() {
+					highvalue = append(highvalue, spn)
+				}
+			}
+			if len(highvalue) == 0 && !o.IsTier0() {
+				continue
+			}
+			keyage, _ := kerberosKeyAge(o, PwdLastSet, asof)
+			var detail string
+			if len(highvalue) > 0 {
+				detail = "Silver tickets for tier 0 services " + strings.Join(highvalue, ", ")
+			} else {
+				detail = "Silver tickets for the services of tier 0 account (" + strings.Join(spns, ", ") + ")"
+			}
+			detail += " - " + keyage + ", encryption types " + kerberosEncryptionTypesString(o)
+			if o.Type() == ObjectTypeUser {
+				detail += " - the key comes from a password, so it can be kerberoasted"
+			}
+			services = append(services, Finding{o.DN(), detail})
+		}
+	}
+	return append(append(append(krbtgts, trusts...), dcs...), services...)
+}
@@ -0,0 +1,86 @@
+package main
+
+import "github.com/rs/zerolog/log"
+
+// RIDs of the built-in groups that control the domain, so their members are tier 0
+// https://docs.microsoft.com/en-us/windows-server/identity/ad-ds/plan/security-best-practices/appendix-b--privileged-accounts-and-groups-in-active-directory
+var tier0RIDs = []uint32{
+	512, // Domain Admins
+	516, // Domain Controllers
+	518, // Schema Admins
+	519, // Enterprise Admins
+	544, // Administrators
+	548, // Account Operators
+	549, // Server Operators
+	550, // Print Operators
+	551, // Backup Operators
+}
+
+// Query for the groups and accounts that are tier 0 in this environment on top of the built-in ones, like custom
+// admin groups or backup service accounts. Members of matching groups are tier 0 too
+var Tier0Query string
+
+// Objects matching Tier0Query
+var tier0Extra map[*Object]struct{}
+
+func buildTier0Extra() {
+	tier0Extra = make(map[*Object]struct{})
+	if Tier0Query == "" {
+		return
+	}
+	query, err := ParseQueryStrict(Tier0Query)
+	if err != nil {
+		log.Warn().Msgf("Problem parsing tier 0 query %v: %v", Tier0Query, err)
+		return
+	}
+	for _, o := range AllObjects.AsArray() {
+		if query.Evaluate(o) {
+			tier0Extra[o] = struct{}{}
+		}
+	}
+	log.Info().Msgf("%v objects are tier 0 from the tier 0 query", len(tier0Extra))
+}
+
+func isTier0Group(o *Object) bool {
+	if _, found := tier0Extra[o]; found {
+		return true
+	}
+	rid := o.SID().RID()
+	for _, tier0rid := range tier0RIDs {
+		if rid == tier0rid {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns all groups this object is a member of, directly or via nesting
+func (o *Object) MemberOfRecursive() []*Object {
+	visited := map[*Object]struct{}{o: {}}
+	var result []*Object
+	queue := o.MemberOf()
+	for len(queue) > 0 {
+		group := queue[0]
+		queue = queue[1:]
+		if _, seen := visited[group]; seen {
+			continue
+		}
+		visited[group] = struct{}{}
+		result = append(result, group)
+		queue = append(queue, group.MemberOf()...)
+	}
+	return result
+}
+
+// IsTier0 returns true if the object is one of the tier 0 groups, or a direct or nested member of one
+func (o *Object) IsTier0() bool {
+	if isTier0Group(o) {
+		return true
+	}
+	for _, group := range o.MemberOfRecursive() {
+		if isTier0Group(group) {
+			return true
+		}
+	}
+	return false
+}
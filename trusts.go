@@ -0,0 +1,350 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-adts/5026a939-44ba-47b2-99cf-386a9e674b04
+const (
+	TRUST_DIRECTION_DISABLED      = 0
+	TRUST_DIRECTION_INBOUND       = 1
+	TRUST_DIRECTION_OUTBOUND      = 2
+	TRUST_DIRECTION_BIDIRECTIONAL = 3
+)
+
+// https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-adts/e9a2d23c-c31e-4a6f-88a0-6646fdb51a3c
+const (
+	TRUST_ATTRIBUTE_NON_TRANSITIVE                       = 0x00000001
+	TRUST_ATTRIBUTE_UPLEVEL_ONLY                         = 0x00000002
+	TRUST_ATTRIBUTE_QUARANTINED_DOMAIN                   = 0x00000004
+	TRUST_ATTRIBUTE_FOREST_TRANSITIVE                    = 0x00000008
+	TRUST_ATTRIBUTE_CROSS_ORGANIZATION                   = 0x00000010
+	TRUST_ATTRIBUTE_WITHIN_FOREST                        = 0x00000020
+	TRUST_ATTRIBUTE_TREAT_AS_EXTERNAL                    = 0x00000040
+	TRUST_ATTRIBUTE_USES_RC4_ENCRYPTION                  = 0x00000080
+	TRUST_ATTRIBUTE_CROSS_ORGANIZATION_NO_TGT_DELEGATION = 0x00000200
+	TRUST_ATTRIBUTE_PIM_TRUST                            = 0x00000400
+)
+
+func trustDirectionString(direction int64) string {
+	switch direction {
+	case TRUST_DIRECTION_DISABLED:
+		return "disabled"
+	case TRUST_DIRECTION_INBOUND:
+		return "inbound"
+	case TRUST_DIRECTION_OUTBOUND:
+		return "outbound"
+	case TRUST_DIRECTION_BIDIRECTIONAL:
+		return "bidirectional"
+	}
+	return "unknown"
+}
+
+func trustTypeString(attributes int64) string {
+	switch {
+	case attributes&TRUST_ATTRIBUTE_WITHIN_FOREST != 0:
+		return "withinforest"
+	case attributes&TRUST_ATTRIBUTE_FOREST_TRANSITIVE != 0:
+		return "forest"
+	}
+	return "external"
+}
+
+// Trusts between domains in a forest and forest trusts are transitive, external trusts are not
+func trustTransitive(attributes int64) bool {
+	if attributes&TRUST_ATTRIBUTE_NON_TRANSITIVE != 0 {
+		return false
+	}
+	return attributes&(TRUST_ATTRIBUTE_WITHIN_FOREST|TRUST_ATTRIBUTE_FOREST_TRANSITIVE) != 0
+}
+
+// SID filtering strips foreign SIDs (like SID history) from tickets crossing the trust. Inside a forest there is
+// none, forest trusts filter unless SID history has been enabled on them, external trusts filter if quarantined
+func trustSIDFiltering(attributes int64) bool {
+	switch {
+	case attributes&TRUST_ATTRIBUTE_WITHIN_FOREST != 0:
+		return false
+	case attributes&TRUST_ATTRIBUTE_FOREST_TRANSITIVE != 0:
+		return attributes&TRUST_ATTRIBUTE_TREAT_AS_EXTERNAL == 0
+	}
+	return attributes&TRUST_ATTRIBUTE_QUARANTINED_DOMAIN != 0
+}
+
+// Decorates trust objects with their configuration, and points them to the partner domain. If the partner
+// isn't loaded, a stub domain is added in its place - load a dump of the partner with the rest, and the real
+// domain is used instead. Foreign security principals are matched with the principals they represent when
+// those are loaded, so paths can continue across the trust where it allows it
+func LinkTrusts() {
+	var stubs, linked int
+	domainTrustLinks = make(map[*Object][]trustLink)
+	for _, trust := range AllObjects.AsArray() {
+		if trust.Type() != ObjectTypeTrust {
+			continue
+		}
+		direction, _ := trust.AttrInt(TrustDirection)
+		attributes, _ := trust.AttrInt(TrustAttributes)
+		trust.SetAttr(MetaTrustDirection, trustDirectionString(direction))
+		trust.SetAttr(MetaTrustType, trustTypeString(attributes))
+		if trustSIDFiltering(attributes) {
+			trust.SetAttr(MetaSIDFiltering, "1")
+		}
+
+		partnername := strings.ToLower(trust.OneAttr(TrustPartner))
+		if partnername == "" {
+			continue
+		}
+		partnerdn := "dc=" + strings.Replace(partnername, ".", ",dc=", -1)
+
+		partner, found := AllObjects.Find(partnerdn)
+		if !found && trust.OneAttr(SecurityIdentifier) != "" {
+			if partnersid, _, err := ParseSID([]byte(trust.OneAttr(SecurityIdentifier))); err == nil {
+				partner, found = AllObjects.FindSID(partnersid)
+			}
+		}
+		if !found {
+			partner = &Object{
+				DistinguishedName: partnerdn,
+				Attributes: map[Attribute][]string{
+					DistinguishedName: {partnerdn},
+					ObjectCategory:    {"CN=Domain-DNS,CN=Schema,CN=Configuration," + AllObjects.Base},
+					ObjectClass:       {"top", "domain", "domainDNS"},
+					Name:              {partnername},
+					Description:       {"Stub for trusted domain " + partnername + ", which is not loaded"},
+					MetaStub:          {"1"},
+				},
+			}
+			if sid := trust.OneAttr(SecurityIdentifier); sid != "" {
+				partner.Attributes[ObjectSid] = []string{sid}
+			}
+			AllObjects.Add(partner)
+			stubs++
+		}
+		trust.SetAttr(MetaTrustPartner, partner.DN())
+
+		// Accounts in the trusted domain can authenticate in the trusting one
+		if own := domainOf(trust); own != nil && own != partner {
+			if direction&TRUST_DIRECTION_OUTBOUND != 0 {
+				domainTrustLinks[own] = append(domainTrustLinks[own], trustLink{trusted: partner, trust: trust})
+			}
+			if direction&TRUST_DIRECTION_INBOUND != 0 {
+				domainTrustLinks[partner] = append(domainTrustLinks[partner], trustLink{trusted: own, trust: trust})
+			}
+		}
+
+		// Let the partner side show how it's trusted, the stub has nothing else to say
+		if partner.OneAttr(MetaStub) == "1" {
+			partner.AddValues(MetaTrustDirection, trustDirectionString(direction))
+			partner.AddValues(MetaTrustType, trustTypeString(attributes))
+			if trustSIDFiltering(attributes) {
+				partner.SetAttr(MetaSIDFiltering, "1")
+			}
+		}
+	}
+
+	// Who trusts who
+	domainTrusts = make(map[[2]SID]*Object)
+	for _, trust := range AllObjects.AsArray() {
+		if trust.Type() != ObjectTypeTrust || trust.OneAttr(SecurityIdentifier) == "" {
+			continue
+		}
+		owndomain := domainSIDOf(trust)
+		partnerdomain, _, err := ParseSID([]byte(trust.OneAttr(SecurityIdentifier)))
+		if owndomain.IsNull() || err != nil {
+			continue
+		}
+		direction, _ := trust.AttrInt(TrustDirection)
+		if direction&TRUST_DIRECTION_OUTBOUND != 0 {
+			domainTrusts[[2]SID{owndomain, partnerdomain}] = trust
+		}
+		if direction&TRUST_DIRECTION_INBOUND != 0 {
+			domainTrusts[[2]SID{partnerdomain, owndomain}] = trust
+		}
+	}
+
+	// Principals from all loaded domains, so foreign security principals can find the real thing
+	principals := make(map[SID]*Object)
+	for _, o := range AllObjects.AsArray() {
+		if o.Type() == ObjectTypeForeignSecurityPrincipal || o.OneAttr(ObjectSid) == "" {
+			continue
+		}
+		principals[o.SID()] = o
+	}
+	foreignPrincipals = make(map[*Object]*Object)
+	for _, fsp := range AllObjects.AsArray() {
+		if fsp.Type() != ObjectTypeForeignSecurityPrincipal {
+			continue
+		}
+		if principal, found := principals[fsp.SID()]; found && principal != fsp {
+			foreignPrincipals[fsp] = principal
+			linked++
+		}
+	}
+
+	// SID history from another domain is stripped when crossing a trust with SID filtering, so it
+	// can't be used to resolve permissions granted to the old SID. Values that were text when the
+	// object was added are SIDs now, so they point to it from here
+	for _, o := range AllObjects.AsArray() {
+		for _, historysid := range o.SIDHistory() {
+			if trust := sidHistoryFiltered(o, historysid); trust != nil {
+				if AllObjects.RemoveSIDAlias(historysid, o) {
+					log.Debug().Msgf("SID history %v on %v is filtered by the trust %v", historysid.ToString(), o.DN(), trust.DN())
+				}
+			} else {
+				AllObjects.AddSIDAlias(historysid, o)
+			}
+		}
+	}
+
+	if stubs > 0 {
+		log.Info().Msgf("Added %v stub domains for trust partners that are not loaded", stubs)
+	}
+	if linked > 0 {
+		log.Info().Msgf("Connected %v foreign security principals to principals in other loaded domains", linked)
+	}
+}
+
+// Trusting domain SID, trusted domain SID -> trust object
+var domainTrusts map[[2]SID]*Object
+
+// A domain trusted by another one, and the trust object that says how
+type trustLink struct {
+	trusted *Object
+	trust   *Object
+}
+
+// Trusting domain -> the domains it trusts. With both domains loaded each trust is there twice, once from each side
+var domainTrustLinks map[*Object][]trustLink
+
+// Foreign security principal -> the principal in another loaded domain it represents
+var foreignPrincipals map[*Object]*Object
+
+// Returns the domain object the object lives in, by looking it up from the DN
+func domainOf(o *Object) *Object {
+	dn := strings.ToLower(o.DN())
+	start := strings.Index(dn, "dc=")
+	if start == -1 {
+		return nil
+	}
+	if domain, found := AllObjects.Find(dn[start:]); found {
+		return domain
+	}
+	return nil
+}
+
+// Returns the SID of the domain the object lives in
+func domainSIDOf(o *Object) SID {
+	if domain := domainOf(o); domain != nil {
+		return domain.SID()
+	}
+	return ""
+}
+
+var AllowedToAuthenticate, _ = uuid.FromString("68b1d179-0d15-4d4f-ab71-46152e79a7bc")
+
+// Returns true if the principal or a group it's in has been granted Allowed to Authenticate on a computer
+// in the domain, which is needed to get anywhere over a trust with selective authentication
+func allowedToAuthenticateIn(principal *Object, domainsid SID) bool {
+	sids := map[SID]struct{}{principal.SID(): {}}
+	for _, group := range principal.MemberOfRecursive() {
+		sids[group.SID()] = struct{}{}
+	}
+	for _, computer := range AllObjects.AsArray() {
+		if computer.Type() != ObjectTypeComputer || computer.SID().StripRID() != domainsid {
+			continue
+		}
+		sd, err := computer.SecurityDescriptor()
+		if err != nil {
+			continue
+		}
+		for _, ace := range sd.DACL.Entries {
+			if _, found := sids[ace.SID]; found && ace.AllowObjectClass(computer) && ace.AllowMaskedClass(RIGHT_DS_CONTROL_ACCESS, AllowedToAuthenticate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the principal in another loaded domain that the foreign security principal represents, if the trust
+// lets it authenticate here. The reasoning is recorded on the connection
+func crossTrustIdentity(fsp *Object) []*Object {
+	principal, found := foreignPrincipals[fsp]
+	if !found {
+		return nil
+	}
+	trustingdomain := domainSIDOf(fsp)
+	trusteddomain := principal.SID().StripRID()
+
+	trust, found := domainTrusts[[2]SID{trustingdomain, trusteddomain}]
+	if !found {
+		SetEdgeReason(principal, fsp, PwnForeignIdentity, "no direct trust found between the domains, assuming a transitive trust path")
+		return []*Object{principal}
+	}
+
+	attributes, _ := trust.AttrInt(TrustAttributes)
+	reason := trust.OneAttr(MetaTrustDirection) + " " + trust.OneAttr(MetaTrustType) + " trust " + trust.DN()
+	if attributes&TRUST_ATTRIBUTE_CROSS_ORGANIZATION != 0 {
+		if !allowedToAuthenticateIn(fsp, trustingdomain) && !allowedToAuthenticateIn(principal, trustingdomain) {
+			log.Debug().Msgf("%v can't use %v, the trust has selective authentication and it's not allowed to authenticate anywhere", principal.DN(), fsp.DN())
+			return nil
+		}
+		reason += ", selective authentication but granted Allowed to Authenticate on a computer"
+	}
+	if trust.OneAttr(MetaSIDFiltering) == "1" {
+		reason += ", SID filtering enabled so SID history is not honored"
+	} else {
+		reason += ", no SID filtering so SID history is honored"
+	}
+	SetEdgeReason(principal, fsp, PwnForeignIdentity, reason)
+	return []*Object{principal}
+}
+
+// Describes the trust for the connection between the domains
+func trustReason(trust *Object) string {
+	attributes, _ := trust.AttrInt(TrustAttributes)
+	reason := trust.OneAttr(MetaTrustDirection) + " " + trust.OneAttr(MetaTrustType) + " trust " + trust.DN()
+	if trustTransitive(attributes) {
+		reason += ", transitive"
+	} else {
+		reason += ", non-transitive"
+	}
+	if attributes&TRUST_ATTRIBUTE_CROSS_ORGANIZATION != 0 {
+		reason += ", selective authentication"
+	}
+	if trust.OneAttr(MetaSIDFiltering) == "1" {
+		reason += ", SID filtering enabled"
+	} else {
+		reason += ", no SID filtering"
+	}
+	return reason
+}
+
+// Returns the domains that the domain trusts, so their accounts can authenticate here. This isn't control of the
+// domain, but shows how the forests and domains hang together
+func trustedDomains(domain *Object) []*Object {
+	var results []*Object
+	for _, link := range domainTrustLinks[domain] {
+		SetEdgeReason(link.trusted, domain, PwnDomainTrust, trustReason(link.trust))
+		results = append(results, link.trusted)
+	}
+	return results
+}
+
+// Returns the domains that the domain trusts without SID filtering. Whoever controls one of them can forge a ticket
+// (golden ticket with extra SIDs, or an inter-realm ticket with the trust key) with the SID of Enterprise Admins or
+// another admin group here in the SID history, and it is honored
+func trustAbusers(domain *Object) []*Object {
+	var results []*Object
+	for _, link := range domainTrustLinks[domain] {
+		if link.trust.OneAttr(MetaSIDFiltering) == "1" {
+			continue
+		}
+		reason := "No SID filtering on the " + link.trust.OneAttr(MetaTrustType) + " trust " + link.trust.DN() + ", so SIDs from " + domain.Label() + " in the SID history of tickets from " + link.trusted.Label() + " are honored"
+		SetEdgeReason(link.trusted, domain, PwnTrustAbuse, reason)
+		results = append(results, link.trusted)
+	}
+	return results
+}
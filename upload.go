@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pierrec/lz4"
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Collectors in other parts of the network can push what they collected to the webservice with the upload command,
+// instead of copying the files to the data folder by hand. The files are checked and written to the data folder,
+// where the monitor picks them up and everything is loaded and analyzed again
+
+// Data files that can be uploaded
+var uploadSuffixes = []string{".objects.lz4.msgp", ".localmachine.json", ".entra.json", ".idp.json", ".aws.json", ".metadata.csv"}
+
+// Uploads bigger than this are refused, so whoever can reach the webservice can't fill the disk
+var UploadMaxSize int64 = 4 << 30
+
+// Held while a file in the data folder is replaced by an upload, so uploads of the same local machine data at the
+// same time are both merged in
+var uploadLocks sync.Map
+
+func uploadLock(destination string) *sync.Mutex {
+	lock, _ := uploadLocks.LoadOrStore(destination, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func uploadAllowed(filename string) bool {
+	if filename != filepath.Base(filename) || strings.ContainsAny(filename, `/\:`) || strings.HasPrefix(filename, ".") {
+		return false
+	}
+	for _, suffix := range uploadSuffixes {
+		if strings.HasSuffix(filename, suffix) && len(filename) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handles PUT /upload/{filename} with the file as the body, from clients with the token as a bearer token
+func uploadHandler(datapath, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			log.Warn().Msgf("Upload from %v with the wrong token", r.RemoteAddr)
+			http.Error(w, "Wrong upload token", http.StatusUnauthorized)
+			return
+		}
+		filename := mux.Vars(r)["filename"]
+		if !uploadAllowed(filename) {
+			http.Error(w, "Only data files ("+strings.Join(uploadSuffixes, ", ")+") can be uploaded", http.StatusBadRequest)
+			return
+		}
+
+		// Written next to where it goes and renamed when complete, so it's never loaded half written
+		temp, err := os.CreateTemp(datapath, ".upload-*")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(temp.Name())
+		size, err := io.Copy(temp, http.MaxBytesReader(w, r.Body, UploadMaxSize))
+		if closeerr := temp.Close(); err == nil {
+			err = closeerr
+		}
+		if err != nil && size >= UploadMaxSize {
+			log.Warn().Msgf("Upload of %v from %v is bigger than %v, refused", filename, r.RemoteAddr, formatBytes(int(UploadMaxSize)))
+			http.Error(w, "File is bigger than "+formatBytes(int(UploadMaxSize)), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Problem receiving file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if strings.HasSuffix(filename, ".objects.lz4.msgp") {
+			if err = checkUploadedDump(temp.Name()); err != nil {
+				http.Error(w, "Not a readable dump: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		destination := filepath.Join(datapath, filename)
+		lock := uploadLock(destination)
+		lock.Lock()
+		if strings.HasSuffix(filename, ".localmachine.json") {
+			err = mergeLocalMachineFile(temp.Name(), destination)
+		} else {
+			err = os.Rename(temp.Name(), destination)
+		}
+		lock.Unlock()
+		if err != nil {
+			http.Error(w, "Problem storing file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Info().Msgf("Received %v (%v bytes) from %v", filename, size, r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Reads all the objects in an uploaded dump, so a broken or truncated one doesn't replace a good one
+func checkUploadedDump(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d := msgp.NewReader(lz4.NewReader(f))
+	for objects := 0; ; objects++ {
+		var raw RawObject
+		err = raw.DecodeMsg(d)
+		if msgp.Cause(err) == io.EOF {
+			if objects == 0 {
+				return errors.New("no objects in it")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("object %v: %v", objects+1, err)
+		}
+	}
+}
+
+// Merges uploaded local machine data into what is already there, as collectors in different places can see different
+// things about the same machine, like the network sessions from it on servers in their part of the network
+func mergeLocalMachineFile(uploadedfile, destination string) error {
+	data, err := os.ReadFile(uploadedfile)
+	if err != nil {
+		return err
+	}
+	var uploaded LocalMachine
+	if err = qjson.Unmarshal(data, &uploaded); err != nil {
+		return fmt.Errorf("not local machine data: %v", err)
+	}
+	if data, err = os.ReadFile(destination); err == nil {
+		var existing LocalMachine
+		if err = qjson.Unmarshal(data, &existing); err == nil {
+			uploaded = mergeLocalMachine(existing, uploaded)
+		}
+	}
+	if data, err = qjson.MarshalIndent(uploaded, "", "  "); err != nil {
+		return err
+	}
+	if err = os.WriteFile(uploadedfile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(uploadedfile, destination)
+}
+
+// Newer data replaces older data, except sessions which are kept from both with when they were last seen
+func mergeLocalMachine(existing, uploaded LocalMachine) LocalMachine {
+	if uploaded.Collected.Before(existing.Collected) {
+		existing, uploaded = uploaded, existing
+	}
+	merged := uploaded
+	if len(merged.Shares) == 0 {
+		merged.Shares = existing.Shares
+	}
+	if len(merged.Services) == 0 {
+		merged.Services = existing.Services
+	}
+	if len(merged.LocalAdmins) == 0 {
+		merged.LocalAdmins = existing.LocalAdmins
+	}
+	if len(merged.RDPUsers) == 0 {
+		merged.RDPUsers = existing.RDPUsers
+	}
+	if len(merged.DCOMUsers) == 0 {
+		merged.DCOMUsers = existing.DCOMUsers
+	}
+	if merged.SCCM == nil {
+		merged.SCCM = existing.SCCM
+	}
+
+	// Sessions default to when the file was collected, which changes when merging, so that is made explicit
+	merged.Sessions = nil
+	latest := make(map[string]int)
+	for _, machine := range []LocalMachine{existing, uploaded} {
+		for _, session := range machine.Sessions {
+			if session.Seen.IsZero() {
+				session.Seen = machine.Collected
+			}
+			key := strings.ToLower(session.User) + "|" + strings.ToLower(session.LogonType)
+			if i, found := latest[key]; found {
+				if session.Seen.After(merged.Sessions[i].Seen) {
+					merged.Sessions[i].Seen = session.Seen
+				}
+				continue
+			}
+			latest[key] = len(merged.Sessions)
+			merged.Sessions = append(merged.Sessions, session)
+		}
+	}
+	return merged
+}
+
+// Pushes data files to the webservice on another machine. Without files it sends all the data files in datapath
+func UploadFiles(serverurl, token, datapath string, files []string) error {
+	if len(files) == 0 {
+		for _, suffix := range uploadSuffixes {
+			found, err := filepath.Glob(filepath.Join(datapath, "*"+suffix))
+			if err != nil {
+				return err
+			}
+			files = append(files, found...)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no data files in %v to upload", datapath)
+	}
+
+	client := &http.Client{Timeout: time.Hour}
+	for _, file := range files {
+		filename := filepath.Base(file)
+		if !uploadAllowed(filename) {
+			return fmt.Errorf("%v is not a data file that can be uploaded", file)
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		request, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(serverurl, "/")+"/upload/"+url.PathEscape(filename), f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := client.Do(request)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		message, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("uploading %v failed with %v: %v", filename, response.Status, strings.TrimSpace(string(message)))
+		}
+		log.Info().Msgf("Uploaded %v", filename)
+	}
+	return nil
+}
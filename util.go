@@ -14,10 +14,16 @@ import (
 
 var legalMatch = regexp.MustCompile("[[:alnum:] _.=,-]") // dash must be LAST! doh
 
-func cleanfilename(input string) string {
+// Removes diacritics, so "Åsa Müller" becomes "Asa Muller"
+func stripAccents(input string) string {
 	normalized, _, _ := transform.String(transform.Chain(norm.NFD, transform.RemoveFunc(func(r rune) bool {
 		return unicode.Is(unicode.Mn, r) // Mn: nonspacing marks
 	}), norm.NFC), input)
+	return normalized
+}
+
+func cleanfilename(input string) string {
+	normalized := stripAccents(input)
 
 	var output string
 
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pierrec/lz4"
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Calls f for every object in a dump file
+func readDumpFile(filename string, f func(*RawObject)) error {
+	cachefile, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer cachefile.Close()
+	d := msgp.NewReader(lz4.NewReader(cachefile))
+	for {
+		var rawObject RawObject
+		err = rawObject.DecodeMsg(d)
+		if msgp.Cause(err) == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f(&rawObject)
+	}
+}
+
+// Writes objects to a dump file, in the same format as dump
+func writeDumpFile(filename string, objects []*RawObject) error {
+	return writeDumpStream(filename, func(e *msgp.Writer) error {
+		for _, object := range objects {
+			if err := object.EncodeMsg(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Creates a dump file and lets write encode the objects into it as they come in
+func writeDumpStream(filename string, write func(e *msgp.Writer) error) error {
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer outfile.Close()
+	boutfile := lz4.NewWriter(outfile)
+	boutfile.Header.CompressionLevel = 10
+	e := msgp.NewWriter(boutfile)
+	if err = write(e); err != nil {
+		return err
+	}
+	if err = e.Flush(); err != nil {
+		return err
+	}
+	return boutfile.Close()
+}
+
+// Lower bound of the 95% Wilson score interval for successes out of total
+func wilsonLowerBound(successes, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	const z = 1.96
+	n := float64(total)
+	p := float64(successes) / n
+	return (p + z*z/(2*n) - z*math.Sqrt(p*(1-p)/n+z*z/(4*n*n))) / (1 + z*z/n)
+}
+
+// Picks samples random objects from the dump file, gets them again from the directory and compares them, to find
+// out if the dump lost data - security descriptors that couldn't be read, ranged multi-valued attributes, attributes
+// left out. Attributes in skip (redacted ones) are not compared. Objects changed since the dump are counted, but not
+// held against it
+func VerifyDump(ad *AD, filename string, samples int, attributes []string, skip []string, nosacl bool) error {
+	rand.Seed(time.Now().UnixNano())
+
+	var total, nosd, ranged int
+	sampled := make([]*RawObject, 0, samples)
+	err := readDumpFile(filename, func(o *RawObject) {
+		total++
+		if len(o.Attributes["nTSecurityDescriptor"]) == 0 {
+			nosd++
+		}
+		for name := range o.Attributes {
+			if strings.Contains(name, ";range=") {
+				ranged++
+				break
+			}
+		}
+		// Reservoir sampling, so every object has the same chance
+		if len(sampled) < samples {
+			sampled = append(sampled, o)
+		} else if i := rand.Intn(total); i < samples {
+			sampled[i] = o
+		}
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("Dump has %v objects, %v without a security descriptor, %v with ranged (truncated) attributes", total, nosd, ranged)
+
+	skipped := make(map[string]struct{})
+	for _, name := range skip {
+		skipped[strings.ToLower(strings.TrimSpace(name))] = struct{}{}
+	}
+
+	missing := make(map[string]int)
+	truncated := make(map[string]int)
+	var complete, changed, gone, checked int
+	for _, dumped := range sampled {
+		live, err := ad.Fetch(dumped.DistinguishedName, attributes, nosacl)
+		if err != nil {
+			return err
+		}
+		if live == nil {
+			gone++
+			continue
+		}
+		checked++
+		if strings.Join(live.Attributes["whenChanged"], "") != strings.Join(dumped.Attributes["whenChanged"], "") {
+			changed++
+			continue
+		}
+
+		lossy := false
+		for name, livevalues := range live.Attributes {
+			if _, found := skipped[strings.ToLower(name)]; found {
+				continue
+			}
+			basename := name
+			if i := strings.Index(name, ";range="); i != -1 {
+				basename = name[:i]
+			}
+			dumpedvalues, found := dumped.Attributes[name]
+			if !found {
+				for dumpedname, values := range dumped.Attributes {
+					if strings.HasPrefix(dumpedname, basename+";range=") {
+						dumpedvalues, found = values, true
+						break
+					}
+				}
+			}
+			switch {
+			case !found:
+				missing[basename]++
+				lossy = true
+			case len(dumpedvalues) < len(livevalues) || name != basename:
+				truncated[basename]++
+				lossy = true
+			}
+		}
+		if !lossy {
+			complete++
+		}
+	}
+
+	log.Info().Msgf("Sampled %v objects: %v unchanged and complete, %v changed since the dump, %v deleted or moved since the dump", len(sampled), complete, changed, gone)
+	report := func(what string, counts map[string]int) {
+		var names []string
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return counts[names[i]] > counts[names[j]]
+		})
+		for _, name := range names {
+			log.Warn().Msgf("%v %v in %v sampled objects", name, what, counts[name])
+		}
+	}
+	report("missing from the dump", missing)
+	report("has fewer values in the dump", truncated)
+	if missing["nTSecurityDescriptor"] > 0 {
+		log.Warn().Msg("Security descriptors are missing, the dump was probably run without -nosacl or with an account that can't read them")
+	}
+
+	compared := checked - changed
+	if compared == 0 {
+		log.Warn().Msg("No unchanged objects to compare, can't tell how complete the dump is")
+		return nil
+	}
+	log.Info().Msgf("Coverage: %.1f%% of the compared objects are complete in the dump (95%% confidence it's at least %.1f%% for the whole dump)",
+		float64(complete)*100/float64(compared), wilsonLowerBound(complete, compared)*100)
+	return nil
+}
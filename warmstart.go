@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OneOfOne/xxhash"
+	"github.com/lkarlslund/stringdedup"
+	"github.com/pierrec/lz4"
+	"github.com/rs/zerolog/log"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Loading and analyzing a big domain takes minutes, so with -warmstart everything is saved to a file in the data folder
+// once it's done: the objects as they are after processing, the connections and their details, and the problems.
+// The next start loads that instead, if it's from the same data, settings and adalanche binary. The lookups are built
+// again from the objects, which is quick. Ages (last logon, sessions) are as they were when the file was written, so
+// it's only used for a day
+
+const warmStartFile = "adalanche.warmstart.lz4.msgp"
+
+// Changes when what is in the file changes
+const warmStartFormat = 4
+
+const warmStartMaxAge = 24 * time.Hour
+
+var WarmStart bool
+
+var errWarmStartStale = errors.New("warm start file is from other data or settings")
+
+// Everything the analysis depends on, so a warm start file from something else isn't used
+func warmStartKey(domains, datapath string, importall bool) uint64 {
+	h := xxhash.New64()
+	// A new version of adalanche analyzes differently
+	if executable, err := os.Executable(); err == nil {
+		if fi, err := os.Stat(executable); err == nil {
+			fmt.Fprint(h, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	stripped := make([]string, 0, len(strippedAttributes))
+	for attribute := range strippedAttributes {
+		stripped = append(stripped, attribute.String())
+	}
+	sort.Strings(stripped)
+	fmt.Fprint(h, warmStartFormat, strings.ToLower(domains), importall, Tier0Query, EdgeHalfLife, EdgeMaxAge, SQLiteFilter, stripped, metaRulesText)
+	fingerprint := make([]byte, 8)
+	binary.LittleEndian.PutUint64(fingerprint, datasetFingerprint(datapath))
+	h.Write(fingerprint)
+	return h.Sum64()
+}
+
+// Saves the loaded and analyzed data. It's written next to where it goes and renamed when complete
+func writeWarmStart(domains, datapath string, importall bool) error {
+	temp, err := os.CreateTemp(datapath, ".warmstart-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	compressed := lz4.NewWriter(temp)
+	e := msgp.NewWriter(compressed)
+	if err = encodeWarmStart(e, warmStartKey(domains, datapath, importall)); err == nil {
+		if err = e.Flush(); err == nil {
+			err = compressed.Close()
+		}
+	}
+	if closeerr := temp.Close(); err == nil {
+		err = closeerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filepath.Join(datapath, warmStartFile))
+}
+
+func encodeWarmStart(e *msgp.Writer, key uint64) error {
+	e.WriteInt(warmStartFormat)
+	e.WriteUint64(key)
+	e.WriteTime(time.Now())
+
+	// Counted while loading, for the PersonalData and Memory reports
+	var loaded []Attribute
+	for attribute := range attributeobjects {
+		if attributeobjects[attribute] > 0 {
+			loaded = append(loaded, Attribute(attribute))
+		}
+	}
+	e.WriteArrayHeader(uint32(len(loaded)))
+	for _, attribute := range loaded {
+		e.WriteString(attribute.String())
+		e.WriteInt(attributeobjects[attribute])
+		e.WriteInt(attributeredacted[attribute])
+	}
+	e.WriteInt(strippedBytes)
+
+	// The attacker is always there, so it's not saved but is number 0
+	objects := []*Object{AttackerObject}
+	for _, o := range AllObjects.AsArray() {
+		if o != AttackerObject {
+			objects = append(objects, o)
+		}
+	}
+	index := make(map[*Object]int, len(objects))
+	for i, o := range objects {
+		index[o] = i
+	}
+	e.WriteArrayHeader(uint32(len(objects) - 1))
+	for _, o := range objects[1:] {
+		raw := RawObject{
+			DistinguishedName: o.DN(),
+			Attributes:        make(map[string][]string),
+		}
+		for attribute, values := range o.AttributesSnapshot() {
+			raw.Attributes[attribute.String()] = values
+		}
+		if err := raw.EncodeMsg(e); err != nil {
+			return err
+		}
+	}
+
+	var connections int
+	for _, o := range objects {
+		connections += len(o.CanPwnSnapshot())
+	}
+	e.WriteArrayHeader(uint32(connections))
+	for i, o := range objects {
+		for _, pwn := range o.CanPwnSnapshot() {
+			e.WriteInt(i)
+			e.WriteInt(index[pwn.Target])
+			e.WriteUint64(uint64(pwn.Method))
+		}
+	}
+
+	var details int
+	for _, edgedetails := range AllEdgeDetails {
+		details += len(edgedetails)
+	}
+	e.WriteArrayHeader(uint32(details))
+	for pair, edgedetails := range AllEdgeDetails {
+		for _, detail := range edgedetails {
+			e.WriteInt(index[pair.Source])
+			e.WriteInt(index[pair.Target])
+			e.WriteUint64(uint64(detail.Method))
+			e.WriteString(detail.Reason)
+			e.WriteTime(detail.Collected)
+			e.WriteFloat64(detail.Chance)
+		}
+	}
+
+	problemlock.Lock()
+	defer problemlock.Unlock()
+	e.WriteArrayHeader(uint32(len(AllProblems)))
+	for _, problem := range AllProblems {
+		e.WriteString(problem.Stage)
+		e.WriteString(problem.DN)
+		e.WriteString(problem.Error)
+		e.WriteArrayHeader(uint32(len(problem.Raw)))
+		for _, attribute := range problem.Raw {
+			e.WriteString(attribute.Name)
+			e.WriteArrayHeader(uint32(len(attribute.Values)))
+			for _, value := range attribute.Values {
+				e.WriteString(value)
+			}
+		}
+	}
+	return nil
+}
+
+// What readWarmStart read from the file, which install puts in place of what is loaded
+type warmStart struct {
+	written     time.Time
+	attributes  []warmStartAttribute
+	stripped    int
+	objects     []RawObject
+	connections []warmStartConnection
+	details     []warmStartDetail
+	problems    []Problem
+}
+
+type warmStartAttribute struct {
+	name              string
+	objects, redacted int
+}
+
+// Objects are numbered as when they were written, the attacker is 0
+type warmStartConnection struct {
+	source, target int
+	method         PwnMethod
+}
+
+type warmStartDetail struct {
+	warmStartConnection
+	reason    string
+	collected time.Time
+	chance    float64
+}
+
+// Reads what writeWarmStart saved, if it's from the same data and settings. Nothing that is loaded is touched, so a
+// file that can't be read leaves it as it is
+func readWarmStart(domains, datapath string, importall bool) (*warmStart, error) {
+	file, err := os.Open(filepath.Join(datapath, warmStartFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	d := msgp.NewReader(lz4.NewReader(file))
+
+	format, err := d.ReadInt()
+	if err != nil {
+		return nil, err
+	}
+	key, err := d.ReadUint64()
+	if err != nil {
+		return nil, err
+	}
+	ws := &warmStart{}
+	if ws.written, err = d.ReadTime(); err != nil {
+		return nil, err
+	}
+	if format != warmStartFormat || key != warmStartKey(domains, datapath, importall) || time.Since(ws.written) > warmStartMaxAge {
+		return nil, errWarmStartStale
+	}
+
+	count, err := d.ReadArrayHeader()
+	if err != nil {
+		return nil, err
+	}
+	ws.attributes = make([]warmStartAttribute, count)
+	for i := range ws.attributes {
+		attribute := &ws.attributes[i]
+		if attribute.name, err = d.ReadString(); err != nil {
+			return nil, err
+		}
+		if attribute.objects, err = d.ReadInt(); err != nil {
+			return nil, err
+		}
+		if attribute.redacted, err = d.ReadInt(); err != nil {
+			return nil, err
+		}
+	}
+	if ws.stripped, err = d.ReadInt(); err != nil {
+		return nil, err
+	}
+
+	if count, err = d.ReadArrayHeader(); err != nil {
+		return nil, err
+	}
+	ws.objects = make([]RawObject, count)
+	for i := range ws.objects {
+		if err = ws.objects[i].DecodeMsg(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if count, err = d.ReadArrayHeader(); err != nil {
+		return nil, err
+	}
+	ws.connections = make([]warmStartConnection, count)
+	for i := range ws.connections {
+		if ws.connections[i], err = readWarmStartConnection(d, len(ws.objects)); err != nil {
+			return nil, err
+		}
+	}
+
+	if count, err = d.ReadArrayHeader(); err != nil {
+		return nil, err
+	}
+	ws.details = make([]warmStartDetail, count)
+	for i := range ws.details {
+		detail := &ws.details[i]
+		if detail.warmStartConnection, err = readWarmStartConnection(d, len(ws.objects)); err != nil {
+			return nil, err
+		}
+		if detail.reason, err = d.ReadString(); err != nil {
+			return nil, err
+		}
+		if detail.collected, err = d.ReadTime(); err != nil {
+			return nil, err
+		}
+		if detail.chance, err = d.ReadFloat64(); err != nil {
+			return nil, err
+		}
+	}
+
+	if count, err = d.ReadArrayHeader(); err != nil {
+		return nil, err
+	}
+	ws.problems = make([]Problem, count)
+	for i := range ws.problems {
+		problem := &ws.problems[i]
+		if problem.Stage, err = d.ReadString(); err != nil {
+			return nil, err
+		}
+		if problem.DN, err = d.ReadString(); err != nil {
+			return nil, err
+		}
+		if problem.Error, err = d.ReadString(); err != nil {
+			return nil, err
+		}
+		attributes, err := d.ReadArrayHeader()
+		if err != nil {
+			return nil, err
+		}
+		problem.Raw = make([]ProblemAttribute, attributes)
+		for j := range problem.Raw {
+			if problem.Raw[j].Name, err = d.ReadString(); err != nil {
+				return nil, err
+			}
+			values, err := d.ReadArrayHeader()
+			if err != nil {
+				return nil, err
+			}
+			problem.Raw[j].Values = make([]string, values)
+			for k := range problem.Raw[j].Values {
+				if problem.Raw[j].Values[k], err = d.ReadString(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return ws, nil
+}
+
+// Makes what was read the loaded data. Everything must have been reset before
+func (ws *warmStart) install(domains, datapath string) {
+	// The per domain setup LoadDataset does
+	for _, domain := range strings.Split(domains, ",") {
+		if AllObjects.Base == "" {
+			AllObjects.Base = "dc=" + strings.Replace(domain, ".", ",dc=", -1)
+			AllObjects.Domain = domain
+		}
+	}
+
+	for _, loaded := range ws.attributes {
+		attribute := NewAttribute(loaded.name)
+		attributeobjects[attribute], attributeredacted[attribute] = loaded.objects, loaded.redacted
+	}
+	strippedBytes = ws.stripped
+
+	objects := make([]*Object, 1, len(ws.objects)+1)
+	objects[0] = AttackerObject
+	for _, raw := range ws.objects {
+		// Like RawObject.ToObject, without the counting and leaving out as that was done the first time
+		o := NewObject()
+		o.DistinguishedName = raw.DistinguishedName
+		for name, values := range raw.Attributes {
+			attribute := NewAttribute(name)
+			if attribute == NTSecurityDescriptor && len(values) > 0 {
+				if err := o.cacheSecurityDescriptor([]byte(values[0])); err != nil {
+					log.Debug().Msgf("Problem parsing security descriptor on %v: %v", raw.DistinguishedName, err)
+				}
+			} else if attribute <= MAX_DEDUP {
+				for i, value := range values {
+					values[i] = stringdedup.S(value)
+				}
+			}
+			if !o.compressValues(attribute, values) {
+				o.Attributes[attribute] = values
+			}
+		}
+		AllObjects.Add(o)
+		objects = append(objects, o)
+	}
+
+	for _, connection := range ws.connections {
+		objects[connection.source].AddPwn(objects[connection.target], connection.method)
+	}
+	for _, detail := range ws.details {
+		pair := PwnPair{Source: objects[detail.source], Target: objects[detail.target]}
+		AllEdgeDetails[pair] = append(AllEdgeDetails[pair], EdgeDetail{
+			Method:    detail.method,
+			Reason:    detail.reason,
+			Collected: detail.collected,
+			Chance:    detail.chance,
+		})
+	}
+
+	problemlock.Lock()
+	AllProblems = ws.problems
+	problemlock.Unlock()
+
+	// The attacker isn't saved, so it gets what processing set on it again
+	AttackerObject.SetAttr(MetaType, AttackerObject.Type().String())
+
+	// The lookups, which point into the objects
+	LoadSchemaSyntaxes()
+	for _, o := range AllObjects.AsArray() {
+		o.MemberOf()
+		indexSchemaObject(o)
+	}
+	if err := LoadLocalMachines(datapath); err != nil {
+		log.Warn().Msgf("Problem loading local machine data: %v", err)
+	}
+	for _, domain := range strings.Split(domains, ",") {
+		policiespath := filepath.Join(datapath, domain+".sysvol")
+		if _, err := os.Stat(policiespath); err == nil {
+			if err = LoadGPOSettings(policiespath); err != nil {
+				log.Warn().Msgf("Problem loading Group Policy settings from %v: %v", policiespath, err)
+			}
+		}
+	}
+	buildIndexes()
+	UpdateDatasetChecksum()
+
+	log.Info().Msgf("Started from the analysis saved %v, with %v objects and %v connections", ws.written.Format(time.RFC3339), len(objects), len(ws.connections))
+}
+
+func readWarmStartConnection(d *msgp.Reader, objects int) (connection warmStartConnection, err error) {
+	var m uint64
+	if connection.source, err = d.ReadInt(); err != nil {
+		return
+	}
+	if connection.target, err = d.ReadInt(); err != nil {
+		return
+	}
+	if m, err = d.ReadUint64(); err != nil {
+		return
+	}
+	connection.method = PwnMethod(m)
+	// The objects are numbered from the attacker at 0
+	for _, i := range []int{connection.source, connection.target} {
+		if i < 0 || i > objects {
+			return connection, fmt.Errorf("object %v is not in the warm start file", i)
+		}
+	}
+	return connection, nil
+}
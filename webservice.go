@@ -3,12 +3,16 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,52 +24,140 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func webservice(bind string) http.Server {
+// Starts the webservice on bind. If basepath is set (like /adalanche) the UI and API are also served below that,
+// so it can sit behind a reverse proxy with other tools, whether the proxy strips the path or not. With an
+// uploadtoken collectors can upload data files into datapath
+func webservice(bind, basepath, datapath, uploadtoken string) *http.Server {
 	router := mux.NewRouter()
-	srv := http.Server{
+	srv := &http.Server{
 		Addr:    bind,
 		Handler: router,
 	}
+	if basepath != "" {
+		toplevel := http.NewServeMux()
+		toplevel.Handle(basepath+"/", http.StripPrefix(basepath, router))
+		toplevel.Handle(basepath, http.RedirectHandler(basepath+"/", http.StatusMovedPermanently))
+		toplevel.Handle("/", router)
+		srv.Handler = toplevel
+	}
+
+	// Requests wait while the data is being reloaded, except uploads which don't use it and switching to other data
+	// which reloads it
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := mux.CurrentRoute(r); route != nil && (route.GetName() == "upload" || route.GetName() == "dataset") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			datasetLock.RLock()
+			defer datasetLock.RUnlock()
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	assets := webAssets()
+	fileserver := http.FileServer(assets)
+
 	router.HandleFunc("/pwnmethods", func(w http.ResponseWriter, r *http.Request) {
 		type methodinfo struct {
 			Name           string `json:"name"`
 			DefaultEnabled bool   `json:"defaultenabled"`
-			Description    string `json:"description"`
+			PwnMethodHelp
 		}
 		var methods []methodinfo
 
 		for _, method := range PwnMethodValues() {
 			methods = append(methods, methodinfo{
 				Name:           method.String(),
-				DefaultEnabled: !strings.HasPrefix(method.String(), "Create") && !strings.HasPrefix(method.String(), "Delete") && !strings.HasPrefix(method.String(), "Inherits"),
-				// Description:    method.Description(),
+				DefaultEnabled: !strings.HasPrefix(method.String(), "Create") && !strings.HasPrefix(method.String(), "Delete") && !strings.HasPrefix(method.String(), "Inherits") && method != PwnSamePersonHeuristic && method != PwnDomainTrust,
+				PwnMethodHelp:  method.Help(),
 			})
 		}
 
 		mj, _ := json.MarshalIndent(methods, "", "  ")
 		w.Write(mj)
 	})
-	router.HandleFunc("/validatequery", func(w http.ResponseWriter, r *http.Request) {
-		rest, _, err := ParseQuery(r.URL.Query().Get("query"))
+	router.HandleFunc("/locales", func(w http.ResponseWriter, r *http.Request) {
+		lj, _ := json.MarshalIndent(availableLocales(assets), "", "  ")
+		w.Write(lj)
+	})
+	router.HandleFunc("/locale/{language}", func(w http.ResponseWriter, r *http.Request) {
+		translations, err := localeStrings(assets, mux.Vars(r)["language"])
 		if err != nil {
 			w.WriteHeader(400) // bad request
 			w.Write([]byte(err.Error()))
 			return
 		}
-		if rest != "" {
-			if rest[0] != ',' {
-				w.WriteHeader(400) // bad request
-				w.Write([]byte("Expecting comma as a seperator before exclude query"))
-				return
-			}
-			if _, err := ParseQueryStrict(rest[1:]); err != nil {
-				w.WriteHeader(400) // bad request
-				w.Write([]byte(err.Error()))
-				return
-			}
+		lj, _ := json.Marshal(translations)
+		w.Write(lj)
+	})
+	router.HandleFunc("/validatequery", func(w http.ResponseWriter, r *http.Request) {
+		if err := validateQuery(r.URL.Query().Get("query")); err != nil {
+			w.WriteHeader(400) // bad request
+			w.Write([]byte(err.Error()))
+			return
 		}
 		w.Write([]byte("ok"))
 	})
+	router.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		sj, _ := json.MarshalIndent(searchObjects(r.URL.Query().Get("q"), searchLimit), "", "  ")
+		w.Write(sj)
+	})
+	router.HandleFunc("/queries", func(w http.ResponseWriter, r *http.Request) {
+		queries, err := loadSavedQueries(datapath)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		qj, _ := json.MarshalIndent(queries, "", "  ")
+		w.Write(qj)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/queries", func(w http.ResponseWriter, r *http.Request) {
+		var query SavedQuery
+		if err := json.NewDecoder(io.LimitReader(r.Body, 65536)).Decode(&query); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := validateQuery(query.Query); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := saveQuery(datapath, query); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		w.Write([]byte("ok"))
+	}).Methods(http.MethodPost)
+	router.HandleFunc("/queries/{name}", func(w http.ResponseWriter, r *http.Request) {
+		found, err := deleteSavedQuery(datapath, mux.Vars(r)["name"])
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		if !found {
+			http.Error(w, "No saved query with that name", 404)
+			return
+		}
+		w.Write([]byte("ok"))
+	}).Methods(http.MethodDelete)
+	router.HandleFunc("/datasets", func(w http.ResponseWriter, r *http.Request) {
+		var result struct {
+			Loaded    []string `json:"loaded"`
+			Available []string `json:"available"`
+		}
+		result.Loaded = strings.Split(DatasetDomains, ",")
+		result.Available = availableDomains(datapath)
+		dj, _ := json.MarshalIndent(result, "", "  ")
+		w.Write(dj)
+	})
+	// Doesn't wait for the data like the others, as it replaces it
+	router.HandleFunc("/dataset", func(w http.ResponseWriter, r *http.Request) {
+		if err := switchDataset(r.FormValue("domains"), datapath); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		w.Write([]byte("ok"))
+	}).Methods(http.MethodPost).Name("dataset")
 	router.HandleFunc("/details/{locateby}/{id}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		var o *Object
@@ -89,6 +181,10 @@ func webservice(bind string) http.Server {
 				return
 			}
 			o, found = AllObjects.FindGUID(u)
+			if !found {
+				// Perhaps it's in the form AD tools show it
+				o, found = AllObjects.FindGUID(SwapUUIDEndianess(u))
+			}
 		}
 		if !found {
 			w.WriteHeader(404) // bad request
@@ -105,21 +201,38 @@ func webservice(bind string) http.Server {
 		// default format
 
 		type ObjectDetails struct {
-			DistinguishedName string              `json:distinguishedname`
-			Attributes        map[string][]string `json:attributes`
-			CanPwn            map[string][]string `json:can_pwn`
-			PwnableBy         map[string][]string `json:pwnable_by`
+			DistinguishedName string                   `json:"distinguishedname"`
+			Attributes        map[string][]string      `json:"attributes"`
+			Decoded           map[string][]interface{} `json:"decoded,omitempty"`
+			Raw               map[string][]string      `json:"raw,omitempty"` // base64 encoded binary values
+			CanPwn            map[string][]string      `json:"can_pwn"`
+			PwnableBy         map[string][]string      `json:"pwnable_by"`
 		}
 
 		od := ObjectDetails{
 			DistinguishedName: o.DN(),
 			Attributes:        make(map[string][]string),
+			Decoded:           make(map[string][]interface{}),
+			Raw:               make(map[string][]string),
 			CanPwn:            make(map[string][]string),
 			PwnableBy:         make(map[string][]string),
 		}
 
-		for attr, values := range o.Attributes {
-			od.Attributes[attr.String()] = values
+		for attr, values := range o.AttributesSnapshot() {
+			decoder, binary := DecoderFor(attr)
+			if !binary {
+				od.Attributes[attr.String()] = values
+				continue
+			}
+			for _, value := range values {
+				od.Raw[attr.String()] = append(od.Raw[attr.String()], base64.StdEncoding.EncodeToString([]byte(value)))
+				rendered, decoded, err := decoder(value)
+				if err != nil {
+					rendered = fmt.Sprintf("(could not decode: %v)", err)
+				}
+				od.Attributes[attr.String()] = append(od.Attributes[attr.String()], rendered)
+				od.Decoded[attr.String()] = append(od.Decoded[attr.String()], decoded)
+			}
 		}
 
 		if r.FormValue("format") == "json" {
@@ -130,11 +243,11 @@ func webservice(bind string) http.Server {
 			return
 		}
 
-		for _, pwninfo := range o.CanPwn {
+		for _, pwninfo := range o.CanPwnSnapshot() {
 			od.CanPwn[pwninfo.Target.DN()] = append(od.CanPwn[pwninfo.Target.DN()], pwninfo.Method.String())
 		}
 
-		for _, pwninfo := range o.PwnableBy {
+		for _, pwninfo := range o.PwnableBySnapshot() {
 			od.PwnableBy[pwninfo.Target.DN()] = append(od.PwnableBy[pwninfo.Target.DN()], pwninfo.Method.String())
 		}
 		e := qjson.NewEncoder(w)
@@ -171,61 +284,13 @@ func webservice(bind string) http.Server {
 		alldetails, _ := ParseBool(uq.Get("alldetails"))
 		force, _ := ParseBool(uq.Get("force"))
 
-		var includeobjects *Objects
-		var excludeobjects *Objects
-
-		var excludequery Query
-
-		rest, includequery, err := ParseQuery(query)
+		methods := selectedPwnMethods(uq)
+		pg, err := cachedAnalysis(query, methods, mode, maxdepth)
 		if err != nil {
 			w.WriteHeader(400) // bad request
-			w.Write([]byte(err.Error()))
+			encoder.Encode(fmt.Sprintf("Error parsing ldap query: %v", err))
 			return
 		}
-		if rest != "" {
-			if rest[0] != ',' {
-				w.WriteHeader(400) // bad request
-				encoder.Encode(fmt.Sprintf("Error parsing ldap query: %v", err))
-				return
-			}
-			if excludequery, err = ParseQueryStrict(rest[1:]); err != nil {
-				w.WriteHeader(400) // bad request
-				encoder.Encode(fmt.Sprintf("Error parsing ldap query: %v", err))
-				return
-			}
-		}
-
-		includeobjects = AllObjects.Filter(func(o *Object) bool {
-			// Domain Admins and Enterprise Admins groups
-			return includequery.Evaluate(o)
-		})
-
-		if excludequery != nil {
-			excludeobjects = AllObjects.Filter(func(o *Object) bool {
-				// Domain Admins and Enterprise Admins groups
-				return excludequery.Evaluate(o)
-			})
-		}
-
-		var selectedmethods []PwnMethod
-		for potentialmethod, values := range uq {
-			if method, ok := PwnMethodString(potentialmethod); ok == nil {
-				enabled, _ := ParseBool(values[0])
-				if len(values) == 1 && enabled {
-					selectedmethods = append(selectedmethods, method)
-				}
-			}
-		}
-		// If everything is deselected, select everything
-		if len(selectedmethods) == 0 {
-			selectedmethods = PwnMethodValues()
-		}
-
-		var methods PwnMethod
-		for _, m := range selectedmethods {
-			methods |= m
-		}
-		pg := AnalyzeObjects(includeobjects, excludeobjects, methods, mode, maxdepth)
 
 		targetmap := make(map[*Object]bool)
 		for _, target := range pg.Targets {
@@ -328,61 +393,16 @@ func webservice(bind string) http.Server {
 			alldetails = true
 		}
 
-		var includeobjects *Objects
-		var excludeobjects *Objects
-
-		var excludequery Query
+		aggregate, _ := ParseBool(uq.Get("aggregate"))
 
-		rest, includequery, err := ParseQuery(r.URL.Query().Get("query"))
+		methods := selectedPwnMethods(uq)
+		pg, err := cachedAnalysis(query, methods, mode, maxdepth)
 		if err != nil {
 			w.WriteHeader(400) // bad request
-			w.Write([]byte(err.Error()))
+			fmt.Fprintf(w, "Error parsing ldap query: %v", err)
 			return
 		}
-		if rest != "" {
-			if rest[0] != ',' {
-				w.WriteHeader(400) // bad request
-				fmt.Fprintf(w, "Error parsing ldap query: %v", err)
-				return
-			}
-			if excludequery, err = ParseQueryStrict(rest[1:]); err != nil {
-				w.WriteHeader(400) // bad request
-				fmt.Fprintf(w, "Error parsing ldap query: %v", err)
-				return
-			}
-		}
-
-		includeobjects = AllObjects.Filter(func(o *Object) bool {
-			// Domain Admins and Enterprise Admins groups
-			return includequery.Evaluate(o)
-		})
-
-		if excludequery != nil {
-			excludeobjects = AllObjects.Filter(func(o *Object) bool {
-				// Domain Admins and Enterprise Admins groups
-				return excludequery.Evaluate(o)
-			})
-		}
-
-		var selectedmethods []PwnMethod
-		for potentialmethod, values := range uq {
-			if method, ok := PwnMethodString(potentialmethod); ok == nil {
-				enabled, _ := ParseBool(values[0])
-				if len(values) == 1 && enabled {
-					selectedmethods = append(selectedmethods, method)
-				}
-			}
-		}
-		// If everything is deselected, select everything
-		if len(selectedmethods) == 0 {
-			selectedmethods = PwnMethodValues()
-		}
-
-		var methods PwnMethod
-		for _, m := range selectedmethods {
-			methods |= m
-		}
-		pg := AnalyzeObjects(includeobjects, excludeobjects, methods, mode, maxdepth)
+		edges := ExportEdges(pg, aggregate)
 
 		idmap := make(map[*Object]int)
 		var id int
@@ -404,6 +424,12 @@ func webservice(bind string) http.Server {
 			filename += ".gml"
 		case "xgmml":
 			filename += ".xgmml"
+		case "mermaid":
+			filename += ".mmd"
+		case "drawio":
+			filename += ".drawio"
+		case "opengraph":
+			filename += ".json"
 		}
 
 		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
@@ -423,7 +449,7 @@ func webservice(bind string) http.Server {
 `, id, node.Label(), node.DN())
 
 				if alldetails {
-					for attribute, values := range node.Attributes {
+					for attribute, values := range node.AttributesSnapshot() {
 						valuesjoined := strings.Join(values, ", ")
 						if IsASCII(valuesjoined) {
 							fmt.Fprintf(w, "  %v %v\n", attribute, valuesjoined)
@@ -433,15 +459,18 @@ func webservice(bind string) http.Server {
 				fmt.Fprintf(w, "  ]\n")
 			}
 
-			for _, pwn := range pg.Connections {
+			for _, edge := range edges {
 				fmt.Fprintf(w,
 					`  edge
   [
     source %v
     target %v
 	label "%v"
-  ]
-`, idmap[pwn.Source], idmap[pwn.Target], methods.JoinedString())
+`, idmap[edge.Source], idmap[edge.Target], edge.Label())
+				if aggregate {
+					fmt.Fprintf(w, "    count %v\n", edge.Count)
+				}
+				fmt.Fprintf(w, "  ]\n")
 			}
 			targetmap := make(map[*Object]bool)
 			for _, target := range pg.Targets {
@@ -462,7 +491,7 @@ func webservice(bind string) http.Server {
 				}
 
 				if alldetails {
-					for attribute, values := range object.Attributes {
+					for attribute, values := range object.AttributesSnapshot() {
 						valuesjoined := strings.Join(values, ", ")
 						if IsASCII(valuesjoined) {
 							node.Attributes = append(node.Attributes, XGMMLAttribute{
@@ -475,20 +504,164 @@ func webservice(bind string) http.Server {
 				graph.Nodes = append(graph.Nodes, node)
 			}
 
-			for _, pwn := range pg.Connections {
-				graph.Edges = append(graph.Edges, XGMMLEdge{
-					Source: idmap[pwn.Source],
-					Target: idmap[pwn.Target],
-					Label:  pwn.Methods.JoinedString(),
-				})
+			for _, edge := range edges {
+				xedge := XGMMLEdge{
+					Source: idmap[edge.Source],
+					Target: idmap[edge.Target],
+					Label:  edge.Label(),
+				}
+				if aggregate {
+					xedge.Attributes = append(xedge.Attributes, XGMMLAttribute{Name: "count", Value: strconv.Itoa(edge.Count)})
+					if edge.ReverseMethods != 0 {
+						xedge.Attributes = append(xedge.Attributes, XGMMLAttribute{Name: "bidirectional", Value: "true"})
+					}
+				}
+				graph.Edges = append(graph.Edges, xedge)
 			}
 			fmt.Fprint(w, xml.Header)
 			xe := xml.NewEncoder(w)
 			xe.Indent("", "  ")
 			xe.Encode(graph)
+		case "mermaid":
+			WriteMermaid(w, pg, aggregate)
+		case "drawio":
+			WriteDrawIO(w, pg, query, aggregate)
+		case "opengraph":
+			WriteOpenGraph(w, pg)
 		}
 	})
 
+	// Runs the same analysis with two different queries, and returns what only one of them found
+	router.HandleFunc("/diff", func(w http.ResponseWriter, r *http.Request) {
+		uq := r.URL.Query()
+		encoder := qjson.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		mode := uq.Get("mode")
+		if mode == "" {
+			mode = "normal"
+		}
+
+		maxdepth := 99
+		if maxdepthval, err := strconv.Atoi(uq.Get("maxdepth")); err == nil {
+			maxdepth = maxdepthval
+		}
+
+		methods := selectedPwnMethods(uq)
+
+		var graphs [2]PwnGraph
+		for i, query := range []string{uq.Get("query"), uq.Get("comparequery")} {
+			pg, err := cachedAnalysis(query, methods, mode, maxdepth)
+			if err != nil {
+				w.WriteHeader(400) // bad request
+				encoder.Encode(fmt.Sprintf("Error parsing ldap query %v: %v", query, err))
+				return
+			}
+			graphs[i] = pg
+		}
+
+		type diffconnection struct {
+			Source  string   `json:"source"`
+			Target  string   `json:"target"`
+			Methods []string `json:"methods"`
+		}
+		type diffside struct {
+			Objects     []string         `json:"objects"`
+			Connections []diffconnection `json:"connections"`
+		}
+
+		// Index everything by DN, so the result is stable even if objects have been reloaded
+		var objects [2]map[string]struct{}
+		var connections [2]map[[2]string]PwnMethod
+		for i, pg := range graphs {
+			objects[i] = make(map[string]struct{})
+			for _, o := range pg.Implicated {
+				objects[i][o.DN()] = struct{}{}
+			}
+			connections[i] = make(map[[2]string]PwnMethod)
+			for _, pc := range pg.Connections {
+				connections[i][[2]string{pc.Source.DN(), pc.Target.DN()}] |= pc.Methods
+			}
+		}
+
+		var result struct {
+			OnlyInQuery   diffside `json:"onlyinquery"`
+			OnlyInCompare diffside `json:"onlyincompare"`
+		}
+		for i, side := range []*diffside{&result.OnlyInQuery, &result.OnlyInCompare} {
+			other := 1 - i
+			for dn := range objects[i] {
+				if _, found := objects[other][dn]; !found {
+					side.Objects = append(side.Objects, dn)
+				}
+			}
+			sort.Strings(side.Objects)
+			for pair, methods := range connections[i] {
+				// Methods on a connection that the other side doesn't have count as a difference too
+				if missing := methods &^ connections[other][pair]; missing != 0 {
+					side.Connections = append(side.Connections, diffconnection{
+						Source:  pair[0],
+						Target:  pair[1],
+						Methods: missing.StringSlice(),
+					})
+				}
+			}
+			sort.Slice(side.Connections, func(a, b int) bool {
+				if side.Connections[a].Source == side.Connections[b].Source {
+					return side.Connections[a].Target < side.Connections[b].Target
+				}
+				return side.Connections[a].Source < side.Connections[b].Source
+			})
+		}
+
+		err := encoder.Encode(result)
+		if err != nil {
+			w.WriteHeader(500)
+			encoder.Encode("Error during JSON encoding")
+		}
+	})
+
+	router.HandleFunc("/reports", func(w http.ResponseWriter, r *http.Request) {
+		type reportinfo struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}
+		var reports []reportinfo
+		for _, report := range Reports {
+			reports = append(reports, reportinfo{report.Name, report.Description})
+		}
+		data, _ := json.MarshalIndent(reports, "", "  ")
+		w.Write(data)
+	})
+	router.HandleFunc("/problems", func(w http.ResponseWriter, r *http.Request) {
+		problemlock.Lock()
+		data, err := json.MarshalIndent(AllProblems, "", "  ")
+		problemlock.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Write(data)
+	})
+	router.HandleFunc("/report/{name}", func(w http.ResponseWriter, r *http.Request) {
+		report, found := FindReport(mux.Vars(r)["name"])
+		if !found {
+			// Static report pages live here too
+			fileserver.ServeHTTP(w, r)
+			return
+		}
+		var result interface{} = report.Generate()
+		if groupby := r.URL.Query().Get("groupby"); groupby != "" {
+			result = GroupFindings(result.([]Finding), A(groupby))
+		}
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Write(data)
+	})
+
 	router.HandleFunc("/query/objects/{query}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		query := vars["query"]
@@ -655,26 +828,22 @@ func webservice(bind string) http.Server {
 
 		var pwnlinks int
 		for _, object := range AllObjects.AsArray() {
-			pwnlinks += len(object.CanPwn)
+			pwnlinks += len(object.CanPwnSnapshot())
 		}
 		result.Statistics["Total"] = len(AllObjects.AsArray())
 		result.Statistics["PwnConnections"] = pwnlinks
+		result.Statistics["CachedResults"], result.Statistics["CacheHits"], result.Statistics["CacheMisses"] = analysisCache.Statistics()
+		result.Statistics["DatasetVersion"] = DatasetVersion
 
 		data, _ := json.MarshalIndent(result, "", "  ")
 		w.Write(data)
 	})
 	// Shutdown
 	router.HandleFunc("/quit", func(w http.ResponseWriter, r *http.Request) {
-		ctx, _ := context.WithTimeout(nil, time.Second*15)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+		defer cancel()
 		srv.Shutdown(ctx)
 	})
-	// Serve embedded static files, or from html folder if it exists
-	var assets http.FileSystem
-	assets = assetFS()
-	if _, err := os.Stat("html"); !os.IsNotExist(err) {
-		// Use local files if they exist
-		assets = http.Dir("html")
-	}
 
 	// Rendered markdown file
 	router.HandleFunc("/readme", func(w http.ResponseWriter, r *http.Request) {
@@ -683,9 +852,90 @@ func webservice(bind string) http.Server {
 		io.Copy(&readmedata, readmefile)
 		w.Write(markdown.ToHTML(readmedata.Bytes(), nil, nil))
 	})
-	router.PathPrefix("/").Handler(http.FileServer(assets))
+	if uploadtoken != "" {
+		router.HandleFunc("/upload/{filename}", uploadHandler(datapath, uploadtoken)).Methods(http.MethodPut).Name("upload")
+	}
+	router.PathPrefix("/").Handler(fileserver)
 
 	log.Debug().Msgf("Listening - navigate to %v ...", bind)
 
 	return srv
 }
+
+// Embedded static files, or from html folder if it exists
+func webAssets() http.FileSystem {
+	if _, err := os.Stat("html"); !os.IsNotExist(err) {
+		// Use local files if they exist
+		return http.Dir("html")
+	}
+	return assetFS()
+}
+
+// Checks an "includequery,excludequery" string without running it
+func validateQuery(query string) error {
+	rest, _, err := ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	if rest != "" {
+		if rest[0] != ',' {
+			return errors.New("Expecting comma as a seperator before exclude query")
+		}
+		if _, err := ParseQueryStrict(rest[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses an "includequery,excludequery" string (exclude is optional) and returns the matching objects
+func queryObjects(query string) (includeobjects, excludeobjects *Objects, err error) {
+	if query == "" {
+		query = "(&(objectClass=group)(|(name=Domain Admins)(name=Enterprise Admins)))"
+	}
+
+	var excludequery Query
+	rest, includequery, err := ParseQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rest != "" {
+		if rest[0] != ',' {
+			return nil, nil, errors.New("Expecting comma as a seperator before exclude query")
+		}
+		if excludequery, err = ParseQueryStrict(rest[1:]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	includeobjects = AllObjects.Filter(func(o *Object) bool {
+		return includequery.Evaluate(o)
+	})
+
+	if excludequery != nil {
+		excludeobjects = AllObjects.Filter(func(o *Object) bool {
+			return excludequery.Evaluate(o)
+		})
+	}
+	return
+}
+
+// Returns the pwn methods enabled in the request, or all of them if none are selected
+func selectedPwnMethods(uq url.Values) PwnMethod {
+	var methods PwnMethod
+	for potentialmethod, values := range uq {
+		if method, ok := PwnMethodString(potentialmethod); ok == nil {
+			enabled, _ := ParseBool(values[0])
+			if len(values) == 1 && enabled {
+				methods |= method
+			}
+		}
+	}
+	// If everything is deselected, select everything
+	if methods == 0 {
+		for _, method := range PwnMethodValues() {
+			methods |= method
+		}
+	}
+	return methods
+}